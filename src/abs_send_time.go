@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// abs_send_time.go - 用 abs-send-time RTP 头部扩展在码流中携带发送时间
+//
+// 说明：
+//   - client 的端到端延迟原本依赖 server 写出的 start_time.txt 与 frame_metadata.csv，两端不共享目录（不同主机）时没有延迟，
+//     只能退化为帧间隔；abs-send-time 把发送时间放进每个视频包，client 不需要任何文件就能得到延迟
+//   - 实验 server / client 的 newWebRTCAPI 总是注册该扩展（视频），两端都注册时协商生效；server 在 interceptor 链的最内层
+//     给每个视频包（含 NACK 重传）写入发出时刻，client 按包读取
+//   - 扩展值是 NTP 时间的 24 位 6.18 定点数（约 3.8µs 精度、64 秒回绕），client 用到达时间补全高位，
+//     所以两端时钟的偏差必须在 ±32 秒以内；延迟的准确度取决于两端的时钟同步（NTP / PTP），可以配合 -clock-drift 修正漂移
+//   - 有 frame_metadata 时仍然优先用它（latency_source 为 e2e，从编码前开始计时）；没有时用 abs-send-time
+//     （latency_source 为 abs_send_time，从帧的第一个包发出开始计时，不含编码耗时）
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// absSendTimeWrap 是 abs-send-time 的回绕周期（24 位，单位 2^-18 秒）
+const absSendTimeWrap = 64 * time.Second
+
+// absSendTimeIDKey 是接收方向的 interceptor 放入 Attributes 的键，值为协商到的扩展 ID（uint8）
+type absSendTimeIDKey struct{}
+
+// registerAbsSendTime 在 mediaEngine 上为视频注册 abs-send-time 扩展，并注册读写它的 interceptor
+func registerAbsSendTime(mediaEngine *webrtc.MediaEngine, registry *interceptor.Registry) error {
+	if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.ABSSendTimeURI}, webrtc.RTPCodecTypeVideo); err != nil {
+		return err
+	}
+	registry.Add(&absSendTimeInterceptorFactory{})
+	return nil
+}
+
+// absSendTimeInterceptorFactory 创建读写 abs-send-time 的 interceptor
+type absSendTimeInterceptorFactory struct{}
+
+// NewInterceptor 实现 interceptor.Factory
+func (f *absSendTimeInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &absSendTimeInterceptor{}, nil
+}
+
+// absSendTimeInterceptor 发送方向给视频包写入发出时刻，接收方向在 Attributes 中标明扩展 ID
+type absSendTimeInterceptor struct {
+	interceptor.NoOp
+}
+
+// absSendTimeExtensionIDOf 返回流协商到的 abs-send-time 扩展 ID；不是视频流或未协商时返回 0
+func absSendTimeExtensionIDOf(info *interceptor.StreamInfo) uint8 {
+	if !strings.HasPrefix(strings.ToLower(info.MimeType), "video/") {
+		return 0
+	}
+	for _, ext := range info.RTPHeaderExtensions {
+		if ext.URI == sdp.ABSSendTimeURI {
+			return uint8(ext.ID)
+		}
+	}
+	return 0
+}
+
+// BindLocalStream 在视频包发出前写入当前时间
+func (i *absSendTimeInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	id := absSendTimeExtensionIDOf(info)
+	if id == 0 {
+		return writer
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if ext, err := rtp.NewAbsSendTimeExtension(time.Now()).Marshal(); err == nil {
+			_ = header.SetExtension(id, ext)
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// BindRemoteStream 把扩展 ID 放进每个包的 Attributes，由 TrackRemote.ReadRTP 返回给 client 的读取循环
+func (i *absSendTimeInterceptor) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	id := absSendTimeExtensionIDOf(info)
+	if id == 0 {
+		return reader
+	}
+	return interceptor.RTPReaderFunc(func(b []byte, attributes interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attributes, err := reader.Read(b, attributes)
+		if err != nil {
+			return n, attributes, err
+		}
+		if attributes == nil {
+			attributes = make(interceptor.Attributes)
+		}
+		attributes.Set(absSendTimeIDKey{}, id)
+		return n, attributes, nil
+	})
+}
+
+// absSendTimeExtensionID 从 ReadRTP 返回的 Attributes 中取出 abs-send-time 的扩展 ID；没有协商时返回 false
+func absSendTimeExtensionID(attributes interceptor.Attributes) (uint8, bool) {
+	if attributes == nil {
+		return 0, false
+	}
+	id, ok := attributes.Get(absSendTimeIDKey{}).(uint8)
+	return id, ok && id != 0
+}
+
+// absSendTime 读取包的 abs-send-time 并用到达时间补全为完整的发送时间；包中没有该扩展时返回 false。
+// 结果与到达时间的差在 ±32 秒之内：client 时钟比 server 慢时得到负的延迟，而不是接近 64 秒的延迟
+func absSendTime(pkt *rtp.Packet, id uint8, arrival time.Time) (time.Time, bool) {
+	if id == 0 {
+		return time.Time{}, false
+	}
+	raw := pkt.GetExtension(id)
+	if raw == nil {
+		return time.Time{}, false
+	}
+	var ext rtp.AbsSendTimeExtension
+	if err := ext.Unmarshal(raw); err != nil {
+		return time.Time{}, false
+	}
+	sent := ext.Estimate(arrival)
+	if arrival.Sub(sent) > absSendTimeWrap/2 {
+		sent = sent.Add(absSendTimeWrap)
+	}
+	return sent, true
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// audio_clock.go - 按采样数计算音频的 PTS 与时长（audio_resample.go 使用，不依赖 FFmpeg）
+package main
+
+import "time"
+
+// opusSampleRate 是 Opus 在 WebRTC 中使用的采样率（RTP 时钟同样为 48kHz）
+const opusSampleRate = 48000
+
+// audioSampleClock 根据采样数生成连续的 PTS，避免逐帧换算带来的漂移
+type audioSampleClock struct {
+	started  bool
+	startPTS int64 // 输出时间基（1/sampleRate）下的起始 PTS
+	samples  int64 // 已输出的采样数
+}
+
+// Next 返回长度为 nbSamples 的下一帧的 PTS；firstPTS 只在第一次调用时使用（已换算到 1/sampleRate）
+func (c *audioSampleClock) Next(firstPTS int64, nbSamples int) int64 {
+	if !c.started {
+		c.startPTS = firstPTS
+		c.started = true
+	}
+	pts := c.startPTS + c.samples
+	c.samples += int64(nbSamples)
+	return pts
+}
+
+// samplesDuration 返回 rate 采样率下 samples 个采样的时长（向下取整到纳秒）
+func samplesDuration(samples int64, rate int) time.Duration {
+	return time.Duration(samples) * time.Second / time.Duration(rate)
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAudioSampleClock44100To48000(t *testing.T) {
+	// 10 秒左右的 44.1kHz AAC（每帧 1024 个采样），第一帧的 PTS 为 2048（1/44100），换算到 1/48000 为 2229
+	const (
+		inRate      = 44100
+		inFrames    = 431
+		inFrameSize = 1024
+		firstPTS    = 2048 * opusSampleRate / inRate
+		outFrame    = 960 // Opus 20ms
+	)
+	inSamples := int64(inFrames * inFrameSize)
+	// aresample 输出 inSamples·48000/44100 个采样，asetnsamples 把最后不足一帧的部分补零
+	resampled := (inSamples*opusSampleRate + inRate - 1) / inRate
+	outFrames := int((resampled + outFrame - 1) / outFrame)
+
+	var clock audioSampleClock
+	for i := 0; i < outFrames; i++ {
+		pts := clock.Next(firstPTS, outFrame)
+		if want := int64(firstPTS + i*outFrame); pts != want {
+			t.Fatalf("frame %d PTS = %d, want %d", i, pts, want)
+		}
+	}
+
+	in := samplesDuration(inSamples, inRate)
+	out := samplesDuration(clock.samples, opusSampleRate)
+	if out != time.Duration(outFrames)*20*time.Millisecond {
+		t.Errorf("output duration = %v for %d frames of 20ms", out, outFrames)
+	}
+	// 输出只比输入多最后一帧补的零，不会逐帧漂移
+	if diff := out - in; diff < 0 || diff >= 20*time.Millisecond {
+		t.Errorf("output %v vs input %v: difference %v, want [0, 20ms)", out, in, diff)
+	}
+}
+
+func TestAudioSampleClockVariableFrames(t *testing.T) {
+	// 不重新分帧时 aresample 的输出长度不固定：PTS 仍是第一帧的 PTS 加上之前输出的采样数
+	var clock audioSampleClock
+	sizes := []int{1114, 1115, 1114, 1115, 1115}
+	want := int64(4800)
+	for i, size := range sizes {
+		// firstPTS 只在第一次调用时使用
+		if pts := clock.Next(4800+int64(i)*1000, size); pts != want {
+			t.Fatalf("frame %d PTS = %d, want %d", i, pts, want)
+		}
+		want += int64(size)
+	}
+	if clock.samples != 5573 {
+		t.Errorf("samples = %d, want 5573", clock.samples)
+	}
+}
+
+func TestSamplesDuration(t *testing.T) {
+	tests := []struct {
+		samples int64
+		rate    int
+		want    time.Duration
+	}{
+		{samples: 1024, rate: 44100, want: 23219954 * time.Nanosecond},
+		{samples: 44100, rate: 44100, want: time.Second},
+		{samples: 960, rate: 48000, want: 20 * time.Millisecond},
+		{samples: 48000 * 86400, rate: 48000, want: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := samplesDuration(tt.samples, tt.rate); got != tt.want {
+			t.Errorf("samplesDuration(%d, %d) = %v, want %v", tt.samples, tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// audio_resample.go - 音频重采样到 Opus 的 48kHz（供音频转码路径使用）
+//
+// 说明：
+//   - Opus 在 WebRTC 中固定使用 48kHz 时钟，44.1kHz 等源必须先重采样
+//   - astiav v0.19 没有封装 libswresample，这里用 FFmpeg 滤镜图 abuffer → aresample → aformat → asetnsamples → abuffersink
+//   - 输出 PTS 不逐帧换算（逐帧 RescaleQ 会累积舍入误差导致音频漂移），
+//     而是 "第一帧的起始时间 + 已输出的采样数"，单位为 1/48000（见 audio_clock.go）
+//   - InputDuration / OutputDuration 可用于在运行时检查输入输出时长是否一致
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// AudioResampler 把解码后的音频帧转换为指定采样格式/声道布局的 48kHz 定长帧
+type AudioResampler struct {
+	graph *astiav.FilterGraph
+	src   *astiav.FilterContext
+	sink  *astiav.FilterContext
+	frame *astiav.Frame
+
+	inTimeBase astiav.Rational
+	inRate     int
+	inSamples  int64
+	firstInPTS int64
+	hasFirst   bool
+
+	clock audioSampleClock
+}
+
+// NewAudioResampler 创建重采样器。
+// inTimeBase 是输入帧 PTS 的时间基；frameSize 是每个输出帧的采样数（Opus 20ms 为 960），0 表示不重新分帧。
+func NewAudioResampler(inRate int, inFormat astiav.SampleFormat, inLayout astiav.ChannelLayout, inTimeBase astiav.Rational,
+	outFormat astiav.SampleFormat, outLayout astiav.ChannelLayout, frameSize int) (*AudioResampler, error) {
+	if inRate <= 0 {
+		return nil, fmt.Errorf("invalid input sample rate %d", inRate)
+	}
+
+	r := &AudioResampler{
+		graph:      astiav.AllocFilterGraph(),
+		frame:      astiav.AllocFrame(),
+		inTimeBase: inTimeBase,
+		inRate:     inRate,
+	}
+	if r.graph == nil {
+		r.Free()
+		return nil, errors.New("failed to allocate filter graph")
+	}
+
+	var err error
+	if r.src, err = r.graph.NewFilterContext(astiav.FindFilterByName("abuffer"), "in", astiav.FilterArgs{
+		"channel_layout": inLayout.String(),
+		"sample_fmt":     inFormat.Name(),
+		"sample_rate":    strconv.Itoa(inRate),
+		"time_base":      inTimeBase.String(),
+	}); err != nil {
+		r.Free()
+		return nil, fmt.Errorf("failed to create abuffer: %w", err)
+	}
+	if r.sink, err = r.graph.NewFilterContext(astiav.FindFilterByName("abuffersink"), "out", nil); err != nil {
+		r.Free()
+		return nil, fmt.Errorf("failed to create abuffersink: %w", err)
+	}
+
+	chain := fmt.Sprintf("aresample=%d,aformat=sample_fmts=%s:channel_layouts=%s",
+		opusSampleRate, outFormat.Name(), outLayout.String())
+	if frameSize > 0 {
+		// p=1：最后不足一帧的采样补零，保证每帧长度一致
+		chain += fmt.Sprintf(",asetnsamples=n=%d:p=1", frameSize)
+	}
+
+	outputs := astiav.AllocFilterInOut()
+	defer outputs.Free()
+	outputs.SetName("in")
+	outputs.SetFilterContext(r.src)
+	outputs.SetPadIdx(0)
+	outputs.SetNext(nil)
+
+	inputs := astiav.AllocFilterInOut()
+	defer inputs.Free()
+	inputs.SetName("out")
+	inputs.SetFilterContext(r.sink)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	if err = r.graph.Parse(chain, inputs, outputs); err != nil {
+		r.Free()
+		return nil, fmt.Errorf("failed to parse resample filter %q: %w", chain, err)
+	}
+	if err = r.graph.Configure(); err != nil {
+		r.Free()
+		return nil, fmt.Errorf("failed to configure resample filter: %w", err)
+	}
+
+	return r, nil
+}
+
+// Resample 送入一个解码后的音频帧，并把得到的每个 48kHz 输出帧交给 handle。
+// 输出帧的 PTS 已按 1/48000 设置。in 为 nil 表示输入结束，会取出滤镜中剩余的采样。
+// handle 返回错误时停止并原样返回该错误。
+func (r *AudioResampler) Resample(in *astiav.Frame, handle func(out *astiav.Frame) error) error {
+	if in != nil {
+		if !r.hasFirst {
+			r.firstInPTS = in.Pts()
+			r.hasFirst = true
+		}
+		r.inSamples += int64(in.NbSamples())
+	}
+
+	if err := r.src.BuffersrcAddFrame(in, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+		return fmt.Errorf("failed to send frame to resampler: %w", err)
+	}
+
+	for {
+		r.frame.Unref()
+		if err := r.sink.BuffersinkGetFrame(r.frame, astiav.NewBuffersinkFlags()); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				return nil
+			}
+			return fmt.Errorf("failed to receive frame from resampler: %w", err)
+		}
+
+		firstPTS := astiav.RescaleQ(r.firstInPTS, r.inTimeBase, astiav.NewRational(1, opusSampleRate))
+		r.frame.SetPts(r.clock.Next(firstPTS, r.frame.NbSamples()))
+
+		if err := handle(r.frame); err != nil {
+			return err
+		}
+	}
+}
+
+// InputDuration 返回已送入的音频时长
+func (r *AudioResampler) InputDuration() time.Duration {
+	return samplesDuration(r.inSamples, r.inRate)
+}
+
+// OutputDuration 返回已输出的音频时长（包含最后一帧补零部分）
+func (r *AudioResampler) OutputDuration() time.Duration {
+	return samplesDuration(r.clock.samples, opusSampleRate)
+}
+
+// Free 释放滤镜图与帧
+func (r *AudioResampler) Free() {
+	if r.frame != nil {
+		r.frame.Free()
+	}
+	if r.graph != nil {
+		r.graph.Free()
+	}
+}
@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// audio_source.go - 从源文件读取音频，转码为 Opus 后写入音频轨道（基础 server）
+//
+// 说明：
+//   - 之前 Opus 轨道只参与协商、从不发送数据；这里解码源文件的音频流，经 AudioResampler 转为 48kHz 的 20ms 定长帧，编码为 Opus 发送
+//   - 音频使用单独打开的 FormatContext：视频循环按帧率逐包读取，共用一个 demuxer 时音频包会被跳过或占用视频的节拍
+//   - 发送节奏按 Opus 包的 PTS（1/48000）对齐墙钟，与视频同时开始；-loop 时 seek 回开头继续，PTS 由采样数连续累计
+//   - 源文件没有音频流时返回 ErrNoAudioStream，调用方不添加音频轨道
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// fileAudioFrameSamples 是编码器没有给出帧长时使用的 Opus 帧采样数（48kHz 下 20ms）
+const fileAudioFrameSamples = 960
+
+// FileAudioSource 解码源文件的音频流并编码为 Opus
+type FileAudioSource struct {
+	formatContext *astiav.FormatContext
+	stream        *astiav.Stream
+	decCtx        *astiav.CodecContext
+	decPkt        *astiav.Packet
+	decFrame      *astiav.Frame
+	resampler     *AudioResampler
+	encCtx        *astiav.CodecContext
+	encPkt        *astiav.Packet
+
+	loop    bool
+	packets int
+
+	// Start 启动的发送协程，Free 前需要先停止它
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewFileAudioSource 打开 path 中的第一个音频流并创建解码器、重采样器与 Opus 编码器；没有音频流时返回 ErrNoAudioStream
+func NewFileAudioSource(path string, loop bool) (*FileAudioSource, error) {
+	s := &FileAudioSource{loop: loop}
+	if s.formatContext = astiav.AllocFormatContext(); s.formatContext == nil {
+		return nil, errors.New("failed to allocate format context")
+	}
+	if err := s.formatContext.OpenInput(path, nil, nil); err != nil {
+		s.formatContext.Free()
+		s.formatContext = nil
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	if err := s.formatContext.FindStreamInfo(nil); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to find stream info: %w", err)
+	}
+	for _, stream := range s.formatContext.Streams() {
+		if stream.CodecParameters().CodecType() == astiav.MediaTypeAudio {
+			s.stream = stream
+			break
+		}
+	}
+	if s.stream == nil {
+		s.Free()
+		return nil, fmt.Errorf("%w in %s", ErrNoAudioStream, path)
+	}
+
+	decoder := astiav.FindDecoder(s.stream.CodecParameters().CodecID())
+	if decoder == nil {
+		s.Free()
+		return nil, fmt.Errorf("%w: no decoder for audio %s", ErrCodecUnsupported, s.stream.CodecParameters().CodecID())
+	}
+	if s.decCtx = astiav.AllocCodecContext(decoder); s.decCtx == nil {
+		s.Free()
+		return nil, errors.New("failed to allocate audio decoder")
+	}
+	if err := s.stream.CodecParameters().ToCodecContext(s.decCtx); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to copy audio codec parameters: %w", err)
+	}
+	// 与视频解码器相同：时间基取源流的时间基，解码帧的 PTS 直接是源流时间基
+	s.decCtx.SetTimeBase(s.stream.TimeBase())
+	if err := s.decCtx.Open(decoder, nil); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to open audio decoder: %w", err)
+	}
+
+	// 优先使用 libopus；FFmpeg 内置的 opus 编码器仍是实验性的
+	encoder := astiav.FindEncoderByName("libopus")
+	if encoder == nil {
+		encoder = astiav.FindEncoder(astiav.CodecIDOpus)
+	}
+	if encoder == nil {
+		s.Free()
+		return nil, fmt.Errorf("%w: no Opus encoder found", ErrCodecUnsupported)
+	}
+	sampleFormats := encoder.SampleFormats()
+	if len(sampleFormats) == 0 {
+		s.Free()
+		return nil, fmt.Errorf("opus encoder %s reports no sample formats", encoder.Name())
+	}
+	outLayout, bitRate := astiav.ChannelLayoutMono, int64(32000)
+	if s.decCtx.ChannelLayout().Channels() >= 2 {
+		outLayout, bitRate = astiav.ChannelLayoutStereo, 64000
+	}
+	if s.encCtx = astiav.AllocCodecContext(encoder); s.encCtx == nil {
+		s.Free()
+		return nil, errors.New("failed to allocate opus encoder")
+	}
+	s.encCtx.SetSampleRate(opusSampleRate)
+	s.encCtx.SetChannelLayout(outLayout)
+	s.encCtx.SetSampleFormat(sampleFormats[0])
+	s.encCtx.SetTimeBase(astiav.NewRational(1, opusSampleRate))
+	s.encCtx.SetBitRate(bitRate)
+	s.encCtx.SetStrictStdCompliance(astiav.StrictStdComplianceExperimental)
+	if err := s.encCtx.Open(encoder, nil); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to open opus encoder %s: %w", encoder.Name(), err)
+	}
+
+	frameSize := s.encCtx.FrameSize()
+	if frameSize <= 0 {
+		frameSize = fileAudioFrameSamples
+	}
+	var err error
+	if s.resampler, err = NewAudioResampler(s.decCtx.SampleRate(), s.decCtx.SampleFormat(), s.decCtx.ChannelLayout(), s.decCtx.TimeBase(),
+		sampleFormats[0], outLayout, frameSize); err != nil {
+		s.Free()
+		return nil, err
+	}
+
+	s.decPkt = astiav.AllocPacket()
+	s.decFrame = astiav.AllocFrame()
+	s.encPkt = astiav.AllocPacket()
+	fmt.Fprintf(os.Stderr, "Audio: %s %d Hz, %d channel(s) -> Opus %s %d kbps\n", s.stream.CodecParameters().CodecID(),
+		s.decCtx.SampleRate(), s.decCtx.ChannelLayout().Channels(), outLayout, bitRate/1000)
+	return s, nil
+}
+
+// Run 按 PTS 节奏把 Opus 包写入 track，直到源结束（未开启 loop 时）、ctx 结束或写入失败
+func (s *FileAudioSource) Run(ctx context.Context, track audioSampleWriter) error {
+	start := time.Now()
+	encode := func(frame *astiav.Frame) error {
+		if err := s.encCtx.SendFrame(frame); err != nil {
+			reportRecoverableError("Error sending audio frame to encoder", err)
+			return nil
+		}
+		return s.drainEncoder(ctx, track, start)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.decPkt.Unref()
+		if err := s.formatContext.ReadFrame(s.decPkt); err != nil {
+			if !errors.Is(err, astiav.ErrEof) {
+				reportRecoverableError("Error reading audio frame", err)
+				continue
+			}
+			if s.loop {
+				if err = s.formatContext.SeekFrame(s.stream.Index(), 0, astiav.NewSeekFlags(astiav.SeekFlagBackward)); err != nil {
+					return fmt.Errorf("failed to seek audio to beginning: %w", err)
+				}
+				// astiav 没有封装 avcodec_flush_buffers；音频解码器只缓存很少的采样，跨越循环点时直接继续解码
+				continue
+			}
+			// 取出重采样滤镜与编码器中剩余的采样
+			if err = s.resampler.Resample(nil, encode); err != nil {
+				return err
+			}
+			if err = s.encCtx.SendFrame(nil); err != nil && !errors.Is(err, astiav.ErrEof) {
+				reportRecoverableError("Error flushing audio encoder", err)
+				return nil
+			}
+			return s.drainEncoder(ctx, track, start)
+		}
+		if s.decPkt.StreamIndex() != s.stream.Index() {
+			continue
+		}
+
+		if err := s.decCtx.SendPacket(s.decPkt); err != nil {
+			reportRecoverableError("Error sending packet to audio decoder", err)
+			continue
+		}
+		for {
+			if err := s.decCtx.ReceiveFrame(s.decFrame); err != nil {
+				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+					break
+				}
+				reportRecoverableError("Error receiving audio frame", err)
+				break
+			}
+			err := s.resampler.Resample(s.decFrame, encode)
+			s.decFrame.Unref()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// drainEncoder 取出编码器中所有已完成的 Opus 包，等到各自的 PTS 对应的时刻再写入 track
+func (s *FileAudioSource) drainEncoder(ctx context.Context, track audioSampleWriter, start time.Time) error {
+	for {
+		if err := s.encCtx.ReceivePacket(s.encPkt); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				return nil
+			}
+			reportRecoverableError("Error receiving audio packet", err)
+			return nil
+		}
+		duration := time.Duration(s.encPkt.Duration()) * time.Second / opusSampleRate
+		if duration <= 0 {
+			duration = time.Second * fileAudioFrameSamples / opusSampleRate
+		}
+		sendAt := start.Add(time.Duration(s.encPkt.Pts()) * time.Second / opusSampleRate)
+		if wait := time.Until(sendAt); wait > 0 {
+			select {
+			case <-ctx.Done():
+				s.encPkt.Unref()
+				return nil
+			case <-time.After(wait):
+			}
+		}
+		err := track.WriteSample(media.Sample{Data: s.encPkt.Data(), Duration: duration})
+		s.encPkt.Unref()
+		if err != nil {
+			return fmt.Errorf("failed to write audio sample: %w", err)
+		}
+		s.packets++
+	}
+}
+
+// audioSampleWriter 是 Opus 包的写入目标：音频轨道，或 -clients 时的 FanoutTrack
+type audioSampleWriter interface {
+	WriteSample(sample media.Sample) error
+}
+
+// Start 在后台运行 Run，出错时只打印日志（视频继续发送）
+func (s *FileAudioSource) Start(ctx context.Context, track audioSampleWriter) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		if err := s.Run(ctx, track); err != nil {
+			fmt.Fprintf(os.Stderr, "Audio stopped: %v\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Audio finished: %d Opus packets, %v of input resampled to %v\n",
+			s.packets, s.resampler.InputDuration().Round(time.Millisecond), s.resampler.OutputDuration().Round(time.Millisecond))
+	}()
+}
+
+// Free 停止发送协程（如果已启动），然后释放解码器、重采样器、编码器与输入文件
+func (s *FileAudioSource) Free() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	if s.encPkt != nil {
+		s.encPkt.Free()
+	}
+	if s.encCtx != nil {
+		s.encCtx.Free()
+	}
+	if s.resampler != nil {
+		s.resampler.Free()
+	}
+	if s.decFrame != nil {
+		s.decFrame.Free()
+	}
+	if s.decPkt != nil {
+		s.decPkt.Free()
+	}
+	if s.decCtx != nil {
+		s.decCtx.Free()
+	}
+	if s.formatContext != nil {
+		s.formatContext.CloseInput()
+		s.formatContext.Free()
+	}
+}
@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// audio_tone.go - 合成音频源：静音或周期性测试音（-audio silence / -test-tone）
+//
+// 说明：
+//   - 之前 Opus 轨道只参与协商、从不发送数据，A/V skew 指标拿不到音频样本；
+//     这里用 FFmpeg 的源滤镜生成 48kHz 单声道 PCM（anullsrc / aevalsrc），编码为 Opus 后按 20ms 实时节奏写入轨道
+//   - 测试音在音频时间轴上每隔 interval 开始一次，持续 testToneDuration；
+//     视频在相同的媒体时间强制关键帧（见 toneKeyframeScheduler），听到 beep 时应同时看到画面刷新
+//   - 音频与视频在同一时刻开始发送，两条时间轴都从 0 开始，因此对齐只依赖帧率与采样率，不依赖墙钟
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+const (
+	// testToneDuration 是每次 beep 的长度
+	testToneDuration = 100 * time.Millisecond
+	// testToneAmplitude 是测试音的幅度（满幅为 1）
+	testToneAmplitude = 0.5
+	// syntheticAudioFrameSamples 是每个 Opus 帧的采样数（48kHz 下 20ms）
+	syntheticAudioFrameSamples = 960
+)
+
+// ToneConfig 描述测试音；Enabled 为 false 时生成静音
+type ToneConfig struct {
+	Enabled   bool
+	Frequency float64       // Hz
+	Interval  time.Duration // 两次 beep 开始之间的间隔
+}
+
+// Validate 检查测试音参数
+func (c ToneConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Frequency <= 0 || c.Frequency >= opusSampleRate/2 {
+		return fmt.Errorf("tone frequency must be in (0, %d) Hz, got %g", opusSampleRate/2, c.Frequency)
+	}
+	if c.Interval <= testToneDuration {
+		return fmt.Errorf("tone interval must be longer than the %v beep, got %v", testToneDuration, c.Interval)
+	}
+	return nil
+}
+
+// filterSource 返回生成 PCM 的源滤镜描述
+func (c ToneConfig) filterSource() string {
+	if !c.Enabled {
+		return fmt.Sprintf("anullsrc=r=%d:cl=mono:n=%d", opusSampleRate, syntheticAudioFrameSamples)
+	}
+	// 滤镜图中逗号是滤镜分隔符，表达式里的逗号需要转义
+	expr := fmt.Sprintf("if(lt(mod(t\\,%g)\\,%g)\\,%g*sin(2*PI*%g*t)\\,0)",
+		c.Interval.Seconds(), testToneDuration.Seconds(), testToneAmplitude, c.Frequency)
+	return fmt.Sprintf("aevalsrc=exprs=%s:s=%d:c=mono:n=%d", expr, opusSampleRate, syntheticAudioFrameSamples)
+}
+
+// SyntheticAudioSource 生成 PCM、编码为 Opus 并写入音频轨道
+type SyntheticAudioSource struct {
+	graph  *astiav.FilterGraph
+	sink   *astiav.FilterContext
+	frame  *astiav.Frame
+	encCtx *astiav.CodecContext
+	pkt    *astiav.Packet
+
+	frameDuration time.Duration
+
+	// Start 启动的发送协程，Free 前需要先停止它
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSyntheticAudioSource 创建 Opus 编码器与源滤镜图
+func NewSyntheticAudioSource(tone ToneConfig) (*SyntheticAudioSource, error) {
+	// 优先使用 libopus；FFmpeg 内置的 opus 编码器仍是实验性的
+	encoder := astiav.FindEncoderByName("libopus")
+	if encoder == nil {
+		encoder = astiav.FindEncoder(astiav.CodecIDOpus)
+	}
+	if encoder == nil {
+		return nil, fmt.Errorf("%w: no Opus encoder found", ErrCodecUnsupported)
+	}
+	sampleFormats := encoder.SampleFormats()
+	if len(sampleFormats) == 0 {
+		return nil, fmt.Errorf("opus encoder %s reports no sample formats", encoder.Name())
+	}
+
+	s := &SyntheticAudioSource{
+		graph:         astiav.AllocFilterGraph(),
+		frame:         astiav.AllocFrame(),
+		encCtx:        astiav.AllocCodecContext(encoder),
+		pkt:           astiav.AllocPacket(),
+		frameDuration: time.Second * syntheticAudioFrameSamples / opusSampleRate,
+	}
+	if s.graph == nil || s.encCtx == nil {
+		s.Free()
+		return nil, errors.New("failed to allocate audio filter graph or encoder")
+	}
+
+	s.encCtx.SetSampleRate(opusSampleRate)
+	s.encCtx.SetChannelLayout(astiav.ChannelLayoutMono)
+	s.encCtx.SetSampleFormat(sampleFormats[0])
+	s.encCtx.SetTimeBase(astiav.NewRational(1, opusSampleRate))
+	s.encCtx.SetBitRate(32000)
+	s.encCtx.SetStrictStdCompliance(astiav.StrictStdComplianceExperimental)
+	if err := s.encCtx.Open(encoder, nil); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to open opus encoder %s: %w", encoder.Name(), err)
+	}
+
+	var err error
+	if s.sink, err = s.graph.NewFilterContext(astiav.FindFilterByName("abuffersink"), "out", nil); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to create abuffersink: %w", err)
+	}
+
+	// 源滤镜没有输入，只需要把链的输出连接到 sink
+	inputs := astiav.AllocFilterInOut()
+	defer inputs.Free()
+	inputs.SetName("out")
+	inputs.SetFilterContext(s.sink)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	chain := fmt.Sprintf("%s,aformat=sample_fmts=%s:channel_layouts=mono", tone.filterSource(), sampleFormats[0].Name())
+	if err = s.graph.Parse(chain, inputs, nil); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to parse audio source filter %q: %w", chain, err)
+	}
+	if err = s.graph.Configure(); err != nil {
+		s.Free()
+		return nil, fmt.Errorf("failed to configure audio source filter: %w", err)
+	}
+
+	return s, nil
+}
+
+// Run 每 20ms 生成并发送一个 Opus 帧，直到 ctx 结束或写入失败
+func (s *SyntheticAudioSource) Run(ctx context.Context, track *webrtc.TrackLocalStaticSample) error {
+	ticker := time.NewTicker(s.frameDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		s.frame.Unref()
+		if err := s.sink.BuffersinkGetFrame(s.frame, astiav.NewBuffersinkFlags()); err != nil {
+			return fmt.Errorf("failed to generate audio frame: %w", err)
+		}
+		if err := s.encCtx.SendFrame(s.frame); err != nil {
+			reportRecoverableError("Error sending audio frame to encoder", err)
+			continue
+		}
+
+		for {
+			if err := s.encCtx.ReceivePacket(s.pkt); err != nil {
+				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+					break
+				}
+				reportRecoverableError("Error receiving audio packet", err)
+				break
+			}
+			err := track.WriteSample(media.Sample{Data: s.pkt.Data(), Duration: s.frameDuration})
+			s.pkt.Unref()
+			if err != nil {
+				return fmt.Errorf("failed to write audio sample: %w", err)
+			}
+		}
+	}
+}
+
+// Start 在后台运行 Run，出错时只打印日志（视频继续发送）
+func (s *SyntheticAudioSource) Start(ctx context.Context, track *webrtc.TrackLocalStaticSample) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		if err := s.Run(ctx, track); err != nil {
+			fmt.Fprintf(os.Stderr, "Synthetic audio stopped: %v\n", err)
+		}
+	}()
+}
+
+// Free 停止发送协程（如果已启动），然后释放滤镜图、编码器与缓冲
+func (s *SyntheticAudioSource) Free() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	if s.pkt != nil {
+		s.pkt.Free()
+	}
+	if s.encCtx != nil {
+		s.encCtx.Free()
+	}
+	if s.frame != nil {
+		s.frame.Free()
+	}
+	if s.graph != nil {
+		s.graph.Free()
+	}
+}
+
+// toneKeyframeScheduler 判断某个视频帧是否是一次测试音开始后的第一帧，该帧需要强制编码为关键帧
+type toneKeyframeScheduler struct {
+	frameInterval time.Duration
+	toneInterval  time.Duration
+	lastTone      int64
+}
+
+// newToneKeyframeScheduler 按视频帧间隔与 beep 间隔创建调度器
+func newToneKeyframeScheduler(frameInterval, toneInterval time.Duration) *toneKeyframeScheduler {
+	return &toneKeyframeScheduler{frameInterval: frameInterval, toneInterval: toneInterval, lastTone: -1}
+}
+
+// IsToneFrame 判断第 frameIndex 帧（从 0 开始）是否是新一次 beep 开始后的第一帧
+func (s *toneKeyframeScheduler) IsToneFrame(frameIndex int) bool {
+	if s == nil || s.toneInterval <= 0 {
+		return false
+	}
+	tone := int64(time.Duration(frameIndex) * s.frameInterval / s.toneInterval)
+	if tone == s.lastTone {
+		return false
+	}
+	s.lastTone = tone
+	return true
+}
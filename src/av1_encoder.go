@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js && !gcc
+// +build !js,!gcc
+
+// av1_encoder.go - 基础 server 的 AV1 编码器选项与时间层（-av1-temporal-layers）
+//
+// 说明：
+//   - libsvtav1 的 hierarchical-levels 只接受 2-5，N 个时间层对应 hierarchical-levels=N-1（基础层加 N-1 个增强层），
+//     因此可用的层数是 1 与 3-6；2 层无法用 hierarchical-levels 表达，启动时报错
+//   - 1 层时不传 svtav1-params，使用 libsvtav1 的默认预测结构
+//   - 低延迟预测结构（pred-struct=1）与 hierarchical-levels 的组合、以及编码器是否在 OBU 扩展头中写入 temporal_id，
+//     都没有在真实的 libsvtav1 上验证过，可能随 SVT-AV1 版本不同；以 client 结束时的 AV1 temporal layers 统计为准
+//   - 不依赖 FFmpeg，可以单独测试
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// libsvtav1 hierarchical-levels 的取值范围
+const (
+	svtav1MinHierarchicalLevels = 2
+	svtav1MaxHierarchicalLevels = 5
+)
+
+// validAV1TemporalLayers 判断 -av1-temporal-layers 的取值是否能配置：1（单层）或 hierarchical-levels 2-5 对应的 3-6 层
+func validAV1TemporalLayers(temporalLayers int) bool {
+	return temporalLayers == 1 ||
+		(temporalLayers-1 >= svtav1MinHierarchicalLevels && temporalLayers-1 <= svtav1MaxHierarchicalLevels)
+}
+
+// av1EncoderOptions 返回 AV1 编码器的选项。libsvtav1 在 temporalLayers > 1 时请求低延迟预测结构（pred-struct=1）
+// 与 hierarchical-levels=temporalLayers-1，期望高层的帧不被低层参考；temporalLayers 需要先经过 validAV1TemporalLayers 检查。
+// libaom 的 FFmpeg 封装不能配置时间层，只编码单层
+func av1EncoderOptions(encoderName string, temporalLayers int) [][2]string {
+	if encoderName == "libsvtav1" {
+		options := [][2]string{{"preset", "12"}, {"crf", "35"}}
+		if temporalLayers > 1 {
+			options = append(options, [2]string{"svtav1-params", fmt.Sprintf("pred-struct=1:hierarchical-levels=%d", temporalLayers-1)})
+		}
+		return options
+	}
+	if temporalLayers > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: %s cannot be configured with temporal layers, encoding a single layer\n", encoderName)
+	}
+	return [][2]string{{"usage", "realtime"}, {"cpu-used", "8"}, {"lag-in-frames", "0"}, {"crf", "35"}, {"error-resilience", "default"}}
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidAV1TemporalLayers(t *testing.T) {
+	for layers := -1; layers <= 8; layers++ {
+		want := layers == 1 || (layers >= 3 && layers <= 6)
+		if got := validAV1TemporalLayers(layers); got != want {
+			t.Errorf("validAV1TemporalLayers(%d) = %v, want %v", layers, got, want)
+		}
+	}
+}
+
+func TestAV1EncoderOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		encoder        string
+		temporalLayers int
+		want           [][2]string
+	}{
+		{
+			name:           "libsvtav1 single layer has no svtav1-params",
+			encoder:        "libsvtav1",
+			temporalLayers: 1,
+			want:           [][2]string{{"preset", "12"}, {"crf", "35"}},
+		},
+		{
+			name:           "libsvtav1 lowest hierarchy",
+			encoder:        "libsvtav1",
+			temporalLayers: 3,
+			want:           [][2]string{{"preset", "12"}, {"crf", "35"}, {"svtav1-params", "pred-struct=1:hierarchical-levels=2"}},
+		},
+		{
+			name:           "libsvtav1 highest hierarchy",
+			encoder:        "libsvtav1",
+			temporalLayers: 6,
+			want:           [][2]string{{"preset", "12"}, {"crf", "35"}, {"svtav1-params", "pred-struct=1:hierarchical-levels=5"}},
+		},
+		{
+			name:           "libaom ignores temporal layers",
+			encoder:        "libaom-av1",
+			temporalLayers: 3,
+			want:           [][2]string{{"usage", "realtime"}, {"cpu-used", "8"}, {"lag-in-frames", "0"}, {"crf", "35"}, {"error-resilience", "default"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := av1EncoderOptions(tt.encoder, tt.temporalLayers)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("av1EncoderOptions(%q, %d) = %v, want %v", tt.encoder, tt.temporalLayers, got, tt.want)
+			}
+		})
+	}
+}
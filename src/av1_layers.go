@@ -0,0 +1,331 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// av1_layers.go - 统计 AV1 码流中每个时间层（temporal_id）的帧数、帧率、码率与接收端所在的层
+//
+// 说明：
+//   - server -av1-temporal-layers N 请求 libsvtav1 以 N 层分层预测结构编码（见 av1_encoder.go），编码器是否真的分层、
+//     是否在 OBU 扩展头中写 temporal_id 没有保证；client 结束时打印各层的统计，用来确认分层是否生效
+//     （例如 3 层时 T0、T1 各约四分之一，T2 约一半）
+//   - 用一个独立的 AV1Depacketizer 还原 OBU（与 ivfwriter 内部的解包互不影响），每个 RTP 时间戳只统计第一个
+//     OBU_FRAME / OBU_FRAME_HEADER 的 temporal_id；同一时间戳所有包的负载字节计入该帧所在的层
+//   - 帧率与码率按第一个到最后一个包的到达时间计算
+//   - "接收端所在的层" 是每个 av1LayerWindow 窗口内收到帧的最高 temporal_id：解码器在该窗口能按这一层的帧率输出，
+//     丢包或上游丢弃高层时下降；各层作为最高层的时间之和即会话时长，给出接收端在各层之间的时间分布
+//   - 指定 csvPath 时每个窗口按层写一行 av1_layers.csv，汇总（Summary）写入 metrics_summary
+//   - 编码器没有写 OBU 扩展头时（单层编码、libaom-av1）这些帧计为 "no extension"，不代表错误
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/rtp/codecs/av1/obu"
+)
+
+// av1MaxTemporalLayers 是 temporal_id 的取值个数（3 位）
+const av1MaxTemporalLayers = 8
+
+// av1LayerWindow 是 av1_layers.csv 的窗口长度，也是判定接收端所在层的粒度
+const av1LayerWindow = time.Second
+
+// 当前时间戳的帧所在的层：没有扩展头，或还没有解析到帧
+const (
+	av1LayerNoExtension = -1
+	av1LayerUnknown     = -2
+)
+
+// av1LayerCounts 是一段时间内各层的帧数与负载字节数，下标 av1MaxTemporalLayers 为没有扩展头的帧
+type av1LayerCounts struct {
+	frames [av1MaxTemporalLayers + 1]int
+	bytes  [av1MaxTemporalLayers + 1]int64
+}
+
+func (c *av1LayerCounts) add(layer int, frames int, bytes int64) {
+	if layer == av1LayerNoExtension {
+		layer = av1MaxTemporalLayers
+	}
+	c.frames[layer] += frames
+	c.bytes[layer] += bytes
+}
+
+// highest 返回收到帧的最高 temporal_id；只有没有扩展头的帧时为 av1LayerNoExtension，没有帧时为 av1LayerUnknown
+func (c *av1LayerCounts) highest() int {
+	for tid := av1MaxTemporalLayers - 1; tid >= 0; tid-- {
+		if c.frames[tid] > 0 {
+			return tid
+		}
+	}
+	if c.frames[av1MaxTemporalLayers] > 0 {
+		return av1LayerNoExtension
+	}
+	return av1LayerUnknown
+}
+
+// AV1LayerStats 按 temporal_id 统计 AV1 帧；方法对 nil 安全（不是 AV1 轨道时什么都不做）
+type AV1LayerStats struct {
+	depacketizer codecs.AV1Depacketizer
+	total        av1LayerCounts
+	parseErrors  int
+	lastTS       uint32
+	hasTS        bool
+
+	// 当前时间戳（temporal unit）的负载字节与帧所在的层，时间戳变化时计入 total 与当前窗口
+	tuTS    uint32
+	hasTU   bool
+	tuLayer int
+	tuBytes int64
+
+	first, last time.Time
+
+	window      av1LayerCounts
+	windowStart time.Time
+	// consumed 是各层作为窗口内最高层的累计时间，下标 av1MaxTemporalLayers 为只有没有扩展头的帧的窗口
+	consumed [av1MaxTemporalLayers + 1]time.Duration
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewAV1LayerStats 创建时间层统计；csvPath 非空时把逐窗口的统计写入该文件
+func NewAV1LayerStats(csvPath string) (*AV1LayerStats, error) {
+	s := &AV1LayerStats{tuLayer: av1LayerUnknown}
+	if csvPath == "" {
+		return s, nil
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AV1 layer csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"window_end_ms", "temporal_id", "frames", "frame_rate", "bitrate_kbps", "consumed_layer"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write AV1 layer header: %w", err)
+	}
+	w.Flush()
+	s.writer, s.file = w, f
+	return s, nil
+}
+
+// Observe 解析一个在 arrival 到达的 RTP 包中的 OBU，遇到本时间戳的第一帧时按 temporal_id 计数
+func (s *AV1LayerStats) Observe(pkt *rtp.Packet, arrival time.Time) {
+	if s == nil {
+		return
+	}
+	if s.first.IsZero() {
+		s.first, s.windowStart = arrival, arrival
+	}
+	s.last = arrival
+	if !s.hasTU || pkt.Timestamp != s.tuTS {
+		s.commitTU()
+		s.tuTS, s.hasTU = pkt.Timestamp, true
+	}
+	s.tuBytes += int64(len(pkt.Payload))
+	if arrival.Sub(s.windowStart) >= av1LayerWindow {
+		s.flushWindow(arrival)
+	}
+
+	data, err := s.depacketizer.Unmarshal(pkt.Payload)
+	if err != nil {
+		s.parseErrors++
+		return
+	}
+	for len(data) > 0 {
+		header, err := obu.ParseOBUHeader(data)
+		if err != nil {
+			s.parseErrors++
+			return
+		}
+		data = data[header.Size():]
+		size := uint(len(data))
+		if header.HasSizeField {
+			value, n, err := obu.ReadLeb128(data)
+			if err != nil || value > uint(len(data))-n {
+				s.parseErrors++
+				return
+			}
+			size = value
+			data = data[n:]
+		}
+		data = data[size:]
+
+		if header.Type != obu.OBUFrame && header.Type != obu.OBUFrameHeader {
+			continue
+		}
+		if s.hasTS && pkt.Timestamp == s.lastTS {
+			continue
+		}
+		s.lastTS, s.hasTS = pkt.Timestamp, true
+		s.tuLayer = av1LayerNoExtension
+		if header.ExtensionHeader != nil {
+			s.tuLayer = int(header.ExtensionHeader.TemporalID)
+		}
+		s.total.add(s.tuLayer, 1, 0)
+		s.window.add(s.tuLayer, 1, 0)
+	}
+}
+
+// commitTU 把当前时间戳的负载字节计入其帧所在的层；没有解析到帧的时间戳不计入
+func (s *AV1LayerStats) commitTU() {
+	if s.tuLayer != av1LayerUnknown {
+		s.total.add(s.tuLayer, 0, s.tuBytes)
+		s.window.add(s.tuLayer, 0, s.tuBytes)
+	}
+	s.tuLayer, s.tuBytes = av1LayerUnknown, 0
+}
+
+// flushWindow 结束从 windowStart 到 end 的窗口：记录接收端所在的层，并按层写一行 CSV
+func (s *AV1LayerStats) flushWindow(end time.Time) {
+	duration := end.Sub(s.windowStart)
+	consumed := s.window.highest()
+	if consumed != av1LayerUnknown && duration > 0 {
+		slot := consumed
+		if slot == av1LayerNoExtension {
+			slot = av1MaxTemporalLayers
+		}
+		s.consumed[slot] += duration
+	}
+	if s.writer != nil && duration > 0 {
+		for slot, frames := range s.window.frames {
+			if frames == 0 {
+				continue
+			}
+			if err := s.writer.Write([]string{
+				fmt.Sprintf("%d", end.Sub(s.first).Milliseconds()),
+				av1LayerName(slot),
+				fmt.Sprintf("%d", frames),
+				fmt.Sprintf("%.2f", float64(frames)/duration.Seconds()),
+				fmt.Sprintf("%.2f", float64(s.window.bytes[slot])*8/duration.Seconds()/1000),
+				av1LayerName(consumed),
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing AV1 layer CSV: %v\n", err)
+				break
+			}
+		}
+		s.writer.Flush()
+	}
+	s.window, s.windowStart = av1LayerCounts{}, end
+}
+
+// av1LayerName 返回层在 CSV 与日志中的名字：temporal_id，没有扩展头为 none
+func av1LayerName(layer int) string {
+	if layer == av1LayerNoExtension || layer == av1MaxTemporalLayers {
+		return "none"
+	}
+	return fmt.Sprintf("%d", layer)
+}
+
+// Close 计入最后一个时间戳与不完整的窗口，并关闭 CSV 文件；之后 Summary / Report 给出完整的统计
+func (s *AV1LayerStats) Close() {
+	if s == nil {
+		return
+	}
+	s.commitTU()
+	if !s.first.IsZero() {
+		s.flushWindow(s.last)
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.writer, s.file = nil, nil
+	}
+}
+
+// AV1LayerSummary 是 metrics_summary 中的 AV1 时间层统计
+type AV1LayerSummary struct {
+	Frames            int                    `json:"frames"`
+	NoExtensionFrames int                    `json:"no_extension_frames,omitempty"`
+	DurationSeconds   float64                `json:"duration_seconds"`
+	Layers            []AV1LayerSummaryEntry `json:"layers"`
+	// ConsumedLayer 是接收端所在时间最长的层（-1 表示只有没有扩展头的帧）
+	ConsumedLayer int `json:"consumed_layer"`
+	ParseErrors   int `json:"parse_errors,omitempty"`
+}
+
+// AV1LayerSummaryEntry 是一个时间层的统计，只列出收到过帧的层
+type AV1LayerSummaryEntry struct {
+	TemporalID      int     `json:"temporal_id"`
+	Frames          int     `json:"frames"`
+	FrameRate       float64 `json:"frame_rate"`
+	BitrateKbps     float64 `json:"bitrate_kbps"`
+	ConsumedSeconds float64 `json:"consumed_seconds"` // 作为接收端所在层（窗口内最高层）的时间
+	ConsumedShare   float64 `json:"consumed_share"`
+}
+
+// Summary 返回整个会话的统计（先调用 Close），没有帧时返回 nil
+func (s *AV1LayerStats) Summary() *AV1LayerSummary {
+	if s == nil {
+		return nil
+	}
+	summary := &AV1LayerSummary{
+		NoExtensionFrames: s.total.frames[av1MaxTemporalLayers],
+		DurationSeconds:   s.last.Sub(s.first).Seconds(),
+		ConsumedLayer:     av1LayerUnknown,
+		ParseErrors:       s.parseErrors,
+	}
+	var consumedTotal, consumedMax time.Duration
+	for slot, d := range s.consumed {
+		consumedTotal += d
+		if d > consumedMax {
+			consumedMax = d
+			summary.ConsumedLayer = slot
+			if slot == av1MaxTemporalLayers {
+				summary.ConsumedLayer = av1LayerNoExtension
+			}
+		}
+	}
+	for tid := 0; tid < av1MaxTemporalLayers; tid++ {
+		summary.Frames += s.total.frames[tid]
+		if s.total.frames[tid] == 0 {
+			continue
+		}
+		entry := AV1LayerSummaryEntry{
+			TemporalID:      tid,
+			Frames:          s.total.frames[tid],
+			ConsumedSeconds: s.consumed[tid].Seconds(),
+		}
+		if summary.DurationSeconds > 0 {
+			entry.FrameRate = float64(entry.Frames) / summary.DurationSeconds
+			entry.BitrateKbps = float64(s.total.bytes[tid]) * 8 / summary.DurationSeconds / 1000
+		}
+		if consumedTotal > 0 {
+			entry.ConsumedShare = float64(s.consumed[tid]) / float64(consumedTotal)
+		}
+		summary.Layers = append(summary.Layers, entry)
+	}
+	summary.Frames += summary.NoExtensionFrames
+	if summary.Frames == 0 {
+		return nil
+	}
+	return summary
+}
+
+// Report 打印各时间层的统计（先调用 Close）
+func (s *AV1LayerStats) Report() {
+	if s == nil {
+		return
+	}
+	summary := s.Summary()
+	if summary == nil {
+		fmt.Fprintf(os.Stderr, "AV1 temporal layers: no frames observed (%d parse errors)\n", s.parseErrors)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "AV1 temporal layers (%d frames in %.1fs):\n", summary.Frames, summary.DurationSeconds)
+	for _, layer := range summary.Layers {
+		fmt.Fprintf(os.Stderr, "  T%d: %d frames (%.1f%%), %.2f fps, %.1f kbps, highest received layer %.1f%% of the time\n",
+			layer.TemporalID, layer.Frames, float64(layer.Frames)*100/float64(summary.Frames),
+			layer.FrameRate, layer.BitrateKbps, layer.ConsumedShare*100)
+	}
+	if summary.NoExtensionFrames > 0 {
+		fmt.Fprintf(os.Stderr, "  no extension header: %d frames (%.1f%%)\n", summary.NoExtensionFrames, float64(summary.NoExtensionFrames)*100/float64(summary.Frames))
+	}
+	if s.parseErrors > 0 {
+		fmt.Fprintf(os.Stderr, "  parse errors: %d\n", s.parseErrors)
+	}
+}
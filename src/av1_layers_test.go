@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// av1FramePacket 返回一个只含一个 OBU_FRAME 的 RTP 包（W=1，OBU 不带长度字段），
+// tid < 0 时 OBU 没有扩展头；负载共 size 字节
+func av1FramePacket(ts uint32, tid, size int) *rtp.Packet {
+	payload := make([]byte, size)
+	payload[0] = 0x10 // 聚合头 W=1
+	if tid < 0 {
+		payload[1] = 6 << 3 // OBU_FRAME
+	} else {
+		payload[1] = 6<<3 | 0x04
+		payload[2] = byte(tid) << 5
+	}
+	return &rtp.Packet{Header: rtp.Header{Timestamp: ts}, Payload: payload}
+}
+
+func TestAV1LayerStats(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewAV1LayerStats(filepath.Join(dir, "av1_layers.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	const frameInterval = 25 * time.Millisecond
+
+	// 前 2 秒 3 层（T0 T2 T1 T2 循环，40fps），之后 1 秒只剩 T0 与 T1（上游丢弃了 T2）
+	pattern := []int{0, 2, 1, 2}
+	frame := 0
+	for ; frame < 80; frame++ {
+		arrival := start.Add(time.Duration(frame) * frameInterval)
+		ts := uint32(frame * 2250)
+		tid := pattern[frame%4]
+		// T0 帧有第二个包（OBU_PADDING），同一时间戳的字节计入 T0
+		s.Observe(av1FramePacket(ts, tid, 1000), arrival)
+		if tid == 0 {
+			s.Observe(&rtp.Packet{Header: rtp.Header{Timestamp: ts}, Payload: append([]byte{0x10, 15 << 3}, make([]byte, 998)...)}, arrival)
+		}
+	}
+	for ; frame < 120; frame++ {
+		tid := pattern[frame%4]
+		if tid == 2 {
+			continue
+		}
+		s.Observe(av1FramePacket(uint32(frame*2250), tid, 1000), start.Add(time.Duration(frame)*frameInterval))
+	}
+	s.Close()
+
+	summary := s.Summary()
+	if summary == nil {
+		t.Fatal("no summary")
+	}
+	if summary.Frames != 100 || summary.NoExtensionFrames != 0 || summary.ConsumedLayer != 2 {
+		t.Errorf("frames %d, no extension %d, consumed layer %d; want 100, 0, 2", summary.Frames, summary.NoExtensionFrames, summary.ConsumedLayer)
+	}
+	// 第一个包到最后一个包（第 118 帧）
+	duration := 118 * frameInterval.Seconds()
+	want := []struct {
+		frames   int
+		bytes    float64
+		consumed float64 // 作为最高层的秒数
+	}{
+		{30, 20*2000 + 10*1000, 0}, // 最后 1 秒的 T0 帧没有第二个包
+		{30, 30 * 1000, 0.95},      // 第 2 秒的窗口从第 80 帧开始，到最后一个包为止
+		{40, 40 * 1000, 2},
+	}
+	if len(summary.Layers) != len(want) {
+		t.Fatalf("layers %+v, want %d layers", summary.Layers, len(want))
+	}
+	for tid, w := range want {
+		layer := summary.Layers[tid]
+		if layer.TemporalID != tid || layer.Frames != w.frames {
+			t.Errorf("T%d: temporal_id %d, %d frames; want %d frames", tid, layer.TemporalID, layer.Frames, w.frames)
+		}
+		if diff := layer.FrameRate - float64(w.frames)/duration; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("T%d: frame rate %.3f, want %.3f", tid, layer.FrameRate, float64(w.frames)/duration)
+		}
+		if wantKbps := w.bytes * 8 / duration / 1000; layer.BitrateKbps-wantKbps > 1e-9 || wantKbps-layer.BitrateKbps > 1e-9 {
+			t.Errorf("T%d: bitrate %.3f kbps, want %.3f", tid, layer.BitrateKbps, wantKbps)
+		}
+		if diff := layer.ConsumedSeconds - w.consumed; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("T%d: highest layer for %.3fs, want %.3fs", tid, layer.ConsumedSeconds, w.consumed)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "av1_layers.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	wantLines := []string{
+		"window_end_ms,temporal_id,frames,frame_rate,bitrate_kbps,consumed_layer",
+		"1000,0,10,10.00,160.00,2",
+		"1000,1,10,10.00,80.00,2",
+		"1000,2,20,20.00,160.00,2",
+		"2000,0,10,10.00,160.00,2",
+		"2000,1,10,10.00,80.00,2",
+		"2000,2,20,20.00,160.00,2",
+		"2950,0,10,10.53,84.21,1",
+		"2950,1,10,10.53,84.21,1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(wantLines, "\n") {
+		t.Errorf("av1_layers.csv:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(wantLines, "\n"))
+	}
+}
+
+func TestAV1LayerStatsNoExtension(t *testing.T) {
+	s, err := NewAV1LayerStats("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		s.Observe(av1FramePacket(uint32(i*3000), -1, 500), start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	s.Observe(&rtp.Packet{Payload: []byte{0x10}}, start.Add(time.Second))
+	s.Close()
+
+	summary := s.Summary()
+	if summary == nil || summary.Frames != 10 || summary.NoExtensionFrames != 10 || len(summary.Layers) != 0 ||
+		summary.ConsumedLayer != av1LayerNoExtension || summary.ParseErrors != 1 {
+		t.Errorf("summary %+v, want 10 frames without extension headers, consumed layer -1, 1 parse error", summary)
+	}
+
+	var nilStats *AV1LayerStats
+	nilStats.Observe(av1FramePacket(0, 0, 10), start)
+	nilStats.Close()
+	if nilStats.Summary() != nil {
+		t.Error("nil stats have a summary")
+	}
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+//
+// av_sync.go - 接收端音视频同步（A/V skew）测量工具
+//
+// 说明：
+//   - 通过 RTCP Sender Report 建立每个 SSRC 的 RTP 时间戳 → 发送端 NTP 时钟映射
+//   - 对每个到达的 RTP 包计算 "到达时间 - 发送端采集时间"（单流延迟）
+//   - 音频延迟 - 视频延迟 即为接收端的 A/V skew（正值表示音频落后于视频）
+//   - 两端时钟的固定偏移在相减时抵消，因此跨主机也成立
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// ntpEpochOffset 是 NTP 纪元（1900）与 Unix 纪元（1970）之间的秒数
+const ntpEpochOffset = 2208988800
+
+// srMapping 记录某个 SSRC 最近一次 SR 中的 RTP 时间戳与 NTP 时间对应关系
+type srMapping struct {
+	rtpTime uint32
+	ntpTime time.Time
+}
+
+// AVSyncTracker 是一个线程安全的 A/V skew 统计器
+type AVSyncTracker struct {
+	mu sync.Mutex
+
+	mappings map[uint32]srMapping
+
+	// 最近一次视频包的单流延迟（到达时间 - 发送端采集时间）
+	lastVideoDelay time.Duration
+	haveVideoDelay bool
+
+	skewCount  int
+	skewSumMs  float64
+	skewMaxAbs float64
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewAVSyncTracker 创建一个新的 A/V skew 统计器。
+// csvPath 为空时只在内存中统计，不写入逐包 CSV。
+func NewAVSyncTracker(csvPath string) (*AVSyncTracker, error) {
+	t := &AVSyncTracker{
+		mappings: make(map[uint32]srMapping),
+	}
+	if csvPath == "" {
+		return t, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create av sync directory: %w", err)
+	}
+
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create av sync csv: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	header := []string{
+		"arrival_unix_ms",
+		"audio_delay_ms",
+		"video_delay_ms",
+		"skew_ms", // audio_delay - video_delay，正值表示音频落后
+	}
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write av sync header: %w", err)
+	}
+	w.Flush()
+
+	t.writer = w
+	t.file = f
+	return t, nil
+}
+
+// OnSenderReport 记录一条 SR 中的 RTP/NTP 对应关系
+func (t *AVSyncTracker) OnSenderReport(sr *rtcp.SenderReport) {
+	if t == nil || sr == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mappings[sr.SSRC] = srMapping{
+		rtpTime: sr.RTPTime,
+		ntpTime: ntpToTime(sr.NTPTime),
+	}
+}
+
+// OnRTP 处理一个到达的 RTP 包。
+// 在对应 SSRC 收到 SR 之前无法映射到发送端时钟，此时直接忽略。
+func (t *AVSyncTracker) OnRTP(kind webrtc.RTPCodecType, ssrc uint32, rtpTimestamp uint32, clockRate uint32, arrival time.Time) {
+	if t == nil || clockRate == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m, ok := t.mappings[ssrc]
+	if !ok {
+		return
+	}
+
+	// 使用有符号差值处理 RTP 时间戳回绕
+	diff := int32(rtpTimestamp - m.rtpTime)
+	captureTime := m.ntpTime.Add(time.Duration(float64(diff) / float64(clockRate) * float64(time.Second)))
+	delay := arrival.Sub(captureTime)
+
+	switch kind {
+	case webrtc.RTPCodecTypeVideo:
+		t.lastVideoDelay = delay
+		t.haveVideoDelay = true
+	case webrtc.RTPCodecTypeAudio:
+		if !t.haveVideoDelay {
+			return
+		}
+		skewMs := float64(delay-t.lastVideoDelay) / float64(time.Millisecond)
+		t.skewCount++
+		t.skewSumMs += skewMs
+		if math.Abs(skewMs) > t.skewMaxAbs {
+			t.skewMaxAbs = math.Abs(skewMs)
+		}
+		if t.writer != nil {
+			record := []string{
+				fmt.Sprintf("%d", arrival.UnixMilli()),
+				fmt.Sprintf("%.3f", float64(delay)/float64(time.Millisecond)),
+				fmt.Sprintf("%.3f", float64(t.lastVideoDelay)/float64(time.Millisecond)),
+				fmt.Sprintf("%.3f", skewMs),
+			}
+			if err := t.writer.Write(record); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing av sync CSV: %v\n", err)
+				return
+			}
+			t.writer.Flush()
+		}
+	}
+}
+
+// Stats 返回 skew 的均值（有符号）、最大绝对值（毫秒）以及样本数
+func (t *AVSyncTracker) Stats() (meanMs float64, maxAbsMs float64, samples int) {
+	if t == nil {
+		return 0, 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.skewCount == 0 {
+		return 0, 0, 0
+	}
+	return t.skewSumMs / float64(t.skewCount), t.skewMaxAbs, t.skewCount
+}
+
+// Close 关闭底层文件句柄
+func (t *AVSyncTracker) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.writer != nil {
+		t.writer.Flush()
+	}
+	if t.file != nil {
+		if err := t.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing av sync CSV file: %v\n", err)
+		}
+	}
+}
+
+// readReceiverRTCP 持续读取 receiver 上的 RTCP：SR 交给 tracker，BYE 交给 eos（见 eos.go）。
+// 读取 RTCP 同时也让 interceptor 正常工作，连接关闭后返回。tracker 与 eos 都可以为 nil。
+func readReceiverRTCP(receiver *webrtc.RTPReceiver, tracker *AVSyncTracker, eos *EndOfStreamWatcher) {
+	for {
+		packets, _, err := receiver.ReadRTCP()
+		if err != nil {
+			if !isConnectionClosed(err) {
+				fmt.Fprintf(os.Stderr, "RTCP read loop stopped: %v\n", err)
+			}
+			return
+		}
+		for _, pkt := range packets {
+			switch p := pkt.(type) {
+			case *rtcp.SenderReport:
+				tracker.OnSenderReport(p)
+				clockDrift.OnSenderReport(p, time.Now())
+			case *rtcp.Goodbye:
+				eos.OnGoodbye(p)
+			}
+		}
+	}
+}
+
+// readAudioForAVSync 读取音频轨道的 RTP 包，只用于 A/V skew 统计（不落盘）
+func readAudioForAVSync(track *webrtc.TrackRemote, tracker *AVSyncTracker) {
+	clockRate := track.Codec().ClockRate
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		tracker.OnRTP(webrtc.RTPCodecTypeAudio, pkt.SSRC, pkt.Timestamp, clockRate, time.Now())
+	}
+}
+
+// ntpToTime 将 64 位 NTP 时间戳（高 32 位秒、低 32 位小数）转换为 time.Time
+func ntpToTime(ntp uint64) time.Time {
+	secs := int64(ntp>>32) - ntpEpochOffset
+	frac := ntp & 0xFFFFFFFF
+	nsec := int64(frac * uint64(time.Second) >> 32)
+	return time.Unix(secs, nsec)
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js && !gcc
+// +build !js,!gcc
+
+// benchmark.go - 基础 server 的只编码基准模式（-benchmark）
+//
+// 说明：
+//   - 用于单独评估 FFmpeg 流水线的性能：不创建 PeerConnection、不交换 SDP，也不按帧率等待，
+//     initVideoSource + initVideoEncoding 之后以最快速度跑完 writeVideoToTrack 的解码 / 缩放 / 编码循环
+//   - 编码循环与正常运行完全相同（-codec、-keyframe-interval 等参数照常生效），只是 sample 写给 VideoBenchmark 计数，而不是发给 client
+//   - 每帧的编码耗时从 SendFrame 开始，到取完该帧产生的所有 packet 为止；编码器内部缓冲的帧（lookahead）在之后的帧中输出，
+//     耗时算在那些帧上。achieved fps 按整个流水线（含读文件、解码与缩放）的帧数与墙钟时间计算
+//   - 只编码一遍源文件，不能与 -loop 同时使用；不发送音频
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// videoBenchmark 在 -benchmark 时非 nil：writeVideoToTrack 不按帧率等待，并上报每帧的编码耗时
+var videoBenchmark *VideoBenchmark
+
+// VideoBenchmark 统计编码耗时与吞吐，同时作为编码循环的 sample 接收端；方法对 nil 安全
+type VideoBenchmark struct {
+	start        time.Time
+	encodeTimes  []time.Duration
+	samples      int
+	sampleBytes  int64
+	mediaSeconds float64 // 编码输出的时长（sample 的 Duration 之和）
+}
+
+// NewVideoBenchmark 创建统计，计时从此刻开始
+func NewVideoBenchmark() *VideoBenchmark {
+	return &VideoBenchmark{start: time.Now()}
+}
+
+// OnFrameEncoded 记录一帧从送入编码器到取完输出的耗时
+func (b *VideoBenchmark) OnFrameEncoded(d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.encodeTimes = append(b.encodeTimes, d)
+}
+
+// WriteSample 实现 videoSampleWriter：只统计编码输出，不发送
+func (b *VideoBenchmark) WriteSample(sample media.Sample) error {
+	b.samples++
+	b.sampleBytes += int64(len(sample.Data))
+	b.mediaSeconds += sample.Duration.Seconds()
+	return nil
+}
+
+// Report 打印编码耗时（均值 / p95 / 最大值）、帧数与达到的帧率
+func (b *VideoBenchmark) Report() {
+	if b == nil {
+		return
+	}
+	elapsed := time.Since(b.start)
+	frames := len(b.encodeTimes)
+	if frames == 0 {
+		fmt.Fprintf(os.Stderr, "Benchmark: no frames encoded (%v elapsed)\n", elapsed.Round(time.Millisecond))
+		return
+	}
+	sorted := append([]time.Duration(nil), b.encodeTimes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	p95Index := min(int(math.Ceil(float64(frames)*0.95))-1, frames-1)
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+	fmt.Fprintf(os.Stderr, "Benchmark (%s encoder): %d frames in %v, %.1f fps achieved\n",
+		benchmarkEncoderName(), frames, elapsed.Round(time.Millisecond), float64(frames)/elapsed.Seconds())
+	fmt.Fprintf(os.Stderr, "  Encode latency per frame: mean %.2f ms, p95 %.2f ms, max %.2f ms (%.1f%% of wall time)\n",
+		ms(sum/time.Duration(frames)), ms(sorted[max(p95Index, 0)]), ms(sorted[frames-1]), float64(sum)*100/float64(elapsed))
+	if b.samples > 0 && b.mediaSeconds > 0 {
+		fmt.Fprintf(os.Stderr, "  Output: %d samples, %.2f MB, %.0f kbps at the source frame rate, %.1fx realtime\n",
+			b.samples, float64(b.sampleBytes)/(1024*1024), float64(b.sampleBytes)*8/1000/b.mediaSeconds, b.mediaSeconds/elapsed.Seconds())
+	}
+}
+
+// benchmarkEncoderName 返回 initVideoEncoding 选用的编码器名称（findEncoder 的结果），找不到时为 -codec 的名称
+func benchmarkEncoderName() string {
+	if encoder := outputCodec.findEncoder(); encoder != nil {
+		return encoder.Name()
+	}
+	return outputCodec.name
+}
+
+// runVideoBenchmark 打开源文件，以最快速度编码一遍并打印统计；不涉及任何 WebRTC
+func runVideoBenchmark(videoPath string) error {
+	if err := initVideoSource(videoPath); err != nil {
+		freeVideoCoding()
+		return fmt.Errorf("failed to initialize video source: %w", err)
+	}
+	defer freeVideoCoding()
+
+	fmt.Fprintf(os.Stderr, "Benchmark: encoding %s as %s without pacing or WebRTC...\n", videoPath, outputCodec.name)
+	videoBenchmark = NewVideoBenchmark()
+	done := make(chan bool, 1)
+	writeVideoToTrack(videoBenchmark, false, done)
+	videoBenchmark.Report()
+	return nil
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// bitrate_estimator.go - 根据接收端 RTCP 反馈估计可用带宽（server -rtcp-bwe）
+//
+// 说明：
+//   - 实验控制器原来用自己的发送吞吐（发送比特数 / 发送耗时）估计可用带宽：发得少估得也少，估计值是循环的；
+//     这里改用接收端的反馈，控制器通过 OnNetworkEstimate 取用
+//   - TWCC：注册 pion 的 cc interceptor（GCC 的 SendSideBWE，延迟 + 丢包控制器）以及 transport-wide 序列号头扩展；
+//     pacer 使用 NoOpPacer，包的发送节奏仍由各实验自己控制（BurstRTC / NDTC 的 pacing 不受影响）
+//   - REMB：对端发送 REMB 时（pion client 默认不发送）作为估计的上限
+//   - Receiver Report 的丢包已由 GCC 的丢包控制器按 TWCC 确认计算，这里不再单独使用
+//   - 收到第一份 TWCC / REMB 之前 EstimatedBitrate 返回 0，控制器继续使用原来的发送吞吐估计
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// bitrateEstimator 在 server 指定 -rtcp-bwe 时非 nil，由 newWebRTCAPI 注册 interceptor，drainSenderRTCP 喂入 RTCP
+var bitrateEstimator *BitrateEstimator
+
+const (
+	// bitrateEstimatorInitialBps 是 GCC 的起始估计：pion 默认只有 10 kbps，按乘性增长要几十秒才能到达常见的视频码率
+	bitrateEstimatorInitialBps = 2_000_000
+	// rembValidity 是一份 REMB 作为上限的有效期，超过后认为对端已经不再发送
+	rembValidity = 2 * time.Second
+)
+
+// BitrateEstimator 汇总 TWCC（经 GCC）与 REMB 的带宽估计，方法对 nil 安全
+type BitrateEstimator struct {
+	mu  sync.Mutex
+	bwe cc.BandwidthEstimator // PeerConnection 创建 interceptor 时设置
+
+	twccReports int
+	rembReports int
+	rembBps     float64
+	rembAt      time.Time
+}
+
+// NewBitrateEstimator 创建估计器，需要在 newWebRTCAPI 之前赋值给 bitrateEstimator
+func NewBitrateEstimator() *BitrateEstimator {
+	return &BitrateEstimator{}
+}
+
+// register 注册 cc interceptor 与 TWCC 头扩展 interceptor，必须在默认 interceptor 之前调用：
+// 发送时包从后注册的 interceptor 流向先注册的，cc interceptor 先于头扩展注册才能看到已经写入的序列号
+func (e *BitrateEstimator) register(mediaEngine *webrtc.MediaEngine, registry *interceptor.Registry) error {
+	factory, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(bitrateEstimatorInitialBps),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create congestion control interceptor: %w", err)
+	}
+	factory.OnNewPeerConnection(func(_ string, bwe cc.BandwidthEstimator) {
+		e.mu.Lock()
+		e.bwe = bwe
+		e.mu.Unlock()
+	})
+	registry.Add(factory)
+	// NDTC 的逐帧间隔记录同样需要看到已经写入的序列号（见 frame_dispersion.go）
+	frameDispersion.register(registry)
+	if err = webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, registry); err != nil {
+		return fmt.Errorf("failed to configure TWCC header extension: %w", err)
+	}
+	return nil
+}
+
+// Observe 记录视频发送端收到的 RTCP：TWCC 已经由 cc interceptor 处理，这里只计数；REMB 作为上限
+func (e *BitrateEstimator) Observe(pkts []rtcp.Packet, now time.Time) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, pkt := range pkts {
+		switch p := pkt.(type) {
+		case *rtcp.TransportLayerCC:
+			e.twccReports++
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			e.rembReports++
+			e.rembBps = float64(p.Bitrate)
+			e.rembAt = now
+		}
+	}
+}
+
+// EstimatedBitrate 返回当前的带宽估计（bit/s）；还没有收到 TWCC / REMB 反馈时返回 0
+func (e *BitrateEstimator) EstimatedBitrate() float64 {
+	if e == nil {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var estimate float64
+	if e.bwe != nil && e.twccReports > 0 {
+		estimate = float64(e.bwe.GetTargetBitrate())
+	}
+	if e.rembBps > 0 && time.Since(e.rembAt) < rembValidity && (estimate <= 0 || e.rembBps < estimate) {
+		estimate = e.rembBps
+	}
+	return estimate
+}
+
+// Report 在 session 结束时输出收到的反馈数量与最后的估计
+func (e *BitrateEstimator) Report(prefix string) {
+	if e == nil {
+		return
+	}
+	estimate := e.EstimatedBitrate()
+	e.mu.Lock()
+	twcc, remb := e.twccReports, e.rembReports
+	e.mu.Unlock()
+	if twcc == 0 && remb == 0 {
+		fmt.Fprintf(os.Stderr, "%s RTCP bandwidth estimate: no TWCC or REMB feedback received (does the client negotiate transport-cc?), controllers used send-side throughput\n", prefix)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s RTCP bandwidth estimate: %d TWCC / %d REMB reports, final estimate %.0f kbps\n", prefix, twcc, remb, estimate/1000)
+}
@@ -6,7 +6,7 @@
 // 说明：
 //   - 实现 BurstRTC 风格的 per-frame 预算控制
 //   - 维护帧大小统计（均值/方差）和可用带宽估计
-//   - 提供 NextFrameBudget 返回目标比特数和 burst fraction
+//   - 实现 RateController：NextFrameBudget 返回目标比特数与发送时长（帧间隔），burst fraction 由 BurstFraction 给出
 
 package main
 
@@ -16,17 +16,9 @@ import (
 	"time"
 )
 
-// BurstObservation 表示一帧的发送观测
-type BurstObservation struct {
-	FrameID   int
-	SentBits  int       // 该帧实际发送的总比特数
-	SendStart time.Time // 发送开始时间
-	SendEnd   time.Time // 发送结束时间
-}
-
 // BurstConfig 表示 BurstRTC 控制器的配置参数
 type BurstConfig struct {
-	FrameInterval time.Duration // 帧周期（例如 1/30s）
+	FrameInterval time.Duration // 帧周期（源帧率的倒数）
 	SafetyMargin  float64       // 安全系数（例如 0.7）
 	WindowSize    int           // 滑动窗口大小（用于统计）
 	BurstFraction float64       // 默认 burst 比例（例如 0.3 表示 30% 的帧数据以 burst 方式发送）
@@ -39,7 +31,7 @@ type BurstController struct {
 	cfg BurstConfig
 
 	// 滑动窗口：存储最近的帧观测
-	observations []BurstObservation
+	observations []FrameObservation
 	// 帧大小统计
 	frameSizeMean   float64 // 帧大小均值（比特）
 	frameSizeVar    float64 // 帧大小方差
@@ -49,12 +41,18 @@ type BurstController struct {
 	totalBits int64
 	// 总发送持续时间（用于计算平均吞吐）
 	totalDuration time.Duration
+	// 窗口内发送缓冲区受压的帧比例
+	sendPressureRate float64
+	// 基于接收端 RTCP 反馈的带宽估计（-rtcp-bwe），0 表示没有
+	networkBps float64
+	// 最近一次 NextFrameBudget 给出的 burst fraction
+	burstFraction float64
 }
 
 // NewBurstController 创建一个具有默认参数的 BurstRTC 控制器
 func NewBurstController(cfg BurstConfig) *BurstController {
 	if cfg.FrameInterval <= 0 {
-		cfg.FrameInterval = time.Second / 30 // 默认 30fps
+		cfg.FrameInterval = time.Second / defaultFrameRateFPS
 	}
 	if cfg.SafetyMargin <= 0 {
 		cfg.SafetyMargin = 0.7 // 默认安全系数
@@ -68,13 +66,13 @@ func NewBurstController(cfg BurstConfig) *BurstController {
 
 	return &BurstController{
 		cfg:          cfg,
-		observations: make([]BurstObservation, 0, cfg.WindowSize),
+		observations: make([]FrameObservation, 0, cfg.WindowSize),
 		availableBps: 0,
 	}
 }
 
-// UpdateStats 更新控制器状态，基于新的帧观测
-func (c *BurstController) UpdateStats(obs BurstObservation) {
+// UpdateStats 实现 RateController，基于新的帧观测更新控制器状态
+func (c *BurstController) UpdateStats(obs FrameObservation) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -89,16 +87,27 @@ func (c *BurstController) UpdateStats(obs BurstObservation) {
 		c.observations = c.observations[1:]
 	}
 
-	// 更新总统计
-	c.totalBits += int64(obs.SentBits)
-	duration := obs.SendEnd.Sub(obs.SendStart)
+	// 更新总统计：pacing 部分的速率是发送端自己定的，吞吐只按 burst 部分计算（没有 burst 划分时按整帧）
+	bits, duration := obs.SentBits, obs.SendEnd.Sub(obs.SendStart)
+	if split := obs.Split; split.BurstBits > 0 {
+		bits, duration = split.BurstBits, split.BurstEnd.Sub(split.BurstStart)
+	}
 	if duration > 0 {
+		c.totalBits += int64(bits)
 		c.totalDuration += duration
 	}
 
 	// 更新帧大小统计（均值与方差）
 	c.updateFrameSizeStats()
 
+	blocked := 0
+	for _, o := range c.observations {
+		if o.SendBlocked {
+			blocked++
+		}
+	}
+	c.sendPressureRate = float64(blocked) / float64(len(c.observations))
+
 	// 更新可用带宽估计
 	if c.totalDuration > 0 {
 		c.availableBps = float64(c.totalBits) / c.totalDuration.Seconds()
@@ -134,13 +143,45 @@ func (c *BurstController) updateFrameSizeStats() {
 	}
 }
 
-// NextFrameBudget 返回下一帧的目标比特数和 burst fraction
-// 基于当前可用带宽估计和帧大小统计，使用 SafetyMargin 确保不会过度拥塞
-func (c *BurstController) NextFrameBudget() (targetBits int, burstFraction float64) {
+// OnNetworkEstimate 设置基于接收端 RTCP 反馈的带宽估计（见 bitrate_estimator.go）。
+// bps > 0 时用它代替发送吞吐 totalBits/totalDuration 作为可用带宽，<= 0（还没有反馈）时沿用发送吞吐
+func (c *BurstController) OnNetworkEstimate(bps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networkBps = bps
+}
+
+// capacityBps 返回计算预算使用的可用带宽：有网络估计时用网络估计，否则用发送吞吐。调用方需持有 c.mu
+func (c *BurstController) capacityBps() float64 {
+	if c.networkBps > 0 {
+		return c.networkBps
+	}
+	return c.availableBps
+}
+
+// NextFrameBudget 实现 RateController：返回下一帧的目标比特数，发送时长为一个帧间隔
+// （burst 部分立即发出，其余在帧间隔内 pacing，比例见 BurstFraction）
+func (c *BurstController) NextFrameBudget() (targetBits int, pacing time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	targetBits, c.burstFraction = c.budget()
+	return targetBits, c.cfg.FrameInterval
+}
+
+// BurstFraction 返回最近一次 NextFrameBudget 对应的 burst fraction（还没有调用时为配置的默认值）
+func (c *BurstController) BurstFraction() float64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.burstFraction <= 0 {
+		return c.cfg.BurstFraction
+	}
+	return c.burstFraction
+}
 
-	A := c.availableBps
+// budget 基于当前可用带宽估计和帧大小统计计算目标比特数和 burst fraction，
+// 使用 SafetyMargin 确保不会过度拥塞。调用方需持有 c.mu
+func (c *BurstController) budget() (targetBits int, burstFraction float64) {
+	A := c.capacityBps()
 	if A <= 0 {
 		// fallback：假设 5Mbps
 		A = 5e6
@@ -150,6 +191,8 @@ func (c *BurstController) NextFrameBudget() (targetBits int, burstFraction float
 	// 考虑帧大小方差，可以进一步调整（当前简化版本先不考虑）
 	frameIntervalSec := c.cfg.FrameInterval.Seconds()
 	targetBitsFloat := A * frameIntervalSec * c.cfg.SafetyMargin
+	// 本地发送缓冲区受压时按受压帧比例降低目标（最多减半）
+	targetBitsFloat *= 1 - 0.5*c.sendPressureRate
 	targetBits = int(targetBitsFloat)
 	if targetBits < 1 {
 		targetBits = 1
@@ -175,7 +218,23 @@ func (c *BurstController) NextFrameBudget() (targetBits int, burstFraction float
 func (c *BurstController) GetStats() (meanBits float64, varianceBits float64, availableBps float64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.frameSizeMean, c.frameSizeVar, c.availableBps
+	return c.frameSizeMean, c.frameSizeVar, c.capacityBps()
+}
+
+// State 返回控制器状态的快照（BurstRTC 没有丢包反馈，LossRate 为 0；EstimateBps 为 -rtcp-bwe 的网络估计）
+func (c *BurstController) State() ControllerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	budgetBits, _ := c.budget()
+	return ControllerState{
+		CapacityBps:    c.capacityBps(),
+		EstimateBps:    c.networkBps,
+		BudgetBits:     budgetBits,
+		WindowFrames:   len(c.observations),
+		WindowMeanBits: c.frameSizeMean,
+		WindowVarBits:  c.frameSizeVar,
+		SendPressure:   c.sendPressureRate,
+	}
 }
 
 
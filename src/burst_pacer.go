@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// burst_pacer.go - BurstRTC 的逐帧 burst + pacing 发送
+//
+// 说明：
+//   - 每帧编码后的 RTP 包按 NextFrameBudget 的 burstFraction 分成两部分：前 ceil(burstFraction × 包数) 个立即发出（burst），
+//     其余的用子 ticker 均匀分布在本帧间隔剩下的时间里（pacing），最后一个包在帧间隔结束时发出
+//   - WriteSample 只分片入队（包装轨道的 -max-bytes、-frame-hash 等照常工作），SendFrame 在发送循环中同步发送并返回两段的时间划分
+//   - RTP 时间戳与 TrackLocalStaticSample 相同，按 sample.Duration 累加
+//   - -send-pressure 只统计 WriteRTP 本身的耗时，不包括 pacing 的等待
+//   - 编码耗时已经用完帧间隔时，pacing 部分没有时间可用，与 burst 一起立即发出并计数
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// burstPacerClockRate 是 H.264 的 RTP 时钟频率
+const burstPacerClockRate = 90000
+
+// BurstSendResult 是一帧的发送划分
+type BurstSendResult struct {
+	BurstPackets int
+	PacedPackets int
+	BurstBits    int // 负载比特数（不含 RTP 头）
+	PacedBits    int
+	BurstStart   time.Time // 第一个包写入的时间
+	BurstEnd     time.Time // burst 部分写完的时间
+	SendEnd      time.Time // 最后一个包写完的时间
+	Unpaced      bool      // 没有剩余时间，pacing 部分随 burst 立即发出
+}
+
+// BurstPacer 实现 h264SampleWriter：WriteSample 只分片入队，由 SendFrame 按 burst fraction 发送
+type BurstPacer struct {
+	track      *webrtc.TrackLocalStaticRTP
+	packetizer rtp.Packetizer
+
+	pending []*rtp.Packet
+
+	frames        int
+	unpacedFrames int
+	burstBytes    int64
+	pacedBytes    int64
+}
+
+// NewBurstPacer 创建 BurstRTC 使用的 H.264 视频轨道
+func NewBurstPacer(id, streamID string) (*BurstPacer, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	// SSRC 与 payload type 会在 WriteRTP 时按实际协商结果改写，这里填 0 即可
+	return &BurstPacer{
+		track:      track,
+		packetizer: rtp.NewPacketizer(h264RTPMTU, 0, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), burstPacerClockRate),
+	}, nil
+}
+
+// Track 返回加入 PeerConnection 的轨道
+func (p *BurstPacer) Track() webrtc.TrackLocal {
+	return p.track
+}
+
+// WriteSample 分片一个 sample 并加入当前帧的待发队列
+func (p *BurstPacer) WriteSample(sample media.Sample) error {
+	samples := uint32(sample.Duration.Seconds() * burstPacerClockRate)
+	p.pending = append(p.pending, p.packetizer.Packetize(sample.Data, samples)...)
+	return nil
+}
+
+// SendFrame 发送当前帧的待发队列：burst 部分立即发出，其余在 deadline 之前均匀发出。
+// burstFraction >= 1 或 deadline 为零值时全部立即发出
+func (p *BurstPacer) SendFrame(burstFraction float64, deadline time.Time) (BurstSendResult, error) {
+	packets := p.pending
+	p.pending = nil
+	var res BurstSendResult
+	if len(packets) == 0 {
+		return res, nil
+	}
+
+	burstCount := len(packets)
+	if !deadline.IsZero() && burstFraction < 1 {
+		burstCount = min(max(int(math.Ceil(burstFraction*float64(len(packets)))), 1), len(packets))
+	}
+
+	res.BurstStart = time.Now()
+	for _, pkt := range packets[:burstCount] {
+		if err := p.write(pkt); err != nil {
+			return res, err
+		}
+		res.BurstPackets++
+		res.BurstBits += len(pkt.Payload) * 8
+	}
+	res.BurstEnd = time.Now()
+
+	paced := packets[burstCount:]
+	window := time.Until(deadline)
+	if len(paced) > 0 && window <= 0 {
+		res.Unpaced = true
+		p.unpacedFrames++
+	}
+	if len(paced) > 0 && window > 0 {
+		// 第 i 个包（从 1 计）在 burst 结束后 i × gap 发出；ticker 落后时一次补发所有到期的包
+		gap := window / time.Duration(len(paced))
+		ticker := time.NewTicker(max(gap, time.Millisecond))
+		pacedStart := time.Now()
+		for len(paced) > 0 {
+			<-ticker.C
+			due := len(paced)
+			if elapsed := time.Since(pacedStart); elapsed < window {
+				due = min(int(elapsed/gap)-res.PacedPackets, len(paced))
+			}
+			for _, pkt := range paced[:due] {
+				if err := p.write(pkt); err != nil {
+					ticker.Stop()
+					return res, err
+				}
+				res.PacedPackets++
+				res.PacedBits += len(pkt.Payload) * 8
+			}
+			paced = paced[due:]
+		}
+		ticker.Stop()
+	} else {
+		for _, pkt := range paced {
+			if err := p.write(pkt); err != nil {
+				return res, err
+			}
+			res.PacedPackets++
+			res.PacedBits += len(pkt.Payload) * 8
+		}
+	}
+	res.SendEnd = time.Now()
+
+	p.frames++
+	p.burstBytes += int64(res.BurstBits / 8)
+	p.pacedBytes += int64(res.PacedBits / 8)
+	return res, nil
+}
+
+// write 发送一个包，耗时计入 -send-pressure
+func (p *BurstPacer) write(pkt *rtp.Packet) error {
+	start := time.Now()
+	if err := p.track.WriteRTP(pkt); err != nil {
+		return err
+	}
+	if sendPressure != nil {
+		sendPressure.observe(len(pkt.Payload), time.Since(start))
+	}
+	return nil
+}
+
+// Report 在 session 结束时输出 burst / pacing 的字节比例
+func (p *BurstPacer) Report(prefix string) {
+	if p.frames == 0 {
+		return
+	}
+	total := p.burstBytes + p.pacedBytes
+	fmt.Fprintf(os.Stderr, "%s Burst pacing: %d frames, %.1f%% of the bytes sent as bursts, %d frames had no time left in the frame interval and were sent at once\n",
+		prefix, p.frames, float64(p.burstBytes)*100/float64(max(total, 1)), p.unpacedFrames)
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// byte_budget.go - 整个 server session 的编码字节上限（-max-bytes）
+//
+// 说明：
+//   - client 有 -max-size，server 却没有输出预算，-loop 时会无限发送
+//   - ByteBudgetTrack 包装 h264SampleWriter，统计已发送的编码字节；
+//     下一个 sample 会使累计字节超过上限时拒绝写入并返回 errByteBudgetReached，
+//     发送循环按写入失败处理，结束发送并通知 done，因此实际发送量不超过上限
+//   - 一帧分多个 sample 发送（Salsify、BurstRTC 按 NALU 发送）时，最后一帧可能只发出一部分
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// errByteBudgetReached 表示再发送就会超过 -max-bytes
+var errByteBudgetReached = errors.New("max-bytes budget reached")
+
+// ByteBudgetTrack 统计经过的编码字节，maxBytes > 0 时在达到上限后拒绝继续写入
+type ByteBudgetTrack struct {
+	track    h264SampleWriter
+	maxBytes int64 // 0 表示不限制，只统计
+
+	sent    atomic.Int64
+	reached atomic.Bool
+}
+
+// NewByteBudgetTrack 包装 track；maxBytes 为 0 时只统计字节数
+func NewByteBudgetTrack(track h264SampleWriter, maxBytes int64) *ByteBudgetTrack {
+	return &ByteBudgetTrack{track: track, maxBytes: maxBytes}
+}
+
+// WriteSample 在预算内时转发给底层轨道并累计字节数
+func (b *ByteBudgetTrack) WriteSample(sample media.Sample) error {
+	size := int64(len(sample.Data))
+	if b.maxBytes > 0 && b.sent.Load()+size > b.maxBytes {
+		if !b.reached.Swap(true) {
+			fmt.Fprintf(os.Stderr, "Max bytes (%d) reached after %d bytes, stopping...\n", b.maxBytes, b.sent.Load())
+		}
+		return errByteBudgetReached
+	}
+	if err := b.track.WriteSample(sample); err != nil {
+		return err
+	}
+	b.sent.Add(size)
+	return nil
+}
+
+// Sent 返回已成功写入底层轨道的编码字节数
+func (b *ByteBudgetTrack) Sent() int64 {
+	return b.sent.Load()
+}
+
+// Report 打印本次 session 实际发送的编码字节数
+func (b *ByteBudgetTrack) Report(prefix string) {
+	if b.maxBytes > 0 {
+		fmt.Fprintf(os.Stderr, "%s Encoded video sent: %d bytes (max-bytes %d)\n", prefix, b.Sent(), b.maxBytes)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s Encoded video sent: %d bytes\n", prefix, b.Sent())
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// candidate_budget.go - Salsify 候选编码的时间预算（-candidate-time-budget）
+//
+// 说明：
+//   - Salsify 每帧按所有候选档位（见 candidate_ladder.go）各编码一次，慢的机器上候选编码的总耗时会超过帧间隔，发送循环跟不上源帧率
+//   - 开启后候选按优先级编码：在档位表中与上一帧选中的档位越接近越先编码（距离相同时先编码质量低、体积小的），
+//     累计耗时达到帧间隔的给定比例后停止，用已经得到的候选选择；至少编码一个候选
+//   - 第一帧没有上一帧的档位，从最后一个档位（最小的候选）开始
+//   - 指定 -session-dir 时逐帧记录到 salsify_candidates.csv：实际编码的候选数、耗时与选中的 QP、分辨率比例
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// candidateBudget 在 -candidate-time-budget 开启时非 nil，由 encodeMultipleCandidates 与发送循环调用
+var candidateBudget *CandidateBudget
+
+// CandidateBudget 限制每帧候选编码的耗时，方法对 nil 安全，只能在发送协程中使用
+type CandidateBudget struct {
+	budget time.Duration // 每帧候选编码的时间上限
+
+	frames    int
+	evaluated int // 累计编码的候选数
+	truncated int // 因时间预算未编码全部候选的帧数
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewCandidateBudget 创建时间预算：fraction 为帧间隔的比例；csvPath 为空时不写逐帧记录
+func NewCandidateBudget(fraction float64, frameInterval time.Duration, csvPath string) (*CandidateBudget, error) {
+	if frameInterval <= 0 {
+		frameInterval = time.Second / defaultFrameRateFPS
+	}
+	b := &CandidateBudget{budget: time.Duration(fraction * float64(frameInterval))}
+	if csvPath == "" {
+		return b, nil
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create candidate csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"frame", "unix_ms", "budget_bits", "evaluated", "total", "encode_ms", "selected_qp", "selected_bits", "selected_scale"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write candidate header: %w", err)
+	}
+	w.Flush()
+	b.writer, b.file = w, f
+	return b, nil
+}
+
+// Order 返回本帧候选档位（档位表下标，0..rungs-1）的编码顺序；prev 为上一帧选中的下标，没有时为 -1。
+// 未开启时按档位表顺序返回
+func (b *CandidateBudget) Order(rungs, prev int) []int {
+	order := make([]int, rungs)
+	for i := range order {
+		order[i] = i
+	}
+	if b == nil {
+		return order
+	}
+	if prev < 0 || prev >= rungs {
+		prev = rungs - 1
+	}
+	slices.SortStableFunc(order, func(a, c int) int {
+		da, dc := rungDistance(a, prev), rungDistance(c, prev)
+		if da != dc {
+			return da - dc
+		}
+		return c - a
+	})
+	return order
+}
+
+// Exhausted 判断从 start 开始的候选编码是否已经用完时间预算；未开启时总是 false
+func (b *CandidateBudget) Exhausted(start time.Time) bool {
+	return b != nil && time.Since(start) >= b.budget
+}
+
+// Record 记录一帧实际编码的候选数（evaluated / total）与选择结果
+func (b *CandidateBudget) Record(frameID, budgetBits, evaluated, total int, elapsed time.Duration, selected EncodedCandidate) {
+	if b == nil {
+		return
+	}
+	b.frames++
+	b.evaluated += evaluated
+	if evaluated < total {
+		b.truncated++
+	}
+	if b.writer == nil {
+		return
+	}
+	if err := b.writer.Write([]string{
+		fmt.Sprintf("%d", frameID),
+		fmt.Sprintf("%d", time.Now().UnixMilli()),
+		fmt.Sprintf("%d", budgetBits),
+		fmt.Sprintf("%d", evaluated),
+		fmt.Sprintf("%d", total),
+		fmt.Sprintf("%.3f", float64(elapsed)/float64(time.Millisecond)),
+		fmt.Sprintf("%d", selected.QP),
+		fmt.Sprintf("%d", selected.Bits),
+		fmt.Sprintf("%g", selected.Scale),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing candidate CSV: %v\n", err)
+	}
+	b.writer.Flush()
+}
+
+// Close 打印汇总并关闭 CSV 文件
+func (b *CandidateBudget) Close() {
+	if b == nil {
+		return
+	}
+	mean := 0.0
+	if b.frames > 0 {
+		mean = float64(b.evaluated) / float64(b.frames)
+	}
+	fmt.Fprintf(os.Stderr, "[Salsify] Candidate time budget %v: %.2f candidates per frame on average, %d of %d frames stopped early\n",
+		b.budget, mean, b.truncated, b.frames)
+	if b.file == nil {
+		return
+	}
+	b.writer.Flush()
+	if err := b.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing candidate CSV file: %v\n", err)
+	}
+}
+
+// rungDistance 返回两个档位下标的差的绝对值
+func rungDistance(a, b int) int {
+	if a < b {
+		return b - a
+	}
+	return a - b
+}
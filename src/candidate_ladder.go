@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// candidate_ladder.go - Salsify 的候选编码档位（-qp-ladder / -candidate-scales）
+//
+// 说明：
+//   - 每帧按档位表中的每个档位各编码一个候选，选择不超过预算的质量最高的候选；档位由 QP 与分辨率比例组成
+//   - -qp-ladder 给出 QP 档位（默认 20,25,30,35），-candidate-scales 给出相对当前编码分辨率的比例（默认只有 1）；
+//     两者的每种组合都是一个档位，每帧的编码次数是两者长度的乘积
+//   - 档位按质量从高到低排列：先按分辨率从大到小，同一分辨率内按 QP 从低到高。因此只有当较大分辨率的所有 QP 都超出预算时
+//     才会选择较小的分辨率
+//   - 比例小于 1 的候选把已缩放到编码分辨率的帧再缩放一次（宽高取偶数）；候选编码器的 GOP 为 1，每个候选都是带 SPS/PPS 的 IDR，
+//     相邻帧选中的分辨率不同时 client 也能直接解码
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/asticode/go-astiav"
+)
+
+// CandidateRung 是一个候选编码档位
+type CandidateRung struct {
+	QP    int
+	Scale float64 // 相对当前编码分辨率的比例，1 表示不缩放
+}
+
+func (r CandidateRung) String() string {
+	if r.Scale == 1 {
+		return fmt.Sprintf("QP=%d", r.QP)
+	}
+	return fmt.Sprintf("QP=%d scale=%.2f", r.QP, r.Scale)
+}
+
+// salsifyLadder 是按质量从高到低排列的候选档位，由 -qp-ladder 与 -candidate-scales 设置
+var salsifyLadder = buildCandidateLadder([]int{20, 25, 30, 35}, []float64{1})
+
+// parseQPLadder 解析逗号分隔的 QP 列表（0-51，不能重复），返回从低到高排序的结果
+func parseQPLadder(value string) ([]int, error) {
+	var qps []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		qp, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QP %q", field)
+		}
+		if qp < 0 || qp > 51 {
+			return nil, fmt.Errorf("QP %d out of range 0-51", qp)
+		}
+		if slices.Contains(qps, qp) {
+			return nil, fmt.Errorf("duplicate QP %d", qp)
+		}
+		qps = append(qps, qp)
+	}
+	if len(qps) == 0 {
+		return nil, errors.New("empty QP ladder")
+	}
+	slices.Sort(qps)
+	return qps, nil
+}
+
+// parseCandidateScales 解析逗号分隔的分辨率比例（0 < scale <= 1，不能重复），返回从大到小排序的结果
+func parseCandidateScales(value string) ([]float64, error) {
+	var scales []float64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		scale, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scale %q", field)
+		}
+		if scale <= 0 || scale > 1 {
+			return nil, fmt.Errorf("scale %g out of range (0, 1]", scale)
+		}
+		if slices.Contains(scales, scale) {
+			return nil, fmt.Errorf("duplicate scale %g", scale)
+		}
+		scales = append(scales, scale)
+	}
+	if len(scales) == 0 {
+		return nil, errors.New("empty scale list")
+	}
+	slices.Sort(scales)
+	slices.Reverse(scales)
+	return scales, nil
+}
+
+// buildCandidateLadder 按质量从高到低组合档位：scales 从大到小，qps 从低到高
+func buildCandidateLadder(qps []int, scales []float64) []CandidateRung {
+	ladder := make([]CandidateRung, 0, len(qps)*len(scales))
+	for _, scale := range scales {
+		for _, qp := range qps {
+			ladder = append(ladder, CandidateRung{QP: qp, Scale: scale})
+		}
+	}
+	return ladder
+}
+
+// candidateScaler 把编码分辨率的帧缩放到一个较小的候选分辨率
+type candidateScaler struct {
+	ssc       *astiav.SoftwareScaleContext
+	frame     *astiav.Frame
+	srcWidth  int
+	srcHeight int
+	srcFormat astiav.PixelFormat
+}
+
+// candidateScalers 按比例缓存缩放上下文，只在发送协程中使用，由 freeCandidateScalers 释放
+var candidateScalers = map[float64]*candidateScaler{}
+
+// scaleCandidateFrame 返回 frame 按 scale 缩放后的帧；scale 为 1 时直接返回 frame。
+// 返回的帧在下一次以同一比例调用之前有效
+func scaleCandidateFrame(frame *astiav.Frame, scale float64) (*astiav.Frame, error) {
+	if scale == 1 {
+		return frame, nil
+	}
+	// YUV420P 要求宽高为偶数
+	width := max(int(float64(frame.Width())*scale)&^1, 2)
+	height := max(int(float64(frame.Height())*scale)&^1, 2)
+
+	s := candidateScalers[scale]
+	if s == nil || s.srcWidth != frame.Width() || s.srcHeight != frame.Height() || s.srcFormat != frame.PixelFormat() {
+		ssc, err := astiav.CreateSoftwareScaleContext(frame.Width(), frame.Height(), frame.PixelFormat(), width, height,
+			astiav.PixelFormatYuv420P, astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBilinear))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create candidate scale context: %w", err)
+		}
+		dst := astiav.AllocFrame()
+		if dst == nil {
+			ssc.Free()
+			return nil, errors.New("failed to allocate candidate frame")
+		}
+		if s != nil {
+			s.free()
+		}
+		s = &candidateScaler{ssc: ssc, frame: dst, srcWidth: frame.Width(), srcHeight: frame.Height(), srcFormat: frame.PixelFormat()}
+		candidateScalers[scale] = s
+	}
+	if err := s.ssc.ScaleFrame(frame, s.frame); err != nil {
+		return nil, fmt.Errorf("failed to scale candidate frame: %w", err)
+	}
+	return s.frame, nil
+}
+
+// free 释放缩放上下文与输出帧
+func (s *candidateScaler) free() {
+	s.ssc.Free()
+	s.frame.Free()
+}
+
+// freeCandidateScalers 释放所有候选缩放上下文
+func freeCandidateScalers() {
+	for scale, s := range candidateScalers {
+		s.free()
+		delete(candidateScalers, scale)
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js && !gcc
+// +build !js,!gcc
+
+// cbr.go - 基础 server 的恒定码率编码（-bitrate）
+//
+// 说明：
+//   - 默认 x264 / x265 使用 CRF（恒定质量），码率随画面复杂度大幅波动，不便与固定带宽的链路或其他实验对比；
+//     -bitrate N（kbps）让编码器以恒定码率输出
+//   - go-astiav 只提供 SetBitRate，rc_max_rate / rc_min_rate / rc_buffer_size 通过打开编码器的选项字典设置
+//     （AVCodecContext 的通用选项 maxrate / minrate / bufsize）：三者都等于目标码率，VBV 缓冲只容纳一帧间隔的比特，
+//     单帧不能借用后续帧的预算，关键帧也被压在缓冲大小以内
+//   - 各编码器的 CBR 开关：x264 加 nal-hrd=cbr（码率不足时填充 filler）；x265 在 x265-params 中加 strict-cbr；
+//     libvpx / libaom 在 minrate == maxrate == bit_rate 时自动使用 CBR；libsvtav1 在 maxrate == bit_rate 时使用 CBR。
+//     CRF 与码率模式冲突，开启后去掉 AV1 的 crf 选项；VP8 的默认码率（vp8BitRate）被 -bitrate 取代
+//   - 编码输出按源帧率计时，每秒（媒体时间）统计一次码率，结束时打印整体与逐秒的最小 / 最大码率，
+//     整体偏离目标超过 cbrTolerance 时给出警告。-benchmark 时同样统计，可以离线检查码率控制
+//   - 0（默认）关闭，保持原来的码率控制
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// cbrTolerance 是整体输出码率相对目标允许的偏差
+const cbrTolerance = 0.10
+
+// cbrTarget 在 -bitrate > 0 时非 nil
+var cbrTarget *CBRTarget
+
+// CBRTarget 配置恒定码率编码并统计编码输出的实际码率；方法对 nil 安全
+type CBRTarget struct {
+	bitRate int64 // bit/s
+
+	mu            sync.Mutex
+	bytes         int64
+	mediaTime     time.Duration
+	frames        int
+	windowBytes   int64
+	windowTime    time.Duration
+	windows       int
+	minWindowKbps float64
+	maxWindowKbps float64
+}
+
+// NewCBRTarget 创建目标码率为 kbps 的 CBR 配置；kbps <= 0 时返回 nil（关闭）
+func NewCBRTarget(kbps int) *CBRTarget {
+	if kbps <= 0 {
+		return nil
+	}
+	return &CBRTarget{bitRate: int64(kbps) * 1000}
+}
+
+// Apply 在打开编码器之前设置目标码率，并返回加上码率控制选项的编码器选项；frameRate 用于计算一帧间隔的 VBV 缓冲大小
+func (t *CBRTarget) Apply(cc *astiav.CodecContext, encoderName string, frameRate astiav.Rational, options [][2]string) [][2]string {
+	if t == nil {
+		return options
+	}
+	cc.SetBitRate(t.bitRate)
+	bufSize := int64(math.Ceil(float64(t.bitRate) * frameRateInterval(frameRate).Seconds()))
+	rate := strconv.FormatInt(t.bitRate, 10)
+
+	result := make([][2]string, 0, len(options)+4)
+	for _, option := range options {
+		if option[0] == "crf" {
+			continue
+		}
+		if option[0] == "x265-params" {
+			option[1] += ":strict-cbr=1"
+		}
+		result = append(result, option)
+	}
+	result = append(result, [2]string{"maxrate", rate}, [2]string{"minrate", rate}, [2]string{"bufsize", strconv.FormatInt(bufSize, 10)})
+	if encoderName == "libx264" {
+		result = append(result, [2]string{"nal-hrd", "cbr"})
+	}
+	fmt.Fprintf(os.Stderr, "CBR: %s at %d kbps, VBV buffer %d bits (one frame at %.3f fps)\n",
+		encoderName, t.bitRate/1000, bufSize, frameRate.Float64())
+	return result
+}
+
+// OnSample 记录一个编码输出的 sample（duration 为其媒体时长）
+func (t *CBRTarget) OnSample(size int, duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytes += int64(size)
+	t.mediaTime += duration
+	t.frames++
+	t.windowBytes += int64(size)
+	t.windowTime += duration
+	if t.windowTime >= time.Second {
+		kbps := float64(t.windowBytes) * 8 / 1000 / t.windowTime.Seconds()
+		if t.windows == 0 || kbps < t.minWindowKbps {
+			t.minWindowKbps = kbps
+		}
+		t.maxWindowKbps = max(t.maxWindowKbps, kbps)
+		t.windows++
+		t.windowBytes, t.windowTime = 0, 0
+	}
+}
+
+// Report 打印实际码率与目标的对比
+func (t *CBRTarget) Report() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	target := float64(t.bitRate) / 1000
+	if t.mediaTime <= 0 {
+		fmt.Fprintf(os.Stderr, "CBR: no frames encoded (target %.0f kbps)\n", target)
+		return
+	}
+	measured := float64(t.bytes) * 8 / 1000 / t.mediaTime.Seconds()
+	deviation := (measured - target) / target
+	var line strings.Builder
+	fmt.Fprintf(&line, "CBR: target %.0f kbps, measured %.0f kbps over %d frames (%+.1f%%)", target, measured, t.frames, deviation*100)
+	if t.windows > 0 {
+		fmt.Fprintf(&line, ", per-second min %.0f / max %.0f kbps", t.minWindowKbps, t.maxWindowKbps)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", line.String())
+	if math.Abs(deviation) > cbrTolerance {
+		fmt.Fprintf(os.Stderr, "Warning: CBR output is off the -bitrate target by more than %.0f%%\n", cbrTolerance*100)
+	}
+}
@@ -8,8 +8,8 @@
 //
 // 这个程序的作用：
 //  1. 连接到 WebRTC 服务器
-//  2. 接收服务器发送的视频流（H.264 格式）
-//  3. 将接收到的视频数据保存为 .h264 文件
+//  2. 接收服务器发送的视频流（H.264 格式，server -codec vp8 / h265 / av1 时为 VP8 / H.265 / AV1）
+//  3. 将接收到的视频数据保存为 .h264 文件（VP8 与 AV1 保存为 IVF，用 -output received.ivf 指定文件名；H.265 同样是 Annex-B，建议 -output received.h265）
 //
 // 工作流程：
 //  1. 从 stdin 或文件读取 server 发送的 offer（会话描述）
@@ -41,13 +41,55 @@ import (
 func main() {
 	// ========== 第一步：解析命令行参数 ==========
 	// 这些参数让用户可以自定义程序行为
-	outputFile := flag.String("output", "received.h264", "输出视频文件名（H.264 格式）")
-	localIP := flag.String("ip", "", "本地 IP 地址（例如：192.168.100.2）。如果不指定，自动检测")
+	outputFile := flag.String("output", "received.h264", "输出视频文件名（H.264 Annex-B 格式；以 .mp4 结尾时直接封装为 MP4，时间戳取自 RTP），- 表示写到 stdout（需要同时指定 -answer-file 或 -signal-url）")
+	localIP := flag.String("ip", "", "本地 IP 地址（例如：192.168.100.2）。如果不指定，自动选择最合适的网卡地址；any 表示使用所有网卡")
 	answerFile := flag.String("answer-file", "", "写入 answer 的文件路径（可选，如果不指定则输出到 stdout）")
+	signalURL := flag.String("signal-url", "", "通过 sdp-bridge 房间自动交换 SDP，代替 stdin/stdout 复制粘贴：ws:// 或 wss:// 地址通过 WebSocket 接收 offer、发送 answer（例如 ws://bridge.example.com:8080/demo），http:// 地址轮询 <url>/offer 并 POST answer")
 	maxDuration := flag.Duration("max-duration", 0, "最大录制时长（例如：30s、5m）。0 表示无限制")
 	maxSize := flag.Int64("max-size", 0, "最大文件大小（MB）。0 表示无限制")
+	sessionDir := flag.String("session-dir", "", "会话目录（可选）：收到 AV1 轨道时把各时间层的帧率、码率与接收端所在层逐秒写入 <session-dir>/av1_layers.csv，汇总写入 metrics_summary.json / .txt。H.264 / H.265 的逐帧指标需要使用实验 client")
+	flag.DurationVar(&jitterBufferDelay, "jitter-buffer", 0, "解包前按序列号重排视频 RTP 包：按序到达的包直接通过，缺口之后的包最多等待这么长时间（例如 50ms）补齐缺失的包。0 表示按到达顺序写入")
+	flag.Var(&playoutPolicy, "playout", "播放策略，预设抖动缓冲的等待时长：low-latency（缺口等待 20ms）、smooth（200ms）或 adaptive（从 50ms 开始，按 RFC 3550 到达间隔抖动的 4 倍在 10-300ms 内调整，有包晚到时增大）。不能与 -jitter-buffer 同时使用")
+	nackOn := flag.Bool("nack", true, "按视频 RTP 序列号缺口发送 RTCP NACK（缺失的包立即请求，间隔 100ms 最多请求 3 次，超过 1 秒放弃）；-nack=false 时不发送 NACK，丢包只能等关键帧恢复")
+	flag.BoolVar(&abortOnFirstError, "abort-on-first-error", false, "严格模式：解码/缩放/编码/写入等可恢复错误直接终止进程（调试用）")
+	printSDPCaps := flag.Bool("print-sdp-capabilities", false, "打印当前构建支持的编解码器、RTCP 反馈与头部扩展后退出")
+	trickle := flag.Bool("trickle", false, "不等待 ICE 候选收集完成就发送 answer，之后通过 -signal-url 的 WebSocket 逐个交换 ICE 候选（需要 ws:// 或 wss:// 的 -signal-url，server 也要指定 -trickle）")
 	flag.Parse()
 
+	if *printSDPCaps {
+		if err := printSDPCapabilities(*localIP, 50100, 50200, nackSender.newAPI); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing SDP capabilities: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *signalURL != "" && *answerFile != "" {
+		fmt.Fprintf(os.Stderr, "Error: -signal-url cannot be combined with -answer-file\n")
+		os.Exit(1)
+	}
+	if *trickle && !isWebSocketSignalURL(*signalURL) {
+		fmt.Fprintf(os.Stderr, "Error: -trickle requires a ws:// or wss:// -signal-url\n")
+		os.Exit(1)
+	}
+	if *outputFile == stdoutOutput && *answerFile == "" && *signalURL == "" {
+		fmt.Fprintf(os.Stderr, "Error: -output - requires -answer-file or -signal-url (otherwise the answer is printed to stdout with the video)\n")
+		os.Exit(1)
+	}
+	if jitterBufferDelay < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -jitter-buffer must be >= 0\n")
+		os.Exit(1)
+	}
+	if err := applyPlayoutPolicy(flagPassed("jitter-buffer")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *sessionDir != "" {
+		if err := os.MkdirAll(*sessionDir, 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating session directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// ========== 第二步：配置 WebRTC 设置引擎 ==========
 	// SettingEngine 用于配置 WebRTC 的各种参数
 	settingEngine := webrtc.SettingEngine{}
@@ -66,11 +108,18 @@ func main() {
 
 	// ========== 第四步：创建 WebRTC API 和 PeerConnection ==========
 	// API 是 WebRTC 的入口，PeerConnection 代表一个对等连接
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	// 与 webrtc.NewAPI 的默认配置相同，只是视频丢包的 NACK 由 nackSender 发送（见 nack_sender.go）
+	nackSender = NewNackSender(*nackOn)
+	api, err := nackSender.newAPI(settingEngine)
+	if err != nil {
+		panic(err)
+	}
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
 		panic(err)
 	}
+	nackSender.Attach(peerConnection)
+	defer nackSender.Report()
 	// defer 确保程序退出时关闭连接，释放资源
 	defer func() {
 		if cErr := peerConnection.Close(); cErr != nil {
@@ -78,6 +127,9 @@ func main() {
 		}
 	}()
 
+	// frameRate 在读取 offer 后设置，OnTrack 只会在 SetRemoteDescription 之后触发
+	var frameRate float64
+
 	// ========== 第五步：设置事件处理器 ==========
 	// 当收到远程视频流时触发
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
@@ -100,7 +152,7 @@ func main() {
 					rtcpSendErr := peerConnection.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}})
 					if rtcpSendErr != nil {
 						// 如果连接已关闭，停止发送
-						if strings.Contains(rtcpSendErr.Error(), "closed") {
+						if isConnectionClosed(rtcpSendErr) {
 							return
 						}
 						// 只记录非关闭错误
@@ -115,26 +167,48 @@ func main() {
 		codecName := strings.ToLower(strings.Split(track.Codec().RTPCodecCapability.MimeType, "/")[1])
 		fmt.Fprintf(os.Stderr, "Track has started, of type %d: %s \n", track.PayloadType(), codecName)
 
-		// 只处理 H.264 视频
-		if codecName == "h264" {
+		// 按编解码器选择写入方式：H.264 / H.265 写 Annex-B（writeH264ToFile 按 MimeType 选择解包器），VP8 / AV1（server -codec vp8 / av1）写 IVF
+		switch codecName {
+		case "h264", "h265":
 			// 将 H.264 数据写入文件
-			// 默认帧率 30 fps，sessionDir 为空（基础 client 不使用）
-			frameRate := 30.0
-			writeH264ToFile(track, *outputFile, *maxDuration, *maxSize, "", frameRate)
-		} else {
-			fmt.Fprintf(os.Stderr, "Unsupported codec: %s, only H264 is supported\n", codecName)
+			// 帧率来自 offer 中的 a=framerate，sessionDir 为空（基础 client 的 -session-dir 只用于 AV1 时间层统计）
+			writeH264ToFile(track, *outputFile, *maxDuration, *maxSize, "", frameRate, nil, defaultBitrateWindowConfig(), StartCodeLong, nil, nil)
+		case "vp8", "av1":
+			writeIVFToFile(track, *outputFile, *maxDuration, *maxSize, *sessionDir)
+		default:
+			fmt.Fprintf(os.Stderr, "Unsupported codec: %s, only H264, H265, VP8 and AV1 are supported\n", codecName)
 		}
 	})
 
 	// 使用公共函数设置事件处理器（避免重复代码）
 	setupPeerConnectionHandlers(peerConnection, nil, nil, nil)
 
+	// -trickle：候选通过 sdp-bridge 的 WebSocket 逐个交换（接管 OnICECandidate）
+	var trickleICE *TrickleICE
+	if *trickle {
+		if trickleICE, err = NewTrickleICE(peerConnection, *signalURL, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -trickle: %v\n", err)
+			os.Exit(1)
+		}
+		defer trickleICE.Close()
+	}
+
 	// ========== 第六步：读取 Server 发送的 Offer ==========
 	// Offer 是 Server 发送的会话描述，包含了 Server 支持的编解码器、网络地址等信息
-	// 我们从 stdin 读取（通常是通过管道或重定向传入）
+	// 默认从 stdin 读取（通常是通过管道或重定向传入），指定 -signal-url 时从 sdp-bridge 房间取得
 	offer := webrtc.SessionDescription{}
-	offerStr := readUntilNewline() // 使用公共函数
-	decode(offerStr, &offer)       // 使用公共函数解码
+	var offerStr string
+	if *signalURL != "" {
+		offerStr = pollSignal(*signalURL, "offer")
+		if offerStr == "" {
+			os.Exit(1)
+		}
+	} else {
+		offerStr = readUntilNewline() // 使用公共函数
+	}
+	decode(offerStr, &offer) // 使用公共函数解码
+	// 源帧率由 server 写入 offer（a=framerate），用于卡顿阈值与码率计算
+	frameRate = offerFrameRate(offer)
 
 	// ========== 第七步：设置远程会话描述 ==========
 	// 告诉 PeerConnection Server 的配置信息
@@ -142,6 +216,8 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// 远端描述设置后才能添加 server 的候选
+	trickleICE.Start()
 
 	// ========== 第八步：创建 Answer（应答） ==========
 	// Answer 是 Client 对 Offer 的回应，包含 Client 支持的编解码器和网络地址
@@ -153,6 +229,7 @@ func main() {
 	// ========== 第九步：等待 ICE 候选收集完成 ==========
 	// ICE 候选是 WebRTC 发现的可能用于建立连接的网络地址
 	// 我们需要等待所有候选收集完成，才能生成完整的 Answer
+	// -trickle 时不等待，候选在收集到时由 trickleICE 逐个发送
 	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
 
 	// 设置本地会话描述，这会启动 UDP 监听器，开始收集 ICE 候选
@@ -163,12 +240,21 @@ func main() {
 
 	// 阻塞直到 ICE 候选收集完成
 	// 这确保了 Answer 中包含所有可用的网络地址信息
-	<-gatherComplete
+	if trickleICE == nil {
+		<-gatherComplete
+	}
 
 	// ========== 第十步：输出 Answer ==========
 	// 将 Answer 编码为 base64 字符串，发送回 Server
 	answerStr := encode(peerConnection.LocalDescription()) // 使用公共函数
-	if *answerFile != "" {
+	if *signalURL != "" {
+		// 发送到 sdp-bridge 房间，由 server 取走
+		if err := postSignal(*signalURL, "answer", answerStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		trickleICE.SignalSent()
+	} else if *answerFile != "" {
 		// 写入文件（用于自动化脚本）
 		err := os.WriteFile(*answerFile, []byte(answerStr+"\n"), 0644)
 		if err != nil {
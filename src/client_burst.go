@@ -23,15 +23,116 @@ import (
 
 func main() {
 	// ========== 参数解析 ==========
-	outputFile := flag.String("output", "", "Output video file (H.264 Annex-B). If empty and -session-dir is set, defaults to <session-dir>/received.h264")
-	localIP := flag.String("ip", "", "Local IP address (e.g., 192.168.100.2). If not specified, auto-detect")
+	outputFile := flag.String("output", "", "Output video file (H.264 Annex-B, or MP4 with timestamps from RTP when the name ends in .mp4), or - to write the stream to stdout for piping (e.g. | ffplay -; requires -answer-file or -signal-url). If empty and -session-dir is set, defaults to <session-dir>/received.h264")
+	localIP := flag.String("ip", "", "Local IP address (e.g., 192.168.100.2). If not specified, auto-select the best interface address; 'any' gathers on all interfaces")
 	offerFile := flag.String("offer-file", "", "Path to file containing offer (optional, if not specified, read from stdin)")
 	answerFile := flag.String("answer-file", "", "Path to file to write answer (optional, if not specified, write to stdout)")
+	signalURL := flag.String("signal-url", "", "Exchange SDP through an sdp-bridge room instead of files or stdin/stdout: poll <url>/offer and POST the answer to <url>/answer (e.g. http://bridge.example.com:8080/demo), or with a ws:// / wss:// URL receive the offer and send the answer over the room's WebSocket")
 	sessionDir := flag.String("session-dir", "", "Session directory for this experiment (optional, used mainly by scripts)")
 	maxDuration := flag.Duration("max-duration", 0, "Maximum recording duration (e.g., 30s, 5m). 0 means unlimited")
 	maxSize := flag.Int64("max-size", 0, "Maximum file size (MB). 0 means unlimited")
+	flag.DurationVar(&jitterBufferDelay, "jitter-buffer", 0, "Reorder video RTP packets by sequence number before depacketizing: in-order packets pass straight through, packets behind a gap wait up to this long (e.g. 50ms) for the missing ones (0 = write in arrival order)")
+	flag.Var(&playoutPolicy, "playout", "Playout policy presetting the jitter buffer: low-latency (wait 20ms for missing packets), smooth (200ms) or adaptive (start at 50ms, then follow 4x the RFC 3550 interarrival jitter within 10-300ms, growing when packets arrive too late). With -session-dir, buffer depth and wait are sampled to <session-dir>/playout.csv and summarized in the metrics summary. Cannot be combined with -jitter-buffer")
+	nackOn := flag.Bool("nack", true, "Send RTCP NACKs for video sequence-number gaps (each missing packet is requested right away and up to 3 times, 100ms apart, for at most 1s); -nack=false sends none, so losses are only repaired by keyframes")
+	bitrateWindow := defaultBitrateWindowConfig()
+	flag.DurationVar(&bitrateWindow.Duration, "bitrate-window", bitrateWindow.Duration, "Sliding window for effective bitrate (longer = smoother, slower to react)")
+	flag.DurationVar(&bitrateWindow.MinSpan, "bitrate-window-min-span", bitrateWindow.MinSpan, "Minimum time span inside the window before a bitrate is computed")
+	flag.IntVar(&bitrateWindow.MinFrames, "bitrate-window-min-frames", bitrateWindow.MinFrames, "Minimum frames inside the window before a bitrate is computed")
+	qualityThresholds := defaultQualityThresholds()
+	flag.Func("quality-latency-ms", "Average latency limits for Excellent,Good,Fair quality (default "+formatThresholdList(qualityThresholds.LatencyMs)+")", func(s string) error {
+		return parseThresholdList(s, &qualityThresholds.LatencyMs)
+	})
+	flag.Func("quality-stall-rate", "Stall rate limits (0-1) for Excellent,Good,Fair quality (default "+formatThresholdList(qualityThresholds.StallRate)+")", func(s string) error {
+		return parseThresholdList(s, &qualityThresholds.StallRate)
+	})
+	flag.Func("quality-loss-rate", "Frame loss limits (0-1) for Excellent,Good,Fair quality (default "+formatThresholdList(qualityThresholds.LossRate)+")", func(s string) error {
+		return parseThresholdList(s, &qualityThresholds.LossRate)
+	})
+	startCode := flag.String("start-code", "4", "Annex-B start code written before each NAL: 4 (always 00 00 00 01), 3 (always 00 00 01) or spec (4 bytes for SPS/PPS/IDR, 3 otherwise). Frame size and bitrate metrics count the bytes actually written")
+	flag.BoolVar(&abortOnFirstError, "abort-on-first-error", false, "Strict mode: exit immediately on recoverable pipeline errors (decode/scale/encode/write) instead of logging and continuing")
+	printSDPCaps := flag.Bool("print-sdp-capabilities", false, "Print supported codecs, RTCP feedback and header extensions from a dummy offer, then exit")
+	rtcpLog := flag.Bool("rtcp-log", false, "Log every sent/received RTCP packet with parsed fields to <session-dir>/rtcp_client.csv (requires -session-dir)")
+	resourceUsage := flag.Bool("resource-usage", false, "Sample process CPU time and RSS every second to <session-dir>/resource_usage_client.csv and print mean/peak at exit (requires -session-dir)")
+	webrtcStats := flag.Bool("webrtc-stats", false, "Poll getStats() every 200ms and write inbound-rtp (packets received/lost, jitter) and candidate-pair (RTT) stats to <session-dir>/webrtc_stats.csv (requires -session-dir)")
+	pprofAddr := flag.String("pprof", "", "Serve net/http/pprof on this address (e.g. :6060) to profile CPU and allocations during the session")
+	statsInterval := flag.Duration("stats-interval", 0, "Print one aggregate health line (frames received, receive bitrate, end-to-end latency and RTP loss, reconnects) every interval, e.g. 10s (0 = disabled)")
+	frameHash := flag.Bool("frame-hash", false, "Write a SHA-256 of every received frame's NAL units to <session-dir>/frame_hashes_client.csv; diff against the other side to verify a lossless round trip (requires -session-dir)")
+	flag.BoolVar(&verifyParamSets, "verify-param-sets", false, "Log every received SPS/PPS that differs from the cached one and print a summary at exit. Changed parameter sets are always re-inserted before the next IDR if it arrives without them")
+	compat := flag.String("compat", "none", "Emulate a limited receiver: none, or constrained-baseline to register only profile-level-id 42e01f so the answer accepts nothing above constrained baseline")
+	sinceKeyframe := flag.Bool("since-keyframe", false, "Measure recovery latency from each detected RTP loss to the next completely received keyframe; per-event rows go to <session-dir>/keyframe_recovery.csv and mean/p95/max to the metrics summary (requires -session-dir)")
+	clockDriftOn := flag.Bool("clock-drift", false, "Estimate server/client clock drift from RTCP sender reports (slope of the per-10s minimum of arrival minus SR time, after 60s) and subtract the accumulated drift from end-to-end latency; per-window rows go to <session-dir>/clock_drift.csv and the rate to the metrics summary (requires -session-dir)")
+	firstKeyframeTimeout := flag.Duration("first-keyframe-timeout", 10*time.Second, "Give up with a \"no keyframe received\" error (exit status 1) if the video track has not delivered an IDR this long after it started; after 1s without one, PLIs are sent every 500ms and FIRs are added after half the timeout (0 = wait forever)")
+	outputRawYUV := flag.String("output-raw-yuv", "", "Also decode the received video and write every decodable frame as raw YUV420P (at the first frame's resolution) to this file, with a <file>.json sidecar giving dimensions, frame rate and ffmpeg input options. Costs a full H.264 decode on the receive path")
+	dumpRTP := flag.Bool("dump-rtp", false, "Record every received video RTP packet with its arrival time to <session-dir>/rtp_dump.bin, so metrics can be recomputed later with -replay-metadata (requires -session-dir)")
+	replayDir := flag.String("replay-metadata", "", "Offline mode: replay <dir>/rtp_dump.bin (from -dump-rtp) against <dir>/frame_metadata.csv, write client_metrics.csv and the metrics summary to -replay-output-dir, then exit without connecting. -bitrate-window*, -quality-* and -start-code apply to the replay")
+	replayOutputDir := flag.String("replay-output-dir", "", "Output directory for -replay-metadata (default <replay dir>/replay; must differ from the replayed directory)")
+	replayStallFactor := flag.Float64("replay-stall-factor", 2, "For -replay-metadata: a frame is a stall when its inter-frame interval exceeds this multiple of the normal frame interval (live runs use 2)")
+	replayWarmup := flag.Duration("replay-warmup", 0, "For -replay-metadata: exclude frames received during this long after the first frame from the summary, e.g. 5s")
 	flag.Parse()
 
+	if bitrateWindow.Duration <= 0 || bitrateWindow.MinSpan < 0 || bitrateWindow.MinFrames < 2 {
+		fmt.Fprintf(os.Stderr, "Error: -bitrate-window must be > 0, -bitrate-window-min-span >= 0 and -bitrate-window-min-frames >= 2\n")
+		os.Exit(1)
+	}
+	startCodeMode, err := parseStartCodeMode(*startCode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -start-code: %v\n", err)
+		os.Exit(1)
+	}
+	if h264Compat, err = parseH264Compat(*compat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -compat: %v\n", err)
+		os.Exit(1)
+	}
+	if *signalURL != "" && (*offerFile != "" || *answerFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: -signal-url cannot be combined with -offer-file or -answer-file\n")
+		os.Exit(1)
+	}
+	if *outputFile == stdoutOutput && *answerFile == "" && *signalURL == "" {
+		fmt.Fprintf(os.Stderr, "Error: -output - requires -answer-file or -signal-url (otherwise the answer is printed to stdout with the video)\n")
+		os.Exit(1)
+	}
+	if jitterBufferDelay < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -jitter-buffer must be >= 0\n")
+		os.Exit(1)
+	}
+	if err := applyPlayoutPolicy(flagPassed("jitter-buffer")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *firstKeyframeTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -first-keyframe-timeout must be >= 0\n")
+		os.Exit(1)
+	}
+	firstKeyframe = NewFirstKeyframeWatch(*firstKeyframeTimeout)
+	// 最先注册、最后执行：其它 defer 关闭日志文件之后再以非零状态退出
+	defer func() {
+		if err := firstKeyframe.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	if *replayDir != "" {
+		runReplayMetrics(ReplayConfig{
+			SessionDir:    *replayDir,
+			OutputDir:     *replayOutputDir,
+			StallFactor:   *replayStallFactor,
+			Warmup:        *replayWarmup,
+			BitrateWindow: bitrateWindow,
+			StartCodeMode: startCodeMode,
+			Thresholds:    qualityThresholds,
+		})
+		return
+	}
+
+	if *printSDPCaps {
+		if err := printSDPCapabilities(*localIP, 50100, 50200, newSDPCapabilitiesAPI); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing SDP capabilities: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *sessionDir != "" {
 		if err := os.MkdirAll(*sessionDir, 0o755); err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating session directory: %v\n", err)
@@ -39,6 +140,126 @@ func main() {
 		}
 	}
 
+	var rtcpLogger *RTCPLogger
+	if *rtcpLog {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -rtcp-log requires -session-dir\n")
+			os.Exit(1)
+		}
+		logger, lErr := NewRTCPLogger(filepath.Join(*sessionDir, "rtcp_client.csv"))
+		if lErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating RTCP log: %v\n", lErr)
+			os.Exit(1)
+		}
+		rtcpLogger = logger
+		defer rtcpLogger.Close()
+	}
+
+	if *pprofAddr != "" {
+		if pErr := startPprofServer(*pprofAddr); pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error starting pprof server: %v\n", pErr)
+			os.Exit(1)
+		}
+	}
+
+	if *statsInterval > 0 {
+		healthStats = NewHealthStats("client")
+	}
+
+	if *resourceUsage {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -resource-usage requires -session-dir\n")
+			os.Exit(1)
+		}
+		monitor, mErr := NewResourceMonitor(filepath.Join(*sessionDir, "resource_usage_client.csv"))
+		if mErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating resource usage log: %v\n", mErr)
+			os.Exit(1)
+		}
+		defer monitor.Close()
+	}
+
+	if *webrtcStats && *sessionDir == "" {
+		fmt.Fprintf(os.Stderr, "Error: -webrtc-stats requires -session-dir\n")
+		os.Exit(1)
+	}
+
+	if *frameHash {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -frame-hash requires -session-dir\n")
+			os.Exit(1)
+		}
+		var hErr error
+		receivedFrameHashes, hErr = NewFrameHashWriter(filepath.Join(*sessionDir, "frame_hashes_client.csv"))
+		if hErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating frame hash log: %v\n", hErr)
+			os.Exit(1)
+		}
+		defer receivedFrameHashes.Close()
+	}
+
+	if *sinceKeyframe {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -since-keyframe requires -session-dir\n")
+			os.Exit(1)
+		}
+		var kErr error
+		keyframeRecovery, kErr = NewKeyframeRecoveryTracker(filepath.Join(*sessionDir, "keyframe_recovery.csv"))
+		if kErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating keyframe recovery log: %v\n", kErr)
+			os.Exit(1)
+		}
+		defer keyframeRecovery.Close()
+	}
+
+	if jitterBufferDelay > 0 && *sessionDir != "" {
+		var pErr error
+		playoutLog, pErr = NewPlayoutLog(filepath.Join(*sessionDir, "playout.csv"), playoutPolicy.label())
+		if pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating playout log: %v\n", pErr)
+			os.Exit(1)
+		}
+		defer playoutLog.Close()
+	}
+
+	if *clockDriftOn {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -clock-drift requires -session-dir\n")
+			os.Exit(1)
+		}
+		var cErr error
+		clockDrift, cErr = NewClockDriftEstimator(filepath.Join(*sessionDir, "clock_drift.csv"))
+		if cErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating clock drift log: %v\n", cErr)
+			os.Exit(1)
+		}
+		defer clockDrift.Close()
+	}
+
+	if *dumpRTP {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -dump-rtp requires -session-dir\n")
+			os.Exit(1)
+		}
+		var dErr error
+		rtpDump, dErr = NewRTPDumpWriter(filepath.Join(*sessionDir, "rtp_dump.bin"))
+		if dErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating RTP dump: %v\n", dErr)
+			os.Exit(1)
+		}
+		defer rtpDump.Close()
+	}
+
+	if *outputRawYUV != "" {
+		var yErr error
+		rawYUV, yErr = NewRawYUVWriter(*outputRawYUV)
+		if yErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating raw YUV output: %v\n", yErr)
+			os.Exit(1)
+		}
+		defer rawYUV.Close()
+	}
+
 	// 输出文件默认：session-dir/received.h264
 	if *outputFile == "" {
 		if *sessionDir != "" {
@@ -57,24 +278,65 @@ func main() {
 		ICEServers: []webrtc.ICEServer{},
 	}
 
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	// 视频丢包的 NACK 由 nackSender 发送，newWebRTCAPI 不再注册 pion 的 NACK generator（见 nack_sender.go）
+	nackSender = NewNackSender(*nackOn)
+	api, err := newWebRTCAPI(settingEngine, rtcpLogger)
+	if err != nil {
+		panic(err)
+	}
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
 		panic(err)
 	}
+	nackSender.Attach(peerConnection)
+	defer nackSender.Report()
 	defer func() {
 		if cErr := peerConnection.Close(); cErr != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", cErr)
 		}
 	}()
 
+	// pion 自己统计的丢包、抖动与 RTT（-webrtc-stats），与按帧计算的指标交叉对照
+	if *webrtcStats {
+		statsLogger, sErr := NewWebRTCStatsLogger(filepath.Join(*sessionDir, "webrtc_stats.csv"), peerConnection)
+		if sErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating webrtc stats log: %v\n", sErr)
+			os.Exit(1)
+		}
+		defer statsLogger.Close()
+	}
+
 	// 用于在接收协程结束时通知 main 退出
 	var recvOnce sync.Once
 	recvDone := make(chan struct{})
 
+	// A/V skew 统计：依赖 server 发送音频以及 RTCP SR，缺少任一项时不会产生样本
+	var avSync *AVSyncTracker
+	if *sessionDir != "" {
+		if avSync, err = NewAVSyncTracker(filepath.Join(*sessionDir, "av_sync.csv")); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to create A/V sync tracker: %v\n", err)
+		} else {
+			defer avSync.Close()
+		}
+	}
+
 	// ========== 事件处理 ==========
-	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	// frameRate 在读取 offer 后设置，OnTrack 只会在 SetRemoteDescription 之后触发
+	var frameRate float64
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		// 视频轨道额外等待 server 的结束标记（RTCP BYE），RTCP 统一在一个循环中读取
+		var eos *EndOfStreamWatcher
 		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			eos = newEndOfStreamWatcher(track)
+		}
+		if avSync != nil || eos != nil {
+			go readReceiverRTCP(receiver, avSync, eos)
+		}
+
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			// 第一个关键帧到达之前加快发送关键帧请求
+			go firstKeyframe.Escalate(peerConnection, track.SSRC())
+
 			// 定期发送 PLI，确保 server 端周期性发送关键帧
 			go func() {
 				ticker := time.NewTicker(time.Second * 3)
@@ -85,7 +347,7 @@ func main() {
 					}
 					rtcpSendErr := peerConnection.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}})
 					if rtcpSendErr != nil {
-						if strings.Contains(rtcpSendErr.Error(), "closed") {
+						if isConnectionClosed(rtcpSendErr) {
 							return
 						}
 						fmt.Fprintf(os.Stderr, "Error sending RTCP PLI: %v\n", rtcpSendErr)
@@ -100,13 +362,14 @@ func main() {
 		if codecName == "h264" {
 			// 在单独的 goroutine 中接收并写文件，结束后通知 main
 			go func() {
-				// 默认帧率 30 fps
-				frameRate := 30.0
-				writeH264ToFile(track, *outputFile, *maxDuration, *maxSize, *sessionDir, frameRate)
+				writeH264ToFile(track, *outputFile, *maxDuration, *maxSize, *sessionDir, frameRate, avSync, bitrateWindow, startCodeMode, nil, eos)
 				recvOnce.Do(func() {
 					close(recvDone)
 				})
 			}()
+		} else if track.Kind() == webrtc.RTPCodecTypeAudio && avSync != nil {
+			// 音频只用于 A/V skew 统计，不写文件
+			go readAudioForAVSync(track, avSync)
 		} else {
 			fmt.Fprintf(os.Stderr, "Unsupported codec: %s, only H264 is supported\n", codecName)
 		}
@@ -115,6 +378,7 @@ func main() {
 	// 设置连接状态监听，当连接断开时主动关闭 peerConnection，使 ReadRTP() 返回错误
 	setupPeerConnectionHandlers(peerConnection, nil, func(connectionState webrtc.ICEConnectionState) {
 		fmt.Fprintf(os.Stderr, "ICE Connection State: %s\n", connectionState.String())
+		healthStats.OnICEConnectionState(connectionState)
 		if connectionState == webrtc.ICEConnectionStateFailed || connectionState == webrtc.ICEConnectionStateDisconnected || connectionState == webrtc.ICEConnectionStateClosed {
 			fmt.Fprintf(os.Stderr, "[BurstRTC Client] ICE connection closed/disconnected/failed, closing peer connection...\n")
 			if err := peerConnection.Close(); err != nil {
@@ -135,7 +399,12 @@ func main() {
 	offer := webrtc.SessionDescription{}
 	var offerStr string
 
-	if *offerFile != "" {
+	if *signalURL != "" {
+		offerStr = pollSignal(*signalURL, "offer")
+		if offerStr == "" {
+			os.Exit(1)
+		}
+	} else if *offerFile != "" {
 		fmt.Fprintf(os.Stderr, "Reading offer from file: %s\n", *offerFile)
 		offerStr = readFromFile(*offerFile)
 		if offerStr == "" {
@@ -147,6 +416,8 @@ func main() {
 	}
 
 	decode(offerStr, &offer)
+	// 源帧率由 server 写入 offer（a=framerate），用于卡顿阈值与码率计算
+	frameRate = offerFrameRate(offer)
 
 	if err = peerConnection.SetRemoteDescription(offer); err != nil {
 		panic(err)
@@ -168,7 +439,12 @@ func main() {
 
 	answerStr := encode(peerConnection.LocalDescription())
 
-	if *answerFile != "" {
+	if *signalURL != "" {
+		if err = postSignal(*signalURL, "answer", answerStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *answerFile != "" {
 		if err = os.WriteFile(*answerFile, []byte(answerStr+"\n"), 0o644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing answer to file: %v\n", err)
 			os.Exit(1)
@@ -180,13 +456,21 @@ func main() {
 
 	// ========== 等待接收协程结束 ==========
 	fmt.Fprintf(os.Stderr, "Waiting for receive loop to finish...\n")
+	stopHealth := healthStats.Start(*statsInterval)
 	<-recvDone
+	stopHealth()
 	fmt.Fprintf(os.Stderr, "Receive loop finished\n")
 
 	// ========== 计算汇总统计 ==========
 	if *sessionDir != "" {
 		csvPath := filepath.Join(*sessionDir, "client_metrics.csv")
-		if summary, err := CalculateSummaryMetrics(csvPath); err == nil {
+		if summary, err := CalculateSummaryMetrics(csvPath, qualityThresholds); err == nil {
+			summary.AVSkewMeanMs, summary.AVSkewMaxMs, summary.AVSyncSamples = avSync.Stats()
+			summary.RecoveryEvents, summary.RecoveryMeanMs, summary.RecoveryP95Ms, summary.RecoveryMaxMs, summary.RecoveryPending = keyframeRecovery.Stats()
+			summary.FirstKeyframeMs, _ = firstKeyframe.Elapsed()
+			summary.ClockDriftPPM, summary.ClockDriftCorrectionMs, _ = clockDrift.Stats()
+			summary.DroppedFragmentNALs, summary.DroppedFragments = fragmentDrops.Stats()
+			summary.Playout = playoutLog.Summary()
 			if err := WriteSummaryMetrics(summary, *sessionDir); err != nil {
 				fmt.Fprintf(os.Stderr, "Warning: Failed to write summary metrics: %v\n", err)
 			} else {
@@ -196,6 +480,35 @@ func main() {
 				fmt.Fprintf(os.Stderr, "P99 Latency: %.3f ms\n", summary.P99LatencyMs)
 				fmt.Fprintf(os.Stderr, "Stall Rate: %.2f%% (%d frames)\n", summary.StallRate*100.0, summary.TotalStallFrames)
 				fmt.Fprintf(os.Stderr, "Effective Bitrate: %.2f kbps\n", summary.EffectiveBitrateKbps)
+				if summary.SentFrames > 0 {
+					fmt.Fprintf(os.Stderr, "Frame Loss: %.2f%% (%d of %d sent)\n", summary.FrameLossRate*100.0, summary.LostFrames, summary.SentFrames)
+				}
+				if summary.AVSyncSamples > 0 {
+					fmt.Fprintf(os.Stderr, "A/V Skew: mean %.3f ms, max %.3f ms (%d samples)\n", summary.AVSkewMeanMs, summary.AVSkewMaxMs, summary.AVSyncSamples)
+				}
+				if summary.RecoveryEvents > 0 {
+					fmt.Fprintf(os.Stderr, "Keyframe Recovery: mean %.1f ms, p95 %.1f ms, max %.1f ms (%d events)\n", summary.RecoveryMeanMs, summary.RecoveryP95Ms, summary.RecoveryMaxMs, summary.RecoveryEvents)
+				}
+				if summary.RecoveryPending {
+					fmt.Fprintf(os.Stderr, "Keyframe Recovery: stream ended before the last loss was recovered\n")
+				}
+				if summary.FirstKeyframeMs > 0 {
+					fmt.Fprintf(os.Stderr, "First Keyframe: %.1f ms after track start\n", summary.FirstKeyframeMs)
+				}
+				if summary.ClockDriftPPM != 0 {
+					fmt.Fprintf(os.Stderr, "Clock Drift: %.2f ppm (latency corrected by %.1f ms at the end)\n", summary.ClockDriftPPM, summary.ClockDriftCorrectionMs)
+				}
+				if summary.DroppedFragments > 0 {
+					fmt.Fprintf(os.Stderr, "Fragment Loss: %d incomplete NAL units dropped (%d fragments)\n", summary.DroppedFragmentNALs, summary.DroppedFragments)
+				}
+				if p := summary.Playout; p != nil {
+					fmt.Fprintf(os.Stderr, "Playout (%s): wait mean %.1f ms (%.1f-%.1f), depth mean %.2f / max %d packets, %d late, %d skipped\n",
+						p.Policy, p.DelayMeanMs, p.DelayMinMs, p.DelayMaxMs, p.DepthMean, p.DepthMax, p.LatePackets, p.SkippedPackets)
+				}
+				fmt.Fprintf(os.Stderr, "Connection Quality: %s\n", summary.Quality)
+				for _, reason := range summary.QualityReasons {
+					fmt.Fprintf(os.Stderr, "  - %s\n", reason)
+				}
 				fmt.Fprintf(os.Stderr, "======================\n\n")
 			}
 		} else {
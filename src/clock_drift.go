@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// clock_drift.go - 用 RTCP Sender Report 估计收发两端的时钟漂移并修正端到端延迟（client 的 -clock-drift）
+//
+// 说明：
+//   - 端到端延迟按 server 的 start_time.txt 换算，相当于假设两端时钟只有开始时的固定偏移；
+//     跨主机的长时间实验中两端时钟按各自的频率走，偏移逐渐变化，延迟会随时间单调偏高或偏低
+//   - 每个 SR 带有 server 发出时的 NTP 时间：到达时间 - SR 时间 = 单程时延 + 两端时钟差。
+//     每 10 秒取一次最小值（排队时延最小的那个 SR），时延的抖动基本被滤掉，剩下的变化来自时钟漂移
+//   - 累计 60 秒以上的窗口后，用最小二乘求窗口最小值随时间的斜率，即漂移率（ppm）；
+//     端到端延迟减去 "漂移率 × 距第一个 SR 的时间"，第一个 SR 之前的固定偏移不变
+//   - 同一台机器上收发时漂移率应接近 0，可用来检查估计本身的噪声
+//   - 每个窗口写入 <session-dir>/clock_drift.csv，漂移率写入 metrics_summary 的 clock_drift_ppm
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	// clockDriftWindow 是取最小偏移的窗口长度
+	clockDriftWindow = 10 * time.Second
+	// clockDriftMinSpan 是开始修正之前窗口最小值需要覆盖的时间
+	clockDriftMinSpan = time.Minute
+)
+
+// clockDrift 在 -clock-drift 开启时非 nil，由 readReceiverRTCP 与 computeFrameLatency 调用
+var clockDrift *ClockDriftEstimator
+
+// driftPoint 是一个窗口内的最小偏移
+type driftPoint struct {
+	at       time.Time // 取得最小值的 SR 的到达时间
+	offsetMs float64   // 到达时间 - SR 的 NTP 时间（毫秒）
+}
+
+// ClockDriftEstimator 估计两端时钟的漂移率，方法对 nil 安全
+type ClockDriftEstimator struct {
+	mu sync.Mutex
+
+	reference   time.Time // 第一个 SR 的到达时间，修正量从这里开始累计
+	windowStart time.Time
+	windowMin   driftPoint
+	haveWindow  bool
+	points      []driftPoint
+
+	slope      float64 // 毫秒 / 秒，正值表示 client 的时钟比 server 快
+	haveSlope  bool
+	reports    int
+	correction float64 // 最近一次计算的修正量（毫秒）
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewClockDriftEstimator 创建估计器，并把每个窗口写入 csvPath
+func NewClockDriftEstimator(csvPath string) (*ClockDriftEstimator, error) {
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clock drift csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"window_unix_ms", "min_offset_ms", "drift_ppm", "correction_ms"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write clock drift header: %w", err)
+	}
+	w.Flush()
+	return &ClockDriftEstimator{writer: w, file: f}, nil
+}
+
+// OnSenderReport 处理一个 SR，arrival 为到达时间
+func (e *ClockDriftEstimator) OnSenderReport(sr *rtcp.SenderReport, arrival time.Time) {
+	if e == nil || sr == nil || sr.NTPTime == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.reports++
+	point := driftPoint{at: arrival, offsetMs: float64(arrival.Sub(ntpToTime(sr.NTPTime))) / float64(time.Millisecond)}
+	if e.reference.IsZero() {
+		e.reference = arrival
+	}
+	if !e.haveWindow {
+		e.windowStart, e.windowMin, e.haveWindow = arrival, point, true
+		return
+	}
+	if arrival.Sub(e.windowStart) < clockDriftWindow {
+		if point.offsetMs < e.windowMin.offsetMs {
+			e.windowMin = point
+		}
+		return
+	}
+
+	// 窗口结束：记录最小值，重新估计斜率，当前 SR 开始下一个窗口
+	e.points = append(e.points, e.windowMin)
+	e.estimate()
+	e.writeWindow(e.windowMin)
+	e.windowStart, e.windowMin = arrival, point
+}
+
+// estimate 用窗口最小值的最小二乘斜率更新漂移率（调用方需持有 e.mu）
+func (e *ClockDriftEstimator) estimate() {
+	if len(e.points) < 3 || e.points[len(e.points)-1].at.Sub(e.points[0].at) < clockDriftMinSpan {
+		return
+	}
+	var sumX, sumY, sumXX, sumXY float64
+	for _, p := range e.points {
+		x := p.at.Sub(e.reference).Seconds()
+		sumX += x
+		sumY += p.offsetMs
+		sumXX += x * x
+		sumXY += x * p.offsetMs
+	}
+	n := float64(len(e.points))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	if !e.haveSlope {
+		fmt.Fprintf(os.Stderr, "[Clock] Drift estimate available: %.2f ppm after %d windows\n", slope*1000, len(e.points))
+	}
+	e.slope, e.haveSlope = slope, true
+}
+
+// writeWindow 写入一个窗口的记录（调用方需持有 e.mu）
+func (e *ClockDriftEstimator) writeWindow(p driftPoint) {
+	driftField, correctionField := "", ""
+	if e.haveSlope {
+		driftField = fmt.Sprintf("%.3f", e.slope*1000)
+		correctionField = fmt.Sprintf("%.3f", e.slope*p.at.Sub(e.reference).Seconds())
+	}
+	if err := e.writer.Write([]string{
+		fmt.Sprintf("%d", p.at.UnixMilli()),
+		fmt.Sprintf("%.3f", p.offsetMs),
+		driftField,
+		correctionField,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing clock drift CSV: %v\n", err)
+	}
+	e.writer.Flush()
+}
+
+// Correction 返回 at 时刻端到端延迟中由时钟漂移造成的部分（毫秒），应从延迟中减去；估计可用之前为 0
+func (e *ClockDriftEstimator) Correction(at time.Time) float64 {
+	if e == nil {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.haveSlope {
+		return 0
+	}
+	e.correction = e.slope * at.Sub(e.reference).Seconds()
+	return e.correction
+}
+
+// Stats 返回漂移率（ppm）与最近一次修正量（毫秒）；估计不可用时 ok 为 false
+func (e *ClockDriftEstimator) Stats() (ppm, correctionMs float64, ok bool) {
+	if e == nil {
+		return 0, 0, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.slope * 1000, e.correction, e.haveSlope
+}
+
+// Close 打印汇总并关闭 CSV 文件
+func (e *ClockDriftEstimator) Close() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if e.haveSlope {
+		fmt.Fprintf(os.Stderr, "[Clock] Drift %.2f ppm from %d windows (%d sender reports), last latency correction %.1f ms\n",
+			e.slope*1000, len(e.points), e.reports, e.correction)
+	} else {
+		fmt.Fprintf(os.Stderr, "[Clock] Not enough sender reports for a drift estimate (%d reports, %d windows; need %v)\n",
+			e.reports, len(e.points), clockDriftMinSpan)
+	}
+	e.mu.Unlock()
+	e.writer.Flush()
+	if err := e.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing clock drift CSV file: %v\n", err)
+	}
+}
@@ -15,8 +15,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -122,10 +124,12 @@ func readUntilNewline() (in string) {
 	return
 }
 
-// readFromFile 从文件读取内容，如果文件不存在或为空，会定期检查直到超时
+// readFromFile 从文件读取内容，如果文件不存在或为空，会等待直到超时
 //
 // 这个函数用于自动化脚本：server 等待 client 将 answer 写入文件
-// 如果文件不存在或为空，函数会每 500ms 检查一次，最多等待 60 秒
+// Linux 上用 inotify 监视文件所在的目录（见 file_watch_linux.go），文件写完后立即读取，并每 5 秒补查一次以防漏掉事件；
+// 无法监视时（目录不存在、其它平台）退回每 500ms 检查一次。最多等待 60 秒
+// 文件是命名管道（mkfifo）时直接读取，直到写入端关闭
 //
 // 使用场景：
 //   - Server 使用 -answer-file 参数时，会调用这个函数等待 client 写入 answer
@@ -134,6 +138,18 @@ func readFromFile(filePath string) (in string) {
 	deadline := time.Now().Add(60 * time.Second)
 	pollInterval := 500 * time.Millisecond
 
+	if info, err := os.Stat(filePath); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		return readFromPipe(filePath, deadline)
+	}
+
+	watch, err := newFileWatch(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot watch %s for changes (%v), polling every %v\n", filePath, err, pollInterval)
+	} else {
+		defer watch.Close()
+		pollInterval = 5 * time.Second
+	}
+
 	for time.Now().Before(deadline) {
 		// Check if file exists and has content
 		data, err := os.ReadFile(filePath)
@@ -146,14 +162,45 @@ func readFromFile(filePath string) (in string) {
 		}
 
 		// Wait before next check
-		time.Sleep(pollInterval)
-		fmt.Fprintf(os.Stderr, "Waiting for answer file... (timeout in %v)\n", deadline.Sub(time.Now()).Round(time.Second))
+		wait := min(pollInterval, time.Until(deadline))
+		if watch == nil {
+			time.Sleep(wait)
+		} else if watch.Wait(wait) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Waiting for answer file... (timeout in %v)\n", time.Until(deadline).Round(time.Second))
 	}
 
 	fmt.Fprintf(os.Stderr, "Error: Timeout waiting for answer file: %s\n", filePath)
 	return ""
 }
 
+// readFromPipe 从命名管道读取一次写入的全部内容（写入端关闭时结束），超过 deadline 返回空串
+func readFromPipe(filePath string, deadline time.Time) string {
+	fmt.Fprintf(os.Stderr, "Waiting for a writer on named pipe %s...\n", filePath)
+	result := make(chan string, 1)
+	go func() {
+		// 没有写入端时 open 会一直阻塞；超时后这个协程随进程退出
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading named pipe %s: %v\n", filePath, err)
+			result <- ""
+			return
+		}
+		result <- strings.TrimSpace(string(data))
+	}()
+	select {
+	case in := <-result:
+		if in != "" {
+			fmt.Fprintf(os.Stderr, "Answer read from pipe (%d bytes)\n", len(in))
+		}
+		return in
+	case <-time.After(time.Until(deadline)):
+		fmt.Fprintf(os.Stderr, "Error: Timeout waiting for answer on named pipe: %s\n", filePath)
+		return ""
+	}
+}
+
 // setupWebRTCSettingEngine 配置 WebRTC 的 SettingEngine（设置引擎）
 //
 // SettingEngine 用于配置 WebRTC 的各种参数，比如：
@@ -163,7 +210,7 @@ func readFromFile(filePath string) (in string) {
 //
 // 参数：
 //   - settingEngine: 要配置的 SettingEngine 对象（会被修改）
-//   - localIP: 本地 IP 地址（可选，为空则自动检测）
+//   - localIP: 本地 IP 地址（可选，为空则自动选择最合适的网卡地址，"any" 表示在所有网卡上收集候选）
 //   - portRangeStart: UDP 端口范围起始值
 //   - portRangeEnd: UDP 端口范围结束值
 //
@@ -200,18 +247,183 @@ func setupWebRTCSettingEngine(settingEngine *webrtc.SettingEngine, localIP strin
 	// 为什么要指定 IP？
 	// - 在局域网环境中（比如使用虚拟网卡对），需要明确告诉 WebRTC 使用哪个 IP
 	// - 如果不指定，WebRTC 可能检测到多个 IP（比如 127.0.0.1、192.168.x.x），导致连接失败
+	if localIP == "any" {
+		// 保留旧行为：在所有网卡上收集候选
+		fmt.Fprintf(os.Stderr, "Gathering ICE candidates on all interfaces (-ip any)\n")
+		return
+	}
 	if localIP != "" {
 		// 验证 IP 地址格式是否正确
 		ip := net.ParseIP(localIP)
-		if ip == nil {
-			fmt.Fprintf(os.Stderr, "Warning: Invalid IP address: %s, using auto-detect\n", localIP)
-		} else {
+		if ip != nil {
 			// 设置 NAT 映射：告诉 WebRTC 使用这个 IP 地址作为本地地址
 			// ICECandidateTypeHost 表示这是"主机候选"，即本机的真实 IP 地址
 			settingEngine.SetNAT1To1IPs([]string{localIP}, webrtc.ICECandidateTypeHost)
 			fmt.Fprintf(os.Stderr, "Using specified IP address: %s\n", localIP)
+			return
 		}
+		fmt.Fprintf(os.Stderr, "Warning: Invalid IP address: %s, using auto-detect\n", localIP)
 	}
+
+	// 未指定 -ip：自动选择一个最合适的本地地址，只在该地址上收集候选，
+	// 避免 ICE 选中 docker0 等虚拟网卡上的无用地址
+	chosen, rejected := selectBestLocalIP()
+	for _, r := range rejected {
+		fmt.Fprintf(os.Stderr, "Rejected local IP candidate: %s\n", r)
+	}
+	if chosen == nil {
+		fmt.Fprintf(os.Stderr, "Warning: No usable local IP found, gathering on all interfaces\n")
+		return
+	}
+	settingEngine.SetIPFilter(func(ip net.IP) bool {
+		return ip.Equal(chosen.ip)
+	})
+	settingEngine.SetNAT1To1IPs([]string{chosen.ip.String()}, webrtc.ICECandidateTypeHost)
+	fmt.Fprintf(os.Stderr, "Auto-selected local IP address: %s (%s)\n", chosen.ip, chosen.iface)
+}
+
+// virtualInterfacePrefixes 是常见虚拟网卡（容器网桥、虚拟机、VPN 隧道）的名称前缀。
+// 这些网卡上的地址只在没有其他可用地址时才会被选中。
+var virtualInterfacePrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "cni", "flannel",
+	"tun", "tap", "utun", "wg", "tailscale", "zt",
+}
+
+// localIPCandidate 是自动选择本地 IP 时的一个候选地址
+type localIPCandidate struct {
+	iface   string
+	ip      net.IP
+	virtual bool
+}
+
+// score 返回候选的优先级：物理网卡优先于虚拟网卡，IPv4 优先于 IPv6
+func (c localIPCandidate) score() int {
+	s := 0
+	if !c.virtual {
+		s += 2
+	}
+	if c.ip.To4() != nil {
+		s++
+	}
+	return s
+}
+
+// selectBestLocalIP 枚举本机网卡，选出最适合 ICE 的地址
+//
+// 过滤规则：
+//   - 跳过未启用（down）的网卡和 loopback 网卡
+//   - 跳过 link-local、loopback、多播等非单播地址
+//   - 剩余的私有/公网单播地址中，物理网卡优先，IPv4 优先，同优先级按枚举顺序取第一个
+//
+// 返回选中的候选（没有可用地址时为 nil）以及被拒绝的候选及原因（用于日志）。
+func selectBestLocalIP() (*localIPCandidate, []string) {
+	var rejected []string
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, []string{fmt.Sprintf("failed to list interfaces: %v", err)}
+	}
+
+	var best *localIPCandidate
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s: failed to list addresses: %v", iface.Name, err))
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP
+
+			switch {
+			case iface.Flags&net.FlagUp == 0:
+				rejected = append(rejected, fmt.Sprintf("%s (%s): interface down", ip, iface.Name))
+				continue
+			case iface.Flags&net.FlagLoopback != 0 || ip.IsLoopback():
+				rejected = append(rejected, fmt.Sprintf("%s (%s): loopback", ip, iface.Name))
+				continue
+			case ip.IsLinkLocalUnicast():
+				rejected = append(rejected, fmt.Sprintf("%s (%s): link-local", ip, iface.Name))
+				continue
+			case !ip.IsGlobalUnicast():
+				rejected = append(rejected, fmt.Sprintf("%s (%s): not a unicast address", ip, iface.Name))
+				continue
+			}
+
+			c := &localIPCandidate{iface: iface.Name, ip: ip}
+			for _, prefix := range virtualInterfacePrefixes {
+				if strings.HasPrefix(iface.Name, prefix) {
+					c.virtual = true
+					break
+				}
+			}
+
+			if best == nil || c.score() > best.score() {
+				if best != nil {
+					rejected = append(rejected, fmt.Sprintf("%s (%s): lower priority", best.ip, best.iface))
+				}
+				best = c
+				continue
+			}
+			reason := "lower priority"
+			if c.virtual {
+				reason = "virtual interface"
+			}
+			rejected = append(rejected, fmt.Sprintf("%s (%s): %s", c.ip, c.iface, reason))
+		}
+	}
+
+	return best, rejected
+}
+
+// defaultFrameRateFPS 是无法得到源帧率时使用的缺省帧率（server 读不到源帧率、client 的 offer 中没有 a=framerate）
+const defaultFrameRateFPS = 30
+
+// withSDPFrameRate 返回在视频 m= 段中加入 a=framerate:<fps>（RFC 4566）的 SessionDescription 副本
+//
+// 只修改发送给对端的文本，本地已经 SetLocalDescription 的描述不受影响；
+// Pion 等实现会忽略不认识的属性，因此不影响协商。
+func withSDPFrameRate(desc webrtc.SessionDescription, fps float64) (webrtc.SessionDescription, error) {
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return desc, err
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media == "video" {
+			// 保留 3 位小数（如 29.97），足够计算帧间隔
+			media.WithValueAttribute("framerate", strconv.FormatFloat(math.Round(fps*1000)/1000, 'f', -1, 64))
+		}
+	}
+	raw, err := parsed.Marshal()
+	if err != nil {
+		return desc, err
+	}
+	desc.SDP = string(raw)
+	return desc, nil
+}
+
+// offerFrameRate 返回 offer 中视频 m= 段声明的帧率（a=framerate）；
+// 没有声明或无法解析时打印警告并返回 defaultFrameRateFPS
+func offerFrameRate(offer webrtc.SessionDescription) float64 {
+	if parsed, err := offer.Unmarshal(); err == nil {
+		for _, media := range parsed.MediaDescriptions {
+			if media.MediaName.Media != "video" {
+				continue
+			}
+			if value, ok := media.Attribute("framerate"); ok {
+				if fps, convErr := strconv.ParseFloat(value, 64); convErr == nil && fps > 0 {
+					fmt.Fprintf(os.Stderr, "Source frame rate from offer: %g fps\n", fps)
+					return fps
+				}
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Warning: offer has no a=framerate, assuming %d fps for stall and bitrate metrics\n", defaultFrameRateFPS)
+	return defaultFrameRateFPS
 }
 
 // setupPeerConnectionHandlers 设置 PeerConnection 的事件处理器
@@ -292,3 +504,33 @@ func setupPeerConnectionHandlers(
 		})
 	}
 }
+
+// abortOnFirstError 对应 -abort-on-first-error（strict 模式）
+// 开启后，流水线中原本 "打印日志后继续" 的错误会立即终止进程，便于调试新的编解码器或控制器。
+// 默认关闭，保持线上运行时的容错性。
+var abortOnFirstError bool
+
+// reportRecoverableError 报告一个流水线中可恢复的错误
+//
+// 参数：
+//   - context: 错误发生的位置描述（例如 "Error scaling frame"）
+//   - err: 具体错误
+//
+// 默认只打印日志，由调用方决定 continue / break；strict 模式下打印上下文后以非 0 状态退出。
+func reportRecoverableError(context string, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", context, err)
+	if abortOnFirstError {
+		fmt.Fprintf(os.Stderr, "Aborting on first error (-abort-on-first-error): %s: %v\n", context, err)
+		os.Exit(1)
+	}
+}
+
+// sessionTimeoutChannel 返回 server 等待会话结束时使用的超时 channel
+//
+// d 为 0 表示不限时，返回 nil channel（select 中永远不会被选中）。
+func sessionTimeoutChannel(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		return nil
+	}
+	return time.After(d)
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// controller_state.go - 按固定间隔记录码率控制器的内部状态（-controller-state-interval）
+//
+// 说明：
+//   - 逐帧的 stderr 日志与编码、发送日志交错在一起，不便于画图；这里由后台协程每个间隔对控制器做一次快照，
+//     写入 controller_state.csv，得到与帧率无关的干净时间序列
+//   - 各控制器（NDTC / Salsify / BurstRTC）通过 State 方法提供快照，不适用的列填 0
+//   - 快照只读取控制器状态（各控制器自带锁），不影响发送循环
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ControllerState 是某一时刻控制器内部状态的快照
+type ControllerState struct {
+	CapacityBps    float64 // 控制器用于计算预算的容量 / 吞吐估计（平滑后）
+	EstimateBps    float64 // 最近一次外部带宽估计（NDTC 的 FDACE 估计；Salsify / BurstRTC 为 -rtcp-bwe 的网络估计，未开启时为 0）
+	BudgetBits     int     // 按当前状态计算的下一帧预算
+	WindowFrames   int     // 滑动窗口中的帧数
+	WindowMeanBits float64 // 窗口内帧大小均值
+	WindowVarBits  float64 // 窗口内帧大小方差
+	LossRate       float64 // 窗口内的丢包帧比例（没有丢包反馈的控制器为 0）
+	SendPressure   float64 // 窗口内本地发送缓冲区受压的帧比例（未开启 -send-pressure 时为 0）
+}
+
+// ControllerStateLogger 在后台按间隔调用 snapshot 并写入 CSV，方法对 nil 安全（nil 表示未开启）
+type ControllerStateLogger struct {
+	writer *csv.Writer
+	file   *os.File
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewControllerStateLogger 创建 CSV 并启动记录协程，interval 必须大于 0
+func NewControllerStateLogger(csvPath string, interval time.Duration, snapshot func() ControllerState) (*ControllerStateLogger, error) {
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create controller state csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	header := []string{
+		"unix_ms",
+		"capacity_bps",
+		"estimate_bps",
+		"budget_bits",
+		"window_frames",
+		"window_mean_bits",
+		"window_var_bits",
+		"loss_rate",
+		"send_pressure",
+	}
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write controller state header: %w", err)
+	}
+	w.Flush()
+
+	l := &ControllerStateLogger{writer: w, file: f, done: make(chan struct{})}
+	l.wg.Add(1)
+	go l.run(interval, snapshot)
+	return l, nil
+}
+
+// run 每个间隔写入一行快照
+func (l *ControllerStateLogger) run(interval time.Duration, snapshot func() ControllerState) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-ticker.C:
+			s := snapshot()
+			if err := l.writer.Write([]string{
+				fmt.Sprintf("%d", now.UnixMilli()),
+				fmt.Sprintf("%.0f", s.CapacityBps),
+				fmt.Sprintf("%.0f", s.EstimateBps),
+				fmt.Sprintf("%d", s.BudgetBits),
+				fmt.Sprintf("%d", s.WindowFrames),
+				fmt.Sprintf("%.1f", s.WindowMeanBits),
+				fmt.Sprintf("%.1f", s.WindowVarBits),
+				fmt.Sprintf("%.4f", s.LossRate),
+				fmt.Sprintf("%.4f", s.SendPressure),
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing controller state CSV: %v\n", err)
+				continue
+			}
+			l.writer.Flush()
+		}
+	}
+}
+
+// Close 停止记录并关闭文件
+func (l *ControllerStateLogger) Close() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+
+	l.writer.Flush()
+	if err := l.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing controller state CSV file: %v\n", err)
+	}
+}
@@ -0,0 +1,270 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// debug_overlay.go - 把控制器状态画进视频画面（-debug-overlay）
+//
+// 说明：
+//   - 左上角画一个滚动图：绿色柱为控制器给出的目标码率，红色横线为带宽估计，下方黄色柱为编码 QP / CRF；
+//     纵轴按窗口内最大值自动缩放。录屏演示时直接在接收画面上就能看到控制器的行为，不需要再对照 CSV
+//   - astiav v0.19 不能直接写帧数据，这里用 FFmpeg 滤镜图 buffer → drawbox × N → buffersink，
+//     每帧通过 avfilter_graph_send_command 更新各个 drawbox 的位置与高度（没有文字，不依赖字体）
+//   - 叠加在缩放之后、编码之前进行，会改变编码内容并占用额外 CPU，只用于调试与演示
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/asticode/go-astiav"
+)
+
+const (
+	debugOverlayColumns  = 48 // 滚动窗口的帧数
+	debugOverlayColWidth = 4  // 每帧一列的宽度（像素）
+	debugOverlayGraphH   = 64 // 码率图高度
+	debugOverlayQPH      = 16 // QP 条高度
+	debugOverlayMargin   = 8  // 距画面左上角的距离
+	debugOverlayMaxQP    = 51 // H.264 QP 上限
+)
+
+// debugOverlay 在 -debug-overlay 开启时非 nil，由发送循环在编码前调用
+var debugOverlay *DebugOverlay
+
+// DebugOverlaySample 是一帧的控制器状态，未知的值填 0（QP 填 -1）
+type DebugOverlaySample struct {
+	TargetBps   float64 // 控制器给出的目标码率
+	EstimateBps float64 // 带宽估计
+	QP          int     // 编码 QP / CRF
+}
+
+// DebugOverlay 在帧上画控制器状态的滚动图，方法对 nil 安全（nil 表示未开启 -debug-overlay）。
+// 滤镜图在第一次 Apply 时按帧的尺寸与像素格式创建；创建失败时打印一次日志并停用叠加。
+type DebugOverlay struct {
+	samples []DebugOverlaySample
+
+	graph    *astiav.FilterGraph
+	src      *astiav.FilterContext
+	sink     *astiav.FilterContext
+	out      *astiav.Frame
+	timeBase astiav.Rational
+	disabled bool
+
+	// 每个 drawbox 上次设置的参数，值不变时不重复发送命令
+	sent map[string]string
+}
+
+// NewDebugOverlay 创建叠加器，timeBase 为送入帧的 PTS 时间基
+func NewDebugOverlay(timeBase astiav.Rational) *DebugOverlay {
+	return &DebugOverlay{
+		samples:  make([]DebugOverlaySample, 0, debugOverlayColumns),
+		timeBase: timeBase,
+		sent:     make(map[string]string),
+	}
+}
+
+// Push 记录当前帧的控制器状态，之后的 Apply 会把它画在最右一列
+func (o *DebugOverlay) Push(s DebugOverlaySample) {
+	if o == nil {
+		return
+	}
+	if len(o.samples) == debugOverlayColumns {
+		copy(o.samples, o.samples[1:])
+		o.samples = o.samples[:debugOverlayColumns-1]
+	}
+	o.samples = append(o.samples, s)
+}
+
+// Apply 返回叠加了滚动图的帧（属于 o，下一次 Apply 前有效）；未开启或出错时原样返回 frame
+func (o *DebugOverlay) Apply(frame *astiav.Frame) *astiav.Frame {
+	if o == nil || o.disabled {
+		return frame
+	}
+	if o.graph == nil {
+		if err := o.init(frame); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug overlay disabled: %v\n", err)
+			o.disabled = true
+			o.Free()
+			return frame
+		}
+	}
+
+	if err := o.update(); err != nil {
+		reportRecoverableError("Error updating debug overlay", err)
+		return frame
+	}
+	if err := o.src.BuffersrcAddFrame(frame, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+		reportRecoverableError("Error sending frame to debug overlay", err)
+		return frame
+	}
+	o.out.Unref()
+	if err := o.sink.BuffersinkGetFrame(o.out, astiav.NewBuffersinkFlags()); err != nil {
+		if !errors.Is(err, astiav.ErrEagain) {
+			reportRecoverableError("Error receiving frame from debug overlay", err)
+		}
+		return frame
+	}
+	return o.out
+}
+
+// init 按帧参数创建滤镜图：背景框 + 每列三个 drawbox（目标码率、带宽估计、QP）
+func (o *DebugOverlay) init(frame *astiav.Frame) error {
+	width, height := frame.Width(), frame.Height()
+	boxW := debugOverlayColumns*debugOverlayColWidth + 4
+	boxH := debugOverlayGraphH + debugOverlayQPH + 6
+	if width < boxW+2*debugOverlayMargin || height < boxH+2*debugOverlayMargin {
+		return fmt.Errorf("frame %dx%d is too small for the %dx%d graph", width, height, boxW, boxH)
+	}
+
+	if o.graph = astiav.AllocFilterGraph(); o.graph == nil {
+		return errors.New("failed to allocate filter graph")
+	}
+	o.out = astiav.AllocFrame()
+
+	var err error
+	if o.src, err = o.graph.NewFilterContext(astiav.FindFilterByName("buffer"), "in", astiav.FilterArgs{
+		"pix_fmt":      strconv.Itoa(int(frame.PixelFormat())),
+		"pixel_aspect": "1/1",
+		"time_base":    o.timeBase.String(),
+		"video_size":   fmt.Sprintf("%dx%d", width, height),
+	}); err != nil {
+		return fmt.Errorf("failed to create buffer: %w", err)
+	}
+	if o.sink, err = o.graph.NewFilterContext(astiav.FindFilterByName("buffersink"), "out", nil); err != nil {
+		return fmt.Errorf("failed to create buffersink: %w", err)
+	}
+
+	// 初始时所有柱高为 1 像素（drawbox 的 h=0 表示整幅画面高度）
+	filters := []string{fmt.Sprintf("drawbox@bg=x=%d:y=%d:w=%d:h=%d:color=black@0.6:t=fill",
+		debugOverlayMargin, debugOverlayMargin, boxW, boxH)}
+	for i := 0; i < debugOverlayColumns; i++ {
+		x := debugOverlayColX(i)
+		w := debugOverlayColWidth - 1
+		filters = append(filters,
+			fmt.Sprintf("drawbox@tgt%d=x=%d:y=%d:w=%d:h=1:color=green@0.9:t=fill", i, x, debugOverlayGraphBottom()-1, w),
+			fmt.Sprintf("drawbox@est%d=x=%d:y=%d:w=%d:h=2:color=red:t=fill", i, x, debugOverlayGraphBottom()-2, w),
+			fmt.Sprintf("drawbox@qp%d=x=%d:y=%d:w=%d:h=1:color=yellow@0.9:t=fill", i, x, debugOverlayQPBottom()-1, w),
+		)
+	}
+	chain := strings.Join(filters, ",")
+
+	outputs := astiav.AllocFilterInOut()
+	defer outputs.Free()
+	outputs.SetName("in")
+	outputs.SetFilterContext(o.src)
+	outputs.SetPadIdx(0)
+	outputs.SetNext(nil)
+
+	inputs := astiav.AllocFilterInOut()
+	defer inputs.Free()
+	inputs.SetName("out")
+	inputs.SetFilterContext(o.sink)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	if err = o.graph.Parse(chain, inputs, outputs); err != nil {
+		return fmt.Errorf("failed to parse overlay filter: %w", err)
+	}
+	if err = o.graph.Configure(); err != nil {
+		return fmt.Errorf("failed to configure overlay filter: %w", err)
+	}
+	return nil
+}
+
+// update 把 samples 映射到各列 drawbox 的 y / h（最新的一帧在最右列）
+func (o *DebugOverlay) update() error {
+	scale := 100_000.0 // 纵轴下限 100 kbps，避免起步阶段的小值被放大成满格
+	for _, s := range o.samples {
+		scale = math.Max(scale, math.Max(s.TargetBps, s.EstimateBps))
+	}
+	scale *= 1.1
+
+	offset := debugOverlayColumns - len(o.samples)
+	for i := 0; i < debugOverlayColumns; i++ {
+		var s DebugOverlaySample
+		if i >= offset {
+			s = o.samples[i-offset]
+		} else {
+			s.QP = -1
+		}
+
+		tgtH := debugOverlayBarHeight(s.TargetBps/scale, debugOverlayGraphH)
+		if err := o.setBox(fmt.Sprintf("tgt%d", i), debugOverlayGraphBottom()-tgtH, tgtH); err != nil {
+			return err
+		}
+		estY := debugOverlayGraphBottom() - 2
+		if s.EstimateBps > 0 {
+			estY = debugOverlayGraphBottom() - debugOverlayBarHeight(s.EstimateBps/scale, debugOverlayGraphH)
+			estY = max(estY, debugOverlayGraphBottom()-debugOverlayGraphH)
+		}
+		if err := o.setBox(fmt.Sprintf("est%d", i), estY, 2); err != nil {
+			return err
+		}
+		qpH := 1
+		if s.QP >= 0 {
+			qpH = debugOverlayBarHeight(float64(s.QP)/debugOverlayMaxQP, debugOverlayQPH)
+		}
+		if err := o.setBox(fmt.Sprintf("qp%d", i), debugOverlayQPBottom()-qpH, qpH); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBox 设置一个 drawbox 的 y 与 h
+func (o *DebugOverlay) setBox(name string, y, h int) error {
+	for _, arg := range [2][2]string{{"y", strconv.Itoa(y)}, {"h", strconv.Itoa(h)}} {
+		param, value := arg[0], arg[1]
+		key := name + "/" + param
+		if o.sent[key] == value {
+			continue
+		}
+		if _, err := o.graph.SendCommand("drawbox@"+name, param, value, astiav.NewFilterCommandFlags()); err != nil {
+			return fmt.Errorf("drawbox %s %s=%s: %w", name, param, value, err)
+		}
+		o.sent[key] = value
+	}
+	return nil
+}
+
+// Free 释放滤镜图与输出帧
+func (o *DebugOverlay) Free() {
+	if o == nil {
+		return
+	}
+	if o.out != nil {
+		o.out.Free()
+		o.out = nil
+	}
+	if o.graph != nil {
+		o.graph.Free()
+		o.graph = nil
+	}
+}
+
+// debugOverlayColX 返回第 i 列的左边界
+func debugOverlayColX(i int) int {
+	return debugOverlayMargin + 2 + i*debugOverlayColWidth
+}
+
+// debugOverlayGraphBottom 返回码率图的下边界（不含）
+func debugOverlayGraphBottom() int {
+	return debugOverlayMargin + 2 + debugOverlayGraphH
+}
+
+// debugOverlayQPBottom 返回 QP 条的下边界（不含）
+func debugOverlayQPBottom() int {
+	return debugOverlayGraphBottom() + 2 + debugOverlayQPH
+}
+
+// debugOverlayBarHeight 把 [0,1] 的比例换算为柱高，至少 1 像素
+func debugOverlayBarHeight(ratio float64, full int) int {
+	h := int(math.Round(ratio * float64(full)))
+	return min(max(h, 1), full)
+}
@@ -0,0 +1,411 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// depacketizer.go - RTP 负载 → NAL 单元的解包接口，以及 H.264（RFC 6184）的实现
+//
+// 说明：
+//   - h264StreamSink 负责序列号、帧指标与 Annex-B 写入，与编码格式有关的部分（负载格式、NAL 头、关键帧判断）放在 Depacketizer 中，
+//     按轨道的 MimeType 选择 H264Depacketizer 或 H265Depacketizer（见 h265_depacketizer.go）
+//   - 返回的 NAL 单元不含 start code，写入时由 sink 按 -start-code 加上
+//   - H.264 负载的解析是纯函数 DepacketizeH264（只依赖 FUState，不打印日志），错误以 Err* 值返回；
+//     H264Depacketizer 在其上按序列号检查分片连续性并统计。格式错误的包只计数并输出警告，结束时由 Finish 打印汇总
+//   - 分片 NAL（FU-A / H.265 FU）按 RTP 序列号检查连续性：中间或结束分片丢失时只丢弃这一个不完整的 NAL 并记录缺口，
+//     丢弃数量汇总到 fragmentDrops，进入 metrics summary。sink 没有重排缓冲，晚到的分片已在 sink 中丢弃，表现为缺口
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// maxFUABufferBytes 是单个 FU-A 重组 NAL 的上限。1080p 的 IDR 通常只有几百 KB，
+// 远超此值的分片序列只可能来自损坏的流或缺失的结束分片
+const maxFUABufferBytes = 4 << 20
+
+// fragmentDrops 汇总本进程所有解包器因分片缺失丢弃的 NAL 与分片，client 结束时写入 metrics summary
+var fragmentDrops = &FragmentDropStats{}
+
+// FragmentDropStats 统计因分片缺失丢弃的数据，方法对 nil 安全
+type FragmentDropStats struct {
+	mu        sync.Mutex
+	nals      int // 丢弃的不完整 NAL 数
+	fragments int // 丢弃的分片数（不完整 NAL 中已收到的分片，以及起始分片丢失后收到的后续分片）
+}
+
+func (f *FragmentDropStats) add(nals, fragments int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.nals += nals
+	f.fragments += fragments
+	f.mu.Unlock()
+}
+
+// Stats 返回丢弃的不完整 NAL 数与分片数
+func (f *FragmentDropStats) Stats() (nals, fragments int) {
+	if f == nil {
+		return 0, 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nals, f.fragments
+}
+
+// fragmentSequence 跟踪正在重组的分片 NAL 的 RTP 序列号
+type fragmentSequence struct {
+	label     string // 日志中的分片类型（FU-A / H.265 FU）
+	lastSeq   uint16
+	fragments int // 当前 NAL 已收到的分片数
+
+	droppedNALs, droppedFragments int
+}
+
+// begin 在起始分片到达时调用
+func (f *fragmentSequence) begin(seq uint16) {
+	f.lastSeq, f.fragments = seq, 1
+}
+
+// next 判断 seq 是否紧接在上一个分片之后，是则记为当前 NAL 的分片
+func (f *fragmentSequence) next(seq uint16) bool {
+	if seq != f.lastSeq+1 {
+		return false
+	}
+	f.lastSeq = seq
+	f.fragments++
+	return true
+}
+
+// dropIncomplete 丢弃正在重组的 NAL（已收到的分片一并计入），reason 说明缺少了哪一片
+func (f *fragmentSequence) dropIncomplete(reason string) {
+	fmt.Fprintf(os.Stderr, "Warning: %s reassembly incomplete, dropping NAL after %d received fragment(s): %s\n", f.label, f.fragments, reason)
+	f.droppedNALs++
+	f.droppedFragments += f.fragments
+	fragmentDrops.add(1, f.fragments)
+	f.fragments = 0
+}
+
+// dropOrphan 记录一个无法使用的后续分片（所属 NAL 的起始分片丢失或已被丢弃）
+func (f *fragmentSequence) dropOrphan() {
+	f.droppedFragments++
+	fragmentDrops.add(0, 1)
+}
+
+// report 在 Finish 中打印丢弃汇总
+func (f *fragmentSequence) report() {
+	if f.droppedNALs > 0 || f.droppedFragments > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d incomplete %s NAL units dropped on sequence gaps (%d fragments discarded)\n", f.droppedNALs, f.label, f.droppedFragments)
+	}
+}
+
+// nalKind 是写入时关心的 NAL 单元类别
+type nalKind int
+
+const (
+	nalKindOther    nalKind = iota
+	nalKindSlice            // 非关键帧的 slice
+	nalKindKeyframe         // H.264 IDR / H.265 IRAP
+	nalKindParamSet         // SPS / PPS（H.265 还有 VPS）
+)
+
+// Depacketizer 把按序到达的 RTP 负载还原为完整的 NAL 单元，只能在接收协程中使用
+type Depacketizer interface {
+	// Depacketize 处理一个 RTP 负载，返回其中已完整的 NAL 单元（分片的 NAL 在最后一片到达时返回）；
+	// frameStart 表示返回的 NAL 中有开始新帧的 slice
+	Depacketize(payload []byte, seq uint16) (nals [][]byte, frameStart bool)
+	// Kind 返回一个完整 NAL 单元的类别
+	Kind(nal []byte) nalKind
+	// HasKeyframe 判断负载中是否带有关键帧数据（包括关键帧的任一分片）
+	HasKeyframe(payload []byte) bool
+	// RecoveryPoint 返回负载中 recovery point SEI 的 recovery_frame_cnt，没有时 ok 为 false
+	RecoveryPoint(payload []byte) (frames int, ok bool)
+	// Finish 打印未完成的分片与格式错误的包的汇总
+	Finish()
+}
+
+// newDepacketizer 按轨道的 MimeType 选择解包器
+func newDepacketizer(mimeType string) (Depacketizer, error) {
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+		return newH264Depacketizer(), nil
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH265):
+		return newH265Depacketizer(), nil
+	}
+	return nil, fmt.Errorf("%w: no depacketizer for %s", ErrCodecUnsupported, mimeType)
+}
+
+// H.264 负载解析（DepacketizeH264）返回的错误，可用 errors.Is 判断；同一个包可能同时带有多个（errors.Join）
+var (
+	// ErrFUAIncomplete 表示正在重组的 FU-A NAL 在结束分片到达之前被后面的包打断，已丢弃
+	ErrFUAIncomplete = errors.New("FU-A end fragment missing")
+	// ErrFUAMissingStart 表示收到的后续分片没有对应的起始分片（起始分片丢失，或属于另一个 NAL），已丢弃
+	ErrFUAMissingStart = errors.New("FU-A fragment without a start fragment")
+	// ErrFUAOversized 表示重组的 NAL 超过 maxFUABufferBytes 仍没有结束分片，已丢弃
+	ErrFUAOversized = errors.New("FU-A reassembly buffer limit exceeded")
+	// ErrMalformedSTAPA 表示 STAP-A 格式错误，返回的只有出错位置之前完整的 NAL 单元
+	ErrMalformedSTAPA = errors.New("malformed STAP-A packet")
+	// ErrMalformedFUA 表示 FU-A 格式错误，该分片与正在重组的 NAL 一并丢弃
+	ErrMalformedFUA = errors.New("malformed FU-A packet")
+	// ErrUnsupportedNALType 表示负载的 NAL 类型不是单 NAL、STAP-A 或 FU-A
+	ErrUnsupportedNALType = errors.New("unsupported NAL type")
+)
+
+// FUState 是 DepacketizeH264 跨包保存的 FU-A 重组状态，零值即可使用
+type FUState struct {
+	buf     []byte
+	nalType byte
+}
+
+// Pending 判断是否有正在重组的 NAL
+func (s *FUState) Pending() bool {
+	return s.buf != nil
+}
+
+// Reset 丢弃正在重组的 NAL
+func (s *FUState) Reset() {
+	s.buf = nil
+}
+
+// DepacketizeH264 解析一个 RFC 6184 负载：单 NAL（类型 1~23）、STAP-A（24）与 FU-A（28），返回其中完整的 NAL 单元（不含 start code）。
+// FU-A 的分片在 state 中累积，最后一片到达时返回重组的 NAL。出错时仍返回出错之前完整的 NAL 单元，
+// err 包装上面的 Err* 值；空负载返回 nil, nil。
+//
+// 只看负载本身，不检查 RTP 序列号：调用方发现后续分片与上一片不连续时应先调用 state.Reset()（见 H264Depacketizer）
+func DepacketizeH264(payload []byte, state *FUState) ([][]byte, error) {
+	if len(payload) < 1 {
+		return nil, nil
+	}
+	nalHeader := payload[0]
+	nalType := nalHeader & 0x1F
+
+	var dropped error
+	if nalType != 28 && state.Pending() {
+		dropped = fmt.Errorf("%w before a NAL type %d packet", ErrFUAIncomplete, nalType)
+		state.Reset()
+	}
+
+	switch {
+	case nalType >= 1 && nalType <= 23:
+		return [][]byte{payload}, dropped
+
+	case nalType == 24:
+		nals, err := splitSTAPA(payload)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrMalformedSTAPA, err)
+		}
+		return nals, errors.Join(dropped, err)
+
+	case nalType == 28:
+		// 格式错误的分片同时丢弃正在重组的 NAL：缺少的一片无法补回
+		if err := checkFUAHeader(payload); err != nil {
+			state.Reset()
+			return nil, fmt.Errorf("%w: %w", ErrMalformedFUA, err)
+		}
+		fuHeader := payload[1]
+		start := (fuHeader & 0x80) != 0
+		end := (fuHeader & 0x40) != 0
+		actualNALType := fuHeader & 0x1F
+
+		switch {
+		case start:
+			if state.Pending() {
+				dropped = fmt.Errorf("%w before the next start fragment", ErrFUAIncomplete)
+			}
+			state.nalType = actualNALType
+			state.buf = append([]byte{(nalHeader & 0xE0) | actualNALType}, payload[2:]...)
+		case state.Pending() && actualNALType == state.nalType:
+			state.buf = append(state.buf, payload[2:]...)
+		default:
+			err := fmt.Errorf("%w (NAL type %d)", ErrFUAMissingStart, actualNALType)
+			if state.Pending() {
+				err = errors.Join(fmt.Errorf("%w: fragment carries NAL type %d instead of %d", ErrFUAIncomplete, actualNALType, state.nalType), err)
+			}
+			state.Reset()
+			return nil, err
+		}
+		// 一直没有结束位的分片序列（损坏或恶意的流）不能无限占用内存：超过上限时丢弃整个 NAL，
+		// 直到下一个起始分片才重新开始重组
+		if len(state.buf) > maxFUABufferBytes {
+			nalType := state.nalType
+			state.Reset()
+			return nil, errors.Join(dropped, fmt.Errorf("%w: NAL type %d exceeds %d bytes without an end fragment", ErrFUAOversized, nalType, maxFUABufferBytes))
+		}
+		if !end {
+			return nil, dropped
+		}
+		nal := state.buf
+		state.Reset()
+		return [][]byte{nal}, dropped
+	}
+	return nil, errors.Join(dropped, fmt.Errorf("%w %d", ErrUnsupportedNALType, nalType))
+}
+
+// H264Depacketizer 在 DepacketizeH264 之上按 RTP 序列号检查分片的连续性，并统计、报告格式错误与丢弃的数据
+type H264Depacketizer struct {
+	fu           FUState
+	fuSeq        fragmentSequence
+	oversizedFUA int // 因超过 maxFUABufferBytes 被丢弃的 FU-A NAL 数
+
+	malformedSTAPA int // 格式错误的 STAP-A 包数
+	malformedFUA   int // 格式错误的 FU-A 包数
+}
+
+// newH264Depacketizer 创建 H.264 解包器
+func newH264Depacketizer() *H264Depacketizer {
+	return &H264Depacketizer{fuSeq: fragmentSequence{label: "FU-A"}}
+}
+
+// Depacketize 实现 Depacketizer
+func (d *H264Depacketizer) Depacketize(payload []byte, seq uint16) (nals [][]byte, frameStart bool) {
+	// 中间或结束分片丢失时只丢弃这一个 NAL：同一帧的其它 NAL 与下一个起始分片照常写入。
+	// 与上一片不连续的后续分片先结束正在重组的 NAL，再由 DepacketizeH264 作为孤立分片丢弃
+	fuType := d.fu.nalType
+	if fuHeader, ok := fuaHeader(payload); ok && fuHeader&0x80 == 0 && d.fu.Pending() && fuHeader&0x1F == fuType && !d.fuSeq.next(seq) {
+		d.fuSeq.dropIncomplete(fmt.Sprintf("sequence gap, expected seq %d but got %d", d.fuSeq.lastSeq+1, seq))
+		d.fu.Reset()
+	}
+
+	nals, err := DepacketizeH264(payload, &d.fu)
+	if err != nil {
+		missingStart := errors.Is(err, ErrFUAMissingStart)
+		if errors.Is(err, ErrFUAIncomplete) {
+			if missingStart {
+				d.fuSeq.dropIncomplete(fmt.Sprintf("seq %d carries NAL type %d instead of %d", seq, payload[1]&0x1F, fuType))
+			} else {
+				d.fuSeq.dropIncomplete(fmt.Sprintf("end fragment missing before seq %d", seq))
+			}
+		}
+		switch {
+		case missingStart:
+			d.fuSeq.dropOrphan()
+		case errors.Is(err, ErrMalformedSTAPA):
+			d.malformedSTAPA++
+			reportRecoverableError("Warning: Malformed STAP-A packet", fmt.Errorf("seq %d: %w", seq, err))
+		case errors.Is(err, ErrMalformedFUA):
+			d.malformedFUA++
+			reportRecoverableError("Warning: Malformed FU-A packet", fmt.Errorf("seq %d: %w", seq, err))
+		case errors.Is(err, ErrFUAOversized):
+			d.oversizedFUA++
+			reportRecoverableError("Warning: FU-A reassembly buffer limit exceeded, discarding fragment", err)
+		case errors.Is(err, ErrUnsupportedNALType):
+			reportRecoverableError("Warning: Unsupported NAL type, skipping", err)
+		}
+	}
+	if fuHeader, ok := fuaHeader(payload); ok && fuHeader&0x80 != 0 && d.fu.Pending() {
+		d.fuSeq.begin(seq)
+	}
+
+	// NAL type 1 (非IDR) 或 5 (IDR) 表示新帧开始
+	for _, nal := range nals {
+		if kind := d.Kind(nal); kind == nalKindSlice || kind == nalKindKeyframe {
+			frameStart = true
+		}
+	}
+	return nals, frameStart
+}
+
+// fuaHeader 返回 FU-A 负载的 FU header；不是 FU-A 或格式错误时 ok 为 false
+func fuaHeader(payload []byte) (byte, bool) {
+	if len(payload) < 1 || payload[0]&0x1F != 28 || checkFUAHeader(payload) != nil {
+		return 0, false
+	}
+	return payload[1], true
+}
+
+// Kind 实现 Depacketizer
+func (d *H264Depacketizer) Kind(nal []byte) nalKind {
+	if len(nal) == 0 {
+		return nalKindOther
+	}
+	switch nal[0] & 0x1F {
+	case 1:
+		return nalKindSlice
+	case 5:
+		return nalKindKeyframe
+	case 7, 8:
+		return nalKindParamSet
+	}
+	return nalKindOther
+}
+
+// HasKeyframe 实现 Depacketizer
+func (d *H264Depacketizer) HasKeyframe(payload []byte) bool {
+	return rtpPayloadHasIDR(payload)
+}
+
+// RecoveryPoint 实现 Depacketizer
+func (d *H264Depacketizer) RecoveryPoint(payload []byte) (int, bool) {
+	return rtpPayloadRecoveryPoint(payload)
+}
+
+// Finish 实现 Depacketizer
+func (d *H264Depacketizer) Finish() {
+	if d.fu.Pending() {
+		fmt.Fprintf(os.Stderr, "Warning: Discarding incomplete FU-A fragment\n")
+	}
+	if d.malformedSTAPA > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d malformed STAP-A packets (only their complete leading NAL units were written)\n", d.malformedSTAPA)
+	}
+	if d.malformedFUA > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d malformed FU-A packets discarded\n", d.malformedFUA)
+	}
+	if d.oversizedFUA > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d FU-A NAL units discarded for exceeding the %d byte reassembly limit\n", d.oversizedFUA, maxFUABufferBytes)
+	}
+	d.fuSeq.report()
+}
+
+// splitSTAPA 解析 STAP-A 负载（RFC 6184 5.7.1：1 字节 STAP-A 头，之后重复 2 字节长度 + NAL 单元），
+// 返回其中的 NAL 单元。格式错误时返回出错位置之前完整的 NAL 单元以及描述错误的 err：
+// 长度为 0、长度字段或 NAL 单元被截断、末尾有多余字节、聚合单元的 NAL 头不合法（forbidden 位或类型不是 1~23）
+func splitSTAPA(payload []byte) ([][]byte, error) {
+	var nals [][]byte
+	offset := 1
+	for offset < len(payload) {
+		if offset+2 > len(payload) {
+			return nals, fmt.Errorf("truncated size field at offset %d (1 trailing byte)", offset)
+		}
+		nalSize := int(payload[offset])<<8 | int(payload[offset+1])
+		offset += 2
+		if nalSize == 0 {
+			return nals, fmt.Errorf("aggregation unit %d has zero size", len(nals)+1)
+		}
+		if offset+nalSize > len(payload) {
+			return nals, fmt.Errorf("aggregation unit %d size %d exceeds the %d remaining bytes", len(nals)+1, nalSize, len(payload)-offset)
+		}
+		nalData := payload[offset : offset+nalSize]
+		if nalData[0]&0x80 != 0 || nalData[0]&0x1F == 0 || nalData[0]&0x1F > 23 {
+			return nals, fmt.Errorf("aggregation unit %d has invalid NAL header 0x%02x", len(nals)+1, nalData[0])
+		}
+		nals = append(nals, nalData)
+		offset += nalSize
+	}
+	if len(nals) == 0 {
+		return nil, fmt.Errorf("no aggregation units")
+	}
+	return nals, nil
+}
+
+// checkFUAHeader 检查 FU-A 负载（RFC 6184 5.8：1 字节 FU indicator + 1 字节 FU header + 分片数据）：
+// 缺少 FU header、起始与结束位同时置位、分片的 NAL 类型不是 1~23（不能再分片聚合包或分片包）时返回错误
+func checkFUAHeader(payload []byte) error {
+	if len(payload) < 2 {
+		return fmt.Errorf("missing FU header (%d byte payload)", len(payload))
+	}
+	fuHeader := payload[1]
+	if fuHeader&0xC0 == 0xC0 {
+		return fmt.Errorf("FU header 0x%02x has both start and end bits set", fuHeader)
+	}
+	if nalType := fuHeader & 0x1F; nalType == 0 || nalType > 23 {
+		return fmt.Errorf("FU header 0x%02x carries invalid NAL type %d", fuHeader, nalType)
+	}
+	return nil
+}
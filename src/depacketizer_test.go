@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDepacketizeH264(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads [][]byte // 依次送入同一个 FUState
+		want     [][]byte // 所有包返回的 NAL 单元
+		wantErr  error    // 最后一个包的错误（nil 表示没有错误）
+	}{
+		{
+			name:     "single NAL",
+			payloads: [][]byte{{0x65, 0x88, 0x84, 0x00}},
+			want:     [][]byte{{0x65, 0x88, 0x84, 0x00}},
+		},
+		{
+			name:     "STAP-A with two units",
+			payloads: [][]byte{{0x18, 0x00, 0x02, 0x67, 0x42, 0x00, 0x03, 0x68, 0xce, 0x38}},
+			want:     [][]byte{{0x67, 0x42}, {0x68, 0xce, 0x38}},
+		},
+		{
+			name: "FU-A in three fragments",
+			payloads: [][]byte{
+				{0x7c, 0x85, 0x01, 0x02}, // 起始分片，IDR
+				{0x7c, 0x05, 0x03},
+				{0x7c, 0x45, 0x04, 0x05}, // 结束分片
+			},
+			want: [][]byte{{0x65, 0x01, 0x02, 0x03, 0x04, 0x05}},
+		},
+		{
+			name:     "STAP-A with a truncated length field",
+			payloads: [][]byte{{0x18, 0x00, 0x02, 0x67, 0x42, 0x00}},
+			want:     [][]byte{{0x67, 0x42}},
+			wantErr:  ErrMalformedSTAPA,
+		},
+		{
+			name:     "FU-A without a start fragment",
+			payloads: [][]byte{{0x7c, 0x05, 0x03}},
+			wantErr:  ErrFUAMissingStart,
+		},
+		{
+			name:     "FU-A interrupted by a single NAL",
+			payloads: [][]byte{{0x7c, 0x85, 0x01}, {0x41, 0x9a}},
+			want:     [][]byte{{0x41, 0x9a}},
+			wantErr:  ErrFUAIncomplete,
+		},
+		{
+			name:     "FU-A with start and end bits set",
+			payloads: [][]byte{{0x7c, 0xc5, 0x01}},
+			wantErr:  ErrMalformedFUA,
+		},
+		{
+			name:     "unsupported NAL type",
+			payloads: [][]byte{{0x1e, 0x00}},
+			wantErr:  ErrUnsupportedNALType,
+		},
+		{
+			name:     "empty payload",
+			payloads: [][]byte{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state FUState
+			var got [][]byte
+			var err error
+			for _, payload := range tt.payloads {
+				var nals [][]byte
+				nals, err = DepacketizeH264(payload, &state)
+				got = append(got, nals...)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d NAL units %x, want %d %x", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("NAL %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+			if state.Pending() {
+				t.Errorf("FU-A reassembly still pending after the last packet")
+			}
+		})
+	}
+}
+
+func TestDepacketizeH264MalformedSTAPA(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    [][]byte // 出错位置之前完整的 NAL 单元
+	}{
+		{name: "no aggregation units", payload: []byte{0x18}},
+		{name: "zero-length unit", payload: []byte{0x18, 0x00, 0x00, 0x67}},
+		{name: "zero-length second unit", payload: []byte{0x18, 0x00, 0x01, 0x67, 0x00, 0x00}, want: [][]byte{{0x67}}},
+		{name: "size exceeds the payload", payload: []byte{0x18, 0x00, 0x10, 0x67, 0x42}},
+		{name: "second size exceeds the payload", payload: []byte{0x18, 0x00, 0x01, 0x68, 0xff, 0xff, 0x65}, want: [][]byte{{0x68}}},
+		{name: "trailing partial length", payload: []byte{0x18, 0x00, 0x02, 0x67, 0x42, 0x00}, want: [][]byte{{0x67, 0x42}}},
+		{name: "forbidden bit set", payload: []byte{0x18, 0x00, 0x01, 0xe7}},
+		{name: "nested aggregation packet", payload: []byte{0x18, 0x00, 0x02, 0x18, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state FUState
+			got, err := DepacketizeH264(tt.payload, &state)
+			if !errors.Is(err, ErrMalformedSTAPA) {
+				t.Fatalf("error = %v, want %v", err, ErrMalformedSTAPA)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d NAL units %x, want %d %x", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("NAL %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// fuaFragment 构造一个 IDR 的 FU-A 分片，负载为 size 字节
+func fuaFragment(start, end bool, size int) []byte {
+	fuHeader := byte(0x05)
+	if start {
+		fuHeader |= 0x80
+	}
+	if end {
+		fuHeader |= 0x40
+	}
+	return append([]byte{0x7c, fuHeader}, make([]byte, size)...)
+}
+
+func TestDepacketizeH264FUABufferBound(t *testing.T) {
+	const fragmentSize = 64 << 10
+
+	t.Run("no end fragment", func(t *testing.T) {
+		var state FUState
+		if _, err := DepacketizeH264(fuaFragment(true, false, fragmentSize), &state); err != nil {
+			t.Fatalf("start fragment: %v", err)
+		}
+		var err error
+		for i := 0; i < 2*maxFUABufferBytes/fragmentSize && err == nil; i++ {
+			var nals [][]byte
+			nals, err = DepacketizeH264(fuaFragment(false, false, fragmentSize), &state)
+			if len(nals) != 0 {
+				t.Fatalf("fragment %d returned %d NAL units without an end fragment", i, len(nals))
+			}
+			if len(state.buf) > maxFUABufferBytes {
+				t.Fatalf("reassembly buffer grew to %d bytes, limit %d", len(state.buf), maxFUABufferBytes)
+			}
+		}
+		if !errors.Is(err, ErrFUAOversized) {
+			t.Fatalf("error = %v, want %v", err, ErrFUAOversized)
+		}
+		if state.Pending() {
+			t.Fatal("oversized NAL should be discarded")
+		}
+		// 之后的后续分片没有起始分片，不再累积
+		if _, err = DepacketizeH264(fuaFragment(false, true, fragmentSize), &state); !errors.Is(err, ErrFUAMissingStart) {
+			t.Fatalf("error after the limit = %v, want %v", err, ErrFUAMissingStart)
+		}
+		if state.Pending() {
+			t.Fatal("fragment after the limit restarted reassembly without a start bit")
+		}
+	})
+
+	t.Run("endless start fragments", func(t *testing.T) {
+		var state FUState
+		for i := 0; i < 2*maxFUABufferBytes/fragmentSize; i++ {
+			nals, err := DepacketizeH264(fuaFragment(true, false, fragmentSize), &state)
+			if len(nals) != 0 {
+				t.Fatalf("start fragment %d returned %d NAL units", i, len(nals))
+			}
+			if i > 0 && !errors.Is(err, ErrFUAIncomplete) {
+				t.Fatalf("start fragment %d: error = %v, want %v", i, err, ErrFUAIncomplete)
+			}
+			if len(state.buf) > fragmentSize+1 {
+				t.Fatalf("repeated start fragments accumulated %d bytes", len(state.buf))
+			}
+		}
+	})
+}
+
+// splitFuzzPayloads 把模糊测试的输入拆成多个 RTP 负载：每个负载前有 2 字节长度，最后不完整的部分作为最后一个负载
+func splitFuzzPayloads(data []byte) [][]byte {
+	var payloads [][]byte
+	for len(data) >= 2 {
+		size := int(data[0])<<8 | int(data[1])
+		data = data[2:]
+		if size > len(data) {
+			break
+		}
+		payloads = append(payloads, data[:size])
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		payloads = append(payloads, data)
+	}
+	return payloads
+}
+
+// joinFuzzPayloads 是 splitFuzzPayloads 的逆操作，用于构造种子
+func joinFuzzPayloads(payloads ...[]byte) []byte {
+	var data []byte
+	for _, payload := range payloads {
+		data = append(data, byte(len(payload)>>8), byte(len(payload)))
+		data = append(data, payload...)
+	}
+	return data
+}
+
+func FuzzDepacketizeH264(f *testing.F) {
+	f.Add(joinFuzzPayloads([]byte{0x65, 0x88, 0x84, 0x00}))
+	f.Add(joinFuzzPayloads([]byte{0x18, 0x00, 0x02, 0x67, 0x42, 0x00, 0x03, 0x68, 0xce, 0x38}))
+	f.Add(joinFuzzPayloads(fuaFragment(true, false, 3), fuaFragment(false, false, 2), fuaFragment(false, true, 4)))
+	f.Add(joinFuzzPayloads(fuaFragment(true, false, 3), []byte{0x18, 0x00, 0x01, 0x67}, fuaFragment(false, true, 1)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var state FUState
+		in, out := 0, 0
+		for _, payload := range splitFuzzPayloads(data) {
+			nals, _ := DepacketizeH264(payload, &state)
+			in += len(payload)
+			for _, nal := range nals {
+				if len(nal) == 0 {
+					t.Fatalf("empty NAL unit from payload %x", payload)
+				}
+				out += len(nal)
+			}
+			if len(state.buf) > maxFUABufferBytes {
+				t.Fatalf("reassembly buffer %d bytes exceeds the %d byte limit", len(state.buf), maxFUABufferBytes)
+			}
+		}
+		// 单 NAL 与 STAP-A 返回负载的子切片，FU-A 每个分片去掉 2 字节头后只加回 1 字节 NAL 头：输出不会多于输入
+		if out > in {
+			t.Fatalf("%d bytes of NAL units from %d bytes of payload", out, in)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// encoded_frame.go - 整理编码器输出的 packet，保证每个 sample 都是一帧（所有 server 共用）
+//
+// 说明：
+//   - 编码器可能输出空 packet，或只包含 SPS/PPS（extradata）、SEI、AUD 等非 VCL NAL 的 packet
+//   - client 按 VCL NAL（slice）计帧，server 按发送的 sample 计帧；把这类 packet 当作一帧发送，
+//     会让 frame_metadata 与 client 的帧序号错位（端到端延迟整体偏移），空 sample 还会在 RTP 时间轴上留下空洞
+//   - 空 packet 直接跳过；只含非 VCL NAL 的 packet 暂存，拼接到下一个含 slice 的 packet 前面一起发送，
+//     SPS/PPS 因此与其后的 IDR 处于同一个 access unit，由分片器按普通 NAL 打包（单 NAL / STAP-A / FU-A）
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// encodedFrameAssembler 过滤空 packet，并把只含参数集等非 VCL NAL 的 packet 合并到下一帧
+type encodedFrameAssembler struct {
+	pending []byte
+
+	emptyPackets  int
+	headerPackets int
+}
+
+// Next 处理一个编码器输出 packet 的数据，返回应作为一帧发送的数据；ok 为 false 时本次不发送
+func (a *encodedFrameAssembler) Next(data []byte) (frame []byte, ok bool) {
+	if len(data) == 0 {
+		a.emptyPackets++
+		return nil, false
+	}
+
+	if hasNAL, hasVCL := h264NALKinds(data); hasNAL && !hasVCL {
+		a.headerPackets++
+		a.pending = append(a.pending, data...)
+		return nil, false
+	}
+
+	if a.pending == nil {
+		return data, true
+	}
+	frame = append(a.pending, data...)
+	a.pending = nil
+	return frame, true
+}
+
+// Report 输出被跳过 / 合并的 packet 数量（都为 0 时不输出）
+func (a *encodedFrameAssembler) Report(prefix string) {
+	if a.emptyPackets == 0 && a.headerPackets == 0 && len(a.pending) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s Encoder output: skipped %d empty packets, merged %d header-only packets into the following frame",
+		prefix, a.emptyPackets, a.headerPackets)
+	if len(a.pending) > 0 {
+		fmt.Fprintf(os.Stderr, " (%d trailing header bytes never sent)", len(a.pending))
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// h264NALKinds 扫描 Annex-B 数据：hasNAL 表示找到了 start code，hasVCL 表示其中有 slice（NAL type 1-5）。
+// 非 Annex-B 的数据（找不到 start code）hasNAL 为 false，调用方应按原样处理。
+func h264NALKinds(data []byte) (hasNAL, hasVCL bool) {
+	zeros := 0
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == 0:
+			zeros++
+		case data[i] == 1 && zeros >= 2:
+			if i+1 < len(data) {
+				hasNAL = true
+				if nalType := data[i+1] & 0x1F; nalType >= 1 && nalType <= 5 {
+					return true, true
+				}
+			}
+			zeros = 0
+		default:
+			zeros = 0
+		}
+	}
+	return hasNAL, false
+}
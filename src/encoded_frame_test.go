@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	testSPS   = []byte{0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x1f}
+	testPPS   = []byte{0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x3c, 0x80}
+	testSEI   = []byte{0x00, 0x00, 0x01, 0x06, 0x05, 0x01, 0x80}
+	testIDR   = []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00}
+	testSlice = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a, 0x02}
+)
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestEncodedFrameAssembler(t *testing.T) {
+	tests := []struct {
+		name        string
+		packets     [][]byte
+		want        [][]byte // 依次返回的帧
+		wantEmpty   int
+		wantHeaders int
+		wantPending int // 最后仍暂存的字节数
+	}{
+		{
+			name:    "frames pass through",
+			packets: [][]byte{testIDR, testSlice},
+			want:    [][]byte{testIDR, testSlice},
+		},
+		{
+			name:        "SPS and PPS fold into the next frame",
+			packets:     [][]byte{concat(testSPS, testPPS), testIDR, testSlice},
+			want:        [][]byte{concat(testSPS, testPPS, testIDR), testSlice},
+			wantHeaders: 1,
+		},
+		{
+			name:        "consecutive header-only packets accumulate",
+			packets:     [][]byte{testSPS, testPPS, testSEI, testIDR},
+			want:        [][]byte{concat(testSPS, testPPS, testSEI, testIDR)},
+			wantHeaders: 3,
+		},
+		{
+			name:      "empty packets are skipped",
+			packets:   [][]byte{{}, testIDR, nil, testSlice},
+			want:      [][]byte{testIDR, testSlice},
+			wantEmpty: 2,
+		},
+		{
+			name:        "empty packet does not flush pending headers",
+			packets:     [][]byte{testSPS, {}, testIDR},
+			want:        [][]byte{concat(testSPS, testIDR)},
+			wantEmpty:   1,
+			wantHeaders: 1,
+		},
+		{
+			name:        "trailing headers stay pending",
+			packets:     [][]byte{testIDR, concat(testSPS, testPPS)},
+			want:        [][]byte{testIDR},
+			wantHeaders: 1,
+			wantPending: len(testSPS) + len(testPPS),
+		},
+		{
+			name:    "data without start codes passes through",
+			packets: [][]byte{{0x65, 0x88, 0x84}},
+			want:    [][]byte{{0x65, 0x88, 0x84}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a encodedFrameAssembler
+			var got [][]byte
+			for _, packet := range tt.packets {
+				if frame, ok := a.Next(packet); ok {
+					got = append(got, frame)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d frames %x, want %d %x", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("frame %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+			if a.emptyPackets != tt.wantEmpty || a.headerPackets != tt.wantHeaders || len(a.pending) != tt.wantPending {
+				t.Errorf("empty = %d, header-only = %d, pending = %d bytes; want %d, %d, %d",
+					a.emptyPackets, a.headerPackets, len(a.pending), tt.wantEmpty, tt.wantHeaders, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestH264NALKinds(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           []byte
+		hasNAL, hasVCL bool
+	}{
+		{name: "IDR", data: testIDR, hasNAL: true, hasVCL: true},
+		{name: "non-IDR slice with a 3-byte start code", data: []byte{0x00, 0x00, 0x01, 0x41, 0x9a}, hasNAL: true, hasVCL: true},
+		{name: "parameter sets", data: concat(testSPS, testPPS), hasNAL: true},
+		{name: "parameter sets before a slice", data: concat(testSPS, testPPS, testSlice), hasNAL: true, hasVCL: true},
+		{name: "no start code", data: []byte{0x65, 0x88}},
+		{name: "start code at the end", data: []byte{0x00, 0x00, 0x01}},
+		{name: "empty", data: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasNAL, hasVCL := h264NALKinds(tt.data)
+			if hasNAL != tt.hasNAL || hasVCL != tt.hasVCL {
+				t.Errorf("h264NALKinds = (%v, %v), want (%v, %v)", hasNAL, hasVCL, tt.hasNAL, tt.hasVCL)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// encoder_flush.go - 流结束时 flush 编码器（所有 server 共用）
+//
+// 说明：
+//   - 编码器内部可能缓存若干帧（B 帧重排、lookahead、帧级多线程），只靠 SendFrame/ReceivePacket
+//     轮询时，这些帧要等后续输入才会输出；EOF 时直接退出会丢掉视频末尾
+//   - 向编码器发送 nil 帧进入 flush 模式后，ReceivePacket 会依次返回所有剩余包，最后返回 EOF
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/asticode/go-astiav"
+)
+
+// flushEncoder 向编码器发送 nil 帧并取出所有剩余的编码包，逐个交给 handle 处理，返回处理的包数。
+// handle 返回错误时停止并返回该错误（例如连接已断开）。
+//
+// 可以安全地重复调用：编码器尚未初始化（nil）时直接返回；已经 flush 过的编码器
+// SendFrame(nil) 会返回 EOF，此时不视为错误，ReceivePacket 也会立即返回 EOF。
+// 注意 flush 之后编码器不能再接收新帧（除非调用 FlushBuffers），因此只应在不再循环播放时调用。
+//
+// pkt 在 handle 返回后会被 Unref 复用，但 pkt.Data() 返回的是 astiav 用 C.GoBytes 复制出的 Go 切片，
+// 不指向 FFmpeg 的缓冲区，handle 可以直接保存它（所有 server 的发送路径同样依赖这一点）。
+// WriteSample 在返回前把数据复制到各 RTP 包，不保留传入的切片（stream_race_test.go 在 -race 下复用发送缓冲区验证）。
+func flushEncoder(codecCtx *astiav.CodecContext, handle func(pkt *astiav.Packet) error) (int, error) {
+	if codecCtx == nil {
+		return 0, nil
+	}
+
+	if err := codecCtx.SendFrame(nil); err != nil && !errors.Is(err, astiav.ErrEof) {
+		return 0, fmt.Errorf("failed to enter encoder flush mode: %w", err)
+	}
+
+	pkt := astiav.AllocPacket()
+	defer pkt.Free()
+
+	flushed := 0
+	for {
+		pkt.Unref()
+		if err := codecCtx.ReceivePacket(pkt); err != nil {
+			// flush 模式下不应出现 EAGAIN，出现时同样视为已取空
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				return flushed, nil
+			}
+			return flushed, fmt.Errorf("failed to receive flushed packet: %w", err)
+		}
+
+		flushed++
+		if err := handle(pkt); err != nil {
+			return flushed, err
+		}
+	}
+}
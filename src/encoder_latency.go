@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// encoder_latency.go - 用周期性帧内刷新代替 IDR 关键帧（server 的 -encoder-latency-mode intra-refresh）
+//
+// 说明：
+//   - tune=zerolatency、bf=0 时 x264 仍按 GOP 周期发送完整的 IDR，IDR 比普通帧大数倍，在恒定码率的链路上造成排队时延尖峰
+//   - intra-refresh 模式打开 x264 的 intra-refresh：帧内编码的宏块列在一个 GOP（刷新周期）内逐帧扫过画面，
+//     每帧大小接近，不再有大的 IDR；只有第一帧是 IDR
+//   - 每个刷新周期开始的帧带 recovery point SEI（recovery_frame_cnt 为刷新需要的帧数），
+//     client 据此把 "recovery point + recovery_frame_cnt 帧" 当作关键帧的等价物（见 keyframe_recovery.go）
+//   - 强制关键帧（丢帧、PLI 等）交给 x264 处理：intra-refresh 模式下开始新的刷新周期，而不是发送 IDR
+//   - Salsify 的候选编码器 GOP 为 1，每帧都是 IDR，不支持此模式
+package main
+
+import (
+	"fmt"
+
+	"github.com/asticode/go-astiav"
+)
+
+// EncoderLatencyMode 是 -encoder-latency-mode 选择的关键帧方式
+type EncoderLatencyMode int
+
+const (
+	EncoderLatencyIDR EncoderLatencyMode = iota
+	EncoderLatencyIntraRefresh
+)
+
+// encoderLatencyMode 由 -encoder-latency-mode 设置
+var encoderLatencyMode EncoderLatencyMode
+
+// parseEncoderLatencyMode 解析 -encoder-latency-mode 的取值
+func parseEncoderLatencyMode(value string) (EncoderLatencyMode, error) {
+	switch value {
+	case "", "idr":
+		return EncoderLatencyIDR, nil
+	case "intra-refresh":
+		return EncoderLatencyIntraRefresh, nil
+	}
+	return EncoderLatencyIDR, fmt.Errorf("unknown encoder latency mode %q (want idr or intra-refresh)", value)
+}
+
+func (m EncoderLatencyMode) String() string {
+	if m == EncoderLatencyIntraRefresh {
+		return "intra-refresh"
+	}
+	return "idr"
+}
+
+// applyEncoderLatencyMode 在 intra-refresh 模式下打开 x264 的 intra-refresh
+func applyEncoderLatencyMode(dict *astiav.Dictionary) error {
+	if encoderLatencyMode != EncoderLatencyIntraRefresh {
+		return nil
+	}
+	if err := dict.Set("intra-refresh", "1", astiav.NewDictionaryFlags()); err != nil {
+		return fmt.Errorf("failed to set encoder option intra-refresh=1: %w", err)
+	}
+	return nil
+}
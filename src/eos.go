@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// eos.go - 流结束标记：server 在源文件播放完时发送 RTCP BYE（reason "eos"），client 据此结束录制
+//
+// 说明：
+//   - 以前 server 到达 EOF 后直接关闭连接，client 只能等连接状态变化或 5 秒读超时，
+//     -max-duration 大于源文件时长时无法区分 "正常结束" 与 "连接中断"
+//   - client 收到 BYE 后再等待 endOfStreamGrace（接收在途包与 NACK 重传），然后作为正常结束退出；
+//     -max-duration 只作为上限
+//   - BYE 走 RTCP，可能丢失，因此连续发送 endOfStreamRepeats 次；全部丢失时退化为原来的关闭 / 超时路径
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// endOfStreamReason 是 BYE 中标识正常结束的 reason
+	endOfStreamReason = "eos"
+	// endOfStreamRepeats / endOfStreamSpacing 控制 BYE 的重复发送
+	endOfStreamRepeats = 3
+	endOfStreamSpacing = 20 * time.Millisecond
+	// endOfStreamGrace 是 client 收到 BYE 后继续接收的时间
+	endOfStreamGrace = time.Second
+)
+
+// sendEndOfStream 为每个视频发送端发送 BYE（reason "eos"），在关闭 PeerConnection 之前调用
+func sendEndOfStream(pc *webrtc.PeerConnection) {
+	var sources []uint32
+	for _, sender := range pc.GetSenders() {
+		track := sender.Track()
+		if track == nil || track.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if params := sender.GetParameters(); len(params.Encodings) > 0 {
+			sources = append(sources, uint32(params.Encodings[0].SSRC))
+		}
+	}
+	if len(sources) == 0 {
+		return
+	}
+
+	bye := []rtcp.Packet{&rtcp.Goodbye{Sources: sources, Reason: endOfStreamReason}}
+	for i := 0; i < endOfStreamRepeats; i++ {
+		if i > 0 {
+			time.Sleep(endOfStreamSpacing)
+		}
+		if err := pc.WriteRTCP(bye); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending end-of-stream BYE: %v\n", err)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Sent end-of-stream (RTCP BYE) for SSRC %v\n", sources)
+}
+
+// EndOfStreamWatcher 在 client 端等待视频轨道的 BYE，方法对 nil 安全
+type EndOfStreamWatcher struct {
+	track    *webrtc.TrackRemote
+	received atomic.Bool
+	deadline atomic.Int64 // grace 截止时间（UnixNano），收到结束标记后设置
+}
+
+// newEndOfStreamWatcher 为视频轨道创建 watcher
+func newEndOfStreamWatcher(track *webrtc.TrackRemote) *EndOfStreamWatcher {
+	return &EndOfStreamWatcher{track: track}
+}
+
+// OnGoodbye 处理一个 BYE：是本轨道的 "eos" 时，设置读超时，让接收循环在 grace 之后结束
+func (w *EndOfStreamWatcher) OnGoodbye(bye *rtcp.Goodbye) {
+	if w == nil || bye.Reason != endOfStreamReason {
+		return
+	}
+	ssrc := uint32(w.track.SSRC())
+	for _, source := range bye.Sources {
+		if source != ssrc {
+			continue
+		}
+		if w.received.Swap(true) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "End of stream received from server (SSRC %d), finishing in %v...\n", ssrc, endOfStreamGrace)
+		deadline := time.Now().Add(endOfStreamGrace)
+		w.deadline.Store(deadline.UnixNano())
+		if err := w.track.SetReadDeadline(deadline); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting read deadline after end of stream: %v\n", err)
+		}
+		return
+	}
+}
+
+// Received 表示是否已收到结束标记
+func (w *EndOfStreamWatcher) Received() bool {
+	return w != nil && w.received.Load()
+}
+
+// Deadline 返回收到结束标记后读取的截止时间；尚未收到时为零值。
+// 接收循环自己设置读取截止时间（-jitter-buffer）时用它避免覆盖 grace
+func (w *EndOfStreamWatcher) Deadline() time.Time {
+	if w == nil {
+		return time.Time{}
+	}
+	if ns := w.deadline.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// errors.go - 信令 / 媒体管线中可用 errors.Is 判断的错误值（client 与 server 共用）
+//
+// 说明：
+//   - 以前用 strings.Contains(err.Error(), "closed") 判断连接是否已关闭，依赖 Pion 的错误文案，升级依赖后可能悄悄失效
+//   - Pion 在不同层返回不同的 "已关闭" 错误（io.EOF、io.ErrClosedPipe、webrtc.ErrConnectionClosed、dtls.ErrConnClosed 等），
+//     isConnectionClosed 统一判断；本程序自己的包装层需要表示 "已关闭" 时返回（或包装）ErrConnectionClosed
+//   - 初始化路径（initVideoSource / initVideoEncoding）返回包装了 ErrNoVideoStream / ErrCodecUnsupported 的 error，由 main 打印后退出
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/pion/dtls/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	// ErrConnectionClosed 表示 PeerConnection 或其下的传输已经关闭，读写循环应正常结束
+	ErrConnectionClosed = errors.New("connection closed")
+	// ErrNoVideoStream 表示输入文件中没有视频流
+	ErrNoVideoStream = errors.New("no video stream found")
+	// ErrNoAudioStream 表示输入文件中没有音频流（音频是可选的，调用方应跳过音频轨道）
+	ErrNoAudioStream = errors.New("no audio stream found")
+	// ErrCodecUnsupported 表示找不到所需的编解码器，或对端协商出的编码格式不受支持
+	ErrCodecUnsupported = errors.New("codec not supported")
+)
+
+// isConnectionClosed 判断 err 是否表示连接已关闭（包括本地调用 Close 后的读写）
+func isConnectionClosed(err error) bool {
+	return errors.Is(err, ErrConnectionClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, webrtc.ErrConnectionClosed) ||
+		errors.Is(err, dtls.ErrConnClosed)
+}
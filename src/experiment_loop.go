@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// experiment_loop.go - NDTC / Salsify / BurstRTC server 共用的发送循环
+//
+// 说明：
+//   - 每个帧间隔读取、解码一帧，向 RateController 取预算，缩放、编码后发送，再把 FrameObservation 交回控制器
+//   - 与编码器有关的步骤由各实验的 server_ffmpeg_*.go 提供（与 initVideoEncoding 等相同，每个二进制各有一份）：
+//     prepareEncoderForBudget 按预算调整编码器并返回叠加图显示的 QP，encodeFrameForBudget 编码一帧并返回要发送的数据
+//   - pacer 非 nil 时（BurstRTC）track 只负责分片入队，由 pacer 按控制器的 burst 比例在发送时长内发出；
+//     否则数据直接写入 track，发送时长超过帧间隔的部分在帧后等待
+//   - 逐帧日志与实验自己的 CSV 放在 onFrame 中，在控制器更新之后调用
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// writeVideoToTrack 基于 FFmpeg 解码 + 编码，把 H.264 帧发送到 track，并按帧驱动 ctrl。
+// prefix 是日志前缀（例如 "[NDTC]"），onFrame 可以为 nil
+func writeVideoToTrack(prefix string, track h264SampleWriter, pacer *BurstPacer, loopVideo bool, ctrl RateController, onFrame func(FrameObservation), done chan<- bool, ctx context.Context, metadataWriter *FrameMetadataWriter) {
+	h264FrameDuration := frameRateInterval(videoFrameRate(inputFormatContext, videoStream))
+	// 解码帧 PTS 的校验与单调化（解码时间基即源流时间基，见 initVideoSource）
+	sourcePTS := newSourcePTSValidator(decodeCodecContext.TimeBase(), videoFrameRate(inputFormatContext, videoStream))
+	defer sourcePTS.Report(prefix)
+
+	ticker := time.NewTicker(h264FrameDuration)
+	defer ticker.Stop()
+
+	// 空包跳过，只含 SPS/PPS 等参数集的包并入下一帧（见 encoded_frame.go）
+	var frameAssembler encodedFrameAssembler
+	defer frameAssembler.Report(prefix)
+
+	finish := func() {
+		select {
+		case done <- true:
+		default:
+		}
+	}
+
+	frameID := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "%s Connection closed, stopping video streaming...\n", prefix)
+			finish()
+			return
+		case <-ticker.C:
+		}
+		decodePacket.Unref()
+
+		if err = inputFormatContext.ReadFrame(decodePacket); err != nil {
+			if errors.Is(err, astiav.ErrEof) {
+				if loopVideo {
+					if err = inputFormatContext.SeekFrame(0, 0, astiav.NewSeekFlags(astiav.SeekFlagFrame)); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to seek to beginning: %v\n", err)
+						return
+					}
+					pts = 0
+					sourcePTS.Reset()
+					fmt.Fprintf(os.Stderr, "Video looped, restarting from beginning...\n")
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Video playback completed (EOF reached)\n")
+				// flush 编码器中缓存的剩余帧，避免丢失视频末尾；按帧间隔发送，只记录 metadata，不再更新控制器
+				flushed, fErr := flushEncoder(encodeCodecContext, func(pkt *astiav.Packet) error {
+					data, ok := frameAssembler.Next(pkt.Data())
+					if !ok {
+						return nil
+					}
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-ticker.C:
+					}
+					frameID++
+					sendStart := time.Now()
+					if wErr := track.WriteSample(media.Sample{Data: data, Duration: h264FrameDuration}); wErr != nil {
+						return wErr
+					}
+					if pacer != nil {
+						if _, wErr := pacer.SendFrame(1, time.Time{}); wErr != nil {
+							return wErr
+						}
+					}
+					healthStats.AddFrame(len(data))
+					if metadataWriter != nil {
+						metadataWriter.WriteMetadata(FrameMetadata{
+							FrameID:   frameID,
+							SendStart: sendStart,
+							SendEnd:   time.Now(),
+							FrameBits: frameMetadataBits(data),
+						})
+					}
+					return nil
+				})
+				if fErr != nil {
+					reportRecoverableError("Error flushing encoder", fErr)
+				} else if flushed > 0 {
+					fmt.Fprintf(os.Stderr, "%s Flushed %d buffered packets from encoder\n", prefix, flushed)
+				}
+				finish()
+				return
+			}
+			reportRecoverableError("Error reading frame", err)
+			continue
+		}
+
+		if decodePacket.StreamIndex() != videoStream.Index() {
+			continue
+		}
+
+		decodePacket.RescaleTs(videoStream.TimeBase(), decodeCodecContext.TimeBase())
+
+		if err = decodeCodecContext.SendPacket(decodePacket); err != nil {
+			reportRecoverableError("Error sending packet to decoder", err)
+			continue
+		}
+
+		for {
+			if err = decodeCodecContext.ReceiveFrame(decodeFrame); err != nil {
+				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+					break
+				}
+				reportRecoverableError("Error receiving frame", err)
+				break
+			}
+			decodedPTS := sourcePTS.Validate(decodeFrame)
+
+			frameID++
+			sendStart := time.Now()
+
+			// 闭环控制：在编码前获取预算
+			ctrl.OnNetworkEstimate(bitrateEstimator.EstimatedBitrate()) // -rtcp-bwe 未开启或还没有反馈时为 0
+			targetBits, pacing := ctrl.NextFrameBudget()
+			targetBits = startupRamp.Apply(targetBits)
+			burstFraction := 1.0
+			if b, ok := ctrl.(burstFractionController); ok && pacer != nil {
+				burstFraction = b.BurstFraction()
+			}
+
+			// 初始化编码器与缩放上下文（如果还没初始化）
+			if eErr := initVideoEncoding(); eErr != nil {
+				fmt.Fprintf(os.Stderr, "%s Error: failed to initialize encoder: %v, stopping video streaming\n", prefix, eErr)
+				finish()
+				return
+			}
+
+			// 预算持续偏低 / 恢复时切换编码分辨率
+			if resolutionAdapter.Update(frameID, float64(targetBits)/h264FrameDuration.Seconds(), decodeCodecContext.Width(), decodeCodecContext.Height()) {
+				if err = switchEncodeResolution(); err != nil {
+					reportRecoverableError("Error switching encode resolution", err)
+				}
+			}
+
+			qp := prepareEncoderForBudget(targetBits)
+			if debugOverlay != nil {
+				debugOverlay.Push(DebugOverlaySample{
+					TargetBps:   float64(targetBits) / h264FrameDuration.Seconds(),
+					EstimateBps: ctrl.State().CapacityBps,
+					QP:          qp,
+				})
+			}
+
+			if err = ensureScalerSource(softwareScaleContext, decodeFrame); err != nil {
+				reportRecoverableError("Error reconfiguring scaler", err)
+				continue
+			}
+			if err = softwareScaleContext.ScaleFrame(decodeFrame, scaledFrame); err != nil {
+				reportRecoverableError("Error scaling frame", err)
+				continue
+			}
+
+			pts = sourcePTS.EncoderPTS(decodedPTS, pts, encodeCodecContext.TimeBase())
+			scaledFrame.SetPts(pts)
+
+			packets, eErr := encodeFrameForBudget(debugOverlay.Apply(scaledFrame), frameID, targetBits, &frameAssembler)
+			if eErr != nil {
+				reportRecoverableError("Error encoding frame", eErr)
+				continue
+			}
+
+			obs := FrameObservation{
+				FrameID:       frameID,
+				TargetBits:    targetBits,
+				Pacing:        pacing,
+				SendStart:     sendStart,
+				BurstFraction: burstFraction,
+			}
+			// firstWrite 之前是编码耗时，之后是发送耗时；pacer 的 WriteSample 只入队，以 burst 开始的时间为准
+			if len(packets) > 0 && pacer == nil {
+				obs.FirstWrite = time.Now()
+			}
+			var wErr error
+			metadataBits := 0 // frame_metadata.csv 的 frame_bits（不含 start code，见 frameMetadataBits）
+			for _, data := range packets {
+				if wErr = track.WriteSample(media.Sample{Data: data, Duration: h264FrameDuration}); wErr != nil {
+					break
+				}
+				obs.SentBits += len(data) * 8
+				metadataBits += frameMetadataBits(data)
+			}
+			if wErr == nil && pacer != nil {
+				// 发送时长从本帧的 tick 算起
+				obs.Split, wErr = pacer.SendFrame(burstFraction, sendStart.Add(pacing))
+				obs.FirstWrite = obs.Split.BurstStart
+			}
+			if wErr != nil {
+				fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", wErr)
+				// 如果写入失败，可能是连接已断开，退出循环
+				finish()
+				return
+			}
+			obs.SendEnd = time.Now()
+			if !obs.Split.SendEnd.IsZero() {
+				obs.SendEnd = obs.Split.SendEnd
+			}
+			if obs.Split.Unpaced {
+				fmt.Fprintf(os.Stderr, "%s Frame %d: no time left in the frame interval, %d paced packets sent with the burst\n", prefix, frameID, obs.Split.PacedPackets)
+			}
+
+			pressure := sendPressure.EndFrame(frameID)
+			obs.SendBlocked, obs.DrainBps = pressure.Blocked, pressure.DrainBps
+			obs.LossDetected = lossFeedback.Take()
+			obs.ReceiveSamples = frameDispersion.Take()
+			if pressure.Blocked {
+				fmt.Fprintf(os.Stderr, "%s Frame %d: send buffer pressure (blocked %v in WriteSample, %.0f%% of frame interval)\n",
+					prefix, frameID, pressure.WriteTime, pressure.Ratio*100)
+			}
+
+			ctrl.UpdateStats(obs)
+			if onFrame != nil {
+				onFrame(obs)
+			}
+
+			// 没有 pacer 时发送时长超过帧间隔的部分在帧后等待，控制发送节奏
+			if pacer == nil && pacing > h264FrameDuration {
+				time.Sleep(pacing - h264FrameDuration)
+			}
+
+			healthStats.AddFrame(obs.SentBits / 8)
+			if metadataWriter != nil {
+				metadataWriter.WriteMetadata(FrameMetadata{
+					FrameID:    frameID,
+					SendStart:  obs.SendStart,
+					SendEnd:    obs.SendEnd,
+					FrameBits:  metadataBits,
+					FirstWrite: obs.FirstWrite,
+				})
+			}
+		}
+	}
+}
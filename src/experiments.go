@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// experiments.go - 各实验（码率控制器）的登记表与 -help-experiments 输出
+//
+// 说明：
+//   - 每个控制器编译为单独的 server / client 二进制，这里登记全部实验，任何一个 server 都能列出所有可选实现
+//   - 新增控制器时在 experimentRegistry 中加一项；参数可以是命令行 flag，也可以是代码中的内部常量（Flag 为空）
+//   - 打印时，当前二进制中存在的 flag 使用运行时注册的默认值与说明，因此不会与实际实现脱节；
+//     其它二进制的 flag 使用登记表中的默认值
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// experimentParam 是控制器的一个可调参数
+type experimentParam struct {
+	Flag    string // 命令行参数名（不含 -），为空表示只能改代码的内部参数
+	Name    string // 内部参数名（Flag 为空时显示）
+	Default string
+	Usage   string
+}
+
+// experimentInfo 描述一个实验：算法摘要、对应的二进制与参数
+type experimentInfo struct {
+	Name    string
+	Server  string
+	Client  string
+	Summary string
+	Params  []experimentParam
+}
+
+// experimentRegistry 是所有已实现的实验，按 Makefile 中的顺序排列
+var experimentRegistry = []experimentInfo{
+	{
+		Name:    "gcc",
+		Server:  "server-gcc",
+		Client:  "client-gcc",
+		Summary: "Baseline: fixed-quality encode, rate left to the WebRTC transport (TWCC/REMB feedback, NACK/rtx); no application-level rate controller",
+		Params: []experimentParam{
+			{Flag: "queue-depth", Default: "0", Usage: "Encoded frame queue depth between encoder and sender"},
+			{Flag: "pace-keyframes", Default: "0", Usage: "Spread each keyframe over the next N frame intervals"},
+			{Flag: "min-send-rate", Default: "0", Usage: "Minimum send rate in kbps, filled with RTP padding"},
+		},
+	},
+	{
+		Name:    "ndtc",
+		Server:  "server-ndtc",
+		Client:  "client-ndtc",
+		Summary: "FDACE capacity estimate from per-frame send/receive durations, smoothed with AIMD; frame budget F = T_R * A, frames paced over T_S",
+		Params: []experimentParam{
+			{Name: "fdace_window", Default: "120", Usage: "Frames in the FDACE estimation window"},
+			{Name: "t_send", Default: "0.7 * frame interval", Usage: "Target send duration T_S (pacing, +/-10% jitter)"},
+			{Name: "t_recv", Default: "0.8 * frame interval", Usage: "Target receive duration T_R (frame budget)"},
+			{Name: "ai_step", Default: "0.05", Usage: "Additive increase per loss-free period"},
+			{Name: "md_ratio", Default: "0.5", Usage: "Multiplicative decrease on loss"},
+		},
+	},
+	{
+		Name:    "salsify",
+		Server:  "server-salsify",
+		Client:  "client-salsify",
+		Summary: "Per-frame bit budget from the sliding-window send throughput times a safety margin, backed off when frame loss exceeds 2%",
+		Params: []experimentParam{
+			{Flag: "salsify-latency-target", Default: "200ms", Usage: "Target end-to-end latency for Salsify controller"},
+			{Flag: "salsify-safety-margin", Default: "0.7", Usage: "Fraction of the estimated throughput used as frame budget"},
+			{Flag: "qp-ladder", Default: "20,25,30,35", Usage: "QPs encoded as candidates for every frame"},
+			{Flag: "candidate-scales", Default: "1", Usage: "Resolution scales combined with every candidate QP"},
+			{Flag: "candidate-time-budget", Default: "0", Usage: "Fraction of the frame interval spent encoding QP candidates (0 = encode all)"},
+			{Name: "window_size", Default: "30", Usage: "Frames in the throughput window"},
+		},
+	},
+	{
+		Name:    "burst",
+		Server:  "server-burst",
+		Client:  "client-burst",
+		Summary: "BurstRTC: frame budget from available bandwidth (measured on the burst) times a safety margin; part of each frame's packets is sent as a burst and the rest paced over the frame interval, smaller bursts when frame sizes vary a lot",
+		Params: []experimentParam{
+			{Flag: "burst-safety-margin", Default: "0.7", Usage: "Fraction of the available bandwidth used as frame budget"},
+			{Flag: "burst-frame-interval", Default: "0s", Usage: "Frame interval override (0 = use the source frame rate)"},
+			{Name: "window_size", Default: "30", Usage: "Frames in the frame size / bandwidth window"},
+			{Name: "burst_fraction", Default: "0.3", Usage: "Share of each frame's RTP packets sent at once, the rest paced over the remaining frame interval (x0.7 when the size CV > 0.5)"},
+		},
+	},
+}
+
+// printExperiments 输出 experimentRegistry（-help-experiments）
+func printExperiments(w io.Writer) {
+	fmt.Fprintf(w, "Available experiments (run the server and client of the same experiment):\n")
+	for _, e := range experimentRegistry {
+		fmt.Fprintf(w, "\n%s  (%s / %s)\n  %s\n", e.Name, e.Server, e.Client, e.Summary)
+		if len(e.Params) == 0 {
+			continue
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, p := range e.Params {
+			name, def, usage := p.Name+" (internal)", p.Default, p.Usage
+			if p.Flag != "" {
+				name = "-" + p.Flag
+				// 当前二进制注册了该 flag 时以运行时的默认值与说明为准
+				if f := flag.Lookup(p.Flag); f != nil {
+					def, usage = f.DefValue, f.Usage
+				}
+			}
+			fmt.Fprintf(tw, "    %s\t%s\t%s\n", name, def, usage)
+		}
+		tw.Flush()
+	}
+}
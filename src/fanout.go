@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// fanout.go - 把同一份编码结果发给多个 client（基础 server 的 -clients）
+//
+// 说明：
+//   - 每个 client 有自己的 PeerConnection 与 TrackLocalStaticSample；视频与音频只编码一次，
+//     FanoutTrack 把每个 media.Sample 依次写到所有仍在连接的 client 的轨道，各轨道按自己协商的 payload type / SSRC 打包
+//   - client 的连接失败或关闭（PeerConnectionState failed / closed）时由 Remove 移出，写入某个轨道出错时同样移出，
+//     其它 client 不受影响；最后一个 client 移出后 Empty() 关闭，server 结束 session
+//   - offer / answer 文件按 client 编号命名（fanoutSignalPath）：-clients 3 -answer-file answer.txt 依次读取 answer-1.txt ... answer-3.txt
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// errNoFanoutClients 表示所有 client 都已断开，没有可以写入的轨道
+var errNoFanoutClients = errors.New("all clients disconnected")
+
+// fanoutSignalPath 返回第 id 个 client（从 1 开始）的 offer / answer 文件：只有一个 client 时保持原路径，
+// 否则在扩展名之前加上 -<id>
+func fanoutSignalPath(path string, id, clients int) string {
+	if clients <= 1 || path == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), id, ext)
+}
+
+// fanoutTarget 是一个 client 的轨道
+type fanoutTarget struct {
+	id    int
+	track *webrtc.TrackLocalStaticSample
+}
+
+// FanoutTrack 把 sample 写到多个 client 的轨道，可以在多个协程中使用
+type FanoutTrack struct {
+	kind string // 日志用："video" / "audio"
+
+	mu      sync.Mutex
+	targets []fanoutTarget
+	added   bool
+	empty   chan struct{}
+}
+
+// NewFanoutTrack 创建没有 client 的 FanoutTrack
+func NewFanoutTrack(kind string) *FanoutTrack {
+	return &FanoutTrack{kind: kind, empty: make(chan struct{})}
+}
+
+// Add 加入一个 client 的轨道
+func (f *FanoutTrack) Add(id int, track *webrtc.TrackLocalStaticSample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.targets = append(f.targets, fanoutTarget{id: id, track: track})
+	f.added = true
+}
+
+// Remove 移出一个 client，返回是否确实移出（重复调用时为 false）
+func (f *FanoutTrack) Remove(id int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.removeLocked(id)
+}
+
+// removeLocked 移出一个 client，调用方需持有 f.mu
+func (f *FanoutTrack) removeLocked(id int) bool {
+	for i, t := range f.targets {
+		if t.id != id {
+			continue
+		}
+		f.targets = append(f.targets[:i], f.targets[i+1:]...)
+		if len(f.targets) == 0 && f.added {
+			close(f.empty)
+			f.added = false
+		}
+		return true
+	}
+	return false
+}
+
+// Active 返回仍在接收的 client 数
+func (f *FanoutTrack) Active() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.targets)
+}
+
+// Empty 在最后一个 client 移出后关闭
+func (f *FanoutTrack) Empty() <-chan struct{} {
+	return f.empty
+}
+
+// WriteSample 把 sample 写到每个 client 的轨道；某个轨道写入失败时只移出该 client，
+// 全部 client 都已移出时返回 errNoFanoutClients
+func (f *FanoutTrack) WriteSample(sample media.Sample) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.targets) == 0 {
+		return errNoFanoutClients
+	}
+	for _, t := range append([]fanoutTarget(nil), f.targets...) {
+		if err := t.track.WriteSample(sample); err != nil {
+			f.removeLocked(t.id)
+			fmt.Fprintf(os.Stderr, "Client %d: error writing %s sample, dropping the client (%d remaining): %v\n", t.id, f.kind, len(f.targets), err)
+		}
+	}
+	if len(f.targets) == 0 {
+		return errNoFanoutClients
+	}
+	return nil
+}
@@ -4,12 +4,16 @@
 // fdace_estimator.go - NDTC 中用于估计可用带宽的简化 FDACE 估计器
 //
 // 说明：
-//   - 理论上 FDACE 通过拟合 R/L 与 S/L 的线性关系得到参数 a_n、b_n，
-//     再进一步推导出可用带宽 A_n。
-//   - 这里实现一个工程上可用的近似版本：
+//   - FDACE 通过拟合 R/L 与 S/L 的线性关系得到参数 a_n、b_n，再推导出可用带宽 A_n：
+//       * 帧在 S 秒内发出、经过容量为 C、交叉流量为 X 的瓶颈时，接收持续时间 R ≈ (L + X·S) / C，
+//         即 R/L = (X/C)·(S/L) + 1/C，斜率 a = X/C（交叉流量占比），截距 b = 1/C；
+//       * 可用带宽 A = C - X = (1 - a) / b（bit/s）。a 是无量纲的比例，不能直接取 1/a 作为带宽；
+//   - 实现：
 //       * 维护一个滑动窗口，记录最近若干帧的 (S, R, L)；
-//       * 通过线性回归估计 a、b（可用于进一步分析）；
-//       * 通过简单的 L/R 平均值近似当前可用带宽（bit/s）。
+//       * EstimateAR 用线性回归估计 a、b；
+//       * EstimateCapacity 由 a、b 得到 A，拟合退化或结果超出合理范围时退回窗口内 L/R 的均值。
+//   - 瓶颈没有排队时 R ≈ S（a ≈ 1、b ≈ 0），拟合不出容量，只能用 L/R（即发送速率，容量的下界）；
+//     NDTC 在 -rtcp-bwe 时用 TWCC 反馈得到的真实 S 与 R（见 frame_dispersion.go），否则只有发送侧观测（S = R），只能使用 L/R 均值。
 
 package main
 
@@ -55,39 +59,55 @@ func (w *FdaceWindow) UpdateSample(s FdaceSample) {
 	}
 	w.samples = append(w.samples, s)
 	if len(w.samples) > w.maxCount {
-		// 丢弃最旧的样本
+		// 丢弃最旧的样本：把最近 maxCount 条按原顺序前移后截断（源区间与目标区间重叠，copy 按 memmove 处理）
 		copy(w.samples[0:], w.samples[len(w.samples)-w.maxCount:])
 		w.samples = w.samples[:w.maxCount]
 	}
 }
 
+// Reset 清空窗口中的样本。
+func (w *FdaceWindow) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples = w.samples[:0]
+}
+
+// FDACE 容量估计的合理范围
+const (
+	// fdaceMaxCrossShare 是斜率 a（交叉流量占比）的上限：超过时 1 - a 接近 0，可用带宽对噪声过于敏感
+	fdaceMaxCrossShare = 0.95
+	// fdaceMinCapacityBps 是拟合得到的可用带宽下限
+	fdaceMinCapacityBps = 50e3
+	// fdaceMaxRateRatio 限制拟合结果与 L/R 均值的比例（两个方向都不超过这个倍数），超出时认为拟合不可信
+	fdaceMaxRateRatio = 4.0
+)
+
 // EstimateAR 在当前窗口上拟合 R/L = a * (S/L) + b。
 // 返回 (a, b, ok)。若样本过少或数据异常则 ok=false。
 func (w *FdaceWindow) EstimateAR() (float64, float64, bool) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.estimateARLocked()
+}
 
+// estimateARLocked 是 EstimateAR 的实现，调用方需持有 w.mu
+func (w *FdaceWindow) estimateARLocked() (float64, float64, bool) {
 	n := len(w.samples)
 	if n < 2 {
 		return 0, 0, false
 	}
 
-	var sumX, sumY, sumXX, sumXY float64
+	// 先求均值再按中心化的离差计算斜率：S/L、R/L 的量级约为 1e-7（秒/比特），
+	// 直接用 n*Σx² - (Σx)² 会因相减抵消损失精度，且与固定阈值比较会把正常窗口误判为退化
+	var sumX, sumY float64
 	valid := 0
-
 	for _, s := range w.samples {
-		if s.L <= 0 {
-			continue
-		}
-		x := s.S / s.L // S/L
-		y := s.R / s.L // R/L
-		if !isFinite(x) || !isFinite(y) {
+		x, y, ok := fdaceRatios(s)
+		if !ok {
 			continue
 		}
 		sumX += x
 		sumY += y
-		sumXX += x * x
-		sumXY += x * y
 		valid++
 	}
 
@@ -96,13 +116,23 @@ func (w *FdaceWindow) EstimateAR() (float64, float64, bool) {
 	}
 
 	nf := float64(valid)
-	den := nf*sumXX - sumX*sumX
-	if math.Abs(den) < 1e-12 {
+	meanX, meanY := sumX/nf, sumY/nf
+	var sxx, sxy float64
+	for _, s := range w.samples {
+		x, y, ok := fdaceRatios(s)
+		if !ok {
+			continue
+		}
+		sxx += (x - meanX) * (x - meanX)
+		sxy += (x - meanX) * (y - meanY)
+	}
+	// S/L 在窗口内（相对其大小）几乎不变时无法拟合斜率
+	if sxx <= 1e-12*nf*meanX*meanX {
 		return 0, 0, false
 	}
 
-	a := (nf*sumXY - sumX*sumY) / den
-	b := (sumY - a*sumX) / nf
+	a := sxy / sxx
+	b := meanY - a*meanX
 
 	if !isFinite(a) || !isFinite(b) {
 		return 0, 0, false
@@ -110,12 +140,38 @@ func (w *FdaceWindow) EstimateAR() (float64, float64, bool) {
 	return a, b, true
 }
 
-// EstimateCapacity 返回一个简化的可用带宽估计（bit/s）。
-// 这里直接使用窗口内 L/R 的均值近似 A ≈ E[L/R]。
+// EstimateCapacity 返回可用带宽估计（bit/s）：由拟合参数得到 A = (1 - a) / b；
+// 拟合退化（a 不在 [0, fdaceMaxCrossShare)、b <= 0）或 A 超出合理范围时，退回窗口内 L/R 的均值 A ≈ E[L/R]。
 func (w *FdaceWindow) EstimateCapacity() (float64, bool) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	meanRate, ok := w.meanRateLocked()
+	if !ok {
+		return 0, false
+	}
+	if capacity, fitted := w.fittedCapacityLocked(meanRate); fitted {
+		return capacity, true
+	}
+	return meanRate, true
+}
+
+// fittedCapacityLocked 由回归参数计算可用带宽，meanRate 为窗口内 L/R 的均值；结果不可信时 ok=false。调用方需持有 w.mu
+func (w *FdaceWindow) fittedCapacityLocked(meanRate float64) (float64, bool) {
+	a, b, ok := w.estimateARLocked()
+	if !ok || a < 0 || a >= fdaceMaxCrossShare || b <= 0 {
+		return 0, false
+	}
+	capacity := (1 - a) / b
+	if !isFinite(capacity) || capacity < fdaceMinCapacityBps ||
+		capacity > meanRate*fdaceMaxRateRatio || capacity < meanRate/fdaceMaxRateRatio {
+		return 0, false
+	}
+	return capacity, true
+}
+
+// meanRateLocked 返回窗口内各帧 L/R 的均值（bit/s），调用方需持有 w.mu
+func (w *FdaceWindow) meanRateLocked() (float64, bool) {
 	var sum, cnt float64
 	for _, s := range w.samples {
 		if s.R <= 0 {
@@ -134,6 +190,43 @@ func (w *FdaceWindow) EstimateCapacity() (float64, bool) {
 	return sum / cnt, true
 }
 
+// FrameSizeStats 返回窗口中的样本数以及帧大小 L 的均值与方差（比特）。
+func (w *FdaceWindow) FrameSizeStats() (count int, meanBits float64, varBits float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	count = len(w.samples)
+	if count == 0 {
+		return 0, 0, 0
+	}
+	var sum float64
+	for _, s := range w.samples {
+		sum += s.L
+	}
+	meanBits = sum / float64(count)
+	if count > 1 {
+		var varianceSum float64
+		for _, s := range w.samples {
+			diff := s.L - meanBits
+			varianceSum += diff * diff
+		}
+		varBits = varianceSum / float64(count-1)
+	}
+	return count, meanBits, varBits
+}
+
+// fdaceRatios 返回样本的 (S/L, R/L)，L 非正或结果非有限值时 ok=false
+func fdaceRatios(s FdaceSample) (x, y float64, ok bool) {
+	if s.L <= 0 {
+		return 0, 0, false
+	}
+	x, y = s.S/s.L, s.R/s.L
+	if !isFinite(x) || !isFinite(y) {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
 func isFinite(x float64) bool {
 	return !math.IsNaN(x) && !math.IsInf(x, 0)
 }
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// linearSamples 构造 n 帧满足 R = a·S + b·L 的样本（即 R/L = a·S/L + b），
+// 帧大小与发送时长在样本之间变化，使 S/L 有足够的离散度
+func linearSamples(a, b float64, n int) []FdaceSample {
+	samples := make([]FdaceSample, n)
+	for i := range samples {
+		l := 40e3 + float64(i%7)*8e3    // 40–88 kbit
+		s := 0.005 + float64(i%5)*0.004 // 5–21 ms
+		samples[i] = FdaceSample{FrameID: i + 1, S: s, R: a*s + b*l, L: l}
+	}
+	return samples
+}
+
+// bottleneckSamples 模拟 n 帧经过容量为 capacity、交叉流量为 cross（bit/s）的瓶颈：R = (L + X·S) / C
+func bottleneckSamples(capacity, cross float64, n int) []FdaceSample {
+	return linearSamples(cross/capacity, 1/capacity, n)
+}
+
+// windowOf 创建一个装入 samples 的窗口
+func windowOf(samples []FdaceSample) *FdaceWindow {
+	w := NewFdaceWindow(len(samples))
+	for _, s := range samples {
+		w.UpdateSample(s)
+	}
+	return w
+}
+
+func closeTo(got, want, relTol float64) bool {
+	return math.Abs(got-want) <= relTol*math.Abs(want)
+}
+
+func TestFdaceWindowUpdateSampleTrims(t *testing.T) {
+	w := NewFdaceWindow(3)
+	for id := 1; id <= 5; id++ {
+		w.UpdateSample(FdaceSample{FrameID: id, S: 0.01, R: 0.01, L: float64(id) * 1000})
+	}
+	w.UpdateSample(FdaceSample{FrameID: 6, S: 0.01, R: 0.01, L: 0})  // 空帧不进入窗口
+	w.UpdateSample(FdaceSample{FrameID: 7, S: 0.01, R: 0.01, L: -1}) // 异常大小不进入窗口
+
+	if len(w.samples) != 3 {
+		t.Fatalf("window holds %d samples, want 3", len(w.samples))
+	}
+	for i, want := range []int{3, 4, 5} {
+		if w.samples[i].FrameID != want {
+			t.Errorf("sample %d is frame %d, want %d", i, w.samples[i].FrameID, want)
+		}
+	}
+	count, mean, variance := w.FrameSizeStats()
+	if count != 3 || mean != 4000 || variance != 1e6 {
+		t.Errorf("FrameSizeStats = (%d, %v, %v), want (3, 4000, 1e6)", count, mean, variance)
+	}
+
+	if w := NewFdaceWindow(0); w.maxCount != 120 {
+		t.Errorf("default window size = %d, want 120", w.maxCount)
+	}
+}
+
+func TestFdaceWindowEstimateAR(t *testing.T) {
+	const capacity, cross = 10e6, 3e6
+	a, b, ok := windowOf(bottleneckSamples(capacity, cross, 30)).EstimateAR()
+	if !ok {
+		t.Fatal("EstimateAR failed on a linear window")
+	}
+	if !closeTo(a, cross/capacity, 1e-6) || !closeTo(b, 1/capacity, 1e-6) {
+		t.Errorf("EstimateAR = (%g, %g), want (%g, %g)", a, b, cross/capacity, 1/capacity)
+	}
+
+	if _, _, ok := windowOf(bottleneckSamples(capacity, cross, 1)).EstimateAR(); ok {
+		t.Error("EstimateAR succeeded with a single sample")
+	}
+	// 每帧的 S/L 相同，拟合不出斜率
+	same := []FdaceSample{{S: 0.01, R: 0.02, L: 50e3}, {S: 0.02, R: 0.03, L: 100e3}, {S: 0.01, R: 0.025, L: 50e3}}
+	if _, _, ok := windowOf(same).EstimateAR(); ok {
+		t.Error("EstimateAR succeeded with a constant S/L")
+	}
+}
+
+func TestFdaceWindowEstimateCapacityMeanRate(t *testing.T) {
+	if _, ok := NewFdaceWindow(10).EstimateCapacity(); ok {
+		t.Error("EstimateCapacity succeeded on an empty window")
+	}
+
+	// 没有排队（R = S）：斜率为 1，退回 L/R 的均值
+	samples := []FdaceSample{
+		{S: 0.010, R: 0.010, L: 40e3},  // 4 Mbit/s
+		{S: 0.020, R: 0.020, L: 120e3}, // 6 Mbit/s
+		{S: 0.015, R: 0.015, L: 75e3},  // 5 Mbit/s
+	}
+	got, ok := windowOf(samples).EstimateCapacity()
+	if !ok || !closeTo(got, 5e6, 1e-9) {
+		t.Errorf("EstimateCapacity = (%v, %v), want the mean rate 5e6", got, ok)
+	}
+}
+
+func TestFdaceWindowEstimateCapacityFit(t *testing.T) {
+	// 10 Mbit/s 的瓶颈上有 3 Mbit/s 的交叉流量：a = 0.3，b = 1e-7，A = 7 Mbit/s
+	const capacity, cross = 10e6, 3e6
+	got, ok := windowOf(bottleneckSamples(capacity, cross, 60)).EstimateCapacity()
+	if !ok || !closeTo(got, capacity-cross, 1e-6) {
+		t.Errorf("EstimateCapacity = (%v, %v), want %v", got, ok, capacity-cross)
+	}
+}
+
+func TestFdaceFittedCapacityClamps(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   float64
+		fitted bool
+	}{
+		{name: "within range", a: 0.3, b: 1e-7, fitted: true},
+		{name: "cross share above the limit", a: 0.97, b: 1e-8},
+		{name: "negative slope", a: -0.05, b: 1e-7},
+		{name: "non-positive intercept", a: 0.5, b: -1e-8},
+		{name: "below the minimum capacity", a: 0.5, b: 0.5 / (fdaceMinCapacityBps * 0.8)},
+		{name: "far above the mean rate", a: 0.9, b: 1e-9},
+		{name: "far below the mean rate", a: 0.9, b: 1e-6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := windowOf(linearSamples(tt.a, tt.b, 35))
+			w.mu.Lock()
+			meanRate, ok := w.meanRateLocked()
+			if !ok {
+				w.mu.Unlock()
+				t.Fatal("no mean rate")
+			}
+			capacity, fitted := w.fittedCapacityLocked(meanRate)
+			w.mu.Unlock()
+
+			if fitted != tt.fitted {
+				t.Fatalf("fitted = %v (capacity %g, mean rate %g), want %v", fitted, capacity, meanRate, tt.fitted)
+			}
+			want := meanRate
+			if tt.fitted {
+				want = (1 - tt.a) / tt.b
+				if !closeTo(capacity, want, 1e-6) {
+					t.Errorf("fitted capacity = %g, want %g", capacity, want)
+				}
+			}
+			// EstimateCapacity 在拟合被拒绝时退回 L/R 的均值
+			if got, ok := w.EstimateCapacity(); !ok || !closeTo(got, want, 1e-6) {
+				t.Errorf("EstimateCapacity = (%g, %v), want %g", got, ok, want)
+			}
+		})
+	}
+}
+
+func TestFdaceWindowReset(t *testing.T) {
+	w := windowOf(bottleneckSamples(10e6, 3e6, 10))
+	w.Reset()
+	if count, _, _ := w.FrameSizeStats(); count != 0 {
+		t.Errorf("%d samples after Reset", count)
+	}
+	if _, ok := w.EstimateCapacity(); ok {
+		t.Error("EstimateCapacity succeeded after Reset")
+	}
+}
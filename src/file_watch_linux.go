@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+// +build linux
+
+// file_watch_linux.go - 用 inotify 等待文件写入完成（readFromFile 等待 offer / answer 文件）
+//
+// 说明：
+//   - 监视文件所在的目录而不是文件本身：等待开始时文件通常还不存在，先删除再写入或 rename 替换的写法也能收到事件
+//   - 只关心写入结束（IN_CLOSE_WRITE）与移入（IN_MOVED_TO），写到一半时不唤醒；事件不区分文件名，唤醒后由调用方重新读取
+//   - inotify 的 fd 设为非阻塞，交给 Go 的 poller，等待时可以设置超时
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// fileWatch 等待一个文件所在目录中的写入事件
+type fileWatch struct {
+	file *os.File
+	buf  []byte
+}
+
+// newFileWatch 开始监视 path 所在的目录；目录不存在或系统不支持 inotify 时返回错误
+func newFileWatch(path string) (*fileWatch, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err = syscall.InotifyAddWatch(fd, filepath.Dir(path), syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	return &fileWatch{file: os.NewFile(uintptr(fd), "inotify"), buf: make([]byte, 4096)}, nil
+}
+
+// Wait 等待下一批事件，返回 true；超时返回 false。读取出错时等满 timeout 再返回 false，调用方退化为定期检查
+func (w *fileWatch) Wait(timeout time.Duration) bool {
+	if err := w.file.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		time.Sleep(timeout)
+		return false
+	}
+	_, err := w.file.Read(w.buf)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		time.Sleep(timeout)
+	}
+	return false
+}
+
+// Close 停止监视
+func (w *fileWatch) Close() {
+	w.file.Close()
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux && !js
+// +build !linux,!js
+
+// file_watch_other.go - 没有 inotify 的平台不监视文件，readFromFile 退回定期检查
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// fileWatch 在不支持的平台上不会被创建
+type fileWatch struct{}
+
+// newFileWatch 在不支持的平台上总是返回错误
+func newFileWatch(string) (*fileWatch, error) {
+	return nil, errors.New("file watching is not supported on this platform")
+}
+
+// Wait 在不支持的平台上直接等待 timeout
+func (w *fileWatch) Wait(timeout time.Duration) bool {
+	time.Sleep(timeout)
+	return false
+}
+
+// Close 在不支持的平台上什么都不做
+func (w *fileWatch) Close() {}
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// first_keyframe.go - 等待第一个关键帧（client 的 -first-keyframe-timeout）
+//
+// 说明：
+//   - 第一个 IDR 之前收到的包都无法解码；server 一直不发关键帧（PLI 反复丢失或 server 的 bug）时，
+//     之前的 client 会一直写出不可解码的数据，最后得到一个看起来正常、实际无法播放的文件
+//   - 视频轨道开始后 1 秒仍没有 IDR 时，每 500ms 发送一次 PLI（正常情况下每 3 秒一次）；等待超过超时的一半后同时发送 FIR
+//   - 超过 -first-keyframe-timeout 仍没有 IDR 时停止接收，client 输出 "no keyframe received" 并以非零状态退出
+//   - 第一个 IDR 相对轨道开始的时间写入 metrics_summary 的 first_keyframe_ms
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// firstKeyframeGrace 是开始加快发送关键帧请求之前的等待时间
+	firstKeyframeGrace = time.Second
+	// firstKeyframePLIInterval 是等待第一个关键帧期间发送 PLI 的间隔
+	firstKeyframePLIInterval = 500 * time.Millisecond
+)
+
+// firstKeyframe 在实时接收时非 nil，由 h264StreamSink 对每个视频 RTP 包调用
+var firstKeyframe *FirstKeyframeWatch
+
+// FirstKeyframeWatch 记录第一个关键帧的到达时间，方法对 nil 安全
+type FirstKeyframeWatch struct {
+	timeout time.Duration // 0 表示一直等待
+
+	mu         sync.Mutex
+	start      time.Time
+	receivedAt time.Time
+	packets    int
+	bytes      int
+	plis       int
+	firs       int
+	firSeq     uint8
+	expired    bool
+}
+
+// NewFirstKeyframeWatch 创建 watcher，timeout 为 0 时只记录时间、不会超时
+func NewFirstKeyframeWatch(timeout time.Duration) *FirstKeyframeWatch {
+	return &FirstKeyframeWatch{timeout: timeout}
+}
+
+// Start 在开始接收视频轨道时调用
+func (w *FirstKeyframeWatch) Start(now time.Time) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.start.IsZero() {
+		w.start = now
+	}
+}
+
+// OnPacket 处理一个视频 RTP 包，idr 表示包中带有 IDR 数据
+func (w *FirstKeyframeWatch) OnPacket(idr bool, size int, arrival time.Time) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.receivedAt.IsZero() || w.start.IsZero() {
+		return
+	}
+	if !idr {
+		w.packets++
+		w.bytes += size
+		return
+	}
+	w.receivedAt = arrival
+	fmt.Fprintf(os.Stderr, "First keyframe received %v after the track started (%d packets before it)\n",
+		arrival.Sub(w.start).Round(time.Millisecond), w.packets)
+}
+
+// Expired 判断是否已超过超时仍没有收到关键帧
+func (w *FirstKeyframeWatch) Expired() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.expiredLocked(time.Now())
+}
+
+// expiredLocked 判断是否超时（调用方需持有 w.mu）
+func (w *FirstKeyframeWatch) expiredLocked(now time.Time) bool {
+	if w.expired {
+		return true
+	}
+	if w.timeout <= 0 || w.start.IsZero() || !w.receivedAt.IsZero() {
+		return false
+	}
+	w.expired = now.Sub(w.start) >= w.timeout
+	return w.expired
+}
+
+// Escalate 在第一个关键帧到达之前加快发送关键帧请求，收到关键帧、超时或连接关闭后返回；在单独的协程中运行
+func (w *FirstKeyframeWatch) Escalate(pc *webrtc.PeerConnection, ssrc webrtc.SSRC) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(firstKeyframePLIInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			return
+		}
+		pkts, done := w.nextRequest(now, ssrc)
+		if done {
+			return
+		}
+		if len(pkts) == 0 {
+			continue
+		}
+		if err := pc.WriteRTCP(pkts); err != nil {
+			if isConnectionClosed(err) {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Error sending keyframe request: %v\n", err)
+		}
+	}
+}
+
+// nextRequest 返回这一轮要发送的关键帧请求；done 表示已收到关键帧或已超时
+func (w *FirstKeyframeWatch) nextRequest(now time.Time, ssrc webrtc.SSRC) (pkts []rtcp.Packet, done bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.receivedAt.IsZero() {
+		return nil, true
+	}
+	if w.expiredLocked(now) {
+		fmt.Fprintf(os.Stderr, "No keyframe within %v of the track start, giving up (%d PLI / %d FIR sent)\n", w.timeout, w.plis, w.firs)
+		return nil, true
+	}
+	waited := now.Sub(w.start)
+	if w.start.IsZero() || waited < firstKeyframeGrace {
+		return nil, false
+	}
+	if w.plis == 0 {
+		fmt.Fprintf(os.Stderr, "No keyframe yet after %v, requesting one every %v\n", waited.Round(time.Millisecond), firstKeyframePLIInterval)
+	}
+	pkts = append(pkts, &rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)})
+	w.plis++
+	if w.timeout > 0 && waited >= w.timeout/2 {
+		if w.firs == 0 {
+			fmt.Fprintf(os.Stderr, "Still no keyframe after %v, adding FIR to the requests\n", waited.Round(time.Millisecond))
+		}
+		w.firSeq++
+		pkts = append(pkts, &rtcp.FullIntraRequest{
+			MediaSSRC: uint32(ssrc),
+			FIR:       []rtcp.FIREntry{{SSRC: uint32(ssrc), SequenceNumber: w.firSeq}},
+		})
+		w.firs++
+	}
+	return pkts, false
+}
+
+// Elapsed 返回第一个关键帧相对轨道开始的时间（毫秒）；还没有收到时 ok 为 false
+func (w *FirstKeyframeWatch) Elapsed() (ms float64, ok bool) {
+	if w == nil {
+		return 0, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.receivedAt.IsZero() {
+		return 0, false
+	}
+	return float64(w.receivedAt.Sub(w.start)) / float64(time.Millisecond), true
+}
+
+// Err 在超时仍没有收到关键帧时返回错误
+func (w *FirstKeyframeWatch) Err() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.expired {
+		return nil
+	}
+	return fmt.Errorf("no keyframe received within %v of the track start (%d packets / %d bytes received without an IDR, %d PLI and %d FIR sent)",
+		w.timeout, w.packets, w.bytes, w.plis, w.firs)
+}
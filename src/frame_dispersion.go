@@ -0,0 +1,298 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// frame_dispersion.go - 由 TWCC 反馈得到每帧的发送与接收持续时间，作为 NDTC 的 FDACE 样本（server -rtcp-bwe）
+//
+// 说明：
+//   - FDACE 拟合 R/L = a·S/L + b 需要每帧真实的发送持续时间 S 与接收持续时间 R（见 fdace_estimator.go）；
+//     发送循环只有本地的发送耗时，用它同时作为 S 与 R 时斜率恒为 1，拟合总是退化
+//   - 发送侧：在 TWCC 头扩展 interceptor 之前注册（位于它的内层，见 bitrate_estimator.go），读取每个视频包的 transport-wide 序列号，
+//     记录包所属的帧（RTP 时间戳）、发出时刻与负载大小；NACK 重传（RTP 序列号不比已记录的新）、rtx 与空负载的 padding 包不记录
+//   - 接收侧：TWCC 反馈给出每个包的到达时间（接收端时钟，250µs 精度）。一帧的所有包都有反馈后，
+//     S 为第一个包到最后一个包的发出间隔，R 为最早与最晚到达的间隔，L 为第一个包之后的负载比特数（第一个包的传输不在间隔内）
+//   - 只使用完整到达、至少两个包的帧：丢了包的帧 R 不完整，单包帧没有间隔；frameDispersionHorizon 内反馈没有到齐的帧丢弃。
+//     仍在发送的最新一帧不会提前完成
+//   - 发送循环每帧调用一次 Take，把这段时间内完成的样本放进 FrameObservation.ReceiveSamples；反馈有一个往返的延迟，样本属于更早的帧
+//   - 未开启 -rtcp-bwe 或 client 没有协商 transport-cc 时没有样本，NDTC 退回发送侧的近似（见 ndtc_controller.go）
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+// frameDispersionHorizon 是等待一帧反馈到齐的最长时间，远长于 TWCC 的反馈间隔与常见的 RTT
+const frameDispersionHorizon = 2 * time.Second
+
+// twccReferenceTimeUnit 是 TWCC 反馈中参考时间的单位
+const twccReferenceTimeUnit = 64 * time.Millisecond
+
+// frameDispersion 在 NDTC server 指定 -rtcp-bwe 时非 nil，由 bitrateEstimator 注册 interceptor，drainSenderRTCP 喂入 TWCC 反馈
+var frameDispersion *FrameDispersion
+
+// FrameDispersionSample 是一帧在发送端与接收端的持续时间
+type FrameDispersionSample struct {
+	Send    time.Duration // 第一个包到最后一个包的发出间隔
+	Receive time.Duration // 最早到最晚到达的间隔（接收端时钟）
+	Bits    int           // 第一个包之后的负载比特数
+}
+
+// dispersionFrame 是一帧已发出的包及其反馈
+type dispersionFrame struct {
+	timestamp uint32
+	seqs      []uint16 // transport-wide 序列号
+	firstSent time.Time
+	lastSent  time.Time
+	firstBits int
+	bits      int
+
+	reported     int // 有反馈的包数（含丢失）
+	received     int // 反馈中带到达时间的包数
+	firstArrival time.Duration
+	lastArrival  time.Duration
+}
+
+// FrameDispersion 把发出的视频包与 TWCC 反馈对应到帧，得到每帧的发送 / 接收持续时间；方法对 nil 安全。
+// onSent 在发送协程（interceptor）中调用，Observe 在 RTCP 读取协程中调用，Take 在发送循环中调用
+type FrameDispersion struct {
+	mu      sync.Mutex
+	hasSeq  bool
+	lastSeq uint16                      // 最近记录的 RTP 序列号
+	frames  []*dispersionFrame          // 按发送顺序，反馈还没有到齐的帧
+	bySeq   map[uint16]*dispersionFrame // transport-wide 序列号 → 帧
+	samples []FrameDispersionSample     // 上次 Take 之后完成的样本
+
+	measured int // 得到样本的帧数
+	lossy    int // 反馈中有丢包的帧数
+	single   int // 只有一个包的帧数
+	expired  int // 反馈没有到齐的帧数
+}
+
+// NewFrameDispersion 创建记录，需要在 newWebRTCAPI 之前赋值给 frameDispersion
+func NewFrameDispersion() *FrameDispersion {
+	return &FrameDispersion{bySeq: make(map[uint16]*dispersionFrame)}
+}
+
+// register 注册记录视频包的 interceptor，必须在 TWCC 头扩展 interceptor 之前注册才能看到已经写入的序列号
+func (d *FrameDispersion) register(registry *interceptor.Registry) {
+	if d == nil {
+		return
+	}
+	registry.Add(&frameDispersionInterceptorFactory{dispersion: d})
+}
+
+// onSent 记录一个发出的视频包；RTP 序列号不比已记录的新的包是重传，不记录
+func (d *FrameDispersion) onSent(rtpSeq uint16, timestamp uint32, transportSeq uint16, bits int, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.hasSeq && int16(rtpSeq-d.lastSeq) <= 0 {
+		return
+	}
+	d.hasSeq, d.lastSeq = true, rtpSeq
+	d.expireLocked(now)
+
+	var f *dispersionFrame
+	if n := len(d.frames); n > 0 && d.frames[n-1].timestamp == timestamp {
+		f = d.frames[n-1]
+	} else {
+		f = &dispersionFrame{timestamp: timestamp, firstSent: now, firstBits: bits}
+		d.frames = append(d.frames, f)
+	}
+	f.lastSent = now
+	f.bits += bits
+	f.seqs = append(f.seqs, transportSeq)
+	d.bySeq[transportSeq] = f
+}
+
+// Observe 从一组 RTCP 包中取出 TWCC 反馈
+func (d *FrameDispersion) Observe(pkts []rtcp.Packet) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, pkt := range pkts {
+		if fb, ok := pkt.(*rtcp.TransportLayerCC); ok {
+			d.observeFeedbackLocked(fb)
+		}
+	}
+	d.completeLocked()
+	d.expireLocked(now)
+}
+
+// observeFeedbackLocked 按包状态依次取出每个序列号的到达时间，调用方需持有 d.mu
+func (d *FrameDispersion) observeFeedbackLocked(fb *rtcp.TransportLayerCC) {
+	arrival := time.Duration(fb.ReferenceTime) * twccReferenceTimeUnit
+	seq := fb.BaseSequenceNumber
+	remaining := int(fb.PacketStatusCount)
+	deltas := fb.RecvDeltas
+
+	next := func(symbol uint16) {
+		received := false
+		if (symbol == rtcp.TypeTCCPacketReceivedSmallDelta || symbol == rtcp.TypeTCCPacketReceivedLargeDelta) && len(deltas) > 0 {
+			arrival += time.Duration(deltas[0].Delta) * time.Microsecond
+			deltas = deltas[1:]
+			received = true
+		}
+		if f := d.bySeq[seq]; f != nil {
+			delete(d.bySeq, seq)
+			f.reported++
+			if received {
+				if f.received == 0 || arrival < f.firstArrival {
+					f.firstArrival = arrival
+				}
+				if f.received == 0 || arrival > f.lastArrival {
+					f.lastArrival = arrival
+				}
+				f.received++
+			}
+		}
+		seq++
+		remaining--
+	}
+
+	for _, chunk := range fb.PacketChunks {
+		switch c := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := 0; i < int(c.RunLength) && remaining > 0; i++ {
+				next(c.PacketStatusSymbol)
+			}
+		case *rtcp.StatusVectorChunk:
+			// 最后一个 status vector 可能带有 PacketStatusCount 之外的填充符号
+			for i := 0; i < len(c.SymbolList) && remaining > 0; i++ {
+				next(c.SymbolList[i])
+			}
+		}
+	}
+}
+
+// completeLocked 把反馈已经到齐的帧（最新一帧除外）转为样本，调用方需持有 d.mu
+func (d *FrameDispersion) completeLocked() {
+	if len(d.frames) < 2 {
+		return
+	}
+	pending := d.frames[:0]
+	last := len(d.frames) - 1
+	for i, f := range d.frames {
+		if i == last || f.reported < len(f.seqs) {
+			pending = append(pending, f)
+			continue
+		}
+		switch {
+		case f.received < len(f.seqs):
+			d.lossy++
+		case len(f.seqs) < 2:
+			d.single++
+		default:
+			d.measured++
+			d.samples = append(d.samples, FrameDispersionSample{
+				Send:    f.lastSent.Sub(f.firstSent),
+				Receive: f.lastArrival - f.firstArrival,
+				Bits:    f.bits - f.firstBits,
+			})
+		}
+	}
+	clear(d.frames[len(pending):])
+	d.frames = pending
+}
+
+// expireLocked 丢弃第一个包发出超过 frameDispersionHorizon、反馈仍未到齐的帧，调用方需持有 d.mu
+func (d *FrameDispersion) expireLocked(now time.Time) {
+	n := 0
+	for n < len(d.frames) && now.Sub(d.frames[n].firstSent) > frameDispersionHorizon {
+		for _, seq := range d.frames[n].seqs {
+			delete(d.bySeq, seq)
+		}
+		d.frames[n] = nil
+		n++
+	}
+	d.expired += n
+	d.frames = d.frames[n:]
+}
+
+// Take 返回上次调用以来完成的样本
+func (d *FrameDispersion) Take() []FrameDispersionSample {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	samples := d.samples
+	d.samples = nil
+	return samples
+}
+
+// Report 在 session 结束时输出样本数与丢弃的帧数
+func (d *FrameDispersion) Report(prefix string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.measured == 0 && d.lossy == 0 && d.single == 0 {
+		fmt.Fprintf(os.Stderr, "%s Frame dispersion: no frame received complete TWCC feedback, FDACE used send-side timing only\n", prefix)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s Frame dispersion: %d frames measured from TWCC feedback (%d with lost packets, %d single-packet, %d without complete feedback skipped)\n",
+		prefix, d.measured, d.lossy, d.single, d.expired)
+}
+
+// frameDispersionInterceptorFactory 创建记录视频包的 interceptor
+type frameDispersionInterceptorFactory struct {
+	dispersion *FrameDispersion
+}
+
+// NewInterceptor 实现 interceptor.Factory
+func (f *frameDispersionInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &frameDispersionInterceptor{dispersion: f.dispersion}, nil
+}
+
+// frameDispersionInterceptor 在发送方向记录视频包的 transport-wide 序列号
+type frameDispersionInterceptor struct {
+	interceptor.NoOp
+	dispersion *FrameDispersion
+}
+
+// transportCCExtensionIDOf 返回视频流协商到的 transport-wide 序列号扩展 ID；不是视频流（含 rtx）或未协商时返回 0
+func transportCCExtensionIDOf(info *interceptor.StreamInfo) uint8 {
+	mime := strings.ToLower(info.MimeType)
+	if !strings.HasPrefix(mime, "video/") || mime == "video/rtx" {
+		return 0
+	}
+	for _, ext := range info.RTPHeaderExtensions {
+		if ext.URI == sdp.TransportCCURI {
+			return uint8(ext.ID)
+		}
+	}
+	return 0
+}
+
+// BindLocalStream 记录本流（不含 rtx）每个非空视频包的序列号、发出时刻与负载大小
+func (i *frameDispersionInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	id := transportCCExtensionIDOf(info)
+	if id == 0 {
+		return writer
+	}
+	ssrc := info.SSRC
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if header.SSRC == ssrc && len(payload) > 0 {
+			var ext rtp.TransportCCExtension
+			if raw := header.GetExtension(id); raw != nil && ext.Unmarshal(raw) == nil {
+				i.dispersion.onSent(header.SequenceNumber, header.Timestamp, ext.TransportSequence, len(payload)*8, time.Now())
+			}
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
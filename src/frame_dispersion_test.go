@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	received    = rtcp.TypeTCCPacketReceivedSmallDelta
+	notReceived = rtcp.TypeTCCPacketNotReceived
+)
+
+// twccFeedback 构造一份 TWCC 反馈并经过 Marshal / Unmarshal：symbols 是从 base 开始每个序列号的状态，
+// deltas 是每个收到的包相对前一个的到达间隔（250µs 的整数倍）
+func twccFeedback(t *testing.T, base uint16, symbols []uint16, deltas []time.Duration) []rtcp.Packet {
+	t.Helper()
+	fb := &rtcp.TransportLayerCC{
+		Header:             rtcp.Header{Count: rtcp.FormatTCC, Type: rtcp.TypeTransportSpecificFeedback},
+		BaseSequenceNumber: base,
+		PacketStatusCount:  uint16(len(symbols)),
+		ReferenceTime:      100,
+	}
+	for i := 0; i < len(symbols); i += 7 {
+		chunk := &rtcp.StatusVectorChunk{Type: rtcp.TypeTCCStatusVectorChunk, SymbolSize: rtcp.TypeTCCSymbolSizeTwoBit}
+		for j := i; j < i+7; j++ {
+			symbol := notReceived // 最后一个 chunk 用“未收到”填充
+			if j < len(symbols) {
+				symbol = symbols[j]
+			}
+			chunk.SymbolList = append(chunk.SymbolList, symbol)
+		}
+		fb.PacketChunks = append(fb.PacketChunks, chunk)
+	}
+	for _, delta := range deltas {
+		fb.RecvDeltas = append(fb.RecvDeltas, &rtcp.RecvDelta{Type: received, Delta: delta.Microseconds()})
+	}
+	// 每个 chunk 2 字节、每个小间隔 1 字节，不足 4 字节对齐时需要填充
+	fb.Header.Padding = (2*len(fb.PacketChunks)+len(fb.RecvDeltas))%4 != 0
+	fb.Header.Length = uint16(fb.MarshalSize()/4 - 1)
+	raw, err := fb.Marshal()
+	if err != nil {
+		t.Fatalf("marshal TWCC feedback: %v", err)
+	}
+	pkts, err := rtcp.Unmarshal(raw)
+	if err != nil {
+		t.Fatalf("unmarshal TWCC feedback: %v", err)
+	}
+	return pkts
+}
+
+func TestFrameDispersionSamples(t *testing.T) {
+	d := NewFrameDispersion()
+	t0 := time.Now()
+	ms := time.Millisecond
+
+	// 帧 1：三个包，transport 序列号 10–12；帧 2：两个包，13–14；帧 3：一个包，15（仍在发送的最新一帧）
+	d.onSent(100, 3000, 10, 8000, t0)
+	d.onSent(101, 3000, 11, 9000, t0.Add(2*ms))
+	d.onSent(102, 3000, 12, 7000, t0.Add(4*ms))
+	d.onSent(103, 6000, 13, 8000, t0.Add(33*ms))
+	d.onSent(104, 6000, 14, 8000, t0.Add(34*ms))
+	d.onSent(105, 9000, 15, 8000, t0.Add(66*ms))
+
+	d.Observe(twccFeedback(t, 10,
+		[]uint16{received, received, received, received, received, received},
+		[]time.Duration{20 * ms, 3 * ms, 3500 * time.Microsecond, 30 * ms, 5 * ms, 30 * ms}))
+
+	got := d.Take()
+	want := []FrameDispersionSample{
+		{Send: 4 * ms, Receive: 6500 * time.Microsecond, Bits: 16000},
+		{Send: ms, Receive: 5 * ms, Bits: 8000},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Take returned %d samples %+v, want %+v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sample %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if again := d.Take(); len(again) != 0 {
+		t.Errorf("second Take returned %d samples", len(again))
+	}
+	if len(d.frames) != 1 || d.frames[0].timestamp != 9000 {
+		t.Errorf("pending frames = %d, want only the newest frame", len(d.frames))
+	}
+}
+
+func TestFrameDispersionSkipsIncompleteFrames(t *testing.T) {
+	d := NewFrameDispersion()
+	t0 := time.Now()
+	ms := time.Millisecond
+
+	d.onSent(1, 3000, 1, 8000, t0) // 帧 1：第二个包丢失
+	d.onSent(2, 3000, 2, 8000, t0.Add(ms))
+	d.onSent(2, 3000, 99, 8000, t0.Add(5*ms)) // NACK 重传（RTP 序列号不是新的），不记录
+	d.onSent(3, 6000, 3, 8000, t0.Add(33*ms)) // 帧 2：单包帧
+	d.onSent(4, 9000, 4, 8000, t0.Add(66*ms)) // 帧 3：反馈迟迟没有到
+	d.onSent(5, 9000, 5, 8000, t0.Add(67*ms))
+	d.onSent(6, 12000, 6, 8000, t0.Add(99*ms))
+
+	if _, ok := d.bySeq[99]; ok {
+		t.Fatal("retransmission was recorded")
+	}
+	d.Observe(twccFeedback(t, 1, []uint16{received, notReceived, received}, []time.Duration{10 * ms, 40 * ms}))
+	if got := d.Take(); len(got) != 0 {
+		t.Fatalf("Take returned samples %+v for a lossy and a single-packet frame", got)
+	}
+	if d.lossy != 1 || d.single != 1 {
+		t.Errorf("lossy = %d, single = %d, want 1 and 1", d.lossy, d.single)
+	}
+
+	// 超过 frameDispersionHorizon 没有反馈的帧被丢弃，它们的序列号不再对应
+	d.onSent(7, 15000, 7, 8000, t0.Add(frameDispersionHorizon+time.Second))
+	if d.expired != 2 {
+		t.Errorf("expired = %d, want 2", d.expired)
+	}
+	for _, seq := range []uint16{4, 5, 6} {
+		if _, ok := d.bySeq[seq]; ok {
+			t.Errorf("transport sequence %d still mapped after the frame expired", seq)
+		}
+	}
+	if len(d.frames) != 1 {
+		t.Errorf("%d frames pending, want 1", len(d.frames))
+	}
+}
+
+func TestFrameDispersionNil(t *testing.T) {
+	var d *FrameDispersion
+	d.Observe(nil)
+	if got := d.Take(); got != nil {
+		t.Errorf("nil Take = %v", got)
+	}
+	d.Report("[test]")
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// frame_hash.go - 逐帧哈希，用于验证 RTP 往返是否逐字节无损（-frame-hash）
+//
+// 说明：
+//   - server 对发送的 access unit、client 对写入文件的 NAL 单元分别计算哈希并写入 CSV，
+//     passthrough 模式下两边应逐行一致，diff 两个文件的 sha256 列即可看出哪些帧完整送达
+//   - 哈希只覆盖 NAL 单元本身（4 字节长度前缀 + NAL 字节），与 start code 长度、STAP-A / FU-A 分片方式无关
+//   - pion 的分片器会丢弃 AUD（9）与 filler（12），因此两边都不计入
+//   - 每个 slice（VCL NAL，type 1-5）结束一条记录，记录包含它之前的 SPS/PPS/SEI 等非 VCL NAL；
+//     与 client 按 slice 计帧的方式一致（单 slice 编码时一条记录就是一帧）
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// receivedFrameHashes 是 client 端的哈希记录（-frame-hash），为 nil 时不记录
+var receivedFrameHashes *FrameHashWriter
+
+// FrameHashWriter 按 slice 汇总 NAL 单元并把哈希写入 CSV，方法对 nil 安全
+type FrameHashWriter struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+	file   *os.File
+
+	hash     hash.Hash
+	nalTypes []string
+	bytes    int
+	index    int
+}
+
+// NewFrameHashWriter 创建哈希 CSV
+func NewFrameHashWriter(csvPath string) (*FrameHashWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create frame hash directory: %w", err)
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create frame hash csv: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"index", "nal_types", "bytes", "sha256"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write frame hash header: %w", err)
+	}
+	w.Flush()
+
+	return &FrameHashWriter{writer: w, file: f, hash: sha256.New()}, nil
+}
+
+// AddNAL 加入一个 NAL 单元（不含 start code）；遇到 slice 时输出一条记录
+func (h *FrameHashWriter) AddNAL(nal []byte) {
+	if h == nil || len(nal) == 0 {
+		return
+	}
+	nalType := nal[0] & 0x1F
+	if nalType == 9 || nalType == 12 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(nal)))
+	h.hash.Write(length[:])
+	h.hash.Write(nal)
+	h.nalTypes = append(h.nalTypes, strconv.Itoa(int(nalType)))
+	h.bytes += len(nal)
+
+	if nalType < 1 || nalType > 5 {
+		return
+	}
+
+	h.index++
+	record := []string{
+		strconv.Itoa(h.index),
+		strings.Join(h.nalTypes, " "),
+		strconv.Itoa(h.bytes),
+		hex.EncodeToString(h.hash.Sum(nil)),
+	}
+	h.hash.Reset()
+	h.nalTypes = h.nalTypes[:0]
+	h.bytes = 0
+
+	if err := h.writer.Write(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing frame hash CSV: %v\n", err)
+		return
+	}
+	h.writer.Flush()
+}
+
+// AddAnnexB 按与 pion 分片器相同的规则拆分 Annex-B 数据，逐个加入 NAL 单元
+func (h *FrameHashWriter) AddAnnexB(data []byte) {
+	if h == nil {
+		return
+	}
+	forEachAnnexBNAL(data, h.AddNAL)
+}
+
+// forEachAnnexBNAL 按与 pion 分片器相同的规则拆分 Annex-B 数据；找不到 start code 时把整段数据当作一个 NAL
+func forEachAnnexBNAL(data []byte, fn func(nal []byte)) {
+	startCode := []byte{0x00, 0x00, 0x01}
+	start := bytes.Index(data, startCode)
+	if start == -1 {
+		fn(data)
+		return
+	}
+
+	offset := 3
+	for start < len(data) {
+		end := bytes.Index(data[start+offset:], startCode)
+		if end == -1 {
+			fn(data[start+offset:])
+			return
+		}
+		next := start + offset + end
+		// 4 字节 start code 的第一个 0 不属于前一个 NAL
+		fourByte := data[next-1] == 0
+		if fourByte {
+			next--
+		}
+		fn(data[start+offset : next])
+		start = next
+		if fourByte {
+			offset = 4
+		} else {
+			offset = 3
+		}
+	}
+}
+
+// Close 关闭 CSV；末尾没有 slice 的 NAL 不会被发送到对端，因此不记录
+func (h *FrameHashWriter) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writer.Flush()
+	if err := h.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing frame hash CSV file: %v\n", err)
+	}
+}
+
+// frameHashTrack 在 sample 成功写入底层轨道后计算哈希
+type frameHashTrack struct {
+	track  h264SampleWriter
+	hashes *FrameHashWriter
+}
+
+// NewFrameHashTrack 包装 track；hashes 为 nil 时原样返回 track
+func NewFrameHashTrack(track h264SampleWriter, hashes *FrameHashWriter) h264SampleWriter {
+	if hashes == nil {
+		return track
+	}
+	return &frameHashTrack{track: track, hashes: hashes}
+}
+
+// WriteSample 写入底层轨道，成功后记录哈希
+func (t *frameHashTrack) WriteSample(sample media.Sample) error {
+	if err := t.track.WriteSample(sample); err != nil {
+		return err
+	}
+	t.hashes.AddAnnexB(sample.Data)
+	return nil
+}
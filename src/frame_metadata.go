@@ -6,6 +6,12 @@
 // 说明：
 //   - 用于记录每帧的发送时间戳，供 client 端计算端到端延迟
 //   - 所有 server（GCC、NDTC、Salsify、BurstRTC）可以复用此工具
+//   - 同时记录发送端自身的帧间隔抖动（send_interval_ms / send_jitter_ms）：
+//     client 端看到的抖动 = 发送端（编码耗时、pacing）+ 网络，两者对比即可区分来源
+//   - 同时记录每帧实际使用的 RTP 时间戳（rtp_timestamp）：client 按收到的 RTP 时间戳查找 server 的帧号，
+//     而不是按收到的 slice 计数，丢帧后帧号不会错位
+//   - 同时把发送耗时拆成两段：encode_ms（帧可用到第一个字节写入轨道，主要是编码 / 排队，CPU 受限）
+//     与 transmit_ms（第一个字节到全部写完，主要受发送缓冲区 / pacing 限制），便于区分慢编码器与慢链路
 
 package main
 
@@ -14,8 +20,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
 )
 
 // FrameMetadata 表示一帧的发送元数据
@@ -26,6 +36,25 @@ type FrameMetadata struct {
 	FrameBits   int
 	SendStartMs int64 // 相对时间戳（毫秒），用于端到端延迟计算
 	SendEndMs   int64 // 相对时间戳（毫秒）
+
+	// FirstWrite 是该帧第一个字节写入轨道的时间（SendStart 到 FirstWrite 为编码耗时，FirstWrite 到 SendEnd 为发送耗时）；
+	// 零值表示未单独测量，CSV 中对应列为空
+	FirstWrite time.Time
+
+	// 该帧的 RTP 时间戳（client 读取 CSV 时填充；旧版本的 CSV 没有这一列，HasRTPTimestamp 为 false）
+	RTPTimestamp    uint32
+	HasRTPTimestamp bool
+}
+
+// frameMetadataBits 返回一帧在 frame_metadata.csv 中的 frame_bits：帧内各 NAL 单元（含 NAL 头，以及与 slice 一起发送的 SPS/PPS/SEI）
+// 的字节数之和 × 8，不含 Annex-B start code。这正是 RTP 负载携带的 NAL 数据（RTP 头、FU-A / STAP-A 头不计），
+// 与编码器使用 3 字节还是 4 字节 start code、一帧拆成几个 packet 都无关，所有 server 都按此计算，不同算法的帧大小可以直接比较
+func frameMetadataBits(data []byte) int {
+	bits := 0
+	forEachAnnexBNAL(data, func(nal []byte) {
+		bits += len(nal) * 8
+	})
+	return bits
 }
 
 // FrameMetadataWriter 是一个线程安全的 CSV 写入器，用于记录帧发送元数据
@@ -34,6 +63,21 @@ type FrameMetadataWriter struct {
 	writer    *csv.Writer
 	file      *os.File
 	startTime time.Time // 记录开始时间，用于计算相对时间戳
+
+	// 发送间隔抖动（RFC 3550 式平滑：J += (|D| - J) / 16，D 为相邻两个发送间隔之差）
+	lastSendStart time.Time
+	lastInterval  time.Duration
+	jitter        float64 // 毫秒
+	maxDeviation  time.Duration
+	intervals     int
+	intervalSum   time.Duration
+
+	// 编码 / 发送耗时累计（只统计测量了 FirstWrite 的帧）
+	splitFrames  int
+	encodeSum    time.Duration
+	transmitSum  time.Duration
+	transmitPeak time.Duration
+	encodePeak   time.Duration
 }
 
 // NewFrameMetadataWriter 创建一个新的帧元数据 CSV 写入器
@@ -59,6 +103,11 @@ func NewFrameMetadataWriter(csvPath string) (*FrameMetadataWriter, error) {
 		"send_start_ms", // 相对时间戳（毫秒，从开始时间算起）
 		"send_end_ms",   // 相对时间戳（毫秒，从开始时间算起）
 		"frame_bits",
+		"send_interval_ms", // 与上一帧 send_start 的间隔，首帧为空
+		"send_jitter_ms",   // 平滑后的发送间隔抖动，前两帧为空
+		"rtp_timestamp",    // 该帧发出的 RTP 时间戳，未知时为空
+		"encode_ms",        // send_start 到第一个字节写入轨道，未测量时为空
+		"transmit_ms",      // 第一个字节写入到 send_end，未测量时为空
 	}
 	if err = w.Write(header); err != nil {
 		f.Close()
@@ -95,11 +144,26 @@ func (m *FrameMetadataWriter) WriteMetadata(metadata FrameMetadata) {
 	startMs := metadata.SendStart.Sub(m.startTime).Milliseconds()
 	endMs := metadata.SendEnd.Sub(m.startTime).Milliseconds()
 
+	intervalField, jitterField := m.updateJitter(metadata.SendStart)
+
+	// 元数据在帧写入轨道之后立即记录，此时最近出现的新时间戳就是这一帧的
+	rtpTimestampField := ""
+	if ts, ok := sentRTPTimestamps.Take(); ok {
+		rtpTimestampField = fmt.Sprintf("%d", ts)
+	}
+
+	encodeField, transmitField := m.updateSplit(metadata)
+
 	record := []string{
 		fmt.Sprintf("%d", metadata.FrameID),
 		fmt.Sprintf("%d", startMs),
 		fmt.Sprintf("%d", endMs),
 		fmt.Sprintf("%d", metadata.FrameBits),
+		intervalField,
+		jitterField,
+		rtpTimestampField,
+		encodeField,
+		transmitField,
 	}
 	if err := m.writer.Write(record); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing frame metadata CSV: %v\n", err)
@@ -108,7 +172,54 @@ func (m *FrameMetadataWriter) WriteMetadata(metadata FrameMetadata) {
 	m.writer.Flush()
 }
 
-// Close 关闭底层文件句柄
+// updateJitter 用本帧的发送开始时间更新发送间隔与抖动，返回对应的 CSV 字段（调用方需持有 m.mu）
+func (m *FrameMetadataWriter) updateJitter(sendStart time.Time) (intervalField, jitterField string) {
+	if m.lastSendStart.IsZero() {
+		m.lastSendStart = sendStart
+		return "", ""
+	}
+
+	interval := sendStart.Sub(m.lastSendStart)
+	m.lastSendStart = sendStart
+	intervalField = fmt.Sprintf("%.3f", float64(interval)/float64(time.Millisecond))
+
+	hasPrevious := m.intervals > 0
+	prevInterval := m.lastInterval
+	m.lastInterval = interval
+	m.intervals++
+	m.intervalSum += interval
+	if !hasPrevious {
+		return intervalField, ""
+	}
+
+	deviation := interval - prevInterval
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	if deviation > m.maxDeviation {
+		m.maxDeviation = deviation
+	}
+	m.jitter += (float64(deviation)/float64(time.Millisecond) - m.jitter) / 16
+	return intervalField, fmt.Sprintf("%.3f", m.jitter)
+}
+
+// updateSplit 累计编码 / 发送耗时，返回对应的 CSV 字段（调用方需持有 m.mu）
+func (m *FrameMetadataWriter) updateSplit(metadata FrameMetadata) (encodeField, transmitField string) {
+	if metadata.FirstWrite.IsZero() {
+		return "", ""
+	}
+	encode := metadata.FirstWrite.Sub(metadata.SendStart)
+	transmit := metadata.SendEnd.Sub(metadata.FirstWrite)
+	m.splitFrames++
+	m.encodeSum += encode
+	m.transmitSum += transmit
+	m.encodePeak = max(m.encodePeak, encode)
+	m.transmitPeak = max(m.transmitPeak, transmit)
+	return fmt.Sprintf("%.3f", float64(encode)/float64(time.Millisecond)),
+		fmt.Sprintf("%.3f", float64(transmit)/float64(time.Millisecond))
+}
+
+// Close 打印发送抖动摘要并关闭底层文件句柄
 func (m *FrameMetadataWriter) Close() {
 	if m == nil {
 		return
@@ -116,6 +227,17 @@ func (m *FrameMetadataWriter) Close() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.intervals > 1 {
+		fmt.Fprintf(os.Stderr, "Sender frame pacing: mean interval %.2f ms, jitter %.2f ms, max interval deviation %.2f ms\n",
+			float64(m.intervalSum)/float64(m.intervals)/float64(time.Millisecond), m.jitter,
+			float64(m.maxDeviation)/float64(time.Millisecond))
+	}
+	if m.splitFrames > 0 {
+		fmt.Fprintf(os.Stderr, "Sender latency split: encode mean %.2f ms (max %.2f ms), transmit mean %.2f ms (max %.2f ms)\n",
+			float64(m.encodeSum)/float64(m.splitFrames)/float64(time.Millisecond), float64(m.encodePeak)/float64(time.Millisecond),
+			float64(m.transmitSum)/float64(m.splitFrames)/float64(time.Millisecond), float64(m.transmitPeak)/float64(time.Millisecond))
+	}
+
 	if m.writer != nil {
 		m.writer.Flush()
 	}
@@ -125,3 +247,87 @@ func (m *FrameMetadataWriter) Close() {
 		}
 	}
 }
+
+// sentRTPTimestamps 在 server 指定 -session-dir 时非 nil，记录视频轨道发出的 RTP 时间戳，
+// 由 WriteMetadata 写入 frame_metadata.csv 的 rtp_timestamp 列
+var sentRTPTimestamps *SentRTPTimestamps
+
+// sentRTPTimestampHistory 是判断时间戳是否已经出现过时保留的历史长度（帧数）
+const sentRTPTimestampHistory = 256
+
+// SentRTPTimestamps 记录最近一次出现的新 RTP 时间戳，方法对 nil 安全
+//
+// 一帧的所有包使用同一个时间戳，所以只有每帧的第一个包是 "新" 时间戳；
+// 已经出现过的时间戳（同一帧的后续包、异步 pacing 稍后才发出的旧帧、沿用上一帧时间戳的 padding）不改变记录。
+// 按 NALU 分多个 sample 发送时（Salsify / BurstRTC），每个 sample 的时间戳不同，记录的是最后一个（即 slice）的时间戳，
+// 与 client 检测帧开始的包一致。
+type SentRTPTimestamps struct {
+	mu      sync.Mutex
+	history [sentRTPTimestampHistory]uint32
+	count   int
+	latest  uint32
+	pending bool // latest 还没有被 Take 取走
+}
+
+// NewSentRTPTimestamps 创建记录器
+func NewSentRTPTimestamps() *SentRTPTimestamps {
+	return &SentRTPTimestamps{}
+}
+
+// Observe 记录一个发出（或已分片待发）的视频包的时间戳
+func (r *SentRTPTimestamps) Observe(ts uint32) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < min(r.count, sentRTPTimestampHistory); i++ {
+		if r.history[i] == ts {
+			return
+		}
+	}
+	r.history[r.count%sentRTPTimestampHistory] = ts
+	r.count++
+	r.latest, r.pending = ts, true
+}
+
+// Take 返回上次调用以来出现的最新时间戳；期间没有新帧时返回 false
+func (r *SentRTPTimestamps) Take() (uint32, bool) {
+	if r == nil {
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ts, ok := r.latest, r.pending
+	r.pending = false
+	return ts, ok
+}
+
+// rtpTimestampInterceptorFactory 创建记录视频 RTP 时间戳的 interceptor
+type rtpTimestampInterceptorFactory struct {
+	recorder *SentRTPTimestamps
+}
+
+// NewInterceptor 实现 interceptor.Factory
+func (f *rtpTimestampInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &rtpTimestampInterceptor{recorder: f.recorder}, nil
+}
+
+// rtpTimestampInterceptor 在视频包发出时把时间戳交给 SentRTPTimestamps
+type rtpTimestampInterceptor struct {
+	interceptor.NoOp
+	recorder *SentRTPTimestamps
+}
+
+// BindLocalStream 只包装视频流；padding 包不携带帧数据，不记录
+func (i *rtpTimestampInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !strings.HasPrefix(strings.ToLower(info.MimeType), "video/") {
+		return writer
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if !header.Padding && len(payload) > 0 {
+			i.recorder.Observe(header.Timestamp)
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+//
+// frame_queue.go - Server 端编码帧队列（有界，满时丢弃最旧帧）
+//
+// 说明：
+//   - 位于编码（生产者）与发送（消费者）之间，解耦两者的节奏
+//   - 当编码或发送跟不上时，丢弃最旧的帧以保持新鲜度，而不是让延迟无限累积
+//   - 被丢弃的帧会被计数并记录到 dropped_frames.csv（如果提供了路径）
+//   - 只用于 GCC server（-queue-depth）。NDTC / Salsify / BurstRTC 的共用发送循环（experiment_loop.go）不使用队列：
+//     控制器按上一帧的发送耗时给下一帧分配预算，编码与发送必须在同一帧间隔内同步完成
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// EncodedFrame 表示一帧编码后的数据（一个编码器输出包，即一个 access unit）
+type EncodedFrame struct {
+	FrameID    int // 编码端帧序号（解码顺序）
+	Sample     media.Sample
+	FrameBits  int       // frame_metadata.csv 的 frame_bits（frameMetadataBits，不含 start code）
+	EncodeTime time.Time // 开始处理这一帧的时间（用作 send_start）
+	EnqueuedAt time.Time
+}
+
+// FrameQueue 是一个线程安全的有界帧队列，满时丢弃最旧帧
+type FrameQueue struct {
+	mu     sync.Mutex
+	frames []*EncodedFrame
+	depth  int
+	closed bool
+	notify chan struct{}
+
+	dropped int
+
+	dropWriter *csv.Writer
+	dropFile   *os.File
+}
+
+// NewFrameQueue 创建一个深度为 depth 的帧队列。
+// dropCSVPath 非空时，每个被丢弃的帧都会写入一行记录。
+func NewFrameQueue(depth int, dropCSVPath string) (*FrameQueue, error) {
+	if depth <= 0 {
+		return nil, fmt.Errorf("queue depth must be positive, got %d", depth)
+	}
+
+	q := &FrameQueue{
+		depth:  depth,
+		notify: make(chan struct{}, 1),
+	}
+	if dropCSVPath == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dropCSVPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dropped frames directory: %w", err)
+	}
+
+	f, err := os.Create(dropCSVPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dropped frames csv: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	header := []string{
+		"frame_id",
+		"drop_unix_ms",
+		"queued_ms", // 在队列中等待的时间
+		"frame_bits",
+	}
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write dropped frames header: %w", err)
+	}
+	w.Flush()
+
+	q.dropWriter = w
+	q.dropFile = f
+	return q, nil
+}
+
+// Push 将一帧放入队列。队列已满时丢弃最旧的一帧，并返回 true 表示发生了丢帧。
+func (q *FrameQueue) Push(frame *EncodedFrame) (droppedOldest bool) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+
+	frame.EnqueuedAt = time.Now()
+	if len(q.frames) >= q.depth {
+		oldest := q.frames[0]
+		q.frames[0] = nil
+		q.frames = q.frames[1:]
+		q.dropped++
+		q.recordDropLocked(oldest)
+		droppedOldest = true
+	}
+	q.frames = append(q.frames, frame)
+
+	// 在持锁状态下通知，避免与 Close 关闭 notify 竞争
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	q.mu.Unlock()
+	return droppedOldest
+}
+
+// Pop 取出最旧的一帧，队列为空时阻塞。
+// 队列关闭且已取空、或 ctx 取消时返回 nil, false。
+func (q *FrameQueue) Pop(ctx context.Context) (*EncodedFrame, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.frames) > 0 {
+			frame := q.frames[0]
+			q.frames[0] = nil
+			q.frames = q.frames[1:]
+			q.mu.Unlock()
+			return frame, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+
+		if closed {
+			return nil, false
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Close 关闭队列：之后的 Push 会被忽略，Pop 在取空剩余帧后返回
+func (q *FrameQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.notify)
+
+	if q.dropWriter != nil {
+		q.dropWriter.Flush()
+	}
+	if q.dropFile != nil {
+		if err := q.dropFile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing dropped frames CSV file: %v\n", err)
+		}
+		q.dropFile = nil
+		q.dropWriter = nil
+	}
+}
+
+// Dropped 返回累计丢弃的帧数
+func (q *FrameQueue) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// recordDropLocked 记录一条丢帧信息，调用方需持有 q.mu
+func (q *FrameQueue) recordDropLocked(frame *EncodedFrame) {
+	if q.dropWriter == nil {
+		return
+	}
+	now := time.Now()
+	record := []string{
+		fmt.Sprintf("%d", frame.FrameID),
+		fmt.Sprintf("%d", now.UnixMilli()),
+		fmt.Sprintf("%d", now.Sub(frame.EnqueuedAt).Milliseconds()),
+		fmt.Sprintf("%d", frame.FrameBits),
+	}
+	if err := q.dropWriter.Write(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing dropped frames CSV: %v\n", err)
+		return
+	}
+	q.dropWriter.Flush()
+}
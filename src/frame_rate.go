@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// frame_rate.go - 源视频帧率的获取（服务端）
+//
+// 说明：
+//   - 编码器时间基、发送节奏、控制器的帧周期都按源帧率设置，不再假设 30fps
+//   - 优先使用 avg_frame_rate，其次使用 FFmpeg 猜测的帧率（r_frame_rate 等），
+//     都不可用时才回退到 defaultFrameRateFPS，并打印一次警告
+//   - 帧率通过 offer 中的 a=framerate 告知 client（见 common.go 的 withSDPFrameRate）
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// frameRateFallbackOnce 保证回退到缺省帧率的警告只打印一次
+var frameRateFallbackOnce sync.Once
+
+// videoFrameRate 返回视频流的帧率
+func videoFrameRate(formatContext *astiav.FormatContext, stream *astiav.Stream) astiav.Rational {
+	if rate := stream.AvgFrameRate(); rate.Num() > 0 && rate.Den() > 0 {
+		return rate
+	}
+	if formatContext != nil {
+		if rate := formatContext.GuessFrameRate(stream, nil); rate.Num() > 0 && rate.Den() > 0 {
+			return rate
+		}
+	}
+
+	frameRateFallbackOnce.Do(func() {
+		fmt.Fprintf(os.Stderr, "Warning: source frame rate unknown, assuming %d fps (latency, pacing and bitrate math may be off)\n", defaultFrameRateFPS)
+	})
+	return astiav.NewRational(defaultFrameRateFPS, 1)
+}
+
+// frameRateInterval 返回帧率对应的帧间隔
+func frameRateInterval(rate astiav.Rational) time.Duration {
+	return time.Duration(float64(time.Second) * float64(rate.Den()) / float64(rate.Num()))
+}
+
+// probeVideoFrameRate 打开源文件读取视频帧率后立即关闭。
+// 用于在打开解码器之前（生成 offer 时）就知道帧率。
+func probeVideoFrameRate(videoPath string) (astiav.Rational, error) {
+	formatContext := astiav.AllocFormatContext()
+	if formatContext == nil {
+		return astiav.Rational{}, errors.New("failed to allocate format context")
+	}
+	defer formatContext.Free()
+
+	if err := formatContext.OpenInput(videoPath, nil, nil); err != nil {
+		return astiav.Rational{}, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer formatContext.CloseInput()
+
+	if err := formatContext.FindStreamInfo(nil); err != nil {
+		return astiav.Rational{}, fmt.Errorf("failed to find stream info: %w", err)
+	}
+
+	for _, stream := range formatContext.Streams() {
+		if stream.CodecParameters().CodecType() == astiav.MediaTypeVideo {
+			return videoFrameRate(formatContext, stream), nil
+		}
+	}
+	return astiav.Rational{}, errors.New("no video stream found in file")
+}
@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// h264_compat.go - 只能解码 constrained baseline 的接收端（-compat constrained-baseline）
+//
+// 说明：
+//   - 部分接收端（老设备、某些 SFU）只能解码 constrained baseline；pion 默认注册的 H.264 还包括 Main / High，
+//     对端按默认 offer 协商后仍可能收到 B 帧或 CABAC 码流而无法解码
+//   - -compat constrained-baseline 时 MediaEngine 只注册 profile-level-id=42e01f 的 H.264（加 RTX 与 Opus），
+//     RTCP 反馈与 pion 默认的 H.264 相同（goog-remb、ccm fir、nack、nack pli、transport-cc），
+//     编码器强制 profile=baseline、bf=0、coder=cavlc
+//   - server 收到 answer 后检查其中 H.264 的最高 profile：低于当前编码配置所需（例如开启 -bframes 但对端只接受 baseline）时
+//     自动降级到 constrained baseline。信令只交换一次 offer/answer，不能重新协商，降级只发生在本地编码器上
+//   - client 的 -compat 用于模拟只支持 constrained baseline 的接收端：answer 中只会出现 42e01f
+//   - 编码器选项与直接转发时的 profile 判断在 h264_compat_encoder.go（依赖 FFmpeg），本文件只处理 SDP
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// H264Compat 是 -compat 选择的兼容模式
+type H264Compat int
+
+const (
+	H264CompatNone H264Compat = iota
+	H264CompatConstrainedBaseline
+)
+
+// h264Compat 由 -compat 设置，server 在 answer 表明对端只支持 baseline 时也会把它切换为 constrained baseline
+var h264Compat H264Compat
+
+// H.264 profile 等级，数值越大要求的解码能力越高
+const (
+	h264ProfileRankBaseline = iota // constrained baseline / baseline：无 B 帧、CAVLC
+	h264ProfileRankMain            // main / extended：B 帧、CABAC
+	h264ProfileRankHigh            // high 及以上：8x8 变换等
+)
+
+// peerH264ProfileRank 是 answer 中 H.264 的最高 profile 等级；收到 answer 之前（或无法判断时）不做限制
+var peerH264ProfileRank = h264ProfileRankHigh
+
+// compatH264PayloadType / compatRTXPayloadType 与 pion 默认注册的 42e01f 一致，便于与未开启 -compat 的对端协商
+const (
+	compatH264PayloadType = 106
+	compatRTXPayloadType  = 107
+	compatProfileLevelID  = "42e01f"
+)
+
+// parseH264Compat 解析 -compat 的取值
+func parseH264Compat(value string) (H264Compat, error) {
+	switch value {
+	case "", "none":
+		return H264CompatNone, nil
+	case "constrained-baseline":
+		return H264CompatConstrainedBaseline, nil
+	}
+	return H264CompatNone, fmt.Errorf("unknown compat mode %q (want none or constrained-baseline)", value)
+}
+
+func (c H264Compat) String() string {
+	if c == H264CompatConstrainedBaseline {
+		return "constrained-baseline"
+	}
+	return "none"
+}
+
+// registerCodecs 在 MediaEngine 上注册编解码器：未开启 -compat 时与 RegisterDefaultCodecs 相同，
+// 开启时只注册 Opus 与 constrained baseline 的 H.264
+func registerCodecs(m *webrtc.MediaEngine) error {
+	if h264Compat == H264CompatNone {
+		return m.RegisterDefaultCodecs()
+	}
+
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1",
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return err
+	}
+
+	// transport-cc 直接写在编解码器上，不依赖之后注册的 TWCC interceptor 追加：-rtcp-bwe 需要对端回送 TWCC
+	feedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}, {Type: "transport-cc"}}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:     webrtc.MimeTypeH264,
+			ClockRate:    90000,
+			SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + compatProfileLevelID,
+			RTCPFeedback: feedback,
+		},
+		PayloadType: compatH264PayloadType,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return err
+	}
+	return m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeRTX, ClockRate: 90000, SDPFmtpLine: fmt.Sprintf("apt=%d", compatH264PayloadType),
+		},
+		PayloadType: compatRTXPayloadType,
+	}, webrtc.RTPCodecTypeVideo)
+}
+
+// h264ProfileRank 返回 profile-level-id（6 位十六进制）对应的 profile 等级；
+// 设置了 constraint_set0 的流满足 baseline 的约束，按 baseline 处理
+func h264ProfileRank(profileLevelID string) (int, bool) {
+	if len(profileLevelID) != 6 {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(profileLevelID, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	profileIDC := value >> 16
+	constraints := (value >> 8) & 0xFF
+	switch {
+	case profileIDC == 66, constraints&0x80 != 0:
+		return h264ProfileRankBaseline, true
+	case profileIDC == 77, profileIDC == 88:
+		return h264ProfileRankMain, true
+	}
+	return h264ProfileRankHigh, true
+}
+
+// h264ProfileRankName 返回 profile 等级的名称（用于日志）
+func h264ProfileRankName(rank int) string {
+	switch rank {
+	case h264ProfileRankBaseline:
+		return "constrained baseline"
+	case h264ProfileRankMain:
+		return "main"
+	}
+	return "high"
+}
+
+// answerMaxH264Profile 返回 answer 视频 m= 段中所有 H.264 负载的最高 profile 等级；
+// 没有 profile-level-id 时按 RFC 6184 的默认值 42000a（baseline）处理。answer 中没有 H.264 时 ok 为 false
+func answerMaxH264Profile(answer webrtc.SessionDescription) (rank int, ok bool) {
+	parsed, err := answer.Unmarshal()
+	if err != nil {
+		return 0, false
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "video" {
+			continue
+		}
+		h264PayloadTypes := map[string]bool{}
+		for _, attr := range media.Attributes {
+			if attr.Key != "rtpmap" {
+				continue
+			}
+			pt, codec, found := strings.Cut(attr.Value, " ")
+			if found && strings.HasPrefix(strings.ToUpper(codec), "H264/") {
+				h264PayloadTypes[pt] = true
+			}
+		}
+		for pt := range h264PayloadTypes {
+			ptRank := h264ProfileRankBaseline
+			for _, attr := range media.Attributes {
+				fmtpPT, params, found := strings.Cut(attr.Value, " ")
+				if attr.Key != "fmtp" || !found || fmtpPT != pt {
+					continue
+				}
+				for _, param := range strings.Split(params, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+					if strings.EqualFold(key, "profile-level-id") {
+						if r, valid := h264ProfileRank(value); valid {
+							ptRank = r
+						}
+					}
+				}
+			}
+			if !ok || ptRank > rank {
+				rank, ok = ptRank, true
+			}
+		}
+	}
+	return rank, ok
+}
+
+// applyAnswerH264Profile 记录 answer 中 H.264 的最高 profile；低于编码配置所需的 required 时
+// 切换到 constrained baseline 并返回 true，之后打开的编码器按 applyH264Compat 配置
+func applyAnswerH264Profile(answer webrtc.SessionDescription, required int) bool {
+	rank, ok := answerMaxH264Profile(answer)
+	if !ok {
+		return false
+	}
+	peerH264ProfileRank = rank
+	if rank >= required || h264Compat == H264CompatConstrainedBaseline {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Answer accepts H.264 up to %s, but the encoder is configured for %s; falling back to constrained baseline (no B-frames, CAVLC)\n",
+		h264ProfileRankName(rank), h264ProfileRankName(required))
+	h264Compat = H264CompatConstrainedBaseline
+	return true
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// h264_compat_encoder.go - -compat 在编码 / 转发一侧的处理（依赖 FFmpeg，SDP 部分见 h264_compat.go）
+package main
+
+import (
+	"fmt"
+
+	"github.com/asticode/go-astiav"
+)
+
+// applyH264Compat 在 -compat constrained-baseline 时把编码器选项限制为 constrained baseline；
+// 必须在设置 bf 之后调用，以覆盖其它选项
+func applyH264Compat(dict *astiav.Dictionary) error {
+	if h264Compat != H264CompatConstrainedBaseline {
+		return nil
+	}
+	for _, kv := range [][2]string{{"profile", "baseline"}, {"bf", "0"}, {"coder", "cavlc"}} {
+		if err := dict.Set(kv[0], kv[1], astiav.NewDictionaryFlags()); err != nil {
+			return fmt.Errorf("failed to set encoder option %s=%s: %w", kv[0], kv[1], err)
+		}
+	}
+	return nil
+}
+
+// sourceH264ProfileRank 返回源 H.264 profile 的等级（用于直接转发时与对端能力比较）
+func sourceH264ProfileRank(profile astiav.Profile) int {
+	switch {
+	case profile == astiav.ProfileH264Baseline, profile == astiav.ProfileH264ConstrainedBaseline:
+		return h264ProfileRankBaseline
+	case profile == astiav.ProfileH264Main:
+		return h264ProfileRankMain
+	}
+	return h264ProfileRankHigh
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+// compatOfferMedia 只用 registerCodecs 注册的编解码器（不注册 interceptor）生成 offer，返回视频 m= 段
+func compatOfferMedia(t *testing.T) sdpMediaInfo {
+	t.Helper()
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := registerCodecs(mediaEngine); err != nil {
+		t.Fatal(err)
+	}
+	// 空的 registry：不传时 pion 会注册默认 interceptor，其中的 TWCC sender 也会追加 transport-cc
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(&interceptor.Registry{}))
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerConnection.Close()
+	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	medias, err := parseSDPMedia(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(medias) != 1 {
+		t.Fatalf("offer has %d media sections, want 1", len(medias))
+	}
+	return medias[0]
+}
+
+func TestCompatCodecFeedback(t *testing.T) {
+	h264Compat = H264CompatConstrainedBaseline
+	defer func() { h264Compat = H264CompatNone }()
+
+	media := compatOfferMedia(t)
+	if names := media.codecNames(); !slices.Equal(names, []string{"h264"}) {
+		t.Fatalf("offered codecs %v, want only h264", names)
+	}
+	for _, c := range media.codecs {
+		if c.payloadType != compatH264PayloadType {
+			continue
+		}
+		for _, want := range []string{"goog-remb", "ccm fir", "nack", "nack pli", "transport-cc"} {
+			if !slices.Contains(c.feedback, want) {
+				t.Errorf("compat H.264 feedback %v is missing %q", c.feedback, want)
+			}
+		}
+		return
+	}
+	t.Fatalf("no payload type %d in the offer", compatH264PayloadType)
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// h264_pts_track.go - 按 PTS 打 RTP 时间戳的 H.264 发送轨道（用于 B 帧）
+//
+// 说明：
+//   - TrackLocalStaticSample 根据 sample.Duration 累加 RTP 时间戳，隐含 "编码顺序 == 显示顺序"
+//   - 开启 B 帧后编码器按 DTS 顺序输出，PTS 会回退，累加方式无法表示
+//   - ptsH264Track 直接使用 sample.PacketTimestamp（由 PTS 换算的 90kHz 时间戳）作为 RTP 时间戳
+package main
+
+import (
+	"math/rand/v2"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// h264SampleWriter 是发送编码后 H.264 access unit 的最小接口，
+// *webrtc.TrackLocalStaticSample 与 *ptsH264Track 都实现了它。
+type h264SampleWriter interface {
+	WriteSample(sample media.Sample) error
+}
+
+// h264RTPMTU 与 Pion 默认的 RTP 分片大小保持一致
+const h264RTPMTU = 1200
+
+// ptsH264Track 封装 TrackLocalStaticRTP，自行分片并使用 PTS 作为 RTP 时间戳
+type ptsH264Track struct {
+	track      *webrtc.TrackLocalStaticRTP
+	packetizer rtp.Packetizer
+	baseTS     uint32 // 随机起始时间戳（RFC 3550 建议）
+	lastTS     uint32 // 最近一帧的 RTP 时间戳，padding 包沿用它
+}
+
+// newPTSH264Track 创建一个按 PTS 打时间戳的 H.264 轨道
+func newPTSH264Track(id, streamID string) (*ptsH264Track, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	// SSRC 与 payload type 会在 WriteRTP 时按实际协商结果改写，这里填 0 即可
+	baseTS := rand.Uint32()
+	return &ptsH264Track{
+		track:      track,
+		packetizer: rtp.NewPacketizer(h264RTPMTU, 0, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), 90000),
+		baseTS:     baseTS,
+		lastTS:     baseTS,
+	}, nil
+}
+
+// WriteSample 将一个 access unit 分片发送，所有分片使用 sample.PacketTimestamp 作为 RTP 时间戳。
+// sample.Duration 被忽略。
+func (t *ptsH264Track) WriteSample(sample media.Sample) error {
+	for _, p := range t.packetize(sample) {
+		if err := t.track.WriteRTP(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packetize 将一个 access unit 分片为 RTP 包（不发送），供 pacer 自行安排发送时间
+func (t *ptsH264Track) packetize(sample media.Sample) []*rtp.Packet {
+	packets := t.packetizer.Packetize(sample.Data, 0)
+	t.lastTS = t.baseTS + sample.PacketTimestamp
+	// pacer 异步发送时，分片时刻才是这一帧 "写入" 的时刻
+	sentRTPTimestamps.Observe(t.lastTS)
+	for _, p := range packets {
+		p.Timestamp = t.lastTS
+	}
+	return packets
+}
+
+// GeneratePadding 发送 count 个只含 padding 的 RTP 包（与媒体包共享序列号空间，时间戳沿用最近一帧）。
+// 与 WriteSample/packetize 共用分片器，调用方需保证二者不并发。
+func (t *ptsH264Track) GeneratePadding(count uint32) error {
+	for _, p := range t.packetizer.GeneratePadding(count) {
+		p.Timestamp = t.lastTS
+		if err := t.track.WriteRTP(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,14 +11,19 @@ package main
 import (
 	"bufio"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 )
 
@@ -26,34 +31,83 @@ import (
 //
 // 参数：
 //   - track: WebRTC 远程视频轨道，用于读取 RTP 数据包
-//   - filename: 输出文件名
-//   - maxDuration: 最大录制时长（0 表示无限制）
-//   - maxSizeMB: 最大文件大小（MB，0 表示无限制）
+//   - filename: 输出文件名；"-" 表示写到 stdout（日志都在 stderr），例如 client -output - | ffplay -；
+//     以 .mp4 结尾时封装为 MP4，时间戳由 RTP 时间戳生成（见 mp4_writer.go），否则写 Annex-B 裸流
+//   - maxDuration: 最大录制时长（0 表示无限制）；只是上限，收到 server 的结束标记（eos）时提前结束
+//   - maxDuration 到达时由 time.AfterFunc 设置轨道的读取截止时间，阻塞中的读取立即返回，不必等下一个包
+//   - maxSizeMB: 最大文件大小（MB，0 表示无限制）；在写入每个 NAL 单元前检查，放不下的 NAL 单元不再写入，文件不会超过上限
 //   - sessionDir: Session 目录，用于读取 frame_metadata.csv 和写入 client_metrics.csv
 //   - frameRate: 帧率（用于计算 stall 阈值）
-func writeH264ToFile(track *webrtc.TrackRemote, filename string, maxDuration time.Duration, maxSizeMB int64, sessionDir string, frameRate float64) {
-	file, err := os.Create(filename)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to create output file: %v", err))
+//   - avSync: A/V skew 统计器（可为 nil），每个视频 RTP 包都会上报给它
+//   - bitrateWindow: 有效码率滑动窗口参数（见 BitrateWindowConfig）
+//   - startCodeMode: Annex-B start code 长度约定（见 StartCodeMode）；帧大小/码率统计按实际写入的字节计算
+//   - onPacket: 每个收到的 RTP 包在解析前都会交给它（可为 nil），例如 tee 模式转发给下游
+//   - eos: 结束标记 watcher（可为 nil）；收到 BYE 后 grace 期满时读取返回超时，按正常结束处理
+//
+// -jitter-buffer 大于 0 时包先经过抖动缓冲按序列号重排再交给 sink（见 jitter_buffer.go）；
+// 缓冲在等待缺口时读取设置截止时间，到时即使没有新包也放弃缺口、放出后面的包
+//
+// 轨道为 video/H265 时按 RFC 7798 解包（见 h265_depacketizer.go），同样写成 Annex-B
+func writeH264ToFile(track *webrtc.TrackRemote, filename string, maxDuration time.Duration, maxSizeMB int64, sessionDir string, frameRate float64, avSync *AVSyncTracker, bitrateWindow BitrateWindowConfig, startCodeMode StartCodeMode, onPacket func(pkt *rtp.Packet), eos *EndOfStreamWatcher) {
+	depacketizer, depErr := newDepacketizer(track.Codec().MimeType)
+	if depErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", depErr)
+		return
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriterSize(file, 64*1024)
+	toStdout := filename == stdoutOutput
+	mp4Output := !toStdout && isMP4Output(filename)
+	file := os.Stdout
+	var out io.Writer = file
+	var mp4 *MP4Writer
+	if toStdout {
+		// 下游播放器退出后写 stdout 返回 EPIPE，按正常结束处理，而不是被 SIGPIPE 直接终止（来不及输出汇总）
+		signal.Ignore(syscall.SIGPIPE)
+		filename = "stdout"
+	} else if mp4Output {
+		// MP4 由 muxer 写文件；sink 仍按 Annex-B 计算写入字节（帧大小、码率与 -max-size 的口径不变），只是不再落盘
+		var err error
+		if mp4, err = NewMP4Writer(filename, track.Codec().MimeType, track.Codec().ClockRate, frameRate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		defer mp4.Close()
+		file, out = nil, io.Discard
+	} else {
+		var err error
+		if file, err = os.Create(filename); err != nil {
+			panic(fmt.Sprintf("Failed to create output file: %v", err))
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer := bufio.NewWriterSize(out, 64*1024)
 	defer writer.Flush()
 
 	packetCount := 0
-	bytesWritten := int64(0)
 	lastFlushTime := time.Now()
 	startTime := time.Now()
 	maxSizeBytes := maxSizeMB * 1024 * 1024
 
-	startCode := []byte{0x00, 0x00, 0x00, 0x01}
-
-	var fuBuffer []byte
-	var fuNALType byte
+	// 到达 -max-duration 时让阻塞中的 ReadRTP 立即返回
+	var durationReached atomic.Bool
+	if maxDuration > 0 {
+		durationTimer := time.AfterFunc(maxDuration, func() {
+			durationReached.Store(true)
+			if err := track.SetReadDeadline(time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to interrupt track read at max duration: %v\n", err)
+			}
+		})
+		defer durationTimer.Stop()
+	}
 
 	fmt.Fprintf(os.Stderr, "Writing H264 stream to %s...\n", filename)
-	fmt.Fprintf(os.Stderr, "Parsing RTP payload and adding Annex-B start codes\n")
+	if mp4Output {
+		fmt.Fprintf(os.Stderr, "Parsing RTP payload and muxing access units into MP4 (timestamps from RTP)\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "Parsing RTP payload and adding Annex-B start codes\n")
+	}
 	if maxDuration > 0 {
 		fmt.Fprintf(os.Stderr, "Max duration: %v\n", maxDuration)
 	}
@@ -64,92 +118,54 @@ func writeH264ToFile(track *webrtc.TrackRemote, filename string, maxDuration tim
 	lastReadTime := time.Now()
 	readTimeout := 5 * time.Second
 
-	// 读取 server 的开始时间（如果存在），用于统一时间基准
-	var serverStartTime time.Time
-	if sessionDir != "" {
-		startTimePath := filepath.Join(sessionDir, "start_time.txt")
-		if data, err := os.ReadFile(startTimePath); err == nil {
-			if startTimeMs, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
-				serverStartTime = time.Unix(0, startTimeMs*int64(time.Millisecond))
-				fmt.Fprintf(os.Stderr, "Loaded server start time from %s: %d ms\n", startTimePath, startTimeMs)
-			} else {
-				fmt.Fprintf(os.Stderr, "Warning: Failed to parse start_time.txt: %v\n", err)
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "Warning: Could not read start_time.txt: %v (will use client start time)\n", err)
-		}
-	}
-
-	// 读取 server 的 frame metadata（如果存在）
-	var frameMetadataMap map[int]FrameMetadata
-	if sessionDir != "" {
-		metadataPath := filepath.Join(sessionDir, "frame_metadata.csv")
-		if metadata, err := loadFrameMetadata(metadataPath); err == nil {
-			frameMetadataMap = metadata
-			fmt.Fprintf(os.Stderr, "Loaded %d frame metadata entries from %s\n", len(frameMetadataMap), metadataPath)
-		} else {
-			fmt.Fprintf(os.Stderr, "Warning: Could not load frame metadata: %v\n", err)
-		}
-	}
-
-	// 创建 metrics CSV writer（如果 sessionDir 存在）
-	// 如果 server 开始时间可用，使用它作为基准；否则使用 client 开始时间
-	var metricsWriter *MetricsCSVWriter
-	if sessionDir != "" {
-		csvPath := filepath.Join(sessionDir, "client_metrics.csv")
-		var err error
-		if !serverStartTime.IsZero() {
-			// 使用 server 的开始时间作为基准
-			metricsWriter, err = NewMetricsCSVWriterWithStartTime(csvPath, serverStartTime)
-		} else {
-			// 使用 client 的开始时间作为基准
-			metricsWriter, err = NewMetricsCSVWriter(csvPath)
-		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to create metrics CSV writer: %v\n", err)
-		} else {
-			defer metricsWriter.Close()
-		}
-	}
-
 	// 帧检测和指标计算相关变量
-	frameID := 0
-	var lastFrameReceiveTime time.Time
 	normalFrameInterval := time.Duration(0)
 	if frameRate > 0 {
 		normalFrameInterval = time.Duration(float64(time.Second) / frameRate)
 	}
 	stallThreshold := normalFrameInterval * 2 // 2倍正常帧间隔
 
-	// 有效码率计算：滑动窗口（最近1秒）
-	var bitWindow []BitSample
-	windowDuration := 1 * time.Second
-	var lastFrameBytesWritten int64 = 0
-	var lastEffectiveBitrateKbps float64 = 0 // 保存上一帧的码率，用于处理异常值
-
-	writeNALUnit := func(nalData []byte) error {
-		if len(nalData) == 0 {
-			return nil
-		}
-		if _, err := writer.Write(startCode); err != nil {
-			return err
-		}
-		n, err := writer.Write(nalData)
-		if err != nil {
-			return err
+	var metricsPath string
+	if sessionDir != "" {
+		metricsPath = filepath.Join(sessionDir, "client_metrics.csv")
+	}
+	sink := newH264StreamSink(writer, sessionDir, metricsPath, startTime, frameRate, stallThreshold, bitrateWindow, startCodeMode)
+	sink.avSync = avSync
+	sink.clockRate = track.Codec().ClockRate
+	sink.depacketizer = depacketizer
+	sink.mp4 = mp4
+	sink.maxBytes = maxSizeBytes
+	if _, isH264 := depacketizer.(*H264Depacketizer); !isH264 {
+		sink.paramSets = nil
+	}
+	defer sink.Close()
+	jitter := NewJitterBuffer(jitterBufferDelay)
+	if jitter != nil {
+		if playoutPolicy == PlayoutAdaptive {
+			jitter.EnableAdaptive(track.Codec().ClockRate)
+			fmt.Fprintf(os.Stderr, "Jitter buffer (adaptive playout): reordering packets by sequence number, waiting %v for missing packets at first, "+
+				"adjusted between %v and %v from interarrival jitter\n", jitter.delay, playoutAdaptiveMinDelay, playoutAdaptiveMaxDelay)
+		} else {
+			fmt.Fprintf(os.Stderr, "Jitter buffer (%s playout): reordering packets by sequence number, waiting up to %v for missing packets\n",
+				playoutPolicy.label(), jitterBufferDelay)
 		}
-		bytesWritten += int64(len(startCode) + n)
-		return nil
+		jitter.log = playoutLog
+		playoutLog.Begin()
 	}
+	defer jitter.Report()
+	var armedDeadline time.Time
+	rtpDump.Begin(frameRate, startTime)
+	rawYUV.SetFrameRate(frameRate)
+	firstKeyframe.Start(startTime)
 
 	for {
-		if maxDuration > 0 && time.Since(startTime) >= maxDuration {
+		if durationReached.Load() {
 			fmt.Fprintf(os.Stderr, "Max duration (%v) reached, stopping...\n", maxDuration)
 			break
 		}
 
-		if maxSizeMB > 0 && bytesWritten >= maxSizeBytes {
-			fmt.Fprintf(os.Stderr, "Max size (%d MB) reached, stopping...\n", maxSizeMB)
+		if firstKeyframe.Expired() {
+			fmt.Fprintf(os.Stderr, "No keyframe received, stopping...\n")
 			break
 		}
 
@@ -158,13 +174,48 @@ func writeH264ToFile(track *webrtc.TrackRemote, filename string, maxDuration tim
 			break
 		}
 
-		rtpPacket, _, readErr := track.ReadRTP()
+		// 抖动缓冲在等待缺口时，读取最多阻塞到放弃缺口的时刻；重新计算一次，避免覆盖同时设置的结束标记 grace
+		jitterWake := false
+		if jitter != nil {
+			deadline, wake := jitter.ReadDeadline(eos)
+			for !deadline.Equal(armedDeadline) {
+				if err := track.SetReadDeadline(deadline); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to set track read deadline for the jitter buffer: %v\n", err)
+				}
+				armedDeadline = deadline
+				deadline, wake = jitter.ReadDeadline(eos)
+			}
+			jitterWake = wake
+			// -max-duration 的定时器可能刚好在这之前设置过截止时间，已被覆盖
+			if durationReached.Load() {
+				fmt.Fprintf(os.Stderr, "Max duration (%v) reached, stopping...\n", maxDuration)
+				break
+			}
+		}
+
+		rtpPacket, attributes, readErr := track.ReadRTP()
 		if readErr != nil {
-			if readErr == io.EOF {
+			if durationReached.Load() {
+				fmt.Fprintf(os.Stderr, "Max duration (%v) reached, stopping...\n", maxDuration)
+				break
+			}
+			if jitterWake && isReadTimeout(readErr) {
+				jitter.Release(time.Now(), sink.WritePacket)
+				if sink.SizeLimitReached() {
+					fmt.Fprintf(os.Stderr, "Max size (%d MB) reached, stopping (%d bytes written)...\n", maxSizeMB, sink.bytesWritten)
+					break
+				}
+				continue
+			}
+			if eos.Received() {
+				fmt.Fprintf(os.Stderr, "Stream ended by server (end of stream), stopping...\n")
+				break
+			}
+			if errors.Is(readErr, io.EOF) {
 				fmt.Fprintf(os.Stderr, "Track ended (EOF)\n")
 				break
 			}
-			if strings.Contains(readErr.Error(), "closed") || strings.Contains(readErr.Error(), "EOF") {
+			if isConnectionClosed(readErr) {
 				fmt.Fprintf(os.Stderr, "Connection closed: %v\n", readErr)
 				break
 			}
@@ -178,116 +229,387 @@ func writeH264ToFile(track *webrtc.TrackRemote, filename string, maxDuration tim
 
 		lastReadTime = time.Now()
 		packetCount++
-
-		payload := rtpPacket.Payload
-		if len(payload) < 1 {
-			continue
+		if onPacket != nil {
+			onPacket(rtpPacket)
 		}
 
-		nalHeader := payload[0]
-		nalType := nalHeader & 0x1F
-
-		// 检测帧边界：NAL type 1 (非IDR) 或 5 (IDR) 表示新帧开始
-		isFrameStart := false
-		if nalType == 1 || nalType == 5 {
-			isFrameStart = true
+		rtx := attributes != nil && attributes.Get(webrtc.AttributeRtxSsrc) != nil
+		if id, ok := absSendTimeExtensionID(attributes); ok {
+			sink.absSendTimeID = id
+		}
+		rtpDump.WritePacket(rtpPacket, rtx, lastReadTime)
+		// 在重排之前检查缺口，缺失的包尽早请求重传
+		nackSender.OnPacket(rtpPacket, lastReadTime)
+		jitter.Push(rtpPacket, rtx, lastReadTime, sink.WritePacket)
+		if sink.SizeLimitReached() {
+			fmt.Fprintf(os.Stderr, "Max size (%d MB) reached, stopping (%d bytes written)...\n", maxSizeMB, sink.bytesWritten)
+			break
 		}
 
-		switch {
-		case nalType >= 1 && nalType <= 23:
-			if err := writeNALUnit(payload); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing NAL unit: %v\n", err)
-				continue
-			}
-			// 如果是帧开始，记录帧指标
-			if isFrameStart {
-				bitWindow, _ = recordFrameMetrics(&frameID, &lastFrameReceiveTime, normalFrameInterval, stallThreshold,
-					frameMetadataMap, bitWindow, windowDuration, metricsWriter, bytesWritten, &lastFrameBytesWritten, serverStartTime, &lastEffectiveBitrateKbps)
-			}
-			fuBuffer = nil
-
-		case nalType == 24:
-			offset := 1
-			for offset < len(payload) {
-				if offset+2 > len(payload) {
-					break
-				}
-				nalSize := int(payload[offset])<<8 | int(payload[offset+1])
-				offset += 2
-				if offset+nalSize > len(payload) {
-					break
-				}
-				nalData := payload[offset : offset+nalSize]
-				if err := writeNALUnit(nalData); err != nil {
-					fmt.Fprintf(os.Stderr, "Error writing STAP-A NAL unit: %v\n", err)
-					break
-				}
-				offset += nalSize
-			}
-			fuBuffer = nil
-
-		case nalType == 28:
-			if len(payload) < 2 {
-				continue
-			}
-			fuHeader := payload[1]
-			start := (fuHeader & 0x80) != 0
-			end := (fuHeader & 0x40) != 0
-			actualNALType := fuHeader & 0x1F
-
-			if start {
-				fuNALType = actualNALType
-				fuBuffer = []byte{(nalHeader & 0xE0) | actualNALType}
-				fuBuffer = append(fuBuffer, payload[2:]...)
-			} else {
-				if fuBuffer != nil && (fuHeader&0x1F) == fuNALType {
-					fuBuffer = append(fuBuffer, payload[2:]...)
-				} else {
-					fuBuffer = nil
-					continue
-				}
-			}
-
-			if end {
-				if fuBuffer != nil {
-					if err := writeNALUnit(fuBuffer); err != nil {
-						fmt.Fprintf(os.Stderr, "Error writing FU-A NAL unit: %v\n", err)
-					}
-					// FU-A 结束表示完整 NAL 单元，检查是否是帧开始
-					if fuNALType == 1 || fuNALType == 5 {
-						bitWindow, _ = recordFrameMetrics(&frameID, &lastFrameReceiveTime, normalFrameInterval, stallThreshold,
-							frameMetadataMap, bitWindow, windowDuration, metricsWriter, bytesWritten, &lastFrameBytesWritten, serverStartTime, &lastEffectiveBitrateKbps)
-					}
-					fuBuffer = nil
-				}
+		// 管道的另一端在实时播放，每个包都立即写出；写失败说明播放器已经退出
+		if toStdout {
+			if err := writer.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "Output pipe closed (%v), stopping...\n", err)
+				break
 			}
-
-		default:
-			fmt.Fprintf(os.Stderr, "Warning: Unsupported NAL type %d, skipping\n", nalType)
 		}
 
 		if time.Since(lastFlushTime) > 1*time.Second {
 			writer.Flush()
-			file.Sync()
+			if file != nil && !toStdout {
+				file.Sync()
+			}
 			elapsed := time.Since(startTime)
-			sizeMB := float64(bytesWritten) / (1024 * 1024)
+			sizeMB := float64(sink.bytesWritten) / (1024 * 1024)
 			fmt.Fprintf(os.Stderr, "Progress: %d packets, %.2f MB, %v elapsed\n", packetCount, sizeMB, elapsed.Round(time.Second))
 			lastFlushTime = time.Now()
 		}
 	}
 
-	if fuBuffer != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Discarding incomplete FU-A fragment\n")
+	if !sink.SizeLimitReached() {
+		jitter.Flush(time.Now(), sink.WritePacket)
 	}
+	sink.Finish()
 
 	writer.Flush()
-	file.Sync()
 	elapsed := time.Since(startTime)
-	sizeMB := float64(bytesWritten) / (1024 * 1024)
+	sizeMB := float64(sink.bytesWritten) / (1024 * 1024)
 	fmt.Fprintf(os.Stderr, "Completed: %d packets, %.2f MB, %v elapsed\n", packetCount, sizeMB, elapsed)
+	if toStdout || mp4Output {
+		return
+	}
+	file.Sync()
 	fmt.Fprintf(os.Stderr, "File flushed and synced to disk\n")
 	fmt.Fprintf(os.Stderr, "You can now use FFmpeg to process this file:\n")
-	fmt.Fprintf(os.Stderr, "  ffmpeg -fflags +genpts -r 30 -i %s -c:v copy received.mp4\n", filename)
+	fmt.Fprintf(os.Stderr, "  ffmpeg -fflags +genpts -r %g -i %s -c:v copy received.mp4\n", frameRate, filename)
+}
+
+// stdoutOutput 是表示写到 stdout 的 -output 取值
+const stdoutOutput = "-"
+
+// h264StreamSink 把按到达顺序交给它的 RTP 包解析为 Annex-B 写入 writer，并在每帧开始时记录帧指标。
+// 实时接收（writeH264ToFile）与离线重放（-replay-metadata）共用，重放时到达时间取自 dump 而不是当前时间
+type h264StreamSink struct {
+	writer        io.Writer
+	startCodeMode StartCodeMode
+	bytesWritten  int64
+	// maxBytes > 0 时写入的字节数不超过它（-max-size）；放不下的 NAL 单元丢弃，之后不再写入
+	maxBytes         int64
+	sizeLimitReached bool
+
+	avSync    *AVSyncTracker // 可为 nil
+	clockRate uint32
+
+	// 按轨道编码格式选择的解包器，默认 H.264（见 depacketizer.go）
+	depacketizer Depacketizer
+	// -output 为 .mp4 时非 nil，按 access unit 封装写入的 NAL 单元
+	mp4 *MP4Writer
+
+	// 帧指标
+	frameID              int
+	lastFrameReceiveTime time.Time
+	normalFrameInterval  time.Duration
+	stallThreshold       time.Duration
+	frameMetadataMap     map[int]FrameMetadata
+	serverStartTime      time.Time
+	// 协商到的 abs-send-time 扩展 ID（见 abs_send_time.go），0 表示没有；没有 metadata 时用它计算延迟
+	absSendTimeID uint8
+	// server 帧号按 RTP 时间戳的索引（见 frameIDsByRTPTimestamp）；为空时按收到的帧计数
+	frameIDByRTPTimestamp    map[uint32]int
+	lastFrameRTPTimestamp    uint32
+	haveFrameRTPTimestamp    bool
+	// 上一次记录帧指标时的 RTP 时间戳，用于得到发送端预期的帧间隔（见 intendedFrameInterval）
+	lastStartRTPTimestamp uint32
+	haveStartRTPTimestamp bool
+	matchedFrames            int
+	unmatchedFrames          int
+	metricsWriter            *MetricsCSVWriter
+	bitWindow                []BitSample
+	bitrateWindow            BitrateWindowConfig
+	lastFrameBytesWritten    int64
+	lastEffectiveBitrateKbps float64 // 保存上一帧的码率，用于处理异常值
+
+	// 重传（rtx）统计：pion 已把 rtx 包还原为原始 SSRC / 序列号，这里按序列号过滤。
+	// 写文件按交给 sink 的顺序进行，sink 自身没有重排缓冲（-jitter-buffer 在 sink 之前重排），晚到的重传包（序列号不大于已收到的最大值）只计数不写入，
+	// 否则会把旧帧的数据插入当前帧之后，并打乱帧计数与 frame_metadata 的对应关系。
+	highestSeq              uint16
+	haveSeq                 bool
+	rtxPackets, latePackets int64
+
+	// 缓存最近的 SPS/PPS：参数集变化后的 IDR 没有带上它们时在其前面补写（见 param_sets.go）；只用于 H.264，其它格式为 nil
+	paramSets       *h264ParamSets
+	endOfAccessUnit bool
+}
+
+// newH264StreamSink 创建 sink：从 metadataDir 读取 start_time.txt 与 frame_metadata.csv（为空时不读取），
+// metricsPath 非空时写入逐帧指标 CSV；没有 server 开始时间时以 clientStart 作为指标的时间基准
+func newH264StreamSink(writer io.Writer, metadataDir, metricsPath string, clientStart time.Time, frameRate float64, stallThreshold time.Duration,
+	bitrateWindow BitrateWindowConfig, startCodeMode StartCodeMode) *h264StreamSink {
+	s := &h264StreamSink{
+		writer:         writer,
+		startCodeMode:  startCodeMode,
+		stallThreshold: stallThreshold,
+		bitrateWindow:  bitrateWindow,
+		paramSets:      newH264ParamSets("[Client]"),
+		depacketizer:   newH264Depacketizer(),
+	}
+	if frameRate > 0 {
+		s.normalFrameInterval = time.Duration(float64(time.Second) / frameRate)
+	}
+
+	// 读取 server 的开始时间（如果存在），用于统一时间基准
+	if metadataDir != "" {
+		startTimePath := filepath.Join(metadataDir, "start_time.txt")
+		if data, err := os.ReadFile(startTimePath); err == nil {
+			if startTimeMs, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+				s.serverStartTime = time.Unix(0, startTimeMs*int64(time.Millisecond))
+				fmt.Fprintf(os.Stderr, "Loaded server start time from %s: %d ms\n", startTimePath, startTimeMs)
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: Failed to parse start_time.txt: %v\n", err)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Could not read start_time.txt: %v (will use client start time)\n", err)
+		}
+	}
+
+	// 读取 server 的 frame metadata（如果存在）
+	if metadataDir != "" {
+		metadataPath := filepath.Join(metadataDir, "frame_metadata.csv")
+		if metadata, err := loadFrameMetadata(metadataPath); err == nil {
+			s.frameMetadataMap = metadata
+			s.frameIDByRTPTimestamp = frameIDsByRTPTimestamp(metadata)
+			fmt.Fprintf(os.Stderr, "Loaded %d frame metadata entries from %s (%d with RTP timestamps)\n",
+				len(s.frameMetadataMap), metadataPath, len(s.frameIDByRTPTimestamp))
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: Could not load frame metadata: %v\n", err)
+		}
+	}
+
+	// 创建 metrics CSV writer
+	// 如果 server 开始时间可用，使用它作为基准；否则使用 client 开始时间
+	if metricsPath != "" {
+		base := clientStart
+		if !s.serverStartTime.IsZero() {
+			// 使用 server 的开始时间作为基准
+			base = s.serverStartTime
+		}
+		var err error
+		s.metricsWriter, err = NewMetricsCSVWriterWithStartTime(metricsPath, base)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to create metrics CSV writer: %v\n", err)
+			s.metricsWriter = nil
+		}
+	}
+	return s
+}
+
+// errMaxSizeReached 表示写入下一个 NAL 单元会超过 -max-size
+var errMaxSizeReached = errors.New("max size reached")
+
+// SizeLimitReached 返回是否因为 -max-size 停止了写入
+func (s *h264StreamSink) SizeLimitReached() bool {
+	return s.sizeLimitReached
+}
+
+// writeNALUnit 写入一个 NAL 单元（前面加 start code），参数集变化后的 IDR 前补写缓存的 SPS/PPS；
+// 设置了 maxBytes 时，连同补写的参数集放不下就整体不写，返回 errMaxSizeReached
+func (s *h264StreamSink) writeNALUnit(nalData []byte) error {
+	if len(nalData) == 0 {
+		return nil
+	}
+	if s.sizeLimitReached {
+		return errMaxSizeReached
+	}
+	kind := s.depacketizer.Kind(nalData)
+	var paramSets [][]byte
+	if kind == nalKindKeyframe && s.paramSets != nil {
+		paramSets = s.paramSets.PendingBeforeIDR()
+	}
+	if s.maxBytes > 0 {
+		size := int64(len(s.startCodeMode.startCode(kind)) + len(nalData))
+		for _, ps := range paramSets {
+			size += int64(len(s.startCodeMode.startCode(nalKindParamSet)) + len(ps))
+		}
+		if s.bytesWritten+size > s.maxBytes {
+			s.sizeLimitReached = true
+			return errMaxSizeReached
+		}
+	}
+	if kind == nalKindKeyframe && s.paramSets != nil {
+		for _, ps := range s.paramSets.BeforeIDR() {
+			startCode := s.startCodeMode.startCode(nalKindParamSet)
+			if _, err := s.writer.Write(startCode); err != nil {
+				return err
+			}
+			if _, err := s.writer.Write(ps); err != nil {
+				return err
+			}
+			s.bytesWritten += int64(len(startCode) + len(ps))
+			rawYUV.AddNAL(startCode, ps)
+			s.mp4.AddNAL(startCode, ps, nalKindParamSet)
+		}
+	}
+	if s.paramSets != nil {
+		s.paramSets.Observe(nalData)
+	}
+	startCode := s.startCodeMode.startCode(kind)
+	if _, err := s.writer.Write(startCode); err != nil {
+		return err
+	}
+	n, err := s.writer.Write(nalData)
+	if err != nil {
+		return err
+	}
+	s.bytesWritten += int64(len(startCode) + n)
+	receivedFrameHashes.AddNAL(nalData)
+	rawYUV.AddNAL(startCode, nalData)
+	s.mp4.AddNAL(startCode, nalData, kind)
+	return nil
+}
+
+// recordFrame 在一帧开始时记录帧指标，rtpTimestamp 为该帧开始的包的 RTP 时间戳，sendTime 为该包的 abs-send-time（没有时为零值）
+//
+// server 的 frame_metadata.csv 带有 RTP 时间戳时，帧号直接取 server 的帧号：丢帧后计数不会错位，
+// 与 metadata 的延迟对应关系也不会整体偏移。同一时间戳的后续 slice 属于同一帧，不再记录。
+// 找不到时间戳的帧（旧的 metadata、server 未记录）沿用计数，从最近一个匹配的帧号继续。
+func (s *h264StreamSink) recordFrame(arrival time.Time, rtpTimestamp uint32, sendTime time.Time) {
+	if id, ok := s.frameIDByRTPTimestamp[rtpTimestamp]; ok {
+		if s.haveFrameRTPTimestamp && rtpTimestamp == s.lastFrameRTPTimestamp {
+			return
+		}
+		s.lastFrameRTPTimestamp, s.haveFrameRTPTimestamp = rtpTimestamp, true
+		s.matchedFrames++
+		// recordFrameMetrics 会先把帧号加一
+		s.frameID = id - 1
+	} else if len(s.frameIDByRTPTimestamp) > 0 {
+		s.unmatchedFrames++
+	}
+	s.bitWindow, _ = recordFrameMetrics(&s.frameID, &s.lastFrameReceiveTime, arrival, s.normalFrameInterval, s.stallThreshold, s.intendedFrameInterval(rtpTimestamp),
+		s.frameMetadataMap, s.bitWindow, s.bitrateWindow, s.metricsWriter, s.bytesWritten, &s.lastFrameBytesWritten, s.serverStartTime, sendTime, &s.lastEffectiveBitrateKbps)
+}
+
+// intendedFrameInterval 返回本帧与上一次记录的帧之间 RTP 时间戳（视频为 90kHz）的间隔，即发送端预期的帧间隔；
+// 第一帧、同一时间戳的后续 slice 以及时间戳回退（B 帧）时返回 0，由调用方按固定帧率处理
+func (s *h264StreamSink) intendedFrameInterval(rtpTimestamp uint32) time.Duration {
+	last, have := s.lastStartRTPTimestamp, s.haveStartRTPTimestamp
+	s.lastStartRTPTimestamp, s.haveStartRTPTimestamp = rtpTimestamp, true
+	if !have {
+		return 0
+	}
+	delta := int32(rtpTimestamp - last)
+	if delta <= 0 {
+		return 0
+	}
+	clockRate := s.clockRate
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+	return time.Duration(int64(delta) * int64(time.Second) / int64(clockRate))
+}
+
+// WritePacket 处理一个 RTP 包；rtx 表示该包由重传流还原而来，arrival 为到达时间
+func (s *h264StreamSink) WritePacket(rtpPacket *rtp.Packet, rtx bool, arrival time.Time) {
+	if rtx {
+		s.rtxPackets++
+	}
+	if s.haveSeq && int16(rtpPacket.SequenceNumber-s.highestSeq) <= 0 {
+		s.latePackets++
+		// 重传补回的包算作已收到，健康统计中的丢包率反映最终丢失
+		healthStats.AddPackets(0, 1)
+		return
+	}
+	lostBefore := 0
+	if s.haveSeq {
+		expected := int(rtpPacket.SequenceNumber - s.highestSeq)
+		healthStats.AddPackets(expected, 1)
+		lostBefore = expected - 1
+	} else {
+		healthStats.AddPackets(1, 1)
+	}
+	s.highestSeq, s.haveSeq = rtpPacket.SequenceNumber, true
+	hasIDR := s.depacketizer.HasKeyframe(rtpPacket.Payload)
+	recoveryFrames := -1
+	if frames, ok := s.depacketizer.RecoveryPoint(rtpPacket.Payload); ok {
+		recoveryFrames = frames
+	}
+	keyframeRecovery.OnPacket(lostBefore, rtpPacket.Marker, hasIDR, recoveryFrames, arrival)
+	// intra-refresh 流中只有第一帧是 IDR，中途加入或错过它时从 recovery point 开始解码
+	firstKeyframe.OnPacket(hasIDR || recoveryFrames >= 0, len(rtpPacket.Payload), arrival)
+
+	s.avSync.OnRTP(webrtc.RTPCodecTypeVideo, rtpPacket.SSRC, rtpPacket.Timestamp, s.clockRate, arrival)
+
+	payload := rtpPacket.Payload
+	if len(payload) < 1 {
+		return
+	}
+
+	// 上一个包带 marker 位时，本包开始新的 access unit
+	if s.endOfAccessUnit && s.paramSets != nil {
+		s.paramSets.EndAccessUnit()
+	}
+	s.endOfAccessUnit = rtpPacket.Marker
+	s.mp4.BeginPacket(rtpPacket.Timestamp)
+	if rtpPacket.Marker {
+		// 本包处理完后 access unit 完整，交给 -output-raw-yuv 解码、写入 MP4
+		defer rawYUV.EndAccessUnit()
+		defer s.mp4.EndAccessUnit()
+	}
+
+	// 帧号优先按 RTP 时间戳查 frame_metadata；查不到时按到达（解码）顺序计数，与 server 端按发送顺序编号的 frame_metadata 对应，
+	// 两种方式都不要求时间戳单调（开启 B 帧时 RTP 时间戳随 PTS 回退）。
+	nals, frameStart := s.depacketizer.Depacketize(payload, rtpPacket.SequenceNumber)
+	for _, nalData := range nals {
+		if err := s.writeNALUnit(nalData); err != nil {
+			if !errors.Is(err, errMaxSizeReached) {
+				reportRecoverableError("Error writing NAL unit", err)
+			}
+			return
+		}
+	}
+	// 包中有开始新帧的 slice 时记录帧指标
+	if frameStart {
+		sendTime, _ := absSendTime(rtpPacket, s.absSendTimeID, arrival)
+		s.recordFrame(arrival, rtpPacket.Timestamp, sendTime)
+	}
+}
+
+// frameIDsByRTPTimestamp 建立 RTP 时间戳到 server 帧号的索引；
+// 多帧使用同一时间戳（例如 -loop 后时间戳回绕）时无法区分，这些时间戳不放入索引
+func frameIDsByRTPTimestamp(metadata map[int]FrameMetadata) map[uint32]int {
+	index := make(map[uint32]int)
+	ambiguous := make(map[uint32]bool)
+	for id, m := range metadata {
+		if !m.HasRTPTimestamp || ambiguous[m.RTPTimestamp] {
+			continue
+		}
+		if _, dup := index[m.RTPTimestamp]; dup {
+			delete(index, m.RTPTimestamp)
+			ambiguous[m.RTPTimestamp] = true
+			continue
+		}
+		index[m.RTPTimestamp] = id
+	}
+	return index
+}
+
+// Finish 在最后一个包之后调用，打印未完成的分片与重传统计
+func (s *h264StreamSink) Finish() {
+	s.depacketizer.Finish()
+	if s.rtxPackets > 0 || s.latePackets > 0 {
+		fmt.Fprintf(os.Stderr, "Retransmissions: %d rtx packets received, %d late/duplicate packets skipped (use -jitter-buffer to reorder them)\n", s.rtxPackets, s.latePackets)
+	}
+	if len(s.frameIDByRTPTimestamp) > 0 {
+		fmt.Fprintf(os.Stderr, "Frame IDs: %d frames matched to server frame IDs by RTP timestamp, %d unmatched (numbered by count)\n",
+			s.matchedFrames, s.unmatchedFrames)
+	}
+}
+
+// Close 关闭指标 CSV 并打印参数集汇总
+func (s *h264StreamSink) Close() {
+	if s.paramSets != nil {
+		s.paramSets.Report()
+	}
+	s.metricsWriter.Close()
 }
 
 // loadFrameMetadata 从 CSV 文件加载帧元数据
@@ -304,6 +626,16 @@ func loadFrameMetadata(csvPath string) (map[int]FrameMetadata, error) {
 		return nil, err
 	}
 
+	// rtp_timestamp 列是后来加入的，按表头查找，旧文件没有这一列
+	rtpTimestampCol := -1
+	if len(records) > 0 {
+		for i, name := range records[0] {
+			if name == "rtp_timestamp" {
+				rtpTimestampCol = i
+			}
+		}
+	}
+
 	metadataMap := make(map[int]FrameMetadata)
 	for i, record := range records {
 		if i == 0 {
@@ -331,7 +663,7 @@ func loadFrameMetadata(csvPath string) (map[int]FrameMetadata, error) {
 		}
 
 		// 保存相对时间戳（毫秒），用于端到端延迟计算
-		metadataMap[frameID] = FrameMetadata{
+		metadata := FrameMetadata{
 			FrameID:     frameID,
 			SendStart:   time.Unix(0, sendStartMs*int64(time.Millisecond)), // 保留用于兼容
 			SendEnd:     time.Unix(0, sendEndMs*int64(time.Millisecond)),   // 保留用于兼容
@@ -339,6 +671,12 @@ func loadFrameMetadata(csvPath string) (map[int]FrameMetadata, error) {
 			SendStartMs: sendStartMs, // 相对时间戳（毫秒）
 			SendEndMs:   sendEndMs,   // 相对时间戳（毫秒）
 		}
+		if rtpTimestampCol >= 0 && rtpTimestampCol < len(record) && record[rtpTimestampCol] != "" {
+			if ts, err := strconv.ParseUint(record[rtpTimestampCol], 10, 32); err == nil {
+				metadata.RTPTimestamp, metadata.HasRTPTimestamp = uint32(ts), true
+			}
+		}
+		metadataMap[frameID] = metadata
 	}
 
 	return metadataMap, nil
@@ -350,51 +688,88 @@ type BitSample struct {
 	Bits  int64
 }
 
-// recordFrameMetrics 记录一帧的指标（延迟、stall、有效码率）
-// 返回更新后的 bitWindow 和计算出的 effectiveBitrateKbps
-func recordFrameMetrics(frameID *int, lastFrameReceiveTime *time.Time,
-	normalFrameInterval time.Duration, stallThreshold time.Duration,
-	frameMetadataMap map[int]FrameMetadata, bitWindow []BitSample, windowDuration time.Duration,
-	metricsWriter *MetricsCSVWriter, currentBytesWritten int64, lastFrameBytesWritten *int64, serverStartTime time.Time,
-	lastEffectiveBitrateKbps *float64) ([]BitSample, float64) {
+// BitrateWindowConfig 控制有效码率滑动窗口的计算方式
+//
+// 窗口越长，报告的码率越平滑（单个大关键帧被摊薄），但对码率突变的响应越慢；
+// 窗口越短则越能反映瞬时突发，曲线抖动也越大。低帧率实验应相应加长窗口，
+// 保证窗口内至少有 MinFrames 帧，否则会一直沿用上一次的码率。
+type BitrateWindowConfig struct {
+	Duration  time.Duration // 窗口时长
+	MinSpan   time.Duration // 窗口内首尾样本的最小时间跨度，低于此值不计算
+	MinFrames int           // 窗口内的最少帧数，低于此值不计算
+}
 
-	receiveTime := time.Now()
-	*frameID++
+// defaultBitrateWindowConfig 返回默认的窗口参数（1 秒窗口，至少 10ms 且 5 帧）
+func defaultBitrateWindowConfig() BitrateWindowConfig {
+	return BitrateWindowConfig{
+		Duration:  1 * time.Second,
+		MinSpan:   10 * time.Millisecond,
+		MinFrames: 5,
+	}
+}
+
+// StartCodeMode 决定写入 Annex-B 文件时每个 NAL 前的 start code 长度
+type StartCodeMode int
+
+const (
+	StartCodeLong  StartCodeMode = iota // 全部使用 4 字节 00 00 00 01（默认）
+	StartCodeShort                      // 全部使用 3 字节 00 00 01
+	StartCodeSpec                       // SPS/PPS/IDR（H.265 为 VPS/SPS/PPS/IRAP）使用 4 字节，其余 NAL 使用 3 字节
+)
+
+var (
+	longStartCode  = []byte{0x00, 0x00, 0x00, 0x01}
+	shortStartCode = []byte{0x00, 0x00, 0x01}
+)
 
-	// 计算端到端延迟（如果 server metadata 存在）
-	// 现在 server 和 client 使用统一的时间基准（server 的开始时间），可以计算端到端延迟
-	var e2eLatencyMs float64
-	if metadata, ok := frameMetadataMap[*frameID]; ok && !serverStartTime.IsZero() {
-		// metadata.SendStartMs 是 server 的相对时间戳（毫秒，从 server 开始时间算起）
-		// receiveTime 是 client 的绝对时间，需要转换为相对于 server 开始时间的相对时间戳（毫秒）
-		clientRelativeMs := receiveTime.Sub(serverStartTime).Milliseconds()
-		// 端到端延迟 = client相对时间 - server相对时间
-		e2eLatencyMs = float64(clientRelativeMs - metadata.SendStartMs)
-	}
-
-	// 计算帧间隔延迟
-	var interFrameLatencyMs float64
-	var stall bool
-	if !lastFrameReceiveTime.IsZero() {
-		interFrameLatency := receiveTime.Sub(*lastFrameReceiveTime)
-		interFrameLatencyMs = float64(interFrameLatency.Nanoseconds()) / 1e6
-		// 检测 stall：帧间隔 > 2倍正常帧间隔
-		if stallThreshold > 0 && interFrameLatency > stallThreshold {
-			stall = true
+// parseStartCodeMode 解析 -start-code 参数：4、3 或 spec
+func parseStartCodeMode(s string) (StartCodeMode, error) {
+	switch s {
+	case "4":
+		return StartCodeLong, nil
+	case "3":
+		return StartCodeShort, nil
+	case "spec":
+		return StartCodeSpec, nil
+	default:
+		return StartCodeLong, fmt.Errorf("invalid start code mode %q (expected 4, 3 or spec)", s)
+	}
+}
+
+// startCode 返回给定类别的 NAL 应使用的 start code
+func (m StartCodeMode) startCode(kind nalKind) []byte {
+	switch m {
+	case StartCodeShort:
+		return shortStartCode
+	case StartCodeSpec:
+		if kind == nalKindKeyframe || kind == nalKindParamSet {
+			return longStartCode
 		}
-	} else {
-		// 第一帧，使用端到端延迟作为帧间隔延迟
-		interFrameLatencyMs = e2eLatencyMs
+		return shortStartCode
+	default:
+		return longStartCode
 	}
+}
+
+// recordFrameMetrics 记录一帧的指标（延迟、stall、有效码率），receiveTime 为该帧的到达时间
+//
+// intendedInterval 为按 RTP 时间戳得到的发送端帧间隔（0 表示未知）：已知时 stall 阈值按它缩放（与正常帧间隔的倍数不变），
+// 发送端本来就隔得久的帧（跳帧、降帧率、暂停后恢复）不算 stall，按固定帧率正常到达但发送端本应更密的帧反而能被发现；
+// 未知时沿用按帧率得到的固定阈值。
+// 返回更新后的 bitWindow 和计算出的 effectiveBitrateKbps
+func recordFrameMetrics(frameID *int, lastFrameReceiveTime *time.Time, receiveTime time.Time,
+	normalFrameInterval time.Duration, stallThreshold time.Duration, intendedInterval time.Duration,
+	frameMetadataMap map[int]FrameMetadata, bitWindow []BitSample, bitrateWindow BitrateWindowConfig,
+	metricsWriter *MetricsCSVWriter, currentBytesWritten int64, lastFrameBytesWritten *int64, serverStartTime, sendTime time.Time,
+	lastEffectiveBitrateKbps *float64) ([]BitSample, float64) {
+
+	*frameID++
 
-	// 使用端到端延迟作为主要延迟指标（如果可用），否则使用帧间隔延迟
-	latencyMs := e2eLatencyMs
-	if latencyMs == 0 && interFrameLatencyMs > 0 {
-		latencyMs = interFrameLatencyMs
-	} else if latencyMs == 0 {
-		// 第一帧且没有端到端延迟，使用帧间隔延迟
-		latencyMs = interFrameLatencyMs
+	if intendedInterval > 0 && normalFrameInterval > 0 && stallThreshold > 0 {
+		stallThreshold = time.Duration(float64(stallThreshold) * float64(intendedInterval) / float64(normalFrameInterval))
 	}
+	latencyMs, latencySource, firstFrame, stall := computeFrameLatency(*frameID, receiveTime, *lastFrameReceiveTime,
+		stallThreshold, frameMetadataMap, serverStartTime, sendTime)
 
 	// 更新有效码率滑动窗口
 	// 计算当前帧的比特数（当前总字节数 - 上次总字节数）
@@ -408,8 +783,13 @@ func recordFrameMetrics(frameID *int, lastFrameReceiveTime *time.Time,
 	})
 	*lastFrameBytesWritten = currentBytesWritten
 
+	healthStats.AddFrame(int(frameBits / 8))
+	if latencySource == latencySourceE2E || latencySource == latencySourceAbsSendTime {
+		healthStats.SetLatency(latencyMs)
+	}
+
 	// 移除窗口外的样本
-	cutoffTime := receiveTime.Add(-windowDuration)
+	cutoffTime := receiveTime.Add(-bitrateWindow.Duration)
 	validStart := 0
 	for i, sample := range bitWindow {
 		if sample.Time.After(cutoffTime) {
@@ -426,13 +806,10 @@ func recordFrameMetrics(frameID *int, lastFrameReceiveTime *time.Time,
 		windowEnd := bitWindow[len(bitWindow)-1].Time
 		windowDurationSec := windowEnd.Sub(windowStart).Seconds()
 		
-		// 检查窗口是否足够大：至少 10ms 或至少 5 帧
-		minWindowDuration := 10 * time.Millisecond
-		minWindowFrames := 5
-		
+		// 检查窗口是否足够大：时间跨度与帧数都要达到下限
 		if windowDurationSec > 0 && 
-		   windowDurationSec >= minWindowDuration.Seconds() && 
-		   len(bitWindow) >= minWindowFrames {
+		   windowDurationSec >= bitrateWindow.MinSpan.Seconds() && 
+		   len(bitWindow) >= bitrateWindow.MinFrames {
 			// 累加窗口内所有帧的比特数
 			var totalBits int64
 			for _, sample := range bitWindow {
@@ -472,6 +849,8 @@ func recordFrameMetrics(frameID *int, lastFrameReceiveTime *time.Time,
 			LatencyMillis:        latencyMs,
 			Stall:                stall,
 			EffectiveBitrateKbps: effectiveBitrateKbps,
+			LatencySource:        latencySource,
+			FirstFrame:           firstFrame,
 		})
 	}
 
@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// h265_depacketizer.go - H.265 / HEVC 的 RTP 解包（RFC 7798）
+//
+// 说明：
+//   - NAL 头为 2 字节：F(1) | Type(6) | LayerId(6) | TID(3)；单 NAL 包的类型为 0~47，
+//     聚合包（AP）为 48，分片单元（FU）为 49，PACI（50）不支持
+//   - 假设 sprop-max-don-diff 为 0（pion 与常见发送端的默认值），AP / FU 中没有 DONL 字段
+//   - 关键帧为 IRAP（BLA / IDR / CRA，类型 16~21）；新帧的开始按 slice 头的 first_slice_segment_in_pic_flag 判断
+//   - 没有 SPS/PPS 补写（param_sets.go 只解析 H.264），也不解析 recovery point SEI
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// H.265 NAL 单元类型（ITU-T H.265 表 7-1 与 RFC 7798）
+const (
+	h265NALTypeIRAPFirst = 16 // BLA_W_LP
+	h265NALTypeIRAPLast  = 21 // CRA_NUT
+	h265NALTypeVPS       = 32
+	h265NALTypeSPS       = 33
+	h265NALTypePPS       = 34
+	h265NALTypeAP        = 48
+	h265NALTypeFU        = 49
+)
+
+// h265NALType 返回 2 字节 NAL 头中的类型
+func h265NALType(header byte) byte {
+	return (header >> 1) & 0x3F
+}
+
+// H265Depacketizer 解析 RFC 7798 的单 NAL、AP 与 FU 包
+type H265Depacketizer struct {
+	fuBuffer    []byte
+	fuNALType   byte
+	fuSeq       fragmentSequence
+	oversizedFU int // 因超过 maxFUABufferBytes 被丢弃的 FU NAL 数
+
+	malformedAP int // 格式错误的 AP 包数
+	malformedFU int // 格式错误的 FU 包数
+}
+
+// newH265Depacketizer 创建 H.265 解包器
+func newH265Depacketizer() *H265Depacketizer {
+	return &H265Depacketizer{fuSeq: fragmentSequence{label: "H.265 FU"}}
+}
+
+// Depacketize 实现 Depacketizer
+func (d *H265Depacketizer) Depacketize(payload []byte, seq uint16) (nals [][]byte, frameStart bool) {
+	if len(payload) < 2 {
+		return nil, false
+	}
+	if h265NALType(payload[0]) != h265NALTypeFU && d.fuBuffer != nil {
+		d.fuSeq.dropIncomplete(fmt.Sprintf("end fragment missing before seq %d", seq))
+	}
+	switch nalType := h265NALType(payload[0]); {
+	case nalType < h265NALTypeAP:
+		d.fuBuffer = nil
+		nals = [][]byte{payload}
+
+	case nalType == h265NALTypeAP:
+		// 格式错误时仍返回出错位置之前完整的 NAL 单元
+		var apErr error
+		nals, apErr = splitH265AP(payload)
+		if apErr != nil {
+			d.malformedAP++
+			reportRecoverableError("Warning: Malformed H.265 aggregation packet", fmt.Errorf("seq %d: %w", seq, apErr))
+		}
+		d.fuBuffer = nil
+
+	case nalType == h265NALTypeFU:
+		if err := checkH265FUHeader(payload); err != nil {
+			d.malformedFU++
+			reportRecoverableError("Warning: Malformed H.265 fragmentation unit", fmt.Errorf("seq %d: %w", seq, err))
+			d.fuBuffer = nil
+			return nil, false
+		}
+		fuHeader := payload[2]
+		start := fuHeader&0x80 != 0
+		end := fuHeader&0x40 != 0
+		fuType := fuHeader & 0x3F
+
+		// 与 FU-A 相同，分片缺失时只丢弃这一个 NAL
+		if start {
+			if d.fuBuffer != nil {
+				d.fuSeq.dropIncomplete(fmt.Sprintf("end fragment missing before seq %d", seq))
+			}
+			// 还原 NAL 头：F 位与 LayerId 的最高位来自负载头，类型来自 FU 头，第二个字节不变
+			d.fuNALType = fuType
+			d.fuBuffer = []byte{(payload[0] & 0x81) | fuType<<1, payload[1]}
+			d.fuBuffer = append(d.fuBuffer, payload[3:]...)
+			d.fuSeq.begin(seq)
+		} else if d.fuBuffer != nil && fuType == d.fuNALType && d.fuSeq.next(seq) {
+			d.fuBuffer = append(d.fuBuffer, payload[3:]...)
+		} else {
+			if d.fuBuffer != nil {
+				if fuType != d.fuNALType {
+					d.fuSeq.dropIncomplete(fmt.Sprintf("seq %d carries NAL type %d instead of %d", seq, fuType, d.fuNALType))
+				} else {
+					d.fuSeq.dropIncomplete(fmt.Sprintf("sequence gap, expected seq %d but got %d", d.fuSeq.lastSeq+1, seq))
+				}
+			}
+			d.fuSeq.dropOrphan()
+			d.fuBuffer = nil
+			return nil, false
+		}
+		if len(d.fuBuffer) > maxFUABufferBytes {
+			d.oversizedFU++
+			reportRecoverableError("Warning: H.265 FU reassembly buffer limit exceeded, discarding fragment",
+				fmt.Errorf("nal type %d exceeds %d bytes without an end fragment", d.fuNALType, maxFUABufferBytes))
+			d.fuBuffer = nil
+			return nil, false
+		}
+		if !end {
+			return nil, false
+		}
+		nals = [][]byte{d.fuBuffer}
+		d.fuBuffer = nil
+
+	default:
+		reportRecoverableError("Warning: Unsupported H.265 NAL type, skipping", fmt.Errorf("nal type %d", nalType))
+		return nil, false
+	}
+
+	for _, nal := range nals {
+		// first_slice_segment_in_pic_flag 是 slice 头的第一位
+		if kind := d.Kind(nal); (kind == nalKindSlice || kind == nalKindKeyframe) && len(nal) > 2 && nal[2]&0x80 != 0 {
+			frameStart = true
+		}
+	}
+	return nals, frameStart
+}
+
+// Kind 实现 Depacketizer
+func (d *H265Depacketizer) Kind(nal []byte) nalKind {
+	if len(nal) < 2 {
+		return nalKindOther
+	}
+	switch nalType := h265NALType(nal[0]); {
+	case nalType >= h265NALTypeIRAPFirst && nalType <= h265NALTypeIRAPLast:
+		return nalKindKeyframe
+	case nalType < 32:
+		return nalKindSlice
+	case nalType == h265NALTypeVPS || nalType == h265NALTypeSPS || nalType == h265NALTypePPS:
+		return nalKindParamSet
+	}
+	return nalKindOther
+}
+
+// HasKeyframe 实现 Depacketizer：单 NAL、AP 中的任一 NAL 或 FU 的任一分片是 IRAP
+func (d *H265Depacketizer) HasKeyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+	isIRAP := func(nalType byte) bool {
+		return nalType >= h265NALTypeIRAPFirst && nalType <= h265NALTypeIRAPLast
+	}
+	switch nalType := h265NALType(payload[0]); nalType {
+	case h265NALTypeAP:
+		nals, _ := splitH265AP(payload)
+		for _, nal := range nals {
+			if isIRAP(h265NALType(nal[0])) {
+				return true
+			}
+		}
+		return false
+	case h265NALTypeFU:
+		return len(payload) >= 3 && isIRAP(payload[2]&0x3F)
+	default:
+		return isIRAP(nalType)
+	}
+}
+
+// RecoveryPoint 实现 Depacketizer；H.265 的 recovery point SEI 不解析
+func (d *H265Depacketizer) RecoveryPoint([]byte) (int, bool) {
+	return 0, false
+}
+
+// Finish 实现 Depacketizer
+func (d *H265Depacketizer) Finish() {
+	if d.fuBuffer != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Discarding incomplete H.265 FU fragment\n")
+	}
+	if d.malformedAP > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d malformed H.265 aggregation packets (only their complete leading NAL units were written)\n", d.malformedAP)
+	}
+	if d.malformedFU > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d malformed H.265 fragmentation units discarded\n", d.malformedFU)
+	}
+	if d.oversizedFU > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d H.265 FU NAL units discarded for exceeding the %d byte reassembly limit\n", d.oversizedFU, maxFUABufferBytes)
+	}
+	d.fuSeq.report()
+}
+
+// splitH265AP 解析 AP 负载（RFC 7798 4.4.2：2 字节负载头，之后重复 2 字节长度 + NAL 单元），
+// 格式错误时返回出错位置之前完整的 NAL 单元以及描述错误的 err
+func splitH265AP(payload []byte) ([][]byte, error) {
+	var nals [][]byte
+	offset := 2
+	for offset < len(payload) {
+		if offset+2 > len(payload) {
+			return nals, fmt.Errorf("truncated size field at offset %d (1 trailing byte)", offset)
+		}
+		nalSize := int(payload[offset])<<8 | int(payload[offset+1])
+		offset += 2
+		if nalSize < 2 {
+			return nals, fmt.Errorf("aggregation unit %d has size %d, shorter than a NAL header", len(nals)+1, nalSize)
+		}
+		if offset+nalSize > len(payload) {
+			return nals, fmt.Errorf("aggregation unit %d size %d exceeds the %d remaining bytes", len(nals)+1, nalSize, len(payload)-offset)
+		}
+		nalData := payload[offset : offset+nalSize]
+		if nalData[0]&0x80 != 0 || h265NALType(nalData[0]) >= h265NALTypeAP {
+			return nals, fmt.Errorf("aggregation unit %d has invalid NAL header 0x%02x%02x", len(nals)+1, nalData[0], nalData[1])
+		}
+		nals = append(nals, nalData)
+		offset += nalSize
+	}
+	if len(nals) == 0 {
+		return nil, fmt.Errorf("no aggregation units")
+	}
+	return nals, nil
+}
+
+// checkH265FUHeader 检查 FU 负载（RFC 7798 4.4.3：2 字节负载头 + 1 字节 FU 头 + 分片数据）：
+// 缺少 FU 头、起始与结束位同时置位、分片的 NAL 类型是 AP / FU / PACI 时返回错误
+func checkH265FUHeader(payload []byte) error {
+	if len(payload) < 3 {
+		return fmt.Errorf("missing FU header (%d byte payload)", len(payload))
+	}
+	fuHeader := payload[2]
+	if fuHeader&0xC0 == 0xC0 {
+		return fmt.Errorf("FU header 0x%02x has both start and end bits set", fuHeader)
+	}
+	if nalType := fuHeader & 0x3F; nalType >= h265NALTypeAP {
+		return fmt.Errorf("FU header 0x%02x carries invalid NAL type %d", fuHeader, nalType)
+	}
+	return nil
+}
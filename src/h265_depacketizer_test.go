@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// h265FUFragment 构造一个 IDR_N_LP（类型 20）的 H.265 FU 分片，负载为 size 字节
+func h265FUFragment(start, end bool, size int) []byte {
+	fuHeader := byte(20)
+	if start {
+		fuHeader |= 0x80
+	}
+	if end {
+		fuHeader |= 0x40
+	}
+	return append([]byte{h265NALTypeFU << 1, 0x01, fuHeader}, make([]byte, size)...)
+}
+
+func TestH265Depacketize(t *testing.T) {
+	const idr = 19 // IDR_W_RADL
+	tests := []struct {
+		name             string
+		payloads         [][]byte // 依次送入同一个解包器，序列号连续
+		want             [][]byte // 所有包返回的 NAL 单元
+		wantFrameStart   bool     // 是否有包标记了新帧的开始
+		wantDroppedFrags int
+	}{
+		{
+			name:           "single NAL",
+			payloads:       [][]byte{{idr << 1, 0x01, 0x80, 0xaa}},
+			want:           [][]byte{{idr << 1, 0x01, 0x80, 0xaa}},
+			wantFrameStart: true,
+		},
+		{
+			name: "AP with two units",
+			payloads: [][]byte{{h265NALTypeAP << 1, 0x01,
+				0x00, 0x03, h265NALTypeVPS << 1, 0x01, 0x0c,
+				0x00, 0x03, h265NALTypeSPS << 1, 0x01, 0x01}},
+			want: [][]byte{{h265NALTypeVPS << 1, 0x01, 0x0c}, {h265NALTypeSPS << 1, 0x01, 0x01}},
+		},
+		{
+			name: "FU in three fragments",
+			payloads: [][]byte{
+				{h265NALTypeFU << 1, 0x01, 0x80 | idr, 0x80, 0x11}, // 起始分片
+				{h265NALTypeFU << 1, 0x01, idr, 0x22},
+				{h265NALTypeFU << 1, 0x01, 0x40 | idr, 0x33}, // 结束分片
+			},
+			want:           [][]byte{{idr << 1, 0x01, 0x80, 0x11, 0x22, 0x33}},
+			wantFrameStart: true,
+		},
+		{
+			name: "FU without a start fragment",
+			payloads: [][]byte{
+				{h265NALTypeFU << 1, 0x01, idr, 0x22},
+				{h265NALTypeFU << 1, 0x01, 0x40 | idr, 0x33},
+			},
+			wantDroppedFrags: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newH265Depacketizer()
+			var got [][]byte
+			frameStart := false
+			for i, payload := range tt.payloads {
+				nals, start := d.Depacketize(payload, uint16(100+i))
+				got = append(got, nals...)
+				frameStart = frameStart || start
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d NAL units %x, want %d %x", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("NAL %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+			if frameStart != tt.wantFrameStart {
+				t.Errorf("frame start = %v, want %v", frameStart, tt.wantFrameStart)
+			}
+			if d.fuSeq.droppedFragments != tt.wantDroppedFrags {
+				t.Errorf("dropped %d fragments, want %d", d.fuSeq.droppedFragments, tt.wantDroppedFrags)
+			}
+			if d.fuBuffer != nil {
+				t.Errorf("FU reassembly still pending after the last packet")
+			}
+		})
+	}
+}
+
+func FuzzH265Depacketize(f *testing.F) {
+	f.Add(joinFuzzPayloads([]byte{20 << 1, 0x01, 0x80, 0x00}))
+	f.Add(joinFuzzPayloads([]byte{h265NALTypeAP << 1, 0x01, 0x00, 0x03, h265NALTypeVPS << 1, 0x01, 0x0c, 0x00, 0x03, h265NALTypeSPS << 1, 0x01, 0x01}))
+	f.Add(joinFuzzPayloads(h265FUFragment(true, false, 3), h265FUFragment(false, false, 2), h265FUFragment(false, true, 4)))
+	f.Add(joinFuzzPayloads(h265FUFragment(true, false, 3), []byte{h265NALTypeAP << 1, 0x01, 0x00}, h265FUFragment(false, true, 1)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := newH265Depacketizer()
+		in, out := 0, 0
+		for i, payload := range splitFuzzPayloads(data) {
+			nals, _ := d.Depacketize(payload, uint16(i))
+			d.HasKeyframe(payload)
+			in += len(payload)
+			for _, nal := range nals {
+				if len(nal) == 0 {
+					t.Fatalf("empty NAL unit from payload %x", payload)
+				}
+				d.Kind(nal)
+				out += len(nal)
+			}
+			if len(d.fuBuffer) > maxFUABufferBytes {
+				t.Fatalf("reassembly buffer %d bytes exceeds the %d byte limit", len(d.fuBuffer), maxFUABufferBytes)
+			}
+		}
+		d.Finish()
+		// FU 每个分片去掉 3 字节头后只加回 2 字节 NAL 头：输出不会多于输入
+		if out > in {
+			t.Fatalf("%d bytes of NAL units from %d bytes of payload", out, in)
+		}
+	})
+}
@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// health.go - 定期输出一行汇总的健康状态（-stats-interval）
+//
+// 说明：
+//   - 长时间运行时，逐帧日志要么太多，要么关掉后什么都看不到；这里每个间隔只打印一行
+//   - server：发送帧数、发送码率、RTT 与丢包率（来自对端 RTCP Receiver Report），-rtcp-bwe 开启时还有带宽估计
+//   - client：接收帧数、接收码率、端到端延迟（需要 frame_metadata）与丢包率（按 RTP 序列号缺口计算，重传补回的不算丢失）
+//   - reconnects 统计 ICE 在首次连接之后再次进入 connected 的次数；目前断开即结束 session，正常情况下为 0
+//   - 与逐帧日志、CSV 相互独立；所有方法对 nil 安全，未启用时调用方无需判断
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// healthStats 是当前进程的健康统计，为 nil 表示未启用 -stats-interval
+var healthStats *HealthStats
+
+// HealthStats 汇总一个 session 的健康状态
+type HealthStats struct {
+	role      string
+	startTime time.Time
+
+	mu     sync.Mutex
+	frames int64
+	bytes  int64
+
+	latencyMs   float64 // 最近一次的延迟（client：端到端；server：RTT）
+	haveLatency bool
+
+	// client 按序列号统计：期望收到的包数与实际收到的包数
+	expectedPackets int64
+	receivedPackets int64
+	// server 使用 Receiver Report 中的 fraction lost
+	reportedLoss     float64
+	haveReportedLoss bool
+
+	iceConnected bool
+	reconnects   int
+
+	// server -rtcp-bwe 开启时返回基于接收端反馈的带宽估计（见 bitrate_estimator.go）
+	bandwidthEstimate func() float64
+
+	// 上一次输出时的计数，用于计算间隔内的增量
+	lastFrames, lastBytes      int64
+	lastExpected, lastReceived int64
+	lastReport                 time.Time
+}
+
+// NewHealthStats 创建健康统计，role 为 "server" 或 "client"
+func NewHealthStats(role string) *HealthStats {
+	now := time.Now()
+	return &HealthStats{role: role, startTime: now, lastReport: now}
+}
+
+// AddFrame 记录一帧（server：已发送；client：已接收）及其字节数
+func (h *HealthStats) AddFrame(bytes int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.frames++
+	h.bytes += int64(bytes)
+	h.mu.Unlock()
+}
+
+// SetLatency 记录最近一次的延迟（毫秒）
+func (h *HealthStats) SetLatency(latencyMs float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.latencyMs, h.haveLatency = latencyMs, true
+	h.mu.Unlock()
+}
+
+// AddPackets 记录 client 端的 RTP 包统计：expected 为序列号前进的数量，received 为实际收到的包数
+func (h *HealthStats) AddPackets(expected, received int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.expectedPackets += int64(expected)
+	h.receivedPackets += int64(received)
+	h.mu.Unlock()
+}
+
+// OnICEConnectionState 跟踪 ICE 状态，统计首次连接后的重新连接次数
+func (h *HealthStats) OnICEConnectionState(state webrtc.ICEConnectionState) {
+	if h == nil || state != webrtc.ICEConnectionStateConnected {
+		return
+	}
+	h.mu.Lock()
+	if h.iceConnected {
+		h.reconnects++
+	}
+	h.iceConnected = true
+	h.mu.Unlock()
+}
+
+// ObserveRTCP 从 server 收到的 Receiver Report 中提取丢包率与 RTT
+func (h *HealthStats) ObserveRTCP(pkts []rtcp.Packet, now time.Time) {
+	if h == nil {
+		return
+	}
+	for _, pkt := range pkts {
+		rr, ok := pkt.(*rtcp.ReceiverReport)
+		if !ok {
+			continue
+		}
+		for _, report := range rr.Reports {
+			h.mu.Lock()
+			h.reportedLoss, h.haveReportedLoss = float64(report.FractionLost)/256, true
+			// RTT = 当前 NTP 时间（中间 32 位）- LSR - DLSR，单位 1/65536 秒；还没收到过 SR 时 LSR 为 0
+			if report.LastSenderReport != 0 {
+				rtt := ntpMiddle32(now) - report.LastSenderReport - report.Delay
+				h.latencyMs, h.haveLatency = float64(rtt)/65536*1000, true
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// ntpMiddle32 返回 t 的 NTP 时间戳中间 32 位（RFC 3550 中 LSR/DLSR 使用的格式）
+func ntpMiddle32(t time.Time) uint32 {
+	const ntpEpochOffset = 2208988800 // 1900-01-01 到 1970-01-01 的秒数
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return uint32((secs<<32 | frac) >> 16)
+}
+
+// SetBandwidthEstimate 设置带宽估计的来源，设置后每行附上 bwe=
+func (h *HealthStats) SetBandwidthEstimate(estimate func() float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bandwidthEstimate = estimate
+}
+
+// Start 每隔 interval 输出一行健康状态，返回的函数用于停止输出
+func (h *HealthStats) Start(interval time.Duration) (stop func()) {
+	if h == nil || interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				fmt.Fprintln(os.Stderr, h.line(now))
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// line 生成一行健康状态并重置间隔计数
+func (h *HealthStats) line(now time.Time) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	elapsed := now.Sub(h.lastReport).Seconds()
+	bitrateKbps := 0.0
+	if elapsed > 0 {
+		bitrateKbps = float64(h.bytes-h.lastBytes) * 8 / elapsed / 1000
+	}
+
+	verb := "sent"
+	if h.role == "client" {
+		verb = "received"
+	}
+	latencyName := "latency"
+	if h.role == "server" {
+		latencyName = "rtt"
+	}
+	latency := "n/a"
+	if h.haveLatency {
+		latency = fmt.Sprintf("%.0fms", h.latencyMs)
+	}
+
+	loss := "n/a"
+	if expected := h.expectedPackets - h.lastExpected; expected > 0 {
+		lost := expected - (h.receivedPackets - h.lastReceived)
+		loss = fmt.Sprintf("%.1f%%", math.Max(0, float64(lost))/float64(expected)*100)
+	} else if h.haveReportedLoss {
+		loss = fmt.Sprintf("%.1f%%", h.reportedLoss*100)
+	}
+
+	s := fmt.Sprintf("[Health] %s uptime=%v frames_%s=%d (+%d) bitrate=%.0fkbps %s=%s loss=%s reconnects=%d",
+		h.role, now.Sub(h.startTime).Round(time.Second), verb, h.frames, h.frames-h.lastFrames,
+		bitrateKbps, latencyName, latency, loss, h.reconnects)
+	if h.bandwidthEstimate != nil {
+		if bwe := h.bandwidthEstimate(); bwe > 0 {
+			s += fmt.Sprintf(" bwe=%.0fkbps", bwe/1000)
+		} else {
+			s += " bwe=n/a"
+		}
+	}
+
+	h.lastFrames, h.lastBytes = h.frames, h.bytes
+	h.lastExpected, h.lastReceived = h.expectedPackets, h.receivedPackets
+	h.lastReport = now
+	return s
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// http_signal.go - 通过 HTTP bridge 交换 offer / answer（-signal-url）
+//
+// 说明：
+//   - 跨网络演示时两端不在同一台机器上，不能共用 -offer-file / -answer-file，复制粘贴 base64 字符串又容易出错；
+//     sdp-bridge（sdp_bridge.go）在一个双方都能访问的地址上暂存 SDP
+//   - -signal-url 指向 bridge 上的一个房间，例如 http://example.com:8080/demo：
+//     server POST <url>/offer 后轮询 GET <url>/answer；client 轮询 GET <url>/offer，再 POST <url>/answer
+//   - 只使用普通的 HTTP(S) 短请求，不需要长连接，能穿过只允许出站 HTTPS 的防火墙
+//   - 请求体与 -offer-file / -answer-file 的内容相同，是 encode 得到的 base64 字符串
+//   - ws:// / wss:// 的地址改用 WebSocket（见 ws_signal.go），对端的 SDP 由 bridge 推送，不需要轮询
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// signalPollInterval 是轮询 bridge 的间隔
+	signalPollInterval = time.Second
+	// signalPollTimeout 是等待对端 SDP 的上限；跨网络演示时另一端通常要手动启动，比 readFromFile 的 60 秒宽松
+	signalPollTimeout = 5 * time.Minute
+	// signalMaxBytes 是接受的 SDP 长度上限
+	signalMaxBytes = 1 << 16
+)
+
+// signalHTTPClient 是访问 bridge 的 HTTP 客户端，单个请求超时后在下一次轮询时重试
+var signalHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// signalURLFor 返回房间 baseURL 下 kind（"offer" / "answer"）的地址
+func signalURLFor(baseURL, kind string) string {
+	return strings.TrimRight(baseURL, "/") + "/" + kind
+}
+
+// postSignal 把 SDP 字符串 POST 到 bridge 房间的 kind 地址
+func postSignal(baseURL, kind, payload string) error {
+	if isWebSocketSignalURL(baseURL) {
+		return postWebSocketSignal(baseURL, kind, payload)
+	}
+	url := signalURLFor(baseURL, kind)
+	resp, err := signalHTTPClient.Post(url, "text/plain", strings.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post %s to %s: %w", kind, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to post %s to %s: %s %s", kind, url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	fmt.Fprintf(os.Stderr, "%s posted to %s (%d bytes)\n", capitalize(kind), url, len(payload))
+	return nil
+}
+
+// pollSignal 轮询 bridge 房间的 kind 地址，直到取得 SDP 字符串；超时返回空串（与 readFromFile 一致）
+func pollSignal(baseURL, kind string) string {
+	if isWebSocketSignalURL(baseURL) {
+		return pollWebSocketSignal(baseURL, kind)
+	}
+	url := signalURLFor(baseURL, kind)
+	deadline := time.Now().Add(signalPollTimeout)
+	for time.Now().Before(deadline) {
+		payload, err := fetchSignal(url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else if payload != "" {
+			fmt.Fprintf(os.Stderr, "%s read from %s (%d bytes)\n", capitalize(kind), url, len(payload))
+			return payload
+		}
+		time.Sleep(signalPollInterval)
+		fmt.Fprintf(os.Stderr, "Waiting for %s at %s... (timeout in %v)\n", kind, url, time.Until(deadline).Round(time.Second))
+	}
+	fmt.Fprintf(os.Stderr, "Error: Timeout waiting for %s at %s\n", kind, url)
+	return ""
+}
+
+// fetchSignal 请求一次；bridge 上还没有 SDP（404）时返回空串
+func fetchSignal(url string) (string, error) {
+	resp, err := signalHTTPClient.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, signalMaxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	if len(body) > signalMaxBytes {
+		return "", fmt.Errorf("response from %s exceeds %d bytes", url, signalMaxBytes)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// capitalize 把 "offer" / "answer" 转为日志开头的 "Offer" / "Answer"
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// hwaccel.go - 硬件编码器选择（server 的 -hwaccel）
+//
+// 说明：
+//   - 默认（none）使用软件 x264；1080p 等大分辨率下软件编码跟不上实时，帧会在 ticker 循环中积压
+//   - -hwaccel nvenc 按名字查找 h264_nvenc 并创建 CUDA 设备上下文；nvenc 直接接受缩放输出的 yuv420p 软件帧，由驱动上传
+//   - -hwaccel vaapi 查找 h264_vaapi 并创建 VAAPI 设备上下文；h264_vaapi 只接受 VAAPI 表面，需要硬件帧上下文
+//     （av_hwframe_ctx_alloc）逐帧上传，当前使用的 go-astiav（v0.19）没有提供这部分接口，因此设备可用时同样回退到软件编码并说明原因
+//   - 编码器或设备不可用时打印警告并回退到软件 x264。选择只在第一次查找编码器时进行，之后重建编码器
+//     （NDTC / BurstRTC 调整 CRF、Salsify 的候选编码器、分辨率切换）沿用同一结果，不会重复打印
+//   - 各 server 仍按 x264 的写法设置编码选项，由 openH264Encoder 在打开硬件编码器前翻译：
+//     preset / tune 换成 nvenc 的低延迟预设，crf 换成 VBR 下的 cq，qp 换成 constqp；其它选项（bf、profile、coder、intra-refresh）原样传递
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/asticode/go-astiav"
+)
+
+// HWAccel 是 -hwaccel 选择的编码方式
+type HWAccel int
+
+const (
+	HWAccelNone HWAccel = iota
+	HWAccelVAAPI
+	HWAccelNVENC
+)
+
+// hwAccel 由 -hwaccel 设置
+var hwAccel HWAccel
+
+// parseHWAccel 解析 -hwaccel 的取值
+func parseHWAccel(value string) (HWAccel, error) {
+	switch value {
+	case "", "none":
+		return HWAccelNone, nil
+	case "vaapi":
+		return HWAccelVAAPI, nil
+	case "nvenc":
+		return HWAccelNVENC, nil
+	}
+	return HWAccelNone, fmt.Errorf("unknown hardware acceleration %q (want none, vaapi or nvenc)", value)
+}
+
+func (h HWAccel) String() string {
+	switch h {
+	case HWAccelVAAPI:
+		return "vaapi"
+	case HWAccelNVENC:
+		return "nvenc"
+	}
+	return "none"
+}
+
+// h264EncoderSelection 是第一次查找编码器时确定的 H.264 编码器
+type h264EncoderSelection struct {
+	codec  *astiav.Codec
+	device *astiav.HardwareDeviceContext // 软件编码时为 nil
+	accel  HWAccel                       // 实际使用的加速方式，回退后为 HWAccelNone
+}
+
+// selectedH264Encoder 在第一次调用 findH264Encoder 后非 nil
+var selectedH264Encoder *h264EncoderSelection
+
+// findH264Encoder 返回 -hwaccel 选择的 H.264 编码器，不可用时回退到软件编码器
+func findH264Encoder() (*astiav.Codec, error) {
+	if selectedH264Encoder == nil {
+		selectedH264Encoder = selectH264Encoder(hwAccel)
+	}
+	if selectedH264Encoder.codec == nil {
+		return nil, fmt.Errorf("%w: no H.264 encoder found", ErrCodecUnsupported)
+	}
+	return selectedH264Encoder.codec, nil
+}
+
+// selectH264Encoder 查找硬件编码器并创建设备上下文，任何一步失败都回退到软件编码器
+func selectH264Encoder(accel HWAccel) *h264EncoderSelection {
+	software := &h264EncoderSelection{codec: astiav.FindEncoder(astiav.CodecIDH264)}
+	if accel == HWAccelNone {
+		return software
+	}
+
+	name, deviceType := "h264_nvenc", astiav.HardwareDeviceTypeCUDA
+	if accel == HWAccelVAAPI {
+		name, deviceType = "h264_vaapi", astiav.HardwareDeviceTypeVAAPI
+	}
+	fallback := func(reason string) *h264EncoderSelection {
+		fmt.Fprintf(os.Stderr, "Warning: -hwaccel %s: %s, falling back to software H.264 encoding\n", accel, reason)
+		return software
+	}
+
+	codec := astiav.FindEncoderByName(name)
+	if codec == nil {
+		return fallback(fmt.Sprintf("encoder %s not found (FFmpeg built without it)", name))
+	}
+	device, err := astiav.CreateHardwareDeviceContext(deviceType, "", nil)
+	if err != nil {
+		return fallback(fmt.Sprintf("failed to open %s device: %v", deviceType, err))
+	}
+	if accel == HWAccelVAAPI {
+		return fallback("h264_vaapi needs a VAAPI hardware frames context to upload frames, which the go-astiav binding in use does not expose")
+	}
+
+	fmt.Fprintf(os.Stderr, "Video encoder: %s (-hwaccel %s)\n", name, accel)
+	return &h264EncoderSelection{codec: codec, device: device, accel: accel}
+}
+
+// openH264Encoder 打开 findH264Encoder 返回的编码器：硬件编码时设置设备上下文，并把 x264 写法的选项翻译为该编码器的选项
+func openH264Encoder(ctx *astiav.CodecContext, codec *astiav.Codec, dict *astiav.Dictionary) error {
+	sel := selectedH264Encoder
+	if sel == nil || sel.accel == HWAccelNone || codec != sel.codec {
+		return ctx.Open(codec, dict)
+	}
+	ctx.SetHardwareDeviceContext(sel.device)
+	options, err := nvencOptions(dict)
+	if err != nil {
+		return err
+	}
+	defer options.Free()
+	return ctx.Open(codec, options)
+}
+
+// nvencOptions 把 x264 写法的编码选项翻译为 h264_nvenc 的选项
+func nvencOptions(dict *astiav.Dictionary) (*astiav.Dictionary, error) {
+	options := astiav.NewDictionary()
+	set := func(key, value string) error {
+		if err := options.Set(key, value, astiav.NewDictionaryFlags()); err != nil {
+			return fmt.Errorf("failed to set encoder option %s=%s: %w", key, value, err)
+		}
+		return nil
+	}
+
+	var err error
+	flags := astiav.NewDictionaryFlags(astiav.DictionaryFlagIgnoreSuffix)
+	for e := dict.Get("", nil, flags); e != nil && err == nil; e = dict.Get("", e, flags) {
+		switch key, value := e.Key(), e.Value(); key {
+		case "preset":
+			// 各 server 都用 ultrafast，对应 nvenc 最快的预设
+			err = set("preset", "p1")
+		case "tune":
+			// zerolatency：超低延迟调优，不做帧重排与前瞻
+			if err = set("tune", "ull"); err == nil {
+				err = set("zerolatency", "1")
+			}
+		case "crf":
+			if err = set("rc", "vbr"); err == nil {
+				err = set("cq", value)
+			}
+		case "qp":
+			if err = set("rc", "constqp"); err == nil {
+				err = set("qp", value)
+			}
+		default:
+			err = set(key, value)
+		}
+	}
+	if err != nil {
+		options.Free()
+		return nil, err
+	}
+	return options, nil
+}
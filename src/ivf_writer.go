@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// ivf_writer.go - VP8 / AV1 RTP → IVF 文件写入（基础 client 收到 server -codec vp8 / av1 的轨道时使用）
+//
+// 说明：
+//   - VP8 与 AV1 没有 Annex-B 这样的裸流格式，按帧写入 IVF 容器。pion 的 ivfwriter 负责解包与拼帧：
+//     VP8 去掉负载描述符；AV1 按聚合头（Z/Y/W/N）拆出 OBU、重组跨包分片的 OBU，按 marker 位拼成 temporal unit
+//   - AV1 另外按 OBU 扩展头统计每个时间层的帧数、帧率与码率（见 av1_layers.go），用于确认 server -av1-temporal-layers 是否生效；
+//     指定 sessionDir 时逐窗口写入 av1_layers.csv，并写 metrics_summary（只有帧数、码率、时长与时间层统计，没有逐帧延迟与卡顿）
+//   - 第一个关键帧之前的帧无法解码，ivfwriter 会丢弃
+//   - IVF 时间戳直接使用 RTP 时间戳（90kHz 时间基），播放速度与发送一致，不需要像 .h264 那样用 -r 指定帧率
+//   - 只统计包数与字节数，没有 H.264 client 的逐帧指标
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+)
+
+// writeIVFToFile 接收 VP8 或 AV1 视频轨道（按轨道的 MimeType）并写入 IVF 文件，参数含义与 writeH264ToFile 相同；filename 为 "-" 时写到 stdout
+func writeIVFToFile(track *webrtc.TrackRemote, filename string, maxDuration time.Duration, maxSizeMB int64, sessionDir string) {
+	mimeType := track.Codec().MimeType
+	codecName := "VP8"
+	var av1Layers *AV1LayerStats
+	if strings.EqualFold(mimeType, webrtc.MimeTypeAV1) {
+		codecName = "AV1"
+		var csvPath string
+		if sessionDir != "" {
+			csvPath = filepath.Join(sessionDir, "av1_layers.csv")
+		}
+		var err error
+		if av1Layers, err = NewAV1LayerStats(csvPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v, AV1 layer statistics are only printed\n", err)
+			av1Layers, _ = NewAV1LayerStats("")
+		}
+	}
+	options := []ivfwriter.Option{
+		ivfwriter.WithCodec(mimeType),
+		ivfwriter.WithFrameRate(1, track.Codec().ClockRate),
+		ivfwriter.WithDirectPTS(),
+	}
+	toStdout := filename == stdoutOutput
+	var writer *ivfwriter.IVFWriter
+	var err error
+	if toStdout {
+		signal.Ignore(syscall.SIGPIPE)
+		filename = "stdout"
+		writer, err = ivfwriter.NewWith(os.Stdout, options...)
+	} else {
+		writer, err = ivfwriter.New(filename, options...)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create output file: %v", err))
+	}
+
+	packetCount := 0
+	var bytesReceived int64
+	startTime := time.Now()
+	lastFlushTime := startTime
+	lastReadTime := startTime
+	readTimeout := 5 * time.Second
+	maxSizeBytes := maxSizeMB * 1024 * 1024
+
+	fmt.Fprintf(os.Stderr, "Writing %s stream to %s (IVF)...\n", codecName, filename)
+
+	for {
+		if maxDuration > 0 && time.Since(startTime) >= maxDuration {
+			fmt.Fprintf(os.Stderr, "Max duration (%v) reached, stopping...\n", maxDuration)
+			break
+		}
+		if maxSizeMB > 0 && bytesReceived >= maxSizeBytes {
+			fmt.Fprintf(os.Stderr, "Max size (%d MB) reached, stopping...\n", maxSizeMB)
+			break
+		}
+		if time.Since(lastReadTime) > readTimeout {
+			fmt.Fprintf(os.Stderr, "Read timeout (%v) - no data received, assuming connection closed\n", readTimeout)
+			break
+		}
+
+		rtpPacket, _, readErr := track.ReadRTP()
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				fmt.Fprintf(os.Stderr, "Track ended (EOF)\n")
+			} else if isConnectionClosed(readErr) {
+				fmt.Fprintf(os.Stderr, "Connection closed: %v\n", readErr)
+			} else {
+				fmt.Fprintf(os.Stderr, "Error reading track: %v\n", readErr)
+			}
+			break
+		}
+		lastReadTime = time.Now()
+		packetCount++
+		bytesReceived += int64(len(rtpPacket.Payload))
+		av1Layers.Observe(rtpPacket, lastReadTime)
+		nackSender.OnPacket(rtpPacket, lastReadTime)
+
+		if err = writer.WriteRTP(rtpPacket); err != nil {
+			if toStdout {
+				fmt.Fprintf(os.Stderr, "Output pipe closed (%v), stopping...\n", err)
+				break
+			}
+			reportRecoverableError(fmt.Sprintf("Error writing %s frame", codecName), err)
+		}
+
+		if time.Since(lastFlushTime) > time.Second {
+			fmt.Fprintf(os.Stderr, "Progress: %d packets, %.2f MB, %v elapsed\n",
+				packetCount, float64(bytesReceived)/(1024*1024), time.Since(startTime).Round(time.Second))
+			lastFlushTime = time.Now()
+		}
+	}
+
+	// stdout 不能回写 IVF 头中的帧数，也不应被关闭
+	if !toStdout {
+		if err = writer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing IVF file: %v\n", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Completed: %d packets, %.2f MB, %v elapsed\n",
+		packetCount, float64(bytesReceived)/(1024*1024), time.Since(startTime))
+	av1Layers.Close()
+	av1Layers.Report()
+	if layers := av1Layers.Summary(); layers != nil && sessionDir != "" {
+		duration := lastReadTime.Sub(startTime).Seconds()
+		summary := &SummaryMetrics{
+			TotalFrames:           layers.Frames,
+			TotalDurationSeconds:  duration,
+			ActiveDurationSeconds: duration,
+			AV1Layers:             layers,
+		}
+		if duration > 0 {
+			summary.EffectiveBitrateKbps = float64(bytesReceived) * 8 / duration / 1000
+		}
+		if err := WriteSummaryMetrics(summary, sessionDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to write summary metrics: %v\n", err)
+		}
+	}
+	if !toStdout {
+		fmt.Fprintf(os.Stderr, "You can now use FFmpeg to process this file:\n")
+		fmt.Fprintf(os.Stderr, "  ffmpeg -i %s -c:v copy received.webm\n", filename)
+	}
+}
@@ -0,0 +1,218 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// jitter_buffer.go - 接收端按序列号重排 RTP 包的抖动缓冲（client 的 -jitter-buffer）
+//
+// 说明：
+//   - writeH264ToFile 原本按到达顺序解包写文件：乱序或晚到（NACK 重传）的包只能丢弃，它之后的包已经写出，
+//     缺口处的帧损坏，stall 统计也把乱序当成卡顿
+//   - 开启后包先进入缓冲，按序列号依次交给解包器：下一个期望的包到达时立即放出（按序到达的流不增加延迟）；
+//     出现缺口时，缺口之后的包最多等待 -jitter-buffer 指定的时长，期间缺失的包到达就按顺序补上，超时则放弃缺口继续放出
+//   - 交给 sink 的到达时间是放出时刻，帧延迟与 stall 统计包含在缓冲中等待的时间，与播放端看到的一致
+//   - 序列号早于已放出位置的包（等待超时之后才到达）丢弃并计数；rtp_dump.bin 仍按到达顺序记录原始包
+//   - 第一个包立即放出并确定起始序列号，不增加启动延迟；代价是比它更早、却在它之后到达的包按晚到丢弃
+//   - 0（默认）关闭，保持按到达顺序写入的旧行为；-playout 按策略设置等待时长，adaptive 时由 PlayoutAdapter 随抖动调整（见 playout.go）
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// jitterBufferDelay 是 -jitter-buffer 指定的最长等待时间，0 表示关闭
+var jitterBufferDelay time.Duration
+
+// jitterEntry 是缓冲中的一个包
+type jitterEntry struct {
+	pkt     *rtp.Packet
+	rtx     bool
+	arrival time.Time
+}
+
+// JitterBuffer 按序列号重排一个视频轨道的 RTP 包；所有方法对 nil 安全（nil 时包直接交给 deliver）
+type JitterBuffer struct {
+	delay    time.Duration
+	pending  []jitterEntry // 按与 nextSeq 的距离从小到大排列
+	nextSeq  uint16
+	haveNext bool
+
+	held       int64 // 因前面有缺口而等待过的包
+	reordered  int64 // 晚于序列号更大的包到达、在缓冲中补上的包
+	skipped    int64 // 等待超时后放弃的缺失序列号
+	late       int64 // 到达时已越过其位置、被丢弃的包
+	duplicates int64
+	maxDepth   int
+	maxHold    time.Duration
+
+	adapter *PlayoutAdapter // -playout adaptive 时非 nil，delay 跟随它
+	log     *PlayoutLog     // 可为 nil
+}
+
+// NewJitterBuffer 创建最长等待 delay 的抖动缓冲；delay <= 0 时返回 nil（关闭）
+func NewJitterBuffer(delay time.Duration) *JitterBuffer {
+	if delay <= 0 {
+		return nil
+	}
+	return &JitterBuffer{delay: delay}
+}
+
+// EnableAdaptive 让缺口等待时长从当前值开始随到达间隔抖动调整；clockRate 为视频轨道的 RTP 时钟频率
+func (j *JitterBuffer) EnableAdaptive(clockRate uint32) {
+	if j == nil {
+		return
+	}
+	j.adapter = NewPlayoutAdapter(clockRate, j.delay)
+	j.delay = j.adapter.Delay()
+}
+
+// distance 返回 seq 相对下一个期望序列号的距离，负数表示已经放出过的位置
+func (j *JitterBuffer) distance(seq uint16) int {
+	return int(int16(seq - j.nextSeq))
+}
+
+// Push 放入一个到达的包，并把可以放出的包按序列号交给 deliver
+func (j *JitterBuffer) Push(pkt *rtp.Packet, rtx bool, arrival time.Time, deliver func(pkt *rtp.Packet, rtx bool, arrival time.Time)) {
+	if j == nil {
+		deliver(pkt, rtx, arrival)
+		return
+	}
+	if j.adapter != nil && !rtx {
+		j.adapter.OnArrival(pkt.Timestamp, arrival)
+		j.delay = j.adapter.Delay()
+	}
+	if !j.haveNext {
+		j.nextSeq, j.haveNext = pkt.SequenceNumber, true
+	}
+	d := j.distance(pkt.SequenceNumber)
+	if d < 0 {
+		j.late++
+		if j.adapter != nil {
+			j.adapter.OnLate(arrival)
+			j.delay = j.adapter.Delay()
+		}
+		j.sample(arrival, false)
+		return
+	}
+	i, found := slices.BinarySearchFunc(j.pending, d, func(e jitterEntry, target int) int {
+		return j.distance(e.pkt.SequenceNumber) - target
+	})
+	if found {
+		j.duplicates++
+		j.sample(arrival, false)
+		return
+	}
+	if i < len(j.pending) {
+		j.reordered++
+	}
+	if d > 0 {
+		j.held++
+	}
+	j.pending = slices.Insert(j.pending, i, jitterEntry{pkt: pkt, rtx: rtx, arrival: arrival})
+	j.maxDepth = max(j.maxDepth, len(j.pending))
+	j.Release(arrival, deliver)
+}
+
+// Release 放出从下一个期望序列号开始连续的包；缺口之后的包等待超过 delay 时放弃缺口。now 作为放出包的到达时间
+func (j *JitterBuffer) Release(now time.Time, deliver func(pkt *rtp.Packet, rtx bool, arrival time.Time)) {
+	if j == nil {
+		return
+	}
+	j.release(now, j.delay, deliver)
+	j.sample(now, false)
+}
+
+// release 按 Release 的规则放出包，缺口最多等待 wait
+func (j *JitterBuffer) release(now time.Time, wait time.Duration, deliver func(pkt *rtp.Packet, rtx bool, arrival time.Time)) {
+	for len(j.pending) > 0 {
+		head := j.pending[0]
+		if gap := j.distance(head.pkt.SequenceNumber); gap > 0 {
+			if now.Sub(j.oldestArrival()) < wait {
+				return
+			}
+			j.skipped += int64(gap)
+			j.nextSeq = head.pkt.SequenceNumber
+		}
+		if head.arrival.Before(now) {
+			j.maxHold = max(j.maxHold, now.Sub(head.arrival))
+		}
+		j.pending = j.pending[1:]
+		j.nextSeq++
+		deliver(head.pkt, head.rtx, now)
+	}
+	j.pending = nil
+}
+
+// oldestArrival 返回缓冲中最早到达的包的到达时间，即当前缺口已经等待的起点
+func (j *JitterBuffer) oldestArrival() time.Time {
+	oldest := j.pending[0].arrival
+	for _, e := range j.pending[1:] {
+		if e.arrival.Before(oldest) {
+			oldest = e.arrival
+		}
+	}
+	return oldest
+}
+
+// Flush 在接收结束时按序列号放出缓冲中剩余的包，缺口不再等待
+func (j *JitterBuffer) Flush(now time.Time, deliver func(pkt *rtp.Packet, rtx bool, arrival time.Time)) {
+	if j == nil {
+		return
+	}
+	j.release(now, 0, deliver)
+	j.sample(now, true)
+}
+
+// sample 把当前状态交给 playout 记录器
+func (j *JitterBuffer) sample(now time.Time, force bool) {
+	if j.log == nil {
+		return
+	}
+	s := playoutSample{depth: len(j.pending), delay: j.delay, late: j.late, skipped: j.skipped}
+	if j.adapter != nil {
+		s.jitter = j.adapter.Jitter()
+	}
+	j.log.Sample(now, s, force)
+}
+
+// ReadDeadline 返回下一次 ReadRTP 的截止时间：缓冲在等待缺口时为放弃缺口的时刻（wake 为 true，超时后调用 Release），
+// 收到结束标记后不晚于其 grace 截止时间；都没有时为零值，不设截止时间
+func (j *JitterBuffer) ReadDeadline(eos *EndOfStreamWatcher) (deadline time.Time, wake bool) {
+	deadline = eos.Deadline()
+	if j == nil || len(j.pending) == 0 {
+		return deadline, false
+	}
+	release := j.oldestArrival().Add(j.delay)
+	if deadline.IsZero() || release.Before(deadline) {
+		return release, true
+	}
+	return deadline, false
+}
+
+// isReadTimeout 表示读取因截止时间到达而返回
+func isReadTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Report 打印缓冲统计
+func (j *JitterBuffer) Report() {
+	if j == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Jitter buffer (%v): %d packets held behind gaps, %d out-of-order packets put back in sequence, "+
+		"%d missing packets skipped, %d late and %d duplicate packets dropped, max depth %d packets, max hold %v\n",
+		j.delay, j.held, j.reordered, j.skipped, j.late, j.duplicates, j.maxDepth, j.maxHold.Round(time.Millisecond))
+	if a := j.adapter; a != nil {
+		fmt.Fprintf(os.Stderr, "Adaptive playout: wait adjusted between %v and %v (%d increases, %d decreases), interarrival jitter %v at the end\n",
+			a.minDelay.Round(time.Millisecond), a.maxDelay.Round(time.Millisecond), a.grows, a.shrinks, a.Jitter().Round(100*time.Microsecond))
+	}
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// jitterStep 是送入抖动缓冲的一步：seq >= 0 时在 at 到达一个包，seq < 0 时在 at 调用 Release（读取超时）
+type jitterStep struct {
+	seq int
+	at  time.Duration
+}
+
+// jitterCounters 是测试关心的统计
+type jitterCounters struct {
+	held, reordered, skipped, late, duplicates int64
+}
+
+func TestJitterBuffer(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name  string
+		steps []jitterStep
+		want  []uint16 // 放出的序列号（按放出顺序）
+		// wantAt 是每个包的放出时刻（相对起点），为 nil 时不检查
+		wantAt []time.Duration
+		counts jitterCounters
+	}{
+		{
+			name:   "in-order packets pass straight through",
+			steps:  []jitterStep{{10, 0}, {11, 5 * ms}, {12, 10 * ms}},
+			want:   []uint16{10, 11, 12},
+			wantAt: []time.Duration{0, 5 * ms, 10 * ms},
+		},
+		{
+			name:   "reorder within the delay",
+			steps:  []jitterStep{{10, 0}, {12, 5 * ms}, {13, 6 * ms}, {11, 20 * ms}},
+			want:   []uint16{10, 11, 12, 13},
+			wantAt: []time.Duration{0, 20 * ms, 20 * ms, 20 * ms},
+			counts: jitterCounters{held: 2, reordered: 1},
+		},
+		{
+			name:   "gap skipped after the delay",
+			steps:  []jitterStep{{10, 0}, {13, 5 * ms}, {14, 6 * ms}, {-1, 54 * ms}, {-1, 55 * ms}},
+			want:   []uint16{10, 13, 14},
+			wantAt: []time.Duration{0, 55 * ms, 55 * ms},
+			counts: jitterCounters{held: 2, skipped: 2},
+		},
+		{
+			name:   "late and duplicate packets are dropped",
+			steps:  []jitterStep{{10, 0}, {12, 5 * ms}, {12, 6 * ms}, {-1, 55 * ms}, {11, 60 * ms}, {10, 61 * ms}},
+			want:   []uint16{10, 12},
+			counts: jitterCounters{held: 1, skipped: 1, late: 2, duplicates: 1},
+		},
+		{
+			name:   "sequence numbers wrap around",
+			steps:  []jitterStep{{65534, 0}, {65535, 1 * ms}, {1, 2 * ms}, {0, 3 * ms}, {2, 4 * ms}},
+			want:   []uint16{65534, 65535, 0, 1, 2},
+			counts: jitterCounters{held: 1, reordered: 1},
+		},
+		{
+			name:   "gap across the wraparound is skipped",
+			steps:  []jitterStep{{65534, 0}, {1, 1 * ms}, {-1, 51 * ms}},
+			want:   []uint16{65534, 1},
+			counts: jitterCounters{held: 1, skipped: 2},
+		},
+		{
+			// 第一个包立即放出并确定起始序列号（不增加启动延迟），因此在它之后才到达的更早的包按晚到丢弃
+			name:   "first packet fixes the start sequence",
+			steps:  []jitterStep{{20, 0}, {19, 1 * ms}, {21, 2 * ms}},
+			want:   []uint16{20, 21},
+			counts: jitterCounters{late: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			j := NewJitterBuffer(delay)
+			var got []uint16
+			var gotAt []time.Duration
+			deliver := func(pkt *rtp.Packet, _ bool, arrival time.Time) {
+				got = append(got, pkt.SequenceNumber)
+				gotAt = append(gotAt, arrival.Sub(start))
+			}
+			for _, step := range tt.steps {
+				now := start.Add(step.at)
+				if step.seq < 0 {
+					j.Release(now, deliver)
+					continue
+				}
+				j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(step.seq)}}, false, now, deliver)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("released %v, want %v", got, tt.want)
+			}
+			if tt.wantAt != nil && !slices.Equal(gotAt, tt.wantAt) {
+				t.Errorf("released at %v, want %v", gotAt, tt.wantAt)
+			}
+			counts := jitterCounters{j.held, j.reordered, j.skipped, j.late, j.duplicates}
+			if counts != tt.counts {
+				t.Errorf("counters %+v, want %+v", counts, tt.counts)
+			}
+			if len(j.pending) != 0 {
+				t.Errorf("%d packets still pending", len(j.pending))
+			}
+		})
+	}
+}
+
+func TestJitterBufferReadDeadline(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	j := NewJitterBuffer(50 * time.Millisecond)
+	deliver := func(*rtp.Packet, bool, time.Time) {}
+
+	if deadline, wake := j.ReadDeadline(nil); !deadline.IsZero() || wake {
+		t.Fatalf("empty buffer: deadline %v, wake %v; want none", deadline, wake)
+	}
+	j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1}}, false, start, deliver)
+	j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 3}}, false, start.Add(10*time.Millisecond), deliver)
+	j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 4}}, false, start.Add(20*time.Millisecond), deliver)
+	// 缺口从最早等待的包（序列号 3）到达时开始计时
+	deadline, wake := j.ReadDeadline(nil)
+	if want := start.Add(60 * time.Millisecond); !deadline.Equal(want) || !wake {
+		t.Errorf("waiting on a gap: deadline %v, wake %v; want %v, true", deadline, wake, want)
+	}
+
+	j.Flush(start.Add(30*time.Millisecond), deliver)
+	if deadline, wake = j.ReadDeadline(nil); !deadline.IsZero() || wake {
+		t.Errorf("after Flush: deadline %v, wake %v; want none", deadline, wake)
+	}
+}
+
+func TestJitterBufferDisabled(t *testing.T) {
+	j := NewJitterBuffer(0)
+	if j != nil {
+		t.Fatal("NewJitterBuffer(0) is not nil")
+	}
+	var got []uint16
+	for _, seq := range []uint16{3, 1, 2} {
+		j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}}, false, time.Now(), func(pkt *rtp.Packet, _ bool, _ time.Time) {
+			got = append(got, pkt.SequenceNumber)
+		})
+	}
+	if !slices.Equal(got, []uint16{3, 1, 2}) {
+		t.Errorf("disabled buffer released %v, want arrival order", got)
+	}
+}
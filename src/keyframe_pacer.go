@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// keyframe_pacer.go - 按帧类型调度的 RTP pacer（把关键帧分摊到多个帧间隔发送）
+//
+// 说明：
+//   - 关键帧通常是 P 帧的数倍大小，一次性发出会瞬间推高瓶颈队列延迟
+//   - 开启后，关键帧的 RTP 包均匀分布在随后的 N 个帧间隔内发送
+//   - 之后的 P 帧按 FIFO 排在关键帧后面：会被推迟，但不会被丢弃
+//   - 每帧由 pacing 引入的额外延迟（入队 → 最后一个包发出）记录到 pacer.csv
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// pacedFrame 是等待 pacer 发送的一帧
+type pacedFrame struct {
+	seq        int
+	packets    []*rtp.Packet
+	bytes      int
+	keyframe   bool
+	enqueuedAt time.Time
+}
+
+// KeyframePacer 实现 h264SampleWriter：WriteSample 只负责分片入队，由后台协程按节奏发送
+type KeyframePacer struct {
+	track         *ptsH264Track
+	frameInterval time.Duration
+	spreadFrames  int // 关键帧分摊的帧间隔数
+
+	frames chan *pacedFrame
+	done   chan struct{}
+
+	// queueMu 保护 closed 与 frames 的发送/关闭（与 mu 分开，避免发送协程在 record 时互相等待）
+	queueMu sync.Mutex
+	closed  bool
+	seq     int
+
+	mu        sync.Mutex
+	sendErr   error
+	count     int
+	sumDelay  time.Duration
+	maxDelay  time.Duration
+	keyframes int
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewKeyframePacer 创建并启动一个 pacer。
+// spreadFrames 必须 >= 1（1 表示关键帧在一个帧间隔内均匀发送）；csvPath 为空时不写 CSV。
+func NewKeyframePacer(track *ptsH264Track, frameInterval time.Duration, spreadFrames int, csvPath string) (*KeyframePacer, error) {
+	if spreadFrames < 1 {
+		return nil, fmt.Errorf("spreadFrames must be >= 1, got %d", spreadFrames)
+	}
+	if frameInterval <= 0 {
+		return nil, fmt.Errorf("frameInterval must be positive")
+	}
+
+	p := &KeyframePacer{
+		track:         track,
+		frameInterval: frameInterval,
+		spreadFrames:  spreadFrames,
+		// 容量足够大以保证 "只推迟不丢弃"；真的写满时 WriteSample 阻塞，把背压传给编码端
+		frames: make(chan *pacedFrame, 1024),
+		done:   make(chan struct{}),
+	}
+
+	if csvPath != "" {
+		if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create pacer directory: %w", err)
+		}
+		f, err := os.Create(csvPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pacer csv: %w", err)
+		}
+		w := csv.NewWriter(f)
+		header := []string{
+			"frame_seq",
+			"keyframe",
+			"packets",
+			"bytes",
+			"enqueue_unix_ms",
+			"added_latency_ms", // 入队到最后一个包发出的时间
+		}
+		if err = w.Write(header); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write pacer header: %w", err)
+		}
+		w.Flush()
+		p.writer = w
+		p.file = f
+	}
+
+	go p.run()
+	return p, nil
+}
+
+// WriteSample 分片并入队一帧。返回的是之前异步发送中遇到的错误（如果有）。
+func (p *KeyframePacer) WriteSample(sample media.Sample) error {
+	p.mu.Lock()
+	sendErr := p.sendErr
+	p.mu.Unlock()
+	if sendErr != nil {
+		return sendErr
+	}
+
+	p.queueMu.Lock()
+	defer p.queueMu.Unlock()
+	if p.closed {
+		return fmt.Errorf("pacer closed")
+	}
+	p.seq++
+	p.frames <- &pacedFrame{
+		seq:        p.seq,
+		packets:    p.track.packetize(sample),
+		bytes:      len(sample.Data),
+		keyframe:   h264ContainsIDR(sample.Data),
+		enqueuedAt: time.Now(),
+	}
+	return nil
+}
+
+// run 是发送协程：关键帧的包均匀分布在 spreadFrames 个帧间隔内，其余帧立即发送
+func (p *KeyframePacer) run() {
+	defer close(p.done)
+
+	for frame := range p.frames {
+		var gap time.Duration
+		if frame.keyframe && len(frame.packets) > 1 {
+			gap = time.Duration(p.spreadFrames) * p.frameInterval / time.Duration(len(frame.packets))
+		}
+
+		start := time.Now()
+		for i, pkt := range frame.packets {
+			if gap > 0 {
+				// 按绝对时间安排，避免 Sleep 误差累积
+				if wait := time.Until(start.Add(time.Duration(i) * gap)); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			if err := p.track.track.WriteRTP(pkt); err != nil {
+				p.mu.Lock()
+				if p.sendErr == nil {
+					p.sendErr = err
+				}
+				p.mu.Unlock()
+				break
+			}
+		}
+
+		p.record(frame, time.Since(frame.enqueuedAt))
+	}
+}
+
+// record 记录一帧由 pacing 引入的额外延迟
+func (p *KeyframePacer) record(frame *pacedFrame, delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.count++
+	p.sumDelay += delay
+	if delay > p.maxDelay {
+		p.maxDelay = delay
+	}
+	if frame.keyframe {
+		p.keyframes++
+	}
+
+	if p.writer == nil {
+		return
+	}
+	record := []string{
+		fmt.Sprintf("%d", frame.seq),
+		fmt.Sprintf("%t", frame.keyframe),
+		fmt.Sprintf("%d", len(frame.packets)),
+		fmt.Sprintf("%d", frame.bytes),
+		fmt.Sprintf("%d", frame.enqueuedAt.UnixMilli()),
+		fmt.Sprintf("%.3f", float64(delay)/float64(time.Millisecond)),
+	}
+	if err := p.writer.Write(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing pacer CSV: %v\n", err)
+		return
+	}
+	p.writer.Flush()
+}
+
+// Stats 返回已发送帧数、关键帧数以及 pacing 引入的平均/最大额外延迟
+func (p *KeyframePacer) Stats() (frames, keyframes int, meanDelay, maxDelay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.count > 0 {
+		meanDelay = p.sumDelay / time.Duration(p.count)
+	}
+	return p.count, p.keyframes, meanDelay, p.maxDelay
+}
+
+// Close 停止接收新帧，等待队列中的帧发送完毕后关闭 CSV
+func (p *KeyframePacer) Close() {
+	p.queueMu.Lock()
+	if p.closed {
+		p.queueMu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.frames)
+	p.queueMu.Unlock()
+
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writer != nil {
+		p.writer.Flush()
+	}
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing pacer CSV file: %v\n", err)
+		}
+	}
+}
+
+// h264ContainsIDR 判断 Annex-B 格式的 access unit 中是否包含 IDR slice（NAL type 5）
+func h264ContainsIDR(data []byte) bool {
+	zeros := 0
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == 0:
+			zeros++
+		case data[i] == 1 && zeros >= 2:
+			if i+1 < len(data) && data[i+1]&0x1F == 5 {
+				return true
+			}
+			zeros = 0
+		default:
+			zeros = 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// keyframe_recovery.go - 丢包后的关键帧恢复时延（client 的 -since-keyframe）
+//
+// 说明：
+//   - 恢复时延 = 检测到 RTP 丢包（序列号缺口）到下一个完整收到的关键帧之间的时间；丢包之后、关键帧之前的帧都依赖丢失的数据，
+//     画面无法正确解码，这段时间直接反映 NACK / 按需关键帧等机制的效果
+//   - client 不解码，"完整收到" 指 IDR 所在的 access unit（到 marker 位为止）没有任何序列号缺口；缺了分片的 IDR 不算恢复
+//   - 同一次恢复之前的多次丢包合并为一个事件，从第一次丢包开始计时；client 周期性发送的 PLI 不是丢包引起的，不作为事件起点
+//   - server 使用 -encoder-latency-mode intra-refresh 时没有周期性 IDR：带 recovery point SEI 的 access unit 开始一个刷新周期，
+//     之后 recovery_frame_cnt 个 access unit 都完整收到时画面恢复，与完整的 IDR 同样结束事件；周期中途再次丢包则等待下一个 recovery point
+//   - 只在接收协程中更新，结束后由 main 读取 Stats 写入 metrics_summary
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keyframeRecovery 在 -since-keyframe 开启时非 nil，由 h264StreamSink 对每个视频 RTP 包调用
+var keyframeRecovery *KeyframeRecoveryTracker
+
+// KeyframeRecoveryTracker 统计每次丢包到下一个完整关键帧的时延，方法对 nil 安全
+type KeyframeRecoveryTracker struct {
+	mu sync.Mutex
+
+	lossAt      time.Time // 当前未恢复事件的第一次丢包时间，零值表示没有未恢复的丢包
+	lostPackets int       // 当前事件累计丢失的包数
+
+	// 当前 access unit 的状态：newAU 表示下一个包开始新的 access unit
+	newAU      bool
+	auLoss     bool
+	auIDR      bool
+	auRecovery int // 本 access unit 中 recovery point SEI 的 recovery_frame_cnt，-1 表示没有
+
+	// refreshLeft 是当前刷新周期完成前还需完整收到的 access unit 数，-1 表示没有进行中的刷新周期
+	refreshLeft int
+
+	recoveriesMs []float64
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewKeyframeRecoveryTracker 创建统计器，并把逐事件记录写入 csvPath
+func NewKeyframeRecoveryTracker(csvPath string) (*KeyframeRecoveryTracker, error) {
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keyframe recovery csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"loss_unix_ms", "keyframe_unix_ms", "recovery_ms", "lost_packets"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write keyframe recovery header: %w", err)
+	}
+	w.Flush()
+	return &KeyframeRecoveryTracker{newAU: true, auRecovery: -1, refreshLeft: -1, writer: w, file: f}, nil
+}
+
+// OnPacket 处理一个按序到达的视频 RTP 包：lost 为它之前缺失的包数，
+// marker 为 RTP marker 位（access unit 结束），idr 表示包中带有 IDR 数据，
+// recovery 为包中 recovery point SEI 的 recovery_frame_cnt（-1 表示没有，见 rtpPayloadRecoveryPoint）
+func (t *KeyframeRecoveryTracker) OnPacket(lost int, marker, idr bool, recovery int, arrival time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.newAU {
+		t.newAU, t.auLoss, t.auIDR, t.auRecovery = false, false, false, -1
+	}
+	if lost > 0 {
+		// 缺口在本包之前，丢失的包可能属于本 access unit 的开头，因此本 access unit 也不完整
+		t.auLoss = true
+		if t.lossAt.IsZero() {
+			t.lossAt = arrival
+		}
+		t.lostPackets += lost
+	}
+	if idr {
+		t.auIDR = true
+	}
+	if recovery >= 0 {
+		t.auRecovery = recovery
+	}
+	if !marker {
+		return
+	}
+	t.newAU = true
+	switch {
+	case t.auLoss:
+		t.refreshLeft = -1
+	case t.auIDR:
+		t.refreshLeft = -1
+		if !t.lossAt.IsZero() {
+			t.record(arrival)
+		}
+	case t.auRecovery >= 0 && !t.lossAt.IsZero():
+		t.refreshLeft = t.auRecovery
+	case t.refreshLeft > 0:
+		t.refreshLeft--
+	default:
+		return
+	}
+	if t.refreshLeft == 0 && !t.lossAt.IsZero() {
+		t.refreshLeft = -1
+		t.record(arrival)
+	}
+}
+
+// record 结束当前事件
+func (t *KeyframeRecoveryTracker) record(keyframeAt time.Time) {
+	recoveryMs := float64(keyframeAt.Sub(t.lossAt)) / float64(time.Millisecond)
+	t.recoveriesMs = append(t.recoveriesMs, recoveryMs)
+	fmt.Fprintf(os.Stderr, "[Recovery] Keyframe received %.1f ms after loss (%d packets lost)\n", recoveryMs, t.lostPackets)
+
+	if err := t.writer.Write([]string{
+		fmt.Sprintf("%d", t.lossAt.UnixMilli()),
+		fmt.Sprintf("%d", keyframeAt.UnixMilli()),
+		fmt.Sprintf("%.3f", recoveryMs),
+		fmt.Sprintf("%d", t.lostPackets),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing keyframe recovery CSV: %v\n", err)
+	}
+	t.writer.Flush()
+
+	t.lossAt = time.Time{}
+	t.lostPackets = 0
+}
+
+// Stats 返回已恢复的事件数、恢复时延的均值 / P95 / 最大值（毫秒），以及结束时是否还有未恢复的丢包
+func (t *KeyframeRecoveryTracker) Stats() (events int, meanMs, p95Ms, maxMs float64, pending bool) {
+	if t == nil {
+		return 0, 0, 0, 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending = !t.lossAt.IsZero()
+	if len(t.recoveriesMs) == 0 {
+		return 0, 0, 0, 0, pending
+	}
+	sorted := append([]float64(nil), t.recoveriesMs...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	p95Index := min(int(math.Ceil(float64(len(sorted))*0.95))-1, len(sorted)-1)
+	return len(sorted), sum / float64(len(sorted)), sorted[max(p95Index, 0)], sorted[len(sorted)-1], pending
+}
+
+// Close 关闭 CSV 文件
+func (t *KeyframeRecoveryTracker) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.writer.Flush()
+	if err := t.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing keyframe recovery CSV file: %v\n", err)
+	}
+}
+
+// rtpPayloadHasIDR 判断 H.264 RTP payload 是否带有 IDR 数据（单 NAL、STAP-A 中的任一 NAL 或 FU-A 的任一分片）
+func rtpPayloadHasIDR(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	switch nalType := payload[0] & 0x1F; nalType {
+	case 5:
+		return true
+	case 24:
+		for offset := 1; offset+2 <= len(payload); {
+			nalSize := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if nalSize == 0 || offset+nalSize > len(payload) {
+				return false
+			}
+			if payload[offset]&0x1F == 5 {
+				return true
+			}
+			offset += nalSize
+		}
+	case 28:
+		return len(payload) >= 2 && payload[1]&0x1F == 5
+	}
+	return false
+}
+
+// rtpPayloadRecoveryPoint 在单个 NAL 或 STAP-A 的 RTP 负载中查找 recovery point SEI（payloadType 6），
+// 返回其中的 recovery_frame_cnt；x264 的 intra-refresh 在每个刷新周期开始的帧前发送该 SEI
+func rtpPayloadRecoveryPoint(payload []byte) (frames int, ok bool) {
+	if len(payload) < 1 {
+		return 0, false
+	}
+	switch nalType := payload[0] & 0x1F; nalType {
+	case 6:
+		return seiRecoveryFrameCount(payload[1:])
+	case 24:
+		for offset := 1; offset+2 <= len(payload); {
+			nalSize := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if nalSize == 0 || offset+nalSize > len(payload) {
+				return 0, false
+			}
+			if payload[offset]&0x1F == 6 {
+				if frames, ok = seiRecoveryFrameCount(payload[offset+1 : offset+nalSize]); ok {
+					return frames, true
+				}
+			}
+			offset += nalSize
+		}
+	}
+	return 0, false
+}
+
+// seiRecoveryFrameCount 解析 SEI NAL 的负载（不含 NAL 头），找到 recovery point 消息时返回 recovery_frame_cnt
+func seiRecoveryFrameCount(data []byte) (int, bool) {
+	// 去掉防竞争字节 00 00 03
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+
+	offset := 0
+	readValue := func() (int, bool) {
+		value := 0
+		for offset < len(rbsp) && rbsp[offset] == 0xFF {
+			value += 255
+			offset++
+		}
+		if offset >= len(rbsp) {
+			return 0, false
+		}
+		value += int(rbsp[offset])
+		offset++
+		return value, true
+	}
+	// 最后的 0x80 是 rbsp_trailing_bits
+	for offset < len(rbsp) && rbsp[offset] != 0x80 {
+		payloadType, ok := readValue()
+		if !ok {
+			return 0, false
+		}
+		payloadSize, ok := readValue()
+		if !ok || offset+payloadSize > len(rbsp) {
+			return 0, false
+		}
+		if payloadType == 6 {
+			return readUE(rbsp[offset : offset+payloadSize])
+		}
+		offset += payloadSize
+	}
+	return 0, false
+}
+
+// readUE 从 data 开头读取一个无符号指数哥伦布码 ue(v)
+func readUE(data []byte) (int, bool) {
+	bit := 0
+	next := func() (int, bool) {
+		if bit >= len(data)*8 {
+			return 0, false
+		}
+		v := int(data[bit/8]>>(7-bit%8)) & 1
+		bit++
+		return v, true
+	}
+	leadingZeros := 0
+	for {
+		v, ok := next()
+		if !ok || leadingZeros > 31 {
+			return 0, false
+		}
+		if v == 1 {
+			break
+		}
+		leadingZeros++
+	}
+	value := 0
+	for i := 0; i < leadingZeros; i++ {
+		v, ok := next()
+		if !ok {
+			return 0, false
+		}
+		value = value<<1 | v
+	}
+	return (1 << leadingZeros) - 1 + value, true
+}
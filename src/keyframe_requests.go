@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// keyframe_requests.go - 合并接收端的关键帧请求（PLI / FIR）并限制强制 IDR 的频率（-keyframe-min-interval）
+//
+// 说明：
+//   - 多个下游（tee relay）或重复的 PLI 在短时间内到达时，每个请求都产生一个 IDR 会使码率暴涨
+//   - 待处理的请求在下一帧合并为一个强制 IDR；距离上一个强制 IDR 不足 -keyframe-min-interval 时继续等待，
+//     间隔满足后只产生一个 IDR
+//   - IDR 发出后 -keyframe-coalesce-window 内到达的请求视为已被该 IDR 满足（对端发出请求时 IDR 还在路上），直接合并
+//   - 因丢帧、重新加载等原因强制的 IDR 同样满足待处理的请求，也计入频率限制
+//   - 每个被执行与被合并的请求都会输出日志，退出时打印汇总
+//   - 未开启时（默认）与之前一致，server 忽略 PLI / FIR
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// keyframeRequests 在 -keyframe-min-interval > 0 时非 nil，由 drainSenderRTCP 与发送循环调用
+var keyframeRequests *KeyframeRequests
+
+// KeyframeRequests 合并关键帧请求，方法对 nil 安全；Observe 在 RTCP 读取协程中调用，Take 在发送协程中调用
+type KeyframeRequests struct {
+	window      time.Duration // IDR 发出后视为已满足请求的时间窗
+	minInterval time.Duration // 两个强制 IDR 之间的最小间隔
+
+	mu           sync.Mutex
+	pending      int // 等待 IDR 的请求数
+	pendingPLI   int
+	pendingFIR   int
+	firstPending time.Time
+	lastForced   time.Time
+	lastFrameID  int
+
+	received  int
+	honored   int // 为请求强制的 IDR 数
+	satisfied int // 被其它原因强制的 IDR 满足的批次数（每批的其余请求计入 coalesced）
+	coalesced int // 合并到其它请求或已发出 IDR 的请求数
+}
+
+// NewKeyframeRequests 创建关键帧请求合并器
+func NewKeyframeRequests(window, minInterval time.Duration) *KeyframeRequests {
+	return &KeyframeRequests{window: window, minInterval: minInterval}
+}
+
+// Observe 从一组 RTCP 包中取出 PLI / FIR
+func (k *KeyframeRequests) Observe(pkts []rtcp.Packet, now time.Time) {
+	if k == nil {
+		return
+	}
+	for _, pkt := range pkts {
+		switch pkt.(type) {
+		case *rtcp.PictureLossIndication:
+			k.request("PLI", now)
+		case *rtcp.FullIntraRequest:
+			k.request("FIR", now)
+		}
+	}
+}
+
+// request 记录一个关键帧请求
+func (k *KeyframeRequests) request(kind string, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.received++
+	if k.pending == 0 && !k.lastForced.IsZero() && now.Sub(k.lastForced) < k.window {
+		k.coalesced++
+		fmt.Fprintf(os.Stderr, "[Keyframe] %s coalesced: IDR at frame %d was sent %v ago\n",
+			kind, k.lastFrameID, now.Sub(k.lastForced).Round(time.Millisecond))
+		return
+	}
+	if k.pending == 0 {
+		k.firstPending = now
+	} else {
+		k.coalesced++
+		fmt.Fprintf(os.Stderr, "[Keyframe] %s coalesced with %d pending request(s)\n", kind, k.pending)
+	}
+	k.pending++
+	if kind == "FIR" {
+		k.pendingFIR++
+	} else {
+		k.pendingPLI++
+	}
+}
+
+// Take 在编码 frameID 之前调用，forcing 表示这一帧已因其它原因强制为 IDR。
+// 有待处理的请求且满足最小间隔时返回 true，调用方应把这一帧编码为 IDR
+func (k *KeyframeRequests) Take(frameID int, forcing bool) bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if forcing {
+		if k.pending > 0 {
+			k.satisfied++
+			fmt.Fprintf(os.Stderr, "[Keyframe] Frame %d: %d pending request(s) satisfied by an IDR forced for another reason\n", frameID, k.pending)
+		}
+		k.markForced(frameID, now)
+		return false
+	}
+	if k.pending == 0 || (!k.lastForced.IsZero() && now.Sub(k.lastForced) < k.minInterval) {
+		return false
+	}
+	k.honored++
+	fmt.Fprintf(os.Stderr, "[Keyframe] Frame %d: forcing IDR for %d request(s) (PLI %d, FIR %d, first waited %v)\n",
+		frameID, k.pending, k.pendingPLI, k.pendingFIR, now.Sub(k.firstPending).Round(time.Millisecond))
+	k.markForced(frameID, now)
+	return true
+}
+
+// markForced 记录一个强制 IDR 并清空待处理的请求
+func (k *KeyframeRequests) markForced(frameID int, now time.Time) {
+	k.lastForced = now
+	k.lastFrameID = frameID
+	k.pending, k.pendingPLI, k.pendingFIR = 0, 0, 0
+}
+
+// Close 打印汇总
+func (k *KeyframeRequests) Close() {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "[Keyframe] %d request(s) received: %d forced IDR(s), %d coalesced, %d satisfied by IDRs forced for other reasons (min interval %v, window %v)\n",
+		k.received, k.honored, k.coalesced, k.satisfied, k.minInterval, k.window)
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// loss_feedback.go - 把接收端的 NACK / PLI 反馈交给拥塞控制器（Salsify 的丢包回退）
+//
+// 说明：
+//   - drainSenderRTCP 读取视频发送端的 RTCP，NACK 表示接收端发现了丢包
+//   - 发送循环在每帧发送完成后调用 Take：距上一帧以来（一个帧间隔内）收到过请求新序列号的 NACK 时，本帧的 FrameObservation.LossDetected 为 true，
+//     SalsifyController 按窗口内这类帧的比例计算 lossRate，超过 2% 时降低预算
+//   - NACK 按序列号去重：client 对同一个缺失包最多重发 3 次 NACK（见 nack_sender.go），只有第一次请求某个序列号时才标记，
+//     lossFeedbackSeqHorizon 之后同一序列号再次出现（序列号回绕）重新算作新的丢包
+//   - PLI / FIR 只计数，不标记：client 不论是否丢包每 3 秒发送一次 PLI，首个关键帧之前还会加发，无损链路上也会出现；
+//     真正的丢包已经由 NACK 反映（client 使用 -nack=false 时没有丢包反馈）
+//   - 反馈只说明这段时间内发生过丢包，不能精确对应到是哪一帧丢失
+//   - 未创建时（nil）LossDetected 恒为 false，与之前一致
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// lossFeedbackSeqHorizon 是 NACK 序列号去重的记忆时长：长于 client 对一个缺失包的重试时长（nackMaxAge），
+// 远短于 16 位序列号回绕一圈的时间
+const lossFeedbackSeqHorizon = 2 * time.Second
+
+// lossFeedback 在 Salsify server 中非 nil，由 drainSenderRTCP 与发送循环调用
+var lossFeedback *LossFeedback
+
+// LossFeedback 记录两帧之间是否收到过丢包反馈，方法对 nil 安全；
+// Observe 在 RTCP 读取协程中调用，Take 在发送协程中调用
+type LossFeedback struct {
+	mu      sync.Mutex
+	pending bool                 // 上次 Take 之后收到过请求新序列号的 NACK
+	nacked  map[uint16]time.Time // 最近请求过的序列号 → 第一次请求的时间
+
+	nacks          int // 收到的 NACK 包数
+	nackedPackets  int // NACK 请求重传的 RTP 包数（含重复请求）
+	newLost        int // 第一次被请求的序列号数
+	keyframeReqs   int // 收到的 PLI / FIR 数
+	frames         int // Take 的次数（发送的帧数）
+	framesWithLoss int // 被标记为丢失的帧数
+}
+
+// NewLossFeedback 创建反馈记录
+func NewLossFeedback() *LossFeedback {
+	return &LossFeedback{nacked: make(map[uint16]time.Time)}
+}
+
+// Observe 从一组 RTCP 包中取出 NACK 与 PLI / FIR
+func (l *LossFeedback) Observe(pkts []rtcp.Packet) {
+	l.observe(pkts, time.Now())
+}
+
+// observe 按 Observe 的规则处理 now 时刻收到的 RTCP 包
+func (l *LossFeedback) observe(pkts []rtcp.Packet, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for seq, first := range l.nacked {
+		if now.Sub(first) >= lossFeedbackSeqHorizon {
+			delete(l.nacked, seq)
+		}
+	}
+	for _, pkt := range pkts {
+		switch p := pkt.(type) {
+		case *rtcp.TransportLayerNack:
+			l.nacks++
+			for _, pair := range p.Nacks {
+				for _, seq := range pair.PacketList() {
+					l.nackedPackets++
+					if _, seen := l.nacked[seq]; seen {
+						continue
+					}
+					l.nacked[seq] = now
+					l.newLost++
+					l.pending = true
+				}
+			}
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			l.keyframeReqs++
+		}
+	}
+}
+
+// Take 在一帧发送完成后调用，返回上一帧以来是否收到过丢包反馈，并清除标记
+func (l *LossFeedback) Take() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frames++
+	if !l.pending {
+		return false
+	}
+	l.pending = false
+	l.framesWithLoss++
+	return true
+}
+
+// Report 在 session 结束时输出反馈汇总
+func (l *LossFeedback) Report(prefix string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.nacks == 0 && l.keyframeReqs == 0 {
+		fmt.Fprintf(os.Stderr, "%s Loss feedback: no NACK or PLI/FIR received\n", prefix)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s Loss feedback: %d NACKs (%d packet requests, %d distinct lost packets), %d PLI/FIR (not counted as loss); %d of %d frames marked as lost\n",
+		prefix, l.nacks, l.nackedPackets, l.newLost, l.keyframeReqs, l.framesWithLoss, l.frames)
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func nackFor(seqs ...uint16) *rtcp.TransportLayerNack {
+	return &rtcp.TransportLayerNack{MediaSSRC: 1, Nacks: rtcp.NackPairsFromSequenceNumbers(seqs)}
+}
+
+func TestLossFeedbackDeduplicatesNackRetries(t *testing.T) {
+	l := NewLossFeedback()
+	start := time.Unix(1000, 0)
+
+	l.observe([]rtcp.Packet{nackFor(10, 11)}, start)
+	if !l.Take() {
+		t.Fatal("first NACK for new sequence numbers should mark the frame as lossy")
+	}
+	// nack_sender.go 对同一个缺失包每 100ms 重发一次 NACK
+	for i := 1; i <= 2; i++ {
+		l.observe([]rtcp.Packet{nackFor(10, 11)}, start.Add(time.Duration(i)*100*time.Millisecond))
+		if l.Take() {
+			t.Fatalf("NACK retry %d for already requested packets marked a frame as lossy", i)
+		}
+	}
+	l.observe([]rtcp.Packet{nackFor(11, 12)}, start.Add(300*time.Millisecond))
+	if !l.Take() {
+		t.Fatal("NACK with a new sequence number should mark the frame as lossy")
+	}
+	if l.newLost != 3 || l.nackedPackets != 8 {
+		t.Fatalf("newLost = %d, nackedPackets = %d, want 3 and 8", l.newLost, l.nackedPackets)
+	}
+
+	// 超过去重时长后同一序列号（回绕后）重新算作丢包
+	l.observe([]rtcp.Packet{nackFor(10)}, start.Add(lossFeedbackSeqHorizon+time.Second))
+	if !l.Take() {
+		t.Fatal("sequence number seen again after the horizon should count as a new loss")
+	}
+}
+
+func TestLossFeedbackIgnoresKeyframeRequests(t *testing.T) {
+	l := NewLossFeedback()
+	now := time.Unix(1000, 0)
+	l.observe([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1}, &rtcp.FullIntraRequest{MediaSSRC: 1}}, now)
+	if l.Take() {
+		t.Fatal("PLI / FIR should not mark a frame as lossy")
+	}
+	if l.keyframeReqs != 2 {
+		t.Fatalf("keyframeReqs = %d, want 2", l.keyframeReqs)
+	}
+}
+
+func TestLossFeedbackNil(t *testing.T) {
+	var l *LossFeedback
+	l.Observe([]rtcp.Packet{nackFor(1)})
+	if l.Take() {
+		t.Fatal("nil LossFeedback should never report loss")
+	}
+}
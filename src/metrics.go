@@ -30,6 +30,54 @@ type FrameMetric struct {
 	LatencyMillis        float64
 	Stall                bool
 	EffectiveBitrateKbps float64
+	LatencySource        string // 见 latencySource* 常量
+	FirstFrame           bool
+}
+
+// LatencyMillis 的来源
+const (
+	latencySourceE2E         = "e2e"           // 端到端延迟（基于 server frame metadata）
+	latencySourceAbsSendTime = "abs_send_time" // 无 metadata 时按包中的 abs-send-time 计算（见 abs_send_time.go）
+	latencySourceInterFrame  = "inter_frame"   // 无 metadata 与 abs-send-time 时退化为帧间隔
+	latencySourceNone        = "none"          // 第一帧且无 metadata 与 abs-send-time：没有可用的延迟，latency_ms 记为 0
+)
+
+// computeFrameLatency 计算一帧的延迟指标，返回延迟（毫秒）、延迟来源、是否第一帧以及是否 stall。
+//
+// 规则：
+//   - 有 server metadata 且已知 server 开始时间：使用端到端延迟（第一帧也一样）
+//   - 否则帧开始的包带有 abs-send-time（sendTime 非零）时，使用它到达时间与发送时间之差（第一帧也一样）
+//   - 否则非第一帧使用帧间隔，第一帧没有可用延迟（latencySourceNone，延迟记为 0）
+//   - stall 只根据帧间隔判断（阈值由调用方按发送端的帧间隔给出），第一帧永远不算 stall
+func computeFrameLatency(frameID int, receiveTime, lastFrameReceiveTime time.Time, stallThreshold time.Duration,
+	frameMetadataMap map[int]FrameMetadata, serverStartTime, sendTime time.Time) (latencyMs float64, source string, firstFrame bool, stall bool) {
+
+	firstFrame = lastFrameReceiveTime.IsZero()
+
+	var interFrameLatency time.Duration
+	if !firstFrame {
+		interFrameLatency = receiveTime.Sub(lastFrameReceiveTime)
+		// 检测 stall：帧间隔 > 2倍正常帧间隔
+		stall = stallThreshold > 0 && interFrameLatency > stallThreshold
+	}
+
+	// 端到端延迟：server 和 client 使用统一的时间基准（server 的开始时间）
+	if metadata, ok := frameMetadataMap[frameID]; ok && !serverStartTime.IsZero() {
+		// metadata.SendStartMs 是 server 的相对时间戳，receiveTime 需要转换为相对于 server 开始时间的毫秒数；
+		// -clock-drift 时再减去开始之后两端时钟漂移造成的偏差
+		clientRelativeMs := receiveTime.Sub(serverStartTime).Milliseconds()
+		return float64(clientRelativeMs-metadata.SendStartMs) - clockDrift.Correction(receiveTime), latencySourceE2E, firstFrame, stall
+	}
+
+	// 没有 metadata（两端不共享目录）时用包中携带的发送时间，两端的时钟需要同步
+	if !sendTime.IsZero() {
+		return float64(receiveTime.Sub(sendTime).Nanoseconds())/1e6 - clockDrift.Correction(receiveTime), latencySourceAbsSendTime, firstFrame, stall
+	}
+
+	if firstFrame {
+		return 0, latencySourceNone, true, false
+	}
+	return float64(interFrameLatency.Nanoseconds()) / 1e6, latencySourceInterFrame, false, stall
 }
 
 // MetricsCSVWriter 是一个简单的线程安全 CSV 写入器
@@ -66,6 +114,8 @@ func NewMetricsCSVWriter(csvPath string) (*MetricsCSVWriter, error) {
 		"latency_ms",
 		"stall",
 		"effective_bitrate_kbps",
+		"latency_source",
+		"first_frame",
 	}
 	if err = w.Write(header); err != nil {
 		f.Close()
@@ -104,6 +154,8 @@ func NewMetricsCSVWriterWithStartTime(csvPath string, startTime time.Time) (*Met
 		"latency_ms",
 		"stall",
 		"effective_bitrate_kbps",
+		"latency_source",
+		"first_frame",
 	}
 	if err = w.Write(header); err != nil {
 		f.Close()
@@ -136,6 +188,8 @@ func (m *MetricsCSVWriter) WriteMetric(metric FrameMetric) {
 		fmt.Sprintf("%.3f", metric.LatencyMillis),
 		fmt.Sprintf("%t", metric.Stall),
 		fmt.Sprintf("%.3f", metric.EffectiveBitrateKbps),
+		metric.LatencySource,
+		fmt.Sprintf("%t", metric.FirstFrame),
 	}
 	if err := m.writer.Write(record); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing metrics CSV: %v\n", err)
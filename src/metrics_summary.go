@@ -6,6 +6,9 @@
 // 说明：
 //   - 读取 client_metrics.csv，计算整体统计指标
 //   - 包括：Average & P99 latency, Stall rate, Effective bitrate
+//   - 同目录下有 server 的 frame_metadata.csv 时计算帧丢失率
+//   - 按阈值给出连接质量等级（Excellent/Good/Fair/Poor）及原因
+//   - 帧间隔超过 summaryGapThreshold 视为断线 / 重连留下的缝隙，单独记录，并从 active 时长中扣除
 
 package main
 
@@ -17,8 +20,19 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// summaryGapThreshold 是视为连接缝隙（断线 / 重连）而不是普通卡顿的最小帧间隔
+const summaryGapThreshold = 2 * time.Second
+
+// SummaryGap 是一次连接缝隙：StartMs 为缝隙前最后一帧的时间戳（与 client_metrics.csv 的时间基准相同）
+type SummaryGap struct {
+	StartMs    int64 `json:"start_ms"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
 // SummaryMetrics 表示汇总统计指标
 type SummaryMetrics struct {
 	TotalFrames           int     `json:"total_frames"`
@@ -28,10 +42,148 @@ type SummaryMetrics struct {
 	EffectiveBitrateKbps  float64 `json:"effective_bitrate_kbps"`
 	TotalStallFrames      int     `json:"total_stall_frames"`
 	TotalDurationSeconds   float64 `json:"total_duration_seconds"`
+
+	// 扣除连接缝隙后的有效时长，以及缝隙列表（没有缝隙时与 TotalDurationSeconds 相同）
+	ActiveDurationSeconds float64      `json:"active_duration_seconds"`
+	Gaps                  []SummaryGap `json:"gaps,omitempty"`
+
+	// A/V skew（需要音频轨道与 RTCP SR，无样本时省略）
+	AVSkewMeanMs  float64 `json:"av_skew_mean_ms,omitempty"`
+	AVSkewMaxMs   float64 `json:"av_skew_max_ms,omitempty"`
+	AVSyncSamples int     `json:"av_sync_samples,omitempty"`
+
+	// 丢包后的关键帧恢复时延（-since-keyframe，无事件时省略；RecoveryPending 表示结束时仍有未恢复的丢包）
+	RecoveryEvents  int     `json:"recovery_events,omitempty"`
+	RecoveryMeanMs  float64 `json:"recovery_mean_ms,omitempty"`
+	RecoveryP95Ms   float64 `json:"recovery_p95_ms,omitempty"`
+	RecoveryMaxMs   float64 `json:"recovery_max_ms,omitempty"`
+	RecoveryPending bool    `json:"recovery_pending,omitempty"`
+
+	// 第一个关键帧相对视频轨道开始的时间（没有收到关键帧时省略）
+	FirstKeyframeMs float64 `json:"first_keyframe_ms,omitempty"`
+
+	// 两端时钟漂移率与结束时的延迟修正量（-clock-drift，估计不可用时省略）
+	ClockDriftPPM          float64 `json:"clock_drift_ppm,omitempty"`
+	ClockDriftCorrectionMs float64 `json:"clock_drift_correction_ms,omitempty"`
+
+	// 分片缺失（序列号缺口）时丢弃的不完整 NAL 与分片数（见 depacketizer.go，没有丢弃时省略）
+	DroppedFragmentNALs int `json:"dropped_fragment_nals,omitempty"`
+	DroppedFragments    int `json:"dropped_fragments,omitempty"`
+
+	// 抖动缓冲的播放策略、等待时长与深度（-jitter-buffer / -playout 且有 -session-dir 时，见 playout.go）
+	Playout *PlayoutSummary `json:"playout,omitempty"`
+
+	// AV1 各时间层的帧率、码率与接收端所在层的时间分布（基础 client 收到 AV1 时，见 av1_layers.go）
+	AV1Layers *AV1LayerSummary `json:"av1_layers,omitempty"`
+
+	// 帧丢失率（需要同目录下的 frame_metadata.csv，无法计算时 SentFrames 为 0）
+	SentFrames    int     `json:"sent_frames,omitempty"`
+	LostFrames    int     `json:"lost_frames,omitempty"`
+	FrameLossRate float64 `json:"frame_loss_rate"`
+
+	// 连接质量等级及判定原因
+	Quality        string   `json:"quality"`
+	QualityReasons []string `json:"quality_reasons"`
+}
+
+// 连接质量等级，从好到差
+var qualityLevels = []string{"Excellent", "Good", "Fair", "Poor"}
+
+// QualityThresholds 是连接质量分级的阈值
+//
+// 每个指标有三个上限，依次对应 Excellent / Good / Fair，超过 Fair 上限即为 Poor。
+// 各指标单独分级，会话的等级取其中最差的一项（任何一项差都会影响观看体验）。
+type QualityThresholds struct {
+	LatencyMs [3]float64 // 平均端到端延迟（毫秒）
+	StallRate [3]float64 // 卡顿帧比例（0~1）
+	LossRate  [3]float64 // 帧丢失率（0~1）
+}
+
+// defaultQualityThresholds 返回默认阈值（面向实时视频通话的经验值）
+func defaultQualityThresholds() QualityThresholds {
+	return QualityThresholds{
+		LatencyMs: [3]float64{100, 200, 400},
+		StallRate: [3]float64{0.01, 0.03, 0.10},
+		LossRate:  [3]float64{0.005, 0.02, 0.05},
+	}
+}
+
+// parseThresholdList 解析 "a,b,c" 形式的三个递增阈值，供 flag.Func 使用
+func parseThresholdList(s string, dst *[3]float64) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected 3 comma-separated values (excellent,good,fair), got %q", s)
+	}
+	var values [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold %q: %w", part, err)
+		}
+		if v < 0 || (i > 0 && v < values[i-1]) {
+			return fmt.Errorf("thresholds must be non-negative and non-decreasing, got %q", s)
+		}
+		values[i] = v
+	}
+	*dst = values
+	return nil
+}
+
+// formatThresholdList 把阈值格式化为 "a,b,c"（用于 flag 默认值显示）
+func formatThresholdList(values [3]float64) string {
+	return fmt.Sprintf("%g,%g,%g", values[0], values[1], values[2])
 }
 
-// CalculateSummaryMetrics 从 client_metrics.csv 计算汇总统计
-func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
+// gradeMetric 返回单个指标对应的等级下标（0=Excellent ... 3=Poor）
+func gradeMetric(value float64, limits [3]float64) int {
+	for i, limit := range limits {
+		if value <= limit {
+			return i
+		}
+	}
+	return len(limits)
+}
+
+// classifyQuality 根据阈值给会话分级，返回等级和每个指标的判定原因
+func classifyQuality(summary *SummaryMetrics, thresholds QualityThresholds) (string, []string) {
+	worst := 0
+	var reasons []string
+
+	check := func(name string, value float64, limits [3]float64, format func(float64) string) {
+		grade := gradeMetric(value, limits)
+		if grade > worst {
+			worst = grade
+		}
+		var reason string
+		if grade < len(limits) {
+			reason = fmt.Sprintf("%s %s <= %s (%s)", name, format(value), format(limits[grade]), qualityLevels[grade])
+		} else {
+			reason = fmt.Sprintf("%s %s > %s (%s)", name, format(value), format(limits[len(limits)-1]), qualityLevels[grade])
+		}
+		reasons = append(reasons, reason)
+	}
+	ms := func(v float64) string { return fmt.Sprintf("%.1f ms", v) }
+	percent := func(v float64) string { return fmt.Sprintf("%.2f%%", v*100.0) }
+
+	check("average latency", summary.AverageLatencyMs, thresholds.LatencyMs, ms)
+	check("stall rate", summary.StallRate, thresholds.StallRate, percent)
+	if summary.SentFrames > 0 {
+		check("frame loss", summary.FrameLossRate, thresholds.LossRate, percent)
+	} else {
+		reasons = append(reasons, "frame loss unknown (no frame_metadata.csv), not considered")
+	}
+
+	return qualityLevels[worst], reasons
+}
+
+// CalculateSummaryMetrics 从 client_metrics.csv 计算汇总统计，并按 thresholds 给出连接质量等级
+func CalculateSummaryMetrics(csvPath string, thresholds QualityThresholds) (*SummaryMetrics, error) {
+	return calculateSummary(csvPath, filepath.Join(filepath.Dir(csvPath), "frame_metadata.csv"), 0, thresholds)
+}
+
+// calculateSummary 是 CalculateSummaryMetrics 的实现：metadataPath 为 server 的 frame_metadata.csv（不存在时不计算帧丢失率），
+// 第一帧之后 warmup 时长内的帧不计入统计（-replay-warmup）
+func calculateSummary(csvPath, metadataPath string, warmup time.Duration, thresholds QualityThresholds) (*SummaryMetrics, error) {
 	f, err := os.Open(csvPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open metrics CSV: %w", err)
@@ -49,11 +201,16 @@ func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
 	}
 
 	var latencies []float64
+	var frameCount int
 	var stallCount int
 	var totalBitrateKbps float64
 	var bitrateCount int
 	var firstTimestamp int64
 	var lastTimestamp int64
+	var gaps []SummaryGap
+	var gapMs int64
+	var warmupEndMs int64 = -1
+	firstFrameIndex := 0
 
 	// 跳过 header
 	for i := 1; i < len(records); i++ {
@@ -62,7 +219,7 @@ func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
 			continue
 		}
 
-		// timestamp_ms (相对时间戳), frame_index, latency_ms, stall, effective_bitrate_kbps
+		// timestamp_ms (相对时间戳), frame_index, latency_ms, stall, effective_bitrate_kbps[, latency_source, first_frame]
 		timestampMs, err := strconv.ParseInt(record[0], 10, 64)
 		if err != nil {
 			continue
@@ -80,7 +237,22 @@ func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
 			continue
 		}
 
-		latencies = append(latencies, latencyMs)
+		// 预热期内的帧直接跳过，统计从预热结束后的第一帧开始
+		if warmupEndMs < 0 {
+			warmupEndMs = timestampMs + warmup.Milliseconds()
+		}
+		if timestampMs < warmupEndMs {
+			continue
+		}
+		if firstFrameIndex == 0 {
+			firstFrameIndex, _ = strconv.Atoi(record[1])
+		}
+
+		// 第一帧且没有 metadata 时没有可用延迟，不计入延迟统计（旧格式 CSV 没有该列）
+		frameCount++
+		if len(record) < 6 || record[5] != latencySourceNone {
+			latencies = append(latencies, latencyMs)
+		}
 		if stall {
 			stallCount++
 		}
@@ -91,6 +263,9 @@ func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
 
 		if firstTimestamp == 0 {
 			firstTimestamp = timestampMs
+		} else if interval := timestampMs - lastTimestamp; interval > summaryGapThreshold.Milliseconds() {
+			gaps = append(gaps, SummaryGap{StartMs: lastTimestamp, DurationMs: interval})
+			gapMs += interval
 		}
 		lastTimestamp = timestampMs
 	}
@@ -115,7 +290,7 @@ func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
 	p99Latency := latencies[p99Index]
 
 	// 计算 Stall rate
-	stallRate := float64(stallCount) / float64(len(latencies))
+	stallRate := float64(stallCount) / float64(frameCount)
 
 	// 计算平均有效码率
 	avgBitrate := 0.0
@@ -126,16 +301,43 @@ func CalculateSummaryMetrics(csvPath string) (*SummaryMetrics, error) {
 		// 计算总时长（秒）
 		// 注意：现在使用相对时间戳，所以 lastTimestamp - firstTimestamp 就是总时长
 		totalDuration := float64(lastTimestamp-firstTimestamp) / 1000.0
+	// 有效时长：扣除连接缝隙（缝隙本身包含一个正常帧间隔，相对总时长可以忽略）
+	activeDuration := float64(lastTimestamp-firstTimestamp-gapMs) / 1000.0
 
-	return &SummaryMetrics{
-		TotalFrames:          len(latencies),
+	summary := &SummaryMetrics{
+		TotalFrames:          frameCount,
 		AverageLatencyMs:    averageLatency,
 		P99LatencyMs:         p99Latency,
 		StallRate:            stallRate,
 		EffectiveBitrateKbps: avgBitrate,
 		TotalStallFrames:     stallCount,
 		TotalDurationSeconds: totalDuration,
-	}, nil
+		ActiveDurationSeconds: activeDuration,
+		Gaps:                  gaps,
+	}
+
+	// 帧丢失率：server 记录的已发送帧数与实际收到的帧数之差。
+	// 只统计在 client 最后一帧之前开始发送的帧，避免把 client 停止后 server 继续发送的帧算作丢失。
+	// 有预热期时同样不统计预热期内发送的帧（client 按收到顺序编号，与 server 的帧号近似对应）
+	if metadata, err := loadFrameMetadata(metadataPath); err == nil {
+		sent := 0
+		for _, m := range metadata {
+			if m.FrameID >= firstFrameIndex && m.SendStartMs <= lastTimestamp {
+				sent++
+			}
+		}
+		if sent > 0 {
+			summary.SentFrames = sent
+			if lost := sent - frameCount; lost > 0 {
+				summary.LostFrames = lost
+				summary.FrameLossRate = float64(lost) / float64(sent)
+			}
+		}
+	}
+
+	summary.Quality, summary.QualityReasons = classifyQuality(summary, thresholds)
+
+	return summary, nil
 }
 
 // WriteSummaryMetrics 将汇总统计写入 JSON 和文本文件
@@ -173,6 +375,52 @@ Total Duration:         %.2f seconds
 		summary.EffectiveBitrateKbps,
 		summary.TotalDurationSeconds,
 	)
+	if len(summary.Gaps) > 0 {
+		var gapSeconds float64
+		for _, gap := range summary.Gaps {
+			gapSeconds += float64(gap.DurationMs) / 1000.0
+		}
+		txtContent += fmt.Sprintf("Active Duration:        %.2f seconds (excluding %d gaps, %.2f seconds)\n",
+			summary.ActiveDurationSeconds, len(summary.Gaps), gapSeconds)
+	}
+	if summary.SentFrames > 0 {
+		txtContent += fmt.Sprintf("Frame Loss:             %.2f%% (%d of %d sent)\n",
+			summary.FrameLossRate*100.0, summary.LostFrames, summary.SentFrames)
+	}
+	if summary.AVSyncSamples > 0 {
+		txtContent += fmt.Sprintf("A/V Skew (mean/max):    %.3f / %.3f ms (%d samples)\n",
+			summary.AVSkewMeanMs, summary.AVSkewMaxMs, summary.AVSyncSamples)
+	}
+	if summary.RecoveryEvents > 0 {
+		txtContent += fmt.Sprintf("Keyframe Recovery:      mean %.1f / p95 %.1f / max %.1f ms (%d events)\n",
+			summary.RecoveryMeanMs, summary.RecoveryP95Ms, summary.RecoveryMaxMs, summary.RecoveryEvents)
+	}
+	if summary.FirstKeyframeMs > 0 {
+		txtContent += fmt.Sprintf("First Keyframe:         %.1f ms after track start\n", summary.FirstKeyframeMs)
+	}
+	if summary.ClockDriftPPM != 0 {
+		txtContent += fmt.Sprintf("Clock Drift:            %.2f ppm (latency corrected by %.1f ms at the end)\n",
+			summary.ClockDriftPPM, summary.ClockDriftCorrectionMs)
+	}
+	if p := summary.Playout; p != nil {
+		txtContent += fmt.Sprintf("Playout (%s):%s wait mean %.1f / min %.1f / max %.1f ms, depth mean %.2f / max %d packets, %d late, %d skipped\n",
+			p.Policy, strings.Repeat(" ", max(1, 13-len(p.Policy))), p.DelayMeanMs, p.DelayMinMs, p.DelayMaxMs, p.DepthMean, p.DepthMax, p.LatePackets, p.SkippedPackets)
+	}
+	if layers := summary.AV1Layers; layers != nil {
+		for _, layer := range layers.Layers {
+			txtContent += fmt.Sprintf("AV1 Layer T%d:           %d frames, %.2f fps, %.1f kbps, highest received layer %.1f%% of the time\n",
+				layer.TemporalID, layer.Frames, layer.FrameRate, layer.BitrateKbps, layer.ConsumedShare*100)
+		}
+		if layers.NoExtensionFrames > 0 {
+			txtContent += fmt.Sprintf("AV1 No Extension:       %d frames\n", layers.NoExtensionFrames)
+		}
+	}
+	if summary.Quality != "" {
+		txtContent += fmt.Sprintf("\nConnection Quality:     %s\n", summary.Quality)
+		for _, reason := range summary.QualityReasons {
+			txtContent += fmt.Sprintf("  - %s\n", reason)
+		}
+	}
 	if err := os.WriteFile(txtPath, []byte(txtContent), 0o644); err != nil {
 		return fmt.Errorf("failed to write text summary: %w", err)
 	}
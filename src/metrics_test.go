@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestComputeFrameLatency(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	receive := start.Add(1500 * time.Millisecond)
+	previous := receive.Add(-40 * time.Millisecond)
+	metadata := map[int]FrameMetadata{7: {FrameID: 7, SendStartMs: 1420}}
+	const threshold = 66 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		frameID     int
+		last        time.Time
+		threshold   time.Duration
+		serverStart time.Time
+		sendTime    time.Time
+		wantMs      float64
+		wantSource  string
+		wantFirst   bool
+		wantStall   bool
+	}{
+		{
+			name:       "first frame without metadata or send time",
+			frameID:    1,
+			threshold:  threshold,
+			wantSource: latencySourceNone,
+			wantFirst:  true,
+		},
+		{
+			name:        "first frame with metadata",
+			frameID:     7,
+			threshold:   threshold,
+			serverStart: start,
+			wantMs:      80,
+			wantSource:  latencySourceE2E,
+			wantFirst:   true,
+		},
+		{
+			name:       "first frame with abs-send-time",
+			frameID:    1,
+			threshold:  threshold,
+			sendTime:   receive.Add(-25 * time.Millisecond),
+			wantMs:     25,
+			wantSource: latencySourceAbsSendTime,
+			wantFirst:  true,
+		},
+		{
+			name:        "frame with metadata",
+			frameID:     7,
+			last:        previous,
+			threshold:   threshold,
+			serverStart: start,
+			sendTime:    receive.Add(-25 * time.Millisecond), // metadata 优先
+			wantMs:      80,
+			wantSource:  latencySourceE2E,
+		},
+		{
+			name:        "frame without metadata",
+			frameID:     8,
+			last:        previous,
+			threshold:   threshold,
+			serverStart: start,
+			wantMs:      40,
+			wantSource:  latencySourceInterFrame,
+		},
+		{
+			name:       "missing server start time falls back to abs-send-time",
+			frameID:    7,
+			last:       previous,
+			threshold:  threshold,
+			sendTime:   receive.Add(-30 * time.Millisecond),
+			wantMs:     30,
+			wantSource: latencySourceAbsSendTime,
+		},
+		{
+			name:       "missing server start time without send time",
+			frameID:    7,
+			last:       previous,
+			threshold:  threshold,
+			wantMs:     40,
+			wantSource: latencySourceInterFrame,
+		},
+		{
+			name:       "stall",
+			frameID:    7,
+			last:       receive.Add(-100 * time.Millisecond),
+			threshold:  threshold,
+			wantMs:     100,
+			wantSource: latencySourceInterFrame,
+			wantStall:  true,
+		},
+		{
+			name:       "no stall without a threshold",
+			frameID:    8,
+			last:       receive.Add(-100 * time.Millisecond),
+			wantMs:     100,
+			wantSource: latencySourceInterFrame,
+		},
+		{
+			name:        "first frame is never a stall",
+			frameID:     8,
+			threshold:   time.Nanosecond,
+			serverStart: start,
+			wantSource:  latencySourceNone,
+			wantFirst:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, source, first, stall := computeFrameLatency(tt.frameID, receive, tt.last, tt.threshold, metadata, tt.serverStart, tt.sendTime)
+			if math.Abs(ms-tt.wantMs) > 1e-9 || source != tt.wantSource || first != tt.wantFirst || stall != tt.wantStall {
+				t.Errorf("computeFrameLatency = (%v, %q, first %v, stall %v), want (%v, %q, first %v, stall %v)",
+					ms, source, first, stall, tt.wantMs, tt.wantSource, tt.wantFirst, tt.wantStall)
+			}
+		})
+	}
+}
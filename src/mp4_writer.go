@@ -0,0 +1,309 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// mp4_writer.go - 把接收到的 H.264 / H.265 access unit 直接封装为 MP4（-output xxx.mp4）
+//
+// 说明：
+//   - 默认仍写 Annex-B 裸流；-output 以 .mp4 结尾时由 writeH264ToFile 创建 MP4Writer，
+//     h264StreamSink 把写入的每个 NAL 单元（含 start code）按 access unit 交给它，不再需要事后用 ffmpeg -fflags +genpts 转封装
+//   - 时间戳：原先丢弃的 RTP 时间戳展开 32 位回绕后减去第一个 access unit 的时间戳作为 PTS，时间基为 1/clockRate（视频为 1/90000）
+//   - 时间戳单调时 DTS = PTS；时间戳回退（server 开启 B 帧时 RTP 时间戳跟随 PTS）时 DTS 取上一个 DTS + 1，
+//     PTS 不小于 DTS，这些帧的显示时间只是近似值（只计数，结束时打印）
+//   - 第一个关键帧之前的 access unit 无法解码，直接丢弃；MP4 的宽高由第一个关键帧解码得到，
+//     avcC / hvcC 由 FFmpeg 的 mp4 muxer 从第一个包中的参数集生成，之后的包仍是 Annex-B，由 muxer 转为长度前缀
+//   - MP4 需要可 seek 的输出（结束时回写 moov），不能写到 stdout
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asticode/go-astiav"
+	"github.com/pion/webrtc/v4"
+)
+
+// isMP4Output 判断 -output 是否要求 MP4 封装（按扩展名，不区分大小写）
+func isMP4Output(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".mp4")
+}
+
+// MP4Writer 把 Annex-B access unit 封装为 MP4，方法对 nil 安全，只能在接收协程中使用
+type MP4Writer struct {
+	path      string
+	codecID   astiav.CodecID
+	clockRate int
+	frameRate float64
+
+	formatContext *astiav.FormatContext
+	ioContext     *astiav.IOContext
+	stream        *astiav.Stream
+	packet        *astiav.Packet
+	headerWritten bool
+
+	au         []byte // 当前 access unit 的 Annex-B 数据
+	auKeyframe bool
+	auRTPTime  uint32
+
+	// RTP 时间戳展开：extTimestamp 是累计的 64 位时间戳，firstTimestamp 为第一个写入的 access unit 的展开值
+	haveTimestamp  bool
+	lastTimestamp  uint32
+	extTimestamp   int64
+	firstTimestamp int64
+	lastDTS        int64
+
+	frames          int
+	keyframes       int
+	skippedLeading  int // 第一个关键帧之前丢弃的 access unit 数
+	reorderedFrames int // 时间戳回退、DTS 需要生成的帧数
+	failed          bool
+}
+
+// NewMP4Writer 为 mimeType（video/H264 或 video/H265）的轨道创建 MP4 输出；文件在第一个关键帧到达时才写入文件头
+func NewMP4Writer(path, mimeType string, clockRate uint32, frameRate float64) (*MP4Writer, error) {
+	var codecID astiav.CodecID
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+		codecID = astiav.CodecIDH264
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH265):
+		codecID = astiav.CodecIDHevc
+	default:
+		return nil, fmt.Errorf("%w: cannot mux %s into MP4", ErrCodecUnsupported, mimeType)
+	}
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+
+	formatContext, err := astiav.AllocOutputFormatContext(nil, "mp4", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate MP4 output context: %w", err)
+	}
+	w := &MP4Writer{
+		path:          path,
+		codecID:       codecID,
+		clockRate:     int(clockRate),
+		frameRate:     frameRate,
+		formatContext: formatContext,
+	}
+	if w.stream = formatContext.NewStream(nil); w.stream == nil {
+		w.free()
+		return nil, errors.New("failed to add MP4 video stream")
+	}
+	w.stream.SetTimeBase(astiav.NewRational(1, w.clockRate))
+	if w.ioContext, err = astiav.OpenIOContext(path, astiav.NewIOContextFlags(astiav.IOContextFlagWrite)); err != nil {
+		w.free()
+		return nil, fmt.Errorf("failed to open MP4 output: %w", err)
+	}
+	formatContext.SetPb(w.ioContext)
+	w.packet = astiav.AllocPacket()
+	return w, nil
+}
+
+// BeginPacket 在解包一个 RTP 包之前调用：时间戳与当前 access unit 不同时（上一帧的 marker 包丢失），先写出当前 access unit
+func (w *MP4Writer) BeginPacket(rtpTimestamp uint32) {
+	if w == nil || w.failed {
+		return
+	}
+	if len(w.au) > 0 && rtpTimestamp != w.auRTPTime {
+		w.EndAccessUnit()
+	}
+	w.auRTPTime = rtpTimestamp
+}
+
+// AddNAL 把一个 NAL 单元（含 start code）追加到当前 access unit，kind 用于判断关键帧
+func (w *MP4Writer) AddNAL(startCode, nal []byte, kind nalKind) {
+	if w == nil || w.failed {
+		return
+	}
+	w.au = append(w.au, startCode...)
+	w.au = append(w.au, nal...)
+	if kind == nalKindKeyframe {
+		w.auKeyframe = true
+	}
+}
+
+// EndAccessUnit 在 access unit 结束时调用，按 RTP 时间戳生成 PTS / DTS 后写入 MP4
+func (w *MP4Writer) EndAccessUnit() {
+	if w == nil || w.failed || len(w.au) == 0 {
+		return
+	}
+	data, keyframe := w.au, w.auKeyframe
+	w.au, w.auKeyframe = w.au[:0], false
+
+	if !w.headerWritten {
+		if !keyframe {
+			w.skippedLeading++
+			return
+		}
+		if err := w.writeHeader(data); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+
+	// 展开 32 位回绕：按有符号差值累加，B 帧造成的小幅回退得到负的增量
+	if !w.haveTimestamp {
+		w.haveTimestamp = true
+		w.lastTimestamp = w.auRTPTime
+		w.extTimestamp = int64(w.auRTPTime)
+		w.firstTimestamp = w.extTimestamp
+		w.lastDTS = -1
+	} else {
+		w.extTimestamp += int64(int32(w.auRTPTime - w.lastTimestamp))
+		w.lastTimestamp = w.auRTPTime
+	}
+	pts := w.extTimestamp - w.firstTimestamp
+	dts := pts
+	if dts <= w.lastDTS {
+		w.reorderedFrames++
+		dts = w.lastDTS + 1
+		if pts < dts {
+			pts = dts
+		}
+	}
+	w.lastDTS = dts
+
+	if err := w.packet.FromData(append(data, make([]byte, rawYUVPacketPadding)...)); err != nil {
+		w.fail(fmt.Errorf("failed to fill MP4 packet: %w", err))
+		return
+	}
+	w.packet.SetSize(len(data))
+	w.packet.SetStreamIndex(w.stream.Index())
+	w.packet.SetPts(pts)
+	w.packet.SetDts(dts)
+	if w.frameRate > 0 {
+		w.packet.SetDuration(int64(float64(w.clockRate) / w.frameRate))
+	}
+	if keyframe {
+		w.packet.SetFlags(astiav.NewPacketFlags(astiav.PacketFlagKey))
+		w.keyframes++
+	}
+	// WriteHeader 可能修改流的时间基（mp4 muxer 会保留 1/90000，这里不做假设）
+	w.packet.RescaleTs(astiav.NewRational(1, w.clockRate), w.stream.TimeBase())
+	err := w.formatContext.WriteFrame(w.packet)
+	w.packet.Unref()
+	if err != nil {
+		w.fail(fmt.Errorf("failed to write MP4 packet: %w", err))
+		return
+	}
+	w.frames++
+}
+
+// writeHeader 从第一个关键帧解码出宽高，填写流参数后写入文件头
+func (w *MP4Writer) writeHeader(keyframeAU []byte) error {
+	width, height, err := probeVideoSize(w.codecID, keyframeAU)
+	if err != nil {
+		return err
+	}
+	params := w.stream.CodecParameters()
+	params.SetMediaType(astiav.MediaTypeVideo)
+	params.SetCodecID(w.codecID)
+	params.SetWidth(width)
+	params.SetHeight(height)
+	if w.frameRate > 0 {
+		rate := astiav.NewRational(int(w.frameRate*1000+0.5), 1000)
+		w.stream.SetAvgFrameRate(rate)
+		w.stream.SetRFrameRate(rate)
+	}
+	if err = w.formatContext.WriteHeader(nil); err != nil {
+		return fmt.Errorf("failed to write MP4 header: %w", err)
+	}
+	w.headerWritten = true
+	fmt.Fprintf(os.Stderr, "MP4 output: %s %dx%d to %s\n", w.codecID, width, height, w.path)
+	return nil
+}
+
+// probeVideoSize 解码一个关键帧 access unit，返回解码器从参数集得到的宽高
+func probeVideoSize(codecID astiav.CodecID, au []byte) (width, height int, err error) {
+	decoder := astiav.FindDecoder(codecID)
+	if decoder == nil {
+		return 0, 0, fmt.Errorf("%w: no %s decoder to probe the video size", ErrCodecUnsupported, codecID)
+	}
+	decodeCodecContext := astiav.AllocCodecContext(decoder)
+	if decodeCodecContext == nil {
+		return 0, 0, errors.New("failed to allocate probe decoder context")
+	}
+	defer decodeCodecContext.Free()
+	if err = decodeCodecContext.Open(decoder, nil); err != nil {
+		return 0, 0, fmt.Errorf("failed to open probe decoder: %w", err)
+	}
+
+	packet := astiav.AllocPacket()
+	defer packet.Free()
+	// FFmpeg 要求输入缓冲区后面有填充，与 raw_yuv.go 相同
+	data := append(append([]byte(nil), au...), make([]byte, rawYUVPacketPadding)...)
+	if err = packet.FromData(data); err != nil {
+		return 0, 0, fmt.Errorf("failed to fill probe packet: %w", err)
+	}
+	packet.SetSize(len(au))
+	if err = decodeCodecContext.SendPacket(packet); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode first keyframe: %w", err)
+	}
+	// 解码器解析参数集后即更新宽高；有输出延迟时再送入空包取出这一帧
+	if decodeCodecContext.Width() == 0 || decodeCodecContext.Height() == 0 {
+		frame := astiav.AllocFrame()
+		defer frame.Free()
+		if err = decodeCodecContext.SendPacket(nil); err == nil {
+			if err = decodeCodecContext.ReceiveFrame(frame); err == nil {
+				return frame.Width(), frame.Height(), nil
+			}
+		}
+		return 0, 0, fmt.Errorf("first keyframe does not give a video size: %v", err)
+	}
+	return decodeCodecContext.Width(), decodeCodecContext.Height(), nil
+}
+
+func (w *MP4Writer) fail(err error) {
+	w.failed = true
+	fmt.Fprintf(os.Stderr, "Error writing MP4 output, stopped: %v\n", err)
+}
+
+// Close 写出最后的 access unit 与 moov，关闭文件并打印汇总
+func (w *MP4Writer) Close() {
+	if w == nil {
+		return
+	}
+	w.EndAccessUnit()
+	if w.headerWritten {
+		if err := w.formatContext.WriteTrailer(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to write MP4 trailer: %v\n", err)
+		}
+	}
+	w.free()
+
+	if !w.headerWritten {
+		fmt.Fprintf(os.Stderr, "MP4 output: no keyframe received, %s has no video (%d access units skipped)\n", w.path, w.skippedLeading)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "MP4 output: %d frames (%d keyframes) written to %s", w.frames, w.keyframes, w.path)
+	if w.skippedLeading > 0 {
+		fmt.Fprintf(os.Stderr, ", %d access units before the first keyframe skipped", w.skippedLeading)
+	}
+	if w.reorderedFrames > 0 {
+		fmt.Fprintf(os.Stderr, ", %d frames with out-of-order RTP timestamps got generated DTS (approximate display times)", w.reorderedFrames)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// free 关闭输出文件并释放 FFmpeg 对象
+func (w *MP4Writer) free() {
+	if w.packet != nil {
+		w.packet.Free()
+		w.packet = nil
+	}
+	if w.ioContext != nil {
+		if err := w.ioContext.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close MP4 output: %v\n", err)
+		}
+		w.ioContext = nil
+	}
+	if w.formatContext != nil {
+		w.formatContext.Free()
+		w.formatContext = nil
+	}
+}
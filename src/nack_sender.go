@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// nack_sender.go - client 按序列号缺口发送 NACK（-nack）
+//
+// 说明：
+//   - 以前 client 的 NACK 由 pion 默认的 NACK generator 在 interceptor 中发出，应用层既看不到也关不掉，
+//     无法对比 "只靠 PLI / 关键帧恢复" 与 "逐包重传" 的效果
+//   - client 不再注册 pion 的 NACK generator（responder 保留，tee 的下游仍能请求重传），改由接收循环在每个视频包到达时检查序列号：
+//     出现缺口时立即为缺失的序列号发送 TransportLayerNack，仍未收到的每 nackRetryInterval 重发一次，最多 nackMaxAttempts 次，
+//     超过 nackMaxAge 的不再请求（重传赶不上播放）
+//   - 一次跳过超过 nackMaxGap 个序列号视为流重置（例如 server 重启编码），不请求
+//   - -nack（默认开启）控制是否发送；-nack=false 时 client 完全不发 NACK，丢包只能等 PLI / 关键帧恢复
+//   - server 不创建 nackSender，仍使用 pion 默认的 interceptor（基础 server 的 -nack-cache 见 retransmit.go）
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// nackRetryInterval 是同一个缺失包两次 NACK 之间的最短间隔
+	nackRetryInterval = 100 * time.Millisecond
+	// nackMaxAttempts 是一个缺失包最多请求的次数
+	nackMaxAttempts = 3
+	// nackMaxAge 是缺失包从发现到放弃的最长时间
+	nackMaxAge = time.Second
+	// nackMaxGap 是一次缺口最多请求的包数，超过时视为流重置
+	nackMaxGap = 512
+)
+
+// nackSender 在 client 中非 nil，负责视频丢包的 NACK；server 中为 nil
+var nackSender *NackSender
+
+// nackMissing 是一个等待重传的序列号
+type nackMissing struct {
+	detected time.Time
+	lastSent time.Time
+	attempts int
+}
+
+// NackSender 跟踪一个视频轨道的序列号缺口并发送 NACK，方法对 nil 安全
+type NackSender struct {
+	enabled bool
+
+	mu        sync.Mutex
+	pc        *webrtc.PeerConnection
+	ssrc      uint32
+	highest   uint16
+	haveSeq   bool
+	missing   map[uint16]*nackMissing
+	lost      int64 // 发现的缺失包数
+	nacks     int64 // 发出的 NACK 数
+	requests  int64 // NACK 中请求的包数（含重发）
+	recovered int64 // 请求后收到的包数
+	expired   int64 // 放弃请求的包数
+	resets    int64 // 视为流重置、没有请求的缺口数
+	sendErrs  int64
+}
+
+// NewNackSender 创建 NACK 发送器；enabled 为 false 时不发送 NACK（client 同样不注册 pion 的 generator）
+func NewNackSender(enabled bool) *NackSender {
+	return &NackSender{enabled: enabled, missing: make(map[uint16]*nackMissing)}
+}
+
+// Attach 设置发送 NACK 的 PeerConnection，需在收到视频包之前调用
+func (n *NackSender) Attach(pc *webrtc.PeerConnection) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.pc = pc
+	n.mu.Unlock()
+}
+
+// newAPI 创建与 webrtc.NewAPI(webrtc.WithSettingEngine(...)) 等价的 API（基础 client 使用），只是不注册 pion 的 NACK generator
+func (n *NackSender) newAPI(settingEngine webrtc.SettingEngine) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+	registry := &interceptor.Registry{}
+	if err := registerDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	), nil
+}
+
+// registerDefaultInterceptors 注册 pion 的默认 interceptor；nackSender 非 nil（client）时 NACK 只注册 responder，
+// 缺口由 nackSender 请求，避免同一个包发出两份 NACK
+func registerDefaultInterceptors(mediaEngine *webrtc.MediaEngine, registry *interceptor.Registry) error {
+	if nackSender == nil {
+		if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+			return fmt.Errorf("failed to register default interceptors: %w", err)
+		}
+		return nil
+	}
+	responder, err := nack.NewResponderInterceptor()
+	if err != nil {
+		return fmt.Errorf("failed to create NACK responder: %w", err)
+	}
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	registry.Add(responder)
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return fmt.Errorf("failed to configure RTCP reports: %w", err)
+	}
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return fmt.Errorf("failed to configure simulcast header extensions: %w", err)
+	}
+	if err := webrtc.ConfigureStatsInterceptor(registry); err != nil {
+		return fmt.Errorf("failed to configure stats interceptor: %w", err)
+	}
+	if err := webrtc.ConfigureTWCCSender(mediaEngine, registry); err != nil {
+		return fmt.Errorf("failed to configure TWCC sender: %w", err)
+	}
+	return nil
+}
+
+// OnPacket 在每个视频包到达时调用（重排之前）：记录新的缺口、清除补上的包，并为到期的缺失包发送 NACK
+func (n *NackSender) OnPacket(pkt *rtp.Packet, arrival time.Time) {
+	if n == nil || !n.enabled {
+		return
+	}
+	n.mu.Lock()
+	if !n.haveSeq || pkt.SSRC != n.ssrc {
+		n.ssrc, n.highest, n.haveSeq = pkt.SSRC, pkt.SequenceNumber, true
+		clear(n.missing)
+		n.mu.Unlock()
+		return
+	}
+	seq := pkt.SequenceNumber
+	if diff := int16(seq - n.highest); diff > 0 {
+		if gap := int(diff) - 1; gap > nackMaxGap {
+			n.resets++
+			clear(n.missing)
+		} else {
+			for s := n.highest + 1; s != seq; s++ {
+				n.missing[s] = &nackMissing{detected: arrival}
+			}
+			n.lost += int64(gap)
+		}
+		n.highest = seq
+	} else if _, ok := n.missing[seq]; ok {
+		delete(n.missing, seq)
+		n.recovered++
+	}
+
+	var due []uint16
+	for s, m := range n.missing {
+		if arrival.Sub(m.detected) > nackMaxAge ||
+			(m.attempts >= nackMaxAttempts && arrival.Sub(m.lastSent) >= nackRetryInterval) {
+			delete(n.missing, s)
+			n.expired++
+			continue
+		}
+		if m.attempts < nackMaxAttempts && (m.attempts == 0 || arrival.Sub(m.lastSent) >= nackRetryInterval) {
+			m.attempts++
+			m.lastSent = arrival
+			due = append(due, s)
+		}
+	}
+	pc, ssrc := n.pc, n.ssrc
+	if len(due) > 0 && pc != nil {
+		n.nacks++
+		n.requests += int64(len(due))
+	}
+	n.mu.Unlock()
+
+	if len(due) == 0 || pc == nil {
+		return
+	}
+	slices.Sort(due)
+	nackPacket := &rtcp.TransportLayerNack{MediaSSRC: ssrc, Nacks: rtcp.NackPairsFromSequenceNumbers(due)}
+	if err := pc.WriteRTCP([]rtcp.Packet{nackPacket}); err != nil && !isConnectionClosed(err) {
+		n.mu.Lock()
+		n.sendErrs++
+		first := n.sendErrs == 1
+		n.mu.Unlock()
+		if first {
+			fmt.Fprintf(os.Stderr, "Error sending RTCP NACK: %v\n", err)
+		}
+	}
+}
+
+// Report 打印 NACK 统计
+func (n *NackSender) Report() {
+	if n == nil {
+		return
+	}
+	if !n.enabled {
+		fmt.Fprintf(os.Stderr, "NACK: disabled (-nack=false), lost packets were not requested\n")
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "NACK: %d packets lost, %d NACKs sent (%d packet requests), %d recovered by retransmission, %d given up",
+		n.lost, n.nacks, n.requests, n.recovered, n.expired+int64(len(n.missing)))
+	if n.resets > 0 {
+		fmt.Fprintf(os.Stderr, ", %d sequence jumps over %d packets treated as stream resets", n.resets, nackMaxGap)
+	}
+	if n.sendErrs > 0 {
+		fmt.Fprintf(os.Stderr, ", %d send errors", n.sendErrs)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+}
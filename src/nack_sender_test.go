@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// nackArrival 是一个在 at 到达的视频包
+type nackArrival struct {
+	seq uint16
+	at  time.Duration
+}
+
+func TestNackSenderOnPacket(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name          string
+		arrivals      []nackArrival
+		wantMissing   map[uint16]int // 仍在等待的序列号 -> 已请求次数
+		wantLost      int64
+		wantRecovered int64
+		wantExpired   int64
+		wantResets    int64
+	}{
+		{
+			name:        "in-order packets",
+			arrivals:    []nackArrival{{10, 0}, {11, 5 * ms}, {12, 10 * ms}},
+			wantMissing: map[uint16]int{},
+		},
+		{
+			name:        "gap is requested right away",
+			arrivals:    []nackArrival{{10, 0}, {13, 5 * ms}},
+			wantMissing: map[uint16]int{11: 1, 12: 1},
+			wantLost:    2,
+		},
+		{
+			name:        "no retry before the interval",
+			arrivals:    []nackArrival{{10, 0}, {12, 0}, {13, 99 * ms}},
+			wantMissing: map[uint16]int{11: 1},
+			wantLost:    1,
+		},
+		{
+			name:        "retried every interval",
+			arrivals:    []nackArrival{{10, 0}, {12, 0}, {13, 100 * ms}, {14, 150 * ms}, {15, 200 * ms}},
+			wantMissing: map[uint16]int{11: 3},
+			wantLost:    1,
+		},
+		{
+			name:        "given up after the last attempt",
+			arrivals:    []nackArrival{{10, 0}, {12, 0}, {13, 100 * ms}, {14, 200 * ms}, {15, 299 * ms}, {16, 300 * ms}},
+			wantMissing: map[uint16]int{},
+			wantLost:    1,
+			wantExpired: 1,
+		},
+		{
+			name: "given up after the age limit",
+			// 两次请求之后（第二次在 100ms），下一个包在 1.1s 才到达：超过 nackMaxAge，不再发第三次
+			arrivals:    []nackArrival{{10, 0}, {12, 0}, {13, 100 * ms}, {14, 1100 * ms}},
+			wantMissing: map[uint16]int{},
+			wantLost:    1,
+			wantExpired: 1,
+		},
+		{
+			name:          "retransmission recovers a packet",
+			arrivals:      []nackArrival{{10, 0}, {13, 0}, {11, 30 * ms}},
+			wantMissing:   map[uint16]int{12: 1},
+			wantLost:      2,
+			wantRecovered: 1,
+		},
+		{
+			name:        "late packet that was never requested is ignored",
+			arrivals:    []nackArrival{{10, 0}, {11, 0}, {10, 5 * ms}},
+			wantMissing: map[uint16]int{},
+		},
+		{
+			name:        "gap at the limit is requested",
+			arrivals:    []nackArrival{{10, 0}, {10 + nackMaxGap + 1, 0}},
+			wantMissing: nackRange(11, nackMaxGap),
+			wantLost:    nackMaxGap,
+		},
+		{
+			name:        "jump over the limit is a reset",
+			arrivals:    []nackArrival{{10, 0}, {12, 0}, {12 + nackMaxGap + 2, 10 * ms}, {12 + nackMaxGap + 3, 20 * ms}},
+			wantMissing: map[uint16]int{},
+			wantLost:    1,
+			wantResets:  1,
+		},
+		{
+			name:          "gap across the wraparound",
+			arrivals:      []nackArrival{{65533, 0}, {1, 5 * ms}, {65535, 10 * ms}},
+			wantMissing:   map[uint16]int{65534: 1, 0: 1},
+			wantLost:      3,
+			wantRecovered: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			n := NewNackSender(true) // 没有 Attach：pc 为 nil，只跟踪缺口，不发送
+			for _, a := range tt.arrivals {
+				n.OnPacket(&rtp.Packet{Header: rtp.Header{SSRC: 1234, SequenceNumber: a.seq}}, start.Add(a.at))
+			}
+
+			missing := make(map[uint16]int, len(n.missing))
+			for seq, m := range n.missing {
+				missing[seq] = m.attempts
+			}
+			if !maps.Equal(missing, tt.wantMissing) {
+				t.Errorf("missing %v, want %v", missing, tt.wantMissing)
+			}
+			if n.lost != tt.wantLost || n.recovered != tt.wantRecovered || n.expired != tt.wantExpired || n.resets != tt.wantResets {
+				t.Errorf("lost %d, recovered %d, expired %d, resets %d; want %d, %d, %d, %d",
+					n.lost, n.recovered, n.expired, n.resets, tt.wantLost, tt.wantRecovered, tt.wantExpired, tt.wantResets)
+			}
+			if n.nacks != 0 || n.requests != 0 {
+				t.Errorf("%d NACKs (%d requests) counted without a peer connection", n.nacks, n.requests)
+			}
+		})
+	}
+}
+
+// nackRange 返回从 first 开始的 count 个序列号，每个已请求一次
+func nackRange(first uint16, count int) map[uint16]int {
+	m := make(map[uint16]int, count)
+	for i := 0; i < count; i++ {
+		m[first+uint16(i)] = 1
+	}
+	return m
+}
+
+func TestNackSenderSSRCChange(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	n := NewNackSender(true)
+	n.OnPacket(&rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 10}}, start)
+	n.OnPacket(&rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 13}}, start)
+	// 新的 SSRC 重新开始跟踪：旧流的缺口不再请求，新流的第一个包不算缺口
+	n.OnPacket(&rtp.Packet{Header: rtp.Header{SSRC: 2, SequenceNumber: 500}}, start.Add(time.Millisecond))
+	if len(n.missing) != 0 || n.highest != 500 || n.ssrc != 2 {
+		t.Errorf("after SSRC change: %d missing, highest %d, SSRC %d; want 0, 500, 2", len(n.missing), n.highest, n.ssrc)
+	}
+}
+
+func TestNackSenderDisabled(t *testing.T) {
+	n := NewNackSender(false)
+	n.OnPacket(&rtp.Packet{Header: rtp.Header{SequenceNumber: 10}}, time.Now())
+	n.OnPacket(&rtp.Packet{Header: rtp.Header{SequenceNumber: 20}}, time.Now())
+	if len(n.missing) != 0 || n.lost != 0 {
+		t.Errorf("disabled sender tracked %d missing, %d lost", len(n.missing), n.lost)
+	}
+	var nilSender *NackSender
+	nilSender.OnPacket(&rtp.Packet{}, time.Now())
+}
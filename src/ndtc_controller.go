@@ -6,6 +6,9 @@
 // 说明：
 //   - 负责将 FDACE 的容量估计 A_n 转换为每帧的目标大小 F_n 和发送持续时间（pacing）。
 //   - 采用简化版 AIMD 逻辑：在无丢包时缓慢增加容量估计，在出现丢包时乘性减小。
+//   - 实现 RateController：UpdateStats 用每帧的观测更新 FDACE 窗口，再把容量估计交给控制器。
+//     -rtcp-bwe 时样本来自 TWCC 反馈的发送 / 接收持续时间（见 frame_dispersion.go），拟合可以得到容量；
+//     没有接收侧样本时用发送耗时近似（S = R），只能得到发送速率的均值。
 
 package main
 
@@ -36,12 +39,22 @@ type NdtcController struct {
 	capacityBps float64
 	// 最近一次外部容量估计（供调试）
 	lastEstimatedBps float64
+	// 基于接收端 RTCP 反馈的带宽估计（-rtcp-bwe），0 表示没有
+	networkBps float64
+
+	// fdace 为 nil 时 UpdateStats 不更新容量估计
+	fdace *FdaceWindow
+	// receiveTiming 表示已经收到过接收侧样本，此后不再加入发送侧的近似样本（只在 UpdateStats 中访问）
+	receiveTiming bool
 }
 
-// NewNdtcController 创建一个具有默认参数的控制器。
-func NewNdtcController() *NdtcController {
-	// 默认按 30fps 配置
-	frame := time.Second / 30
+// NewNdtcController 创建一个具有默认参数的控制器，frameInterval 为源视频的帧间隔（<= 0 时按缺省帧率），
+// fdace 是 UpdateStats 更新的 FDACE 窗口。
+func NewNdtcController(frameInterval time.Duration, fdace *FdaceWindow) *NdtcController {
+	frame := frameInterval
+	if frame <= 0 {
+		frame = time.Second / defaultFrameRateFPS
+	}
 	return &NdtcController{
 		cfg: NdtcConfig{
 			TFrame: frame,
@@ -52,7 +65,16 @@ func NewNdtcController() *NdtcController {
 			MdRatio: 0.5,
 		},
 		capacityBps: 0,
+		fdace:       fdace,
+	}
+}
+
+// frameInterval 返回配置的帧周期（未配置时按缺省帧率），调用方需持有 c.mu
+func (c *NdtcController) frameInterval() time.Duration {
+	if c.cfg.TFrame > 0 {
+		return c.cfg.TFrame
 	}
+	return time.Second / defaultFrameRateFPS
 }
 
 // SetConfig 用于覆盖默认配置。
@@ -107,6 +129,90 @@ func (c *NdtcController) OnNoLossPeriod() {
 	c.capacityBps *= (1 + c.cfg.AiStep)
 }
 
+// OnSendPressure 在本地发送缓冲区受压时调用（见 send_pressure.go），把容量估计限制在缓冲区的排空速率以内。
+func (c *NdtcController) OnSendPressure(drainBps float64) {
+	if drainBps <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacityBps <= 0 || drainBps < c.capacityBps {
+		c.capacityBps = drainBps
+	}
+}
+
+// UpdateStats 实现 RateController：用接收侧样本（没有时用本帧的发送时长）构造 FDACE 样本并更新容量估计，
+// 本地发送缓冲区受压时把容量限制在排空速率以内。
+func (c *NdtcController) UpdateStats(obs FrameObservation) {
+	if c.fdace != nil {
+		switch {
+		case len(obs.ReceiveSamples) > 0:
+			if !c.receiveTiming {
+				// 第一次收到接收侧样本：丢弃窗口中 S = R 的近似样本，避免与真实样本混合拟合
+				c.receiveTiming = true
+				c.fdace.Reset()
+			}
+			// 样本属于更早的帧（反馈有一个往返的延迟），FrameID 记录得到样本时的帧
+			for _, s := range obs.ReceiveSamples {
+				c.fdace.UpdateSample(FdaceSample{
+					FrameID: obs.FrameID,
+					S:       s.Send.Seconds(),
+					R:       s.Receive.Seconds(),
+					L:       float64(s.Bits),
+				})
+			}
+		case !c.receiveTiming:
+			// 没有接收侧样本，用发送持续时间近似接收持续时间（S≈R）。
+			// 仍使用编码加写入的总耗时：WriteSample 只是写入 UDP 发送缓冲区，单独的发送耗时通常只有几微秒，
+			// 直接当作 S / R 会使容量估计失真。
+			sendDur := obs.SendEnd.Sub(obs.SendStart).Seconds()
+			c.fdace.UpdateSample(FdaceSample{
+				FrameID: obs.FrameID,
+				S:       sendDur,
+				R:       sendDur,
+				L:       float64(obs.SentBits),
+			})
+		}
+		if capBps, ok := c.fdace.EstimateCapacity(); ok {
+			c.OnCapacityEstimate(capBps)
+		}
+	}
+	if obs.SendBlocked {
+		c.OnSendPressure(obs.DrainBps)
+	}
+}
+
+// OnNetworkEstimate 设置基于接收端 RTCP 反馈的带宽估计（见 bitrate_estimator.go）。
+// bps > 0 时 NextFrameBudget 使用的容量不超过它，FDACE 还没有估计时直接使用它；<= 0 时不起作用。
+func (c *NdtcController) OnNetworkEstimate(bps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networkBps = bps
+}
+
+// CapacityEstimate 返回当前平滑后的容量估计（bit/s），还没有估计时为 0。
+func (c *NdtcController) CapacityEstimate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacityBps
+}
+
+// State 返回控制器状态的快照，窗口统计取自 FDACE 窗口（发送缓冲区压力由调用方补充）。
+func (c *NdtcController) State() ControllerState {
+	budgetBits, _ := c.NextFrameBudget()
+	c.mu.Lock()
+	s := ControllerState{
+		CapacityBps: c.capacityBps,
+		EstimateBps: c.lastEstimatedBps,
+		BudgetBits:  budgetBits,
+	}
+	c.mu.Unlock()
+	if c.fdace != nil {
+		s.WindowFrames, s.WindowMeanBits, s.WindowVarBits = c.fdace.FrameSizeStats()
+	}
+	return s
+}
+
 // NextFrameBudget 返回下一帧的目标大小（比特）和发送持续时间（包含轻微抖动）。
 // 若当前容量估计不足，则使用一个保守的缺省值。
 func (c *NdtcController) NextFrameBudget() (frameBits int, pacingDuration time.Duration) {
@@ -114,6 +220,9 @@ func (c *NdtcController) NextFrameBudget() (frameBits int, pacingDuration time.D
 	defer c.mu.Unlock()
 
 	A := c.capacityBps
+	if c.networkBps > 0 && (A <= 0 || c.networkBps < A) {
+		A = c.networkBps
+	}
 	if A <= 0 {
 		// fallback：假设 5Mbps
 		A = 5e6
@@ -122,7 +231,7 @@ func (c *NdtcController) NextFrameBudget() (frameBits int, pacingDuration time.D
 	// F_n = T_R * A_n
 	Trecv := c.cfg.TRecv
 	if Trecv <= 0 {
-		Trecv = time.Second / 30 * 8 / 10
+		Trecv = c.frameInterval() * 8 / 10
 	}
 	frameBits = int(Trecv.Seconds() * A)
 	if frameBits < 1 {
@@ -132,7 +241,7 @@ func (c *NdtcController) NextFrameBudget() (frameBits int, pacingDuration time.D
 	// pacing 以 T_S 为中心做 ±10% 抖动
 	Tsend := c.cfg.TSend
 	if Tsend <= 0 {
-		Tsend = time.Second / 30 * 7 / 10
+		Tsend = c.frameInterval() * 7 / 10
 	}
 	jitterFactor := 0.1
 	j := 1 + jitterFactor*(rand.Float64()*2-1) // [1-0.1, 1+0.1]
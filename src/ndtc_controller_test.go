@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// sendOnlyObservation 是没有接收侧样本的一帧：bits 比特在 sendDur 内发出
+func sendOnlyObservation(frameID, bits int, sendDur time.Duration) FrameObservation {
+	start := time.Now()
+	return FrameObservation{FrameID: frameID, SentBits: bits, SendStart: start, SendEnd: start.Add(sendDur)}
+}
+
+func TestNdtcControllerUsesReceiveSamples(t *testing.T) {
+	fdace := NewFdaceWindow(120)
+	ctrl := NewNdtcController(time.Second/30, fdace)
+
+	// 没有反馈时用发送耗时近似：只能得到发送速率（100 kbit / 20 ms = 5 Mbit/s）
+	for id := 1; id <= 5; id++ {
+		ctrl.UpdateStats(sendOnlyObservation(id, 100e3, 20*time.Millisecond))
+	}
+	if got := ctrl.State().EstimateBps; !closeTo(got, 5e6, 1e-6) {
+		t.Fatalf("send-side estimate = %g, want 5e6", got)
+	}
+
+	// TWCC 反馈给出 10 Mbit/s 瓶颈、3 Mbit/s 交叉流量下的发送 / 接收时长：拟合得到可用带宽 7 Mbit/s
+	var samples []FrameDispersionSample
+	for _, s := range bottleneckSamples(10e6, 3e6, 40) {
+		samples = append(samples, FrameDispersionSample{
+			Send:    time.Duration(s.S * float64(time.Second)),
+			Receive: time.Duration(s.R * float64(time.Second)),
+			Bits:    int(s.L),
+		})
+	}
+	obs := sendOnlyObservation(6, 100e3, 20*time.Millisecond)
+	obs.ReceiveSamples = samples
+	ctrl.UpdateStats(obs)
+	if got := ctrl.State().EstimateBps; !closeTo(got, 7e6, 1e-3) {
+		t.Errorf("estimate from receive samples = %g, want 7e6", got)
+	}
+	// 之前的发送侧样本被丢弃
+	if count, _, _ := fdace.FrameSizeStats(); count != len(samples) {
+		t.Errorf("window holds %d samples, want the %d receive samples", count, len(samples))
+	}
+
+	// 之后没有新反馈的帧不再加入 S = R 的近似样本
+	ctrl.UpdateStats(sendOnlyObservation(7, 100e3, 20*time.Millisecond))
+	if count, _, _ := fdace.FrameSizeStats(); count != len(samples) {
+		t.Errorf("send-only frame added a sample after receive timing started (%d samples)", count)
+	}
+	if got := ctrl.State().EstimateBps; !closeTo(got, 7e6, 1e-3) {
+		t.Errorf("estimate after a send-only frame = %g, want 7e6", got)
+	}
+}
@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// padding.go - 码率低于下限时发送 RTP padding 包（-min-send-rate）
+//
+// 说明：
+//   - 静态场景下编码器输出很小，带宽估计（TWCC/GCC）因样本不足而停滞甚至持续下调
+//   - 每个统计间隔内媒体码率低于下限时，用只含 padding 的 RTP 包补足差额
+//   - padding 包的负载为空，接收端不会写入 H.264 文件，也不计入 frame_metadata；
+//     padding 字节单独记录到 padding.csv，不污染有效码率（goodput）统计
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+const (
+	// paddingInterval 是检查码率并补 padding 的间隔
+	paddingInterval = 100 * time.Millisecond
+	// paddingPacketBytes 是单个 padding 包的 RTP 大小：12 字节头 + 255 字节 padding（Pion 的固定值）
+	paddingPacketBytes = 12 + 255
+	// maxPaddingPacketsPerInterval 限制单个间隔内的 padding 包数量，避免下限设置过高时瞬间突发
+	maxPaddingPacketsPerInterval = 100
+)
+
+// paddingGenerator 是可以在媒体流中插入 padding 包的轨道，
+// *webrtc.TrackLocalStaticSample 与 *ptsH264Track 都实现了它。
+type paddingGenerator interface {
+	GeneratePadding(count uint32) error
+}
+
+// MinRatePadder 包装 h264SampleWriter：统计媒体字节数，并在后台按间隔补足 padding
+type MinRatePadder struct {
+	track    h264SampleWriter
+	padder   paddingGenerator
+	minBytes int // 每个间隔内的最少字节数
+
+	// mu 串行化 WriteSample 与 GeneratePadding（二者共用同一个 RTP 分片器）
+	mu             sync.Mutex
+	intervalBytes  int
+	mediaBytes     int64
+	paddingPackets int64
+	paddingBytes   int64
+	stopped        bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewMinRatePadder 创建并启动一个 padder。minRateKbps 为码率下限；csvPath 为空时不写 CSV。
+func NewMinRatePadder(track h264SampleWriter, padder paddingGenerator, minRateKbps int, csvPath string) (*MinRatePadder, error) {
+	if minRateKbps <= 0 {
+		return nil, fmt.Errorf("minRateKbps must be positive, got %d", minRateKbps)
+	}
+
+	p := &MinRatePadder{
+		track:    track,
+		padder:   padder,
+		minBytes: int(int64(minRateKbps) * 1000 / 8 * int64(paddingInterval) / int64(time.Second)),
+		done:     make(chan struct{}),
+	}
+
+	if csvPath != "" {
+		if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create padding directory: %w", err)
+		}
+		f, err := os.Create(csvPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create padding csv: %w", err)
+		}
+		w := csv.NewWriter(f)
+		header := []string{
+			"unix_ms",
+			"media_bytes",     // 该间隔内的媒体（编码帧）字节数
+			"padding_packets", // 该间隔内补发的 padding 包数
+			"padding_bytes",   // padding 包的 RTP 字节数（含 RTP 头）
+		}
+		if err = w.Write(header); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write padding header: %w", err)
+		}
+		w.Flush()
+		p.writer = w
+		p.file = f
+	}
+
+	p.wg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+// WriteSample 发送一帧并计入当前间隔的媒体字节数
+func (p *MinRatePadder) WriteSample(sample media.Sample) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.track.WriteSample(sample); err != nil {
+		return err
+	}
+	p.intervalBytes += len(sample.Data)
+	p.mediaBytes += int64(len(sample.Data))
+	return nil
+}
+
+// run 每个间隔检查一次媒体字节数，不足部分用 padding 补齐
+func (p *MinRatePadder) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(paddingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		media := p.intervalBytes
+		p.intervalBytes = 0
+
+		packets := 0
+		if deficit := p.minBytes - media; deficit > 0 && !p.stopped {
+			packets = (deficit + paddingPacketBytes - 1) / paddingPacketBytes
+			if packets > maxPaddingPacketsPerInterval {
+				packets = maxPaddingPacketsPerInterval
+			}
+			if err := p.padder.GeneratePadding(uint32(packets)); err != nil {
+				// 通常是连接已关闭，停止补 padding，不影响媒体发送
+				fmt.Fprintf(os.Stderr, "Error sending padding, disabling padding: %v\n", err)
+				p.stopped = true
+				packets = 0
+			}
+			p.paddingPackets += int64(packets)
+			p.paddingBytes += int64(packets * paddingPacketBytes)
+		}
+		p.mu.Unlock()
+
+		if packets > 0 {
+			p.record(media, packets)
+		}
+	}
+}
+
+// record 写入一行 padding 记录
+func (p *MinRatePadder) record(media, packets int) {
+	if p.writer == nil {
+		return
+	}
+	record := []string{
+		fmt.Sprintf("%d", time.Now().UnixMilli()),
+		fmt.Sprintf("%d", media),
+		fmt.Sprintf("%d", packets),
+		fmt.Sprintf("%d", packets*paddingPacketBytes),
+	}
+	if err := p.writer.Write(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing padding CSV: %v\n", err)
+		return
+	}
+	p.writer.Flush()
+}
+
+// Stats 返回累计的媒体字节数、padding 包数与 padding 字节数
+func (p *MinRatePadder) Stats() (mediaBytes, paddingPackets, paddingBytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mediaBytes, p.paddingPackets, p.paddingBytes
+}
+
+// Close 停止补 padding 并关闭 CSV（不关闭被包装的 writer）
+func (p *MinRatePadder) Close() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+	p.wg.Wait()
+
+	if p.writer != nil {
+		p.writer.Flush()
+	}
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing padding CSV file: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// param_sets.go - SPS/PPS 一致性检查（-verify-param-sets）
+//
+// 说明：
+//   - NDTC / BurstRTC 按预算重建编码器，Salsify 在不同 QP 的候选编码器之间切换，码流中途的 SPS/PPS 会变化；
+//     解码器如果还用旧的参数集解码新的 IDR，会花屏或直接报错
+//   - server：-verify-param-sets 时包装发送轨道，每次发出与上一次不同的 SPS/PPS 时打印一行
+//   - client：写文件时始终缓存最新的 SPS/PPS；参数集变化后的第一个 IDR 所在的 access unit 里如果没有带上它们
+//     （例如参数集在更早的包里、或包含它们的包丢失），在 IDR 之前补写缓存的参数集，保证文件从该 IDR 起可以解码。
+//     -verify-param-sets 时同样打印每次变化
+//   - 补写的参数集计入写入字节数，但不计入 -frame-hash（对端发送的数据里没有它们）
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// verifyParamSets 由 -verify-param-sets 设置，开启后打印每次 SPS/PPS 变化
+var verifyParamSets bool
+
+// h264ParamSets 缓存最近的 SPS/PPS 并检测变化，只能在单个协程中使用
+type h264ParamSets struct {
+	prefix string
+
+	sps, pps []byte
+	changed  bool // 参数集变化后还没有 IDR 跟随
+
+	// 当前 access unit 中是否已经有 SPS / PPS
+	auSPS, auPPS bool
+
+	spsChanges int
+	ppsChanges int
+	inserted   int
+}
+
+// newH264ParamSets 创建缓存，prefix 用于日志
+func newH264ParamSets(prefix string) *h264ParamSets {
+	return &h264ParamSets{prefix: prefix}
+}
+
+// Observe 处理一个 NAL 单元，SPS/PPS 与缓存不同时更新缓存
+func (c *h264ParamSets) Observe(nal []byte) {
+	if len(nal) == 0 {
+		return
+	}
+	switch nal[0] & 0x1F {
+	case 7:
+		c.auSPS = true
+		if c.update(&c.sps, nal, "SPS") {
+			c.spsChanges++
+		}
+	case 8:
+		c.auPPS = true
+		if c.update(&c.pps, nal, "PPS") {
+			c.ppsChanges++
+		}
+	}
+}
+
+// update 替换缓存的参数集，返回是否是一次变化（第一次收到不算）
+func (c *h264ParamSets) update(cached *[]byte, nal []byte, name string) bool {
+	if bytes.Equal(*cached, nal) {
+		return false
+	}
+	first := *cached == nil
+	// 每次变化都复制到新的切片：BeforeIDR 返回的参数集在之后的变化中不会被改写
+	*cached = append([]byte(nil), nal...)
+	if first {
+		if verifyParamSets {
+			fmt.Fprintf(os.Stderr, "%s Initial %s (%d bytes%s)\n", c.prefix, name, len(nal), spsSummary(nal))
+		}
+		return false
+	}
+	c.changed = true
+	if verifyParamSets {
+		fmt.Fprintf(os.Stderr, "%s %s changed mid-stream (%d bytes%s)\n", c.prefix, name, len(nal), spsSummary(nal))
+	}
+	return true
+}
+
+// PendingBeforeIDR 返回 BeforeIDR 将要补写的参数集，但不改变状态（写入前检查 -max-size 用）
+func (c *h264ParamSets) PendingBeforeIDR() [][]byte {
+	if !c.changed {
+		return nil
+	}
+	var missing [][]byte
+	if !c.auSPS && c.sps != nil {
+		missing = append(missing, c.sps)
+	}
+	if !c.auPPS && c.pps != nil {
+		missing = append(missing, c.pps)
+	}
+	return missing
+}
+
+// BeforeIDR 在写入 IDR 前调用，返回需要补写在它前面的参数集（通常为空）
+func (c *h264ParamSets) BeforeIDR() [][]byte {
+	missing := c.PendingBeforeIDR()
+	if !c.changed {
+		return nil
+	}
+	c.changed = false
+	c.auSPS = c.auSPS || c.sps != nil
+	c.auPPS = c.auPPS || c.pps != nil
+	if len(missing) > 0 {
+		c.inserted++
+		if verifyParamSets {
+			fmt.Fprintf(os.Stderr, "%s IDR after a parameter set change arrived without them, re-inserting %d cached parameter sets\n", c.prefix, len(missing))
+		}
+	}
+	return missing
+}
+
+// EndAccessUnit 在一个 access unit 结束（RTP marker 位）时调用
+func (c *h264ParamSets) EndAccessUnit() {
+	c.auSPS, c.auPPS = false, false
+}
+
+// Report 打印参数集变化的汇总（没有变化且未开启 -verify-param-sets 时不打印）
+func (c *h264ParamSets) Report() {
+	if !verifyParamSets && c.spsChanges == 0 && c.ppsChanges == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s Parameter sets: %d SPS changes, %d PPS changes", c.prefix, c.spsChanges, c.ppsChanges)
+	if c.inserted > 0 {
+		fmt.Fprintf(os.Stderr, ", re-inserted before %d IDRs", c.inserted)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// spsSummary 返回 SPS 的 profile / level 描述，其它 NAL 返回空串
+func spsSummary(nal []byte) string {
+	if len(nal) < 4 || nal[0]&0x1F != 7 {
+		return ""
+	}
+	return fmt.Sprintf(", profile_idc=%d level_idc=%d", nal[1], nal[3])
+}
+
+// ParamSetTrack 包装 h264SampleWriter，在 -verify-param-sets 时检查成功发出的 SPS/PPS；未开启时直接透传
+type ParamSetTrack struct {
+	track h264SampleWriter
+	sets  *h264ParamSets
+}
+
+// NewParamSetTrack 包装 track，prefix 用于日志
+func NewParamSetTrack(track h264SampleWriter, prefix string) *ParamSetTrack {
+	return &ParamSetTrack{track: track, sets: newH264ParamSets(prefix)}
+}
+
+// WriteSample 写入底层轨道，成功后检查其中的参数集
+func (t *ParamSetTrack) WriteSample(sample media.Sample) error {
+	if err := t.track.WriteSample(sample); err != nil {
+		return err
+	}
+	if verifyParamSets {
+		forEachAnnexBNAL(sample.Data, t.sets.Observe)
+	}
+	return nil
+}
+
+// Report 打印汇总
+func (t *ParamSetTrack) Report() {
+	t.sets.Report()
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// 测试用的 NAL 单元：最后一个字节是编码配置（分辨率）的编号，内容不是合法的 H.264 语法，只需要随配置不同
+func spsFor(config byte) []byte { return []byte{0x67, 0x42, 0xc0, 0x1e, config} }
+func ppsFor(config byte) []byte { return []byte{0x68, 0xce, 0x3c, config} }
+func idrFor(config byte) []byte { return []byte{0x65, 0x88, 0x84, config} }
+func pFor(config byte) []byte   { return []byte{0x41, 0x9a, 0x02, config} }
+
+// writeAccessUnits 按 h264StreamSink.writeNALUnit 的调用顺序使用 h264ParamSets（IDR 前补写参数集，再记录本 NAL），
+// 每个 access unit 结束时调用 EndAccessUnit，返回写入文件的 NAL 序列
+func writeAccessUnits(t *testing.T, sets *h264ParamSets, accessUnits [][][]byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for _, au := range accessUnits {
+		for _, nal := range au {
+			if nal[0]&0x1F == 5 {
+				pending := sets.PendingBeforeIDR()
+				inserted := sets.BeforeIDR()
+				if fmt.Sprint(pending) != fmt.Sprint(inserted) {
+					t.Fatalf("PendingBeforeIDR = %x, BeforeIDR = %x", pending, inserted)
+				}
+				out = append(out, inserted...)
+			}
+			sets.Observe(nal)
+			out = append(out, nal)
+		}
+		sets.EndAccessUnit()
+	}
+	return out
+}
+
+// checkDecodable 模拟解码器：每个 slice 必须使用与自己同一配置的 SPS/PPS；配置变化后的第一个 IDR
+// 前面（上一个 slice 之后）必须带有新的 SPS 与 PPS，文件从这个 IDR 开始也可以解码
+func checkDecodable(t *testing.T, out [][]byte) {
+	t.Helper()
+	var sps, pps, lastIDR = -1, -1, -1
+	spsSinceSlice, ppsSinceSlice := false, false
+	for i, nal := range out {
+		config := int(nal[len(nal)-1])
+		switch nal[0] & 0x1F {
+		case 7:
+			sps, spsSinceSlice = config, true
+		case 8:
+			pps, ppsSinceSlice = config, true
+		case 5, 1:
+			if sps != config || pps != config {
+				t.Fatalf("NAL %d (%x) of configuration %d decoded with SPS %d / PPS %d", i, nal, config, sps, pps)
+			}
+			if nal[0]&0x1F == 5 {
+				if config != lastIDR && (!spsSinceSlice || !ppsSinceSlice) {
+					t.Fatalf("IDR %d of the new configuration %d is not preceded by its parameter sets", i, config)
+				}
+				lastIDR = config
+			}
+			spsSinceSlice, ppsSinceSlice = false, false
+		}
+	}
+}
+
+func TestParamSetsResolutionChange(t *testing.T) {
+	tests := []struct {
+		name         string
+		accessUnits  [][][]byte
+		wantInserted [][]byte // 补写的参数集（按顺序）
+		wantChanges  int
+	}{
+		{
+			name: "parameter sets in the IDR access unit",
+			accessUnits: [][][]byte{
+				{spsFor(0), ppsFor(0), idrFor(0)}, {pFor(0)},
+				{spsFor(1), ppsFor(1), idrFor(1)}, {pFor(1)},
+			},
+			wantChanges: 1,
+		},
+		{
+			name: "parameter sets in an earlier access unit",
+			accessUnits: [][][]byte{
+				{spsFor(0), ppsFor(0), idrFor(0)}, {pFor(0)},
+				{spsFor(1), ppsFor(1)}, {idrFor(1)}, {pFor(1)},
+			},
+			wantInserted: [][]byte{spsFor(1), ppsFor(1)},
+			wantChanges:  1,
+		},
+		{
+			name: "only the SPS in the IDR access unit",
+			accessUnits: [][][]byte{
+				{spsFor(0), ppsFor(0), idrFor(0)}, {pFor(0)},
+				{ppsFor(1)}, {spsFor(1), idrFor(1)}, {pFor(1)},
+			},
+			wantInserted: [][]byte{ppsFor(1)},
+			wantChanges:  1,
+		},
+		{
+			name: "two changes",
+			accessUnits: [][][]byte{
+				{spsFor(0), ppsFor(0), idrFor(0)}, {pFor(0)},
+				{spsFor(1), ppsFor(1)}, {idrFor(1)}, {pFor(1)},
+				{spsFor(2), ppsFor(2)}, {idrFor(2)}, {pFor(2)},
+			},
+			wantInserted: [][]byte{spsFor(1), ppsFor(1), spsFor(2), ppsFor(2)},
+			wantChanges:  2,
+		},
+		{
+			name: "periodic IDR without parameter sets",
+			accessUnits: [][][]byte{
+				{spsFor(0), ppsFor(0), idrFor(0)}, {pFor(0)}, {idrFor(0)}, {pFor(0)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets := newH264ParamSets("[test]")
+			out := writeAccessUnits(t, sets, tt.accessUnits)
+			checkDecodable(t, out)
+
+			// 输出 = 输入加上补写的参数集
+			var in [][]byte
+			for _, au := range tt.accessUnits {
+				in = append(in, au...)
+			}
+			if len(out) != len(in)+len(tt.wantInserted) {
+				t.Fatalf("wrote %d NAL units %x, want %d input + %d re-inserted", len(out), out, len(in), len(tt.wantInserted))
+			}
+			var inserted [][]byte
+			for i, j := 0, 0; i < len(out); i++ {
+				if j < len(in) && bytes.Equal(out[i], in[j]) {
+					j++
+					continue
+				}
+				inserted = append(inserted, out[i])
+			}
+			if fmt.Sprint(inserted) != fmt.Sprint(tt.wantInserted) {
+				t.Errorf("re-inserted %x, want %x", inserted, tt.wantInserted)
+			}
+			if sets.spsChanges != tt.wantChanges || sets.ppsChanges != tt.wantChanges {
+				t.Errorf("SPS changes = %d, PPS changes = %d, want %d", sets.spsChanges, sets.ppsChanges, tt.wantChanges)
+			}
+		})
+	}
+}
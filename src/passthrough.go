@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// passthrough.go - 源文件已是 H.264 时直接转发（跳过解码/缩放/重编码）
+//
+// 说明：
+//   - 服务器不做分辨率缩放，只要源是接收端可解码的 H.264（8bit 4:2:0，Baseline/Main/High），
+//     重编码只会浪费 CPU 并引入二次压缩损失
+//   - MP4/MKV 中的 H.264 是 AVCC（长度前缀）格式，SPS/PPS 在 extradata 中；
+//     经 h264_mp4toannexb 转为 Annex-B 后才能交给 RTP 分片器（本身就是 Annex-B 的源会原样通过）
+//   - RTP 时间戳取自源 packet 的 PTS，因此源中的 B 帧也能正确表示（需配合 ptsH264Track）
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/asticode/go-astiav"
+)
+
+// passthroughProfiles 是接收端（Pion 默认注册的 H.264 profile）可以解码的 profile
+var passthroughProfiles = []astiav.Profile{
+	astiav.ProfileH264Baseline,
+	astiav.ProfileH264ConstrainedBaseline,
+	astiav.ProfileH264Main,
+	astiav.ProfileH264High,
+}
+
+// passthroughPixelFormats 是与重编码输出（yuv420p）一致的像素格式
+var passthroughPixelFormats = []astiav.PixelFormat{
+	astiav.PixelFormatYuv420P,
+	astiav.PixelFormatYuvj420P,
+}
+
+// checkPassthrough 判断视频流能否直接转发，不能时返回原因
+func checkPassthrough(stream *astiav.Stream) (bool, string) {
+	params := stream.CodecParameters()
+
+	if params.CodecID() != astiav.CodecIDH264 {
+		return false, fmt.Sprintf("source codec is %s, not H.264", params.CodecID())
+	}
+
+	if !slices.Contains(passthroughProfiles, params.Profile()) {
+		return false, fmt.Sprintf("unsupported H.264 profile %d", params.Profile())
+	}
+
+	// 直接转发无法改变码流的 profile：-compat 时只接受 constrained baseline，否则不能超过 answer 中的最高 profile
+	if h264Compat == H264CompatConstrainedBaseline && params.Profile() != astiav.ProfileH264ConstrainedBaseline {
+		return false, fmt.Sprintf("-compat constrained-baseline but source H.264 profile is %d", params.Profile())
+	}
+	if rank := sourceH264ProfileRank(params.Profile()); rank > peerH264ProfileRank {
+		return false, fmt.Sprintf("source H.264 profile is %s, answer only accepts up to %s", h264ProfileRankName(rank), h264ProfileRankName(peerH264ProfileRank))
+	}
+
+	if !slices.Contains(passthroughPixelFormats, params.PixelFormat()) {
+		return false, fmt.Sprintf("pixel format is %s, not 8-bit 4:2:0", params.PixelFormat())
+	}
+
+	if params.Width() <= 0 || params.Height() <= 0 {
+		return false, "unknown source resolution"
+	}
+
+	return true, ""
+}
+
+// passthroughSource 把源文件的 H.264 packet 转为 Annex-B 并计算 90kHz RTP 时间戳
+type passthroughSource struct {
+	bsf      *astiav.BitStreamFilterContext
+	pkt      *astiav.Packet
+	timeBase astiav.Rational
+
+	// 循环播放时 seek 回开头，PTS 会回退；通过累加偏移保持时间戳单调
+	firstPTS    int64
+	hasFirstPTS bool
+	offset      int64 // 流时间基下的累计偏移
+	maxEnd      int64 // 已转发内容的最大 (相对 PTS + duration)
+}
+
+// newPassthroughSource 为视频流创建 h264_mp4toannexb 过滤器
+func newPassthroughSource(stream *astiav.Stream) (*passthroughSource, error) {
+	filter := astiav.FindBitStreamFilterByName("h264_mp4toannexb")
+	if filter == nil {
+		return nil, errors.New("h264_mp4toannexb bitstream filter not found")
+	}
+
+	bsf, err := astiav.AllocBitStreamFilterContext(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate bitstream filter: %w", err)
+	}
+
+	if err = stream.CodecParameters().Copy(bsf.InputCodecParameters()); err != nil {
+		bsf.Free()
+		return nil, fmt.Errorf("failed to copy codec parameters: %w", err)
+	}
+	bsf.SetInputTimeBase(stream.TimeBase())
+
+	if err = bsf.Initialize(); err != nil {
+		bsf.Free()
+		return nil, fmt.Errorf("failed to initialize bitstream filter: %w", err)
+	}
+
+	return &passthroughSource{
+		bsf:      bsf,
+		pkt:      astiav.AllocPacket(),
+		timeBase: stream.TimeBase(),
+	}, nil
+}
+
+// Filter 将一个源 packet 送入过滤器，并把得到的每个 Annex-B access unit 交给 handle。
+// handle 返回错误时停止并原样返回该错误。
+func (p *passthroughSource) Filter(in *astiav.Packet, handle func(pkt *astiav.Packet) error) error {
+	if err := p.bsf.SendPacket(in); err != nil {
+		return fmt.Errorf("failed to send packet to bitstream filter: %w", err)
+	}
+
+	for {
+		p.pkt.Unref()
+		if err := p.bsf.ReceivePacket(p.pkt); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				return nil
+			}
+			return fmt.Errorf("failed to receive packet from bitstream filter: %w", err)
+		}
+		if err := handle(p.pkt); err != nil {
+			return err
+		}
+	}
+}
+
+// Timestamp 返回 packet 对应的 90kHz RTP 时间戳（相对第一帧，循环播放时保持递增）
+func (p *passthroughSource) Timestamp(pkt *astiav.Packet) uint32 {
+	if !p.hasFirstPTS {
+		p.firstPTS = pkt.Pts()
+		p.hasFirstPTS = true
+	}
+
+	rel := pkt.Pts() - p.firstPTS + p.offset
+	if end := rel + pkt.Duration(); end > p.maxEnd {
+		p.maxEnd = end
+	}
+	return uint32(astiav.RescaleQ(rel, p.timeBase, astiav.NewRational(1, 90000)))
+}
+
+// OnLoop 在 seek 回开头后调用：之后的时间戳接在已发送内容之后
+func (p *passthroughSource) OnLoop() {
+	p.offset = p.maxEnd
+	p.hasFirstPTS = false
+}
+
+// Free 释放过滤器
+func (p *passthroughSource) Free() {
+	if p.pkt != nil {
+		p.pkt.Free()
+	}
+	if p.bsf != nil {
+		p.bsf.Free()
+	}
+}
@@ -0,0 +1,345 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// playout.go - 接收端的播放策略（client 的 -playout）与抖动缓冲深度记录
+//
+// 说明：
+//   - -playout 是 -jitter-buffer 的预设：low-latency 与 smooth 是固定的缺口等待时长，分别偏向低延迟和少丢帧；
+//     adaptive 从 playoutAdaptiveInitialDelay 开始，按到达间隔抖动（RFC 3550 的 J，只用原始包，不含 RTX 重传）调整等待时长
+//   - adaptive 的目标等待时长是 playoutJitterMultiplier 倍的 J，限制在 [playoutAdaptiveMinDelay, playoutAdaptiveMaxDelay]；
+//     目标变大时立即增大，变小时每 playoutShrinkInterval 最多收回差值的 1/playoutShrinkDivisor，避免抖动短暂下降就开始丢缺口；
+//     有包因等待超时而晚到丢弃时，说明当前等待不够，直接增大一半
+//   - -playout 与显式的 -jitter-buffer 不能同时使用
+//   - 有 -session-dir 且抖动缓冲开启时，每 playoutSampleInterval 把缓冲深度、当前等待时长、J 以及累计的晚到 / 放弃数写入 playout.csv，
+//     均值与最大值写入 metrics_summary，与同一份汇总中的延迟、卡顿率对照
+//   - 不依赖 FFmpeg，可以单独测试
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlayoutPolicy 是 -playout 的取值，零值表示未指定（只用 -jitter-buffer）
+type PlayoutPolicy string
+
+const (
+	PlayoutLowLatency PlayoutPolicy = "low-latency"
+	PlayoutSmooth     PlayoutPolicy = "smooth"
+	PlayoutAdaptive   PlayoutPolicy = "adaptive"
+)
+
+// 各策略的缺口等待时长
+const (
+	playoutLowLatencyDelay      = 20 * time.Millisecond
+	playoutSmoothDelay          = 200 * time.Millisecond
+	playoutAdaptiveInitialDelay = 50 * time.Millisecond
+	playoutAdaptiveMinDelay     = 10 * time.Millisecond
+	playoutAdaptiveMaxDelay     = 300 * time.Millisecond
+)
+
+// adaptive 的调整参数
+const (
+	playoutJitterMultiplier = 4
+	playoutShrinkInterval   = 100 * time.Millisecond
+	playoutShrinkDivisor    = 8
+	// 相邻两个原始包的到达间隔超过它时视为流中断（暂停、重连），重新开始估计 J
+	playoutJitterResetGap = time.Second
+)
+
+// playoutSampleInterval 是 playout.csv 的采样间隔
+const playoutSampleInterval = 100 * time.Millisecond
+
+// playoutPolicy 是 -playout 指定的策略
+var playoutPolicy PlayoutPolicy
+
+// String 实现 flag.Value
+func (p *PlayoutPolicy) String() string {
+	return string(*p)
+}
+
+// Set 实现 flag.Value
+func (p *PlayoutPolicy) Set(s string) error {
+	switch policy := PlayoutPolicy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case PlayoutLowLatency, PlayoutSmooth, PlayoutAdaptive:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("unknown playout policy %q (want low-latency, smooth or adaptive)", s)
+}
+
+// label 返回汇总与日志中的策略名：没有 -playout 时为 fixed（-jitter-buffer 指定的固定等待时长）
+func (p PlayoutPolicy) label() string {
+	if p == "" {
+		return "fixed"
+	}
+	return string(p)
+}
+
+// flagPassed 表示命令行上显式给出了名为 name 的 flag
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// applyPlayoutPolicy 把 -playout 映射到 jitterBufferDelay；jitterBufferSet 表示同时显式给出了 -jitter-buffer
+func applyPlayoutPolicy(jitterBufferSet bool) error {
+	if playoutPolicy == "" {
+		return nil
+	}
+	if jitterBufferSet {
+		return fmt.Errorf("-playout cannot be combined with -jitter-buffer")
+	}
+	switch playoutPolicy {
+	case PlayoutLowLatency:
+		jitterBufferDelay = playoutLowLatencyDelay
+	case PlayoutSmooth:
+		jitterBufferDelay = playoutSmoothDelay
+	case PlayoutAdaptive:
+		jitterBufferDelay = playoutAdaptiveInitialDelay
+	}
+	return nil
+}
+
+// PlayoutAdapter 按到达间隔抖动调整抖动缓冲的缺口等待时长（-playout adaptive）
+type PlayoutAdapter struct {
+	clockRate uint32
+	delay     time.Duration
+
+	jitter      float64 // RFC 3550 的到达间隔抖动 J，秒
+	lastTS      uint32
+	lastArrival time.Time
+	haveLast    bool
+	lastChange  time.Time
+
+	minDelay, maxDelay time.Duration // 实际用到的等待时长范围
+	grows, shrinks     int64
+}
+
+// NewPlayoutAdapter 创建从 initial 开始调整的 adapter；clockRate 为视频轨道的 RTP 时钟频率
+func NewPlayoutAdapter(clockRate uint32, initial time.Duration) *PlayoutAdapter {
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+	initial = min(max(initial, playoutAdaptiveMinDelay), playoutAdaptiveMaxDelay)
+	return &PlayoutAdapter{clockRate: clockRate, delay: initial, minDelay: initial, maxDelay: initial}
+}
+
+// Delay 返回当前的缺口等待时长
+func (a *PlayoutAdapter) Delay() time.Duration {
+	return a.delay
+}
+
+// Jitter 返回当前的到达间隔抖动估计
+func (a *PlayoutAdapter) Jitter() time.Duration {
+	return time.Duration(a.jitter * float64(time.Second))
+}
+
+// OnArrival 用一个原始（非 RTX）包的 RTP 时间戳与到达时间更新 J，并调整等待时长
+func (a *PlayoutAdapter) OnArrival(timestamp uint32, arrival time.Time) {
+	if !a.haveLast || arrival.Sub(a.lastArrival) > playoutJitterResetGap {
+		a.lastTS, a.lastArrival, a.haveLast = timestamp, arrival, true
+		a.lastChange = arrival
+		return
+	}
+	// D = 到达间隔 - 发送间隔（RTP 时间戳差按有符号数处理回绕与乱序）
+	d := arrival.Sub(a.lastArrival).Seconds() - float64(int32(timestamp-a.lastTS))/float64(a.clockRate)
+	a.jitter += (math.Abs(d) - a.jitter) / 16
+	a.lastTS, a.lastArrival = timestamp, arrival
+
+	target := min(max(time.Duration(playoutJitterMultiplier*a.jitter*float64(time.Second)), playoutAdaptiveMinDelay), playoutAdaptiveMaxDelay)
+	switch {
+	case target > a.delay:
+		a.setDelay(target, arrival)
+	case target < a.delay && arrival.Sub(a.lastChange) >= playoutShrinkInterval:
+		next := a.delay - (a.delay-target)/playoutShrinkDivisor
+		if next-target < time.Millisecond {
+			next = target
+		}
+		a.setDelay(next, arrival)
+	}
+}
+
+// OnLate 在有包因等待超时而晚到丢弃时增大等待时长
+func (a *PlayoutAdapter) OnLate(now time.Time) {
+	a.setDelay(min(a.delay+a.delay/2, playoutAdaptiveMaxDelay), now)
+}
+
+func (a *PlayoutAdapter) setDelay(delay time.Duration, now time.Time) {
+	if delay > a.delay {
+		a.grows++
+	} else if delay < a.delay {
+		a.shrinks++
+	}
+	a.delay, a.lastChange = delay, now
+	a.minDelay, a.maxDelay = min(a.minDelay, delay), max(a.maxDelay, delay)
+}
+
+// playoutSample 是抖动缓冲某一时刻的状态
+type playoutSample struct {
+	depth   int
+	delay   time.Duration
+	jitter  time.Duration // 没有 adapter 时为 0
+	late    int64         // 累计值
+	skipped int64         // 累计值
+}
+
+// playoutLog 在 -session-dir 下开启了抖动缓冲时非 nil，由 writeH264ToFile 交给 JitterBuffer
+var playoutLog *PlayoutLog
+
+// PlayoutLog 定期记录抖动缓冲的状态，方法对 nil 安全
+type PlayoutLog struct {
+	mu     sync.Mutex
+	policy string
+
+	lastSample  time.Time
+	samples     int
+	depthSum    int
+	depthMax    int
+	delaySum    time.Duration
+	delayMin    time.Duration
+	delayMax    time.Duration
+	jitterSum   time.Duration
+	lateBase    int64 // 之前的抖动缓冲累计的计数
+	skippedBase int64
+	lateAll     int64
+	skippedAll  int64
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewPlayoutLog 创建记录器，逐次采样写入 csvPath；policy 为汇总中的策略名
+func NewPlayoutLog(csvPath, policy string) (*PlayoutLog, error) {
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create playout csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"unix_ms", "depth_packets", "delay_ms", "jitter_ms", "late_packets", "skipped_packets"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write playout header: %w", err)
+	}
+	w.Flush()
+	return &PlayoutLog{policy: policy, writer: w, file: f}, nil
+}
+
+// Begin 在新的抖动缓冲开始使用时（每次接收）调用：新缓冲的计数从 0 开始，接在之前的累计值之后
+func (l *PlayoutLog) Begin() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lateBase, l.skippedBase = l.lateAll, l.skippedAll
+	l.lastSample = time.Time{}
+}
+
+// Sample 记录 now 时刻的状态；距离上次记录不足 playoutSampleInterval 时只更新累计计数，force 时总是记录
+func (l *PlayoutLog) Sample(now time.Time, s playoutSample, force bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lateAll, l.skippedAll = l.lateBase+s.late, l.skippedBase+s.skipped
+	if !force && !l.lastSample.IsZero() && now.Sub(l.lastSample) < playoutSampleInterval {
+		return
+	}
+	l.lastSample = now
+	if l.samples == 0 || s.delay < l.delayMin {
+		l.delayMin = s.delay
+	}
+	l.samples++
+	l.depthSum += s.depth
+	l.depthMax = max(l.depthMax, s.depth)
+	l.delaySum += s.delay
+	l.delayMax = max(l.delayMax, s.delay)
+	l.jitterSum += s.jitter
+
+	if l.writer == nil {
+		return
+	}
+	if err := l.writer.Write([]string{
+		fmt.Sprintf("%d", now.UnixMilli()),
+		fmt.Sprintf("%d", s.depth),
+		fmt.Sprintf("%.3f", float64(s.delay)/float64(time.Millisecond)),
+		fmt.Sprintf("%.3f", float64(s.jitter)/float64(time.Millisecond)),
+		fmt.Sprintf("%d", l.lateAll),
+		fmt.Sprintf("%d", l.skippedAll),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing playout CSV: %v\n", err)
+		return
+	}
+	l.writer.Flush()
+}
+
+// PlayoutSummary 是 metrics_summary 中的播放策略统计
+type PlayoutSummary struct {
+	Policy         string  `json:"policy"`
+	Samples        int     `json:"samples"`
+	DelayMeanMs    float64 `json:"delay_mean_ms"`
+	DelayMinMs     float64 `json:"delay_min_ms"`
+	DelayMaxMs     float64 `json:"delay_max_ms"`
+	DepthMean      float64 `json:"depth_mean_packets"`
+	DepthMax       int     `json:"depth_max_packets"`
+	JitterMeanMs   float64 `json:"jitter_mean_ms,omitempty"`
+	LatePackets    int64   `json:"late_packets"`
+	SkippedPackets int64   `json:"skipped_packets"`
+}
+
+// Summary 返回采样的汇总，没有记录器或没有样本时返回 nil
+func (l *PlayoutLog) Summary() *PlayoutSummary {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.samples == 0 {
+		return nil
+	}
+	n := float64(l.samples)
+	ms := float64(time.Millisecond)
+	return &PlayoutSummary{
+		Policy:         l.policy,
+		Samples:        l.samples,
+		DelayMeanMs:    float64(l.delaySum) / n / ms,
+		DelayMinMs:     float64(l.delayMin) / ms,
+		DelayMaxMs:     float64(l.delayMax) / ms,
+		DepthMean:      float64(l.depthSum) / n,
+		DepthMax:       l.depthMax,
+		JitterMeanMs:   float64(l.jitterSum) / n / ms,
+		LatePackets:    l.lateAll,
+		SkippedPackets: l.skippedAll,
+	}
+}
+
+// Close 关闭 CSV 文件
+func (l *PlayoutLog) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.writer != nil {
+		l.writer.Flush()
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func TestApplyPlayoutPolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		jitterBufferSet bool
+		want            time.Duration
+		wantErr         bool
+	}{
+		{name: "no policy keeps -jitter-buffer", policy: "", jitterBufferSet: true, want: 75 * time.Millisecond},
+		{name: "low-latency", policy: "low-latency", want: playoutLowLatencyDelay},
+		{name: "smooth", policy: "Smooth", want: playoutSmoothDelay},
+		{name: "adaptive starts at the initial delay", policy: "adaptive", want: playoutAdaptiveInitialDelay},
+		{name: "combined with -jitter-buffer", policy: "smooth", jitterBufferSet: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(policy PlayoutPolicy, delay time.Duration) { playoutPolicy, jitterBufferDelay = policy, delay }(playoutPolicy, jitterBufferDelay)
+			playoutPolicy, jitterBufferDelay = "", 75*time.Millisecond
+			if tt.policy != "" {
+				if err := playoutPolicy.Set(tt.policy); err != nil {
+					t.Fatalf("Set(%q): %v", tt.policy, err)
+				}
+			}
+			err := applyPlayoutPolicy(tt.jitterBufferSet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyPlayoutPolicy error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && jitterBufferDelay != tt.want {
+				t.Errorf("jitterBufferDelay %v, want %v", jitterBufferDelay, tt.want)
+			}
+		})
+	}
+
+	var p PlayoutPolicy
+	if err := p.Set("fast"); err == nil {
+		t.Error("Set(\"fast\") accepted an unknown policy")
+	}
+}
+
+// feedAdapter 以 30fps 送入 frames 帧（每帧一个包），第 i 帧额外晚到 extra(i)
+func feedAdapter(a *PlayoutAdapter, start time.Time, first, frames int, extra func(i int) time.Duration) {
+	const frameInterval = time.Second / 30
+	for i := first; i < first+frames; i++ {
+		a.OnArrival(uint32(i*3000), start.Add(time.Duration(i)*frameInterval+extra(i)))
+	}
+}
+
+func TestPlayoutAdapter(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	steady := func(int) time.Duration { return 0 }
+	jittery := func(i int) time.Duration { return time.Duration(i%2) * 40 * time.Millisecond }
+
+	a := NewPlayoutAdapter(90000, playoutAdaptiveInitialDelay)
+	feedAdapter(a, start, 0, 90, steady)
+	if a.Jitter() != 0 || a.Delay() >= playoutAdaptiveInitialDelay {
+		t.Errorf("steady stream: jitter %v, wait %v; want 0 and shrinking below %v", a.Jitter(), a.Delay(), playoutAdaptiveInitialDelay)
+	}
+
+	// 交替晚到 40ms：D 在 ±40ms 之间，J 收敛到 40ms，等待时长立即增大到上限以内的 4J
+	feedAdapter(a, start, 90, 150, jittery)
+	if j := a.Jitter(); j < 35*time.Millisecond || j > 41*time.Millisecond {
+		t.Errorf("jittery stream: jitter %v, want about 40ms", j)
+	}
+	if a.Delay() < 140*time.Millisecond || a.grows == 0 {
+		t.Errorf("jittery stream: wait %v after %d increases, want >= 140ms", a.Delay(), a.grows)
+	}
+
+	// 抖动消失后逐步收回，而不是一步回到下限
+	grown := a.Delay()
+	feedAdapter(a, start, 240, 4, steady)
+	if a.Delay() < grown*3/4 {
+		t.Errorf("wait dropped from %v to %v within 4 steady frames", grown, a.Delay())
+	}
+	feedAdapter(a, start, 244, 600, steady)
+	if a.Delay() != playoutAdaptiveMinDelay {
+		t.Errorf("after 20s of steady stream: wait %v, want %v", a.Delay(), playoutAdaptiveMinDelay)
+	}
+	if a.minDelay != playoutAdaptiveMinDelay || a.maxDelay < grown {
+		t.Errorf("range %v-%v, want %v up to at least %v", a.minDelay, a.maxDelay, playoutAdaptiveMinDelay, grown)
+	}
+
+	a.OnLate(start)
+	if want := playoutAdaptiveMinDelay * 3 / 2; a.Delay() != want {
+		t.Errorf("after a late packet: wait %v, want %v", a.Delay(), want)
+	}
+	for range 20 {
+		a.OnLate(start)
+	}
+	if a.Delay() != playoutAdaptiveMaxDelay {
+		t.Errorf("after many late packets: wait %v, want the %v limit", a.Delay(), playoutAdaptiveMaxDelay)
+	}
+}
+
+func TestJitterBufferAdaptive(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	j := NewJitterBuffer(playoutAdaptiveInitialDelay)
+	j.EnableAdaptive(90000)
+	deliver := func(*rtp.Packet, bool, time.Time) {}
+
+	j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1, Timestamp: 0}}, false, start, deliver)
+	j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 3, Timestamp: 6000}}, false, start.Add(66*time.Millisecond), deliver)
+	j.Release(start.Add(116*time.Millisecond), deliver)
+	// 缺口已放弃，序列号 2 晚到：丢弃，等待时长增大一半
+	before := j.delay
+	j.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 2, Timestamp: 3000}}, true, start.Add(120*time.Millisecond), deliver)
+	if j.late != 1 || j.delay != before*3/2 {
+		t.Errorf("late packet: %d late, wait %v; want 1, %v", j.late, j.delay, before*3/2)
+	}
+	if deadline, _ := j.ReadDeadline(nil); !deadline.IsZero() {
+		t.Errorf("empty buffer has a read deadline %v", deadline)
+	}
+}
+
+func TestPlayoutLog(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewPlayoutLog(filepath.Join(dir, "playout.csv"), "smooth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+
+	log.Begin()
+	log.Sample(start, playoutSample{depth: 0, delay: 200 * ms}, false)
+	log.Sample(start.Add(50*ms), playoutSample{depth: 9, delay: 200 * ms, late: 1}, false) // 不足采样间隔，只更新计数
+	log.Sample(start.Add(100*ms), playoutSample{depth: 4, delay: 200 * ms, late: 1, skipped: 2}, false)
+	// 重新连接后的新缓冲：计数从 0 开始，接在之前的累计值之后
+	log.Begin()
+	log.Sample(start.Add(150*ms), playoutSample{depth: 2, delay: 200 * ms, late: 2}, false)
+	log.Sample(start.Add(160*ms), playoutSample{depth: 0, delay: 200 * ms, late: 2, skipped: 1}, true)
+	log.Close()
+
+	s := log.Summary()
+	want := PlayoutSummary{Policy: "smooth", Samples: 4, DelayMeanMs: 200, DelayMinMs: 200, DelayMaxMs: 200,
+		DepthMean: 1.5, DepthMax: 4, LatePackets: 3, SkippedPackets: 3}
+	if s == nil || *s != want {
+		t.Fatalf("summary %+v, want %+v", s, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "playout.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 || lines[0] != "unix_ms,depth_packets,delay_ms,jitter_ms,late_packets,skipped_packets" {
+		t.Fatalf("playout.csv:\n%s", data)
+	}
+	if want := "4,200.000,0.000,1,2"; !strings.HasSuffix(lines[2], want) {
+		t.Errorf("second sample %q, want suffix %q", lines[2], want)
+	}
+
+	var nilLog *PlayoutLog
+	nilLog.Begin()
+	nilLog.Sample(start, playoutSample{}, true)
+	if nilLog.Summary() != nil {
+		t.Error("nil log has a summary")
+	}
+}
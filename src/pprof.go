@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// pprof.go - 运行时性能剖析入口（-pprof）
+//
+// 说明：
+//   - 在指定地址上提供 net/http/pprof 的标准端点（/debug/pprof/...），会话进行中即可采集
+//   - CPU：go tool pprof http://<addr>/debug/pprof/profile?seconds=30
+//   - 分配：go tool pprof -sample_index=alloc_space http://<addr>/debug/pprof/allocs
+//   - 只能看到 Go 侧的 CPU 与分配；FFmpeg（cgo）内部的内存分配不在堆剖析中
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // 在 http.DefaultServeMux 上注册 /debug/pprof/ 处理函数
+	"os"
+)
+
+// startPprofServer 在 addr（如 ":6060"、"127.0.0.1:6060"）上启动 pprof HTTP 服务。
+// 监听在返回前完成，端口被占用等错误会直接返回；之后的服务错误只打印日志，不影响会话。
+func startPprofServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "pprof listening on http://%s/debug/pprof/\n", listener.Addr())
+	go func() {
+		if sErr := http.Serve(listener, nil); sErr != nil {
+			fmt.Fprintf(os.Stderr, "pprof server stopped: %v\n", sErr)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// rate_controller.go - 实验 server 共用的拥塞控制器接口
+//
+// 说明：
+//   - NdtcController / SalsifyController / BurstController 都实现 RateController，由 experiment_loop.go 的
+//     writeVideoToTrack 驱动：每帧编码前取预算，发送完成后提交 FrameObservation
+//   - 三种控制器使用同一个观测结构，各自只读需要的字段（例如 NDTC 用发送时长构造 FDACE 样本，BurstRTC 用 burst 部分估计吞吐）
+//   - 新增控制器时实现 RateController，并在 experiments.go 的 experimentRegistry 中登记
+package main
+
+import "time"
+
+// FrameObservation 是一帧发送完成后的观测
+type FrameObservation struct {
+	FrameID    int
+	TargetBits int           // 本帧的预算（经过 -startup-ramp）
+	Pacing     time.Duration // NextFrameBudget 给出的发送时长
+	SentBits   int           // 实际发送的比特数
+	SendStart  time.Time     // 本帧的 tick：之后依次是缩放、编码与发送
+	FirstWrite time.Time     // 第一个包开始写入的时间，零值表示本帧没有输出
+	SendEnd    time.Time     // 最后一个包写完的时间
+
+	// BurstFraction 与 Split 是 BurstRTC 按 burst + pacing 发送时的划分（见 burst_pacer.go），其它实验为 1 与零值
+	BurstFraction float64
+	Split         BurstSendResult
+
+	SendBlocked  bool    // 发送时本地发送缓冲区受压（-send-pressure）
+	DrainBps     float64 // 受压时的排空速率估计
+	LossDetected bool    // 上一帧以来收到过接收端的 NACK / PLI（见 loss_feedback.go，未开启时恒为 false）
+
+	// ReceiveSamples 是上一帧以来由 TWCC 反馈得到的完整帧的发送 / 接收持续时间（见 frame_dispersion.go，只有 NDTC 的 -rtcp-bwe 开启）
+	ReceiveSamples []FrameDispersionSample
+}
+
+// RateController 是按帧给出预算的拥塞控制器
+type RateController interface {
+	// NextFrameBudget 返回下一帧的目标比特数与发送时长（<= 0 表示编码后立即发出）
+	NextFrameBudget() (bits int, pacing time.Duration)
+	// UpdateStats 提交一帧的发送观测
+	UpdateStats(obs FrameObservation)
+	// OnNetworkEstimate 设置 -rtcp-bwe 的网络带宽估计，<= 0 表示还没有
+	OnNetworkEstimate(bps float64)
+	// State 返回控制器状态的快照（-controller-state-interval 与 -debug-overlay 使用）
+	State() ControllerState
+}
+
+// burstFractionController 由按 burst + pacing 发送的控制器（BurstRTC）实现，
+// 返回最近一次 NextFrameBudget 对应的 burst 比例
+type burstFractionController interface {
+	BurstFraction() float64
+}
@@ -0,0 +1,269 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// raw_yuv.go - client 端解码并输出原始 YUV420P（-output-raw-yuv）
+//
+// 说明：
+//   - 与 received.h264 并行输出：接收协程把写入文件的每个 access unit（到 RTP marker 位为止）交给 FFmpeg H.264 解码器，
+//     解出的每一帧按 YUV420P 平面顺序（Y、U、V，无行对齐填充）追加写入，可直接作为 PSNR / SSIM 的输入，不需要再解码一次
+//   - 解码失败的 access unit（例如丢包导致的不完整帧、丢失参考帧）跳过并计数，文件中只有能解码的帧
+//   - 文件中所有帧使用第一帧的分辨率：流中途分辨率变化（例如 -degrade-resolution-kbps 降档）时缩放回第一帧的分辨率，
+//     保证文件是单一格式的 rawvideo；缩放过的帧数记录在旁路文件中
+//   - 结束时写入旁路文件 <输出>.json：宽高、像素格式、帧率、帧数以及对应的 ffmpeg 输入参数
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/asticode/go-astiav"
+)
+
+// rawYUV 在 -output-raw-yuv 开启时非 nil，由 h264StreamSink 按 access unit 调用
+var rawYUV *RawYUVWriter
+
+// rawYUVPacketPadding 是送入解码器的数据后面补的 0 字节数（FFmpeg 要求输入缓冲区至少有 AV_INPUT_BUFFER_PADDING_SIZE 的填充）
+const rawYUVPacketPadding = 64
+
+// RawYUVSidecar 是旁路文件的内容
+type RawYUVSidecar struct {
+	File           string  `json:"file"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	PixelFormat    string  `json:"pixel_format"`
+	FrameRate      float64 `json:"frame_rate"`
+	Frames         int     `json:"frames"`
+	FrameBytes     int     `json:"frame_bytes"`
+	AccessUnits    int     `json:"access_units"`
+	DecodeErrors   int     `json:"decode_errors"`
+	RescaledFrames int     `json:"rescaled_frames,omitempty"`
+	FFmpegInput    string  `json:"ffmpeg_input"`
+}
+
+// RawYUVWriter 解码 H.264 access unit 并写出原始 YUV420P，方法对 nil 安全，只能在接收协程中使用
+type RawYUVWriter struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+
+	decodeCodecContext *astiav.CodecContext
+	packet             *astiav.Packet
+	frame              *astiav.Frame
+	convertedFrame     *astiav.Frame
+	scaleContext       *astiav.SoftwareScaleContext
+
+	frameRate float64
+	au        []byte // 当前 access unit 的 Annex-B 数据
+	buf       []byte // 一帧 YUV420P 的输出缓冲区
+
+	width, height  int // 文件中的分辨率（第一帧的分辨率）
+	frames         int
+	accessUnits    int
+	decodeErrors   int
+	rescaledFrames int
+	failed         bool
+}
+
+// NewRawYUVWriter 创建输出文件并打开 H.264 解码器
+func NewRawYUVWriter(path string) (*RawYUVWriter, error) {
+	h264Decoder := astiav.FindDecoder(astiav.CodecIDH264)
+	if h264Decoder == nil {
+		return nil, fmt.Errorf("%w: no H.264 decoder found", ErrCodecUnsupported)
+	}
+	decodeCodecContext := astiav.AllocCodecContext(h264Decoder)
+	if decodeCodecContext == nil {
+		return nil, fmt.Errorf("failed to allocate H.264 decoder context")
+	}
+	if err := decodeCodecContext.Open(h264Decoder, nil); err != nil {
+		decodeCodecContext.Free()
+		return nil, fmt.Errorf("failed to open H.264 decoder: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		decodeCodecContext.Free()
+		return nil, fmt.Errorf("failed to create raw YUV output: %w", err)
+	}
+	return &RawYUVWriter{
+		path:               path,
+		file:               f,
+		writer:             bufio.NewWriterSize(f, 1<<20),
+		decodeCodecContext: decodeCodecContext,
+		packet:             astiav.AllocPacket(),
+		frame:              astiav.AllocFrame(),
+		convertedFrame:     astiav.AllocFrame(),
+	}, nil
+}
+
+// SetFrameRate 记录帧率（只用于旁路文件）
+func (w *RawYUVWriter) SetFrameRate(frameRate float64) {
+	if w == nil {
+		return
+	}
+	w.frameRate = frameRate
+}
+
+// AddNAL 把一个 NAL 单元（含 start code）追加到当前 access unit
+func (w *RawYUVWriter) AddNAL(startCode, nal []byte) {
+	if w == nil || w.failed {
+		return
+	}
+	w.au = append(w.au, startCode...)
+	w.au = append(w.au, nal...)
+}
+
+// EndAccessUnit 在 access unit 结束时调用，解码并写出得到的帧
+func (w *RawYUVWriter) EndAccessUnit() {
+	if w == nil || w.failed || len(w.au) == 0 {
+		return
+	}
+	w.accessUnits++
+	data := append(w.au, make([]byte, rawYUVPacketPadding)...)
+	w.au = w.au[:0]
+
+	if err := w.packet.FromData(data); err != nil {
+		w.fail(fmt.Errorf("failed to fill decoder packet: %w", err))
+		return
+	}
+	w.packet.SetSize(len(data) - rawYUVPacketPadding)
+	err := w.decodeCodecContext.SendPacket(w.packet)
+	w.packet.Unref()
+	if err != nil {
+		w.decodeErrors++
+		return
+	}
+	w.receiveFrames()
+}
+
+// receiveFrames 取出解码器当前可以输出的所有帧
+func (w *RawYUVWriter) receiveFrames() {
+	for !w.failed {
+		if err := w.decodeCodecContext.ReceiveFrame(w.frame); err != nil {
+			if !errors.Is(err, astiav.ErrEagain) && !errors.Is(err, astiav.ErrEof) {
+				w.decodeErrors++
+			}
+			return
+		}
+		if err := w.writeFrame(w.frame); err != nil {
+			w.fail(err)
+		}
+		w.frame.Unref()
+	}
+}
+
+// writeFrame 把一帧转换为文件的分辨率与 YUV420P 后写出
+func (w *RawYUVWriter) writeFrame(frame *astiav.Frame) error {
+	if w.width == 0 {
+		w.width, w.height = frame.Width(), frame.Height()
+		fmt.Fprintf(os.Stderr, "Raw YUV output: %dx%d yuv420p to %s\n", w.width, w.height, w.path)
+	}
+
+	out := frame
+	if frame.Width() != w.width || frame.Height() != w.height || frame.PixelFormat() != astiav.PixelFormatYuv420P {
+		if frame.Width() != w.width || frame.Height() != w.height {
+			w.rescaledFrames++
+		}
+		if err := w.ensureScaleContext(frame); err != nil {
+			return err
+		}
+		if err := w.scaleContext.ScaleFrame(frame, w.convertedFrame); err != nil {
+			return fmt.Errorf("failed to convert decoded frame: %w", err)
+		}
+		out = w.convertedFrame
+	}
+
+	size, err := out.ImageBufferSize(1)
+	if err != nil {
+		return fmt.Errorf("failed to get frame size: %w", err)
+	}
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	w.buf = w.buf[:size]
+	if _, err = out.ImageCopyToBuffer(w.buf, 1); err != nil {
+		return fmt.Errorf("failed to copy frame data: %w", err)
+	}
+	if _, err = w.writer.Write(w.buf); err != nil {
+		return fmt.Errorf("failed to write raw YUV frame: %w", err)
+	}
+	w.frames++
+	return nil
+}
+
+// ensureScaleContext 创建或更新把 frame 转为文件格式的缩放上下文
+func (w *RawYUVWriter) ensureScaleContext(frame *astiav.Frame) error {
+	if w.scaleContext == nil {
+		ssc, err := astiav.CreateSoftwareScaleContext(
+			frame.Width(), frame.Height(), frame.PixelFormat(),
+			w.width, w.height, astiav.PixelFormatYuv420P,
+			astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBilinear),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create scale context: %w", err)
+		}
+		w.scaleContext = ssc
+		return nil
+	}
+	return ensureScalerSource(w.scaleContext, frame)
+}
+
+func (w *RawYUVWriter) fail(err error) {
+	w.failed = true
+	fmt.Fprintf(os.Stderr, "Error writing raw YUV output, stopped: %v\n", err)
+}
+
+// Close 解码剩余的数据、写出旁路文件并释放解码器
+func (w *RawYUVWriter) Close() {
+	if w == nil {
+		return
+	}
+	w.EndAccessUnit()
+	// 送入空包取出解码器中缓存的帧
+	if !w.failed {
+		if err := w.decodeCodecContext.SendPacket(nil); err == nil {
+			w.receiveFrames()
+		}
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error flushing raw YUV output: %v\n", err)
+	}
+	if err := w.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing raw YUV output: %v\n", err)
+	}
+
+	sidecar := RawYUVSidecar{
+		File:           w.path,
+		Width:          w.width,
+		Height:         w.height,
+		PixelFormat:    "yuv420p",
+		FrameRate:      w.frameRate,
+		Frames:         w.frames,
+		FrameBytes:     len(w.buf),
+		AccessUnits:    w.accessUnits,
+		DecodeErrors:   w.decodeErrors,
+		RescaledFrames: w.rescaledFrames,
+		FFmpegInput:    fmt.Sprintf("-f rawvideo -pix_fmt yuv420p -s %dx%d -r %g -i %s", w.width, w.height, w.frameRate, w.path),
+	}
+	if data, err := json.MarshalIndent(sidecar, "", "  "); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding raw YUV sidecar: %v\n", err)
+	} else if err = os.WriteFile(w.path+".json", data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing raw YUV sidecar: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "Raw YUV: %d frames from %d access units (%d decode errors, %d rescaled) written to %s\n",
+		w.frames, w.accessUnits, w.decodeErrors, w.rescaledFrames, w.path)
+
+	if w.scaleContext != nil {
+		w.scaleContext.Free()
+	}
+	w.convertedFrame.Free()
+	w.frame.Free()
+	w.packet.Free()
+	w.decodeCodecContext.Free()
+}
@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// replay_metrics.go - 从 RTP dump 离线重新计算指标（client 的 -replay-metadata）
+//
+// 说明：
+//   - 输入为一次实验的 session 目录：-dump-rtp 记录的 rtp_dump.bin，以及 server 写入的 frame_metadata.csv 与 start_time.txt
+//   - 按记录的到达时间把每个包交给与实时接收相同的 h264StreamSink，重新生成 client_metrics.csv 与 metrics_summary，
+//     因此解包、帧计数、延迟、stall 与有效码率的计算与实时运行完全一致，只有参数不同
+//   - 可调参数：stall 阈值（帧间隔的倍数）、预热时长（统计时跳过开头的帧），以及 client 已有的码率窗口、质量阈值与 start code 参数
+//   - 结果写入单独的输出目录，不覆盖原始 session 的文件；重放不写 H.264 文件，也不更新 -since-keyframe 等实时统计
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReplayConfig 是离线重放的参数
+type ReplayConfig struct {
+	SessionDir    string        // 被重放的 session 目录（包含 rtp_dump.bin / frame_metadata.csv / start_time.txt）
+	OutputDir     string        // 输出目录，为空时使用 <SessionDir>/replay
+	StallFactor   float64       // 帧间隔超过正常帧间隔的多少倍算 stall（实时运行为 2）
+	Warmup        time.Duration // 汇总统计跳过开头的时长
+	BitrateWindow BitrateWindowConfig
+	StartCodeMode StartCodeMode
+	Thresholds    QualityThresholds
+}
+
+// replayMetrics 重放 rtp_dump.bin，写出 client_metrics.csv 与汇总，返回汇总结果
+func replayMetrics(cfg ReplayConfig) (*SummaryMetrics, error) {
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = filepath.Join(cfg.SessionDir, "replay")
+	}
+	if filepath.Clean(cfg.OutputDir) == filepath.Clean(cfg.SessionDir) {
+		return nil, fmt.Errorf("output directory must differ from the replayed session directory")
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	dumpPath := filepath.Join(cfg.SessionDir, "rtp_dump.bin")
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RTP dump: %w", err)
+	}
+	defer f.Close()
+	reader, err := NewRTPDumpReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var stallThreshold time.Duration
+	if reader.FrameRate > 0 {
+		stallThreshold = time.Duration(cfg.StallFactor * float64(time.Second) / reader.FrameRate)
+	}
+	fmt.Fprintf(os.Stderr, "Replaying %s (%.3g fps, stall threshold %v, warmup %v) into %s\n",
+		dumpPath, reader.FrameRate, stallThreshold, cfg.Warmup, cfg.OutputDir)
+
+	metricsPath := filepath.Join(cfg.OutputDir, "client_metrics.csv")
+	sink := newH264StreamSink(io.Discard, cfg.SessionDir, metricsPath, reader.StartTime, reader.FrameRate, stallThreshold, cfg.BitrateWindow, cfg.StartCodeMode)
+	packets := 0
+	for {
+		pkt, rtx, arrival, rErr := reader.Next()
+		if errors.Is(rErr, io.EOF) {
+			break
+		}
+		if rErr != nil {
+			// 实时运行被中断时最后一条记录可能不完整，已读到的部分仍然有效
+			fmt.Fprintf(os.Stderr, "Warning: RTP dump ends early after %d packets: %v\n", packets, rErr)
+			break
+		}
+		sink.WritePacket(pkt, rtx, arrival)
+		packets++
+	}
+	sink.Finish()
+	sink.Close()
+	fmt.Fprintf(os.Stderr, "Replayed %d packets, %d frames\n", packets, sink.frameID)
+
+	summary, err := calculateSummary(metricsPath, filepath.Join(cfg.SessionDir, "frame_metadata.csv"), cfg.Warmup, cfg.Thresholds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate summary: %w", err)
+	}
+	// 分片丢弃只取决于 dump 中的包序列，重放时同样可以得到
+	summary.DroppedFragmentNALs, summary.DroppedFragments = fragmentDrops.Stats()
+	if err = WriteSummaryMetrics(summary, cfg.OutputDir); err != nil {
+		return nil, fmt.Errorf("failed to write summary: %w", err)
+	}
+	return summary, nil
+}
+
+// runReplayMetrics 执行 -replay-metadata 并打印汇总，出错时退出进程
+func runReplayMetrics(cfg ReplayConfig) {
+	if cfg.StallFactor <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -replay-stall-factor must be > 0\n")
+		os.Exit(1)
+	}
+	if cfg.Warmup < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -replay-warmup must be >= 0\n")
+		os.Exit(1)
+	}
+	summary, err := replayMetrics(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error replaying metrics: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "\n=== Replayed Metrics Summary ===\n")
+	fmt.Fprintf(os.Stderr, "Total Frames: %d\n", summary.TotalFrames)
+	fmt.Fprintf(os.Stderr, "Average Latency: %.3f ms\n", summary.AverageLatencyMs)
+	fmt.Fprintf(os.Stderr, "P99 Latency: %.3f ms\n", summary.P99LatencyMs)
+	fmt.Fprintf(os.Stderr, "Stall Rate: %.2f%% (%d frames)\n", summary.StallRate*100.0, summary.TotalStallFrames)
+	fmt.Fprintf(os.Stderr, "Effective Bitrate: %.2f kbps\n", summary.EffectiveBitrateKbps)
+	if summary.SentFrames > 0 {
+		fmt.Fprintf(os.Stderr, "Frame Loss: %.2f%% (%d of %d sent)\n", summary.FrameLossRate*100.0, summary.LostFrames, summary.SentFrames)
+	}
+	if summary.DroppedFragments > 0 {
+		fmt.Fprintf(os.Stderr, "Fragment Loss: %d incomplete NAL units dropped (%d fragments)\n", summary.DroppedFragmentNALs, summary.DroppedFragments)
+	}
+	fmt.Fprintf(os.Stderr, "Connection Quality: %s\n", summary.Quality)
+}
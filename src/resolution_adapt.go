@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// resolution_adapt.go - 持续拥塞时降低编码分辨率（-degrade-resolution-kbps / -degrade-resolution-hold）
+//
+// 说明：
+//   - 控制器只能通过 CRF / QP 适应带宽，预算很低时原分辨率下的画面块效应严重，降低分辨率的主观质量更好
+//   - 预算（按帧间隔换算为 bps）持续低于阈值超过 hold 时降一档，持续高于阈值的 1.5 倍超过 hold 时升一档；
+//     两个方向的阈值不同，且每次切换后重新计时，避免在阈值附近来回切换
+//   - 档位为源分辨率的 1、3/4、1/2，宽高取偶数；切换时 server 修改缩放输出并重建编码器，
+//     新编码器的第一帧是带 SPS/PPS 的 IDR，接收端可以直接从新分辨率解码
+//   - 每次切换在 stderr 打印一行，指定 -session-dir 时记录到 resolution_switches.csv
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+)
+
+// resolutionAdapter 在 -degrade-resolution-kbps 开启时非 nil，由发送循环在取得帧预算后调用
+var resolutionAdapter *ResolutionAdapter
+
+// resolutionScales 是可选的分辨率档位（相对源分辨率），从高到低
+var resolutionScales = []float64{1, 0.75, 0.5}
+
+// resolutionUpFactor 是升档阈值相对降档阈值的倍数
+const resolutionUpFactor = 1.5
+
+// ResolutionAdapter 根据持续的预算水平选择分辨率档位，方法对 nil 安全，只能在发送协程中使用
+type ResolutionAdapter struct {
+	downBps float64
+	upBps   float64
+	hold    time.Duration
+
+	level      int
+	belowSince time.Time // 预算开始持续低于 downBps 的时刻，零值表示当前不低于
+	aboveSince time.Time // 预算开始持续高于 upBps 的时刻
+	switches   int
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewResolutionAdapter 创建分辨率适配器：thresholdBps 为降档阈值，hold 为持续时间；csvPath 为空时不写切换记录
+func NewResolutionAdapter(thresholdBps float64, hold time.Duration, csvPath string) (*ResolutionAdapter, error) {
+	a := &ResolutionAdapter{
+		downBps: thresholdBps,
+		upBps:   thresholdBps * resolutionUpFactor,
+		hold:    hold,
+	}
+	if csvPath == "" {
+		return a, nil
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resolution switch csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"frame", "unix_ms", "budget_bps", "direction", "scale", "width", "height"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write resolution switch header: %w", err)
+	}
+	w.Flush()
+	a.writer, a.file = w, f
+	return a, nil
+}
+
+// Update 按本帧预算更新状态，返回是否切换了档位；切换后调用方按 Size 重建缩放与编码器
+func (a *ResolutionAdapter) Update(frameID int, budgetBps float64, srcWidth, srcHeight int) bool {
+	if a == nil {
+		return false
+	}
+	now := time.Now()
+
+	direction := ""
+	switch {
+	case budgetBps < a.downBps && a.level < len(resolutionScales)-1:
+		a.aboveSince = time.Time{}
+		if a.belowSince.IsZero() {
+			a.belowSince = now
+		} else if now.Sub(a.belowSince) >= a.hold {
+			a.level++
+			direction = "down"
+		}
+	case budgetBps > a.upBps && a.level > 0:
+		a.belowSince = time.Time{}
+		if a.aboveSince.IsZero() {
+			a.aboveSince = now
+		} else if now.Sub(a.aboveSince) >= a.hold {
+			a.level--
+			direction = "up"
+		}
+	default:
+		a.belowSince, a.aboveSince = time.Time{}, time.Time{}
+	}
+	if direction == "" {
+		return false
+	}
+
+	// 每次切换后重新计时
+	a.belowSince, a.aboveSince = time.Time{}, time.Time{}
+	a.switches++
+	width, height := a.Size(srcWidth, srcHeight)
+	fmt.Fprintf(os.Stderr, "[Resolution] Frame %d: budget %.0f kbps sustained for %v, switching %s to %dx%d (scale %.2f), forcing keyframe\n",
+		frameID, budgetBps/1000, a.hold, direction, width, height, resolutionScales[a.level])
+	if a.writer != nil {
+		if err := a.writer.Write([]string{
+			fmt.Sprintf("%d", frameID),
+			fmt.Sprintf("%d", now.UnixMilli()),
+			fmt.Sprintf("%.0f", budgetBps),
+			direction,
+			fmt.Sprintf("%.2f", resolutionScales[a.level]),
+			fmt.Sprintf("%d", width),
+			fmt.Sprintf("%d", height),
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing resolution switch CSV: %v\n", err)
+		}
+		a.writer.Flush()
+	}
+	return true
+}
+
+// Size 返回当前档位下的编码分辨率；未开启时返回源分辨率
+func (a *ResolutionAdapter) Size(srcWidth, srcHeight int) (int, int) {
+	if a == nil || a.level == 0 {
+		return srcWidth, srcHeight
+	}
+	scale := resolutionScales[a.level]
+	// YUV420P 要求宽高为偶数
+	width := max(int(float64(srcWidth)*scale)&^1, 2)
+	height := max(int(float64(srcHeight)*scale)&^1, 2)
+	return width, height
+}
+
+// Close 打印切换次数并关闭 CSV 文件
+func (a *ResolutionAdapter) Close() {
+	if a == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[Resolution] %d resolution switches, final scale %.2f\n", a.switches, resolutionScales[a.level])
+	if a.file == nil {
+		return
+	}
+	a.writer.Flush()
+	if err := a.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing resolution switch CSV file: %v\n", err)
+	}
+}
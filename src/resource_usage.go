@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// resource_usage.go - 定期记录进程的 CPU 与内存占用（-resource-usage）
+//
+// 说明：
+//   - 每个采样间隔读取一次进程 CPU 时间（user/system）与 RSS，写入 resource_usage_<side>.csv
+//   - CPU 占用按相邻两次采样的 CPU 时间差 / 墙钟时间差计算，100% 表示占满一个核
+//   - RSS 的读取方式与平台相关（见 resource_usage_unix.go / resource_usage_other.go）；
+//     Go 堆与 goroutine 数来自 runtime，所有平台都可用
+//   - Close 时在 stderr 输出平均/峰值汇总，便于与质量、码率指标对照
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// resourceSampleInterval 是资源占用的采样间隔
+const resourceSampleInterval = time.Second
+
+// processUsage 是某一时刻的进程资源占用
+type processUsage struct {
+	userCPU   time.Duration
+	systemCPU time.Duration
+	rssBytes  int64 // 0 表示当前平台无法读取
+}
+
+// ResourceMonitor 在后台定期采样进程资源占用并写入 CSV
+type ResourceMonitor struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+	file   *os.File
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// 汇总统计
+	samples        int
+	cpuPercentSum  float64
+	peakCPUPercent float64
+	peakRSSBytes   int64
+	peakHeapBytes  uint64
+}
+
+// NewResourceMonitor 创建 CSV 并启动采样协程
+func NewResourceMonitor(csvPath string) (*ResourceMonitor, error) {
+	if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create resource usage directory: %w", err)
+	}
+
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource usage csv: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	header := []string{
+		"unix_ms",
+		"cpu_user_ms",   // 累计用户态 CPU 时间
+		"cpu_system_ms", // 累计内核态 CPU 时间
+		"cpu_percent",   // 本采样间隔的 CPU 占用（100 = 一个核）
+		"rss_kb",        // 常驻内存（0 表示当前平台不可用）
+		"go_heap_kb",    // Go 堆中已分配的内存（不含 FFmpeg 等 C 分配）
+		"goroutines",
+	}
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write resource usage header: %w", err)
+	}
+	w.Flush()
+
+	m := &ResourceMonitor{
+		writer: w,
+		file:   f,
+		done:   make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// run 每个采样间隔记录一行
+func (m *ResourceMonitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(resourceSampleInterval)
+	defer ticker.Stop()
+
+	last, lastOK := readProcessUsage()
+	lastTime := time.Now()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		usage, ok := readProcessUsage()
+
+		cpuPercent := 0.0
+		if ok && lastOK {
+			cpu := (usage.userCPU + usage.systemCPU) - (last.userCPU + last.systemCPU)
+			if wall := now.Sub(lastTime); wall > 0 {
+				cpuPercent = float64(cpu) / float64(wall) * 100.0
+			}
+		}
+		last, lastOK, lastTime = usage, ok, now
+
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
+
+		m.record(now, usage, cpuPercent, memStats.HeapAlloc)
+	}
+}
+
+// record 写入一行并更新汇总统计
+func (m *ResourceMonitor) record(now time.Time, usage processUsage, cpuPercent float64, heapBytes uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.samples++
+	m.cpuPercentSum += cpuPercent
+	if cpuPercent > m.peakCPUPercent {
+		m.peakCPUPercent = cpuPercent
+	}
+	if usage.rssBytes > m.peakRSSBytes {
+		m.peakRSSBytes = usage.rssBytes
+	}
+	if heapBytes > m.peakHeapBytes {
+		m.peakHeapBytes = heapBytes
+	}
+
+	if m.writer == nil {
+		return
+	}
+	record := []string{
+		fmt.Sprintf("%d", now.UnixMilli()),
+		fmt.Sprintf("%d", usage.userCPU.Milliseconds()),
+		fmt.Sprintf("%d", usage.systemCPU.Milliseconds()),
+		fmt.Sprintf("%.1f", cpuPercent),
+		fmt.Sprintf("%d", usage.rssBytes/1024),
+		fmt.Sprintf("%d", heapBytes/1024),
+		fmt.Sprintf("%d", runtime.NumGoroutine()),
+	}
+	if err := m.writer.Write(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing resource usage CSV: %v\n", err)
+		return
+	}
+	m.writer.Flush()
+}
+
+// Close 停止采样、关闭 CSV，并在 stderr 输出平均/峰值汇总
+func (m *ResourceMonitor) Close() {
+	select {
+	case <-m.done:
+		return
+	default:
+		close(m.done)
+	}
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.writer != nil {
+		m.writer.Flush()
+		m.writer = nil
+	}
+	if m.file != nil {
+		if err := m.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing resource usage CSV file: %v\n", err)
+		}
+		m.file = nil
+	}
+
+	if m.samples == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n=== Resource Usage ===\n")
+	fmt.Fprintf(os.Stderr, "Samples: %d (every %v)\n", m.samples, resourceSampleInterval)
+	fmt.Fprintf(os.Stderr, "CPU: mean %.1f%%, peak %.1f%% (100%% = one core)\n", m.cpuPercentSum/float64(m.samples), m.peakCPUPercent)
+	if m.peakRSSBytes > 0 {
+		fmt.Fprintf(os.Stderr, "Peak RSS: %.1f MB\n", float64(m.peakRSSBytes)/(1024*1024))
+	}
+	fmt.Fprintf(os.Stderr, "Peak Go heap: %.1f MB\n", float64(m.peakHeapBytes)/(1024*1024))
+	fmt.Fprintf(os.Stderr, "======================\n\n")
+}
@@ -0,0 +1,13 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !unix && !js
+// +build !unix,!js
+
+// resource_usage_other.go - 没有 getrusage 的平台（如 Windows）只记录 runtime 提供的指标
+package main
+
+// readProcessUsage 在不支持的平台上返回 false，CSV 中 CPU 与 RSS 列为 0
+func readProcessUsage() (processUsage, bool) {
+	return processUsage{}, false
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build unix
+// +build unix
+
+// resource_usage_unix.go - 通过 getrusage 读取 CPU 时间，Linux 下从 /proc 读取当前 RSS
+package main
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// readProcessUsage 读取当前进程的 CPU 时间与 RSS
+func readProcessUsage() (processUsage, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return processUsage{}, false
+	}
+
+	usage := processUsage{
+		userCPU:   time.Duration(ru.Utime.Nano()),
+		systemCPU: time.Duration(ru.Stime.Nano()),
+	}
+
+	if rss, ok := readLinuxRSS(); ok {
+		usage.rssBytes = rss
+	} else {
+		// 其他 Unix 没有 /proc/self/statm，退而使用 ru_maxrss（峰值 RSS）；
+		// macOS 的单位是字节，其余平台是 KB
+		usage.rssBytes = int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" {
+			usage.rssBytes *= 1024
+		}
+	}
+	return usage, true
+}
+
+// readLinuxRSS 从 /proc/self/statm 读取当前 RSS（第二列，单位为页）
+func readLinuxRSS() (int64, bool) {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * int64(os.Getpagesize()), true
+}
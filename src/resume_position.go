@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js && gcc
+// +build !js,gcc
+
+// resume_position.go - GCC server 记录源视频的解码位置，重启后从该位置继续发送（-resume）
+//
+// 说明：
+//   - 指定 -session-dir 时，每送入编码器一帧就记录它的源 PTS（解码时间基，即源流时间基），最多每 resumeSaveInterval
+//     写一次 <session-dir>/video_position.json（先写临时文件再 rename，进程中途被杀也不会留下半个文件）；退出时再写一次最新位置
+//   - 实验中途重启 server 时加 -resume：initVideoSource 打开解码器后读取该文件，以 SeekFlagBackward 定位到记录位置之前最近的关键帧，
+//     接收循环丢弃关键帧之前的包，再解码并丢弃 PTS 不晚于记录位置的帧（已经发送过），第一帧发出的是记录位置之后的下一帧，
+//     编码器是新建的，这一帧编码为 IDR，client 看到的画面是干净的
+//   - 位置文件记录了源文件路径，与 -video 不一致时报错退出；文件不存在时从头开始（第一次运行也可以带 -resume）
+//   - 编码器内部缓存的帧（-bframes / lookahead）在记录时尚未发出，重启后不会重发，最多缺少这几帧
+//   - 回到开头（-loop）、重新加载（-watch）或控制命令 seek 时，尚未完成的恢复被取消；记录位置从此跟随新的位置
+//   - -passthrough 不解码，无法逐帧定位，不能与 -resume 同时使用
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// resumeSaveInterval 是两次写位置文件之间的最短间隔
+const resumeSaveInterval = time.Second
+
+// videoResume 在指定 -session-dir 时非 nil
+var videoResume *VideoResume
+
+// videoPosition 是 video_position.json 的内容
+type videoPosition struct {
+	Video       string  `json:"video"`
+	PTS         int64   `json:"pts"`
+	TimeBaseNum int     `json:"time_base_num"`
+	TimeBaseDen int     `json:"time_base_den"`
+	Seconds     float64 `json:"position_seconds"`
+	UpdatedAt   string  `json:"updated_at"`
+}
+
+// VideoResume 保存并恢复源视频的解码位置；方法对 nil 安全
+type VideoResume struct {
+	path      string
+	videoPath string
+
+	// 恢复：pending 表示还没有 seek，resuming 表示 seek 之后还在丢弃已经发送过的帧
+	pending        bool
+	resuming       bool
+	target         videoPosition
+	targetPTS      int64 // target 换算到解码时间基
+	keyframe       bool  // seek 之后是否已经收到关键帧
+	droppedPackets int
+	dropped        int
+
+	// last 由发送协程写入，退出时由 Close 读取
+	mu        sync.Mutex
+	last      videoPosition
+	hasLast   bool
+	lastSaved time.Time
+	saveErrs  int
+}
+
+// NewVideoResume 创建位置记录，文件为 <sessionDir>/video_position.json；videoPath 为源文件的绝对路径
+func NewVideoResume(sessionDir, videoPath string) *VideoResume {
+	return &VideoResume{path: filepath.Join(sessionDir, "video_position.json"), videoPath: videoPath}
+}
+
+// Load 读取上次保存的位置（-resume），之后的 initVideoSource 会定位到该位置；文件不存在时从头开始
+func (r *VideoResume) Load() error {
+	if r == nil {
+		return nil
+	}
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "[GCC] -resume: no saved position in %s, starting from the beginning\n", r.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var position videoPosition
+	if err := json.Unmarshal(data, &position); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", r.path, err)
+	}
+	if position.Video != r.videoPath {
+		return fmt.Errorf("%s was saved for %s, not %s", r.path, position.Video, r.videoPath)
+	}
+	if position.TimeBaseNum <= 0 || position.TimeBaseDen <= 0 {
+		return fmt.Errorf("%s has an invalid time base %d/%d", r.path, position.TimeBaseNum, position.TimeBaseDen)
+	}
+	r.target, r.pending = position, true
+	return nil
+}
+
+// Seek 在 initVideoSource 打开解码器之后调用：把输入定位到保存位置之前最近的关键帧。只在第一次调用时生效（-watch 重新加载时不再定位）
+func (r *VideoResume) Seek() error {
+	if r == nil || !r.pending {
+		return nil
+	}
+	r.pending = false
+	r.targetPTS = astiav.RescaleQ(r.target.PTS, astiav.NewRational(r.target.TimeBaseNum, r.target.TimeBaseDen), videoStream.TimeBase())
+	if err := inputFormatContext.SeekFrame(videoStream.Index(), r.targetPTS, astiav.NewSeekFlags(astiav.SeekFlagBackward)); err != nil {
+		return err
+	}
+	r.resuming = true
+	fmt.Fprintf(os.Stderr, "[GCC] Resuming %s after %.3fs (saved %s)\n", r.videoPath, r.target.Seconds, r.target.UpdatedAt)
+	return nil
+}
+
+// SkipPacket 在恢复期间丢弃关键帧之前的视频包：seek 落在非关键帧上时，解码器从下一个关键帧开始
+func (r *VideoResume) SkipPacket(pkt *astiav.Packet) bool {
+	if r == nil || !r.resuming || r.keyframe {
+		return false
+	}
+	if !pkt.Flags().Has(astiav.PacketFlagKey) {
+		r.droppedPackets++
+		return true
+	}
+	r.keyframe = true
+	return false
+}
+
+// SkipFrame 在恢复期间丢弃 PTS 不晚于保存位置的解码帧（解码时间基），到达保存位置之后的第一帧时结束恢复
+func (r *VideoResume) SkipFrame(pts int64) bool {
+	if r == nil || !r.resuming {
+		return false
+	}
+	if pts <= r.targetPTS {
+		r.dropped++
+		return true
+	}
+	r.resuming = false
+	fmt.Fprintf(os.Stderr, "[GCC] Resumed at %.3fs: %d packets before the keyframe and %d decoded frames discarded\n",
+		r.seconds(pts), r.droppedPackets, r.dropped)
+	return false
+}
+
+// Cancel 在源位置跳变（-loop 回到开头、-watch 重新加载、控制命令 seek）时取消尚未完成的恢复
+func (r *VideoResume) Cancel() {
+	if r == nil || !r.resuming {
+		return
+	}
+	r.resuming = false
+	fmt.Fprintf(os.Stderr, "[GCC] Warning: resume to %.3fs interrupted by a source seek after %d discarded frames\n", r.target.Seconds, r.dropped)
+}
+
+// Save 记录已送入编码器的一帧的源 PTS（解码时间基），最多每 resumeSaveInterval 写一次文件
+func (r *VideoResume) Save(pts int64) {
+	if r == nil {
+		return
+	}
+	tb := videoStream.TimeBase()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = videoPosition{Video: r.videoPath, PTS: pts, TimeBaseNum: tb.Num(), TimeBaseDen: tb.Den(), Seconds: r.seconds(pts)}
+	r.hasLast = true
+	if now := time.Now(); now.Sub(r.lastSaved) >= resumeSaveInterval {
+		r.lastSaved = now
+		r.write()
+	}
+}
+
+// Close 在退出时写入最后记录的位置
+func (r *VideoResume) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.hasLast {
+		return
+	}
+	r.write()
+	if r.saveErrs == 0 {
+		fmt.Fprintf(os.Stderr, "[GCC] Video position %.3fs saved to %s (restart with -resume to continue from there)\n", r.last.Seconds, r.path)
+	}
+}
+
+// seconds 把源 PTS 换算为相对流起点的秒数
+func (r *VideoResume) seconds(pts int64) float64 {
+	if start := videoStream.StartTime(); start != astiav.NoPtsValue {
+		pts -= start
+	}
+	return float64(pts) * videoStream.TimeBase().Float64()
+}
+
+// write 以临时文件 + rename 写入位置文件，只打印第一次失败
+func (r *VideoResume) write() {
+	r.last.UpdatedAt = time.Now().Format(time.RFC3339)
+	data, err := json.MarshalIndent(r.last, "", "  ")
+	if err == nil {
+		tmp := r.path + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, r.path)
+		}
+	}
+	if err != nil {
+		r.saveErrs++
+		if r.saveErrs == 1 {
+			fmt.Fprintf(os.Stderr, "Error saving video position: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// retransmit.go - 基础 server 的 NACK 重传缓存（-nack-cache）
+//
+// 说明：
+//   - 基础 server 原来从不读取 RTCP，client 的 NACK 没有人处理，丢包只能等 PLI 触发的关键帧恢复
+//   - 以 interceptor 的形式按 RTP 序列号缓存发出的视频包，只保留最近 -nack-cache（默认 500ms）内的包，超过的包重传也来不及播放
+//   - 收到 TransportLayerNack 时从缓存中取出被请求的包，用原 SSRC 与原序列号重发（不使用 rtx 流）；已经移出缓存的包计为未命中
+//   - 代替 pion 默认的 NACK responder（按包数固定缓存 1024 个包），其余默认 interceptor（RTCP 报告、TWCC 等）不变
+//   - 接收方向的 RTCP 只有在应用层持续读取 RTPSender 时才会经过 interceptor
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// retransmitCache 在基础 server 的 -nack-cache > 0 时非 nil
+var retransmitCache *RetransmitCache
+
+// cachedRTPPacket 是缓存中的一个已发送的包
+type cachedRTPPacket struct {
+	header  rtp.Header
+	payload []byte
+	sentAt  time.Time
+}
+
+// retransmitStream 是一个视频流的缓存：packets 按序列号索引，order 按发送顺序用于按时间淘汰
+type retransmitStream struct {
+	writer  interceptor.RTPWriter
+	packets map[uint16]*cachedRTPPacket
+	order   []*cachedRTPPacket
+}
+
+// RetransmitCache 保存所有视频流的重传缓存与统计，方法对 nil 安全
+type RetransmitCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	streams map[uint32]*retransmitStream
+
+	nacks         int // 收到的 NACK 数
+	requested     int // NACK 请求的包数
+	retransmitted int // 从缓存中重发的包数
+	missed        int // 已经移出缓存（或从未发送）的包数
+}
+
+// NewRetransmitCache 创建缓存，ttl 为包在缓存中保留的时长
+func NewRetransmitCache(ttl time.Duration) *RetransmitCache {
+	return &RetransmitCache{ttl: ttl, streams: make(map[uint32]*retransmitStream)}
+}
+
+// newAPI 创建与 webrtc.NewAPI(webrtc.WithSettingEngine(...)) 等价的 API，只是不注册 pion 默认的 NACK responder：
+// 缓存非 nil 时由它代替 responder，为 nil（-nack-cache 0）时 client 的 NACK 不被处理，丢包只能等 PLI 关键帧恢复
+func (c *RetransmitCache) newAPI(settingEngine webrtc.SettingEngine) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	// 默认编解码器的视频 RTCP 反馈已经包含 nack 与 nack pli
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+	registry := &interceptor.Registry{}
+	if c != nil {
+		// 与 ConfigureNack 中的 responder 位置相同：先注册，位于发送链的最内层
+		registry.Add(&retransmitInterceptorFactory{cache: c})
+	}
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return nil, fmt.Errorf("failed to configure RTCP reports: %w", err)
+	}
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, fmt.Errorf("failed to configure simulcast header extensions: %w", err)
+	}
+	if err := webrtc.ConfigureStatsInterceptor(registry); err != nil {
+		return nil, fmt.Errorf("failed to configure stats interceptor: %w", err)
+	}
+	if err := webrtc.ConfigureTWCCSender(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("failed to configure TWCC sender: %w", err)
+	}
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	), nil
+}
+
+// bind 为一个视频流创建缓存
+func (c *RetransmitCache) bind(ssrc uint32, writer interceptor.RTPWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.streams[ssrc] = &retransmitStream{writer: writer, packets: make(map[uint16]*cachedRTPPacket)}
+}
+
+// unbind 删除一个视频流的缓存
+func (c *RetransmitCache) unbind(ssrc uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.streams, ssrc)
+}
+
+// store 缓存一个发出的包，并淘汰超过 ttl 的包
+func (c *RetransmitCache) store(ssrc uint32, header *rtp.Header, payload []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.streams[ssrc]
+	if s == nil {
+		return
+	}
+	pkt := &cachedRTPPacket{header: header.Clone(), payload: append([]byte(nil), payload...), sentAt: now}
+	s.packets[header.SequenceNumber] = pkt
+	s.order = append(s.order, pkt)
+
+	expired := 0
+	for _, old := range s.order {
+		if now.Sub(old.sentAt) <= c.ttl {
+			break
+		}
+		// 序列号回绕后同一个序列号可能已经被新的包占用
+		if s.packets[old.header.SequenceNumber] == old {
+			delete(s.packets, old.header.SequenceNumber)
+		}
+		expired++
+	}
+	if expired > 0 {
+		s.order = append(s.order[:0], s.order[expired:]...)
+	}
+}
+
+// handleNack 重发一个 NACK 请求的包
+func (c *RetransmitCache) handleNack(nack *rtcp.TransportLayerNack, now time.Time) {
+	var resend []*cachedRTPPacket
+	c.mu.Lock()
+	s := c.streams[nack.MediaSSRC]
+	if s == nil {
+		c.mu.Unlock()
+		return
+	}
+	writer := s.writer
+	c.nacks++
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			c.requested++
+			if pkt := s.packets[seq]; pkt != nil && now.Sub(pkt.sentAt) <= c.ttl {
+				resend = append(resend, pkt)
+			} else {
+				c.missed++
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	sent := 0
+	for _, pkt := range resend {
+		if _, err := writer.Write(&pkt.header, pkt.payload, nil); err != nil {
+			reportRecoverableError("Error retransmitting packet", fmt.Errorf("seq %d: %w", pkt.header.SequenceNumber, err))
+			break
+		}
+		sent++
+	}
+	c.mu.Lock()
+	c.retransmitted += sent
+	c.mu.Unlock()
+}
+
+// Report 在 session 结束时输出 NACK 与重传的统计
+func (c *RetransmitCache) Report() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nacks == 0 {
+		fmt.Fprintf(os.Stderr, "NACK retransmission: no NACKs received\n")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "NACK retransmission: %d NACKs requesting %d packets, %d retransmitted from the %v cache, %d no longer cached\n",
+		c.nacks, c.requested, c.retransmitted, c.ttl, c.missed)
+}
+
+// retransmitInterceptorFactory 为每个 PeerConnection 创建 retransmitInterceptor
+type retransmitInterceptorFactory struct {
+	cache *RetransmitCache
+}
+
+// NewInterceptor 实现 interceptor.Factory
+func (f *retransmitInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &retransmitInterceptor{cache: f.cache}, nil
+}
+
+// retransmitInterceptor 缓存发出的视频包并响应 NACK
+type retransmitInterceptor struct {
+	interceptor.NoOp
+	cache *RetransmitCache
+}
+
+// BindLocalStream 只缓存协商了 nack 的视频流
+func (i *retransmitInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !strings.HasPrefix(strings.ToLower(info.MimeType), "video/") || !hasNackFeedback(info) {
+		return writer
+	}
+	i.cache.bind(info.SSRC, writer)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		// rtx 等其它 SSRC 的包不缓存
+		if header.SSRC == info.SSRC {
+			i.cache.store(info.SSRC, header, payload, time.Now())
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// UnbindLocalStream 删除流的缓存
+func (i *retransmitInterceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	i.cache.unbind(info.SSRC)
+}
+
+// BindRTCPReader 响应收到的 TransportLayerNack
+func (i *retransmitInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		if pkts, pErr := attr.GetRTCPPackets(b[:n]); pErr == nil {
+			now := time.Now()
+			for _, pkt := range pkts {
+				if nack, ok := pkt.(*rtcp.TransportLayerNack); ok {
+					i.cache.handleNack(nack, now)
+				}
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+// readSenderRTCP 持续读取 RTPSender 上的 RTCP，使 NACK 经过 retransmitInterceptor；连接关闭后返回
+func readSenderRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// hasNackFeedback 判断流是否协商了通用 NACK（"nack" 且没有参数；"nack pli" 是关键帧请求）
+func hasNackFeedback(info *interceptor.StreamInfo) bool {
+	for _, fb := range info.RTCPFeedback {
+		if fb.Type == "nack" && fb.Parameter == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// rtcp_logger.go - 记录所有收发的 RTCP 包（-rtcp-log）
+//
+// 说明：
+//   - 以 interceptor 的形式挂在 interceptor 链的最内层（最靠近网络），
+//     因此其它 interceptor 生成的 RR / NACK / TWCC 以及应用层发送的 PLI 都能被看到
+//   - 每个 RTCP 包（复合包拆开后）写一行到 rtcp_server.csv / rtcp_client.csv，包含时间戳、方向、类型和解析后的字段
+//   - 接收方向只有在应用层持续调用 ReadRTCP（或 RTPSender.Read）时才会经过 interceptor
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// RTCPLogger 是一个线程安全的 RTCP CSV 写入器
+type RTCPLogger struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewRTCPLogger 创建一个新的 RTCP 日志写入器
+func NewRTCPLogger(csvPath string) (*RTCPLogger, error) {
+	if csvPath == "" {
+		return nil, fmt.Errorf("csvPath is empty")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create rtcp log directory: %w", err)
+	}
+
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rtcp log csv: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	header := []string{
+		"unix_ms",
+		"direction", // sent / received
+		"type",
+		"sender_ssrc",
+		"media_ssrc",
+		"details", // 解析后的字段，空格分隔的 key=value
+	}
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write rtcp log header: %w", err)
+	}
+	w.Flush()
+
+	return &RTCPLogger{
+		writer: w,
+		file:   f,
+	}, nil
+}
+
+// Log 记录一组 RTCP 包
+func (l *RTCPLogger) Log(direction string, pkts []rtcp.Packet) {
+	if l == nil || len(pkts) == 0 {
+		return
+	}
+
+	now := time.Now().UnixMilli()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.writer == nil {
+		return
+	}
+
+	for _, pkt := range pkts {
+		typ, senderSSRC, mediaSSRC, details := describeRTCP(pkt)
+		record := []string{
+			fmt.Sprintf("%d", now),
+			direction,
+			typ,
+			senderSSRC,
+			mediaSSRC,
+			details,
+		}
+		if err := l.writer.Write(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing rtcp log CSV: %v\n", err)
+			return
+		}
+	}
+	l.writer.Flush()
+}
+
+// Close 关闭底层文件句柄
+func (l *RTCPLogger) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.writer != nil {
+		l.writer.Flush()
+	}
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing rtcp log CSV file: %v\n", err)
+		}
+	}
+	// 连接关闭过程中 interceptor 仍可能调用 Log，关闭后忽略
+	l.writer = nil
+	l.file = nil
+}
+
+// describeRTCP 把一个 RTCP 包转换为 (类型, sender ssrc, media ssrc, 字段描述)
+func describeRTCP(pkt rtcp.Packet) (typ, senderSSRC, mediaSSRC, details string) {
+	ssrc := func(v uint32) string { return fmt.Sprintf("%d", v) }
+
+	switch p := pkt.(type) {
+	case *rtcp.SenderReport:
+		return "SR", ssrc(p.SSRC), "", fmt.Sprintf("ntp=%d rtp_ts=%d packets=%d octets=%d%s",
+			p.NTPTime, p.RTPTime, p.PacketCount, p.OctetCount, describeReceptionReports(p.Reports))
+	case *rtcp.ReceiverReport:
+		return "RR", ssrc(p.SSRC), "", strings.TrimSpace(describeReceptionReports(p.Reports))
+	case *rtcp.PictureLossIndication:
+		return "PLI", ssrc(p.SenderSSRC), ssrc(p.MediaSSRC), ""
+	case *rtcp.FullIntraRequest:
+		return "FIR", ssrc(p.SenderSSRC), ssrc(p.MediaSSRC), ""
+	case *rtcp.TransportLayerNack:
+		var lost []string
+		for i := range p.Nacks {
+			for _, seq := range p.Nacks[i].PacketList() {
+				lost = append(lost, fmt.Sprintf("%d", seq))
+			}
+		}
+		return "NACK", ssrc(p.SenderSSRC), ssrc(p.MediaSSRC), fmt.Sprintf("lost=%s", strings.Join(lost, ";"))
+	case *rtcp.ReceiverEstimatedMaximumBitrate:
+		ssrcs := make([]string, 0, len(p.SSRCs))
+		for _, s := range p.SSRCs {
+			ssrcs = append(ssrcs, ssrc(s))
+		}
+		return "REMB", ssrc(p.SenderSSRC), strings.Join(ssrcs, ";"), fmt.Sprintf("bitrate_bps=%.0f", p.Bitrate)
+	case *rtcp.TransportLayerCC:
+		return "TWCC", ssrc(p.SenderSSRC), ssrc(p.MediaSSRC), fmt.Sprintf("base_seq=%d status_count=%d ref_time=%d fb_count=%d",
+			p.BaseSequenceNumber, p.PacketStatusCount, p.ReferenceTime, p.FbPktCount)
+	case *rtcp.SourceDescription:
+		var items []string
+		for _, chunk := range p.Chunks {
+			for _, item := range chunk.Items {
+				items = append(items, fmt.Sprintf("%d:%s=%s", chunk.Source, item.Type, item.Text))
+			}
+		}
+		return "SDES", "", "", strings.Join(items, " ")
+	case *rtcp.Goodbye:
+		sources := make([]string, 0, len(p.Sources))
+		for _, s := range p.Sources {
+			sources = append(sources, ssrc(s))
+		}
+		return "BYE", "", strings.Join(sources, ";"), p.Reason
+	default:
+		return fmt.Sprintf("%T", pkt), "", "", ""
+	}
+}
+
+// describeReceptionReports 把 SR/RR 中的 reception report 转换为字段描述
+func describeReceptionReports(reports []rtcp.ReceptionReport) string {
+	var b strings.Builder
+	for _, r := range reports {
+		fmt.Fprintf(&b, " [ssrc=%d fraction_lost=%d total_lost=%d last_seq=%d jitter=%d lsr=%d dlsr=%d]",
+			r.SSRC, r.FractionLost, r.TotalLost, r.LastSequenceNumber, r.Jitter, r.LastSenderReport, r.Delay)
+	}
+	return b.String()
+}
+
+// rtcpLogInterceptorFactory 为每个 PeerConnection 创建 rtcpLogInterceptor
+type rtcpLogInterceptorFactory struct {
+	logger *RTCPLogger
+}
+
+// NewInterceptor 实现 interceptor.Factory
+func (f *rtcpLogInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &rtcpLogInterceptor{logger: f.logger}, nil
+}
+
+// rtcpLogInterceptor 只观察 RTCP，不修改任何数据
+type rtcpLogInterceptor struct {
+	interceptor.NoOp
+	logger *RTCPLogger
+}
+
+// BindRTCPReader 记录收到的 RTCP
+func (i *rtcpLogInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		if pkts, pErr := attr.GetRTCPPackets(b[:n]); pErr == nil {
+			i.logger.Log("received", pkts)
+		}
+		return n, attr, nil
+	})
+}
+
+// BindRTCPWriter 记录发送的 RTCP
+func (i *rtcpLogInterceptor) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		i.logger.Log("sent", pkts)
+		return writer.Write(pkts, attributes)
+	})
+}
+
+// newWebRTCAPI 创建与 webrtc.NewAPI(webrtc.WithSettingEngine(...)) 等价的 API，并总是为视频注册 abs-send-time 扩展
+// 与读写它的 interceptor（最先注册，位于链的最内层，见 abs_send_time.go）。
+// rtcpLogger 非 nil 时，在默认 interceptor 之前注册 RTCP 日志 interceptor（位于链的最内层）；
+// sentRTPTimestamps 非 nil 时，在默认 interceptor 之后注册记录视频 RTP 时间戳的 interceptor（见 frame_metadata.go）；
+// bitrateEstimator 非 nil 时，在 RTCP 日志之后、默认 interceptor 之前注册带宽估计的 interceptor（见 bitrate_estimator.go）；
+// -compat 开启时只注册兼容的编解码器（见 h264_compat.go）；client 中默认 interceptor 不含 NACK generator（见 nack_sender.go）。
+func newWebRTCAPI(settingEngine webrtc.SettingEngine, rtcpLogger *RTCPLogger) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := registerCodecs(mediaEngine); err != nil {
+		return nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+
+	registry := &interceptor.Registry{}
+	if err := registerAbsSendTime(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("failed to register abs-send-time header extension: %w", err)
+	}
+	if rtcpLogger != nil {
+		registry.Add(&rtcpLogInterceptorFactory{logger: rtcpLogger})
+	}
+	if bitrateEstimator != nil {
+		if err := bitrateEstimator.register(mediaEngine, registry); err != nil {
+			return nil, err
+		}
+	}
+	if err := registerDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+	// 注册在默认 interceptor 之后，位于发送链的最外层：只看到轨道写入的包，看不到 NACK 重传
+	if sentRTPTimestamps != nil {
+		registry.Add(&rtpTimestampInterceptorFactory{recorder: sentRTPTimestamps})
+	}
+
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	), nil
+}
+
+// newSDPCapabilitiesAPI 是 -print-sdp-capabilities 使用的 newWebRTCAPI：RTCP 日志不影响协商，不需要 logger
+func newSDPCapabilitiesAPI(settingEngine webrtc.SettingEngine) (*webrtc.API, error) {
+	return newWebRTCAPI(settingEngine, nil)
+}
+
+// drainSenderRTCP 持续读取 RTPSender 上的 RTCP，使接收方向的 RTCP 经过 interceptor（从而被记录）。
+// 视频发送端收到的 Receiver Report 同时用于 -stats-interval 的 RTT 与丢包率，PLI / FIR 交给 keyframeRequests，
+// TWCC / REMB 交给 bitrateEstimator，TWCC 同时交给 frameDispersion。连接关闭后返回。
+func drainSenderRTCP(sender *webrtc.RTPSender) {
+	isVideo := sender.Track() != nil && sender.Track().Kind() == webrtc.RTPCodecTypeVideo
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := sender.Read(buf)
+		if err != nil {
+			return
+		}
+		if !isVideo || (healthStats == nil && keyframeRequests == nil && bitrateEstimator == nil && lossFeedback == nil && frameDispersion == nil) {
+			continue
+		}
+		// 解析失败只影响健康统计、关键帧请求、带宽估计与丢包反馈，不能中断读取（NACK 重传与 cc interceptor 都依赖持续读取）
+		if pkts, err := rtcp.Unmarshal(buf[:n]); err == nil {
+			now := time.Now()
+			healthStats.ObserveRTCP(pkts, now)
+			keyframeRequests.Observe(pkts, now)
+			bitrateEstimator.Observe(pkts, now)
+			lossFeedback.Observe(pkts)
+			frameDispersion.Observe(pkts)
+		}
+	}
+}
+
+// logRTXNegotiation 输出每个视频发送端协商到的 rtx 重传流（RFC 4588）。
+// 对端 answer 中没有 rtx 时 pion 会在本地禁用 rtx，NACK 重传退化为直接用原 SSRC 重发。
+func logRTXNegotiation(pc *webrtc.PeerConnection) {
+	for _, sender := range pc.GetSenders() {
+		track := sender.Track()
+		if track == nil || track.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		params := sender.GetParameters()
+		if len(params.Encodings) == 0 || params.Encodings[0].RTX.SSRC == 0 {
+			fmt.Fprintf(os.Stderr, "RTX not negotiated for track %s: NACKed packets are resent on the media SSRC\n", track.ID())
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "RTX negotiated for track %s: media SSRC %d, rtx SSRC %d\n",
+			track.ID(), params.Encodings[0].SSRC, params.Encodings[0].RTX.SSRC)
+	}
+}
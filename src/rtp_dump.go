@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// rtp_dump.go - 接收到的视频 RTP 包的原始记录（client 的 -dump-rtp）
+//
+// 说明：
+//   - 按到达顺序记录每个视频 RTP 包（序列化后的完整 RTP 包）及到达时间，供 -replay-metadata 离线重放，
+//     不必重新跑网络实验就能用不同的参数重新计算指标
+//   - 文件格式（大端）：8 字节魔数 "VTDRTP01"，float64 帧率，int64 client 开始接收的时间（Unix 纳秒）；之后每个包一条记录：
+//     int64 到达时间（Unix 纳秒）、uint8 标志（bit0 = rtx 还原的重传包）、uint16 长度、RTP 包
+//   - 只在接收协程中写入
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const rtpDumpMagic = "VTDRTP01"
+
+// rtpDumpFlagRTX 表示记录的包由 rtx 重传流还原而来
+const rtpDumpFlagRTX = 0x01
+
+// rtpDump 在 -dump-rtp 开启时非 nil，由 writeH264ToFile 对每个视频 RTP 包调用
+var rtpDump *RTPDumpWriter
+
+// RTPDumpWriter 写入 RTP dump 文件，方法对 nil 安全
+type RTPDumpWriter struct {
+	file    *os.File
+	writer  *bufio.Writer
+	started bool
+	packets int
+	failed  bool
+}
+
+// NewRTPDumpWriter 创建 dump 文件；文件头在 Begin 时（帧率已知后）写入
+func NewRTPDumpWriter(path string) (*RTPDumpWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create RTP dump: %w", err)
+	}
+	return &RTPDumpWriter{file: f, writer: bufio.NewWriterSize(f, 64*1024)}, nil
+}
+
+// Begin 写入文件头，只有第一次调用生效；start 为开始接收的时间，重放时作为没有 server 开始时间时的指标基准
+func (d *RTPDumpWriter) Begin(frameRate float64, start time.Time) {
+	if d == nil || d.started {
+		return
+	}
+	d.started = true
+	var header [24]byte
+	copy(header[:8], rtpDumpMagic)
+	binary.BigEndian.PutUint64(header[8:16], math.Float64bits(frameRate))
+	binary.BigEndian.PutUint64(header[16:24], uint64(start.UnixNano()))
+	if _, err := d.writer.Write(header[:]); err != nil {
+		d.fail(err)
+	}
+}
+
+// WritePacket 追加一条记录；写入失败后不再继续记录
+func (d *RTPDumpWriter) WritePacket(pkt *rtp.Packet, rtx bool, arrival time.Time) {
+	if d == nil || !d.started || d.failed {
+		return
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to marshal RTP packet for dump: %v\n", err)
+		return
+	}
+	var header [11]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(arrival.UnixNano()))
+	if rtx {
+		header[8] = rtpDumpFlagRTX
+	}
+	binary.BigEndian.PutUint16(header[9:11], uint16(len(data)))
+	if _, err = d.writer.Write(header[:]); err == nil {
+		_, err = d.writer.Write(data)
+	}
+	if err != nil {
+		d.fail(err)
+		return
+	}
+	d.packets++
+}
+
+func (d *RTPDumpWriter) fail(err error) {
+	d.failed = true
+	fmt.Fprintf(os.Stderr, "Error writing RTP dump, recording stopped: %v\n", err)
+}
+
+// Close 刷新并关闭文件
+func (d *RTPDumpWriter) Close() {
+	if d == nil {
+		return
+	}
+	if err := d.writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error flushing RTP dump: %v\n", err)
+	}
+	if err := d.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing RTP dump file: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "RTP dump: %d packets written to %s\n", d.packets, d.file.Name())
+}
+
+// RTPDumpReader 按顺序读取 RTP dump 文件
+type RTPDumpReader struct {
+	reader    *bufio.Reader
+	FrameRate float64
+	StartTime time.Time
+}
+
+// NewRTPDumpReader 校验文件头并读出帧率与开始时间
+func NewRTPDumpReader(r io.Reader) (*RTPDumpReader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var header [24]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read RTP dump header: %w", err)
+	}
+	if string(header[:8]) != rtpDumpMagic {
+		return nil, fmt.Errorf("not an RTP dump file (bad magic %q)", header[:8])
+	}
+	return &RTPDumpReader{
+		reader:    br,
+		FrameRate: math.Float64frombits(binary.BigEndian.Uint64(header[8:16])),
+		StartTime: time.Unix(0, int64(binary.BigEndian.Uint64(header[16:24]))),
+	}, nil
+}
+
+// Next 返回下一个包；文件结束时返回 io.EOF，最后一条记录不完整时返回 io.ErrUnexpectedEOF
+func (r *RTPDumpReader) Next() (*rtp.Packet, bool, time.Time, error) {
+	var header [11]byte
+	if _, err := io.ReadFull(r.reader, header[:]); err != nil {
+		return nil, false, time.Time{}, err
+	}
+	arrival := time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8])))
+	rtx := header[8]&rtpDumpFlagRTX != 0
+	data := make([]byte, binary.BigEndian.Uint16(header[9:11]))
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, false, time.Time{}, err
+	}
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(data); err != nil {
+		return nil, false, time.Time{}, fmt.Errorf("invalid RTP packet in dump: %w", err)
+	}
+	return pkt, rtx, arrival, nil
+}
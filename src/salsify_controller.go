@@ -13,18 +13,9 @@ import (
 	"time"
 )
 
-// SalsifyObservation 表示一帧的发送观测数据（仅发送侧，客户端反馈暂未接入）。
-type SalsifyObservation struct {
-	FrameID      int
-	SentBits     int
-	SendStart    time.Time
-	SendEnd      time.Time
-	LossDetected bool
-}
-
 // SalsifyConfig 控制器配置。
 type SalsifyConfig struct {
-	FrameInterval time.Duration // 期望帧间隔，例如 1/30s
+	FrameInterval time.Duration // 期望帧间隔（源帧率的倒数）
 
 	LatencyTarget time.Duration // 目标排队+传输延迟上限（目前仅用于未来扩展）
 
@@ -42,17 +33,19 @@ type SalsifyController struct {
 
 	cfg SalsifyConfig
 
-	observations []SalsifyObservation
+	observations []FrameObservation // 仅发送侧的观测，客户端反馈暂未接入
 
 	// 派生统计
 	avgThroughputBitsPerSec float64
 	lossRate                float64
+	sendPressureRate        float64 // 窗口内发送缓冲区受压的帧比例
+	networkBps              float64 // 基于接收端 RTCP 反馈的带宽估计（-rtcp-bwe），0 表示没有
 }
 
 // NewSalsifyController 创建一个新的控制器实例。
 func NewSalsifyController(cfg SalsifyConfig) *SalsifyController {
 	if cfg.FrameInterval <= 0 {
-		cfg.FrameInterval = time.Second / 30
+		cfg.FrameInterval = time.Second / defaultFrameRateFPS
 	}
 	if cfg.SafetyMargin <= 0 || cfg.SafetyMargin > 1 {
 		cfg.SafetyMargin = 0.7
@@ -66,12 +59,12 @@ func NewSalsifyController(cfg SalsifyConfig) *SalsifyController {
 
 	return &SalsifyController{
 		cfg:          cfg,
-		observations: make([]SalsifyObservation, 0, cfg.WindowSize),
+		observations: make([]FrameObservation, 0, cfg.WindowSize),
 	}
 }
 
-// UpdateStats 记录一帧的发送观测，并更新滑动窗口统计。
-func (c *SalsifyController) UpdateStats(obs SalsifyObservation) {
+// UpdateStats 实现 RateController：记录一帧的发送观测，并更新滑动窗口统计。
+func (c *SalsifyController) UpdateStats(obs FrameObservation) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -84,6 +77,7 @@ func (c *SalsifyController) UpdateStats(obs SalsifyObservation) {
 	var totalBits int64
 	var totalDurationSec float64
 	var lossCount int
+	var blockedCount int
 
 	for _, o := range c.observations {
 		totalBits += int64(o.SentBits)
@@ -96,6 +90,9 @@ func (c *SalsifyController) UpdateStats(obs SalsifyObservation) {
 		if o.LossDetected {
 			lossCount++
 		}
+		if o.SendBlocked {
+			blockedCount++
+		}
 	}
 
 	if totalDurationSec > 0 {
@@ -104,19 +101,75 @@ func (c *SalsifyController) UpdateStats(obs SalsifyObservation) {
 
 	if len(c.observations) > 0 {
 		c.lossRate = float64(lossCount) / float64(len(c.observations))
+		c.sendPressureRate = float64(blockedCount) / float64(len(c.observations))
 	}
 }
 
-// NextFrameBudget 估计下一帧可用的 bit 预算（工程近似版）。
-// 思路：
-//   - 以滑动窗口平均吞吐 * 帧间隔 * SafetyMargin 作为预算；
-//   - 当 lossRate 较高时进一步降低预算。
-func (c *SalsifyController) NextFrameBudget() int {
+// ThroughputEstimate 返回滑动窗口平均吞吐（bit/s），还没有观测时为 0。
+func (c *SalsifyController) ThroughputEstimate() float64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.avgThroughputBitsPerSec
+}
 
+// OnNetworkEstimate 设置基于接收端 RTCP 反馈的带宽估计（见 bitrate_estimator.go）。
+// bps > 0 时 NextFrameBudget 用它代替滑动窗口平均发送吞吐，<= 0（还没有反馈）时沿用发送吞吐。
+func (c *SalsifyController) OnNetworkEstimate(bps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networkBps = bps
+}
+
+// State 返回控制器状态的快照，窗口统计为最近 WindowSize 帧的发送大小。
+func (c *SalsifyController) State() ControllerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	budgetBits := c.budget()
+
+	s := ControllerState{
+		CapacityBps:  c.avgThroughputBitsPerSec,
+		EstimateBps:  c.networkBps,
+		BudgetBits:   budgetBits,
+		WindowFrames: len(c.observations),
+		LossRate:     c.lossRate,
+		SendPressure: c.sendPressureRate,
+	}
+	if n := len(c.observations); n > 0 {
+		var sum float64
+		for _, o := range c.observations {
+			sum += float64(o.SentBits)
+		}
+		s.WindowMeanBits = sum / float64(n)
+		if n > 1 {
+			var varianceSum float64
+			for _, o := range c.observations {
+				diff := float64(o.SentBits) - s.WindowMeanBits
+				varianceSum += diff * diff
+			}
+			s.WindowVarBits = varianceSum / float64(n-1)
+		}
+	}
+	return s
+}
+
+// NextFrameBudget 实现 RateController：返回下一帧可用的 bit 预算，选中的候选编码后立即发出（发送时长为 0）。
+func (c *SalsifyController) NextFrameBudget() (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.budget(), 0
+}
+
+// budget 估计下一帧可用的 bit 预算（工程近似版），调用方需持有 c.mu。
+// 思路：
+//   - 以滑动窗口平均吞吐（-rtcp-bwe 有网络估计时改用网络估计）* 帧间隔 * SafetyMargin 作为预算；
+//   - 当 lossRate 较高时进一步降低预算；
+//   - 本地发送缓冲区受压时按受压帧比例降低预算（最多减半）。
+func (c *SalsifyController) budget() int {
 	// 如果还没有观测，就采用一个保守的初始预算，例如 500kbps * 1/30s。
 	throughput := c.avgThroughputBitsPerSec
+	if c.networkBps > 0 {
+		throughput = c.networkBps
+	}
 	if throughput <= 0 {
 		throughput = 500_000 // 500 kbps
 	}
@@ -133,6 +186,11 @@ func (c *SalsifyController) NextFrameBudget() int {
 		budget *= scale
 	}
 
+	// 发送缓冲区受压说明本地已经发不出去，不等 RTCP 反馈就回退
+	if c.sendPressureRate > 0 {
+		budget *= 1 - 0.5*c.sendPressureRate
+	}
+
 	if budget < 10_000 {
 		budget = 10_000
 	}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// scaler.go - 缩放上下文与解码帧格式的一致性检查（所有 server 以及 client 的 -output-raw-yuv 共用）
+//
+// 说明：
+//   - 缩放上下文在第一次编码前按解码器参数创建一次；而一个 packet 可能解出多帧，
+//     帧的分辨率 / 像素格式也可能在流中途变化（分辨率切换、拼接的源文件等）
+//   - 每帧缩放前比较源参数，不一致时就地更新（sws_getCachedContext），输出仍为编码器的分辨率与 YUV420P，
+//     因此编码器不需要重建
+//   - 输出分辨率变化时（实验 server 的 rescaleTo）用 recreateScaler 按旧上下文的源参数新建一个，成功后再释放旧的
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/asticode/go-astiav"
+)
+
+// ensureScalerSource 确保缩放上下文的源分辨率与像素格式与 frame 一致
+func ensureScalerSource(ssc *astiav.SoftwareScaleContext, frame *astiav.Frame) error {
+	width, height, format := frame.Width(), frame.Height(), frame.PixelFormat()
+	srcWidth, srcHeight := ssc.SourceResolution()
+	srcFormat := ssc.SourcePixelFormat()
+	if width == srcWidth && height == srcHeight && format == srcFormat {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Decoded frame format changed (%dx%d %s -> %dx%d %s), reconfiguring scaler\n",
+		srcWidth, srcHeight, srcFormat, width, height, format)
+	if err := ssc.SetSourceResolution(width, height); err != nil {
+		return fmt.Errorf("failed to set scaler source resolution: %w", err)
+	}
+	if err := ssc.SetSourcePixelFormat(format); err != nil {
+		return fmt.Errorf("failed to set scaler source pixel format: %w", err)
+	}
+	return nil
+}
+
+// recreateScaler 新建一个源参数、输出像素格式与缩放算法都与 ssc 相同，输出为 width x height 的缩放上下文；
+// ssc 不被修改，由调用方在替换成功后释放
+func recreateScaler(ssc *astiav.SoftwareScaleContext, width, height int) (*astiav.SoftwareScaleContext, error) {
+	srcWidth, srcHeight := ssc.SourceResolution()
+	next, err := astiav.CreateSoftwareScaleContext(
+		srcWidth,
+		srcHeight,
+		ssc.SourcePixelFormat(),
+		width,
+		height,
+		ssc.DestinationPixelFormat(),
+		ssc.Flags(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %dx%d scale context: %w", width, height, err)
+	}
+	return next, nil
+}
@@ -0,0 +1,346 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js && bridge
+// +build !js,bridge
+
+// sdp_bridge.go - 跨网络演示用的 HTTP / WebSocket SDP 中转（sdp-bridge）
+//
+// 说明：
+//   - 部署在 server 与 client 都能访问的机器上，按房间暂存 offer 和 answer，两端用 -signal-url 指向同一个房间
+//   - POST /<房间>/offer：保存 offer 并清除旧的 answer（同一房间开始新的会话）
+//   - GET /<房间>/offer：取得 offer，还没有时返回 404
+//   - POST /<房间>/answer：保存 answer，房间里没有 offer 时返回 409
+//   - GET /<房间>/answer：取得 answer 后删除整个房间（一次性），还没有时返回 404
+//   - /<房间>/ws：WebSocket（-signal-url ws://...），收发 {"kind", "payload"} JSON 消息；加入时推送房间里已有的 SDP，
+//     之后任一端（WebSocket 或 HTTP POST）存入的 SDP 立即推送给房间里的其他连接；answer 推送出去后同样删除房间
+//   - WebSocket 上还可以发送 "offer-candidate" / "answer-candidate"（-trickle 逐个发送的 ICE 候选）：转发给房间里的其他连接，
+//     offer 一端的候选同时暂存在房间里（最多 bridgeMaxCandidates 个），在推送 offer 之后依次推送给后加入的连接；新的 offer 清除旧的候选。
+//     answer 送达后房间已删除，之后的候选只转发给在线的连接（此时 server 一定在线）
+//   - 只在内存中保存，超过 -ttl 的房间被清除；不做鉴权，房间名相当于共享口令，演示时使用不易猜到的名字
+//   - 内容是 encode 得到的 base64 字符串，bridge 不解析，只检查长度
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// bridgeMaxBytes 是单个 SDP 的长度上限（与 http_signal.go 的 signalMaxBytes 一致）
+const bridgeMaxBytes = 1 << 16
+
+// bridgeMaxCandidates 是一个房间暂存的 ICE 候选数上限
+const bridgeMaxCandidates = 64
+
+// bridgeRoomPattern 限制房间名，避免路径穿越之类的歧义
+var bridgeRoomPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// bridgeRoom 是一个房间中暂存的 SDP
+type bridgeRoom struct {
+	offer   string
+	answer  string
+	updated time.Time
+
+	// candidates 是 offer 一端通过 WebSocket 发送的 ICE 候选（-trickle）
+	candidates []string
+}
+
+// bridgeMessage 是 WebSocket 上的一条 SDP 消息（与 ws_signal.go 的 signalMessage 相同）
+type bridgeMessage struct {
+	Kind    string `json:"kind"`
+	Payload string `json:"payload"`
+}
+
+// sdpBridge 保存所有房间
+type sdpBridge struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	rooms map[string]*bridgeRoom
+	// subscribers 是每个房间当前的 WebSocket 连接，不随房间一起过期
+	subscribers map[string]map[*websocket.Conn]bool
+}
+
+func main() {
+	listen := flag.String("listen", ":8080", "Address to listen on")
+	ttl := flag.Duration("ttl", 10*time.Minute, "Forget a room this long after its last update")
+	tlsCert := flag.String("tls-cert", "", "Serve HTTPS with this certificate file (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "Private key file for -tls-cert")
+	flag.Parse()
+
+	if *ttl <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -ttl must be > 0\n")
+		os.Exit(1)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fmt.Fprintf(os.Stderr, "Error: -tls-cert and -tls-key must be given together\n")
+		os.Exit(1)
+	}
+
+	b := &sdpBridge{
+		ttl:         *ttl,
+		rooms:       make(map[string]*bridgeRoom),
+		subscribers: make(map[string]map[*websocket.Conn]bool),
+	}
+	go b.expireLoop()
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           b,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Fprintf(os.Stderr, "SDP bridge listening on %s (rooms expire after %v)\n", *listen, *ttl)
+	var err error
+	if *tlsCert != "" {
+		err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
+
+// ServeHTTP 处理 /<房间>/offer、/<房间>/answer 与 /<房间>/ws
+func (b *sdpBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	room, kind, ok := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+	if !ok || !bridgeRoomPattern.MatchString(room) || (kind != "offer" && kind != "answer" && kind != "ws") {
+		http.Error(w, "expected /<room>/offer, /<room>/answer or /<room>/ws", http.StatusNotFound)
+		return
+	}
+	if kind == "ws" {
+		// 不检查 Origin：两端都是命令行程序，房间名才是口令
+		websocket.Server{Handler: func(conn *websocket.Conn) { b.serveWebSocket(room, conn) }}.ServeHTTP(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, bridgeMaxBytes+1))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > bridgeMaxBytes {
+			http.Error(w, "sdp too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		payload := strings.TrimSpace(string(body))
+		if payload == "" {
+			http.Error(w, "empty sdp", http.StatusBadRequest)
+			return
+		}
+		if status, msg := b.store(room, kind, payload); status != http.StatusNoContent {
+			http.Error(w, msg, status)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "[%s] %s stored (%d bytes) from %s\n", room, kind, len(payload), r.RemoteAddr)
+		b.publish(room, kind, payload, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodGet:
+		payload, found := b.load(room, kind)
+		if !found {
+			http.Error(w, kind+" not available yet", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "[%s] %s fetched by %s\n", room, kind, r.RemoteAddr)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, payload+"\n")
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// store 保存 offer / answer，返回 HTTP 状态码
+func (b *sdpBridge) store(room, kind, payload string) (int, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.rooms[room]
+	if kind == "offer" {
+		// 新的 offer 开始新的会话，旧的 answer 不再有效
+		b.rooms[room] = &bridgeRoom{offer: payload, updated: time.Now()}
+		return http.StatusNoContent, ""
+	}
+	if entry == nil || entry.offer == "" {
+		return http.StatusConflict, "no offer in this room"
+	}
+	entry.answer = payload
+	entry.updated = time.Now()
+	return http.StatusNoContent, ""
+}
+
+// storeCandidate 暂存 offer 一端的候选；房间不存在或已满时只转发不暂存
+func (b *sdpBridge) storeCandidate(room, kind, payload string) {
+	if kind != "offer-candidate" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.rooms[room]
+	if entry == nil || len(entry.candidates) >= bridgeMaxCandidates {
+		return
+	}
+	entry.candidates = append(entry.candidates, payload)
+	entry.updated = time.Now()
+}
+
+// candidates 返回房间暂存的 offer 一端的候选
+func (b *sdpBridge) candidates(room string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if entry := b.rooms[room]; entry != nil {
+		return append([]string(nil), entry.candidates...)
+	}
+	return nil
+}
+
+// load 取得 offer / answer；answer 被取走后整个房间删除
+func (b *sdpBridge) load(room, kind string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.rooms[room]
+	if entry == nil {
+		return "", false
+	}
+	if kind == "offer" {
+		return entry.offer, entry.offer != ""
+	}
+	if entry.answer == "" {
+		return "", false
+	}
+	delete(b.rooms, room)
+	return entry.answer, true
+}
+
+// serveWebSocket 处理一个 WebSocket 连接：先推送房间里已有的 SDP，之后把收到的 SDP 存入房间并转发给其他连接
+func (b *sdpBridge) serveWebSocket(room string, conn *websocket.Conn) {
+	conn.MaxPayloadBytes = bridgeMaxBytes + 1024
+	remote := conn.Request().RemoteAddr
+	fmt.Fprintf(os.Stderr, "[%s] websocket joined from %s\n", room, remote)
+
+	b.mu.Lock()
+	if b.subscribers[room] == nil {
+		b.subscribers[room] = make(map[*websocket.Conn]bool)
+	}
+	b.subscribers[room][conn] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subscribers[room], conn)
+		if len(b.subscribers[room]) == 0 {
+			delete(b.subscribers, room)
+		}
+		b.mu.Unlock()
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "[%s] websocket from %s closed\n", room, remote)
+	}()
+
+	// 后加入的一端（通常是 client）立即拿到 offer 与其后的候选；answer 已经存在时一并推送并删除房间
+	if offer, found := b.peek(room, "offer"); found {
+		b.send(room, conn, "offer", offer)
+		for _, candidate := range b.candidates(room) {
+			b.send(room, conn, "offer-candidate", candidate)
+		}
+		if answer, found := b.peek(room, "answer"); found && b.send(room, conn, "answer", answer) {
+			b.load(room, "answer")
+		}
+	}
+
+	for {
+		var msg bridgeMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+		payload := strings.TrimSpace(msg.Payload)
+		if (msg.Kind == "offer-candidate" || msg.Kind == "answer-candidate") && payload != "" {
+			b.storeCandidate(room, msg.Kind, payload)
+			b.publish(room, msg.Kind, payload, conn)
+			continue
+		}
+		if (msg.Kind != "offer" && msg.Kind != "answer") || payload == "" {
+			fmt.Fprintf(os.Stderr, "[%s] ignoring websocket message of kind %q (%d bytes) from %s\n", room, msg.Kind, len(payload), remote)
+			continue
+		}
+		if status, text := b.store(room, msg.Kind, payload); status != http.StatusNoContent {
+			fmt.Fprintf(os.Stderr, "[%s] rejected %s from %s: %s\n", room, msg.Kind, remote, text)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "[%s] %s stored (%d bytes) from %s (websocket)\n", room, msg.Kind, len(payload), remote)
+		b.publish(room, msg.Kind, payload, conn)
+	}
+}
+
+// publish 把新存入的 SDP 推送给房间里除 from 以外的 WebSocket 连接；answer 至少送达一个连接时删除房间（与 GET 一致）
+func (b *sdpBridge) publish(room, kind, payload string, from *websocket.Conn) {
+	b.mu.Lock()
+	var targets []*websocket.Conn
+	for conn := range b.subscribers[room] {
+		if conn != from {
+			targets = append(targets, conn)
+		}
+	}
+	b.mu.Unlock()
+
+	delivered := false
+	for _, conn := range targets {
+		if b.send(room, conn, kind, payload) {
+			delivered = true
+		}
+	}
+	if kind == "answer" && delivered {
+		b.load(room, "answer")
+	}
+}
+
+// send 向一个 WebSocket 连接推送 SDP，失败时关闭连接（接收循环随之退出）
+func (b *sdpBridge) send(room string, conn *websocket.Conn, kind, payload string) bool {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := websocket.JSON.Send(conn, bridgeMessage{Kind: kind, Payload: payload}); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] failed to push %s to %s: %v\n", room, kind, conn.Request().RemoteAddr, err)
+		conn.Close()
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "[%s] %s pushed to %s\n", room, kind, conn.Request().RemoteAddr)
+	return true
+}
+
+// peek 与 load 相同，但取得 answer 时不删除房间
+func (b *sdpBridge) peek(room, kind string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.rooms[room]
+	if entry == nil {
+		return "", false
+	}
+	if kind == "offer" {
+		return entry.offer, entry.offer != ""
+	}
+	return entry.answer, entry.answer != ""
+}
+
+// expireLoop 定期清除超过 ttl 没有更新的房间
+func (b *sdpBridge) expireLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.mu.Lock()
+		for room, entry := range b.rooms {
+			if time.Since(entry.updated) > b.ttl {
+				delete(b.rooms, room)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
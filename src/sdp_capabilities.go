@@ -0,0 +1,287 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// sdp_capabilities.go - 打印当前构建协商能力（-print-sdp-capabilities）
+//
+// 说明：
+//   - 用与 main 相同的 SettingEngine 设置和 API 构造函数（newWebRTCAPI / RetransmitCache.newAPI / NackSender.newAPI）
+//     创建 API，生成一个不发送出去的 offer，因此 -compat、-rtcp-bwe 等影响协商的参数同样反映在输出中
+//   - 从 offer 中提取每个 m= 段的编解码器（rtpmap/fmtp）、RTCP 反馈和头部扩展
+//   - 用于在不建立完整连接的情况下确认自定义编解码器注册是否生效
+//   - 同样的解析也用于 SetRemoteDescription 失败时对比 offer/answer（reportNegotiationFailure）
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// sdpCodecInfo 表示 offer 中某个 payload type 对应的编解码器信息
+type sdpCodecInfo struct {
+	payloadType int
+	rtpmap      string
+	fmtp        string
+	feedback    []string
+}
+
+// printSDPCapabilities 用 newAPI 创建 API 并生成一个临时 offer，把其中的能力输出到 stdout。
+// localIP / 端口范围与调用方 main 中传给 setupWebRTCSettingEngine 的参数一致，newAPI 是 main 创建连接时使用的同一个构造函数。
+func printSDPCapabilities(localIP string, portRangeStart, portRangeEnd uint16, newAPI func(webrtc.SettingEngine) (*webrtc.API, error)) error {
+	settingEngine := webrtc.SettingEngine{}
+	setupWebRTCSettingEngine(&settingEngine, localIP, portRangeStart, portRangeEnd)
+	api, err := newAPI(settingEngine)
+	if err != nil {
+		return err
+	}
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	defer func() {
+		if cErr := peerConnection.Close(); cErr != nil {
+			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", cErr)
+		}
+	}()
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err = peerConnection.AddTransceiverFromKind(kind); err != nil {
+			return fmt.Errorf("failed to add %s transceiver: %w", kind, err)
+		}
+	}
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	return writeSDPCapabilities(os.Stdout, offer)
+}
+
+// sdpMediaInfo 表示 SDP 中一个 m= 段的协商相关信息
+type sdpMediaInfo struct {
+	kind        string
+	mid         string
+	codecs      []*sdpCodecInfo // 按 payload type 排序
+	extensions  []string
+	fingerprint string // m= 段或会话级的 a=fingerprint
+}
+
+// parseSDPMedia 从 SessionDescription 中提取每个 m= 段的编解码器、反馈机制、头部扩展与 DTLS 指纹
+func parseSDPMedia(desc webrtc.SessionDescription) ([]sdpMediaInfo, error) {
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionFingerprint, _ := parsed.Attribute("fingerprint")
+
+	medias := make([]sdpMediaInfo, 0, len(parsed.MediaDescriptions))
+	for _, media := range parsed.MediaDescriptions {
+		info := sdpMediaInfo{kind: media.MediaName.Media, fingerprint: sessionFingerprint}
+
+		codecs := make(map[int]*sdpCodecInfo)
+		codecFor := func(pt int) *sdpCodecInfo {
+			c, ok := codecs[pt]
+			if !ok {
+				c = &sdpCodecInfo{payloadType: pt}
+				codecs[pt] = c
+			}
+			return c
+		}
+
+		for _, attr := range media.Attributes {
+			switch attr.Key {
+			case "rtpmap", "fmtp", "rtcp-fb":
+				ptStr, rest, _ := strings.Cut(attr.Value, " ")
+				pt, convErr := strconv.Atoi(ptStr)
+				if convErr != nil {
+					continue
+				}
+				c := codecFor(pt)
+				switch attr.Key {
+				case "rtpmap":
+					c.rtpmap = rest
+				case "fmtp":
+					c.fmtp = rest
+				default:
+					// interceptor 注册可能重复添加相同的反馈，去重后输出
+					if !slices.Contains(c.feedback, rest) {
+						c.feedback = append(c.feedback, rest)
+					}
+				}
+			case "extmap":
+				info.extensions = append(info.extensions, attr.Value)
+			case "mid":
+				info.mid = attr.Value
+			case "fingerprint":
+				info.fingerprint = attr.Value
+			}
+		}
+
+		payloadTypes := make([]int, 0, len(codecs))
+		for pt := range codecs {
+			payloadTypes = append(payloadTypes, pt)
+		}
+		sort.Ints(payloadTypes)
+		for _, pt := range payloadTypes {
+			info.codecs = append(info.codecs, codecs[pt])
+		}
+
+		medias = append(medias, info)
+	}
+	return medias, nil
+}
+
+// writeSDPCapabilities 解析 offer 并按 m= 段输出编解码器、反馈机制与头部扩展
+func writeSDPCapabilities(w io.Writer, desc webrtc.SessionDescription) error {
+	medias, err := parseSDPMedia(desc)
+	if err != nil {
+		return fmt.Errorf("failed to parse offer SDP: %w", err)
+	}
+
+	for _, media := range medias {
+		fmt.Fprintf(w, "== %s ==\n", media.kind)
+		fmt.Fprintf(w, "Codecs:\n")
+		for _, c := range media.codecs {
+			fmt.Fprintf(w, "  %3d %s", c.payloadType, c.rtpmap)
+			if c.fmtp != "" {
+				fmt.Fprintf(w, " [%s]", c.fmtp)
+			}
+			fmt.Fprintf(w, "\n")
+			if len(c.feedback) > 0 {
+				fmt.Fprintf(w, "      feedback: %s\n", strings.Join(c.feedback, ", "))
+			}
+		}
+		fmt.Fprintf(w, "Header extensions:\n")
+		if len(media.extensions) == 0 {
+			fmt.Fprintf(w, "  (none)\n")
+		}
+		for _, ext := range media.extensions {
+			fmt.Fprintf(w, "  %s\n", ext)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	return nil
+}
+
+// codecNames 返回 m= 段中的编解码器名称（rtpmap 的 encoding name，去重、小写，忽略 rtx）
+func (m sdpMediaInfo) codecNames() []string {
+	var names []string
+	for _, c := range m.codecs {
+		name, _, _ := strings.Cut(c.rtpmap, "/")
+		name = strings.ToLower(name)
+		if name == "" || name == "rtx" || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// reportNegotiationFailure 在 SetRemoteDescription 失败时输出诊断信息：
+// 逐个 m= 段对比 offer 与 answer 的编解码器，并检查 DTLS 指纹，给出可操作的提示。
+// offer 为 nil（尚未设置本地描述）时只输出原始错误。
+func reportNegotiationFailure(w io.Writer, offer *webrtc.SessionDescription, answer webrtc.SessionDescription, setErr error) {
+	fmt.Fprintf(w, "Error: Failed to set remote description: %v\n", setErr)
+	if offer == nil {
+		return
+	}
+
+	offered, err := parseSDPMedia(*offer)
+	if err != nil {
+		fmt.Fprintf(w, "Could not parse local offer for diagnostics: %v\n", err)
+		return
+	}
+	answered, err := parseSDPMedia(answer)
+	if err != nil {
+		fmt.Fprintf(w, "Could not parse answer SDP: %v\n", err)
+		fmt.Fprintf(w, "Hint: the answer is not valid SDP; make sure the whole base64 answer was copied (no line breaks or truncation)\n")
+		return
+	}
+
+	fmt.Fprintf(w, "\nSDP negotiation diagnostic (offer vs answer):\n")
+	var hints []string
+
+	if len(answered) != len(offered) {
+		hints = append(hints, fmt.Sprintf("answer has %d media sections but the offer has %d; the answer was probably created for a different offer (stale answer file from a previous run?)",
+			len(answered), len(offered)))
+	}
+
+	for i, o := range offered {
+		offeredCodecs := o.codecNames()
+		fmt.Fprintf(w, "  [%d] %s (mid %s)\n", i, o.kind, o.mid)
+		fmt.Fprintf(w, "      offered: %s\n", joinOrNone(offeredCodecs))
+		if i >= len(answered) {
+			fmt.Fprintf(w, "      answer:  (missing media section)\n")
+			continue
+		}
+
+		a := answered[i]
+		answeredCodecs := a.codecNames()
+		fmt.Fprintf(w, "      answer:  %s\n", joinOrNone(answeredCodecs))
+
+		// 被拒绝的 m= 段（port 0）没有 mid，按编解码器不匹配处理
+		if a.kind != o.kind || (a.mid != "" && a.mid != o.mid) {
+			hints = append(hints, fmt.Sprintf("media section %d is %s (mid %s) in the offer but %s (mid %s) in the answer; the answer does not match this offer",
+				i, o.kind, o.mid, a.kind, a.mid))
+			continue
+		}
+
+		var common []string
+		for _, name := range answeredCodecs {
+			if slices.Contains(offeredCodecs, name) {
+				common = append(common, name)
+			}
+		}
+		if len(common) == 0 {
+			hints = append(hints, fmt.Sprintf("no common codec for %s: offered %s, answer has %s; make sure both sides register the same codecs (compare the -print-sdp-capabilities output of server and client)",
+				o.kind, joinOrNone(offeredCodecs), joinOrNone(answeredCodecs)))
+		}
+	}
+
+	offerFingerprint, answerFingerprint := "", ""
+	if len(offered) > 0 {
+		offerFingerprint = offered[0].fingerprint
+	}
+	if len(answered) > 0 {
+		answerFingerprint = answered[0].fingerprint
+	}
+	fmt.Fprintf(w, "  fingerprint: offer %s\n", joinOrNone([]string{offerFingerprint}))
+	fmt.Fprintf(w, "               answer %s\n", joinOrNone([]string{answerFingerprint}))
+	if answerFingerprint == "" {
+		hints = append(hints, "answer has no DTLS fingerprint; it was not produced by a WebRTC peer or was truncated")
+	}
+
+	if len(hints) == 0 {
+		hints = append(hints, "no obvious codec or fingerprint mismatch; check that the answer belongs to this offer (e.g. delete stale answer files before starting the server)")
+	}
+	fmt.Fprintf(w, "\n")
+	for _, hint := range hints {
+		fmt.Fprintf(w, "Hint: %s\n", hint)
+	}
+}
+
+// joinOrNone 用逗号连接非空字符串，全部为空时返回 "none"
+func joinOrNone(values []string) string {
+	var nonEmpty []string
+	for _, v := range values {
+		if v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return "none"
+	}
+	return strings.Join(nonEmpty, ", ")
+}
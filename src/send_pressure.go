@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// send_pressure.go - 本地发送缓冲区压力作为拥塞信号（-send-pressure）
+//
+// 说明：
+//   - localhost / 局域网实验中 RTCP 几乎不会报告丢包，控制器没有可以反应的拥塞信号；
+//     但本地 UDP 发送缓冲区写满时，socket 写入会阻塞到缓冲区腾出空间为止，这是真实的本地拥塞
+//   - pion 的 SettingEngine / ICE 层没有暴露 socket 发送缓冲区的占用，所以从 WriteSample 的耗时推断：
+//     WriteSample 同步完成打包、SRTP 加密和 socket 写入，缓冲区有空间时一帧只需要几百微秒
+//   - 一帧内累计阻塞在 WriteSample 中的时间达到帧间隔的给定比例时，认为该帧遇到了发送缓冲区压力；
+//     此时 字节数 / 写入耗时 近似为缓冲区的排空速率
+//   - 各控制器的使用方式：NDTC 把容量估计限制在排空速率以内；Salsify / BurstRTC 按窗口内受压帧的比例降低预算
+//   - 指定 -session-dir 时逐帧记录到 send_pressure.csv，受压帧比例也写入 controller_state.csv 的 send_pressure 列
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// sendPressure 在 -send-pressure 开启时非 nil，包装视频轨道并由发送循环在每帧发送结束后调用
+var sendPressure *SendPressureMonitor
+
+// sendPressureWindow 是计算受压帧比例的窗口（帧数）
+const sendPressureWindow = 30
+
+// SendPressureSample 是一帧的发送缓冲区压力观测
+type SendPressureSample struct {
+	FrameID   int
+	Bytes     int
+	Writes    int           // WriteSample 调用次数
+	WriteTime time.Duration // 累计阻塞在 WriteSample 中的时间
+	MaxWrite  time.Duration // 单次 WriteSample 的最长耗时
+	Ratio     float64       // WriteTime 占帧间隔的比例
+	Blocked   bool          // Ratio 达到阈值
+	DrainBps  float64       // 受压时的排空速率估计（bit/s），未受压时为 0
+}
+
+// SendPressureMonitor 统计 WriteSample 的耗时，方法对 nil 安全
+type SendPressureMonitor struct {
+	threshold     float64
+	frameInterval time.Duration
+
+	mu        sync.Mutex
+	bytes     int
+	writes    int
+	writeTime time.Duration
+	maxWrite  time.Duration
+
+	recent        []bool // 最近 sendPressureWindow 帧是否受压
+	frames        int
+	blockedFrames int
+	longestWrite  time.Duration
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewSendPressureMonitor 创建监视器：threshold 为受压判定的帧间隔比例；csvPath 为空时不写逐帧记录
+func NewSendPressureMonitor(threshold float64, frameInterval time.Duration, csvPath string) (*SendPressureMonitor, error) {
+	if frameInterval <= 0 {
+		frameInterval = time.Second / defaultFrameRateFPS
+	}
+	m := &SendPressureMonitor{threshold: threshold, frameInterval: frameInterval}
+	if csvPath == "" {
+		return m, nil
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create send pressure csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"frame", "unix_ms", "bytes", "writes", "write_ms", "max_write_ms", "ratio", "blocked", "drain_bps"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write send pressure header: %w", err)
+	}
+	w.Flush()
+	m.writer, m.file = w, f
+	return m, nil
+}
+
+// Wrap 返回统计写入耗时的轨道；未开启时原样返回 track
+func (m *SendPressureMonitor) Wrap(track h264SampleWriter) h264SampleWriter {
+	if m == nil {
+		return track
+	}
+	return &SendPressureTrack{track: track, monitor: m}
+}
+
+// observe 累计一次写入
+func (m *SendPressureMonitor) observe(size int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += size
+	m.writes++
+	m.writeTime += d
+	m.maxWrite = max(m.maxWrite, d)
+}
+
+// EndFrame 结束一帧的统计并返回观测；未开启时返回零值
+func (m *SendPressureMonitor) EndFrame(frameID int) SendPressureSample {
+	if m == nil {
+		return SendPressureSample{FrameID: frameID}
+	}
+	m.mu.Lock()
+	s := SendPressureSample{
+		FrameID:   frameID,
+		Bytes:     m.bytes,
+		Writes:    m.writes,
+		WriteTime: m.writeTime,
+		MaxWrite:  m.maxWrite,
+		Ratio:     m.writeTime.Seconds() / m.frameInterval.Seconds(),
+	}
+	m.bytes, m.writes, m.writeTime, m.maxWrite = 0, 0, 0, 0
+
+	s.Blocked = s.Writes > 0 && s.Ratio >= m.threshold
+	if s.Blocked {
+		s.DrainBps = float64(s.Bytes*8) / s.WriteTime.Seconds()
+		m.blockedFrames++
+	}
+	m.frames++
+	m.longestWrite = max(m.longestWrite, s.MaxWrite)
+	m.recent = append(m.recent, s.Blocked)
+	if len(m.recent) > sendPressureWindow {
+		m.recent = m.recent[len(m.recent)-sendPressureWindow:]
+	}
+	m.mu.Unlock()
+
+	if m.writer != nil {
+		blocked := "0"
+		if s.Blocked {
+			blocked = "1"
+		}
+		if err := m.writer.Write([]string{
+			fmt.Sprintf("%d", frameID),
+			fmt.Sprintf("%d", time.Now().UnixMilli()),
+			fmt.Sprintf("%d", s.Bytes),
+			fmt.Sprintf("%d", s.Writes),
+			fmt.Sprintf("%.3f", float64(s.WriteTime)/float64(time.Millisecond)),
+			fmt.Sprintf("%.3f", float64(s.MaxWrite)/float64(time.Millisecond)),
+			fmt.Sprintf("%.4f", s.Ratio),
+			blocked,
+			fmt.Sprintf("%.0f", s.DrainBps),
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing send pressure CSV: %v\n", err)
+		}
+		m.writer.Flush()
+	}
+	return s
+}
+
+// Rate 返回最近 sendPressureWindow 帧中受压帧的比例
+func (m *SendPressureMonitor) Rate() float64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.recent) == 0 {
+		return 0
+	}
+	blocked := 0
+	for _, b := range m.recent {
+		if b {
+			blocked++
+		}
+	}
+	return float64(blocked) / float64(len(m.recent))
+}
+
+// Close 打印汇总并关闭 CSV 文件
+func (m *SendPressureMonitor) Close() {
+	if m == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[SendPressure] %d of %d frames blocked in WriteSample for >= %.0f%% of the frame interval, longest single write %v\n",
+		m.blockedFrames, m.frames, m.threshold*100, m.longestWrite)
+	if m.file == nil {
+		return
+	}
+	m.writer.Flush()
+	if err := m.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing send pressure CSV file: %v\n", err)
+	}
+}
+
+// SendPressureTrack 包装 h264SampleWriter，记录每次 WriteSample 的耗时
+type SendPressureTrack struct {
+	track   h264SampleWriter
+	monitor *SendPressureMonitor
+}
+
+// WriteSample 转发给底层轨道；只统计成功的写入
+func (t *SendPressureTrack) WriteSample(sample media.Sample) error {
+	start := time.Now()
+	if err := t.track.WriteSample(sample); err != nil {
+		return err
+	}
+	t.monitor.observe(len(sample.Data), time.Since(start))
+	return nil
+}
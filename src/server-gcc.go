@@ -29,17 +29,166 @@ import (
 
 func main() {
 	videoFile := flag.String("video", "", "Video file path (e.g., assets/Ultra.mp4)")
-	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-detect")
+	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-select the best interface address; 'any' gathers on all interfaces")
 	offerFile := flag.String("offer-file", "", "Path to file to write offer (optional, if not specified, write to stdout)")
 	answerFile := flag.String("answer-file", "", "Path to file containing answer (optional, if not specified, read from stdin)")
+	signalURL := flag.String("signal-url", "", "Exchange SDP through an sdp-bridge room instead of files or stdin/stdout: POST the offer to <url>/offer and poll <url>/answer (e.g. http://bridge.example.com:8080/demo), or with a ws:// / wss:// URL send the offer and receive the answer over the room's WebSocket")
 	loop := flag.Bool("loop", false, "Loop video playback (default: false, play once)")
+	sessionTimeout := flag.Duration("session-timeout", time.Hour, "Maximum session length for unattended runs: close the connection once streaming has run this long, e.g. 90m (0 = unlimited)")
+	watch := flag.Bool("watch", false, "Reload -video whenever the file changes (mtime/size), keeping the connection alive and starting the new content with a keyframe; at EOF wait for the next change instead of ending the session")
+	watchDebounce := flag.Duration("watch-debounce", time.Second, "With -watch, how long the file must stay unchanged before it is reloaded")
+	keepOpen := flag.Bool("keep-open", false, "After EOF keep the connection open, sending a black keepalive frame every second, and accept replay / seek <seconds> commands on the \"control\" data channel (see client -control)")
+	maxBytes := flag.Int64("max-bytes", 0, "Stop streaming before the cumulative encoded video bytes exceed this cap, then close the session (0 = unlimited). Bytes actually sent are reported at shutdown")
 	sessionDir := flag.String("session-dir", "", "Session directory for this experiment (optional, used mainly by scripts)")
+	resume := flag.Bool("resume", false, "Continue from the source position saved in <session-dir>/video_position.json by a previous run: seek to the keyframe before it and decode-and-discard up to it, so the first frame sent is the next one (requires -session-dir; starts from the beginning if no position was saved)")
+	queueDepth := flag.Int("queue-depth", 0, "Encoded frame queue depth between encoder and sender (0 = disabled, send inline). When full, the oldest frame is dropped. GCC server only: the NDTC / Salsify / BurstRTC servers always encode and send each frame inline")
+	paceKeyframes := flag.Int("pace-keyframes", 0, "Spread each keyframe's RTP packets over the next N frame intervals (0 = disabled). Later frames are delayed, not dropped")
+	passthrough := flag.Bool("passthrough", false, "Forward the source H.264 access units without decode/re-encode when the source is compatible (H.264 Baseline/Main/High, 8-bit 4:2:0); falls back to transcoding otherwise")
+	minSendRate := flag.Int("min-send-rate", 0, "Minimum send rate in kbps (0 = disabled). When media falls below it, RTP padding packets fill the gap so bandwidth estimators keep getting samples; padding is logged to padding.csv, not frame metadata")
+	flag.BoolVar(&keyframesOnly, "encode-only-keyframes", false, "Diagnostic: encode every frame as an IDR (GOP size 1) to measure worst-case keyframe bitrate and stress keyframe pacing")
+	flag.IntVar(&maxBFrames, "bframes", 0, "Maximum consecutive B-frames (0 = disabled). B-frames improve compression but add reordering latency")
+	flag.BoolVar(&abortOnFirstError, "abort-on-first-error", false, "Strict mode: exit immediately on recoverable pipeline errors (decode/scale/encode/write) instead of logging and continuing")
+	printSDPCaps := flag.Bool("print-sdp-capabilities", false, "Print supported codecs, RTCP feedback and header extensions from a dummy offer, then exit")
+	helpExperiments := flag.Bool("help-experiments", false, "Print every available experiment (rate controller) with a one-line algorithm summary and its tunable parameters and defaults, then exit")
+	rtcpLog := flag.Bool("rtcp-log", false, "Log every sent/received RTCP packet with parsed fields to <session-dir>/rtcp_server.csv (requires -session-dir)")
+	resourceUsage := flag.Bool("resource-usage", false, "Sample process CPU time and RSS every second to <session-dir>/resource_usage_server.csv and print mean/peak at exit (requires -session-dir)")
+	pprofAddr := flag.String("pprof", "", "Serve net/http/pprof on this address (e.g. :6060) to profile CPU and allocations during the session")
+	statsInterval := flag.Duration("stats-interval", 0, "Print one aggregate health line (frames sent, send bitrate, RTT and loss from receiver reports, reconnects) every interval, e.g. 10s (0 = disabled)")
+	frameHash := flag.Bool("frame-hash", false, "Write a SHA-256 of every sent access unit's NAL units to <session-dir>/frame_hashes_server.csv; diff against the other side to verify a lossless round trip (requires -session-dir)")
+	flag.BoolVar(&verifyParamSets, "verify-param-sets", false, "Log every SPS/PPS that differs from the previously sent one (encoder rebuilds or candidate switches change them mid-stream) and print a summary at exit")
+	compat := flag.String("compat", "none", "H.264 receiver compatibility: none, or constrained-baseline to advertise only profile-level-id 42e01f and force the encoder to profile baseline, no B-frames and CAVLC")
+	hwaccelName := flag.String("hwaccel", "none", "H.264 encoder: none (software x264), nvenc (h264_nvenc on a CUDA device) or vaapi (h264_vaapi); falls back to software with a warning when the hardware encoder or device is unavailable")
+	latencyMode := flag.String("encoder-latency-mode", "idr", "Keyframe strategy: idr (periodic IDR keyframes) or intra-refresh (x264 periodic intra refresh: an intra column sweeps the picture once per GOP instead of sending large IDRs; receivers recover at the end of each refresh cycle)")
+	keyframeMinInterval := flag.Duration("keyframe-min-interval", 0, "Honor PLI/FIR keyframe requests from the receiver, forcing at most one IDR per interval, e.g. 1s; requests arriving while waiting are merged into that single IDR (0 = disabled, PLI/FIR are ignored)")
+	keyframeWindow := flag.Duration("keyframe-coalesce-window", 200*time.Millisecond, "With -keyframe-min-interval, treat requests arriving this soon after a forced IDR as already satisfied by it (the request was sent before the IDR arrived)")
+	ssrcList := flag.String("ssrc", "", "Fixed SSRCs for the local tracks as video[,audio] (e.g. 1000 or 1000,2000; one value means audio uses video+1). Empty lets pion pick random SSRCs")
+	cname := flag.String("cname", "", "CNAME (and stream ID) for all local tracks, so captures and RTCP logs from different runs line up. Empty keeps the defaults")
+	audioMode := flag.String("audio", "none", "Audio sent on the Opus track: none (negotiated but never sent) or silence (generated Opus frames, see -test-tone)")
+	var tone ToneConfig
+	flag.BoolVar(&tone.Enabled, "test-tone", false, "With -audio silence, beep periodically and force a video keyframe at each beep, so A/V sync can be checked by ear and by the A/V skew metric")
+	flag.Float64Var(&tone.Frequency, "test-tone-freq", 1000, "Test tone frequency in Hz")
+	flag.DurationVar(&tone.Interval, "test-tone-interval", time.Second, "Time between test tone beeps (each beep lasts 100ms)")
+	rtcpBWE := flag.Bool("rtcp-bwe", false, "Estimate available bandwidth from receiver RTCP feedback (TWCC through the GCC send-side estimator, capped by REMB) and report it as bwe= in the -stats-interval health line. The client must negotiate transport-cc (pion clients do by default)")
 	flag.Parse()
 
+	if *helpExperiments {
+		printExperiments(os.Stdout)
+		return
+	}
+
+	trackIdentity, err := parseTrackIdentity(*ssrcList, *cname, 2)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -ssrc/-cname: %v\n", err)
+		os.Exit(1)
+	}
+	if h264Compat, err = parseH264Compat(*compat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -compat: %v\n", err)
+		os.Exit(1)
+	}
+	if hwAccel, err = parseHWAccel(*hwaccelName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -hwaccel: %v\n", err)
+		os.Exit(1)
+	}
+	if encoderLatencyMode, err = parseEncoderLatencyMode(*latencyMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -encoder-latency-mode: %v\n", err)
+		os.Exit(1)
+	}
+	if encoderLatencyMode == EncoderLatencyIntraRefresh {
+		fmt.Fprintf(os.Stderr, "[GCC] Intra refresh enabled: no periodic IDRs, forced keyframes start a new refresh cycle\n")
+	}
+	if *signalURL != "" && (*offerFile != "" || *answerFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: -signal-url cannot be combined with -offer-file or -answer-file\n")
+		os.Exit(1)
+	}
+	if *keyframeMinInterval < 0 || *keyframeWindow < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -keyframe-min-interval and -keyframe-coalesce-window must be >= 0\n")
+		os.Exit(1)
+	}
+	if *keyframeMinInterval > 0 {
+		keyframeRequests = NewKeyframeRequests(*keyframeWindow, *keyframeMinInterval)
+		defer keyframeRequests.Close()
+	}
+
+	if *printSDPCaps {
+		// -rtcp-bwe 注册 TWCC 发送端头部扩展，与下面创建 API 时一致
+		if *rtcpBWE {
+			bitrateEstimator = NewBitrateEstimator()
+		}
+		if err := printSDPCapabilities(*localIP, 50000, 50100, newSDPCapabilitiesAPI); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing SDP capabilities: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *videoFile == "" {
 		fmt.Fprintf(os.Stderr, "Error: -video parameter is required\n")
 		os.Exit(1)
 	}
+	if *sessionTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -session-timeout must be >= 0 (0 = unlimited)\n")
+		os.Exit(1)
+	}
+	if *maxBytes < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -max-bytes must be >= 0\n")
+		os.Exit(1)
+	}
+	if *watch && *passthrough {
+		fmt.Fprintf(os.Stderr, "Error: -watch cannot be combined with -passthrough\n")
+		os.Exit(1)
+	}
+	if *resume && *sessionDir == "" {
+		fmt.Fprintf(os.Stderr, "Error: -resume requires -session-dir\n")
+		os.Exit(1)
+	}
+	if *resume && *passthrough {
+		fmt.Fprintf(os.Stderr, "Error: -resume cannot be combined with -passthrough\n")
+		os.Exit(1)
+	}
+	if *keepOpen && *passthrough {
+		fmt.Fprintf(os.Stderr, "Error: -keep-open cannot be combined with -passthrough\n")
+		os.Exit(1)
+	}
+	if *watchDebounce < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -watch-debounce must be >= 0\n")
+		os.Exit(1)
+	}
+	if *queueDepth < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -queue-depth must be >= 0\n")
+		os.Exit(1)
+	}
+	if *paceKeyframes < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -pace-keyframes must be >= 0\n")
+		os.Exit(1)
+	}
+	if *minSendRate < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -min-send-rate must be >= 0\n")
+		os.Exit(1)
+	}
+	if *audioMode != "none" && *audioMode != "silence" {
+		fmt.Fprintf(os.Stderr, "Error: -audio must be none or silence, got %q\n", *audioMode)
+		os.Exit(1)
+	}
+	if tone.Enabled && *audioMode != "silence" {
+		fmt.Fprintf(os.Stderr, "Error: -test-tone requires -audio silence\n")
+		os.Exit(1)
+	}
+	if err := tone.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -test-tone: %v\n", err)
+		os.Exit(1)
+	}
+	if maxBFrames < 0 || maxBFrames > 16 {
+		fmt.Fprintf(os.Stderr, "Error: -bframes must be between 0 and 16\n")
+		os.Exit(1)
+	}
+	if h264Compat == H264CompatConstrainedBaseline && maxBFrames > 0 {
+		fmt.Fprintf(os.Stderr, "Error: -compat constrained-baseline cannot be combined with -bframes\n")
+		os.Exit(1)
+	}
+	if encoderLatencyMode == EncoderLatencyIntraRefresh && keyframesOnly {
+		fmt.Fprintf(os.Stderr, "Error: -encoder-latency-mode intra-refresh cannot be combined with -encode-only-keyframes\n")
+		os.Exit(1)
+	}
 
 	if *sessionDir != "" {
 		if err := os.MkdirAll(*sessionDir, 0o755); err != nil {
@@ -48,6 +197,60 @@ func main() {
 		}
 	}
 
+	var rtcpLogger *RTCPLogger
+	if *rtcpLog {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -rtcp-log requires -session-dir\n")
+			os.Exit(1)
+		}
+		logger, lErr := NewRTCPLogger(filepath.Join(*sessionDir, "rtcp_server.csv"))
+		if lErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating RTCP log: %v\n", lErr)
+			os.Exit(1)
+		}
+		rtcpLogger = logger
+		defer rtcpLogger.Close()
+	}
+
+	if *pprofAddr != "" {
+		if pErr := startPprofServer(*pprofAddr); pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error starting pprof server: %v\n", pErr)
+			os.Exit(1)
+		}
+	}
+
+	if *statsInterval > 0 {
+		healthStats = NewHealthStats("server")
+	}
+
+	if *resourceUsage {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -resource-usage requires -session-dir\n")
+			os.Exit(1)
+		}
+		monitor, mErr := NewResourceMonitor(filepath.Join(*sessionDir, "resource_usage_server.csv"))
+		if mErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating resource usage log: %v\n", mErr)
+			os.Exit(1)
+		}
+		defer monitor.Close()
+	}
+
+	var frameHashes *FrameHashWriter
+	if *frameHash {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -frame-hash requires -session-dir\n")
+			os.Exit(1)
+		}
+		var hErr error
+		frameHashes, hErr = NewFrameHashWriter(filepath.Join(*sessionDir, "frame_hashes_server.csv"))
+		if hErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating frame hash log: %v\n", hErr)
+			os.Exit(1)
+		}
+		defer frameHashes.Close()
+	}
+
 	if _, err := os.Stat(*videoFile); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Error: video file not found: %s\n", *videoFile)
 		os.Exit(1)
@@ -59,6 +262,26 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 记录源视频的解码位置，-resume 时从上次保存的位置继续（见 resume_position.go）
+	if *sessionDir != "" {
+		videoResume = NewVideoResume(*sessionDir, absPath)
+		defer videoResume.Close()
+	}
+	if *resume {
+		if rErr := videoResume.Load(); rErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: -resume: %v\n", rErr)
+			os.Exit(1)
+		}
+	}
+
+	// 源帧率：用于发送节奏与编码器时间基，并通过 offer 的 a=framerate 告知 client
+	sourceFrameRate, err := probeVideoFrameRate(absPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to probe video file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Source frame rate: %s (%.3f fps)\n", sourceFrameRate, sourceFrameRate.Float64())
+
 	astiav.RegisterAllDevices()
 
 	// WebRTC SettingEngine
@@ -75,7 +298,23 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Starting ICE gathering (localhost mode, no STUN, fixed port range 50000-50100)...\n")
 	}
 
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	// 记录每帧的 RTP 时间戳写入 frame_metadata.csv，client 据此对齐帧号
+	if *sessionDir != "" {
+		sentRTPTimestamps = NewSentRTPTimestamps()
+	}
+
+	// 根据接收端的 TWCC / REMB 反馈估计带宽（-rtcp-bwe），需要在创建 API 之前设置
+	if *rtcpBWE {
+		bitrateEstimator = NewBitrateEstimator()
+		healthStats.SetBandwidthEstimate(bitrateEstimator.EstimatedBitrate)
+		defer bitrateEstimator.Report("[GCC]")
+		fmt.Fprintf(os.Stderr, "[GCC] RTCP bandwidth estimation enabled (TWCC + REMB)\n")
+	}
+
+	api, err := newWebRTCAPI(settingEngine, rtcpLogger)
+	if err != nil {
+		panic(err)
+	}
 
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
@@ -92,6 +331,7 @@ func main() {
 
 	setupPeerConnectionHandlers(peerConnection, nil, func(connectionState webrtc.ICEConnectionState) {
 		fmt.Fprintf(os.Stderr, "ICE Connection State: %s\n", connectionState.String())
+		healthStats.OnICEConnectionState(connectionState)
 		if connectionState == webrtc.ICEConnectionStateConnected {
 			fmt.Fprintf(os.Stderr, "ICE connection established!\n")
 			iceConnectedCtxCancel()
@@ -111,26 +351,68 @@ func main() {
 		}
 	})
 
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "video/h264"}, "video", "pion",
-	)
-	if err != nil {
-		panic(err)
+	// 开启 B 帧时 RTP 时间戳需要跟随 PTS（非单调），改用自行分片的 ptsH264Track；
+	// keyframe pacer 需要自行安排每个 RTP 包的发送时间，同样基于 ptsH264Track；
+	// passthrough 直接使用源 PTS（源文件可能含 B 帧），且是否可用要等打开源文件后才知道
+	var videoTrack h264SampleWriter
+	var ptsTrack *ptsH264Track
+	var paddingTarget paddingGenerator // -min-send-rate 的 padding 包写入底层轨道
+	if maxBFrames > 0 || *paceKeyframes > 0 || *passthrough {
+		ptsTrack, err = newPTSH264Track("video", trackIdentity.StreamID("pion"))
+		if err != nil {
+			panic(err)
+		}
+		if _, err = addTrackWithSSRC(peerConnection, ptsTrack.track, trackIdentity.SSRC(0)); err != nil {
+			panic(err)
+		}
+		videoTrack = ptsTrack
+		paddingTarget = ptsTrack
+	} else {
+		sampleTrack, err := webrtc.NewTrackLocalStaticSample(
+			webrtc.RTPCodecCapability{MimeType: "video/h264"}, "video", trackIdentity.StreamID("pion"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		if _, err = addTrackWithSSRC(peerConnection, sampleTrack, trackIdentity.SSRC(0)); err != nil {
+			panic(err)
+		}
+		videoTrack = sampleTrack
+		paddingTarget = sampleTrack
 	}
-	if _, err = peerConnection.AddTrack(videoTrack); err != nil {
-		panic(err)
+	if maxBFrames > 0 {
+		fmt.Fprintf(os.Stderr, "[GCC] B-frames enabled (bf=%d), RTP timestamps follow PTS\n", maxBFrames)
+	}
+	if keyframesOnly {
+		fmt.Fprintf(os.Stderr, "[GCC] Diagnostic mode: every frame is encoded as an IDR (GOP size 1)\n")
+		if maxBFrames > 0 {
+			fmt.Fprintf(os.Stderr, "[GCC] Note: -bframes has no effect with -encode-only-keyframes\n")
+		}
 	}
 
 	opusTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion1",
+		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", trackIdentity.StreamID("pion1"),
 	)
 	if err != nil {
 		panic(err)
 	}
-	if _, err = peerConnection.AddTrack(opusTrack); err != nil {
+	if _, err = addTrackWithSSRC(peerConnection, opusTrack, trackIdentity.SSRC(1)); err != nil {
 		panic(err)
 	}
 
+	// 收到的 RTCP 只有在应用层读取时才会经过 interceptor：NACK 重传（rtx）与 -rtcp-log 都依赖持续读取
+	for _, sender := range peerConnection.GetSenders() {
+		go drainSenderRTCP(sender)
+	}
+
+	// 控制 data channel 必须在创建 offer 之前建立，才会出现在 SDP 中
+	var streamControl *StreamControl
+	if *keepOpen {
+		if streamControl, err = newStreamControl(peerConnection); err != nil {
+			panic(err)
+		}
+	}
+
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
 		panic(err)
@@ -145,8 +427,17 @@ func main() {
 	<-gatherComplete
 	fmt.Fprintf(os.Stderr, "ICE gathering completed\n")
 
-	offerStr := encode(peerConnection.LocalDescription())
-	if *offerFile != "" {
+	offerDesc, fErr := withSDPFrameRate(*peerConnection.LocalDescription(), sourceFrameRate.Float64())
+	if fErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to add frame rate to offer: %v\n", fErr)
+	}
+	offerStr := encode(&offerDesc)
+	if *signalURL != "" {
+		if err := postSignal(*signalURL, "offer", offerStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *offerFile != "" {
 		if err := os.WriteFile(*offerFile, []byte(offerStr+"\n"), 0o644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing offer to file: %v\n", err)
 			os.Exit(1)
@@ -161,7 +452,9 @@ func main() {
 	fmt.Fprintf(os.Stderr, "Waiting for answer from client...\n")
 	answer := webrtc.SessionDescription{}
 	var answerStr string
-	if *answerFile != "" {
+	if *signalURL != "" {
+		answerStr = pollSignal(*signalURL, "answer")
+	} else if *answerFile != "" {
 		fmt.Fprintf(os.Stderr, "Reading answer from file: %s\n", *answerFile)
 		answerStr = readFromFile(*answerFile)
 	} else {
@@ -178,7 +471,18 @@ func main() {
 	decode(answerStr, &answer)
 	fmt.Fprintf(os.Stderr, "Answer received, setting remote description...\n")
 	if err = peerConnection.SetRemoteDescription(answer); err != nil {
-		panic(fmt.Sprintf("Failed to set remote description: %v", err))
+		reportNegotiationFailure(os.Stderr, peerConnection.LocalDescription(), answer, err)
+		os.Exit(1)
+	}
+	logRTXNegotiation(peerConnection)
+	// 开启 B 帧时需要对端接受 main 及以上；answer 只接受 baseline 时关闭 B 帧并切换到 constrained baseline
+	requiredProfile := h264ProfileRankBaseline
+	if maxBFrames > 0 {
+		requiredProfile = h264ProfileRankMain
+	}
+	if applyAnswerH264Profile(answer, requiredProfile) {
+		fmt.Fprintf(os.Stderr, "[GCC] B-frames disabled for this session (-bframes %d ignored)\n", maxBFrames)
+		maxBFrames = 0
 	}
 
 	fmt.Fprintf(os.Stderr, "Waiting for ICE connection to establish...\n")
@@ -192,9 +496,51 @@ func main() {
 		fmt.Fprintf(os.Stderr, "WARNING: ICE connection timeout, starting video streaming anyway...\n")
 	}
 
-	initVideoSource(absPath)
+	if err := initVideoSource(absPath); err != nil {
+		freeVideoCoding()
+		fmt.Fprintf(os.Stderr, "Error: failed to initialize video source: %v\n", err)
+		os.Exit(1)
+	}
 	defer freeVideoCoding()
 
+	var sourceWatcher *SourceWatcher
+	if *watch {
+		if sourceWatcher, err = newSourceWatcher(absPath, *watchDebounce); err != nil {
+			fmt.Fprintf(os.Stderr, "Error watching video file: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "[GCC] Watching %s for changes (debounce %v)\n", absPath, *watchDebounce)
+	}
+
+	// 直接转发（可选）：源参数不满足时回退到转码
+	var passthroughSrc *passthroughSource
+	if *passthrough {
+		if ok, reason := checkPassthrough(videoStream); !ok {
+			fmt.Fprintf(os.Stderr, "[GCC] Passthrough not possible (%s), falling back to transcoding\n", reason)
+		} else if passthroughSrc, err = newPassthroughSource(videoStream); err != nil {
+			fmt.Fprintf(os.Stderr, "[GCC] Failed to set up passthrough (%v), falling back to transcoding\n", err)
+			passthroughSrc = nil
+		} else {
+			defer passthroughSrc.Free()
+			fmt.Fprintf(os.Stderr, "[GCC] Passthrough enabled: forwarding source H.264 without re-encoding\n")
+			if maxBFrames > 0 {
+				fmt.Fprintf(os.Stderr, "[GCC] Note: -bframes has no effect in passthrough mode\n")
+			}
+			if keyframesOnly {
+				fmt.Fprintf(os.Stderr, "[GCC] Note: -encode-only-keyframes has no effect in passthrough mode\n")
+			}
+			if encoderLatencyMode == EncoderLatencyIntraRefresh {
+				fmt.Fprintf(os.Stderr, "[GCC] Note: -encoder-latency-mode intra-refresh has no effect in passthrough mode, the source keyframes are forwarded as-is\n")
+			}
+			if *queueDepth > 0 {
+				fmt.Fprintf(os.Stderr, "[GCC] Warning: passthrough cannot force keyframes, frames after a queue drop may be corrupted until the next source keyframe\n")
+			}
+			if keyframeRequests != nil {
+				fmt.Fprintf(os.Stderr, "[GCC] Note: -keyframe-min-interval has no effect in passthrough mode, PLI/FIR wait for the next source keyframe\n")
+			}
+		}
+	}
+
 	// 创建 frame metadata writer（如果 session-dir 存在）
 	var metadataWriter *FrameMetadataWriter
 	if *sessionDir != "" {
@@ -208,12 +554,106 @@ func main() {
 		}
 	}
 
+	// 关键帧 pacer（可选）：包装 ptsTrack，关键帧分摊到后续多个帧间隔发送
+	if *paceKeyframes > 0 {
+		frameInterval := frameRateInterval(videoFrameRate(inputFormatContext, videoStream))
+
+		pacerCSVPath := ""
+		if *sessionDir != "" {
+			pacerCSVPath = filepath.Join(*sessionDir, "pacer.csv")
+		}
+		pacer, err := NewKeyframePacer(ptsTrack, frameInterval, *paceKeyframes, pacerCSVPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating keyframe pacer: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() {
+			pacer.Close()
+			frames, keyframes, meanDelay, maxDelay := pacer.Stats()
+			fmt.Fprintf(os.Stderr, "[GCC] Keyframe pacer: %d frames (%d keyframes), added latency mean=%v max=%v\n",
+				frames, keyframes, meanDelay.Round(time.Microsecond), maxDelay.Round(time.Microsecond))
+		}()
+		videoTrack = pacer
+		fmt.Fprintf(os.Stderr, "[GCC] Keyframe pacing enabled: spreading keyframes over %d frame intervals\n", *paceKeyframes)
+	}
+
+	// 码率下限 padding（可选）：包装最终的 videoTrack 统计媒体字节，padding 包写入底层轨道
+	if *minSendRate > 0 {
+		paddingCSVPath := ""
+		if *sessionDir != "" {
+			paddingCSVPath = filepath.Join(*sessionDir, "padding.csv")
+		}
+		padder, err := NewMinRatePadder(videoTrack, paddingTarget, *minSendRate, paddingCSVPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating padder: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() {
+			padder.Close()
+			mediaBytes, paddingPackets, paddingBytes := padder.Stats()
+			fmt.Fprintf(os.Stderr, "[GCC] Padding: %d packets, %d bytes (media %d bytes)\n", paddingPackets, paddingBytes, mediaBytes)
+		}()
+		videoTrack = padder
+		fmt.Fprintf(os.Stderr, "[GCC] Minimum send rate enabled: %d kbps (RTP padding)\n", *minSendRate)
+	}
+
+	// 编码字节预算：包在最外层，只统计编码后的媒体字节（不含 padding）；未设置 -max-bytes 时只统计
+	budgetTrack := NewByteBudgetTrack(videoTrack, *maxBytes)
+	defer budgetTrack.Report("[GCC]")
+	// 逐帧哈希（-frame-hash）只记录最终成功发送的帧
+	// SPS/PPS 变化检查（-verify-param-sets）
+	paramSetTrack := NewParamSetTrack(budgetTrack, "[GCC]")
+	defer paramSetTrack.Report()
+	videoTrack = NewFrameHashTrack(paramSetTrack, frameHashes)
+
+	// 编码与发送之间的有界帧队列（可选）
+	var frameQueue *FrameQueue
+	if *queueDepth > 0 {
+		dropCSVPath := ""
+		if *sessionDir != "" {
+			dropCSVPath = filepath.Join(*sessionDir, "dropped_frames.csv")
+		}
+		frameQueue, err = NewFrameQueue(*queueDepth, dropCSVPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating frame queue: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() {
+			frameQueue.Close()
+			fmt.Fprintf(os.Stderr, "[GCC] Frame queue dropped %d frames (depth=%d)\n", frameQueue.Dropped(), *queueDepth)
+		}()
+		fmt.Fprintf(os.Stderr, "[GCC] Frame queue enabled (depth=%d, drop-oldest)\n", *queueDepth)
+	}
+
+	// 合成音频（可选）：与视频同时开始发送，测试音与视频关键帧按各自的媒体时间对齐
+	if *audioMode == "silence" {
+		audioSource, aErr := NewSyntheticAudioSource(tone)
+		if aErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating synthetic audio source: %v\n", aErr)
+			os.Exit(1)
+		}
+		defer audioSource.Free()
+		if tone.Enabled {
+			if passthroughSrc != nil {
+				fmt.Fprintf(os.Stderr, "[GCC] Warning: passthrough cannot force keyframes, test tone beeps are not aligned to keyframes\n")
+			}
+			testToneKeyframes = newToneKeyframeScheduler(frameRateInterval(videoFrameRate(inputFormatContext, videoStream)), tone.Interval)
+			fmt.Fprintf(os.Stderr, "[GCC] Test tone: %g Hz beep every %v, aligned to forced keyframes\n", tone.Frequency, tone.Interval)
+		}
+		audioSource.Start(connectionClosedCtx, opusTrack)
+	}
+
+	stopHealth := healthStats.Start(*statsInterval)
+	defer stopHealth()
+
 	videoDone := make(chan bool, 1)
-	go writeVideoToTrackWithGCCMetrics(videoTrack, *loop, videoDone, connectionClosedCtx, metadataWriter)
+	go writeVideoToTrackWithGCCMetrics(videoTrack, *loop, videoDone, connectionClosedCtx, metadataWriter, frameQueue, passthroughSrc, sourceWatcher, streamControl)
 
 	select {
 	case <-videoDone:
 		fmt.Fprintf(os.Stderr, "Video streaming completed, closing connection...\n")
+		// 先通知 client 正常结束（RTCP BYE），避免它把关闭连接当成中断
+		sendEndOfStream(peerConnection)
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
@@ -222,8 +662,10 @@ func main() {
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
-	case <-time.After(24 * time.Hour):
-		fmt.Fprintf(os.Stderr, "Timeout waiting for video completion\n")
+	case <-sessionTimeoutChannel(*sessionTimeout):
+		fmt.Fprintf(os.Stderr, "[GCC] Session timeout: streaming ran for %v (-session-timeout), closing connection...\n", *sessionTimeout)
+		// 与正常结束一样先发送 RTCP BYE，client 按正常结束处理
+		sendEndOfStream(peerConnection)
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
@@ -232,26 +674,145 @@ func main() {
 
 // writeVideoToTrackWithGCCMetrics 与原 writeVideoToTrack 几乎相同，目前只负责按帧率发送 H.264。
 // 为后续 GCC 实验预留扩展点（例如在这里根据带宽估计调整编码参数）。
-func writeVideoToTrackWithGCCMetrics(track *webrtc.TrackLocalStaticSample, loopVideo bool, done chan<- bool, ctx context.Context, metadataWriter *FrameMetadataWriter) {
-	frameRate := videoStream.AvgFrameRate()
-	if frameRate.Num() == 0 {
-		frameRate = astiav.NewRational(30, 1)
-	}
-	h264FrameDuration := time.Duration(float64(time.Second) * float64(frameRate.Den()) / float64(frameRate.Num()))
+// queue 非 nil 时只负责编码并入队，由 sendQueuedFrames 负责发送并在结束时通知 done。
+//
+// 每个编码器输出包视为一帧发送并记录 metadata：B 帧模式下编码器有延迟且按 DTS 顺序输出，
+// 一次 SendFrame 可能产生 0 个或多个包。send_start 取该包 PTS 对应的输入帧时间，
+// 因此 B 帧带来的重排序延迟会体现在端到端延迟中。
+//
+// passthrough 非 nil 时跳过解码/缩放/编码，源文件中的每个视频 packet 转为 Annex-B 后作为一帧发送。
+// watcher 非 nil 时（-watch）源文件变化后重新打开并强制关键帧，到达 EOF 时等待下一次变化而不是结束。
+// control 非 nil 时（-keep-open）到达 EOF 后保持连接，并处理 client 发来的 replay / seek 命令。
+// 在 EOF 处等待期间每秒发送一个黑色保活帧，client 不会因为读超时而结束。
+func writeVideoToTrackWithGCCMetrics(track h264SampleWriter, loopVideo bool, done chan<- bool, ctx context.Context, metadataWriter *FrameMetadataWriter, queue *FrameQueue, passthrough *passthroughSource, watcher *SourceWatcher, control *StreamControl) {
+	h264FrameDuration := frameRateInterval(videoFrameRate(inputFormatContext, videoStream))
+	// 解码帧 PTS 的校验与单调化（解码时间基即源流时间基，见 initVideoSource）
+	sourcePTS := newSourcePTSValidator(decodeCodecContext.TimeBase(), videoFrameRate(inputFormatContext, videoStream))
+	defer sourcePTS.Report("[GCC]")
 
 	ticker := time.NewTicker(h264FrameDuration)
 	defer ticker.Stop()
 
+	// 结束时的通知：使用队列时关闭队列，由发送协程在取空后通知 done
+	finish := func() {
+		if queue != nil {
+			queue.Close()
+			return
+		}
+		select {
+		case done <- true:
+		default:
+		}
+	}
+	if queue != nil {
+		go sendQueuedFrames(track, queue, done, ctx, metadataWriter)
+	}
+
 	frameID := 0
+	sentFrameID := 0
+	// 丢帧后下一帧强制编码为关键帧，否则接收端会因参考帧缺失而花屏
+	forceKeyframe := false
+	// PTS → 输入帧开始处理时间，用于把 (可能延迟输出的) 编码包对应回原始帧
+	sendStartByPTS := make(map[int64]time.Time)
+	// 空包跳过，只含 SPS/PPS 等参数集的包并入下一帧，避免 frame_metadata 多出 client 不计数的 "帧"
+	var frameAssembler encodedFrameAssembler
+	defer frameAssembler.Report("[GCC]")
+	// -watch：重新加载次数；idle 表示已在 EOF 处等待（文件变化或控制命令），lastKeepalive 为上一个保活帧的时间
+	reloads := 0
+	idle := false
+	var lastKeepalive time.Time
+
+	// emitSample 发送（或入队）一帧。返回的错误只来自直接发送，意味着连接可能已断开。
+	emitSample := func(sample media.Sample, frameStart time.Time) error {
+		if queue != nil {
+			// Data() 返回的是拷贝，可以直接入队
+			if queue.Push(&EncodedFrame{
+				FrameID:    frameID,
+				Sample:     sample,
+				FrameBits:  frameMetadataBits(sample.Data),
+				EncodeTime: frameStart,
+			}) {
+				forceKeyframe = true
+			}
+			return nil
+		}
+
+		firstWrite := time.Now()
+		if err := track.WriteSample(sample); err != nil {
+			return err
+		}
+
+		// 写入 frame metadata
+		sentFrameID++
+		healthStats.AddFrame(len(sample.Data))
+		if metadataWriter != nil {
+			metadataWriter.WriteMetadata(FrameMetadata{
+				FrameID:    sentFrameID,
+				SendStart:  frameStart,
+				SendEnd:    time.Now(),
+				FrameBits:  frameMetadataBits(sample.Data),
+				FirstWrite: firstWrite,
+			})
+		}
+		return nil
+	}
+
+	// emitPacket 发送一个编码包；fallbackStart 用于找不到 PTS 对应输入帧的情况
+	emitPacket := func(pkt *astiav.Packet, fallbackStart time.Time) error {
+		data, ok := frameAssembler.Next(pkt.Data())
+		if !ok {
+			return nil
+		}
+		packetPTS := pkt.Pts()
+		frameStart, ok := sendStartByPTS[packetPTS]
+		if !ok {
+			frameStart = fallbackStart
+		}
+		delete(sendStartByPTS, packetPTS)
+		return emitSample(media.Sample{
+			Data:            data,
+			Duration:        h264FrameDuration,
+			PacketTimestamp: uint32(astiav.RescaleQ(packetPTS, encodeCodecContext.TimeBase(), astiav.NewRational(1, 90000))),
+		}, frameStart)
+	}
+
+	// emitKeepalive 编码并发送一个黑帧（EOF 后等待期间使用），编码器尚未初始化时跳过
+	emitKeepalive := func(now time.Time) error {
+		if encodeCodecContext == nil || scaledFrame == nil {
+			return nil
+		}
+		if err := scaledFrame.ImageFillBlack(); err != nil {
+			reportRecoverableError("Error filling keepalive frame", err)
+			return nil
+		}
+		pts++
+		scaledFrame.SetPts(pts)
+		scaledFrame.SetPictureType(astiav.PictureTypeNone)
+		sendStartByPTS[pts] = now
+		if err := encodeCodecContext.SendFrame(scaledFrame); err != nil {
+			reportRecoverableError("Error sending keepalive frame to encoder", err)
+			return nil
+		}
+		for {
+			if err := encodeCodecContext.ReceivePacket(encodePacket); err != nil {
+				if !errors.Is(err, astiav.ErrEof) && !errors.Is(err, astiav.ErrEagain) {
+					reportRecoverableError("Error receiving keepalive packet", err)
+				}
+				return nil
+			}
+			err := emitPacket(encodePacket, now)
+			encodePacket.Unref()
+			if err != nil {
+				return err
+			}
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			fmt.Fprintf(os.Stderr, "[GCC] Connection closed context triggered, stopping video streaming...\n")
-			select {
-			case done <- true:
-			default:
-			}
+			finish()
 			return
 		case <-ticker.C:
 			// 继续处理这一帧
@@ -261,13 +822,57 @@ func writeVideoToTrackWithGCCMetrics(track *webrtc.TrackLocalStaticSample, loopV
 		select {
 		case <-ctx.Done():
 			fmt.Fprintf(os.Stderr, "[GCC] Connection closed after ticker, stopping video streaming...\n")
-			select {
-			case done <- true:
-			default:
-			}
+			finish()
 			return
 		default:
 		}
+
+		if watcher.Changed() {
+			if rErr := reloadVideoSource(watcher.Path()); errors.Is(rErr, errSourceReleased) {
+				fmt.Fprintf(os.Stderr, "[GCC] Error: video file changed and reload failed (%v), stopping video streaming\n", rErr)
+				finish()
+				return
+			} else if rErr != nil {
+				fmt.Fprintf(os.Stderr, "[GCC] Video file changed but reload failed (%v), keeping current input\n", rErr)
+			} else {
+				reloads++
+				idle = false
+				sourcePTS.Reset()
+				videoResume.Cancel()
+				// 新内容不能参考旧内容的帧
+				forceKeyframe = true
+				fmt.Fprintf(os.Stderr, "[GCC] Video file changed, reloaded %s (reload #%d)\n", watcher.Path(), reloads)
+			}
+		}
+
+		if cmd, ok := control.Poll(); ok {
+			sErr := seekVideoSource(cmd.Position)
+			if sErr == nil {
+				idle = false
+				sourcePTS.Reset()
+				videoResume.Cancel()
+				forceKeyframe = true
+				fmt.Fprintf(os.Stderr, "[GCC] Control command %q: streaming from %v\n", cmd.Text, cmd.Position)
+			} else {
+				fmt.Fprintf(os.Stderr, "[GCC] Control command %q failed: %v\n", cmd.Text, sErr)
+			}
+			control.Done(cmd, sErr)
+		}
+
+		if idle {
+			if time.Since(lastKeepalive) >= idleKeepaliveInterval {
+				lastKeepalive = time.Now()
+				if kErr := emitKeepalive(lastKeepalive); kErr != nil {
+					fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", kErr)
+					select {
+					case done <- true:
+					default:
+					}
+					return
+				}
+			}
+			continue
+		}
 		
 		decodePacket.Unref()
 
@@ -279,17 +884,41 @@ func writeVideoToTrackWithGCCMetrics(track *webrtc.TrackLocalStaticSample, loopV
 						break
 					}
 					pts = 0
+					sourcePTS.Reset()
+					videoResume.Cancel()
+					if passthrough != nil {
+						passthrough.OnLoop()
+					}
 					fmt.Fprintf(os.Stderr, "Video looped, restarting from beginning...\n")
 					continue
 				}
+				if watcher != nil || control != nil {
+					// 保持连接，等待文件变化或控制命令（每个 tick 检查一次）；编码器不 flush，之后还要继续编码
+					fmt.Fprintf(os.Stderr, "[GCC] Video playback reached EOF, keeping the connection open...\n")
+					control.Notify("eof")
+					idle = true
+					continue
+				}
 				fmt.Fprintf(os.Stderr, "Video playback completed (EOF reached)\n")
-				select {
-				case done <- true:
-				default:
+				// 编码器内部可能还缓存着若干帧（B 帧 / lookahead），flush 后再结束，避免丢失视频末尾；
+				// 剩余的包仍按帧间隔发送，不在结尾产生突发
+				flushed, fErr := flushEncoder(encodeCodecContext, func(pkt *astiav.Packet) error {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-ticker.C:
+					}
+					return emitPacket(pkt, time.Now())
+				})
+				if fErr != nil {
+					reportRecoverableError("Error flushing encoder", fErr)
+				} else if flushed > 0 {
+					fmt.Fprintf(os.Stderr, "[GCC] Flushed %d buffered packets from encoder\n", flushed)
 				}
+				finish()
 				break
 			}
-			fmt.Fprintf(os.Stderr, "Error reading frame: %v\n", err)
+			reportRecoverableError("Error reading frame", err)
 			continue
 		}
 
@@ -297,10 +926,40 @@ func writeVideoToTrackWithGCCMetrics(track *webrtc.TrackLocalStaticSample, loopV
 			continue
 		}
 
+		if passthrough != nil {
+			frameID++
+			sendStart := time.Now()
+			var writeErr error
+			if err = passthrough.Filter(decodePacket, func(pkt *astiav.Packet) error {
+				writeErr = emitSample(media.Sample{
+					Data:            pkt.Data(),
+					Duration:        h264FrameDuration,
+					PacketTimestamp: passthrough.Timestamp(pkt),
+				}, sendStart)
+				return writeErr
+			}); err != nil {
+				if writeErr != nil {
+					fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", writeErr)
+					select {
+					case done <- true:
+					default:
+					}
+					return
+				}
+				reportRecoverableError("Error filtering passthrough packet", err)
+			}
+			continue
+		}
+
+		// -resume：seek 之后先丢弃关键帧之前的包
+		if videoResume.SkipPacket(decodePacket) {
+			continue
+		}
+
 		decodePacket.RescaleTs(videoStream.TimeBase(), decodeCodecContext.TimeBase())
 
 		if err = decodeCodecContext.SendPacket(decodePacket); err != nil {
-			fmt.Fprintf(os.Stderr, "Error sending packet to decoder: %v\n", err)
+			reportRecoverableError("Error sending packet to decoder", err)
 			continue
 		}
 
@@ -309,46 +968,67 @@ func writeVideoToTrackWithGCCMetrics(track *webrtc.TrackLocalStaticSample, loopV
 				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
 					break
 				}
-				fmt.Fprintf(os.Stderr, "Error receiving frame: %v\n", err)
+				reportRecoverableError("Error receiving frame", err)
 				break
 			}
+			decodedPTS := sourcePTS.Validate(decodeFrame)
+			// -resume：上次已经发送过的帧只解码不发送
+			if videoResume.SkipFrame(decodedPTS) {
+				continue
+			}
 
 			frameID++
 			sendStart := time.Now()
+			if testToneKeyframes.IsToneFrame(frameID - 1) {
+				forceKeyframe = true
+			}
 
-			initVideoEncoding()
+			if eErr := initVideoEncoding(); eErr != nil {
+				fmt.Fprintf(os.Stderr, "[GCC] Error: failed to initialize encoder: %v, stopping video streaming\n", eErr)
+				finish()
+				return
+			}
 
+			if err = ensureScalerSource(softwareScaleContext, decodeFrame); err != nil {
+				reportRecoverableError("Error reconfiguring scaler", err)
+				continue
+			}
 			if err = softwareScaleContext.ScaleFrame(decodeFrame, scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scaling frame: %v\n", err)
+				reportRecoverableError("Error scaling frame", err)
 				continue
 			}
 
-			pts++
+			pts = sourcePTS.EncoderPTS(decodedPTS, pts, encodeCodecContext.TimeBase())
 			scaledFrame.SetPts(pts)
+			sendStartByPTS[pts] = sendStart
+			if keyframeRequests.Take(frameID, forceKeyframe) {
+				forceKeyframe = true
+			}
+			if forceKeyframe {
+				scaledFrame.SetPictureType(astiav.PictureTypeI)
+				forceKeyframe = false
+			} else {
+				scaledFrame.SetPictureType(astiav.PictureTypeNone)
+			}
 
 			if err = encodeCodecContext.SendFrame(scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error sending frame to encoder: %v\n", err)
+				reportRecoverableError("Error sending frame to encoder", err)
 				continue
 			}
+			videoResume.Save(decodedPTS)
 
-			var frameBits int
 			for {
-				encodePacket = astiav.AllocPacket()
 				if err = encodeCodecContext.ReceivePacket(encodePacket); err != nil {
 					if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-						encodePacket.Free()
 						break
 					}
-					encodePacket.Free()
-					fmt.Fprintf(os.Stderr, "Error receiving packet: %v\n", err)
+					reportRecoverableError("Error receiving packet", err)
 					break
 				}
 
-				data := encodePacket.Data()
-				frameBits += len(data) * 8
-
-				if err = track.WriteSample(media.Sample{Data: data, Duration: h264FrameDuration}); err != nil {
-					encodePacket.Free()
+				err = emitPacket(encodePacket, sendStart)
+				encodePacket.Unref()
+				if err != nil {
 					fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", err)
 					// 如果写入失败，可能是连接已断开，退出循环
 					select {
@@ -357,20 +1037,45 @@ func writeVideoToTrackWithGCCMetrics(track *webrtc.TrackLocalStaticSample, loopV
 					}
 					return
 				}
-				encodePacket.Free()
 			}
+		}
+	}
+}
 
-			sendEnd := time.Now()
+// sendQueuedFrames 从帧队列中取出编码帧并发送，是 writeVideoToTrackWithGCCMetrics 的消费者一侧。
+// metadata 中的 frame_id 按实际发送顺序编号，保证与 client 端接收到的帧序号一致（被丢弃的帧不占编号）。
+func sendQueuedFrames(track h264SampleWriter, queue *FrameQueue, done chan<- bool, ctx context.Context, metadataWriter *FrameMetadataWriter) {
+	defer func() {
+		select {
+		case done <- true:
+		default:
+		}
+	}()
 
-			// 写入 frame metadata
-			if metadataWriter != nil {
-				metadataWriter.WriteMetadata(FrameMetadata{
-					FrameID:   frameID,
-					SendStart: sendStart,
-					SendEnd:   sendEnd,
-					FrameBits: frameBits,
-				})
-			}
+	sentFrameID := 0
+	for {
+		frame, ok := queue.Pop(ctx)
+		if !ok {
+			return
+		}
+
+		// 帧在队列中等待的时间计入 encode_ms（帧可用到开始写入）
+		firstWrite := time.Now()
+		if err := track.WriteSample(frame.Sample); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", err)
+			return
+		}
+
+		sentFrameID++
+		healthStats.AddFrame(len(frame.Sample.Data))
+		if metadataWriter != nil {
+			metadataWriter.WriteMetadata(FrameMetadata{
+				FrameID:    sentFrameID,
+				SendStart:  frame.EncodeTime,
+				SendEnd:    time.Now(),
+				FrameBits:  frame.FrameBits,
+				FirstWrite: firstWrite,
+			})
 		}
 	}
 }
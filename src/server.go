@@ -7,9 +7,9 @@
 // server.go - WebRTC 服务器程序
 //
 // 这个程序的作用：
-//  1. 读取本地视频文件（支持多种格式：MP4、AVI、MKV 等）
+//  1. 读取本地视频文件（支持多种格式：MP4、AVI、MKV 等），有音频流时一并转码为 Opus 发送（见 audio_source.go）
 //  2. 使用 FFmpeg 解码视频（支持 H.264、HEVC 等编码格式）
-//  3. 将视频重新编码为 H.264 格式（WebRTC 标准要求）
+//  3. 将视频重新编码为 H.264 格式（WebRTC 标准要求；-codec vp8 / h265 / av1 时编码为对应格式）
 //  4. 通过 WebRTC 发送视频流给客户端
 //
 // 工作流程：
@@ -35,6 +35,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/asticode/go-astiav"
@@ -50,27 +52,167 @@ var (
 	decodePacket         *astiav.Packet               // 解码数据包：从文件读取的压缩数据
 	decodeFrame          *astiav.Frame                // 解码后的帧：原始像素数据（YUV 格式）
 	videoStream          *astiav.Stream               // 视频流：文件中的视频轨道
-	audioStream          *astiav.Stream               // 音频流：文件中的音频轨道（当前未使用）
+	audioStream          *astiav.Stream               // 音频流：视频循环中跳过，音频由 FileAudioSource 单独读取
 	softwareScaleContext *astiav.SoftwareScaleContext // 缩放上下文：用于调整视频分辨率（如果需要）
 	scaledFrame          *astiav.Frame                // 缩放后的帧：调整分辨率后的像素数据
 	encodeCodecContext   *astiav.CodecContext         // 编码器上下文：用于将像素数据编码为 H.264
 	encodePacket         *astiav.Packet               // 编码后的数据包：H.264 压缩数据
 	pts                  int64                        // 显示时间戳：用于控制视频播放速度
 	err                  error                        // 错误变量：用于存储函数返回的错误
+	outputCodec          videoCodec                   // 发送的视频编码格式（-codec）
+	keyframeInterval     int                          // -keyframe-interval：编码器 GOP 长度（帧），0 表示编码器默认
+	keyframeOnLoop       bool                         // -keyframe-on-loop：-loop 回到开头后的第一帧强制编码为 IDR
+	av1TemporalLayers    int                          // -av1-temporal-layers：AV1（libsvtav1）请求的时间层数（见 av1_encoder.go）
 )
 
+// videoCodec 描述 -codec 可选的一种发送编码格式
+type videoCodec struct {
+	name     string
+	mimeType string
+	codecID  astiav.CodecID
+	// encoderNames 非空时按顺序查找这些编码器（go-astiav 没有 AV1 的 CodecID 常量，AV1 只能按名称查找），否则按 codecID 查找
+	encoderNames []string
+}
+
+var (
+	videoCodecH264 = videoCodec{name: "h264", mimeType: webrtc.MimeTypeH264, codecID: astiav.CodecIDH264}
+	videoCodecVP8  = videoCodec{name: "vp8", mimeType: webrtc.MimeTypeVP8, codecID: astiav.CodecIDVp8}
+	videoCodecH265 = videoCodec{name: "h265", mimeType: webrtc.MimeTypeH265, codecID: astiav.CodecIDHevc}
+	// SVT-AV1 的实时 preset 比 libaom 快得多，并且支持低延迟的分层预测结构（时间层），优先使用
+	videoCodecAV1 = videoCodec{name: "av1", mimeType: webrtc.MimeTypeAV1, encoderNames: []string{"libsvtav1", "libaom-av1"}}
+)
+
+// vp8BitRate 是 VP8 编码的目标码率：libvpx 没有与 x264 默认 CRF 对应的实时恒定质量模式，需要指定码率
+const vp8BitRate = 4_000_000
+
+// parseVideoCodec 解析 -codec 的取值
+func parseVideoCodec(value string) (videoCodec, error) {
+	switch strings.ToLower(value) {
+	case "h264":
+		return videoCodecH264, nil
+	case "vp8":
+		return videoCodecVP8, nil
+	case "h265", "hevc":
+		return videoCodecH265, nil
+	case "av1":
+		return videoCodecAV1, nil
+	}
+	return videoCodec{}, fmt.Errorf("unknown codec %q (want h264, h265, vp8 or av1)", value)
+}
+
+// findEncoder 返回该编码格式可用的编码器，没有时返回 nil
+func (c videoCodec) findEncoder() *astiav.Codec {
+	if len(c.encoderNames) == 0 {
+		return astiav.FindEncoder(c.codecID)
+	}
+	for _, name := range c.encoderNames {
+		if encoder := astiav.FindEncoderByName(name); encoder != nil {
+			return encoder
+		}
+	}
+	return nil
+}
+
+// encoderOptions 返回该编码格式的低延迟编码器选项：preset / tune / bf 只属于 x264，libvpx 用 deadline / cpu-used / lag-in-frames，
+// x265 的 B 帧与参数集重复通过 x265-params 设置（repeat-headers 让每个关键帧前都带 VPS/SPS/PPS，中途加入的 client 也能解码）；
+// forced-idr 让强制的关键帧（-keyframe-on-loop）是 IDR 而不是普通 I 帧，之前的参考帧不再被引用
+func (c videoCodec) encoderOptions() [][2]string {
+	switch c.codecID {
+	case astiav.CodecIDVp8:
+		return [][2]string{{"deadline", "realtime"}, {"cpu-used", "8"}, {"lag-in-frames", "0"}, {"error-resilient", "1"}}
+	case astiav.CodecIDHevc:
+		return [][2]string{{"preset", "ultrafast"}, {"tune", "zerolatency"}, {"x265-params", "bframes=0:repeat-headers=1"}, {"forced-idr", "1"}}
+	}
+	return [][2]string{{"preset", "ultrafast"}, {"tune", "zerolatency"}, {"bf", "0"}, {"forced-idr", "1"}}
+}
+
 func main() {
 	videoFile := flag.String("video", "", "Video file path (e.g., Ultra.mp4)")
-	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-detect")
+	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-select the best interface address; 'any' gathers on all interfaces")
 	offerFile := flag.String("offer-file", "", "Path to file to write offer (optional, if not specified, write to stdout)")
 	answerFile := flag.String("answer-file", "", "Path to file containing answer (optional, if not specified, read from stdin)")
+	signalURL := flag.String("signal-url", "", "Exchange SDP through an sdp-bridge room instead of files or stdin/stdout: with a ws:// / wss:// URL send the offer and receive the answer over the room's WebSocket (e.g. ws://bridge.example.com:8080/demo), with http:// POST the offer to <url>/offer and poll <url>/answer")
 	loop := flag.Bool("loop", false, "Loop video playback (default: false, play once)")
+	sessionTimeout := flag.Duration("session-timeout", time.Hour, "Maximum session length for unattended runs: close the connection once streaming has run this long, e.g. 90m (0 = unlimited)")
+	flag.BoolVar(&abortOnFirstError, "abort-on-first-error", false, "Strict mode: exit immediately on recoverable pipeline errors (decode/scale/encode/write) instead of logging and continuing")
+	printSDPCaps := flag.Bool("print-sdp-capabilities", false, "Print supported codecs, RTCP feedback and header extensions from a dummy offer, then exit")
+	codec := flag.String("codec", "h264", "Video codec to send: h264, h265, vp8 or av1 (the basic client records VP8 / AV1 as IVF and H.265 as an Annex-B .h265 stream)")
+	flag.IntVar(&av1TemporalLayers, "av1-temporal-layers", 1, "With -codec av1 and libsvtav1, request this many temporal layers: 1 (default, no hierarchy) or 3-6, passed as svtav1-params hierarchical-levels=N-1 (libsvtav1 accepts 2-5). Whether the encoder marks the layers is not guaranteed; check the client's AV1 temporal layers report")
+	bitrate := flag.Int("bitrate", 0, "Encode at a constant bitrate of this many kbps, e.g. 2000: sets the encoder bit rate, max/min rate and a VBV buffer of one frame interval, and reports the measured output bitrate at the end (0 = codec default rate control: CRF for x264/x265/AV1, 4000 kbps for VP8)")
+	flag.IntVar(&keyframeInterval, "keyframe-interval", 0, "Encoder GOP size in frames: a keyframe at least every N frames, e.g. 30 for one per second at 30fps (0 = encoder default, 250 for x264)")
+	nackCache := flag.Duration("nack-cache", 500*time.Millisecond, "Keep sent video RTP packets this long and retransmit them when the client NACKs them (0 = ignore NACKs and rely on PLI keyframes)")
+	clients := flag.Int("clients", 1, "Number of clients to stream to: one peer connection per client, all receiving the same encoded packets (the video is encoded once). With N > 1, client i uses <offer-file>/<answer-file> with -i inserted before the extension, e.g. answer-1.txt ... answer-N.txt; requires -offer-file and -answer-file. A client that disconnects is dropped without stopping the others")
+	trickle := flag.Bool("trickle", false, "Send the offer right away without waiting for ICE gathering and exchange ICE candidates one by one over the -signal-url WebSocket as they are gathered (requires a ws:// or wss:// -signal-url; the client must also use -trickle)")
+	benchmark := flag.Bool("benchmark", false, "Encode-only benchmark: decode, scale and encode -video once as fast as possible without WebRTC (no offer, no pacing), then report per-frame encode latency, frame count and achieved fps")
+	flag.BoolVar(&keyframeOnLoop, "keyframe-on-loop", true, "With -loop, encode the first frame after seeking back to the start as an IDR so the client does not show corruption across the loop point")
 	flag.Parse()
 
+	if *printSDPCaps {
+		if err := printSDPCapabilities(*localIP, 50000, 50100, retransmitCache.newAPI); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing SDP capabilities: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *videoFile == "" {
 		fmt.Fprintf(os.Stderr, "Error: -video parameter is required\n")
 		os.Exit(1)
 	}
+	if *sessionTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -session-timeout must be >= 0 (0 = unlimited)\n")
+		os.Exit(1)
+	}
+	if outputCodec, err = parseVideoCodec(*codec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -codec: %v\n", err)
+		os.Exit(1)
+	}
+	if keyframeInterval < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -keyframe-interval must be >= 0 (0 = encoder default)\n")
+		os.Exit(1)
+	}
+	if *bitrate < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -bitrate must be >= 0 (0 = codec default rate control)\n")
+		os.Exit(1)
+	}
+	cbrTarget = NewCBRTarget(*bitrate)
+	defer cbrTarget.Report()
+	if !validAV1TemporalLayers(av1TemporalLayers) {
+		fmt.Fprintf(os.Stderr, "Error: -av1-temporal-layers must be 1 or 3-6 (libsvtav1 hierarchical-levels %d-%d)\n", svtav1MinHierarchicalLevels, svtav1MaxHierarchicalLevels)
+		os.Exit(1)
+	}
+	if av1TemporalLayers > 1 && outputCodec.name != videoCodecAV1.name {
+		fmt.Fprintf(os.Stderr, "Error: -av1-temporal-layers requires -codec av1\n")
+		os.Exit(1)
+	}
+	if *nackCache < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -nack-cache must be >= 0 (0 = disabled)\n")
+		os.Exit(1)
+	}
+	if *nackCache > 0 && !*benchmark {
+		retransmitCache = NewRetransmitCache(*nackCache)
+		defer retransmitCache.Report()
+	}
+	if *signalURL != "" && (*offerFile != "" || *answerFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: -signal-url cannot be combined with -offer-file or -answer-file\n")
+		os.Exit(1)
+	}
+	if *trickle && !isWebSocketSignalURL(*signalURL) {
+		fmt.Fprintf(os.Stderr, "Error: -trickle requires a ws:// or wss:// -signal-url\n")
+		os.Exit(1)
+	}
+	if *clients < 1 {
+		fmt.Fprintf(os.Stderr, "Error: -clients must be >= 1\n")
+		os.Exit(1)
+	}
+	if *benchmark && *loop {
+		fmt.Fprintf(os.Stderr, "Error: -benchmark cannot be combined with -loop (the benchmark encodes the file once)\n")
+		os.Exit(1)
+	}
+	if *clients > 1 && (*offerFile == "" || *answerFile == "") {
+		fmt.Fprintf(os.Stderr, "Error: -clients > 1 requires -offer-file and -answer-file\n")
+		os.Exit(1)
+	}
 
 	// Check if video file exists
 	if _, err := os.Stat(*videoFile); os.IsNotExist(err) {
@@ -85,9 +227,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 源帧率：用于发送节奏与编码器时间基，并通过 offer 的 a=framerate 告知 client
+	sourceFrameRate, err := probeVideoFrameRate(absPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to probe video file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Source frame rate: %s (%.3f fps)\n", sourceFrameRate, sourceFrameRate.Float64())
+
 	// Register all devices
 	astiav.RegisterAllDevices()
 
+	if *benchmark {
+		if err := runVideoBenchmark(absPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Everything below is the Pion WebRTC API! Thanks for using it ❤️.
 
 	// ========== 配置 WebRTC 设置引擎 ==========
@@ -110,198 +268,353 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Starting ICE gathering (localhost mode, no STUN, fixed port range 50000-50100)...\n")
 	}
 
-	// Create API with SettingEngine
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
-
-	// Create a new RTCPeerConnection
-	peerConnection, err := api.NewPeerConnection(config)
+	// Create API with SettingEngine（-nack-cache 开启时用 retransmit.go 的重传缓存代替默认的 NACK responder）
+	api, err := retransmitCache.newAPI(settingEngine)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+
+	// ========== 第九步：创建视频和音频轨道 ==========
+	// Track 代表一个媒体流，可以是视频或音频
+	// 每个 client 有自己的视频轨道（H.264 或 -codec 指定的格式）和 Opus 音频轨道，
+	// 编码只做一次，由 FanoutTrack 把同一个 sample 写到所有 client 的轨道（见 fanout.go）
+	videoFanout := NewFanoutTrack("video")
+
+	// 音频：源文件有音频流时转码发送，没有音频流（或无法转码）时不添加音频轨道
+	var audioFanout *FanoutTrack
+	audioSource, aErr := NewFileAudioSource(absPath, *loop)
+	switch {
+	case errors.Is(aErr, ErrNoAudioStream):
+		fmt.Fprintf(os.Stderr, "No audio stream in the source, sending video only\n")
+	case aErr != nil:
+		fmt.Fprintf(os.Stderr, "Warning: audio disabled, sending video only: %v\n", aErr)
+	default:
+		defer audioSource.Free()
+		audioFanout = NewFanoutTrack("audio")
+	}
+
+	// Create one RTCPeerConnection per client
+	peers := make([]*serverPeer, 0, *clients)
 	defer func() {
-		if cErr := peerConnection.Close(); cErr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", cErr)
+		for _, peer := range peers {
+			peer.Close()
 		}
 	}()
+	for id := 1; id <= *clients; id++ {
+		peer, pErr := newServerPeer(api, config, id, *clients, videoFanout, audioFanout)
+		if pErr != nil {
+			panic(pErr)
+		}
+		peers = append(peers, peer)
+		if *trickle {
+			// -trickle 只支持 -signal-url，因此只有一个 client
+			if peer.trickle, pErr = NewTrickleICE(peer.pc, *signalURL, true); pErr != nil {
+				fmt.Fprintf(os.Stderr, "Error: -trickle: %v\n", pErr)
+				os.Exit(1)
+			}
+			defer peer.trickle.Close()
+		}
+	}
+	if *clients > 1 {
+		fmt.Fprintf(os.Stderr, "Fan-out to %d clients: offers %s ... %s, answers %s ... %s\n", *clients,
+			fanoutSignalPath(*offerFile, 1, *clients), fanoutSignalPath(*offerFile, *clients, *clients),
+			fanoutSignalPath(*answerFile, 1, *clients), fanoutSignalPath(*answerFile, *clients, *clients))
+	}
+
+	// ========== 第十步：创建 Offer（会话描述） ==========
+	// Offer 包含 Server 支持的编解码器、网络地址等信息；每个 client 一个 offer
+	for _, peer := range peers {
+		offerStr := peer.createOffer(sourceFrameRate.Float64())
+		offerPath := fanoutSignalPath(*offerFile, peer.id, *clients)
+		if *signalURL != "" {
+			// 通过 sdp-bridge 房间发送（ws:// 为 WebSocket，http:// 为 POST）
+			if err := postSignal(*signalURL, "offer", offerStr); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			peer.trickle.SignalSent()
+		} else if offerPath != "" {
+			// 写入文件（用于自动化脚本）
+			err := os.WriteFile(offerPath, []byte(offerStr+"\n"), 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing offer to file: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "%sOffer written to file: %s (%d bytes)\n", peer.logPrefix(), offerPath, len(offerStr))
+		} else {
+			// 输出到 stdout（用于手动复制粘贴）
+			os.Stdout.WriteString(offerStr + "\n")
+			os.Stdout.Sync()
+			fmt.Fprintf(os.Stderr, "Offer written to stdout (%d bytes)\n", len(offerStr))
+		}
+	}
+
+	// ========== 等待客户端的 Answer ==========
+	// Answer 是客户端对 Offer 的回应，包含客户端支持的编解码器和网络地址
+	for _, peer := range peers {
+		fmt.Fprintf(os.Stderr, "%sWaiting for answer from client...\n", peer.logPrefix())
+		answer := webrtc.SessionDescription{}
+		var answerStr string
+		answerPath := fanoutSignalPath(*answerFile, peer.id, *clients)
+		if *signalURL != "" {
+			// 等待 sdp-bridge 推送（WebSocket）或轮询（HTTP）
+			answerStr = pollSignal(*signalURL, "answer")
+		} else if answerPath != "" {
+			// 从文件读取（用于自动化脚本）
+			fmt.Fprintf(os.Stderr, "Reading answer from file: %s\n", answerPath)
+			answerStr = readFromFile(answerPath)
+		} else {
+			// 从 stdin 读取（用于手动复制粘贴）
+			answerStr = readUntilNewline() // 使用公共函数
+		}
+		if answerStr == "" {
+			fmt.Fprintf(os.Stderr, "Error: Empty answer received\n")
+			os.Exit(1)
+		}
+		// 验证 Answer 格式（base64 字符串应该比较长）
+		if len(answerStr) < 100 {
+			fmt.Fprintf(os.Stderr, "Error: Answer too short (%d chars), expected base64 string\n", len(answerStr))
+			os.Exit(1)
+		}
+		decode(answerStr, &answer) // 使用公共函数解码
+		fmt.Fprintf(os.Stderr, "%sAnswer received, setting remote description...\n", peer.logPrefix())
+
+		// Set the remote SessionDescription
+		if err = peer.pc.SetRemoteDescription(answer); err != nil {
+			// 最常见的原因是编解码器/SDP 不匹配：输出 offer 与 answer 的对比，而不是直接 panic
+			reportNegotiationFailure(os.Stderr, peer.pc.LocalDescription(), answer, err)
+			os.Exit(1)
+		}
+		peer.trickle.Start()
+	}
+
+	// ========== 第十二步：等待 ICE 连接建立 ==========
+	// 在开始发送视频之前，需要先建立网络连接
+	fmt.Fprintf(os.Stderr, "Waiting for ICE connection to establish...\n")
+	// 添加超时，避免无限等待；多个 client 共用同一个超时
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	for _, peer := range peers {
+		select {
+		case <-peer.iceConnected.Done():
+			// ICE 连接已建立，可以开始发送视频
+			fmt.Fprintf(os.Stderr, "%sICE connection established, starting video streaming...\n", peer.logPrefix())
+		case <-ctx.Done():
+			// 超时，但继续发送视频（可能连接已经建立，只是事件未触发）
+			fmt.Fprintf(os.Stderr, "%sWARNING: ICE connection timeout, starting video streaming anyway...\n", peer.logPrefix())
+		}
+	}
+
+	// ========== 第十三步：初始化视频源 ==========
+	// 打开视频文件，创建解码器
+	if err := initVideoSource(absPath); err != nil {
+		freeVideoCoding()
+		fmt.Fprintf(os.Stderr, "Error: failed to initialize video source: %v\n", err)
+		os.Exit(1)
+	}
+	defer freeVideoCoding() // 程序退出时释放 FFmpeg 资源
+
+	// ========== 第十四步：启动视频发送 ==========
+	// 创建一个 channel 用于接收视频播放完成的信号
+	videoDone := make(chan bool, 1)
+
+	// 音频在自己的协程中按 PTS 发送，与视频同时开始
+	if audioSource != nil {
+		audioSource.Start(context.Background(), audioFanout)
+	}
+
+	// 在 goroutine 中启动视频发送（不阻塞主程序）
+	// writeVideoToTrack 会按视频帧率持续发送帧，直到视频播放完毕
+	go writeVideoToTrack(videoFanout, *loop, videoDone)
+
+	// ========== 第十五步：等待视频播放完成 ==========
+	// 主程序在这里等待，直到视频播放完毕、超时或所有 client 都已断开
+	select {
+	case <-videoDone:
+		// 视频播放完成，关闭连接
+		fmt.Fprintf(os.Stderr, "Video streaming completed, closing connection...\n")
+	case <-sessionTimeoutChannel(*sessionTimeout):
+		// 会话超过 -session-timeout（-loop 或源不结束时防止程序永远运行）
+		fmt.Fprintf(os.Stderr, "Session timeout: streaming ran for %v (-session-timeout), closing connection...\n", *sessionTimeout)
+	case <-videoFanout.Empty():
+		fmt.Fprintf(os.Stderr, "All clients disconnected, closing connection...\n")
+	}
+	for _, peer := range peers {
+		peer.Close()
+	}
+}
+
+// serverPeer 是一个 client 的 PeerConnection 与它的视频 / 音频轨道（-clients 时每个 client 一个）
+type serverPeer struct {
+	id           int
+	clients      int
+	pc           *webrtc.PeerConnection
+	iceConnected context.Context
+	closeOnce    sync.Once
+
+	video, audio *FanoutTrack
+	trickle      *TrickleICE // -trickle 时非 nil
+}
+
+// newServerPeer 创建第 id 个 client 的 PeerConnection，添加轨道并加入 fan-out；audio 为 nil 时不添加音频轨道
+func newServerPeer(api *webrtc.API, config webrtc.Configuration, id, clients int, video, audio *FanoutTrack) (*serverPeer, error) {
+	pc, err := api.NewPeerConnection(config)
+	if err != nil {
+		return nil, err
+	}
+	p := &serverPeer{id: id, clients: clients, pc: pc, video: video, audio: audio}
 
 	// Create context to wait for ICE connection
-	iceConnectedCtx, iceConnectedCtxCancel := context.WithCancel(context.Background())
+	iceConnected, iceConnectedCancel := context.WithCancel(context.Background())
+	p.iceConnected = iceConnected
 
 	// ========== 设置事件处理器 ==========
 	// 使用公共函数设置默认的事件处理器
 	// 但我们还需要自定义 ICE 连接状态处理器，用于通知主程序连接已建立
-	setupPeerConnectionHandlers(peerConnection, nil, func(connectionState webrtc.ICEConnectionState) {
-		fmt.Fprintf(os.Stderr, "ICE Connection State: %s\n", connectionState.String())
+	setupPeerConnectionHandlers(pc, nil, func(connectionState webrtc.ICEConnectionState) {
+		fmt.Fprintf(os.Stderr, "%sICE Connection State: %s\n", p.logPrefix(), connectionState.String())
 		if connectionState == webrtc.ICEConnectionStateConnected {
-			fmt.Fprintf(os.Stderr, "ICE connection established!\n")
-			iceConnectedCtxCancel() // 通知主程序可以开始发送视频了
+			fmt.Fprintf(os.Stderr, "%sICE connection established!\n", p.logPrefix())
+			iceConnectedCancel() // 通知主程序可以开始发送视频了
 		} else if connectionState == webrtc.ICEConnectionStateFailed {
-			fmt.Fprintf(os.Stderr, "ERROR: ICE connection failed!\n")
+			fmt.Fprintf(os.Stderr, "%sERROR: ICE connection failed!\n", p.logPrefix())
 		}
 	}, func(s webrtc.PeerConnectionState) {
-		fmt.Fprintf(os.Stderr, "Peer Connection State: %s\n", s.String())
-		if s == webrtc.PeerConnectionStateConnected {
-			fmt.Fprintf(os.Stderr, "Peer connection established!\n")
-		} else if s == webrtc.PeerConnectionStateFailed {
-			fmt.Fprintf(os.Stderr, "ERROR: Peer connection failed!\n")
+		fmt.Fprintf(os.Stderr, "%sPeer Connection State: %s\n", p.logPrefix(), s.String())
+		switch s {
+		case webrtc.PeerConnectionStateConnected:
+			fmt.Fprintf(os.Stderr, "%sPeer connection established!\n", p.logPrefix())
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			if s == webrtc.PeerConnectionStateFailed {
+				fmt.Fprintf(os.Stderr, "%sERROR: Peer connection failed!\n", p.logPrefix())
+			}
+			// 断开的 client 不再接收，其它 client 继续
+			p.leave()
 		}
 	})
 
-	// ========== 第九步：创建视频和音频轨道 ==========
-	// Track 代表一个媒体流，可以是视频或音频
-	// 我们创建 H.264 视频轨道和 Opus 音频轨道（虽然音频当前未使用）
-
-	// 创建 H.264 视频轨道
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "video/h264"}, "video", "pion")
+	// 创建视频轨道
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: outputCodec.mimeType}, "video", "pion")
 	if err != nil {
-		panic(err)
+		pc.Close()
+		return nil, err
 	}
-	_, err = peerConnection.AddTrack(videoTrack)
-	if err != nil {
-		panic(err)
+	if _, err = pc.AddTrack(videoTrack); err != nil {
+		pc.Close()
+		return nil, err
 	}
+	video.Add(id, videoTrack)
 
-	// 创建 Opus 音频轨道（可选，当前未使用）
-	opusTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion1")
-	if err != nil {
-		panic(err)
+	// 创建 Opus 音频轨道
+	if audio != nil {
+		opusTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion1")
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		if _, err = pc.AddTrack(opusTrack); err != nil {
+			p.Close()
+			return nil, err
+		}
+		audio.Add(id, opusTrack)
 	}
-	_, err = peerConnection.AddTrack(opusTrack)
-	if err != nil {
-		panic(err)
+
+	// 持续读取 RTCP：client 的 NACK 只有被读取时才会经过重传 interceptor
+	for _, sender := range pc.GetSenders() {
+		go readSenderRTCP(sender)
 	}
+	return p, nil
+}
 
-	// ========== 第十步：创建 Offer（会话描述） ==========
-	// Offer 包含 Server 支持的编解码器、网络地址等信息
-	offer, err := peerConnection.CreateOffer(nil)
+// createOffer 创建 offer 并等待 ICE 候选收集完成，返回编码后的 offer（带 a=framerate）；
+// -trickle 时不等待，候选由 p.trickle 在收集到时逐个发送
+func (p *serverPeer) createOffer(frameRate float64) string {
+	offer, err := p.pc.CreateOffer(nil)
 	if err != nil {
 		panic(err)
 	}
 
+	if p.trickle != nil {
+		if err = p.pc.SetLocalDescription(offer); err != nil {
+			panic(err)
+		}
+		return p.encodeOffer(frameRate)
+	}
+
 	// ========== 第十一步：等待 ICE 候选收集完成 ==========
 	// 在设置本地描述之前，先创建一个 channel 来等待 ICE 候选收集完成
-	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	gatherComplete := webrtc.GatheringCompletePromise(p.pc)
 
 	// 设置本地描述，这会启动 UDP 监听器，开始收集 ICE 候选
-	if err = peerConnection.SetLocalDescription(offer); err != nil {
+	if err = p.pc.SetLocalDescription(offer); err != nil {
 		panic(err)
 	}
 
 	// 阻塞直到 ICE 候选收集完成
 	// 这确保了 Offer 中包含所有可用的网络地址信息
-	fmt.Fprintf(os.Stderr, "Waiting for ICE gathering to complete...\n")
+	fmt.Fprintf(os.Stderr, "%sWaiting for ICE gathering to complete...\n", p.logPrefix())
 	<-gatherComplete
-	fmt.Fprintf(os.Stderr, "ICE gathering completed\n")
+	fmt.Fprintf(os.Stderr, "%sICE gathering completed\n", p.logPrefix())
+	return p.encodeOffer(frameRate)
+}
 
+// encodeOffer 返回编码后的本地描述（带 a=framerate）
+func (p *serverPeer) encodeOffer(frameRate float64) string {
 	// ========== 输出 Offer ==========
 	// 将 Offer 编码为 base64 字符串，发送给客户端
-	offerStr := encode(peerConnection.LocalDescription()) // 使用公共函数
-	if *offerFile != "" {
-		// 写入文件（用于自动化脚本）
-		err := os.WriteFile(*offerFile, []byte(offerStr+"\n"), 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing offer to file: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Fprintf(os.Stderr, "Offer written to file: %s (%d bytes)\n", *offerFile, len(offerStr))
-	} else {
-		// 输出到 stdout（用于手动复制粘贴）
-		os.Stdout.WriteString(offerStr + "\n")
-		os.Stdout.Sync()
-		fmt.Fprintf(os.Stderr, "Offer written to stdout (%d bytes)\n", len(offerStr))
-	}
-
-	// ========== 等待客户端的 Answer ==========
-	// Answer 是客户端对 Offer 的回应，包含客户端支持的编解码器和网络地址
-	fmt.Fprintf(os.Stderr, "Waiting for answer from client...\n")
-	answer := webrtc.SessionDescription{}
-	var answerStr string
-	if *answerFile != "" {
-		// 从文件读取（用于自动化脚本）
-		fmt.Fprintf(os.Stderr, "Reading answer from file: %s\n", *answerFile)
-		answerStr = readFromFile(*answerFile)
-	} else {
-		// 从 stdin 读取（用于手动复制粘贴）
-		answerStr = readUntilNewline() // 使用公共函数
+	offerDesc, fErr := withSDPFrameRate(*p.pc.LocalDescription(), frameRate)
+	if fErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to add frame rate to offer: %v\n", fErr)
 	}
-	if answerStr == "" {
-		fmt.Fprintf(os.Stderr, "Error: Empty answer received\n")
-		os.Exit(1)
-	}
-	// 验证 Answer 格式（base64 字符串应该比较长）
-	if len(answerStr) < 100 {
-		fmt.Fprintf(os.Stderr, "Error: Answer too short (%d chars), expected base64 string\n", len(answerStr))
-		os.Exit(1)
-	}
-	decode(answerStr, &answer) // 使用公共函数解码
-	fmt.Fprintf(os.Stderr, "Answer received, setting remote description...\n")
+	return encode(&offerDesc) // 使用公共函数
+}
 
-	// Set the remote SessionDescription
-	err = peerConnection.SetRemoteDescription(answer)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to set remote description: %v", err))
+// leave 把 client 移出 fan-out，可以重复调用
+func (p *serverPeer) leave() {
+	removed := p.video.Remove(p.id)
+	if p.audio != nil {
+		p.audio.Remove(p.id)
 	}
-
-	// ========== 第十二步：等待 ICE 连接建立 ==========
-	// 在开始发送视频之前，需要先建立网络连接
-	fmt.Fprintf(os.Stderr, "Waiting for ICE connection to establish...\n")
-	// 添加超时，避免无限等待
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	select {
-	case <-iceConnectedCtx.Done():
-		// ICE 连接已建立，可以开始发送视频
-		fmt.Fprintf(os.Stderr, "ICE connection established, starting video streaming...\n")
-	case <-ctx.Done():
-		// 超时，但继续发送视频（可能连接已经建立，只是事件未触发）
-		fmt.Fprintf(os.Stderr, "WARNING: ICE connection timeout, starting video streaming anyway...\n")
+	if removed && p.clients > 1 {
+		fmt.Fprintf(os.Stderr, "%sClient left, %d client(s) still streaming\n", p.logPrefix(), p.video.Active())
 	}
+}
 
-	// ========== 第十三步：初始化视频源 ==========
-	// 打开视频文件，创建解码器
-	initVideoSource(absPath)
-	defer freeVideoCoding() // 程序退出时释放 FFmpeg 资源
-
-	// ========== 第十四步：启动视频发送 ==========
-	// 创建一个 channel 用于接收视频播放完成的信号
-	videoDone := make(chan bool, 1)
-
-	// 在 goroutine 中启动视频发送（不阻塞主程序）
-	// writeVideoToTrack 会按视频帧率持续发送帧，直到视频播放完毕
-	go writeVideoToTrack(videoTrack, *loop, videoDone)
-
-	// ========== 第十五步：等待视频播放完成 ==========
-	// 主程序在这里等待，直到视频播放完毕或超时
-	select {
-	case <-videoDone:
-		// 视频播放完成，关闭连接
-		fmt.Fprintf(os.Stderr, "Video streaming completed, closing connection...\n")
-		if err := peerConnection.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
-		}
-	case <-time.After(24 * time.Hour):
-		// 安全超时（正常情况下不会触发，只是防止程序永远运行）
-		fmt.Fprintf(os.Stderr, "Timeout waiting for video completion\n")
-		if err := peerConnection.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
+// Close 关闭 PeerConnection，可以重复调用
+func (p *serverPeer) Close() {
+	p.closeOnce.Do(func() {
+		if cErr := p.pc.Close(); cErr != nil {
+			fmt.Fprintf(os.Stderr, "%sError closing peer connection: %v\n", p.logPrefix(), cErr)
 		}
+	})
+}
+
+// logPrefix 是日志前缀：只有一个 client 时为空，与之前的日志一致
+func (p *serverPeer) logPrefix() string {
+	if p.clients <= 1 {
+		return ""
 	}
+	return fmt.Sprintf("[Client %d] ", p.id)
 }
 
-func initVideoSource(videoPath string) {
+func initVideoSource(videoPath string) error {
 	if inputFormatContext = astiav.AllocFormatContext(); inputFormatContext == nil {
-		panic("Failed to AllocFormatContext")
+		return errors.New("failed to allocate format context")
 	}
 
 	// Open input file
 	if err = inputFormatContext.OpenInput(videoPath, nil, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open input file: %v", err))
+		// 打开失败时 FFmpeg 已经释放了内部的 AVFormatContext，只能 Free，不能再 CloseInput
+		inputFormatContext.Free()
+		inputFormatContext = nil
+		return fmt.Errorf("failed to open input file: %w", err)
 	}
 
 	// Find stream info
 	if err = inputFormatContext.FindStreamInfo(nil); err != nil {
-		panic(fmt.Sprintf("Failed to find stream info: %v", err))
+		return fmt.Errorf("failed to find stream info: %w", err)
 	}
 
 	// Find video stream
@@ -316,68 +629,84 @@ func initVideoSource(videoPath string) {
 	}
 
 	if videoStream == nil {
-		panic("No video stream found in file")
+		return fmt.Errorf("%w in %s", ErrNoVideoStream, videoPath)
 	}
 
 	// Get decoder
 	decodeCodec := astiav.FindDecoder(videoStream.CodecParameters().CodecID())
 	if decodeCodec == nil {
-		panic("FindDecoder returned nil")
+		return fmt.Errorf("%w: no decoder for %s", ErrCodecUnsupported, videoStream.CodecParameters().CodecID())
 	}
 
 	if decodeCodecContext = astiav.AllocCodecContext(decodeCodec); decodeCodecContext == nil {
-		panic("Failed to AllocCodecContext")
+		return errors.New("failed to allocate decoder context")
 	}
 
 	if err = videoStream.CodecParameters().ToCodecContext(decodeCodecContext); err != nil {
-		panic(fmt.Sprintf("Failed to copy codec parameters: %v", err))
+		return fmt.Errorf("failed to copy codec parameters: %w", err)
 	}
+	// 解码器的时间基与源流一致：packet 的 RescaleTs 因此不改变时间戳，解码帧的 PTS 直接是源流时间基
+	// （codec parameters 不含时间基，不设置时为 0/1，RescaleTs 会把所有时间戳变成 AV_NOPTS_VALUE）
+	decodeCodecContext.SetTimeBase(videoStream.TimeBase())
 
 	decodeCodecContext.SetFramerate(inputFormatContext.GuessFrameRate(videoStream, nil))
 
 	if err = decodeCodecContext.Open(decodeCodec, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open decoder: %v", err))
+		return fmt.Errorf("failed to open decoder: %w", err)
 	}
 
 	decodePacket = astiav.AllocPacket()
 	decodeFrame = astiav.AllocFrame()
 
 	// Initialize encoder (will be set up after we know the frame size)
+	return nil
 }
 
-func initVideoEncoding() {
+func initVideoEncoding() error {
 	if encodeCodecContext != nil {
-		return
+		return nil
 	}
 
-	h264Encoder := astiav.FindEncoder(astiav.CodecIDH264)
-	if h264Encoder == nil {
-		panic("No H264 Encoder Found")
+	videoEncoder := outputCodec.findEncoder()
+	if videoEncoder == nil {
+		return fmt.Errorf("%w: no %s encoder found", ErrCodecUnsupported, outputCodec.name)
 	}
 
-	if encodeCodecContext = astiav.AllocCodecContext(h264Encoder); encodeCodecContext == nil {
-		panic("Failed to AllocCodecContext Encoder")
+	if encodeCodecContext = astiav.AllocCodecContext(videoEncoder); encodeCodecContext == nil {
+		return errors.New("failed to allocate encoder context")
 	}
 
 	encodeCodecContext.SetPixelFormat(astiav.PixelFormatYuv420P)
 	encodeCodecContext.SetSampleAspectRatio(decodeCodecContext.SampleAspectRatio())
-	encodeCodecContext.SetTimeBase(astiav.NewRational(1, 30))
+	// 时间基取源帧率的倒数：恒定帧率的源每帧 pts 加 1（见 sourcePTSValidator.EncoderPTS），编码器的码率控制按真实帧率分配比特
+	encodeFrameRate := videoFrameRate(inputFormatContext, videoStream)
+	encodeCodecContext.SetTimeBase(encodeFrameRate.Invert())
+	encodeCodecContext.SetFramerate(encodeFrameRate)
 	encodeCodecContext.SetWidth(decodeCodecContext.Width())
 	encodeCodecContext.SetHeight(decodeCodecContext.Height())
-
-	encodeCodecContextDictionary := astiav.NewDictionary()
-	if err = encodeCodecContextDictionary.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+	if outputCodec.codecID == astiav.CodecIDVp8 {
+		encodeCodecContext.SetBitRate(vp8BitRate)
 	}
-	if err = encodeCodecContextDictionary.Set("tune", "zerolatency", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+	if keyframeInterval > 0 {
+		encodeCodecContext.SetGopSize(keyframeInterval)
 	}
-	if err = encodeCodecContextDictionary.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+
+	options := outputCodec.encoderOptions()
+	if outputCodec.name == videoCodecAV1.name {
+		options = av1EncoderOptions(videoEncoder.Name(), av1TemporalLayers)
+		fmt.Fprintf(os.Stderr, "Encoding AV1 with %s (%d temporal layer(s))\n", videoEncoder.Name(), av1TemporalLayers)
+	}
+	// -bitrate：恒定码率，覆盖上面的默认码率与 CRF（见 cbr.go）
+	options = cbrTarget.Apply(encodeCodecContext, videoEncoder.Name(), encodeFrameRate, options)
+	encodeCodecContextDictionary := astiav.NewDictionary()
+	for _, option := range options {
+		if err = encodeCodecContextDictionary.Set(option[0], option[1], astiav.NewDictionaryFlags()); err != nil {
+			return err
+		}
 	}
 
-	if err = encodeCodecContext.Open(h264Encoder, encodeCodecContextDictionary); err != nil {
-		panic(fmt.Sprintf("Failed to open encoder: %v", err))
+	if err = encodeCodecContext.Open(videoEncoder, encodeCodecContextDictionary); err != nil {
+		return fmt.Errorf("failed to open encoder: %w", err)
 	}
 
 	softwareScaleContext, err = astiav.CreateSoftwareScaleContext(
@@ -390,23 +719,48 @@ func initVideoEncoding() {
 		astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBilinear),
 	)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create scale context: %v", err))
+		return fmt.Errorf("failed to create scale context: %w", err)
 	}
 
 	scaledFrame = astiav.AllocFrame()
+	// 与 decodePacket 一样只分配一次，编码循环中每个包用完后 Unref
+	encodePacket = astiav.AllocPacket()
+	return nil
 }
 
-func writeVideoToTrack(track *webrtc.TrackLocalStaticSample, loopVideo bool, done chan<- bool) {
-	frameRate := videoStream.AvgFrameRate()
-	if frameRate.Num() == 0 {
-		frameRate = astiav.NewRational(30, 1)
-	}
-	h264FrameDuration := time.Duration(float64(time.Second) * float64(frameRate.Den()) / float64(frameRate.Num()))
+// videoSampleWriter 接收编码后的 sample：正常运行时是 FanoutTrack，-benchmark 时是只计数的 VideoBenchmark
+type videoSampleWriter interface {
+	WriteSample(sample media.Sample) error
+}
+
+// writeVideoToTrack 解码、缩放、编码源视频，把每个编码后的 sample 写到 track 中所有 client 的轨道；
+// 所有 client 都断开后停止。-benchmark 时不按帧率等待，并统计每帧的编码耗时（见 benchmark.go）
+func writeVideoToTrack(track videoSampleWriter, loopVideo bool, done chan<- bool) {
+	h264FrameDuration := frameRateInterval(videoFrameRate(inputFormatContext, videoStream))
+	// 解码帧 PTS 的校验与单调化（解码时间基即源流时间基，见 initVideoSource）
+	sourcePTS := newSourcePTSValidator(decodeCodecContext.TimeBase(), videoFrameRate(inputFormatContext, videoStream))
+	defer sourcePTS.Report("[Server]")
 
 	ticker := time.NewTicker(h264FrameDuration)
 	defer ticker.Stop()
+	pace := func() { <-ticker.C }
+	if videoBenchmark != nil {
+		pace = func() {}
+	}
+
+	// Skip empty packets and carry header-only packets (SPS/PPS) into the next frame
+	var frameAssembler encodedFrameAssembler
+	defer frameAssembler.Report("[Server]")
+	// 参数集合并按 H.264 的 NAL 类型判断；VP8 的每个非空 packet 就是一帧，x265 开启 repeat-headers 后参数集与关键帧在同一个 packet 中
+	nextSample := frameAssembler.Next
+	if outputCodec.codecID != astiav.CodecIDH264 {
+		nextSample = func(data []byte) ([]byte, bool) { return data, len(data) > 0 }
+	}
+	// 循环回到开头后，下一帧的内容与上一帧无关；不强制 IDR 时编码器用末尾的帧作参考，client 在循环点看到花屏直到下一个 GOP
+	forceKeyframe := false
 
-	for range ticker.C {
+	for {
+		pace()
 		decodePacket.Unref()
 
 		// Read frame from file
@@ -419,11 +773,28 @@ func writeVideoToTrack(track *webrtc.TrackLocalStaticSample, loopVideo bool, don
 						break
 					}
 					pts = 0
+					sourcePTS.Reset()
+					forceKeyframe = keyframeOnLoop
 					fmt.Fprintf(os.Stderr, "Video looped, restarting from beginning...\n")
 					continue
 				} else {
 					// Play once, stop when EOF
 					fmt.Fprintf(os.Stderr, "Video playback completed (EOF reached)\n")
+					// Flush frames still buffered inside the encoder, keeping the frame interval
+					flushed, fErr := flushEncoder(encodeCodecContext, func(pkt *astiav.Packet) error {
+						data, ok := nextSample(pkt.Data())
+						if !ok {
+							return nil
+						}
+						cbrTarget.OnSample(len(data), h264FrameDuration)
+						pace()
+						return track.WriteSample(media.Sample{Data: data, Duration: h264FrameDuration})
+					})
+					if fErr != nil {
+						reportRecoverableError("Error flushing encoder", fErr)
+					} else if flushed > 0 {
+						fmt.Fprintf(os.Stderr, "Flushed %d buffered packets from encoder\n", flushed)
+					}
 					// Send completion signal
 					select {
 					case done <- true:
@@ -432,7 +803,7 @@ func writeVideoToTrack(track *webrtc.TrackLocalStaticSample, loopVideo bool, don
 					break
 				}
 			}
-			fmt.Fprintf(os.Stderr, "Error reading frame: %v\n", err)
+			reportRecoverableError("Error reading frame", err)
 			continue
 		}
 
@@ -445,7 +816,7 @@ func writeVideoToTrack(track *webrtc.TrackLocalStaticSample, loopVideo bool, don
 
 		// Send the packet to decoder
 		if err = decodeCodecContext.SendPacket(decodePacket); err != nil {
-			fmt.Fprintf(os.Stderr, "Error sending packet to decoder: %v\n", err)
+			reportRecoverableError("Error sending packet to decoder", err)
 			continue
 		}
 
@@ -455,81 +826,119 @@ func writeVideoToTrack(track *webrtc.TrackLocalStaticSample, loopVideo bool, don
 				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
 					break
 				}
-				fmt.Fprintf(os.Stderr, "Error receiving frame: %v\n", err)
+				reportRecoverableError("Error receiving frame", err)
 				break
 			}
+			decodedPTS := sourcePTS.Validate(decodeFrame)
 
 			// Init the Scaling+Encoding. Can't be started until we know info on input video
-			initVideoEncoding()
+			if eErr := initVideoEncoding(); eErr != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to initialize encoder: %v, stopping video streaming\n", eErr)
+				select {
+				case done <- true:
+				default:
+				}
+				return
+			}
 
 			// Scale the video
+			if err = ensureScalerSource(softwareScaleContext, decodeFrame); err != nil {
+				reportRecoverableError("Error reconfiguring scaler", err)
+				continue
+			}
 			if err = softwareScaleContext.ScaleFrame(decodeFrame, scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scaling frame: %v\n", err)
+				reportRecoverableError("Error scaling frame", err)
 				continue
 			}
 
 			// Set PTS
-			pts++
+			pts = sourcePTS.EncoderPTS(decodedPTS, pts, encodeCodecContext.TimeBase())
 			scaledFrame.SetPts(pts)
+			if forceKeyframe {
+				scaledFrame.SetPictureType(astiav.PictureTypeI)
+				forceKeyframe = false
+			} else {
+				scaledFrame.SetPictureType(astiav.PictureTypeNone)
+			}
 
 			// Encode the frame
+			encodeStart := time.Now()
 			if err = encodeCodecContext.SendFrame(scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error sending frame to encoder: %v\n", err)
+				reportRecoverableError("Error sending frame to encoder", err)
 				continue
 			}
 
 			for {
 				// Read encoded packets
-				encodePacket = astiav.AllocPacket()
 				if err = encodeCodecContext.ReceivePacket(encodePacket); err != nil {
 					if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-						encodePacket.Free()
 						break
 					}
-					encodePacket.Free()
-					fmt.Fprintf(os.Stderr, "Error receiving packet: %v\n", err)
+					reportRecoverableError("Error receiving packet", err)
 					break
 				}
 
-				// Write H264 to track
-				if err = track.WriteSample(media.Sample{Data: encodePacket.Data(), Duration: h264FrameDuration}); err != nil {
-					encodePacket.Free()
-					fmt.Fprintf(os.Stderr, "Error writing sample: %v\n", err)
+				// Write the encoded frame to track
+				// Data() 返回 Go 切片副本，WriteSample 打包时再复制到各 RTP 包，encodePacket 可以随后立即 Unref
+				data, ok := nextSample(encodePacket.Data())
+				encodePacket.Unref()
+				if !ok {
+					continue
+				}
+				cbrTarget.OnSample(len(data), h264FrameDuration)
+				if err = track.WriteSample(media.Sample{Data: data, Duration: h264FrameDuration}); err != nil {
+					if errors.Is(err, errNoFanoutClients) {
+						fmt.Fprintf(os.Stderr, "No clients left, stopping video streaming\n")
+						select {
+						case done <- true:
+						default:
+						}
+						return
+					}
+					reportRecoverableError("Error writing sample", err)
 					continue
 				}
-
-				encodePacket.Free()
 			}
+			videoBenchmark.OnFrameEncoded(time.Since(encodeStart))
 		}
 	}
 }
 
+// freeVideoCoding 释放 FFmpeg 相关的全局状态并置为 nil：可以重复调用，也可以在 initVideoSource / initVideoEncoding 中途失败后调用
 func freeVideoCoding() {
 	if inputFormatContext != nil {
 		inputFormatContext.CloseInput()
 		inputFormatContext.Free()
+		inputFormatContext = nil
 	}
 
 	if decodeCodecContext != nil {
 		decodeCodecContext.Free()
+		decodeCodecContext = nil
 	}
 	if decodePacket != nil {
 		decodePacket.Free()
+		decodePacket = nil
 	}
 	if decodeFrame != nil {
 		decodeFrame.Free()
+		decodeFrame = nil
 	}
 
 	if scaledFrame != nil {
 		scaledFrame.Free()
+		scaledFrame = nil
 	}
 	if softwareScaleContext != nil {
 		softwareScaleContext.Free()
+		softwareScaleContext = nil
 	}
 	if encodeCodecContext != nil {
 		encodeCodecContext.Free()
+		encodeCodecContext = nil
 	}
 	if encodePacket != nil {
 		encodePacket.Free()
+		encodePacket = nil
 	}
 }
@@ -12,7 +12,6 @@ package main
 import (
 	"context"
 	"encoding/csv"
-	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -22,24 +21,108 @@ import (
 
 	"github.com/asticode/go-astiav"
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
 )
 
 func main() {
 	videoFile := flag.String("video", "", "Video file path (e.g., assets/Ultra.mp4)")
-	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-detect")
+	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-select the best interface address; 'any' gathers on all interfaces")
 	offerFile := flag.String("offer-file", "", "Path to file to write offer (optional, if not specified, write to stdout)")
 	answerFile := flag.String("answer-file", "", "Path to file containing answer (optional, if not specified, read from stdin)")
+	signalURL := flag.String("signal-url", "", "Exchange SDP through an sdp-bridge room instead of files or stdin/stdout: POST the offer to <url>/offer and poll <url>/answer (e.g. http://bridge.example.com:8080/demo), or with a ws:// / wss:// URL send the offer and receive the answer over the room's WebSocket")
 	loop := flag.Bool("loop", false, "Loop video playback (default: false, play once)")
+	sessionTimeout := flag.Duration("session-timeout", time.Hour, "Maximum session length for unattended runs: close the connection once streaming has run this long, e.g. 90m (0 = unlimited)")
+	maxBytes := flag.Int64("max-bytes", 0, "Stop streaming before the cumulative encoded video bytes exceed this cap, then close the session (0 = unlimited). Bytes actually sent are reported at shutdown")
 	sessionDir := flag.String("session-dir", "", "Session directory for this experiment (optional, used mainly by scripts)")
 	safetyMargin := flag.Float64("burst-safety-margin", 0.7, "Safety margin for burst rate control (default: 0.7)")
-	frameInterval := flag.Duration("burst-frame-interval", time.Second/30, "Frame interval (default: 1/30s for 30fps)")
+	frameInterval := flag.Duration("burst-frame-interval", 0, "Frame interval override (0 = use the source frame rate)")
+	flag.BoolVar(&abortOnFirstError, "abort-on-first-error", false, "Strict mode: exit immediately on recoverable pipeline errors (decode/scale/encode/write) instead of logging and continuing")
+	printSDPCaps := flag.Bool("print-sdp-capabilities", false, "Print supported codecs, RTCP feedback and header extensions from a dummy offer, then exit")
+	helpExperiments := flag.Bool("help-experiments", false, "Print every available experiment (rate controller) with a one-line algorithm summary and its tunable parameters and defaults, then exit")
+	rtcpLog := flag.Bool("rtcp-log", false, "Log every sent/received RTCP packet with parsed fields to <session-dir>/rtcp_server.csv (requires -session-dir)")
+	resourceUsage := flag.Bool("resource-usage", false, "Sample process CPU time and RSS every second to <session-dir>/resource_usage_server.csv and print mean/peak at exit (requires -session-dir)")
+	pprofAddr := flag.String("pprof", "", "Serve net/http/pprof on this address (e.g. :6060) to profile CPU and allocations during the session")
+	statsInterval := flag.Duration("stats-interval", 0, "Print one aggregate health line (frames sent, send bitrate, RTT and loss from receiver reports, reconnects) every interval, e.g. 10s (0 = disabled)")
+	frameHash := flag.Bool("frame-hash", false, "Write a SHA-256 of every sent access unit's NAL units to <session-dir>/frame_hashes_server.csv; diff against the other side to verify a lossless round trip (requires -session-dir)")
+	flag.BoolVar(&verifyParamSets, "verify-param-sets", false, "Log every SPS/PPS that differs from the previously sent one (encoder rebuilds or candidate switches change them mid-stream) and print a summary at exit")
+	compat := flag.String("compat", "none", "H.264 receiver compatibility: none, or constrained-baseline to advertise only profile-level-id 42e01f and force the encoder to profile baseline, no B-frames and CAVLC")
+	hwaccelName := flag.String("hwaccel", "none", "H.264 encoder: none (software x264), nvenc (h264_nvenc on a CUDA device) or vaapi (h264_vaapi); falls back to software with a warning when the hardware encoder or device is unavailable")
+	latencyMode := flag.String("encoder-latency-mode", "idr", "Keyframe strategy: idr (periodic IDR keyframes) or intra-refresh (x264 periodic intra refresh: an intra column sweeps the picture once per GOP instead of sending large IDRs; receivers recover at the end of each refresh cycle)")
+	keyframeMinInterval := flag.Duration("keyframe-min-interval", 0, "Honor PLI/FIR keyframe requests from the receiver, forcing at most one IDR per interval, e.g. 1s; requests arriving while waiting are merged into that single IDR (0 = disabled, PLI/FIR are ignored)")
+	keyframeWindow := flag.Duration("keyframe-coalesce-window", 200*time.Millisecond, "With -keyframe-min-interval, treat requests arriving this soon after a forced IDR as already satisfied by it (the request was sent before the IDR arrived)")
+	debugOverlayOn := flag.Bool("debug-overlay", false, "Burn a rolling graph of the controller's target bitrate, bandwidth estimate and QP/CRF into the top-left corner of each frame before encoding (costs CPU and changes the encoded video; for demos and debugging)")
+	controllerStateInterval := flag.Duration("controller-state-interval", 0, "Snapshot the rate controller's internal state (capacity estimate, frame budget, window mean/variance, loss rate) every interval to <session-dir>/controller_state.csv, e.g. 100ms (0 = disabled; requires -session-dir)")
+	startupRampFrames := flag.Int("startup-ramp", 0, "Cap the frame budget of the first N frames, ramping geometrically from -startup-bitrate up to the controller's budget, so the first keyframe does not overwhelm a constrained link (0 = disabled). Logged per frame to <session-dir>/startup_ramp.csv when -session-dir is set")
+	startupBitrate := flag.Int("startup-bitrate", 300, "Bitrate in kbps of the first frame during -startup-ramp")
+	degradeKbps := flag.Int("degrade-resolution-kbps", 0, "Drop the encode resolution one step (1, 3/4, 1/2 of the source) when the frame budget stays below this bitrate in kbps for -degrade-resolution-hold, and step back up once it stays above 1.5x this value; each switch rebuilds the scaler and encoder and starts with a keyframe (0 = disabled). Switches are logged to <session-dir>/resolution_switches.csv when -session-dir is set")
+	degradeHold := flag.Duration("degrade-resolution-hold", 3*time.Second, "How long the frame budget must stay below / above the -degrade-resolution-kbps thresholds before switching resolution")
+	sendPressureThreshold := flag.Float64("send-pressure", 0, "Treat the local UDP send buffer filling up as congestion: a frame whose WriteSample calls block for at least this fraction of the frame interval (e.g. 0.25) counts as blocked and makes the controller back off (0 = disabled). Logged per frame to <session-dir>/send_pressure.csv when -session-dir is set")
+	ssrcList := flag.String("ssrc", "", "Fixed SSRCs for the local tracks as video[,audio] (e.g. 1000 or 1000,2000; one value means audio uses video+1). Empty lets pion pick random SSRCs")
+	cname := flag.String("cname", "", "CNAME (and stream ID) for all local tracks, so captures and RTCP logs from different runs line up. Empty keeps the defaults")
+	rtcpBWE := flag.Bool("rtcp-bwe", false, "Estimate available bandwidth from receiver RTCP feedback (TWCC through the GCC send-side estimator, capped by REMB) and use it for the frame budget instead of the send-side throughput. The client must negotiate transport-cc (pion clients do by default)")
 	flag.Parse()
 
+	if *helpExperiments {
+		printExperiments(os.Stdout)
+		return
+	}
+
+	trackIdentity, err := parseTrackIdentity(*ssrcList, *cname, 2)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -ssrc/-cname: %v\n", err)
+		os.Exit(1)
+	}
+	if h264Compat, err = parseH264Compat(*compat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -compat: %v\n", err)
+		os.Exit(1)
+	}
+	if hwAccel, err = parseHWAccel(*hwaccelName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -hwaccel: %v\n", err)
+		os.Exit(1)
+	}
+	if encoderLatencyMode, err = parseEncoderLatencyMode(*latencyMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -encoder-latency-mode: %v\n", err)
+		os.Exit(1)
+	}
+	if encoderLatencyMode == EncoderLatencyIntraRefresh {
+		fmt.Fprintf(os.Stderr, "[BurstRTC] Intra refresh enabled: no periodic IDRs, forced keyframes start a new refresh cycle\n")
+	}
+	if *signalURL != "" && (*offerFile != "" || *answerFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: -signal-url cannot be combined with -offer-file or -answer-file\n")
+		os.Exit(1)
+	}
+	if *keyframeMinInterval < 0 || *keyframeWindow < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -keyframe-min-interval and -keyframe-coalesce-window must be >= 0\n")
+		os.Exit(1)
+	}
+	if *keyframeMinInterval > 0 {
+		keyframeRequests = NewKeyframeRequests(*keyframeWindow, *keyframeMinInterval)
+		defer keyframeRequests.Close()
+	}
+
+	if *printSDPCaps {
+		// -rtcp-bwe 注册 TWCC 发送端头部扩展，与下面创建 API 时一致
+		if *rtcpBWE {
+			bitrateEstimator = NewBitrateEstimator()
+		}
+		if err := printSDPCapabilities(*localIP, 50000, 50100, newSDPCapabilitiesAPI); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing SDP capabilities: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *videoFile == "" {
 		fmt.Fprintf(os.Stderr, "Error: -video parameter is required\n")
 		os.Exit(1)
 	}
+	if *sessionTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -session-timeout must be >= 0 (0 = unlimited)\n")
+		os.Exit(1)
+	}
+	if *maxBytes < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -max-bytes must be >= 0\n")
+		os.Exit(1)
+	}
 
 	if *sessionDir != "" {
 		if err := os.MkdirAll(*sessionDir, 0o755); err != nil {
@@ -48,6 +131,89 @@ func main() {
 		}
 	}
 
+	var rtcpLogger *RTCPLogger
+	if *rtcpLog {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -rtcp-log requires -session-dir\n")
+			os.Exit(1)
+		}
+		logger, lErr := NewRTCPLogger(filepath.Join(*sessionDir, "rtcp_server.csv"))
+		if lErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating RTCP log: %v\n", lErr)
+			os.Exit(1)
+		}
+		rtcpLogger = logger
+		defer rtcpLogger.Close()
+	}
+
+	if *pprofAddr != "" {
+		if pErr := startPprofServer(*pprofAddr); pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error starting pprof server: %v\n", pErr)
+			os.Exit(1)
+		}
+	}
+
+	if *statsInterval > 0 {
+		healthStats = NewHealthStats("server")
+	}
+
+	if *resourceUsage {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -resource-usage requires -session-dir\n")
+			os.Exit(1)
+		}
+		monitor, mErr := NewResourceMonitor(filepath.Join(*sessionDir, "resource_usage_server.csv"))
+		if mErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating resource usage log: %v\n", mErr)
+			os.Exit(1)
+		}
+		defer monitor.Close()
+	}
+
+	var frameHashes *FrameHashWriter
+	if *frameHash {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -frame-hash requires -session-dir\n")
+			os.Exit(1)
+		}
+		var hErr error
+		frameHashes, hErr = NewFrameHashWriter(filepath.Join(*sessionDir, "frame_hashes_server.csv"))
+		if hErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating frame hash log: %v\n", hErr)
+			os.Exit(1)
+		}
+		defer frameHashes.Close()
+	}
+
+	if *controllerStateInterval < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -controller-state-interval must be >= 0\n")
+		os.Exit(1)
+	}
+	if *controllerStateInterval > 0 && *sessionDir == "" {
+		fmt.Fprintf(os.Stderr, "Error: -controller-state-interval requires -session-dir\n")
+		os.Exit(1)
+	}
+	if *startupRampFrames < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -startup-ramp must be >= 0\n")
+		os.Exit(1)
+	}
+	if *startupRampFrames > 0 && *startupBitrate <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -startup-bitrate must be > 0\n")
+		os.Exit(1)
+	}
+	if *degradeKbps < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -degrade-resolution-kbps must be >= 0\n")
+		os.Exit(1)
+	}
+	if *degradeKbps > 0 && *degradeHold <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -degrade-resolution-hold must be > 0\n")
+		os.Exit(1)
+	}
+	if *sendPressureThreshold < 0 || *sendPressureThreshold > 1 {
+		fmt.Fprintf(os.Stderr, "Error: -send-pressure must be between 0 and 1\n")
+		os.Exit(1)
+	}
+
 	if _, err := os.Stat(*videoFile); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Error: video file not found: %s\n", *videoFile)
 		os.Exit(1)
@@ -59,6 +225,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 源帧率：用于发送节奏与编码器时间基，并通过 offer 的 a=framerate 告知 client
+	sourceFrameRate, err := probeVideoFrameRate(absPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to probe video file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Source frame rate: %s (%.3f fps)\n", sourceFrameRate, sourceFrameRate.Float64())
+
 	astiav.RegisterAllDevices()
 
 	// WebRTC SettingEngine
@@ -75,7 +249,23 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Starting ICE gathering (localhost mode, no STUN, fixed port range 50000-50100)...\n")
 	}
 
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	// 记录每帧的 RTP 时间戳写入 frame_metadata.csv，client 据此对齐帧号
+	if *sessionDir != "" {
+		sentRTPTimestamps = NewSentRTPTimestamps()
+	}
+
+	// 根据接收端的 TWCC / REMB 反馈估计带宽（-rtcp-bwe），需要在创建 API 之前设置
+	if *rtcpBWE {
+		bitrateEstimator = NewBitrateEstimator()
+		healthStats.SetBandwidthEstimate(bitrateEstimator.EstimatedBitrate)
+		defer bitrateEstimator.Report("[BurstRTC]")
+		fmt.Fprintf(os.Stderr, "[BurstRTC] RTCP bandwidth estimation enabled (TWCC + REMB)\n")
+	}
+
+	api, err := newWebRTCAPI(settingEngine, rtcpLogger)
+	if err != nil {
+		panic(err)
+	}
 
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
@@ -92,6 +282,7 @@ func main() {
 
 	setupPeerConnectionHandlers(peerConnection, nil, func(connectionState webrtc.ICEConnectionState) {
 		fmt.Fprintf(os.Stderr, "ICE Connection State: %s\n", connectionState.String())
+		healthStats.OnICEConnectionState(connectionState)
 		if connectionState == webrtc.ICEConnectionStateConnected {
 			fmt.Fprintf(os.Stderr, "ICE connection established!\n")
 			iceConnectedCtxCancel()
@@ -109,26 +300,31 @@ func main() {
 		}
 	})
 
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "video/h264"}, "video", "pion",
-	)
+	// 视频轨道：每帧分成 burst 与 pacing 两部分发送（见 burst_pacer.go）
+	burstPacer, err := NewBurstPacer("video", trackIdentity.StreamID("pion"))
 	if err != nil {
 		panic(err)
 	}
-	if _, err = peerConnection.AddTrack(videoTrack); err != nil {
+	defer burstPacer.Report("[BurstRTC]")
+	if _, err = addTrackWithSSRC(peerConnection, burstPacer.Track(), trackIdentity.SSRC(0)); err != nil {
 		panic(err)
 	}
 
 	opusTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion1",
+		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", trackIdentity.StreamID("pion1"),
 	)
 	if err != nil {
 		panic(err)
 	}
-	if _, err = peerConnection.AddTrack(opusTrack); err != nil {
+	if _, err = addTrackWithSSRC(peerConnection, opusTrack, trackIdentity.SSRC(1)); err != nil {
 		panic(err)
 	}
 
+	// 收到的 RTCP 只有在应用层读取时才会经过 interceptor：NACK 重传（rtx）与 -rtcp-log 都依赖持续读取
+	for _, sender := range peerConnection.GetSenders() {
+		go drainSenderRTCP(sender)
+	}
+
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
 		panic(err)
@@ -143,8 +339,17 @@ func main() {
 	<-gatherComplete
 	fmt.Fprintf(os.Stderr, "ICE gathering completed\n")
 
-	offerStr := encode(peerConnection.LocalDescription())
-	if *offerFile != "" {
+	offerDesc, fErr := withSDPFrameRate(*peerConnection.LocalDescription(), sourceFrameRate.Float64())
+	if fErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to add frame rate to offer: %v\n", fErr)
+	}
+	offerStr := encode(&offerDesc)
+	if *signalURL != "" {
+		if err := postSignal(*signalURL, "offer", offerStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *offerFile != "" {
 		if err := os.WriteFile(*offerFile, []byte(offerStr+"\n"), 0o644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing offer to file: %v\n", err)
 			os.Exit(1)
@@ -159,7 +364,9 @@ func main() {
 	fmt.Fprintf(os.Stderr, "Waiting for answer from client...\n")
 	answer := webrtc.SessionDescription{}
 	var answerStr string
-	if *answerFile != "" {
+	if *signalURL != "" {
+		answerStr = pollSignal(*signalURL, "answer")
+	} else if *answerFile != "" {
 		fmt.Fprintf(os.Stderr, "Reading answer from file: %s\n", *answerFile)
 		answerStr = readFromFile(*answerFile)
 	} else {
@@ -176,8 +383,10 @@ func main() {
 	decode(answerStr, &answer)
 	fmt.Fprintf(os.Stderr, "Answer received, setting remote description...\n")
 	if err = peerConnection.SetRemoteDescription(answer); err != nil {
-		panic(fmt.Sprintf("Failed to set remote description: %v", err))
+		reportNegotiationFailure(os.Stderr, peerConnection.LocalDescription(), answer, err)
+		os.Exit(1)
 	}
+	logRTXNegotiation(peerConnection)
 
 	fmt.Fprintf(os.Stderr, "Waiting for ICE connection to establish...\n")
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -190,10 +399,22 @@ func main() {
 		fmt.Fprintf(os.Stderr, "WARNING: ICE connection timeout, starting video streaming anyway...\n")
 	}
 
-	initVideoSource(absPath)
+	if err := initVideoSource(absPath); err != nil {
+		freeVideoCoding()
+		fmt.Fprintf(os.Stderr, "Error: failed to initialize video source: %v\n", err)
+		os.Exit(1)
+	}
 	defer freeVideoCoding()
 
+	if *debugOverlayOn {
+		debugOverlay = NewDebugOverlay(videoFrameRate(inputFormatContext, videoStream).Invert())
+		defer debugOverlay.Free()
+	}
+
 	// 创建 BurstRTC 控制器
+	if *frameInterval <= 0 {
+		*frameInterval = frameRateInterval(sourceFrameRate)
+	}
 	burstCtrl := NewBurstController(BurstConfig{
 		FrameInterval: *frameInterval,
 		SafetyMargin:  *safetyMargin,
@@ -201,6 +422,61 @@ func main() {
 		BurstFraction: 0.3, // 默认 30% burst
 	})
 
+	// 启动阶段的预算爬升（-startup-ramp）
+	if *startupRampFrames > 0 {
+		rampCSV := ""
+		if *sessionDir != "" {
+			rampCSV = filepath.Join(*sessionDir, "startup_ramp.csv")
+		}
+		var rErr error
+		startupRamp, rErr = NewStartupRamp(*startupRampFrames, float64(*startupBitrate)*1000, *frameInterval, rampCSV)
+		if rErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating startup ramp: %v\n", rErr)
+			os.Exit(1)
+		}
+		defer startupRamp.Close()
+	}
+
+	// 持续拥塞时降低分辨率（-degrade-resolution-kbps）
+	if *degradeKbps > 0 {
+		switchCSV := ""
+		if *sessionDir != "" {
+			switchCSV = filepath.Join(*sessionDir, "resolution_switches.csv")
+		}
+		var aErr error
+		resolutionAdapter, aErr = NewResolutionAdapter(float64(*degradeKbps)*1000, *degradeHold, switchCSV)
+		if aErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating resolution adapter: %v\n", aErr)
+			os.Exit(1)
+		}
+		defer resolutionAdapter.Close()
+	}
+
+	// 本地发送缓冲区压力作为拥塞信号（-send-pressure）
+	if *sendPressureThreshold > 0 {
+		pressureCSV := ""
+		if *sessionDir != "" {
+			pressureCSV = filepath.Join(*sessionDir, "send_pressure.csv")
+		}
+		var pErr error
+		sendPressure, pErr = NewSendPressureMonitor(*sendPressureThreshold, *frameInterval, pressureCSV)
+		if pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating send pressure monitor: %v\n", pErr)
+			os.Exit(1)
+		}
+		defer sendPressure.Close()
+	}
+
+	// 控制器内部状态的时间序列（-controller-state-interval），与逐帧日志分开
+	if *controllerStateInterval > 0 {
+		stateLogger, sErr := NewControllerStateLogger(filepath.Join(*sessionDir, "controller_state.csv"), *controllerStateInterval, burstCtrl.State)
+		if sErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating controller state log: %v\n", sErr)
+			os.Exit(1)
+		}
+		defer stateLogger.Close()
+	}
+
 	// 创建 metrics CSV writer（如果 session-dir 存在）
 	var metricsWriter *BurstMetricsWriter
 	if *sessionDir != "" {
@@ -227,12 +503,28 @@ func main() {
 		}
 	}
 
+	// 编码字节预算（-max-bytes）；未设置时只统计发送字节数
+	// -send-pressure 由 burstPacer 在实际写入 RTP 包时统计，不包括 pacing 的等待
+	budgetTrack := NewByteBudgetTrack(burstPacer, *maxBytes)
+	defer budgetTrack.Report("[BurstRTC]")
+	// 逐帧哈希（-frame-hash）只记录最终成功发送的帧
+	// SPS/PPS 变化检查（-verify-param-sets）
+	paramSetTrack := NewParamSetTrack(budgetTrack, "[BurstRTC]")
+	defer paramSetTrack.Report()
+	sendTrack := NewFrameHashTrack(paramSetTrack, frameHashes)
+
+	stopHealth := healthStats.Start(*statsInterval)
+	defer stopHealth()
+
 	videoDone := make(chan bool, 1)
-	go writeVideoToTrackBurst(videoTrack, *loop, burstCtrl, metricsWriter, videoDone, connectionClosedCtx, metadataWriter)
+	logFrame := func(obs FrameObservation) { logBurstFrame(burstCtrl, metricsWriter, obs) }
+	go writeVideoToTrack("[BurstRTC]", sendTrack, burstPacer, *loop, burstCtrl, logFrame, videoDone, connectionClosedCtx, metadataWriter)
 
 	select {
 	case <-videoDone:
 		fmt.Fprintf(os.Stderr, "Video streaming completed, closing connection...\n")
+		// 先通知 client 正常结束（RTCP BYE），避免它把关闭连接当成中断
+		sendEndOfStream(peerConnection)
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
@@ -241,8 +533,10 @@ func main() {
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
-	case <-time.After(24 * time.Hour):
-		fmt.Fprintf(os.Stderr, "Timeout waiting for video completion\n")
+	case <-sessionTimeoutChannel(*sessionTimeout):
+		fmt.Fprintf(os.Stderr, "[BurstRTC] Session timeout: streaming ran for %v (-session-timeout), closing connection...\n", *sessionTimeout)
+		// 与正常结束一样先发送 RTCP BYE，client 按正常结束处理
+		sendEndOfStream(peerConnection)
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
@@ -285,6 +579,12 @@ func NewBurstMetricsWriter(csvPath string) (*BurstMetricsWriter, error) {
 		"est_capacity_bps",
 		"frame_size_mean",
 		"frame_size_var",
+		"burst_packets",
+		"paced_packets",
+		"burst_bits",
+		"paced_bits",
+		"burst_duration_ms", // 第一个包到 burst 部分写完
+		"paced_duration_ms", // burst 写完到最后一个包写完
 	}
 	if err = w.Write(header); err != nil {
 		f.Close()
@@ -299,7 +599,7 @@ func NewBurstMetricsWriter(csvPath string) (*BurstMetricsWriter, error) {
 }
 
 // WriteBurstMetric 写入一条 BurstRTC 帧级指标
-func (m *BurstMetricsWriter) WriteBurstMetric(frameIndex, targetBits, actualBits int, burstFraction float64, sendStart, sendEnd time.Time, estCapacityBps, meanBits, varBits float64) {
+func (m *BurstMetricsWriter) WriteBurstMetric(frameIndex, targetBits, actualBits int, burstFraction float64, sendStart, sendEnd time.Time, estCapacityBps, meanBits, varBits float64, split BurstSendResult) {
 	if m == nil || m.writer == nil {
 		return
 	}
@@ -320,6 +620,12 @@ func (m *BurstMetricsWriter) WriteBurstMetric(frameIndex, targetBits, actualBits
 		fmt.Sprintf("%.2f", estCapacityBps),
 		fmt.Sprintf("%.2f", meanBits),
 		fmt.Sprintf("%.2f", varBits),
+		fmt.Sprintf("%d", split.BurstPackets),
+		fmt.Sprintf("%d", split.PacedPackets),
+		fmt.Sprintf("%d", split.BurstBits),
+		fmt.Sprintf("%d", split.PacedBits),
+		fmt.Sprintf("%.3f", split.BurstEnd.Sub(split.BurstStart).Seconds()*1000),
+		fmt.Sprintf("%.3f", split.SendEnd.Sub(split.BurstEnd).Seconds()*1000),
 	}
 	if err := m.writer.Write(record); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing BurstRTC metrics CSV: %v\n", err)
@@ -346,207 +652,13 @@ func (m *BurstMetricsWriter) Close() {
 	}
 }
 
-// writeVideoToTrackBurst 基于 FFmpeg 解码+编码，将 H.264 帧发送到 WebRTC video track，
-// 同时为每一帧更新 BurstRTC 控制器，记录发送统计并应用 per-frame 预算控制。
-func writeVideoToTrackBurst(track *webrtc.TrackLocalStaticSample, loopVideo bool, ctrl *BurstController, metricsWriter *BurstMetricsWriter, done chan<- bool, ctx context.Context, metadataWriter *FrameMetadataWriter) {
-	frameRate := videoStream.AvgFrameRate()
-	if frameRate.Num() == 0 {
-		frameRate = astiav.NewRational(30, 1)
-	}
-	h264FrameDuration := time.Duration(float64(time.Second) * float64(frameRate.Den()) / float64(frameRate.Num()))
-
-	ticker := time.NewTicker(h264FrameDuration)
-	defer ticker.Stop()
-
-	frameID := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(os.Stderr, "Connection closed, stopping video streaming...\n")
-			select {
-			case done <- true:
-			default:
-			}
-			return
-		case <-ticker.C:
-		}
-		decodePacket.Unref()
-
-		if err = inputFormatContext.ReadFrame(decodePacket); err != nil {
-			if errors.Is(err, astiav.ErrEof) {
-				if loopVideo {
-					if err = inputFormatContext.SeekFrame(0, 0, astiav.NewSeekFlags(astiav.SeekFlagFrame)); err != nil {
-						fmt.Fprintf(os.Stderr, "Failed to seek to beginning: %v\n", err)
-						break
-					}
-					pts = 0
-					fmt.Fprintf(os.Stderr, "Video looped, restarting from beginning...\n")
-					continue
-				}
-				fmt.Fprintf(os.Stderr, "Video playback completed (EOF reached)\n")
-				select {
-				case done <- true:
-				default:
-				}
-				break
-			}
-			fmt.Fprintf(os.Stderr, "Error reading frame: %v\n", err)
-			continue
-		}
-
-		if decodePacket.StreamIndex() != videoStream.Index() {
-			continue
-		}
-
-		decodePacket.RescaleTs(videoStream.TimeBase(), decodeCodecContext.TimeBase())
-
-		if err = decodeCodecContext.SendPacket(decodePacket); err != nil {
-			fmt.Fprintf(os.Stderr, "Error sending packet to decoder: %v\n", err)
-			continue
-		}
-
-		for {
-			if err = decodeCodecContext.ReceiveFrame(decodeFrame); err != nil {
-				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-					break
-				}
-				fmt.Fprintf(os.Stderr, "Error receiving frame: %v\n", err)
-				break
-			}
-
-			frameID++
-			sendStart := time.Now()
-
-			// 闭环控制：从 BurstRTC 控制器获取当前帧的预算和 burst fraction
-			targetBits, burstFraction := ctrl.NextFrameBudget()
-
-			// 初始化编码器（如果还没初始化）
-			initVideoEncoding()
-
-			// 根据预算调整编码器质量（闭环控制的关键步骤）
-			if err = updateEncoderForBudgetBurst(targetBits); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: Failed to update encoder for budget %d: %v, using default\n", targetBits, err)
-			}
-
-			if err = softwareScaleContext.ScaleFrame(decodeFrame, scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scaling frame: %v\n", err)
-				continue
-			}
-
-			pts++
-			scaledFrame.SetPts(pts)
-
-			if err = encodeCodecContext.SendFrame(scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error sending frame to encoder: %v\n", err)
-				continue
-			}
-
-			var sentBitsForFrame int
-			var allPackets [][]byte // 收集所有 packet，用于 burst 发送
-
-			for {
-				encodePacket = astiav.AllocPacket()
-				if err = encodeCodecContext.ReceivePacket(encodePacket); err != nil {
-					if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-						encodePacket.Free()
-						break
-					}
-					encodePacket.Free()
-					fmt.Fprintf(os.Stderr, "Error receiving packet: %v\n", err)
-					break
-				}
-
-				data := encodePacket.Data()
-				sentBitsForFrame += len(data) * 8
-				allPackets = append(allPackets, data)
-				encodePacket.Free()
-			}
-
-			// 应用 burst fraction：控制发送 pattern
-			// burstFraction 表示在帧间隔内，应该用多长时间来发送数据
-			// 例如：burstFraction=0.5 表示用一半的帧间隔时间发送，另一半时间 sleep
-			burstSendDuration := time.Duration(float64(h264FrameDuration) * burstFraction)
-			
-			if len(allPackets) > 0 && burstSendDuration > 0 {
-				// 计算每个 packet 之间的发送间隔
-				packetInterval := burstSendDuration / time.Duration(len(allPackets))
-				if packetInterval < 0 {
-					packetInterval = 0
-				}
-
-				burstStart := time.Now()
-				for i, pktData := range allPackets {
-					if err = track.WriteSample(media.Sample{Data: pktData, Duration: h264FrameDuration}); err != nil {
-						fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", err)
-						// 如果写入失败，可能是连接已断开，退出循环
-						select {
-						case done <- true:
-						default:
-						}
-						return
-					}
-					
-					// 在 packet 之间 sleep，控制 burst 发送节奏
-					// 最后一个 packet 不需要 sleep
-					if i < len(allPackets)-1 && packetInterval > 0 {
-						time.Sleep(packetInterval)
-					}
-				}
-				actualBurstDuration := time.Since(burstStart)
-				
-				// 如果实际发送时间小于预期，在帧间隔剩余时间内 sleep
-				if actualBurstDuration < burstSendDuration {
-					remainingSleep := burstSendDuration - actualBurstDuration
-					if remainingSleep > 0 {
-						time.Sleep(remainingSleep)
-					}
-				}
-			} else {
-				// fallback：直接发送所有 packet
-				for _, pktData := range allPackets {
-					if err = track.WriteSample(media.Sample{Data: pktData, Duration: h264FrameDuration}); err != nil {
-						fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", err)
-						// 如果写入失败，可能是连接已断开，退出循环
-						select {
-						case done <- true:
-						default:
-						}
-						return
-					}
-				}
-			}
-
-			sendEnd := time.Now()
-
-			// 更新 BurstRTC 控制器
-			ctrl.UpdateStats(BurstObservation{
-				FrameID:   frameID,
-				SentBits:  sentBitsForFrame,
-				SendStart: sendStart,
-				SendEnd:   sendEnd,
-			})
-
-			// 获取统计信息用于日志和 CSV
-			meanBits, varBits, availBps := ctrl.GetStats()
-			fmt.Fprintf(os.Stderr, "[BurstRTC] Frame %d: sent_bits=%d, target_bits=%d, burst_frac=%.2f, mean=%.0f, var=%.0f, avail_bps=%.0f\n",
-				frameID, sentBitsForFrame, targetBits, burstFraction, meanBits, varBits, availBps)
-
-			// 写入 metrics CSV
-			if metricsWriter != nil {
-				metricsWriter.WriteBurstMetric(frameID, targetBits, sentBitsForFrame, burstFraction,
-					sendStart, sendEnd, availBps, meanBits, varBits)
-			}
-
-			// 写入 frame metadata
-			if metadataWriter != nil {
-				metadataWriter.WriteMetadata(FrameMetadata{
-					FrameID:   frameID,
-					SendStart: sendStart,
-					SendEnd:   sendEnd,
-					FrameBits: sentBitsForFrame,
-				})
-			}
-		}
+// logBurstFrame 是 BurstRTC 的逐帧日志与 burst_server_metrics.csv（writeVideoToTrack 的 onFrame）
+func logBurstFrame(ctrl *BurstController, metricsWriter *BurstMetricsWriter, obs FrameObservation) {
+	meanBits, varBits, availBps := ctrl.GetStats()
+	fmt.Fprintf(os.Stderr, "[BurstRTC] Frame %d: sent_bits=%d, target_bits=%d, burst_frac=%.2f, burst_pkts=%d, paced_pkts=%d, mean=%.0f, var=%.0f, avail_bps=%.0f\n",
+		obs.FrameID, obs.SentBits, obs.TargetBits, obs.BurstFraction, obs.Split.BurstPackets, obs.Split.PacedPackets, meanBits, varBits, availBps)
+	if metricsWriter != nil {
+		metricsWriter.WriteBurstMetric(obs.FrameID, obs.TargetBits, obs.SentBits, obs.BurstFraction,
+			obs.SendStart, obs.SendEnd, availBps, meanBits, varBits, obs.Split)
 	}
 }
@@ -9,7 +9,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/asticode/go-astiav"
 )
@@ -31,19 +34,33 @@ var (
 	err                  error
 )
 
-func initVideoSource(videoPath string) {
+// maxBFrames 是编码器允许的最大连续 B 帧数（-bframes），0 表示关闭 B 帧（默认）。
+// 开启后编码输出为 DTS 顺序，发送端需要按 PTS 打 RTP 时间戳（见 ptsH264Track）。
+var maxBFrames int
+
+// keyframesOnly 对应 -encode-only-keyframes：GOP 为 1，每一帧都编码为 IDR。
+// 诊断用，用于测量关键帧的最坏码率，以及在极端条件下测试 keyframe pacer 与接收端的参数集处理。
+var keyframesOnly bool
+
+// testToneKeyframes 在 -test-tone 开启时非 nil：每次测试音开始时强制一个关键帧，使 beep 与画面刷新对齐。
+var testToneKeyframes *toneKeyframeScheduler
+
+func initVideoSource(videoPath string) error {
 	if inputFormatContext = astiav.AllocFormatContext(); inputFormatContext == nil {
-		panic("Failed to AllocFormatContext")
+		return errors.New("failed to allocate format context")
 	}
 
 	// Open input file
 	if err = inputFormatContext.OpenInput(videoPath, nil, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open input file: %v", err))
+		// 打开失败时 FFmpeg 已经释放了内部的 AVFormatContext，只能 Free，不能再 CloseInput
+		inputFormatContext.Free()
+		inputFormatContext = nil
+		return fmt.Errorf("failed to open input file: %w", err)
 	}
 
 	// Find stream info
 	if err = inputFormatContext.FindStreamInfo(nil); err != nil {
-		panic(fmt.Sprintf("Failed to find stream info: %v", err))
+		return fmt.Errorf("failed to find stream info: %w", err)
 	}
 
 	// Find video stream
@@ -58,69 +75,147 @@ func initVideoSource(videoPath string) {
 	}
 
 	if videoStream == nil {
-		panic("No video stream found in file")
+		return fmt.Errorf("%w in %s", ErrNoVideoStream, videoPath)
 	}
 
 	// Get decoder
 	decodeCodec := astiav.FindDecoder(videoStream.CodecParameters().CodecID())
 	if decodeCodec == nil {
-		panic("FindDecoder returned nil")
+		return fmt.Errorf("%w: no decoder for %s", ErrCodecUnsupported, videoStream.CodecParameters().CodecID())
 	}
 
 	if decodeCodecContext = astiav.AllocCodecContext(decodeCodec); decodeCodecContext == nil {
-		panic("Failed to AllocCodecContext")
+		return errors.New("failed to allocate decoder context")
 	}
 
 	if err = videoStream.CodecParameters().ToCodecContext(decodeCodecContext); err != nil {
-		panic(fmt.Sprintf("Failed to copy codec parameters: %v", err))
+		return fmt.Errorf("failed to copy codec parameters: %w", err)
 	}
+	// 解码器的时间基与源流一致：packet 的 RescaleTs 因此不改变时间戳，解码帧的 PTS 直接是源流时间基
+	// （codec parameters 不含时间基，不设置时为 0/1，RescaleTs 会把所有时间戳变成 AV_NOPTS_VALUE）
+	decodeCodecContext.SetTimeBase(videoStream.TimeBase())
 
 	decodeCodecContext.SetFramerate(inputFormatContext.GuessFrameRate(videoStream, nil))
 
 	if err = decodeCodecContext.Open(decodeCodec, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open decoder: %v", err))
+		return fmt.Errorf("failed to open decoder: %w", err)
 	}
 
 	decodePacket = astiav.AllocPacket()
 	decodeFrame = astiav.AllocFrame()
 
+	// -resume：定位到上次保存的位置（只在第一次打开时）
+	if err = videoResume.Seek(); err != nil {
+		return fmt.Errorf("failed to seek to the saved position: %w", err)
+	}
+
 	// 初始化编码器在 initVideoEncoding 中完成
+	return nil
+}
+
+// errSourceReleased 表示 reloadVideoSource 已经释放了原来的输入，但新文件没能完成初始化
+var errSourceReleased = errors.New("previous input already released")
+
+// reloadVideoSource 重新打开源文件（-watch）：只替换输入与解码器，编码器与缩放上下文保留，
+// 因此 RTP 时间戳与 PTS 保持连续；分辨率 / 像素格式不同时由 ensureScalerSource 处理。
+// 新文件先试探性打开一次，打不开时保留原来的输入并返回错误。
+func reloadVideoSource(videoPath string) error {
+	probe := astiav.AllocFormatContext()
+	if probe == nil {
+		return fmt.Errorf("failed to AllocFormatContext")
+	}
+	if err := probe.OpenInput(videoPath, nil, nil); err != nil {
+		probe.Free()
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	probeErr := probe.FindStreamInfo(nil)
+	hasVideo := false
+	for _, stream := range probe.Streams() {
+		if stream.CodecParameters().CodecType() == astiav.MediaTypeVideo {
+			hasVideo = true
+			break
+		}
+	}
+	probe.CloseInput()
+	probe.Free()
+	if probeErr != nil {
+		return fmt.Errorf("failed to find stream info: %w", probeErr)
+	}
+	if !hasVideo {
+		return ErrNoVideoStream
+	}
+
+	inputFormatContext.CloseInput()
+	inputFormatContext.Free()
+	decodeCodecContext.Free()
+	decodePacket.Free()
+	decodeFrame.Free()
+	inputFormatContext, decodeCodecContext, decodePacket, decodeFrame = nil, nil, nil, nil
+	videoStream, audioStream = nil, nil
+
+	// 原来的输入已经释放，这里失败时无法保留：返回 errSourceReleased，调用方停止发送
+	if err := initVideoSource(videoPath); err != nil {
+		return fmt.Errorf("%w: %w", errSourceReleased, err)
+	}
+	return nil
+}
+
+// seekVideoSource 把输入定位到 position（相对流起点）之前最近的关键帧（-keep-open 的 replay / seek）。
+// 解码器没有 flush，seek 前已送入的少量帧仍会输出，其 PTS 由 sourcePTSValidator 修正。
+func seekVideoSource(position time.Duration) error {
+	ts := astiav.RescaleQ(int64(position/time.Microsecond), astiav.NewRational(1, 1_000_000), videoStream.TimeBase())
+	if start := videoStream.StartTime(); start != astiav.NoPtsValue {
+		ts += start
+	}
+	return inputFormatContext.SeekFrame(videoStream.Index(), ts, astiav.NewSeekFlags(astiav.SeekFlagBackward))
 }
 
 // initVideoEncoding 与 server.go 中保持一致，用于在第一次编码前初始化编码器与缩放上下文。
-func initVideoEncoding() {
+func initVideoEncoding() error {
 	if encodeCodecContext != nil {
-		return
+		return nil
 	}
 
-	h264Encoder := astiav.FindEncoder(astiav.CodecIDH264)
-	if h264Encoder == nil {
-		panic("No H264 Encoder Found")
+	h264Encoder, encErr := findH264Encoder()
+	if encErr != nil {
+		return encErr
 	}
 
 	if encodeCodecContext = astiav.AllocCodecContext(h264Encoder); encodeCodecContext == nil {
-		panic("Failed to AllocCodecContext Encoder")
+		return errors.New("failed to allocate encoder context")
 	}
 
 	encodeCodecContext.SetPixelFormat(astiav.PixelFormatYuv420P)
 	encodeCodecContext.SetSampleAspectRatio(decodeCodecContext.SampleAspectRatio())
-	encodeCodecContext.SetTimeBase(astiav.NewRational(1, 30))
+	encodeFrameRate := videoFrameRate(inputFormatContext, videoStream)
+	encodeCodecContext.SetTimeBase(encodeFrameRate.Invert())
+	encodeCodecContext.SetFramerate(encodeFrameRate)
 	encodeCodecContext.SetWidth(decodeCodecContext.Width())
 	encodeCodecContext.SetHeight(decodeCodecContext.Height())
 
 	encodeCodecContextDictionary := astiav.NewDictionary()
 	if err = encodeCodecContextDictionary.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
 	}
 	if err = encodeCodecContextDictionary.Set("tune", "zerolatency", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
+	}
+	if err = encodeCodecContextDictionary.Set("bf", strconv.Itoa(maxBFrames), astiav.NewDictionaryFlags()); err != nil {
+		return err
 	}
-	if err = encodeCodecContextDictionary.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+	if err = applyH264Compat(encodeCodecContextDictionary); err != nil {
+		return err
+	}
+	if err = applyEncoderLatencyMode(encodeCodecContextDictionary); err != nil {
+		return err
+	}
+
+	if keyframesOnly {
+		encodeCodecContext.SetGopSize(1)
 	}
 
-	if err = encodeCodecContext.Open(h264Encoder, encodeCodecContextDictionary); err != nil {
-		panic(fmt.Sprintf("Failed to open encoder: %v", err))
+	if err = openH264Encoder(encodeCodecContext, h264Encoder, encodeCodecContextDictionary); err != nil {
+		return fmt.Errorf("failed to open encoder: %w", err)
 	}
 
 	softwareScaleContext, err = astiav.CreateSoftwareScaleContext(
@@ -133,40 +228,50 @@ func initVideoEncoding() {
 		astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBilinear),
 	)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create scale context: %v", err))
+		return fmt.Errorf("failed to create scale context: %w", err)
 	}
 
 	scaledFrame = astiav.AllocFrame()
+	encodePacket = astiav.AllocPacket()
+	return nil
 }
 
-// freeVideoCoding 释放 FFmpeg 相关的全局状态。
+// freeVideoCoding 释放 FFmpeg 相关的全局状态并置为 nil：可以重复调用，也可以在 initVideoSource / initVideoEncoding 中途失败后调用。
 func freeVideoCoding() {
 	if inputFormatContext != nil {
 		inputFormatContext.CloseInput()
 		inputFormatContext.Free()
+		inputFormatContext = nil
 	}
 
 	if decodeCodecContext != nil {
 		decodeCodecContext.Free()
+		decodeCodecContext = nil
 	}
 	if decodePacket != nil {
 		decodePacket.Free()
+		decodePacket = nil
 	}
 	if decodeFrame != nil {
 		decodeFrame.Free()
+		decodeFrame = nil
 	}
 
 	if scaledFrame != nil {
 		scaledFrame.Free()
+		scaledFrame = nil
 	}
 	if softwareScaleContext != nil {
 		softwareScaleContext.Free()
+		softwareScaleContext = nil
 	}
 	if encodeCodecContext != nil {
 		encodeCodecContext.Free()
+		encodeCodecContext = nil
 	}
 	if encodePacket != nil {
 		encodePacket.Free()
+		encodePacket = nil
 	}
 }
 
@@ -9,7 +9,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/asticode/go-astiav"
 )
@@ -31,19 +33,22 @@ var (
 	err                  error
 )
 
-func initVideoSource(videoPath string) {
+func initVideoSource(videoPath string) error {
 	if inputFormatContext = astiav.AllocFormatContext(); inputFormatContext == nil {
-		panic("Failed to AllocFormatContext")
+		return errors.New("failed to allocate format context")
 	}
 
 	// Open input file
 	if err = inputFormatContext.OpenInput(videoPath, nil, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open input file: %v", err))
+		// 打开失败时 FFmpeg 已经释放了内部的 AVFormatContext，只能 Free，不能再 CloseInput
+		inputFormatContext.Free()
+		inputFormatContext = nil
+		return fmt.Errorf("failed to open input file: %w", err)
 	}
 
 	// Find stream info
 	if err = inputFormatContext.FindStreamInfo(nil); err != nil {
-		panic(fmt.Sprintf("Failed to find stream info: %v", err))
+		return fmt.Errorf("failed to find stream info: %w", err)
 	}
 
 	// Find video stream
@@ -58,69 +63,81 @@ func initVideoSource(videoPath string) {
 	}
 
 	if videoStream == nil {
-		panic("No video stream found in file")
+		return fmt.Errorf("%w in %s", ErrNoVideoStream, videoPath)
 	}
 
 	// Get decoder
 	decodeCodec := astiav.FindDecoder(videoStream.CodecParameters().CodecID())
 	if decodeCodec == nil {
-		panic("FindDecoder returned nil")
+		return fmt.Errorf("%w: no decoder for %s", ErrCodecUnsupported, videoStream.CodecParameters().CodecID())
 	}
 
 	if decodeCodecContext = astiav.AllocCodecContext(decodeCodec); decodeCodecContext == nil {
-		panic("Failed to AllocCodecContext")
+		return errors.New("failed to allocate decoder context")
 	}
 
 	if err = videoStream.CodecParameters().ToCodecContext(decodeCodecContext); err != nil {
-		panic(fmt.Sprintf("Failed to copy codec parameters: %v", err))
+		return fmt.Errorf("failed to copy codec parameters: %w", err)
 	}
+	// 解码器的时间基与源流一致：packet 的 RescaleTs 因此不改变时间戳，解码帧的 PTS 直接是源流时间基
+	// （codec parameters 不含时间基，不设置时为 0/1，RescaleTs 会把所有时间戳变成 AV_NOPTS_VALUE）
+	decodeCodecContext.SetTimeBase(videoStream.TimeBase())
 
 	decodeCodecContext.SetFramerate(inputFormatContext.GuessFrameRate(videoStream, nil))
 
 	if err = decodeCodecContext.Open(decodeCodec, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open decoder: %v", err))
+		return fmt.Errorf("failed to open decoder: %w", err)
 	}
 
 	decodePacket = astiav.AllocPacket()
 	decodeFrame = astiav.AllocFrame()
 
 	// 初始化编码器在 initVideoEncoding 中完成
+	return nil
 }
 
 // initVideoEncoding 与其它服务器中的实现保持一致，用于在第一次编码前初始化编码器与缩放上下文。
-func initVideoEncoding() {
+func initVideoEncoding() error {
 	if encodeCodecContext != nil {
-		return
+		return nil
 	}
 
-	h264Encoder := astiav.FindEncoder(astiav.CodecIDH264)
-	if h264Encoder == nil {
-		panic("No H264 Encoder Found")
+	h264Encoder, encErr := findH264Encoder()
+	if encErr != nil {
+		return encErr
 	}
 
 	if encodeCodecContext = astiav.AllocCodecContext(h264Encoder); encodeCodecContext == nil {
-		panic("Failed to AllocCodecContext Encoder")
+		return errors.New("failed to allocate encoder context")
 	}
 
 	encodeCodecContext.SetPixelFormat(astiav.PixelFormatYuv420P)
 	encodeCodecContext.SetSampleAspectRatio(decodeCodecContext.SampleAspectRatio())
-	encodeCodecContext.SetTimeBase(astiav.NewRational(1, 30))
+	encodeFrameRate := videoFrameRate(inputFormatContext, videoStream)
+	encodeCodecContext.SetTimeBase(encodeFrameRate.Invert())
+	encodeCodecContext.SetFramerate(encodeFrameRate)
 	encodeCodecContext.SetWidth(decodeCodecContext.Width())
 	encodeCodecContext.SetHeight(decodeCodecContext.Height())
 
 	encodeCodecContextDictionary := astiav.NewDictionary()
 	if err = encodeCodecContextDictionary.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
 	}
 	if err = encodeCodecContextDictionary.Set("tune", "zerolatency", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
 	}
 	if err = encodeCodecContextDictionary.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
+	}
+	if err = applyH264Compat(encodeCodecContextDictionary); err != nil {
+		return err
+	}
+	if err = applyEncoderLatencyMode(encodeCodecContextDictionary); err != nil {
+		return err
 	}
 
-	if err = encodeCodecContext.Open(h264Encoder, encodeCodecContextDictionary); err != nil {
-		panic(fmt.Sprintf("Failed to open encoder: %v", err))
+	if err = openH264Encoder(encodeCodecContext, h264Encoder, encodeCodecContextDictionary); err != nil {
+		return fmt.Errorf("failed to open encoder: %w", err)
 	}
 
 	softwareScaleContext, err = astiav.CreateSoftwareScaleContext(
@@ -133,10 +150,12 @@ func initVideoEncoding() {
 		astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBilinear),
 	)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create scale context: %v", err))
+		return fmt.Errorf("failed to create scale context: %w", err)
 	}
 
 	scaledFrame = astiav.AllocFrame()
+	encodePacket = astiav.AllocPacket()
+	return nil
 }
 
 // updateEncoderForBudget 根据预算 bits 动态调整编码器质量。
@@ -182,9 +201,9 @@ func updateEncoderForBudget(targetBits int) error {
 		encodeCodecContext = nil
 	}
 
-	h264Encoder := astiav.FindEncoder(astiav.CodecIDH264)
-	if h264Encoder == nil {
-		return fmt.Errorf("No H264 Encoder Found")
+	h264Encoder, encErr := findH264Encoder()
+	if encErr != nil {
+		return encErr
 	}
 
 	if encodeCodecContext = astiav.AllocCodecContext(h264Encoder); encodeCodecContext == nil {
@@ -193,9 +212,13 @@ func updateEncoderForBudget(targetBits int) error {
 
 	encodeCodecContext.SetPixelFormat(astiav.PixelFormatYuv420P)
 	encodeCodecContext.SetSampleAspectRatio(decodeCodecContext.SampleAspectRatio())
-	encodeCodecContext.SetTimeBase(astiav.NewRational(1, 30))
-	encodeCodecContext.SetWidth(decodeCodecContext.Width())
-	encodeCodecContext.SetHeight(decodeCodecContext.Height())
+	encodeFrameRate := videoFrameRate(inputFormatContext, videoStream)
+	encodeCodecContext.SetTimeBase(encodeFrameRate.Invert())
+	encodeCodecContext.SetFramerate(encodeFrameRate)
+	// 编码分辨率跟随 -degrade-resolution-kbps 的当前档位（未开启时为源分辨率）
+	encodeWidth, encodeHeight := resolutionAdapter.Size(decodeCodecContext.Width(), decodeCodecContext.Height())
+	encodeCodecContext.SetWidth(encodeWidth)
+	encodeCodecContext.SetHeight(encodeHeight)
 
 	encodeCodecContextDictionary := astiav.NewDictionary()
 	if err = encodeCodecContextDictionary.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
@@ -207,13 +230,19 @@ func updateEncoderForBudget(targetBits int) error {
 	if err = encodeCodecContextDictionary.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
 		return err
 	}
+	if err = applyH264Compat(encodeCodecContextDictionary); err != nil {
+		return err
+	}
+	if err = applyEncoderLatencyMode(encodeCodecContextDictionary); err != nil {
+		return err
+	}
 	// 设置 CRF
 	crfStr := fmt.Sprintf("%d", targetCRF)
 	if err = encodeCodecContextDictionary.Set("crf", crfStr, astiav.NewDictionaryFlags()); err != nil {
 		return err
 	}
 
-	if err = encodeCodecContext.Open(h264Encoder, encodeCodecContextDictionary); err != nil {
+	if err = openH264Encoder(encodeCodecContext, h264Encoder, encodeCodecContextDictionary); err != nil {
 		return fmt.Errorf("Failed to open encoder with CRF %d: %v", targetCRF, err)
 	}
 
@@ -222,6 +251,77 @@ func updateEncoderForBudget(targetBits int) error {
 	return nil
 }
 
+// rescaleTo 把缩放输出改为 width x height：新建缩放上下文与输出帧，成功后释放旧的；失败时保持原来的分辨率。
+// 只能在发送协程中、两次 ScaleFrame 之间调用
+func rescaleTo(width, height int) error {
+	if softwareScaleContext == nil {
+		return errors.New("scale context not initialized")
+	}
+	if w, h := softwareScaleContext.DestinationResolution(); w == width && h == height {
+		return nil
+	}
+	next, err := recreateScaler(softwareScaleContext, width, height)
+	if err != nil {
+		return err
+	}
+	nextFrame := astiav.AllocFrame()
+	if nextFrame == nil {
+		next.Free()
+		return errors.New("failed to allocate scaled frame")
+	}
+	softwareScaleContext.Free()
+	scaledFrame.Free()
+	softwareScaleContext, scaledFrame = next, nextFrame
+	return nil
+}
+
+// switchEncodeResolution 在分辨率档位切换后修改缩放输出，并让下一次 updateEncoderForBudget 按新分辨率重建编码器
+// （新编码器的第一帧是 IDR，相当于在切换处强制关键帧）
+func switchEncodeResolution() error {
+	width, height := resolutionAdapter.Size(decodeCodecContext.Width(), decodeCodecContext.Height())
+	if err := rescaleTo(width, height); err != nil {
+		return err
+	}
+	// 编码器的分辨率在打开时固定，下一次 updateEncoderForBudget 按新分辨率重建
+	currentCRF = -1
+	return nil
+}
+
+// prepareEncoderForBudget 供 writeVideoToTrack 调用：按预算调整编码器，返回当前的 CRF（叠加图中的 QP）
+func prepareEncoderForBudget(targetBits int) int {
+	if err := updateEncoderForBudget(targetBits); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to update encoder for budget %d: %v, using default\n", targetBits, err)
+	}
+	return currentCRF
+}
+
+// encodeFrameForBudget 供 writeVideoToTrack 调用：编码一帧（处理关键帧请求），返回经 assembler 整理后要发送的数据
+func encodeFrameForBudget(frame *astiav.Frame, frameID, _ int, assembler *encodedFrameAssembler) ([][]byte, error) {
+	if keyframeRequests.Take(frameID, false) {
+		frame.SetPictureType(astiav.PictureTypeI)
+	} else {
+		frame.SetPictureType(astiav.PictureTypeNone)
+	}
+	if err := encodeCodecContext.SendFrame(frame); err != nil {
+		return nil, fmt.Errorf("failed to send frame to encoder: %w", err)
+	}
+
+	var packets [][]byte
+	for {
+		if err := encodeCodecContext.ReceivePacket(encodePacket); err != nil {
+			if !errors.Is(err, astiav.ErrEof) && !errors.Is(err, astiav.ErrEagain) {
+				reportRecoverableError("Error receiving packet", err)
+			}
+			return packets, nil
+		}
+		data, ok := assembler.Next(encodePacket.Data())
+		encodePacket.Unref()
+		if ok {
+			packets = append(packets, data)
+		}
+	}
+}
+
 func abs(x int) int {
 	if x < 0 {
 		return -x
@@ -229,34 +329,42 @@ func abs(x int) int {
 	return x
 }
 
-// freeVideoCoding 释放 FFmpeg 相关的全局状态。
+// freeVideoCoding 释放 FFmpeg 相关的全局状态并置为 nil：可以重复调用，也可以在 initVideoSource / initVideoEncoding 中途失败后调用。
 func freeVideoCoding() {
 	if inputFormatContext != nil {
 		inputFormatContext.CloseInput()
 		inputFormatContext.Free()
+		inputFormatContext = nil
 	}
 
 	if decodeCodecContext != nil {
 		decodeCodecContext.Free()
+		decodeCodecContext = nil
 	}
 	if decodePacket != nil {
 		decodePacket.Free()
+		decodePacket = nil
 	}
 	if decodeFrame != nil {
 		decodeFrame.Free()
+		decodeFrame = nil
 	}
 
 	if scaledFrame != nil {
 		scaledFrame.Free()
+		scaledFrame = nil
 	}
 	if softwareScaleContext != nil {
 		softwareScaleContext.Free()
+		softwareScaleContext = nil
 	}
 	if encodeCodecContext != nil {
 		encodeCodecContext.Free()
+		encodeCodecContext = nil
 	}
 	if encodePacket != nil {
 		encodePacket.Free()
+		encodePacket = nil
 	}
 }
 
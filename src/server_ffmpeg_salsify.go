@@ -12,6 +12,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/asticode/go-astiav"
 )
@@ -33,19 +35,22 @@ var (
 	err                  error
 )
 
-func initVideoSource(videoPath string) {
+func initVideoSource(videoPath string) error {
 	if inputFormatContext = astiav.AllocFormatContext(); inputFormatContext == nil {
-		panic("Failed to AllocFormatContext")
+		return errors.New("failed to allocate format context")
 	}
 
 	// Open input file
 	if err = inputFormatContext.OpenInput(videoPath, nil, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open input file: %v", err))
+		// 打开失败时 FFmpeg 已经释放了内部的 AVFormatContext，只能 Free，不能再 CloseInput
+		inputFormatContext.Free()
+		inputFormatContext = nil
+		return fmt.Errorf("failed to open input file: %w", err)
 	}
 
 	// Find stream info
 	if err = inputFormatContext.FindStreamInfo(nil); err != nil {
-		panic(fmt.Sprintf("Failed to find stream info: %v", err))
+		return fmt.Errorf("failed to find stream info: %w", err)
 	}
 
 	// Find video stream
@@ -60,69 +65,78 @@ func initVideoSource(videoPath string) {
 	}
 
 	if videoStream == nil {
-		panic("No video stream found in file")
+		return fmt.Errorf("%w in %s", ErrNoVideoStream, videoPath)
 	}
 
 	// Get decoder
 	decodeCodec := astiav.FindDecoder(videoStream.CodecParameters().CodecID())
 	if decodeCodec == nil {
-		panic("FindDecoder returned nil")
+		return fmt.Errorf("%w: no decoder for %s", ErrCodecUnsupported, videoStream.CodecParameters().CodecID())
 	}
 
 	if decodeCodecContext = astiav.AllocCodecContext(decodeCodec); decodeCodecContext == nil {
-		panic("Failed to AllocCodecContext")
+		return errors.New("failed to allocate decoder context")
 	}
 
 	if err = videoStream.CodecParameters().ToCodecContext(decodeCodecContext); err != nil {
-		panic(fmt.Sprintf("Failed to copy codec parameters: %v", err))
+		return fmt.Errorf("failed to copy codec parameters: %w", err)
 	}
+	// 解码器的时间基与源流一致：packet 的 RescaleTs 因此不改变时间戳，解码帧的 PTS 直接是源流时间基
+	// （codec parameters 不含时间基，不设置时为 0/1，RescaleTs 会把所有时间戳变成 AV_NOPTS_VALUE）
+	decodeCodecContext.SetTimeBase(videoStream.TimeBase())
 
 	decodeCodecContext.SetFramerate(inputFormatContext.GuessFrameRate(videoStream, nil))
 
 	if err = decodeCodecContext.Open(decodeCodec, nil); err != nil {
-		panic(fmt.Sprintf("Failed to open decoder: %v", err))
+		return fmt.Errorf("failed to open decoder: %w", err)
 	}
 
 	decodePacket = astiav.AllocPacket()
 	decodeFrame = astiav.AllocFrame()
 
 	// 初始化编码器在 initVideoEncoding 中完成
+	return nil
 }
 
 // initVideoEncoding 与 server.go / server_ffmpeg_gcc.go 中保持一致，用于在第一次编码前初始化编码器与缩放上下文。
-func initVideoEncoding() {
+func initVideoEncoding() error {
 	if encodeCodecContext != nil {
-		return
+		return nil
 	}
 
-	h264Encoder := astiav.FindEncoder(astiav.CodecIDH264)
-	if h264Encoder == nil {
-		panic("No H264 Encoder Found")
+	h264Encoder, encErr := findH264Encoder()
+	if encErr != nil {
+		return encErr
 	}
 
 	if encodeCodecContext = astiav.AllocCodecContext(h264Encoder); encodeCodecContext == nil {
-		panic("Failed to AllocCodecContext Encoder")
+		return errors.New("failed to allocate encoder context")
 	}
 
 	encodeCodecContext.SetPixelFormat(astiav.PixelFormatYuv420P)
 	encodeCodecContext.SetSampleAspectRatio(decodeCodecContext.SampleAspectRatio())
-	encodeCodecContext.SetTimeBase(astiav.NewRational(1, 30))
+	encodeFrameRate := videoFrameRate(inputFormatContext, videoStream)
+	encodeCodecContext.SetTimeBase(encodeFrameRate.Invert())
+	encodeCodecContext.SetFramerate(encodeFrameRate)
 	encodeCodecContext.SetWidth(decodeCodecContext.Width())
 	encodeCodecContext.SetHeight(decodeCodecContext.Height())
 
 	encodeCodecContextDictionary := astiav.NewDictionary()
 	if err = encodeCodecContextDictionary.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
 	}
 	if err = encodeCodecContextDictionary.Set("tune", "zerolatency", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
 	}
 	if err = encodeCodecContextDictionary.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
-		panic(err)
+		return err
+	}
+	if err = applyH264Compat(encodeCodecContextDictionary); err != nil {
+		return err
 	}
 
-	if err = encodeCodecContext.Open(h264Encoder, encodeCodecContextDictionary); err != nil {
-		panic(fmt.Sprintf("Failed to open encoder: %v", err))
+	if err = openH264Encoder(encodeCodecContext, h264Encoder, encodeCodecContextDictionary); err != nil {
+		return fmt.Errorf("failed to open encoder: %w", err)
 	}
 
 	softwareScaleContext, err = astiav.CreateSoftwareScaleContext(
@@ -135,110 +149,245 @@ func initVideoEncoding() {
 		astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBilinear),
 	)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create scale context: %v", err))
+		return fmt.Errorf("failed to create scale context: %w", err)
 	}
 
 	scaledFrame = astiav.AllocFrame()
+	return nil
+}
+
+// rescaleTo 把缩放输出改为 width x height：新建缩放上下文与输出帧，成功后释放旧的；失败时保持原来的分辨率。
+// 只能在发送协程中、两次 ScaleFrame 之间调用
+func rescaleTo(width, height int) error {
+	if softwareScaleContext == nil {
+		return errors.New("scale context not initialized")
+	}
+	if w, h := softwareScaleContext.DestinationResolution(); w == width && h == height {
+		return nil
+	}
+	next, err := recreateScaler(softwareScaleContext, width, height)
+	if err != nil {
+		return err
+	}
+	nextFrame := astiav.AllocFrame()
+	if nextFrame == nil {
+		next.Free()
+		return errors.New("failed to allocate scaled frame")
+	}
+	softwareScaleContext.Free()
+	scaledFrame.Free()
+	softwareScaleContext, scaledFrame = next, nextFrame
+	return nil
 }
 
-// EncodedCandidate 表示一个编码候选（不同 QP 下的编码结果）
+// switchEncodeResolution 在分辨率档位切换后修改缩放输出。
+// 候选编码器在分辨率变化后的第一帧重建（candidateEncoderFor），且每帧都是 IDR
+func switchEncodeResolution() error {
+	width, height := resolutionAdapter.Size(decodeCodecContext.Width(), decodeCodecContext.Height())
+	return rescaleTo(width, height)
+}
+
+// EncodedCandidate 表示一个编码候选（一个档位下的编码结果）
 type EncodedCandidate struct {
-	QP     int      // 使用的 QP 值（用于质量排序）
-	Bits   int      // 编码后的比特数
+	Rung    int      // 在 salsifyLadder 中的下标（越小质量越高）
+	QP      int      // 使用的 QP 值
+	Scale   float64  // 相对当前编码分辨率的比例
+	Bits    int      // 编码后的比特数
 	Packets [][]byte // 编码后的 H.264 packet 列表（每个 packet 对应一个 NALU）
 }
 
-// encodeFrameWithQP 使用指定的 QP 值编码一帧，返回编码后的 packet 列表和总比特数
-func encodeFrameWithQP(frame *astiav.Frame, framePts int64, qp int) ([][]byte, int, error) {
-	h264Encoder := astiav.FindEncoder(astiav.CodecIDH264)
-	if h264Encoder == nil {
-		return nil, 0, fmt.Errorf("No H264 Encoder Found")
+// candidateEncoder 是为一个档位缓存的已打开的编码器
+type candidateEncoder struct {
+	ctx    *astiav.CodecContext
+	width  int // 打开时的分辨率，分辨率变化（-degrade-resolution-kbps）后重建
+	height int
+}
+
+// candidateEncoders 按档位缓存候选编码器，跨帧复用，避免每帧每个档位都新建并打开编码器；
+// 只在发送协程中使用，由 freeCandidateEncoders 释放
+var candidateEncoders = map[CandidateRung]*candidateEncoder{}
+
+// candidatePacket 是候选编码器共用的输出 packet
+var candidatePacket *astiav.Packet
+
+// openCandidateEncoder 新建并打开一个固定 QP 的编码器。
+// GOP 为 1：每帧都是带 SPS/PPS 的 IDR，不参考该编码器之前编码、但可能没有发送的帧，任何一个候选被选中时 client 都能直接解码
+func openCandidateEncoder(qp, width, height int) (*astiav.CodecContext, error) {
+	h264Encoder, encErr := findH264Encoder()
+	if encErr != nil {
+		return nil, encErr
 	}
 
 	encCtx := astiav.AllocCodecContext(h264Encoder)
 	if encCtx == nil {
-		return nil, 0, fmt.Errorf("Failed to AllocCodecContext Encoder")
+		return nil, fmt.Errorf("Failed to AllocCodecContext Encoder")
 	}
-	defer encCtx.Free()
 
 	encCtx.SetPixelFormat(astiav.PixelFormatYuv420P)
 	encCtx.SetSampleAspectRatio(decodeCodecContext.SampleAspectRatio())
-	encCtx.SetTimeBase(astiav.NewRational(1, 30))
-	encCtx.SetWidth(decodeCodecContext.Width())
-	encCtx.SetHeight(decodeCodecContext.Height())
+	encodeFrameRate := videoFrameRate(inputFormatContext, videoStream)
+	encCtx.SetTimeBase(encodeFrameRate.Invert())
+	encCtx.SetFramerate(encodeFrameRate)
+	encCtx.SetWidth(width)
+	encCtx.SetHeight(height)
+	encCtx.SetGopSize(1)
 
 	encDict := astiav.NewDictionary()
-	if err = encDict.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
-		return nil, 0, err
+	defer encDict.Free()
+	if err := setCandidateEncoderOptions(encDict, qp); err != nil {
+		encCtx.Free()
+		return nil, err
 	}
-	if err = encDict.Set("tune", "zerolatency", astiav.NewDictionaryFlags()); err != nil {
-		return nil, 0, err
+	if err := openH264Encoder(encCtx, h264Encoder, encDict); err != nil {
+		encCtx.Free()
+		return nil, fmt.Errorf("Failed to open encoder with QP %d: %v", qp, err)
 	}
-	if err = encDict.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
-		return nil, 0, err
+	return encCtx, nil
+}
+
+// setCandidateEncoderOptions 设置候选编码器的选项：与主编码器相同的低延迟设置，加上固定 QP
+func setCandidateEncoderOptions(encDict *astiav.Dictionary, qp int) error {
+	if err := encDict.Set("preset", "ultrafast", astiav.NewDictionaryFlags()); err != nil {
+		return err
+	}
+	if err := encDict.Set("tune", "zerolatency", astiav.NewDictionaryFlags()); err != nil {
+		return err
+	}
+	if err := encDict.Set("bf", "0", astiav.NewDictionaryFlags()); err != nil {
+		return err
+	}
+	if err := applyH264Compat(encDict); err != nil {
+		return err
 	}
 	// 使用固定 QP 模式
-	qpStr := fmt.Sprintf("%d", qp)
-	if err = encDict.Set("qp", qpStr, astiav.NewDictionaryFlags()); err != nil {
-		return nil, 0, err
+	return encDict.Set("qp", fmt.Sprintf("%d", qp), astiav.NewDictionaryFlags())
+}
+
+// candidateEncoderFor 返回 rung 的缓存编码器，没有或分辨率不同时新建
+func candidateEncoderFor(rung CandidateRung, width, height int) (*astiav.CodecContext, error) {
+	if enc := candidateEncoders[rung]; enc != nil {
+		if enc.width == width && enc.height == height {
+			return enc.ctx, nil
+		}
+		enc.ctx.Free()
+		delete(candidateEncoders, rung)
 	}
+	ctx, err := openCandidateEncoder(rung.QP, width, height)
+	if err != nil {
+		return nil, err
+	}
+	candidateEncoders[rung] = &candidateEncoder{ctx: ctx, width: width, height: height}
+	return ctx, nil
+}
 
-	if err = encCtx.Open(h264Encoder, encDict); err != nil {
-		return nil, 0, fmt.Errorf("Failed to open encoder with QP %d: %v", qp, err)
+// dropCandidateEncoder 释放 rung 的缓存编码器，下一帧重新创建
+func dropCandidateEncoder(rung CandidateRung) {
+	if enc := candidateEncoders[rung]; enc != nil {
+		enc.ctx.Free()
+		delete(candidateEncoders, rung)
+	}
+}
+
+// pruneCandidateEncoders 释放不在 salsifyLadder 中的档位的编码器（档位表改变后）
+func pruneCandidateEncoders() {
+	for rung := range candidateEncoders {
+		if !slices.Contains(salsifyLadder, rung) {
+			dropCandidateEncoder(rung)
+		}
+	}
+}
+
+// freeCandidateEncoders 释放所有候选编码器与共用的 packet
+func freeCandidateEncoders() {
+	for rung := range candidateEncoders {
+		dropCandidateEncoder(rung)
+	}
+	if candidatePacket != nil {
+		candidatePacket.Free()
+		candidatePacket = nil
+	}
+}
+
+// encodeFrameWithQP 使用 rung 的缓存编码器（固定 QP）编码一帧，返回编码后的 packet 列表和总比特数
+func encodeFrameWithQP(frame *astiav.Frame, framePts int64, rung CandidateRung) ([][]byte, int, error) {
+	encCtx, err := candidateEncoderFor(rung, frame.Width(), frame.Height())
+	if err != nil {
+		return nil, 0, err
+	}
+	if candidatePacket == nil {
+		candidatePacket = astiav.AllocPacket()
 	}
 
 	// 设置 PTS
 	frame.SetPts(framePts)
+	frame.SetPictureType(astiav.PictureTypeI)
 
 	// 发送帧到编码器
 	if err = encCtx.SendFrame(frame); err != nil {
+		dropCandidateEncoder(rung)
 		return nil, 0, fmt.Errorf("Error sending frame to encoder: %v", err)
 	}
 
-	// 收集所有编码后的 packet（保持 packet 边界）
+	// 收集本帧的全部输出（保持 packet 边界）。ultrafast + zerolatency 且没有 B 帧时编码器不缓存帧，
+	// SendFrame 之后即可取出本帧；空包跳过，只含 SPS/PPS 的包并入其后的 slice 包（GOP 为 1，每帧都带参数集）
 	var packets [][]byte
 	totalBits := 0
-
+	var assembler encodedFrameAssembler
 	for {
-		pkt := astiav.AllocPacket()
-		if err = encCtx.ReceivePacket(pkt); err != nil {
-			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-				pkt.Free()
+		if err = encCtx.ReceivePacket(candidatePacket); err != nil {
+			if errors.Is(err, astiav.ErrEagain) {
 				break
 			}
-			pkt.Free()
+			dropCandidateEncoder(rung)
 			return nil, 0, fmt.Errorf("Error receiving packet: %v", err)
 		}
-
-		data := pkt.Data()
-		// 复制数据（因为 packet 会被释放）
-		dataCopy := make([]byte, len(data))
-		copy(dataCopy, data)
-		packets = append(packets, dataCopy)
-		totalBits += len(data) * 8
-		pkt.Free()
+		// Data() 是复制出的 Go 切片，Unref 之后仍然有效
+		data, ok := assembler.Next(candidatePacket.Data())
+		candidatePacket.Unref()
+		if ok {
+			packets = append(packets, data)
+			totalBits += len(data) * 8
+		}
+	}
+	if len(packets) == 0 {
+		// 编码器把帧缓存在内部（例如硬件编码器的异步输出）：之后取出的会是这一帧而不是当前帧，重建编码器
+		dropCandidateEncoder(rung)
+		return nil, 0, fmt.Errorf("encoder with %v produced no output for the frame", rung)
 	}
 
 	return packets, totalBits, nil
 }
 
-// encodeMultipleCandidates 对同一帧生成多个编码候选（使用不同的 QP 值）
-// 返回按 QP 排序的候选列表（QP 越低质量越高）
-func encodeMultipleCandidates(frame *astiav.Frame, framePts int64) ([]EncodedCandidate, error) {
-	// 定义几个 QP 档位：低 QP = 高质量，高 QP = 低质量
-	qpLevels := []int{20, 25, 30, 35} // 从高质量到低质量
-
+// encodeMultipleCandidates 对同一帧按 salsifyLadder 的每个档位生成一个编码候选（不同 QP / 分辨率）
+// 返回按档位排序的候选列表（下标越小质量越高）。
+// -candidate-time-budget 开启时从最接近上一帧选中档位（prevRung，没有时为 -1）的档位开始编码，用完时间预算后停止，
+// 返回的候选可能少于 salsifyLadder
+func encodeMultipleCandidates(frame *astiav.Frame, framePts int64, prevRung int) ([]EncodedCandidate, error) {
 	var candidates []EncodedCandidate
 
-	for _, qp := range qpLevels {
-		packets, bits, err := encodeFrameWithQP(frame, framePts, qp)
+	pruneCandidateEncoders()
+	start := time.Now()
+	for _, i := range candidateBudget.Order(len(salsifyLadder), prevRung) {
+		// 至少保留一个候选，之后用完时间预算就停止
+		if len(candidates) > 0 && candidateBudget.Exhausted(start) {
+			break
+		}
+		rung := salsifyLadder[i]
+		scaled, err := scaleCandidateFrame(frame, rung.Scale)
+		if err != nil {
+			reportRecoverableError(fmt.Sprintf("Warning: Failed to scale candidate %v", rung), err)
+			continue
+		}
+		packets, bits, err := encodeFrameWithQP(scaled, framePts, rung)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to encode with QP %d: %v\n", qp, err)
+			reportRecoverableError(fmt.Sprintf("Warning: Failed to encode candidate %v", rung), err)
 			continue
 		}
 
 		candidates = append(candidates, EncodedCandidate{
-			QP:      qp,
+			Rung:    i,
+			QP:      rung.QP,
+			Scale:   rung.Scale,
 			Bits:    bits,
 			Packets: packets,
 		})
@@ -247,39 +396,103 @@ func encodeMultipleCandidates(frame *astiav.Frame, framePts int64) ([]EncodedCan
 	if len(candidates) == 0 {
 		return nil, fmt.Errorf("Failed to generate any encoding candidates")
 	}
+	slices.SortFunc(candidates, func(a, b EncodedCandidate) int { return a.Rung - b.Rung })
 
 	return candidates, nil
 }
 
-// freeVideoCoding 释放 FFmpeg 相关的全局状态。
+// salsifyLastRung 是上一帧选中的候选在 salsifyLadder 中的下标，还没有时为 -1
+var salsifyLastRung = -1
+
+// salsifyLastQP 是上一帧选中的候选的 QP，还没有时为 -1
+var salsifyLastQP = -1
+
+// prepareEncoderForBudget 供 writeVideoToTrack 调用：候选编码器的 QP 固定，这里不需要调整，
+// 返回上一帧选中的 QP（本帧的 QP 要在编码后才确定）
+func prepareEncoderForBudget(int) int {
+	return salsifyLastQP
+}
+
+// encodeFrameForBudget 供 writeVideoToTrack 调用：生成多个不同 QP 的候选，选择不超过预算的最高质量候选
+// （都超预算时选最小的），返回它的 packet 列表。候选编码器每帧都输出带参数集的 IDR，不需要 assembler
+func encodeFrameForBudget(frame *astiav.Frame, frameID, budgetBits int, _ *encodedFrameAssembler) ([][]byte, error) {
+	fmt.Fprintf(os.Stderr, "[Salsify] Frame %d budget: %d bits\n", frameID, budgetBits)
+
+	encodeStart := time.Now()
+	candidates, err := encodeMultipleCandidates(frame, pts, salsifyLastRung)
+	encodeElapsed := time.Since(encodeStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate encoding candidates: %w", err)
+	}
+
+	var selected *EncodedCandidate
+	for i := range candidates {
+		// 候选按档位排序，第一个不超过预算的就是质量最高的
+		if candidates[i].Bits <= budgetBits {
+			selected = &candidates[i]
+			break
+		}
+	}
+	if selected == nil {
+		// 都超预算时选比特数最少的（不同分辨率下档位靠后的不一定最小）
+		selected = &candidates[0]
+		for i := range candidates {
+			if candidates[i].Bits < selected.Bits {
+				selected = &candidates[i]
+			}
+		}
+		fmt.Fprintf(os.Stderr, "[Salsify] Frame %d: All candidates exceed budget, selecting smallest (%v, bits=%d)\n",
+			frameID, salsifyLadder[selected.Rung], selected.Bits)
+	} else {
+		fmt.Fprintf(os.Stderr, "[Salsify] Frame %d: Selected candidate %v, bits=%d (budget=%d)\n",
+			frameID, salsifyLadder[selected.Rung], selected.Bits, budgetBits)
+	}
+
+	salsifyLastRung = selected.Rung
+	salsifyLastQP = selected.QP
+	candidateBudget.Record(frameID, budgetBits, len(candidates), len(salsifyLadder), encodeElapsed, *selected)
+	return selected.Packets, nil
+}
+
+// freeVideoCoding 释放 FFmpeg 相关的全局状态并置为 nil：可以重复调用，也可以在 initVideoSource / initVideoEncoding 中途失败后调用。
 func freeVideoCoding() {
 	if inputFormatContext != nil {
 		inputFormatContext.CloseInput()
 		inputFormatContext.Free()
+		inputFormatContext = nil
 	}
 
 	if decodeCodecContext != nil {
 		decodeCodecContext.Free()
+		decodeCodecContext = nil
 	}
 	if decodePacket != nil {
 		decodePacket.Free()
+		decodePacket = nil
 	}
 	if decodeFrame != nil {
 		decodeFrame.Free()
+		decodeFrame = nil
 	}
 
 	if scaledFrame != nil {
 		scaledFrame.Free()
+		scaledFrame = nil
 	}
 	if softwareScaleContext != nil {
 		softwareScaleContext.Free()
+		softwareScaleContext = nil
 	}
 	if encodeCodecContext != nil {
 		encodeCodecContext.Free()
+		encodeCodecContext = nil
 	}
 	if encodePacket != nil {
 		encodePacket.Free()
+		encodePacket = nil
 	}
+	freeCandidateScalers()
+	freeCandidateEncoders()
 }
 
 
@@ -10,7 +10,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -19,27 +18,96 @@ import (
 
 	"github.com/asticode/go-astiav"
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
 )
 
 func main() {
 	videoFile := flag.String("video", "", "Video file path (e.g., assets/Ultra.mp4)")
-	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-detect")
+	localIP := flag.String("ip", "", "Local IP address for WebRTC (e.g., 192.168.100.1). If not specified, auto-select the best interface address; 'any' gathers on all interfaces")
 	offerFile := flag.String("offer-file", "", "Path to file to write offer (optional, if not specified, write to stdout)")
 	answerFile := flag.String("answer-file", "", "Path to file containing answer (optional, if not specified, read from stdin)")
+	signalURL := flag.String("signal-url", "", "Exchange SDP through an sdp-bridge room instead of files or stdin/stdout: POST the offer to <url>/offer and poll <url>/answer (e.g. http://bridge.example.com:8080/demo), or with a ws:// / wss:// URL send the offer and receive the answer over the room's WebSocket")
 	loop := flag.Bool("loop", false, "Loop video playback (default: false, play once)")
+	sessionTimeout := flag.Duration("session-timeout", time.Hour, "Maximum session length for unattended runs: close the connection once streaming has run this long, e.g. 90m (0 = unlimited)")
+	maxBytes := flag.Int64("max-bytes", 0, "Stop streaming before the cumulative encoded video bytes exceed this cap, then close the session (0 = unlimited). Bytes actually sent are reported at shutdown")
 	sessionDir := flag.String("session-dir", "", "Session directory for this experiment (optional, used mainly by scripts)")
 
 	// Salsify 控制相关参数
 	latencyTarget := flag.Duration("salsify-latency-target", 200*time.Millisecond, "Target end-to-end latency for Salsify controller")
 	safetyMargin := flag.Float64("salsify-safety-margin", 0.7, "Safety margin for Salsify bitrate budget (0,1]")
 
+	flag.BoolVar(&abortOnFirstError, "abort-on-first-error", false, "Strict mode: exit immediately on recoverable pipeline errors (decode/scale/encode/write) instead of logging and continuing")
+	printSDPCaps := flag.Bool("print-sdp-capabilities", false, "Print supported codecs, RTCP feedback and header extensions from a dummy offer, then exit")
+	helpExperiments := flag.Bool("help-experiments", false, "Print every available experiment (rate controller) with a one-line algorithm summary and its tunable parameters and defaults, then exit")
+	rtcpLog := flag.Bool("rtcp-log", false, "Log every sent/received RTCP packet with parsed fields to <session-dir>/rtcp_server.csv (requires -session-dir)")
+	resourceUsage := flag.Bool("resource-usage", false, "Sample process CPU time and RSS every second to <session-dir>/resource_usage_server.csv and print mean/peak at exit (requires -session-dir)")
+	pprofAddr := flag.String("pprof", "", "Serve net/http/pprof on this address (e.g. :6060) to profile CPU and allocations during the session")
+	statsInterval := flag.Duration("stats-interval", 0, "Print one aggregate health line (frames sent, send bitrate, RTT and loss from receiver reports, reconnects) every interval, e.g. 10s (0 = disabled)")
+	frameHash := flag.Bool("frame-hash", false, "Write a SHA-256 of every sent access unit's NAL units to <session-dir>/frame_hashes_server.csv; diff against the other side to verify a lossless round trip (requires -session-dir)")
+	flag.BoolVar(&verifyParamSets, "verify-param-sets", false, "Log every SPS/PPS that differs from the previously sent one (encoder rebuilds or candidate switches change them mid-stream) and print a summary at exit")
+	compat := flag.String("compat", "none", "H.264 receiver compatibility: none, or constrained-baseline to advertise only profile-level-id 42e01f and force the encoder to profile baseline, no B-frames and CAVLC")
+	hwaccelName := flag.String("hwaccel", "none", "H.264 encoder: none (software x264), nvenc (h264_nvenc on a CUDA device) or vaapi (h264_vaapi); falls back to software with a warning when the hardware encoder or device is unavailable")
+	debugOverlayOn := flag.Bool("debug-overlay", false, "Burn a rolling graph of the controller's target bitrate, bandwidth estimate and QP/CRF into the top-left corner of each frame before encoding (costs CPU and changes the encoded video; for demos and debugging)")
+	controllerStateInterval := flag.Duration("controller-state-interval", 0, "Snapshot the rate controller's internal state (capacity estimate, frame budget, window mean/variance, loss rate) every interval to <session-dir>/controller_state.csv, e.g. 100ms (0 = disabled; requires -session-dir)")
+	startupRampFrames := flag.Int("startup-ramp", 0, "Cap the frame budget of the first N frames, ramping geometrically from -startup-bitrate up to the controller's budget, so the first keyframe does not overwhelm a constrained link (0 = disabled). Logged per frame to <session-dir>/startup_ramp.csv when -session-dir is set")
+	startupBitrate := flag.Int("startup-bitrate", 300, "Bitrate in kbps of the first frame during -startup-ramp")
+	degradeKbps := flag.Int("degrade-resolution-kbps", 0, "Drop the encode resolution one step (1, 3/4, 1/2 of the source) when the frame budget stays below this bitrate in kbps for -degrade-resolution-hold, and step back up once it stays above 1.5x this value; each switch rebuilds the scaler and encoder and starts with a keyframe (0 = disabled). Switches are logged to <session-dir>/resolution_switches.csv when -session-dir is set")
+	degradeHold := flag.Duration("degrade-resolution-hold", 3*time.Second, "How long the frame budget must stay below / above the -degrade-resolution-kbps thresholds before switching resolution")
+	sendPressureThreshold := flag.Float64("send-pressure", 0, "Treat the local UDP send buffer filling up as congestion: a frame whose WriteSample calls block for at least this fraction of the frame interval (e.g. 0.25) counts as blocked and makes the controller back off (0 = disabled). Logged per frame to <session-dir>/send_pressure.csv when -session-dir is set")
+	qpLadder := flag.String("qp-ladder", "20,25,30,35", "Comma-separated QPs (0-51) to encode as candidates for every frame; the lowest QP that fits the frame budget is sent")
+	candidateScales := flag.String("candidate-scales", "1", "Comma-separated resolution scales in (0, 1] relative to the encode resolution (e.g. 1,0.5); every scale is combined with every -qp-ladder QP, and a smaller scale is only chosen when no QP at a larger scale fits the budget")
+	candidateTimeBudget := flag.Float64("candidate-time-budget", 0, "Stop encoding QP candidates once they have taken this fraction of the frame interval (e.g. 0.5), starting from the QP nearest the previous frame's choice and using whatever candidates are ready; at least one is always encoded (0 = encode every candidate). Logged per frame to <session-dir>/salsify_candidates.csv when -session-dir is set")
+	ssrcList := flag.String("ssrc", "", "Fixed SSRCs for the local tracks as video[,audio] (e.g. 1000 or 1000,2000; one value means audio uses video+1). Empty lets pion pick random SSRCs")
+	cname := flag.String("cname", "", "CNAME (and stream ID) for all local tracks, so captures and RTCP logs from different runs line up. Empty keeps the defaults")
+	rtcpBWE := flag.Bool("rtcp-bwe", false, "Estimate available bandwidth from receiver RTCP feedback (TWCC through the GCC send-side estimator, capped by REMB) and use it for the frame budget instead of the send-side throughput. The client must negotiate transport-cc (pion clients do by default)")
 	flag.Parse()
 
+	if *helpExperiments {
+		printExperiments(os.Stdout)
+		return
+	}
+
+	trackIdentity, err := parseTrackIdentity(*ssrcList, *cname, 2)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -ssrc/-cname: %v\n", err)
+		os.Exit(1)
+	}
+	if h264Compat, err = parseH264Compat(*compat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -compat: %v\n", err)
+		os.Exit(1)
+	}
+	if hwAccel, err = parseHWAccel(*hwaccelName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -hwaccel: %v\n", err)
+		os.Exit(1)
+	}
+	if *signalURL != "" && (*offerFile != "" || *answerFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: -signal-url cannot be combined with -offer-file or -answer-file\n")
+		os.Exit(1)
+	}
+
+	if *printSDPCaps {
+		// -rtcp-bwe 注册 TWCC 发送端头部扩展，与下面创建 API 时一致
+		if *rtcpBWE {
+			bitrateEstimator = NewBitrateEstimator()
+		}
+		if err := printSDPCapabilities(*localIP, 50000, 50100, newSDPCapabilitiesAPI); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing SDP capabilities: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *videoFile == "" {
 		fmt.Fprintf(os.Stderr, "Error: -video parameter is required\n")
 		os.Exit(1)
 	}
+	if *sessionTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -session-timeout must be >= 0 (0 = unlimited)\n")
+		os.Exit(1)
+	}
+	if *maxBytes < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -max-bytes must be >= 0\n")
+		os.Exit(1)
+	}
 
 	if *sessionDir != "" {
 		if err := os.MkdirAll(*sessionDir, 0o755); err != nil {
@@ -48,6 +116,104 @@ func main() {
 		}
 	}
 
+	var rtcpLogger *RTCPLogger
+	if *rtcpLog {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -rtcp-log requires -session-dir\n")
+			os.Exit(1)
+		}
+		logger, lErr := NewRTCPLogger(filepath.Join(*sessionDir, "rtcp_server.csv"))
+		if lErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating RTCP log: %v\n", lErr)
+			os.Exit(1)
+		}
+		rtcpLogger = logger
+		defer rtcpLogger.Close()
+	}
+
+	if *pprofAddr != "" {
+		if pErr := startPprofServer(*pprofAddr); pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error starting pprof server: %v\n", pErr)
+			os.Exit(1)
+		}
+	}
+
+	if *statsInterval > 0 {
+		healthStats = NewHealthStats("server")
+	}
+
+	if *resourceUsage {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -resource-usage requires -session-dir\n")
+			os.Exit(1)
+		}
+		monitor, mErr := NewResourceMonitor(filepath.Join(*sessionDir, "resource_usage_server.csv"))
+		if mErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating resource usage log: %v\n", mErr)
+			os.Exit(1)
+		}
+		defer monitor.Close()
+	}
+
+	var frameHashes *FrameHashWriter
+	if *frameHash {
+		if *sessionDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -frame-hash requires -session-dir\n")
+			os.Exit(1)
+		}
+		var hErr error
+		frameHashes, hErr = NewFrameHashWriter(filepath.Join(*sessionDir, "frame_hashes_server.csv"))
+		if hErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating frame hash log: %v\n", hErr)
+			os.Exit(1)
+		}
+		defer frameHashes.Close()
+	}
+
+	if *controllerStateInterval < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -controller-state-interval must be >= 0\n")
+		os.Exit(1)
+	}
+	if *controllerStateInterval > 0 && *sessionDir == "" {
+		fmt.Fprintf(os.Stderr, "Error: -controller-state-interval requires -session-dir\n")
+		os.Exit(1)
+	}
+	if *startupRampFrames < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -startup-ramp must be >= 0\n")
+		os.Exit(1)
+	}
+	if *startupRampFrames > 0 && *startupBitrate <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -startup-bitrate must be > 0\n")
+		os.Exit(1)
+	}
+	if *degradeKbps < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -degrade-resolution-kbps must be >= 0\n")
+		os.Exit(1)
+	}
+	if *degradeKbps > 0 && *degradeHold <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -degrade-resolution-hold must be > 0\n")
+		os.Exit(1)
+	}
+	if *sendPressureThreshold < 0 || *sendPressureThreshold > 1 {
+		fmt.Fprintf(os.Stderr, "Error: -send-pressure must be between 0 and 1\n")
+		os.Exit(1)
+	}
+	if *candidateTimeBudget < 0 || *candidateTimeBudget > 1 {
+		fmt.Fprintf(os.Stderr, "Error: -candidate-time-budget must be between 0 and 1\n")
+		os.Exit(1)
+	}
+	ladderQPs, err := parseQPLadder(*qpLadder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -qp-ladder: %v\n", err)
+		os.Exit(1)
+	}
+	ladderScales, err := parseCandidateScales(*candidateScales)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -candidate-scales: %v\n", err)
+		os.Exit(1)
+	}
+	salsifyLadder = buildCandidateLadder(ladderQPs, ladderScales)
+
 	if _, err := os.Stat(*videoFile); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Error: video file not found: %s\n", *videoFile)
 		os.Exit(1)
@@ -59,6 +225,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 源帧率：用于发送节奏与编码器时间基，并通过 offer 的 a=framerate 告知 client
+	sourceFrameRate, err := probeVideoFrameRate(absPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to probe video file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Source frame rate: %s (%.3f fps)\n", sourceFrameRate, sourceFrameRate.Float64())
+
 	astiav.RegisterAllDevices()
 
 	// WebRTC SettingEngine
@@ -75,7 +249,27 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Starting ICE gathering (localhost mode, no STUN, fixed port range 50000-50100)...\n")
 	}
 
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	// 记录每帧的 RTP 时间戳写入 frame_metadata.csv，client 据此对齐帧号
+	if *sessionDir != "" {
+		sentRTPTimestamps = NewSentRTPTimestamps()
+	}
+
+	// 接收端的 NACK / PLI 作为丢包信号，驱动控制器的 lossRate 回退
+	lossFeedback = NewLossFeedback()
+	defer lossFeedback.Report("[Salsify]")
+
+	// 根据接收端的 TWCC / REMB 反馈估计带宽（-rtcp-bwe），需要在创建 API 之前设置
+	if *rtcpBWE {
+		bitrateEstimator = NewBitrateEstimator()
+		healthStats.SetBandwidthEstimate(bitrateEstimator.EstimatedBitrate)
+		defer bitrateEstimator.Report("[Salsify]")
+		fmt.Fprintf(os.Stderr, "[Salsify] RTCP bandwidth estimation enabled (TWCC + REMB)\n")
+	}
+
+	api, err := newWebRTCAPI(settingEngine, rtcpLogger)
+	if err != nil {
+		panic(err)
+	}
 
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
@@ -92,6 +286,7 @@ func main() {
 
 	setupPeerConnectionHandlers(peerConnection, nil, func(connectionState webrtc.ICEConnectionState) {
 		fmt.Fprintf(os.Stderr, "ICE Connection State: %s\n", connectionState.String())
+		healthStats.OnICEConnectionState(connectionState)
 		if connectionState == webrtc.ICEConnectionStateConnected {
 			fmt.Fprintf(os.Stderr, "ICE connection established!\n")
 			iceConnectedCtxCancel()
@@ -112,25 +307,30 @@ func main() {
 	})
 
 	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "video/h264"}, "video", "pion",
+		webrtc.RTPCodecCapability{MimeType: "video/h264"}, "video", trackIdentity.StreamID("pion"),
 	)
 	if err != nil {
 		panic(err)
 	}
-	if _, err = peerConnection.AddTrack(videoTrack); err != nil {
+	if _, err = addTrackWithSSRC(peerConnection, videoTrack, trackIdentity.SSRC(0)); err != nil {
 		panic(err)
 	}
 
 	opusTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion1",
+		webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", trackIdentity.StreamID("pion1"),
 	)
 	if err != nil {
 		panic(err)
 	}
-	if _, err = peerConnection.AddTrack(opusTrack); err != nil {
+	if _, err = addTrackWithSSRC(peerConnection, opusTrack, trackIdentity.SSRC(1)); err != nil {
 		panic(err)
 	}
 
+	// 收到的 RTCP 只有在应用层读取时才会经过 interceptor：NACK 重传（rtx）与 -rtcp-log 都依赖持续读取
+	for _, sender := range peerConnection.GetSenders() {
+		go drainSenderRTCP(sender)
+	}
+
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
 		panic(err)
@@ -145,8 +345,17 @@ func main() {
 	<-gatherComplete
 	fmt.Fprintf(os.Stderr, "ICE gathering completed\n")
 
-	offerStr := encode(peerConnection.LocalDescription())
-	if *offerFile != "" {
+	offerDesc, fErr := withSDPFrameRate(*peerConnection.LocalDescription(), sourceFrameRate.Float64())
+	if fErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to add frame rate to offer: %v\n", fErr)
+	}
+	offerStr := encode(&offerDesc)
+	if *signalURL != "" {
+		if err := postSignal(*signalURL, "offer", offerStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *offerFile != "" {
 		if err := os.WriteFile(*offerFile, []byte(offerStr+"\n"), 0o644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing offer to file: %v\n", err)
 			os.Exit(1)
@@ -161,7 +370,9 @@ func main() {
 	fmt.Fprintf(os.Stderr, "Waiting for answer from client...\n")
 	answer := webrtc.SessionDescription{}
 	var answerStr string
-	if *answerFile != "" {
+	if *signalURL != "" {
+		answerStr = pollSignal(*signalURL, "answer")
+	} else if *answerFile != "" {
 		fmt.Fprintf(os.Stderr, "Reading answer from file: %s\n", *answerFile)
 		answerStr = readFromFile(*answerFile)
 	} else {
@@ -178,8 +389,10 @@ func main() {
 	decode(answerStr, &answer)
 	fmt.Fprintf(os.Stderr, "Answer received, setting remote description...\n")
 	if err = peerConnection.SetRemoteDescription(answer); err != nil {
-		panic(fmt.Sprintf("Failed to set remote description: %v", err))
+		reportNegotiationFailure(os.Stderr, peerConnection.LocalDescription(), answer, err)
+		os.Exit(1)
 	}
+	logRTXNegotiation(peerConnection)
 
 	fmt.Fprintf(os.Stderr, "Waiting for ICE connection to establish...\n")
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -192,9 +405,18 @@ func main() {
 		fmt.Fprintf(os.Stderr, "WARNING: ICE connection timeout, starting video streaming anyway...\n")
 	}
 
-	initVideoSource(absPath)
+	if err := initVideoSource(absPath); err != nil {
+		freeVideoCoding()
+		fmt.Fprintf(os.Stderr, "Error: failed to initialize video source: %v\n", err)
+		os.Exit(1)
+	}
 	defer freeVideoCoding()
 
+	if *debugOverlayOn {
+		debugOverlay = NewDebugOverlay(videoFrameRate(inputFormatContext, videoStream).Invert())
+		defer debugOverlay.Free()
+	}
+
 	// 创建 frame metadata writer（如果 session-dir 存在）
 	var metadataWriter *FrameMetadataWriter
 	if *sessionDir != "" {
@@ -208,20 +430,104 @@ func main() {
 		}
 	}
 
-	// 创建 Salsify 控制器（目前仅基于发送侧吞吐做预算）
+	// 创建 Salsify 控制器：按发送侧吞吐做预算，接收端反馈丢包时回退
 	ctrl := NewSalsifyController(SalsifyConfig{
-		FrameInterval: time.Second / 30,
+		FrameInterval: frameRateInterval(sourceFrameRate),
 		LatencyTarget: *latencyTarget,
 		SafetyMargin:  *safetyMargin,
 		WindowSize:    30,
 	})
 
+	// 启动阶段的预算爬升（-startup-ramp）
+	if *startupRampFrames > 0 {
+		rampCSV := ""
+		if *sessionDir != "" {
+			rampCSV = filepath.Join(*sessionDir, "startup_ramp.csv")
+		}
+		var rErr error
+		startupRamp, rErr = NewStartupRamp(*startupRampFrames, float64(*startupBitrate)*1000, frameRateInterval(sourceFrameRate), rampCSV)
+		if rErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating startup ramp: %v\n", rErr)
+			os.Exit(1)
+		}
+		defer startupRamp.Close()
+	}
+
+	// 持续拥塞时降低分辨率（-degrade-resolution-kbps）
+	if *degradeKbps > 0 {
+		switchCSV := ""
+		if *sessionDir != "" {
+			switchCSV = filepath.Join(*sessionDir, "resolution_switches.csv")
+		}
+		var aErr error
+		resolutionAdapter, aErr = NewResolutionAdapter(float64(*degradeKbps)*1000, *degradeHold, switchCSV)
+		if aErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating resolution adapter: %v\n", aErr)
+			os.Exit(1)
+		}
+		defer resolutionAdapter.Close()
+	}
+
+	// 本地发送缓冲区压力作为拥塞信号（-send-pressure）
+	if *sendPressureThreshold > 0 {
+		pressureCSV := ""
+		if *sessionDir != "" {
+			pressureCSV = filepath.Join(*sessionDir, "send_pressure.csv")
+		}
+		var pErr error
+		sendPressure, pErr = NewSendPressureMonitor(*sendPressureThreshold, frameRateInterval(sourceFrameRate), pressureCSV)
+		if pErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating send pressure monitor: %v\n", pErr)
+			os.Exit(1)
+		}
+		defer sendPressure.Close()
+	}
+
+	// 候选编码的时间预算（-candidate-time-budget）
+	if *candidateTimeBudget > 0 {
+		candidateCSV := ""
+		if *sessionDir != "" {
+			candidateCSV = filepath.Join(*sessionDir, "salsify_candidates.csv")
+		}
+		var cErr error
+		candidateBudget, cErr = NewCandidateBudget(*candidateTimeBudget, frameRateInterval(sourceFrameRate), candidateCSV)
+		if cErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating candidate time budget: %v\n", cErr)
+			os.Exit(1)
+		}
+		defer candidateBudget.Close()
+	}
+
+	// 控制器内部状态的时间序列（-controller-state-interval），与逐帧日志分开
+	if *controllerStateInterval > 0 {
+		stateLogger, sErr := NewControllerStateLogger(filepath.Join(*sessionDir, "controller_state.csv"), *controllerStateInterval, ctrl.State)
+		if sErr != nil {
+			fmt.Fprintf(os.Stderr, "Error creating controller state log: %v\n", sErr)
+			os.Exit(1)
+		}
+		defer stateLogger.Close()
+	}
+
+	// 编码字节预算（-max-bytes）；未设置时只统计发送字节数
+	budgetTrack := NewByteBudgetTrack(sendPressure.Wrap(videoTrack), *maxBytes)
+	defer budgetTrack.Report("[Salsify]")
+	// 逐帧哈希（-frame-hash）只记录最终成功发送的帧
+	// SPS/PPS 变化检查（-verify-param-sets）
+	paramSetTrack := NewParamSetTrack(budgetTrack, "[Salsify]")
+	defer paramSetTrack.Report()
+	sendTrack := NewFrameHashTrack(paramSetTrack, frameHashes)
+
+	stopHealth := healthStats.Start(*statsInterval)
+	defer stopHealth()
+
 	videoDone := make(chan bool, 1)
-	go writeVideoToTrackSalsify(videoTrack, *loop, ctrl, videoDone, connectionClosedCtx, metadataWriter)
+	go writeVideoToTrack("[Salsify]", sendTrack, nil, *loop, ctrl, nil, videoDone, connectionClosedCtx, metadataWriter)
 
 	select {
 	case <-videoDone:
 		fmt.Fprintf(os.Stderr, "Video streaming completed, closing connection...\n")
+		// 先通知 client 正常结束（RTCP BYE），避免它把关闭连接当成中断
+		sendEndOfStream(peerConnection)
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
@@ -230,183 +536,12 @@ func main() {
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
-	case <-time.After(24 * time.Hour):
-		fmt.Fprintf(os.Stderr, "Timeout waiting for video completion\n")
+	case <-sessionTimeoutChannel(*sessionTimeout):
+		fmt.Fprintf(os.Stderr, "[Salsify] Session timeout: streaming ran for %v (-session-timeout), closing connection...\n", *sessionTimeout)
+		// 与正常结束一样先发送 RTCP BYE，client 按正常结束处理
+		sendEndOfStream(peerConnection)
 		if err := peerConnection.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error closing peer connection: %v\n", err)
 		}
 	}
 }
-
-// writeVideoToTrackSalsify 在现有 FFmpeg 管线基础上，增加按帧 bit 统计并喂给 SalsifyController。
-// 当前版本仍然只编码单个候选，但已经按帧调用 NextFrameBudget 并打印预算，便于后续扩展为多候选选择。
-func writeVideoToTrackSalsify(track *webrtc.TrackLocalStaticSample, loopVideo bool, ctrl *SalsifyController, done chan<- bool, ctx context.Context, metadataWriter *FrameMetadataWriter) {
-	frameRate := videoStream.AvgFrameRate()
-	if frameRate.Num() == 0 {
-		frameRate = astiav.NewRational(30, 1)
-	}
-	h264FrameDuration := time.Duration(float64(time.Second) * float64(frameRate.Den()) / float64(frameRate.Num()))
-
-	ticker := time.NewTicker(h264FrameDuration)
-	defer ticker.Stop()
-
-	frameID := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(os.Stderr, "[Salsify] Connection closed context triggered, stopping video streaming...\n")
-			select {
-			case done <- true:
-			default:
-			}
-			return
-		case <-ticker.C:
-			// 继续处理这一帧
-		}
-		
-		// 检查 context 是否已取消（在 ticker 触发后再次检查）
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(os.Stderr, "[Salsify] Connection closed after ticker, stopping video streaming...\n")
-			select {
-			case done <- true:
-			default:
-			}
-			return
-		default:
-		}
-		
-		decodePacket.Unref()
-
-		if err = inputFormatContext.ReadFrame(decodePacket); err != nil {
-			if errors.Is(err, astiav.ErrEof) {
-				if loopVideo {
-					if err = inputFormatContext.SeekFrame(0, 0, astiav.NewSeekFlags(astiav.SeekFlagFrame)); err != nil {
-						fmt.Fprintf(os.Stderr, "Failed to seek to beginning: %v\n", err)
-						break
-					}
-					pts = 0
-					fmt.Fprintf(os.Stderr, "Video looped, restarting from beginning...\n")
-					continue
-				}
-				fmt.Fprintf(os.Stderr, "Video playback completed (EOF reached)\n")
-				select {
-				case done <- true:
-				default:
-				}
-				break
-			}
-			fmt.Fprintf(os.Stderr, "Error reading frame: %v\n", err)
-			continue
-		}
-
-		if decodePacket.StreamIndex() != videoStream.Index() {
-			continue
-		}
-
-		decodePacket.RescaleTs(videoStream.TimeBase(), decodeCodecContext.TimeBase())
-
-		if err = decodeCodecContext.SendPacket(decodePacket); err != nil {
-			fmt.Fprintf(os.Stderr, "Error sending packet to decoder: %v\n", err)
-			continue
-		}
-
-		for {
-			if err = decodeCodecContext.ReceiveFrame(decodeFrame); err != nil {
-				if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-					break
-				}
-				fmt.Fprintf(os.Stderr, "Error receiving frame: %v\n", err)
-				break
-			}
-
-			frameID++
-			frameSendStart := time.Now()
-
-			// 闭环控制：获取当前帧预算
-			budgetBits := ctrl.NextFrameBudget()
-			fmt.Fprintf(os.Stderr, "[Salsify] Frame %d budget: %d bits\n", frameID, budgetBits)
-
-			// 初始化缩放上下文（如果还没初始化）
-			if softwareScaleContext == nil {
-				initVideoEncoding()
-			}
-
-			if err = softwareScaleContext.ScaleFrame(decodeFrame, scaledFrame); err != nil {
-				fmt.Fprintf(os.Stderr, "Error scaling frame: %v\n", err)
-				continue
-			}
-
-			pts++
-			scaledFrame.SetPts(pts)
-
-			// 多候选编码：生成多个不同 QP 的编码候选
-			candidates, err := encodeMultipleCandidates(scaledFrame, pts)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error generating encoding candidates: %v\n", err)
-				continue
-			}
-
-			// 根据预算选择候选：选择不超过预算的最高质量候选
-			var selectedCandidate *EncodedCandidate
-			for i := range candidates {
-				cand := &candidates[i]
-				if cand.Bits <= budgetBits {
-					// 找到不超过预算的候选，选择 QP 最低的（质量最高）
-					if selectedCandidate == nil || cand.QP < selectedCandidate.QP {
-						selectedCandidate = cand
-					}
-				}
-			}
-
-			// 如果所有候选都超预算，选择最小的一个（记录 budget violation）
-			if selectedCandidate == nil {
-				selectedCandidate = &candidates[len(candidates)-1] // 选择 QP 最高的（最小）
-				fmt.Fprintf(os.Stderr, "[Salsify] Frame %d: All candidates exceed budget, selecting smallest (QP=%d, bits=%d)\n",
-					frameID, selectedCandidate.QP, selectedCandidate.Bits)
-			} else {
-				fmt.Fprintf(os.Stderr, "[Salsify] Frame %d: Selected candidate QP=%d, bits=%d (budget=%d)\n",
-					frameID, selectedCandidate.QP, selectedCandidate.Bits, budgetBits)
-			}
-
-			// 发送选中的候选：按 packet（NALU）边界发送
-			sentBitsForFrame := selectedCandidate.Bits
-
-			// 将候选的每个 packet（对应一个 NALU）逐个发送
-			for _, pktData := range selectedCandidate.Packets {
-				if err = track.WriteSample(media.Sample{Data: pktData, Duration: h264FrameDuration}); err != nil {
-					fmt.Fprintf(os.Stderr, "Error writing sample (connection may be closed): %v\n", err)
-					// 如果写入失败，可能是连接已断开，退出循环
-					select {
-					case done <- true:
-					default:
-					}
-					return
-				}
-			}
-
-			frameSendEnd := time.Now()
-
-			ctrl.UpdateStats(SalsifyObservation{
-				FrameID:      frameID,
-				SentBits:     sentBitsForFrame,
-				SendStart:    frameSendStart,
-				SendEnd:      frameSendEnd,
-				LossDetected: false,
-			})
-
-			// 写入 frame metadata
-			if metadataWriter != nil {
-				metadataWriter.WriteMetadata(FrameMetadata{
-					FrameID:   frameID,
-					SendStart: frameSendStart,
-					SendEnd:   frameSendEnd,
-					FrameBits: sentBitsForFrame,
-				})
-			}
-		}
-	}
-}
-
-
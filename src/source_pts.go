@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// source_pts.go - 解码帧 PTS 的校验与单调化（所有 server 共用）
+//
+// 说明：
+//   - 编码器使用自己的 pts 计数，但按 PTS 安排发送节奏、把源时间戳映射到 RTP 时都要依赖解码帧的 PTS
+//   - 损坏的文件、seek 之后、拼接的源文件中常见 AV_NOPTS_VALUE、负 PTS 或回退的 PTS；
+//     这些帧的 PTS 替换为 "上一帧 + 一个帧间隔"，保证严格递增，并打印修正日志
+//   - 循环播放 / 重新打开源文件时时间轴从头开始，需要调用 Reset，否则回到开头的每一帧都会被当成回退
+//   - 送入编码器的 pts 由校验后的 PTS 换算（EncoderPTS），而不是每帧加一：可变帧率的源、源文件中缺帧时，
+//     编码器（码率控制、B 帧时的 RTP 时间戳）看到的帧间隔与源一致。解码器已经按显示顺序输出含 B 帧的源，
+//     PTS 回退只会来自损坏的时间戳，已由 Validate 修正
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/asticode/go-astiav"
+)
+
+// sourcePTSLogLimit 是逐条打印的修正次数，之后只计数（Report 中汇总）
+const sourcePTSLogLimit = 10
+
+// sourcePTSValidator 校验并修正解码帧的 PTS，只能在解码协程中使用
+type sourcePTSValidator struct {
+	timeBase astiav.Rational // 解码时间基
+	step     int64           // 一个帧间隔（解码时间基），至少为 1
+
+	last    int64
+	hasLast bool
+
+	// EncoderPTS 的换算原点：Reset 之后第一帧的源 PTS 与对应的编码 pts
+	origin        int64
+	originEncoded int64
+	hasOrigin     bool
+
+	frames      int
+	corrections int
+}
+
+// newSourcePTSValidator 按解码时间基与源帧率创建校验器
+func newSourcePTSValidator(timeBase, frameRate astiav.Rational) *sourcePTSValidator {
+	step := int64(1)
+	if timeBase.Num() > 0 && timeBase.Den() > 0 && frameRate.Num() > 0 && frameRate.Den() > 0 {
+		step = max(astiav.RescaleQ(1, frameRate.Invert(), timeBase), 1)
+	}
+	return &sourcePTSValidator{timeBase: timeBase, step: step}
+}
+
+// Validate 检查 frame 的 PTS，无效（缺失、为负或不递增）时就地替换为推算值，返回最终的 PTS
+func (v *sourcePTSValidator) Validate(frame *astiav.Frame) int64 {
+	pts := frame.Pts()
+	v.frames++
+
+	var reason string
+	switch {
+	case pts == astiav.NoPtsValue:
+		reason = "missing"
+	case pts < 0:
+		reason = "negative"
+	case v.hasLast && pts <= v.last:
+		reason = "non-increasing"
+	}
+
+	if reason != "" {
+		derived := int64(0)
+		if v.hasLast {
+			derived = v.last + v.step
+		}
+		v.corrections++
+		if v.corrections <= sourcePTSLogLimit {
+			original := "NOPTS"
+			if pts != astiav.NoPtsValue {
+				original = fmt.Sprintf("%d", pts)
+			}
+			fmt.Fprintf(os.Stderr, "Source PTS %s at decoded frame %d (%s), using %d\n", reason, v.frames, original, derived)
+			if v.corrections == sourcePTSLogLimit {
+				fmt.Fprintf(os.Stderr, "Further source PTS corrections are only counted\n")
+			}
+		}
+		pts = derived
+		frame.SetPts(pts)
+	}
+
+	v.last = pts
+	v.hasLast = true
+	return pts
+}
+
+// EncoderPTS 把 Validate 返回的源 PTS 换算为编码器时间基 encodeTimeBase 下的 pts；prev 为上一帧送入编码器的 pts。
+// 按与原点的差换算，结果至少为 prev+1（两帧的间隔小于一个编码时间基时顺延），保证送入编码器的 pts 严格递增
+func (v *sourcePTSValidator) EncoderPTS(pts, prev int64, encodeTimeBase astiav.Rational) int64 {
+	if !v.hasOrigin || v.timeBase.Num() <= 0 || v.timeBase.Den() <= 0 {
+		v.origin, v.originEncoded, v.hasOrigin = pts, prev+1, true
+	}
+	encoded := v.originEncoded + astiav.RescaleQ(pts-v.origin, v.timeBase, encodeTimeBase)
+	if encoded <= prev {
+		encoded = prev + 1
+	}
+	return encoded
+}
+
+// Reset 在源时间轴重新开始（循环、重新打开文件）时调用
+func (v *sourcePTSValidator) Reset() {
+	v.hasLast = false
+	v.hasOrigin = false
+}
+
+// Report 在有修正时打印汇总
+func (v *sourcePTSValidator) Report(prefix string) {
+	if v.corrections == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s Source PTS corrected on %d of %d decoded frames\n", prefix, v.corrections, v.frames)
+}
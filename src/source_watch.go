@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// source_watch.go - 监视源视频文件的变化（-watch）
+//
+// 说明：
+//   - 只比较 mtime 与文件大小，不依赖 inotify 等平台相关机制；检查频率受 sourceWatchPoll 限制，可以在发送循环中每帧调用
+//   - 编辑器 / 转码工具写文件时往往分多次写入，文件在 debounce 时间内不再变化才算一次修改，避免读到写了一半的文件
+//   - 文件暂时不存在（先删除再写入的保存方式）时不触发，等新文件出现并稳定后再触发
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// sourceWatchPoll 是两次 stat 之间的最小间隔
+const sourceWatchPoll = 250 * time.Millisecond
+
+// fileSignature 是判断文件是否变化的依据
+type fileSignature struct {
+	modTime int64 // UnixNano，便于直接用 == 比较
+	size    int64
+}
+
+// SourceWatcher 轮询一个文件，文件变化并稳定 debounce 时间后 Changed 返回 true（每次变化只返回一次）。
+// 方法对 nil 安全（nil 表示未开启 -watch），只能在单个协程中使用。
+type SourceWatcher struct {
+	path     string
+	debounce time.Duration
+
+	loaded   fileSignature // 当前已加载内容对应的签名
+	pending  fileSignature // 观察到的新签名，等待稳定
+	changeAt time.Time     // pending 最近一次变化的时间，零值表示没有待处理的变化
+	lastPoll time.Time
+}
+
+// newSourceWatcher 以文件当前状态为基准创建 watcher
+func newSourceWatcher(path string, debounce time.Duration) (*SourceWatcher, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SourceWatcher{
+		path:     path,
+		debounce: debounce,
+		loaded:   fileSignature{modTime: info.ModTime().UnixNano(), size: info.Size()},
+	}, nil
+}
+
+// Changed 报告文件是否已修改并稳定，返回 true 后新内容即视为已加载
+func (w *SourceWatcher) Changed() bool {
+	if w == nil {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(w.lastPoll) < sourceWatchPoll {
+		return false
+	}
+	w.lastPoll = now
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	sig := fileSignature{modTime: info.ModTime().UnixNano(), size: info.Size()}
+
+	if sig == w.loaded {
+		w.changeAt = time.Time{}
+		return false
+	}
+	if w.changeAt.IsZero() || sig != w.pending {
+		w.pending = sig
+		w.changeAt = now
+		return false
+	}
+	if now.Sub(w.changeAt) < w.debounce {
+		return false
+	}
+	w.loaded = sig
+	w.changeAt = time.Time{}
+	return true
+}
+
+// Path 返回被监视的文件路径
+func (w *SourceWatcher) Path() string {
+	return w.path
+}
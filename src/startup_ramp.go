@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// startup_ramp.go - 启动阶段的帧预算爬升（-startup-ramp / -startup-bitrate）
+//
+// 说明：
+//   - 连接刚建立时控制器还没有任何带宽估计，NDTC / BurstRTC 按 5 Mbps 的缺省值、Salsify 按 500 kbps 计算预算，
+//     第一帧又是 IDR，受限链路上一开始就会丢包，首屏质量很差
+//   - 开启后前 N 帧的预算不超过爬升上限：第一帧按 -startup-bitrate 计算，之后按指数（几何插值）逐帧升到控制器给出的预算，
+//     第 N 帧之后完全由控制器决定；控制器本身给出的预算更低时直接使用控制器的值
+//   - 预算通过各 server 已有的映射（CRF / 候选 QP）作用于编码器，所以第一帧 IDR 以较高 QP 编码
+//   - 控制器仍然观测爬升期间实际发送的帧，爬升只限制预算，不修改控制器状态
+//   - 指定 -session-dir 时逐帧记录到 startup_ramp.csv，开始与结束时在 stderr 各打印一行
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// startupRamp 在 -startup-ramp 开启时非 nil，由发送循环在取得控制器预算后调用
+var startupRamp *StartupRamp
+
+// StartupRamp 限制启动阶段前若干帧的预算，方法对 nil 安全，只能在发送协程中使用
+type StartupRamp struct {
+	frames    int
+	startBits float64 // 第一帧的预算上限（比特）
+	index     int     // 已经处理的帧数
+	capped    int     // 被爬升上限截断的帧数
+
+	writer *csv.Writer
+	file   *os.File
+}
+
+// NewStartupRamp 创建爬升器：frames 帧内从 startBps 升到控制器的预算；csvPath 为空时不写逐帧记录
+func NewStartupRamp(frames int, startBps float64, frameInterval time.Duration, csvPath string) (*StartupRamp, error) {
+	r := &StartupRamp{
+		frames:    frames,
+		startBits: math.Max(startBps*frameInterval.Seconds(), 1),
+	}
+	if csvPath == "" {
+		return r, nil
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create startup ramp csv: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"frame", "unix_ms", "controller_bits", "ramp_cap_bits", "budget_bits"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write startup ramp header: %w", err)
+	}
+	w.Flush()
+	r.writer, r.file = w, f
+	return r, nil
+}
+
+// Apply 返回本帧实际使用的预算（比特）；爬升结束后原样返回 controllerBits
+func (r *StartupRamp) Apply(controllerBits int) int {
+	if r == nil || r.index >= r.frames {
+		return controllerBits
+	}
+	if r.index == 0 {
+		fmt.Fprintf(os.Stderr, "[Ramp] Startup ramp: first %d frames start at %.0f bits/frame\n", r.frames, r.startBits)
+	}
+
+	// 第 i 帧（从 0 开始）的上限为 start * (target/start)^(i/frames)
+	progress := float64(r.index) / float64(r.frames)
+	rampCap := float64(controllerBits)
+	if rampCap > r.startBits {
+		rampCap = r.startBits * math.Pow(rampCap/r.startBits, progress)
+	}
+	budget := min(controllerBits, max(int(math.Round(rampCap)), 1))
+	if budget < controllerBits {
+		r.capped++
+	}
+	r.index++
+
+	if r.writer != nil {
+		if err := r.writer.Write([]string{
+			fmt.Sprintf("%d", r.index),
+			fmt.Sprintf("%d", time.Now().UnixMilli()),
+			fmt.Sprintf("%d", controllerBits),
+			fmt.Sprintf("%.0f", rampCap),
+			fmt.Sprintf("%d", budget),
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing startup ramp CSV: %v\n", err)
+		}
+		r.writer.Flush()
+	}
+	if r.index == r.frames {
+		fmt.Fprintf(os.Stderr, "[Ramp] Startup ramp finished after %d frames (%d capped), controller budget now in effect\n", r.frames, r.capped)
+	}
+	return budget
+}
+
+// Close 关闭 CSV 文件
+func (r *StartupRamp) Close() {
+	if r == nil || r.file == nil {
+		return
+	}
+	r.writer.Flush()
+	if err := r.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing startup ramp CSV file: %v\n", err)
+	}
+}
@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// stream_control.go - 通过 data channel 控制回放（server 的 -keep-open，client 的 -control）
+//
+// 说明：
+//   - server 在创建 offer 前建立名为 "control" 的 data channel，client 在 OnDataChannel 中接收它
+//   - 命令是一行文本：replay（从头播放）或 seek <秒>（从指定位置播放）；server 对每条命令回复一行 ok / error
+//   - 命令只在发送循环中处理（Poll），data channel 的回调不直接操作 FFmpeg 状态
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// streamControlLabel 是控制 data channel 的名称
+	streamControlLabel = "control"
+	// idleKeepaliveInterval 是 server 在 EOF 后等待期间发送保活帧的间隔，需小于 client 的读超时
+	idleKeepaliveInterval = time.Second
+)
+
+// streamCommand 是一条解析后的控制命令；replay 等价于 seek 0
+type streamCommand struct {
+	Text     string        // 原始命令，用于日志与回复
+	Position time.Duration // 播放起点
+}
+
+// parseStreamCommand 解析一行控制命令
+func parseStreamCommand(line string) (streamCommand, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return streamCommand{}, fmt.Errorf("empty command")
+	}
+	cmd := streamCommand{Text: strings.Join(fields, " ")}
+	switch strings.ToLower(fields[0]) {
+	case "replay":
+		if len(fields) != 1 {
+			return cmd, fmt.Errorf("usage: replay")
+		}
+	case "seek":
+		if len(fields) != 2 {
+			return cmd, fmt.Errorf("usage: seek <seconds>")
+		}
+		seconds, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || seconds < 0 {
+			return cmd, fmt.Errorf("invalid seek position %q", fields[1])
+		}
+		cmd.Position = time.Duration(seconds * float64(time.Second))
+	default:
+		return cmd, fmt.Errorf("unknown command %q (expected replay or seek <seconds>)", fields[0])
+	}
+	return cmd, nil
+}
+
+// StreamControl 是 server 端的控制通道，方法对 nil 安全（nil 表示未开启 -keep-open）
+type StreamControl struct {
+	dc       *webrtc.DataChannel
+	commands chan streamCommand
+}
+
+// newStreamControl 在 pc 上创建控制 data channel，必须在 CreateOffer 之前调用
+func newStreamControl(pc *webrtc.PeerConnection) (*StreamControl, error) {
+	dc, err := pc.CreateDataChannel(streamControlLabel, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &StreamControl{dc: dc, commands: make(chan streamCommand, 8)}
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		cmd, pErr := parseStreamCommand(string(msg.Data))
+		if pErr != nil {
+			c.reply("error " + pErr.Error())
+			return
+		}
+		select {
+		case c.commands <- cmd:
+		default:
+			c.reply("error busy, command dropped: " + cmd.Text)
+		}
+	})
+	return c, nil
+}
+
+// Poll 非阻塞地取出一条待处理的命令
+func (c *StreamControl) Poll() (streamCommand, bool) {
+	if c == nil {
+		return streamCommand{}, false
+	}
+	select {
+	case cmd := <-c.commands:
+		return cmd, true
+	default:
+		return streamCommand{}, false
+	}
+}
+
+// Done 回复一条命令的处理结果，err 为 nil 表示成功
+func (c *StreamControl) Done(cmd streamCommand, err error) {
+	if c == nil {
+		return
+	}
+	if err != nil {
+		c.reply(fmt.Sprintf("error %s: %v", cmd.Text, err))
+		return
+	}
+	c.reply("ok " + cmd.Text)
+}
+
+// Notify 向 client 发送一条状态消息（例如到达 EOF）
+func (c *StreamControl) Notify(text string) {
+	if c == nil {
+		return
+	}
+	c.reply(text)
+}
+
+func (c *StreamControl) reply(text string) {
+	if c.dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	if err := c.dc.SendText(text); err != nil {
+		fmt.Fprintf(os.Stderr, "Error replying on control channel: %v\n", err)
+	}
+}
+
+// forwardControlCommands 是 client 端（-control）：收到 server 的控制 data channel 后，
+// 把 stdin 中的每一行作为命令发送，并打印 server 的回复
+func forwardControlCommands(pc *webrtc.PeerConnection) {
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != streamControlLabel {
+			return
+		}
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			fmt.Fprintf(os.Stderr, "[Control] %s\n", string(msg.Data))
+		})
+		dc.OnOpen(func() {
+			fmt.Fprintf(os.Stderr, "[Control] Channel open: type replay or seek <seconds>\n")
+			go func() {
+				scanner := bufio.NewScanner(os.Stdin)
+				for scanner.Scan() {
+					line := strings.TrimSpace(scanner.Text())
+					if line == "" {
+						continue
+					}
+					if err := dc.SendText(line); err != nil {
+						fmt.Fprintf(os.Stderr, "[Control] Error sending command: %v\n", err)
+						return
+					}
+				}
+			}()
+		})
+	})
+}
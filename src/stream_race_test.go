@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// raceTrackContext 是 TrackLocalStaticSample.Bind 需要的最小 TrackLocalContext，发出的包交给 writer
+type raceTrackContext struct {
+	writer webrtc.TrackLocalWriter
+}
+
+func (c *raceTrackContext) CodecParameters() []webrtc.RTPCodecParameters {
+	return []webrtc.RTPCodecParameters{{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		},
+		PayloadType: 102,
+	}}
+}
+func (c *raceTrackContext) HeaderExtensions() []webrtc.RTPHeaderExtensionParameter { return nil }
+func (c *raceTrackContext) SSRC() webrtc.SSRC                                      { return 1234 }
+func (c *raceTrackContext) SSRCRetransmission() webrtc.SSRC                        { return 0 }
+func (c *raceTrackContext) SSRCForwardErrorCorrection() webrtc.SSRC                { return 0 }
+func (c *raceTrackContext) WriteStream() webrtc.TrackLocalWriter                   { return c.writer }
+func (c *raceTrackContext) ID() string                                             { return "race" }
+func (c *raceTrackContext) RTCPReader() interceptor.RTCPReader                     { return nil }
+
+// raceWriter 接收打包后的 RTP 包：按 frameDispersion 的发送侧记录每个包，并保存负载的副本供之后检查
+type raceWriter struct {
+	transportSeq uint16
+	payloads     [][]byte
+	timestamps   []uint32
+}
+
+func (w *raceWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	frameDispersion.onSent(header.SequenceNumber, header.Timestamp, w.transportSeq, len(payload)*8, time.Now())
+	w.transportSeq++
+	w.payloads = append(w.payloads, append([]byte(nil), payload...))
+	w.timestamps = append(w.timestamps, header.Timestamp)
+	return len(payload), nil
+}
+
+func (w *raceWriter) Write(b []byte) (int, error) {
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	return w.WriteRTP(&pkt.Header, pkt.Payload)
+}
+
+// TestStreamingConcurrentReads 在 -race 下发送大量帧：每帧复用同一个缓冲区交给 WriteSample，
+// 同时由其它协程读取控制器状态、健康统计并喂入 TWCC 反馈。make test 以 -race 运行
+func TestStreamingConcurrentReads(t *testing.T) {
+	const frames = 300
+	const frameBytes = 4000 // 多于一个 MTU，按 FU-A 分片
+
+	frameDispersion = NewFrameDispersion()
+	defer func() { frameDispersion = nil }()
+	health := NewHealthStats("server")
+	ctrl := NewNdtcController(time.Second/30, NewFdaceWindow(120))
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "race")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &raceWriter{}
+	if _, err = track.Bind(&raceTrackContext{writer: writer}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { // 控制器状态与健康统计的读取（-controller-state-interval、-debug-overlay、-stats-interval）
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = ctrl.State()
+			_, _ = ctrl.NextFrameBudget()
+			_ = ctrl.CapacityEstimate()
+			_ = health.line(time.Now())
+		}
+	}()
+	go func() { // RTCP 读取协程：对已经发出的序列号回送 TWCC 反馈
+		defer wg.Done()
+		var base uint16
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			const count = 50
+			deltas := make([]*rtcp.RecvDelta, count)
+			for i := range deltas {
+				deltas[i] = &rtcp.RecvDelta{Type: rtcp.TypeTCCPacketReceivedSmallDelta, Delta: 250}
+			}
+			fb := &rtcp.TransportLayerCC{
+				BaseSequenceNumber: base,
+				PacketStatusCount:  count,
+				PacketChunks:       []rtcp.PacketStatusChunk{&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketReceivedSmallDelta, RunLength: count}},
+				RecvDeltas:         deltas,
+			}
+			frameDispersion.Observe([]rtcp.Packet{fb})
+			health.ObserveRTCP([]rtcp.Packet{fb}, time.Now())
+			base += count
+		}
+	}()
+
+	// 发送循环：同一个缓冲区每帧填入不同的内容，WriteSample 返回后立即被改写
+	buf := make([]byte, frameBytes)
+	for i := 0; i < frames; i++ {
+		fill := byte(1 + i%250)
+		copy(buf, []byte{0x00, 0x00, 0x00, 0x01, 0x65})
+		for j := 5; j < len(buf); j++ {
+			buf[j] = fill
+		}
+		start := time.Now()
+		if err = track.WriteSample(media.Sample{Data: buf, Duration: time.Second / 30}); err != nil {
+			t.Fatal(err)
+		}
+		ctrl.UpdateStats(FrameObservation{
+			FrameID:        i + 1,
+			SentBits:       len(buf) * 8,
+			SendStart:      start,
+			SendEnd:        time.Now(),
+			ReceiveSamples: frameDispersion.Take(),
+		})
+		health.AddFrame(len(buf))
+		for j := range buf {
+			buf[j] = 0
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// 每个包的负载（去掉 FU-A 的两字节头）必须是发送时那一帧的内容：打包器没有保留发送循环的缓冲区
+	if len(writer.payloads) < frames {
+		t.Fatalf("%d packets for %d frames", len(writer.payloads), frames)
+	}
+	frame := -1
+	var lastTimestamp uint32
+	for i, payload := range writer.payloads {
+		if i == 0 || writer.timestamps[i] != lastTimestamp {
+			frame++
+			lastTimestamp = writer.timestamps[i]
+		}
+		want := bytes.Repeat([]byte{byte(1 + frame%250)}, len(payload)-2)
+		if len(payload) < 3 || !bytes.Equal(payload[2:], want) {
+			t.Fatalf("packet %d of frame %d does not carry the frame's data", i, frame)
+		}
+	}
+	if frame != frames-1 {
+		t.Errorf("packets cover %d frames, want %d", frame+1, frames)
+	}
+}
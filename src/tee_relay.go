@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// tee_relay.go - client 的 tee 模式：录制的同时把收到的视频 RTP 转发给下游 peer（单跳 relay）
+//
+// 说明：
+//   - 下游使用单独的 PeerConnection，由本端发出 offer（与 server 相同的文件交换方式），
+//     下游可以直接是另一个 client：-offer-file 指向本端的 tee offer，-answer-file 指向 tee answer
+//   - RTP 包不转码直接转发，TrackLocalStaticRTP 负责改写 SSRC / payload type
+//   - 下游的 PLI / FIR 转换为对上游的 PLI，由上游 server 产生关键帧
+//   - 下游连接建立之前收到的包直接丢弃（没有绑定时 WriteRTP 不发送）
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// teeKeyframeRequestInterval 限制向上游转发关键帧请求的频率，避免多个下游请求叠加成关键帧风暴
+const teeKeyframeRequestInterval = 500 * time.Millisecond
+
+// TeeRelay 把一条上游视频轨道转发给一个下游 peer
+type TeeRelay struct {
+	peerConnection *webrtc.PeerConnection
+	track          *webrtc.TrackLocalStaticRTP
+
+	mu               sync.Mutex
+	upstream         *webrtc.PeerConnection
+	upstreamSSRC     webrtc.SSRC
+	lastKeyframeReq  time.Time
+	forwardedPackets int64
+	forwardErrors    int64
+}
+
+// NewTeeRelay 创建下游 PeerConnection 与转发用的 H.264 轨道
+func NewTeeRelay(api *webrtc.API, config webrtc.Configuration) (*TeeRelay, error) {
+	pc, err := api.NewPeerConnection(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create downstream peer connection: %w", err)
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, "video", "tee",
+	)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("failed to create relay track: %w", err)
+	}
+
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("failed to add relay track: %w", err)
+	}
+
+	r := &TeeRelay{peerConnection: pc, track: track}
+	go r.readDownstreamRTCP(sender)
+
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		fmt.Fprintf(os.Stderr, "[Tee] Downstream connection state: %s\n", s.String())
+	})
+
+	return r, nil
+}
+
+// SetUpstream 记录上游连接与视频 SSRC，用于转发关键帧请求
+func (r *TeeRelay) SetUpstream(pc *webrtc.PeerConnection, ssrc webrtc.SSRC) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.upstream = pc
+	r.upstreamSSRC = ssrc
+}
+
+// Negotiate 生成下游 offer 写入 offerFile，并等待下游把 answer 写入 answerFile。
+// frameRate 写入 offer 的 a=framerate，下游 client 据此计算指标。
+func (r *TeeRelay) Negotiate(offerFile, answerFile string, frameRate float64) error {
+	offer, err := r.peerConnection.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create downstream offer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(r.peerConnection)
+	if err = r.peerConnection.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("failed to set downstream local description: %w", err)
+	}
+	<-gatherComplete
+
+	offerDesc, fErr := withSDPFrameRate(*r.peerConnection.LocalDescription(), frameRate)
+	if fErr != nil {
+		fmt.Fprintf(os.Stderr, "[Tee] Warning: Failed to add frame rate to offer: %v\n", fErr)
+	}
+	offerStr := encode(&offerDesc)
+	if err = os.WriteFile(offerFile, []byte(offerStr+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write downstream offer: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "[Tee] Downstream offer written to file: %s (%d bytes)\n", offerFile, len(offerStr))
+
+	fmt.Fprintf(os.Stderr, "[Tee] Waiting for downstream answer: %s\n", answerFile)
+	answerStr := readFromFile(answerFile)
+	if answerStr == "" {
+		return fmt.Errorf("no downstream answer received")
+	}
+
+	answer := webrtc.SessionDescription{}
+	decode(answerStr, &answer)
+	if err = r.peerConnection.SetRemoteDescription(answer); err != nil {
+		reportNegotiationFailure(os.Stderr, r.peerConnection.LocalDescription(), answer, err)
+		return fmt.Errorf("failed to set downstream remote description: %w", err)
+	}
+	return nil
+}
+
+// Forward 把一个上游 RTP 包转发给下游
+func (r *TeeRelay) Forward(pkt *rtp.Packet) {
+	// 上游的头部扩展 ID 是与上游协商的，对下游没有意义（还可能与下游的 ID 冲突），转发前去掉
+	out := *pkt
+	out.Header.Extension = false
+	out.Header.Extensions = nil
+	err := r.track.WriteRTP(&out)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.forwardErrors++
+		return
+	}
+	r.forwardedPackets++
+}
+
+// readDownstreamRTCP 读取下游的 RTCP（同时驱动 interceptor），把 PLI / FIR 转为对上游的 PLI
+func (r *TeeRelay) readDownstreamRTCP(sender *webrtc.RTPSender) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range pkts {
+			switch pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				r.requestUpstreamKeyframe()
+			}
+		}
+	}
+}
+
+// requestUpstreamKeyframe 向上游发送 PLI（按 teeKeyframeRequestInterval 限速）
+func (r *TeeRelay) requestUpstreamKeyframe() {
+	r.mu.Lock()
+	upstream, ssrc := r.upstream, r.upstreamSSRC
+	if upstream == nil || time.Since(r.lastKeyframeReq) < teeKeyframeRequestInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastKeyframeReq = time.Now()
+	r.mu.Unlock()
+
+	if err := upstream.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}}); err != nil {
+		fmt.Fprintf(os.Stderr, "[Tee] Error forwarding keyframe request upstream: %v\n", err)
+	}
+}
+
+// Close 关闭下游连接并输出转发统计
+func (r *TeeRelay) Close() {
+	r.mu.Lock()
+	forwarded, errs := r.forwardedPackets, r.forwardErrors
+	r.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "[Tee] Forwarded %d RTP packets downstream (%d write errors)\n", forwarded, errs)
+
+	if err := r.peerConnection.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "[Tee] Error closing downstream peer connection: %v\n", err)
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// track_identity.go - 本地轨道的固定 SSRC 与 CNAME（-ssrc / -cname）
+//
+// 说明：
+//   - 默认情况下 pion 为每个轨道随机分配 SSRC，多次运行之间的抓包、rtcp_*.csv 无法直接对齐
+//   - -ssrc 按轨道顺序（视频、音频）指定 SSRC；只给一个值时其余轨道依次加 1
+//   - pion 把轨道的 stream ID 写作 SDP 中的 cname（a=ssrc:<ssrc> cname:<stream ID>），
+//     因此 -cname 通过把所有轨道的 stream ID 设为同一个值实现，同时让对端把音视频归入同一个 MediaStream
+//   - rtx 重传流的 SSRC 仍由 pion 随机分配
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// TrackIdentity 是 -ssrc / -cname 解析后的结果，零值表示全部使用 pion 的默认值
+type TrackIdentity struct {
+	SSRCs []uint32 // 按轨道顺序（视频、音频）；为空表示随机分配
+	CNAME string   // 为空表示使用各轨道原来的 stream ID
+}
+
+// parseTrackIdentity 解析 -ssrc（逗号分隔的 SSRC 列表）与 -cname，trackCount 为本地轨道数
+func parseTrackIdentity(ssrcList, cname string, trackCount int) (TrackIdentity, error) {
+	var id TrackIdentity
+
+	if strings.IndexFunc(cname, unicode.IsSpace) >= 0 {
+		return id, fmt.Errorf("cname %q must not contain whitespace", cname)
+	}
+	id.CNAME = cname
+
+	if ssrcList == "" {
+		return id, nil
+	}
+
+	parts := strings.Split(ssrcList, ",")
+	if len(parts) != 1 && len(parts) != trackCount {
+		return id, fmt.Errorf("expected 1 or %d SSRCs (video,audio), got %d", trackCount, len(parts))
+	}
+
+	for _, part := range parts {
+		v, err := strconv.ParseUint(strings.TrimSpace(part), 0, 32)
+		if err != nil {
+			return id, fmt.Errorf("invalid SSRC %q: %w", part, err)
+		}
+		if v == 0 {
+			return id, fmt.Errorf("SSRC must be non-zero")
+		}
+		id.SSRCs = append(id.SSRCs, uint32(v))
+	}
+
+	// 只给出一个值时，其余轨道使用连续的 SSRC
+	for len(id.SSRCs) < trackCount {
+		next := id.SSRCs[len(id.SSRCs)-1] + 1
+		if next == 0 {
+			return id, fmt.Errorf("SSRC %d leaves no room for %d consecutive tracks", id.SSRCs[0], trackCount)
+		}
+		id.SSRCs = append(id.SSRCs, next)
+	}
+
+	seen := make(map[uint32]bool, len(id.SSRCs))
+	for _, ssrc := range id.SSRCs {
+		if seen[ssrc] {
+			return id, fmt.Errorf("duplicate SSRC %d: each track needs its own SSRC", ssrc)
+		}
+		seen[ssrc] = true
+	}
+	return id, nil
+}
+
+// StreamID 返回轨道应使用的 stream ID：指定了 -cname 时使用它，否则使用 defaultID
+func (id TrackIdentity) StreamID(defaultID string) string {
+	if id.CNAME != "" {
+		return id.CNAME
+	}
+	return defaultID
+}
+
+// SSRC 返回第 index 个轨道的 SSRC，0 表示由 pion 分配
+func (id TrackIdentity) SSRC(index int) webrtc.SSRC {
+	if index < 0 || index >= len(id.SSRCs) {
+		return 0
+	}
+	return webrtc.SSRC(id.SSRCs[index])
+}
+
+// addTrackWithSSRC 与 PeerConnection.AddTrack 相同（sendrecv transceiver），ssrc 非 0 时固定发送端的 SSRC
+func addTrackWithSSRC(pc *webrtc.PeerConnection, track webrtc.TrackLocal, ssrc webrtc.SSRC) (*webrtc.RTPSender, error) {
+	if ssrc == 0 {
+		return pc.AddTrack(track)
+	}
+
+	transceiver, err := pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendrecv,
+		SendEncodings: []webrtc.RTPEncodingParameters{
+			{RTPCodingParameters: webrtc.RTPCodingParameters{SSRC: ssrc}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transceiver.Sender(), nil
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// trickle_ice.go - 逐个交换 ICE 候选（基础 server / client 的 -trickle）
+//
+// 说明：
+//   - 默认两端在 SetLocalDescription 之后等待 GatheringCompletePromise，SDP 中带上全部候选再发出；
+//     有多个网卡或配置了 STUN 时收集要几秒，协商因此变慢
+//   - -trickle 时 SDP 立即发出（不含候选），之后 OnICECandidate 每收集到一个候选就通过 -signal-url 的 WebSocket 发给对端，
+//     对端收到后调用 AddICECandidate；ICE 在第一对候选可用时就开始连通性检查
+//   - 需要双向的信令通道，只支持 ws:// / wss:// 的 -signal-url：offer 一端的候选以 "offer-candidate"、answer 一端的以 "answer-candidate"
+//     消息发送，payload 是 ICECandidateInit 的 JSON；sdp-bridge 暂存 offer 一端的候选，后加入房间的 client 也能收到
+//   - 本端 SDP 发出之前收集到的候选先排队（对端还没有远端描述，无法添加），由 SignalSent 发出；
+//     对端的候选在本端设置远端描述后（Start）才添加，之前到达的由 ws_signal.go 暂存
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/net/websocket"
+)
+
+// TrickleICE 通过 -signal-url 的 WebSocket 收发一个 PeerConnection 的 ICE 候选；
+// SignalSent / Start / Close 对 nil 安全（未开启 -trickle 时什么都不做）
+type TrickleICE struct {
+	baseURL    string
+	localKind  string
+	remoteKind string
+	pc         *webrtc.PeerConnection
+	conn       *wsSignalConn
+
+	mu      sync.Mutex
+	ready   bool     // 本端 SDP 已发出，候选可以直接发送
+	queued  []string // SDP 发出之前收集到的候选
+	closed  bool
+	sent    int
+	added   int
+	started bool
+}
+
+// NewTrickleICE 连接 baseURL 房间的 WebSocket，并接管 pc 的 OnICECandidate；offerer 表示本端发送 offer。
+// 必须在 SetLocalDescription 之前调用
+func NewTrickleICE(pc *webrtc.PeerConnection, baseURL string, offerer bool) (*TrickleICE, error) {
+	conn, err := holdWebSocketSignal(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	t := &TrickleICE{baseURL: baseURL, pc: pc, conn: conn,
+		localKind: signalKindAnswerCandidate, remoteKind: signalKindOfferCandidate}
+	if offerer {
+		t.localKind, t.remoteKind = t.remoteKind, t.localKind
+	}
+	pc.OnICECandidate(t.onCandidate)
+	return t, nil
+}
+
+// onCandidate 在收集到一个候选时调用；candidate 为 nil 表示收集完成
+func (t *TrickleICE) onCandidate(candidate *webrtc.ICECandidate) {
+	if candidate == nil {
+		t.mu.Lock()
+		sent := t.sent + len(t.queued)
+		t.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "ICE Candidate gathering completed (%d candidates trickled)\n", sent)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ICE Candidate: %s\n", candidate.String())
+	payload, err := json.Marshal(candidate.ToJSON())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to encode ICE candidate: %v\n", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.ready {
+		t.queued = append(t.queued, string(payload))
+		return
+	}
+	t.sendLocked(string(payload))
+}
+
+// sendLocked 发送一个候选，调用方需持有 t.mu
+func (t *TrickleICE) sendLocked(payload string) {
+	if t.closed {
+		return
+	}
+	if err := websocket.JSON.Send(t.conn.conn, signalMessage{Kind: t.localKind, Payload: payload}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to send ICE candidate to %s: %v\n", signalURLFor(t.baseURL, "ws"), err)
+		return
+	}
+	t.sent++
+}
+
+// SignalSent 在本端 SDP 发出后调用：发出排队的候选，之后收集到的候选直接发送
+func (t *TrickleICE) SignalSent() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ready = true
+	for _, payload := range t.queued {
+		t.sendLocked(payload)
+	}
+	t.queued = nil
+}
+
+// Start 在设置远端描述后调用：添加等待 SDP 期间到达的对端候选，并在后台继续接收
+func (t *TrickleICE) Start() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.started {
+		t.mu.Unlock()
+		return
+	}
+	t.started = true
+	backlog := t.conn.backlog
+	t.conn.backlog = nil
+	t.mu.Unlock()
+
+	for _, msg := range backlog {
+		t.addRemote(msg)
+	}
+	go t.receiveLoop()
+}
+
+// receiveLoop 接收对端的候选，直到连接关闭
+func (t *TrickleICE) receiveLoop() {
+	for {
+		var msg signalMessage
+		if err := websocket.JSON.Receive(t.conn.conn, &msg); err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if !closed {
+				fmt.Fprintf(os.Stderr, "Trickle ICE: stopped receiving candidates from %s: %v\n", signalURLFor(t.baseURL, "ws"), err)
+			}
+			return
+		}
+		t.addRemote(msg)
+	}
+}
+
+// addRemote 把一条对端候选消息交给 PeerConnection，其它消息忽略
+func (t *TrickleICE) addRemote(msg signalMessage) {
+	if msg.Kind != t.remoteKind {
+		return
+	}
+	var candidate webrtc.ICECandidateInit
+	if err := json.Unmarshal([]byte(msg.Payload), &candidate); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: invalid ICE candidate from %s: %v\n", signalURLFor(t.baseURL, "ws"), err)
+		return
+	}
+	if err := t.pc.AddICECandidate(candidate); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to add remote ICE candidate %s: %v\n", candidate.Candidate, err)
+		return
+	}
+	t.mu.Lock()
+	t.added++
+	t.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "Remote ICE Candidate: %s\n", candidate.Candidate)
+}
+
+// Close 关闭 WebSocket 并打印收发的候选数，可以重复调用
+func (t *TrickleICE) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	sent, added := t.sent, t.added
+	t.mu.Unlock()
+	releaseWebSocketSignal(t.baseURL, t.conn)
+	fmt.Fprintf(os.Stderr, "Trickle ICE: %d local candidates sent, %d remote candidates added\n", sent, added)
+}
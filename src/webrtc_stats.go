@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// webrtc_stats.go - 定期记录 PeerConnection.GetStats() 的快照（client 的 -webrtc-stats）
+//
+// 说明：
+//   - 每 200ms 调用一次 GetStats()，把 inbound-rtp 与 candidate-pair 两类统计写入 <session-dir>/webrtc_stats.csv，
+//     作为 pion 自己统计的网络指标，与按帧计算的码率 / 延迟（metrics.go）和 -rtcp-bwe 的估计交叉对照
+//   - inbound-rtp 来自默认 interceptor 中的 stats interceptor（newWebRTCAPI 总会注册）；jitter 是 RFC 3550 的到达间隔抖动，单位换算为毫秒
+//   - candidate-pair 只记录检查成功（succeeded）的候选对；current_rtt_ms 来自 ICE 连通性检查 / consent 请求的往返时间，
+//     没有测量时为 0
+//   - 两类统计的行放在同一个 CSV 中，以 type 列区分，不适用的列留空；所有计数都是累计值
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// webrtcStatsInterval 是 GetStats() 的轮询间隔
+const webrtcStatsInterval = 200 * time.Millisecond
+
+// WebRTCStatsLogger 在后台定期记录 GetStats() 的快照，方法对 nil 安全（nil 表示未开启）
+type WebRTCStatsLogger struct {
+	pc     *webrtc.PeerConnection
+	writer *csv.Writer
+	file   *os.File
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// 汇总统计（最近一次快照中的视频流与 RTT）
+	mu              sync.Mutex
+	snapshots       int
+	videoReceived   uint32
+	videoLost       int32
+	lastRTT         float64
+	lastRTTMeasured bool
+}
+
+// NewWebRTCStatsLogger 创建 CSV 并启动轮询协程
+func NewWebRTCStatsLogger(csvPath string, pc *webrtc.PeerConnection) (*WebRTCStatsLogger, error) {
+	if err := os.MkdirAll(filepath.Dir(csvPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create webrtc stats directory: %w", err)
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webrtc stats csv: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	header := []string{
+		"unix_ms",
+		"type", // inbound-rtp / candidate-pair
+		"id",
+		"kind",      // inbound-rtp：audio / video
+		"ssrc",      // inbound-rtp
+		"nominated", // candidate-pair：1 表示正在使用的候选对
+		"packets_received",
+		"packets_lost",
+		"jitter_ms",
+		"bytes_received",
+		"current_rtt_ms",
+	}
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write webrtc stats header: %w", err)
+	}
+	w.Flush()
+
+	l := &WebRTCStatsLogger{
+		pc:     pc,
+		writer: w,
+		file:   f,
+		done:   make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.run()
+	return l, nil
+}
+
+// run 每个轮询间隔记录一次快照
+func (l *WebRTCStatsLogger) run() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(webrtcStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		l.record(time.Now(), l.pc.GetStats())
+	}
+}
+
+// record 写入一次快照中的 inbound-rtp 与 candidate-pair 行（按 id 排序）
+func (l *WebRTCStatsLogger) record(now time.Time, report webrtc.StatsReport) {
+	ids := make([]string, 0, len(report))
+	for id := range report {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	unixMs := strconv.FormatInt(now.UnixMilli(), 10)
+	var rows [][]string
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.snapshots++
+	for _, id := range ids {
+		switch s := report[id].(type) {
+		case webrtc.InboundRTPStreamStats:
+			rows = append(rows, []string{
+				unixMs, string(s.Type), s.ID, s.Kind,
+				strconv.FormatUint(uint64(s.SSRC), 10), "",
+				strconv.FormatUint(uint64(s.PacketsReceived), 10),
+				strconv.FormatInt(int64(s.PacketsLost), 10),
+				fmt.Sprintf("%.3f", s.Jitter*1000),
+				strconv.FormatUint(s.BytesReceived, 10),
+				"",
+			})
+			if s.Kind == "video" {
+				l.videoReceived, l.videoLost = s.PacketsReceived, s.PacketsLost
+			}
+		case webrtc.ICECandidatePairStats:
+			if s.State != webrtc.StatsICECandidatePairStateSucceeded {
+				continue
+			}
+			nominated := "0"
+			if s.Nominated {
+				nominated = "1"
+			}
+			rows = append(rows, []string{
+				unixMs, string(s.Type), s.ID, "", "", nominated,
+				strconv.FormatUint(uint64(s.PacketsReceived), 10),
+				"", "",
+				strconv.FormatUint(s.BytesReceived, 10),
+				fmt.Sprintf("%.3f", s.CurrentRoundTripTime*1000),
+			})
+			if s.CurrentRoundTripTime > 0 && (s.Nominated || !l.lastRTTMeasured) {
+				l.lastRTT, l.lastRTTMeasured = s.CurrentRoundTripTime, true
+			}
+		}
+	}
+
+	if l.writer == nil || len(rows) == 0 {
+		return
+	}
+	if err := l.writer.WriteAll(rows); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing webrtc stats CSV: %v\n", err)
+	}
+}
+
+// Close 停止轮询、关闭 CSV，并在 stderr 输出最近一次快照的摘要
+func (l *WebRTCStatsLogger) Close() {
+	if l == nil {
+		return
+	}
+	select {
+	case <-l.done:
+		return
+	default:
+		close(l.done)
+	}
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.writer != nil {
+		l.writer.Flush()
+		l.writer = nil
+	}
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing webrtc stats CSV file: %v\n", err)
+		}
+		l.file = nil
+	}
+	if l.snapshots == 0 {
+		return
+	}
+	rtt := "n/a"
+	if l.lastRTTMeasured {
+		rtt = fmt.Sprintf("%.1f ms", l.lastRTT*1000)
+	}
+	fmt.Fprintf(os.Stderr, "WebRTC stats: %d snapshots, video packets received %d, lost %d, last candidate-pair RTT %s\n",
+		l.snapshots, l.videoReceived, l.videoLost, rtt)
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2026 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+// ws_signal.go - 通过 sdp-bridge 的 WebSocket 交换 offer / answer（-signal-url ws://...）
+//
+// 说明：
+//   - -signal-url 以 ws:// 或 wss:// 开头时，postSignal / pollSignal 改为连接 bridge 房间的 <url>/ws，
+//     对端的 SDP 一到达就由 bridge 推送过来，不再每秒轮询；http(s):// 仍走 http_signal.go 的短请求
+//   - 一次会话中 server 先发 offer 再等 answer（client 反之），两步使用同一条连接，收发都完成后关闭
+//   - 消息是 JSON：{"kind": "offer" | "answer", "payload": <encode 得到的 base64 字符串>}，与 -offer-file / -answer-file 的内容相同；
+//     bridge 把 WebSocket 与 HTTP 两种方式放在同一个房间里，两端可以各用一种
+//   - -trickle 时连接由 TrickleICE 持有（见 trickle_ice.go），SDP 交换完成后继续用来收发 ICE 候选，直到 TrickleICE.Close
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// signalMessage 是 WebSocket 上的一条 SDP 消息（sdp_bridge.go 中的 bridgeMessage 与之相同）
+type signalMessage struct {
+	Kind    string `json:"kind"`
+	Payload string `json:"payload"`
+}
+
+// -trickle 时逐个发送的 ICE 候选的消息类型：offer 一端的候选与 answer 一端的候选
+const (
+	signalKindOfferCandidate  = "offer-candidate"
+	signalKindAnswerCandidate = "answer-candidate"
+)
+
+// isCandidateSignalKind 判断消息是否是 ICE 候选
+func isCandidateSignalKind(kind string) bool {
+	return kind == signalKindOfferCandidate || kind == signalKindAnswerCandidate
+}
+
+// wsSignalConn 是一个房间的 WebSocket 连接，sent / received 都为 true 时关闭（held 时除外）
+type wsSignalConn struct {
+	conn     *websocket.Conn
+	sent     bool
+	received bool
+
+	// held 表示连接由 TrickleICE 持有，交换完 SDP 后不关闭
+	held bool
+	// backlog 是 held 时等待 SDP 期间先到达的 ICE 候选消息，由 TrickleICE 在开始接收候选时取走
+	backlog []signalMessage
+}
+
+// wsSignalConns 按房间地址缓存连接，postSignal 与 pollSignal 共用
+var (
+	wsSignalMu    sync.Mutex
+	wsSignalConns = make(map[string]*wsSignalConn)
+)
+
+// isWebSocketSignalURL 判断 -signal-url 是否使用 WebSocket
+func isWebSocketSignalURL(baseURL string) bool {
+	lower := strings.ToLower(baseURL)
+	return strings.HasPrefix(lower, "ws://") || strings.HasPrefix(lower, "wss://")
+}
+
+// dialWebSocketSignal 连接房间的 <baseURL>/ws；Origin 取同一主机的 http(s) 地址
+func dialWebSocketSignal(baseURL string) (*websocket.Conn, error) {
+	wsURL := signalURLFor(baseURL, "ws")
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signal url %s: %w", baseURL, err)
+	}
+	origin := &url.URL{Scheme: "http", Host: u.Host}
+	if strings.EqualFold(u.Scheme, "wss") {
+		origin.Scheme = "https"
+	}
+	config, err := websocket.NewConfig(wsURL, origin.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signal url %s: %w", baseURL, err)
+	}
+	config.Dialer = &net.Dialer{Timeout: signalHTTPClient.Timeout}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", wsURL, err)
+	}
+	// 与 HTTP 方式相同的长度上限，另加 JSON 外壳
+	conn.MaxPayloadBytes = signalMaxBytes + 1024
+	fmt.Fprintf(os.Stderr, "Connected to signaling WebSocket %s\n", wsURL)
+	return conn, nil
+}
+
+// wsSignalFor 返回房间的连接，还没有时建立；deadline 非零时连接失败会重试到 deadline（bridge 可能稍后才启动）
+func wsSignalFor(baseURL string, deadline time.Time) (*wsSignalConn, error) {
+	wsSignalMu.Lock()
+	defer wsSignalMu.Unlock()
+	if c := wsSignalConns[baseURL]; c != nil {
+		return c, nil
+	}
+	for {
+		conn, err := dialWebSocketSignal(baseURL)
+		if err == nil {
+			c := &wsSignalConn{conn: conn}
+			wsSignalConns[baseURL] = c
+			return c, nil
+		}
+		if deadline.IsZero() || time.Now().Add(signalPollInterval).After(deadline) {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v (retrying)\n", err)
+		time.Sleep(signalPollInterval)
+	}
+}
+
+// finishWebSocketSignal 在一次收发后调用，offer 与 answer 都交换完时关闭连接
+func finishWebSocketSignal(baseURL string, c *wsSignalConn, failed bool) {
+	wsSignalMu.Lock()
+	defer wsSignalMu.Unlock()
+	if failed || (c.sent && c.received && !c.held) {
+		c.conn.Close()
+		delete(wsSignalConns, baseURL)
+	}
+}
+
+// postWebSocketSignal 通过房间的 WebSocket 发送 SDP
+func postWebSocketSignal(baseURL, kind, payload string) error {
+	c, err := wsSignalFor(baseURL, time.Time{})
+	if err != nil {
+		return err
+	}
+	if err = websocket.JSON.Send(c.conn, signalMessage{Kind: kind, Payload: payload}); err != nil {
+		finishWebSocketSignal(baseURL, c, true)
+		return fmt.Errorf("failed to send %s over %s: %w", kind, signalURLFor(baseURL, "ws"), err)
+	}
+	c.sent = true
+	finishWebSocketSignal(baseURL, c, false)
+	fmt.Fprintf(os.Stderr, "%s sent to %s (%d bytes)\n", capitalize(kind), signalURLFor(baseURL, "ws"), len(payload))
+	return nil
+}
+
+// pollWebSocketSignal 等待 bridge 推送 kind 的 SDP；超时或连接断开时返回空串（与 pollSignal 一致）
+func pollWebSocketSignal(baseURL, kind string) string {
+	deadline := time.Now().Add(signalPollTimeout)
+	c, err := wsSignalFor(baseURL, deadline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ""
+	}
+	wsURL := signalURLFor(baseURL, "ws")
+	fmt.Fprintf(os.Stderr, "Waiting for %s at %s... (timeout in %v)\n", kind, wsURL, time.Until(deadline).Round(time.Second))
+	c.conn.SetReadDeadline(deadline)
+	for {
+		var msg signalMessage
+		if err = websocket.JSON.Receive(c.conn, &msg); err != nil {
+			finishWebSocketSignal(baseURL, c, true)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				fmt.Fprintf(os.Stderr, "Error: Timeout waiting for %s at %s\n", kind, wsURL)
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: failed to receive %s from %s: %v\n", kind, wsURL, err)
+			}
+			return ""
+		}
+		if msg.Kind != kind {
+			// 例如重新加入房间时 bridge 推送的旧 offer；-trickle 时先到的候选留给 TrickleICE
+			if c.held && isCandidateSignalKind(msg.Kind) {
+				c.backlog = append(c.backlog, msg)
+			}
+			continue
+		}
+		c.conn.SetReadDeadline(time.Time{})
+		c.received = true
+		finishWebSocketSignal(baseURL, c, false)
+		payload := strings.TrimSpace(msg.Payload)
+		fmt.Fprintf(os.Stderr, "%s received from %s (%d bytes)\n", capitalize(kind), wsURL, len(payload))
+		return payload
+	}
+}
+
+// holdWebSocketSignal 建立（或取得）房间的连接并标记为由 TrickleICE 持有
+func holdWebSocketSignal(baseURL string) (*wsSignalConn, error) {
+	c, err := wsSignalFor(baseURL, time.Now().Add(signalPollTimeout))
+	if err != nil {
+		return nil, err
+	}
+	wsSignalMu.Lock()
+	c.held = true
+	wsSignalMu.Unlock()
+	return c, nil
+}
+
+// releaseWebSocketSignal 关闭 TrickleICE 持有的连接
+func releaseWebSocketSignal(baseURL string, c *wsSignalConn) {
+	wsSignalMu.Lock()
+	defer wsSignalMu.Unlock()
+	c.conn.Close()
+	if wsSignalConns[baseURL] == c {
+		delete(wsSignalConns, baseURL)
+	}
+}